@@ -0,0 +1,267 @@
+package accumulator
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/scholzj/go-kafka-protocol/compression"
+	"github.com/scholzj/go-kafka-protocol/producerstate"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// Defaults matching the Java producer configuration.
+const (
+	DefaultBatchSize       = 16384                // batch.size
+	DefaultLinger          = 5 * time.Millisecond // linger.ms
+	DefaultMaxRequestSize  = 1048576              // max.request.size
+	DefaultRequestTimeout  = 30 * time.Second     // request.timeout.ms
+	DefaultDeliveryTimeout = 2 * time.Minute      // delivery.timeout.ms
+	DefaultRetryBackoff    = 100 * time.Millisecond
+	DefaultMaxInFlight     = 5 // max.in.flight.requests.per.connection
+)
+
+var (
+	ErrRecordTooLarge  = errors.New("the record is larger than the maximum request size")
+	ErrDeliveryTimeout = errors.New("the batch was not delivered within the delivery timeout")
+)
+
+// TopicPartition identifies a partition by topic name.
+type TopicPartition struct {
+	Topic     string
+	Partition int32
+}
+
+func (tp TopicPartition) String() string {
+	return fmt.Sprintf("%s-%d", tp.Topic, tp.Partition)
+}
+
+// Record is a record to produce. The partition is chosen by the caller, for example with the
+// partitioner package.
+type Record struct {
+	TopicPartition
+	Timestamp int64 // Milliseconds since the epoch.
+	Key       []byte
+	Value     []byte
+	Headers   []records.Header
+}
+
+// Batch is a record batch of a partition together with its delivery state.
+type Batch struct {
+	TopicPartition
+	Batch    *records.RecordBatch
+	Created  time.Time
+	Attempts int // How often the batch was sent.
+
+	id      uint64 // Orders the batches of a partition by creation.
+	size    int    // The estimated uncompressed size of the batch.
+	retryAt time.Time
+	flush   bool
+	stamped bool
+}
+
+// Result is the outcome of a batch which was written or failed for good.
+type Result struct {
+	TopicPartition
+	Records         int
+	BaseOffset      int64 // The offset of the first record, or -1.
+	LogAppendTimeMs int64 // The broker time of the append for topics with LogAppendTime, or -1.
+	Err             error
+}
+
+// Accumulator collects records into batches per partition and drains the batches which are ready into
+// Produce requests per leader. When Producer is set, the batches are stamped with its producer id,
+// epoch and sequence numbers and the produce results are passed to it, which makes the produce
+// idempotent or transactional. It is safe for concurrent use.
+type Accumulator struct {
+	// Now is the clock used for linger, retry backoff and the delivery timeout. It defaults to time.Now
+	// and can be replaced in tests.
+	Now func() time.Time
+
+	BatchSize       int // The size in bytes after which a batch is closed.
+	Linger          time.Duration
+	MaxRequestSize  int // The maximum size in bytes of the record batches of one Produce request.
+	Compression     compression.Type
+	Acks            int16
+	RequestTimeout  time.Duration
+	DeliveryTimeout time.Duration
+	RetryBackoff    time.Duration
+	MaxInFlight     int // The maximum number of batches of one partition sent without a response.
+	Producer        *producerstate.Producer
+
+	mu         sync.Mutex
+	partitions map[TopicPartition][]*Batch
+	inflight   map[TopicPartition]int
+	nextId     uint64
+}
+
+func NewAccumulator() *Accumulator {
+	return &Accumulator{
+		Now:             time.Now,
+		BatchSize:       DefaultBatchSize,
+		Linger:          DefaultLinger,
+		MaxRequestSize:  DefaultMaxRequestSize,
+		Compression:     compression.None,
+		Acks:            -1,
+		RequestTimeout:  DefaultRequestTimeout,
+		DeliveryTimeout: DefaultDeliveryTimeout,
+		RetryBackoff:    DefaultRetryBackoff,
+		MaxInFlight:     DefaultMaxInFlight,
+		partitions:      make(map[TopicPartition][]*Batch),
+		inflight:        make(map[TopicPartition]int),
+	}
+}
+
+// Append adds the record to the open batch of its partition, or to a new batch when it does not fit
+// into the open one anymore. A record larger than the batch size gets a batch of its own.
+func (a *Accumulator) Append(r Record) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	queue := a.partitions[r.TopicPartition]
+	var open *Batch
+	if len(queue) > 0 {
+		last := queue[len(queue)-1]
+		if last.Attempts == 0 && !last.stamped && !last.flush {
+			open = last
+		}
+	}
+
+	if open != nil {
+		size := recordSize(int32(len(open.Batch.Records)), r.Timestamp-open.Batch.BaseTimestamp, r.Key, r.Value, r.Headers)
+		if open.size+size <= a.BatchSize {
+			open.Batch.AppendRecord(r.Timestamp, r.Key, r.Value, r.Headers)
+			open.size += size
+			return nil
+		}
+	}
+
+	size := records.BatchHeaderSize + recordSize(0, 0, r.Key, r.Value, r.Headers)
+	if size > a.MaxRequestSize {
+		return fmt.Errorf("%w: %d bytes", ErrRecordTooLarge, size)
+	}
+
+	batch := records.NewRecordBatch(0, a.Compression)
+	batch.AppendRecord(r.Timestamp, r.Key, r.Value, r.Headers)
+	a.nextId++
+	a.partitions[r.TopicPartition] = append(queue, &Batch{TopicPartition: r.TopicPartition, Batch: batch, Created: a.Now(), id: a.nextId, size: size})
+	return nil
+}
+
+// Flush makes all batches ready to be drained without waiting for the linger time.
+func (a *Accumulator) Flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, queue := range a.partitions {
+		for _, b := range queue {
+			b.flush = true
+		}
+	}
+}
+
+// Pending returns the number of batches waiting to be drained.
+func (a *Accumulator) Pending() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	pending := 0
+	for _, queue := range a.partitions {
+		pending += len(queue)
+	}
+	return pending
+}
+
+// NextReady returns when the next batch becomes ready because its linger time or retry backoff ends.
+// The boolean is false when there are no batches.
+func (a *Accumulator) NextReady() (time.Time, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var next time.Time
+	found := false
+	for _, queue := range a.partitions {
+		if len(queue) == 0 {
+			continue
+		}
+		at := a.readyAt(queue)
+		if !found || at.Before(next) {
+			next, found = at, true
+		}
+	}
+	return next, found
+}
+
+// readyAt returns when the first batch of the queue can be sent. A batch which is full, or followed by
+// another batch, does not wait for the linger time.
+func (a *Accumulator) readyAt(queue []*Batch) time.Time {
+	first := queue[0]
+	at := first.Created.Add(a.Linger)
+	if first.flush || first.Attempts > 0 || len(queue) > 1 || first.size >= a.BatchSize {
+		at = first.Created
+	}
+	if first.retryAt.After(at) {
+		at = first.retryAt
+	}
+	return at
+}
+
+// requeue puts the batches back into the queue of their partition in front of all batches which were
+// created after them, so that retried batches keep their order.
+func (a *Accumulator) requeue(tp TopicPartition, batches ...*Batch) {
+	queue := a.partitions[tp]
+	i := sort.Search(len(queue), func(i int) bool { return queue[i].id > batches[0].id })
+	requeued := make([]*Batch, 0, len(queue)+len(batches))
+	requeued = append(requeued, queue[:i]...)
+	requeued = append(requeued, batches...)
+	a.partitions[tp] = append(requeued, queue[i:]...)
+}
+
+// sortedPartitions returns the partitions with batches in a stable order.
+func (a *Accumulator) sortedPartitions() []TopicPartition {
+	tps := make([]TopicPartition, 0, len(a.partitions))
+	for tp, queue := range a.partitions {
+		if len(queue) > 0 {
+			tps = append(tps, tp)
+		}
+	}
+	sort.Slice(tps, func(i, j int) bool {
+		if tps[i].Topic != tps[j].Topic {
+			return tps[i].Topic < tps[j].Topic
+		}
+		return tps[i].Partition < tps[j].Partition
+	})
+	return tps
+}
+
+// recordSize returns the size of the encoded record, as DefaultRecord.sizeInBytes in the Java client.
+func recordSize(offsetDelta int32, timestampDelta int64, key []byte, value []byte, headers []records.Header) int {
+	size := 1 + varlongSize(timestampDelta) + varlongSize(int64(offsetDelta))
+	size += bytesSize(key) + bytesSize(value)
+	size += varlongSize(int64(len(headers)))
+	for _, h := range headers {
+		size += varlongSize(int64(len(h.Key))) + len(h.Key) + bytesSize(h.Value)
+	}
+	return varlongSize(int64(size)) + size
+}
+
+// bytesSize returns the size of a nullable byte array with its varint length.
+func bytesSize(b []byte) int {
+	if b == nil {
+		return varlongSize(-1)
+	}
+	return varlongSize(int64(len(b))) + len(b)
+}
+
+// varlongSize returns the size of the zig-zag encoded varint.
+func varlongSize(v int64) int {
+	u := uint64((v << 1) ^ (v >> 63))
+	size := 1
+	for u >= 0x80 {
+		u >>= 7
+		size++
+	}
+	return size
+}
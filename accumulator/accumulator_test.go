@@ -0,0 +1,342 @@
+package accumulator
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/initproducerid"
+	"github.com/scholzj/go-kafka-protocol/api/metadata"
+	"github.com/scholzj/go-kafka-protocol/api/produce"
+	"github.com/scholzj/go-kafka-protocol/compression"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/metadatacache"
+	"github.com/scholzj/go-kafka-protocol/producerstate"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+var ordersId = uuid.MustParse("5b4a5a34-8e1a-4d0e-9d3f-0a4b6c7d8e9f")
+
+func str(s string) *string {
+	return &s
+}
+
+// testCache returns a cache with the partitions 0 and 1 of orders led by broker 1 and partition 2 led
+// by broker 2.
+func testCache() *metadatacache.Cache {
+	partition := func(index int32, leader int32) metadata.MetadataResponseTopicPartition {
+		return metadata.MetadataResponseTopicPartition{PartitionIndex: index, LeaderId: leader, LeaderEpoch: 1, ReplicaNodes: &[]int32{leader}, IsrNodes: &[]int32{leader}, OfflineReplicas: &[]int32{}}
+	}
+
+	c := metadatacache.NewCache()
+	c.UpdateMetadata(&metadata.MetadataResponse{
+		ApiVersion: 12,
+		Brokers: &[]metadata.MetadataResponseBroker{
+			{NodeId: 1, Host: str("broker-1"), Port: 9092},
+			{NodeId: 2, Host: str("broker-2"), Port: 9092},
+		},
+		Topics: &[]metadata.MetadataResponseTopic{
+			{Name: str("orders"), TopicId: ordersId, Partitions: &[]metadata.MetadataResponseTopicPartition{partition(0, 1), partition(1, 1), partition(2, 2)}},
+		},
+	})
+	return c
+}
+
+func testAccumulator(now *time.Time) *Accumulator {
+	a := NewAccumulator()
+	a.Now = func() time.Time { return *now }
+	return a
+}
+
+func record(partition int32, value string) Record {
+	return Record{TopicPartition: TopicPartition{"orders", partition}, Timestamp: 1700000000000, Value: []byte(value)}
+}
+
+// response returns a Produce response for the request with the error code for every partition.
+func response(req *Request, errorCode int16) *produce.ProduceResponse {
+	res := &produce.ProduceResponse{ApiVersion: req.Request.ApiVersion, Responses: &[]produce.ProduceResponseResponse{}}
+	for _, t := range *req.Request.TopicData {
+		var partitions []produce.ProduceResponseResponsePartitionResponse
+		for _, p := range *t.PartitionData {
+			partitions = append(partitions, produce.ProduceResponseResponsePartitionResponse{Index: p.Index, ErrorCode: errorCode, BaseOffset: 100, LogAppendTimeMs: -1})
+		}
+		*res.Responses = append(*res.Responses, produce.ProduceResponseResponse{Name: t.Name, TopicId: t.TopicId, PartitionResponses: &partitions})
+	}
+	return res
+}
+
+func TestRecordSize(t *testing.T) {
+	batch := records.NewRecordBatch(0, compression.None)
+	size := records.BatchHeaderSize
+	inputs := []Record{
+		{Timestamp: 1700000000000, Value: []byte("value")},
+		{Timestamp: 1700000000100, Key: []byte("key"), Value: make([]byte, 300)},
+		{Timestamp: 1700000000050, Headers: []records.Header{{Key: "h", Value: []byte("v")}, {Key: "null"}}},
+	}
+	for _, r := range inputs {
+		var delta int64
+		if len(batch.Records) > 0 {
+			delta = r.Timestamp - batch.BaseTimestamp
+		}
+		size += recordSize(int32(len(batch.Records)), delta, r.Key, r.Value, r.Headers)
+		batch.AppendRecord(r.Timestamp, r.Key, r.Value, r.Headers)
+	}
+
+	data, err := records.WriteRecordBatches([]*records.RecordBatch{batch})
+	if err != nil {
+		t.Fatalf("WriteRecordBatches: %v", err)
+	}
+	if size != len(data) {
+		t.Errorf("estimated %d bytes, encoded %d", size, len(data))
+	}
+}
+
+func TestAppend(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	a := testAccumulator(&now)
+	a.BatchSize = 200
+	a.MaxRequestSize = 500
+
+	for i := 0; i < 3; i++ {
+		if err := a.Append(record(0, string(make([]byte, 50)))); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if err := a.Append(record(0, string(make([]byte, 300)))); err != nil {
+		t.Fatalf("a record larger than the batch size was rejected: %v", err)
+	}
+	if err := a.Append(record(0, string(make([]byte, 500)))); !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	queue := a.partitions[TopicPartition{"orders", 0}]
+	if len(queue) != 3 || len(queue[0].Batch.Records) != 2 || len(queue[1].Batch.Records) != 1 || len(queue[2].Batch.Records) != 1 {
+		t.Fatalf("unexpected batches %+v", queue)
+	}
+	if a.Pending() != 3 {
+		t.Errorf("unexpected pending batches %d", a.Pending())
+	}
+
+	// The full batches are ready, only the last one lingers.
+	if next, ok := a.NextReady(); !ok || !next.Equal(now) {
+		t.Errorf("unexpected next ready time %v", next)
+	}
+	requests, _, err := a.Drain(12, testCache())
+	if err != nil || len(requests) != 1 || len(requests[0].Batches) != 1 {
+		t.Fatalf("unexpected requests %+v (%v)", requests, err)
+	}
+}
+
+func TestLinger(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	a := testAccumulator(&now)
+	cache := testCache()
+	_ = a.Append(record(0, "a"))
+
+	if requests, _, _ := a.Drain(12, cache); len(requests) != 0 {
+		t.Fatalf("a lingering batch was drained")
+	}
+	if next, ok := a.NextReady(); !ok || !next.Equal(now.Add(DefaultLinger)) {
+		t.Errorf("unexpected next ready time %v", next)
+	}
+
+	a.Flush()
+	if requests, _, _ := a.Drain(12, cache); len(requests) != 1 {
+		t.Fatalf("a flushed batch was not drained")
+	}
+	if _, ok := a.NextReady(); ok {
+		t.Errorf("a drained batch is still pending")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cache := testCache()
+
+	for _, version := range []int16{3, 12, 13} {
+		a := testAccumulator(&now)
+		for _, partition := range []int32{0, 1, 2, 3} {
+			_ = a.Append(record(partition, "value"))
+		}
+		_ = a.Append(Record{TopicPartition: TopicPartition{"payments", 0}, Value: []byte("value")})
+		a.Flush()
+
+		requests, noLeader, err := a.Drain(version, cache)
+		if err != nil {
+			t.Fatalf("Drain: %v", err)
+		}
+		if !reflect.DeepEqual(noLeader, []TopicPartition{{"orders", 3}, {"payments", 0}}) {
+			t.Errorf("unexpected partitions without leader %v", noLeader)
+		}
+		if len(requests) != 2 || requests[0].NodeId != 1 || requests[1].NodeId != 2 {
+			t.Fatalf("unexpected requests %+v", requests)
+		}
+
+		req := requests[0].Request
+		if req.ApiVersion != version || req.Acks != -1 || req.TimeoutMs != 30000 || req.TransactionalId != nil || len(*req.TopicData) != 1 {
+			t.Fatalf("unexpected request %+v", req)
+		}
+		topic := (*req.TopicData)[0]
+		if version >= 13 && (topic.Name != nil || topic.TopicId != ordersId) {
+			t.Errorf("version %d: unexpected topic %+v", version, topic)
+		}
+		if version < 13 && (topic.Name == nil || *topic.Name != "orders" || topic.TopicId != uuid.Nil) {
+			t.Errorf("version %d: unexpected topic %+v", version, topic)
+		}
+		if len(*topic.PartitionData) != 2 || (*topic.PartitionData)[1].Index != 1 {
+			t.Fatalf("unexpected partitions %+v", *topic.PartitionData)
+		}
+		batches, err := records.ReadRecordBatches(*(*topic.PartitionData)[0].Records)
+		if err != nil || len(batches) != 1 || string(batches[0].Records[0].Value) != "value" {
+			t.Errorf("unexpected batches %+v (%v)", batches, err)
+		}
+
+		results := a.HandleResponse(requests[0], response(requests[0], errorcodes.None))
+		if len(results) != 2 || results[0].Err != nil || results[0].BaseOffset != 100 || results[0].Records != 1 {
+			t.Errorf("unexpected results %+v", results)
+		}
+	}
+}
+
+func TestDrainMaxRequestSize(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	a := testAccumulator(&now)
+	a.BatchSize = 150
+	a.MaxRequestSize = 200
+	for _, partition := range []int32{0, 1} {
+		_ = a.Append(record(partition, string(make([]byte, 100))))
+		_ = a.Append(record(partition, string(make([]byte, 100))))
+	}
+
+	// One batch per partition is drained, and the two batches do not fit into one request.
+	requests, _, _ := a.Drain(12, testCache())
+	if len(requests) != 2 || requests[0].NodeId != 1 || requests[1].NodeId != 1 || len(requests[0].Batches) != 1 || requests[1].Batches[0].Partition != 1 {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
+	if a.Pending() != 2 {
+		t.Errorf("unexpected pending batches %d", a.Pending())
+	}
+}
+
+func TestHandleResponse(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	a := testAccumulator(&now)
+	cache := testCache()
+	for _, value := range []string{"a", "b", "c"} {
+		_ = a.Append(record(0, value))
+	}
+	_ = a.Append(record(1, "d"))
+	a.Flush()
+
+	// A retriable error queues the batch again after the backoff.
+	requests, _, _ := a.Drain(12, cache)
+	res := response(requests[0], errorcodes.NotLeaderOrFollower)
+	(*(*res.Responses)[0].PartitionResponses)[1].ErrorCode = errorcodes.InvalidRecord
+	results := a.HandleResponse(requests[0], res)
+	if len(results) != 1 || results[0].Partition != 1 || results[0].BaseOffset != -1 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	var kafkaErr *errorcodes.Error
+	if !errors.As(results[0].Err, &kafkaErr) || kafkaErr.Code != errorcodes.InvalidRecord {
+		t.Errorf("unexpected error %v", results[0].Err)
+	}
+	if requests, _, _ := a.Drain(12, cache); len(requests) != 0 {
+		t.Fatalf("the batch was retried before the backoff")
+	}
+
+	// A batch which is too large is split.
+	now = now.Add(DefaultRetryBackoff)
+	requests, _, _ = a.Drain(12, cache)
+	if len(requests) != 1 || requests[0].Batches[0].Attempts != 2 {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
+	if results := a.HandleResponse(requests[0], response(requests[0], errorcodes.MessageTooLarge)); len(results) != 0 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	queue := a.partitions[TopicPartition{"orders", 0}]
+	if len(queue) != 2 || len(queue[0].Batch.Records) != 1 || string(queue[1].Batch.Records[1].Value) != "c" {
+		t.Fatalf("unexpected batches %+v", queue)
+	}
+
+	// A request without a response sends the batches again.
+	now = now.Add(DefaultRetryBackoff)
+	requests, _, _ = a.Drain(12, cache)
+	if results := a.HandleRequestError(requests[0], errors.New("connection reset")); len(results) != 0 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+
+	// The delivery timeout fails the queued batches.
+	now = now.Add(DefaultDeliveryTimeout)
+	if results := a.Expire(); len(results) != 2 || !errors.Is(results[0].Err, ErrDeliveryTimeout) || a.Pending() != 0 {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
+
+func TestAcksZero(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	a := testAccumulator(&now)
+	a.Acks = 0
+	_ = a.Append(record(2, "a"))
+	a.Flush()
+
+	requests, _, _ := a.Drain(13, testCache())
+	if results := a.HandleResponse(requests[0], nil); len(results) != 1 || results[0].Err != nil || results[0].BaseOffset != -1 {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
+
+func TestIdempotentProducer(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	a := testAccumulator(&now)
+	a.Producer = producerstate.NewProducer("")
+	cache := testCache()
+	_ = a.Append(record(0, "a"))
+	a.Flush()
+
+	if requests, _, _ := a.Drain(12, cache); len(requests) != 0 {
+		t.Fatalf("a batch was drained without a producer id")
+	}
+	if _, err := a.Producer.InitProducerIdRequest(4); err != nil {
+		t.Fatalf("InitProducerIdRequest: %v", err)
+	}
+	if err := a.Producer.HandleInitProducerIdResponse(&initproducerid.InitProducerIdResponse{ApiVersion: 4, ProducerId: 1000}); err != nil {
+		t.Fatalf("HandleInitProducerIdResponse: %v", err)
+	}
+
+	requests, _, _ := a.Drain(12, cache)
+	if len(requests) != 1 {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
+	if b := requests[0].Batches[0].Batch; b.ProducerId != 1000 || b.ProducerEpoch != 0 || b.BaseSequence != 0 {
+		t.Errorf("the batch was not stamped: %+v", b)
+	}
+
+	// Following batches of the partition can be in flight together.
+	_ = a.Append(record(0, "b"))
+	a.Flush()
+	second, _, _ := a.Drain(12, cache)
+	if len(second) != 1 || second[0].Batches[0].Batch.BaseSequence != 1 {
+		t.Fatalf("unexpected requests %+v", second)
+	}
+
+	// The second batch is written after a retry of the first one.
+	if results := a.HandleResponse(requests[0], response(requests[0], errorcodes.RequestTimedOut)); len(results) != 0 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if results := a.HandleResponse(second[0], response(second[0], errorcodes.OutOfOrderSequenceNumber)); len(results) != 0 {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	now = now.Add(DefaultRetryBackoff)
+	retried, _, _ := a.Drain(12, cache)
+	if len(retried) != 1 || retried[0].Batches[0].Batch.BaseSequence != 0 {
+		t.Fatalf("unexpected requests %+v", retried)
+	}
+	if results := a.HandleResponse(retried[0], response(retried[0], errorcodes.None)); len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if a.Producer.LastAckedSequence(producerstate.TopicPartition{Topic: "orders", Partition: 0}) != 0 {
+		t.Errorf("the sequence was not acknowledged")
+	}
+}
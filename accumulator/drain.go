@@ -0,0 +1,366 @@
+package accumulator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/produce"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/metadatacache"
+	"github.com/scholzj/go-kafka-protocol/producerstate"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+var ErrMissingPartitionResponse = errors.New("the Produce response has no result for the partition")
+
+// Request is a Produce request for the leader of its partitions together with the batches it carries.
+// Every batch must be passed back with HandleResponse or HandleRequestError.
+type Request struct {
+	NodeId  int32
+	Request *produce.ProduceRequest
+	Batches []*Batch
+
+	size     int
+	topicIds map[string]uuid.UUID // The topic ids used from version 13.
+}
+
+// Drain takes the first batch of every partition which is ready and groups the batches by the leader
+// of their partitions into Produce requests of the given version. Topics are identified by name up to
+// version 12 and by topic id from version 13. The batches of one leader are split into several
+// requests when they do not fit into MaxRequestSize together.
+//
+// Partitions without a known leader (or topic id) are returned so that the caller can refresh the
+// metadata. When Producer is set, batches are only drained while it has a producer id and only to
+// partitions which were added to the transaction.
+func (a *Accumulator) Drain(version int16, cache *metadatacache.Cache) ([]*Request, []TopicPartition, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.Now()
+	var requests []*Request
+	var noLeader []TopicPartition
+	current := make(map[int32]*Request)
+
+	for _, tp := range a.sortedPartitions() {
+		queue := a.partitions[tp]
+		if a.readyAt(queue).After(now) || a.inflight[tp] >= a.MaxInFlight {
+			continue
+		}
+
+		leader, _, err := cache.Leader(metadatacache.TopicPartition{Topic: tp.Topic, Partition: tp.Partition})
+		if err != nil {
+			noLeader = append(noLeader, tp)
+			continue
+		}
+		if version >= 13 {
+			if _, ok := cache.TopicId(tp.Topic); !ok {
+				noLeader = append(noLeader, tp)
+				continue
+			}
+		}
+
+		batch := queue[0]
+		if a.Producer != nil {
+			// Batches stamped before an epoch bump cannot be written anymore.
+			id, epoch := a.Producer.ProducerIdAndEpoch()
+			if batch.stamped && (batch.Batch.ProducerId != id || batch.Batch.ProducerEpoch != epoch) {
+				restamp(batch)
+			}
+			ptp := producerstate.TopicPartition{Topic: tp.Topic, Partition: tp.Partition}
+			if !batch.stamped {
+				// The batch waits while the producer is initialized or outside of a transaction.
+				if a.Producer.NeedsInitProducerId() || a.Producer.Stamp(ptp, batch.Batch) != nil {
+					continue
+				}
+				batch.stamped = true
+			}
+			if !a.Producer.CanSend(ptp) {
+				continue
+			}
+		}
+
+		size := batch.size
+		req := current[leader.NodeId]
+		if req == nil || (len(req.Batches) > 0 && req.size+size > a.MaxRequestSize) {
+			req = &Request{NodeId: leader.NodeId}
+			current[leader.NodeId] = req
+			requests = append(requests, req)
+		}
+		req.Batches = append(req.Batches, batch)
+		req.size += size
+
+		batch.Attempts++
+		a.inflight[tp]++
+		a.partitions[tp] = queue[1:]
+		if len(a.partitions[tp]) == 0 {
+			delete(a.partitions, tp)
+		}
+	}
+
+	for _, req := range requests {
+		if err := a.buildRequest(version, cache, req); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return requests, noLeader, nil
+}
+
+// buildRequest builds the Produce request for the batches of the request, grouped by topic in their
+// order.
+func (a *Accumulator) buildRequest(version int16, cache *metadatacache.Cache, req *Request) error {
+	var topicData []produce.ProduceRequestTopicData
+	topics := make(map[string]int)
+	req.topicIds = make(map[string]uuid.UUID)
+	for _, b := range req.Batches {
+		var buf bytes.Buffer
+		if err := b.Batch.Write(&buf); err != nil {
+			return fmt.Errorf("failed to write the batch of %s: %w", b.TopicPartition, err)
+		}
+		data := buf.Bytes()
+
+		i, ok := topics[b.Topic]
+		if !ok {
+			i = len(topicData)
+			topics[b.Topic] = i
+			t := produce.ProduceRequestTopicData{PartitionData: &[]produce.ProduceRequestTopicDataPartitionData{}}
+			if version >= 13 {
+				t.TopicId, _ = cache.TopicId(b.Topic)
+				req.topicIds[b.Topic] = t.TopicId
+			} else {
+				t.Name = &b.Topic
+			}
+			topicData = append(topicData, t)
+		}
+		*topicData[i].PartitionData = append(*topicData[i].PartitionData, produce.ProduceRequestTopicDataPartitionData{Index: b.Partition, Records: &data})
+	}
+
+	req.Request = &produce.ProduceRequest{
+		ApiVersion: version,
+		Acks:       a.Acks,
+		TimeoutMs:  int32(a.RequestTimeout.Milliseconds()),
+		TopicData:  &topicData,
+	}
+	if a.Producer != nil && a.Producer.TransactionalId != "" {
+		req.Request.TransactionalId = &a.Producer.TransactionalId
+	}
+	return nil
+}
+
+// HandleResponse processes the Produce response of the request and returns the results of the batches
+// which were written or failed for good. Batches which failed with a retriable error are queued again
+// in their original order and sent again after RetryBackoff. A batch rejected as too large is
+// split in two, unless it has only one record. When Producer is set, the results are passed to it and
+// batches which must be stamped again wait until it has a new producer epoch.
+//
+// The broker does not respond to requests with Acks 0; res is nil for them and every batch counts as
+// written without an offset.
+func (a *Accumulator) HandleResponse(req *Request, res *produce.ProduceResponse) []Result {
+	if res == nil && req.Request.Acks == 0 {
+		var results []Result
+		for _, b := range req.Batches {
+			a.done(b)
+			results = append(results, Result{TopicPartition: b.TopicPartition, Records: len(b.Batch.Records), BaseOffset: -1, LogAppendTimeMs: -1})
+		}
+		return results
+	}
+
+	type partitionKey struct {
+		topic     string
+		topicId   uuid.UUID
+		partition int32
+	}
+	partitions := make(map[partitionKey]*produce.ProduceResponseResponsePartitionResponse)
+	if res != nil && res.Responses != nil {
+		for _, t := range *res.Responses {
+			if t.PartitionResponses == nil {
+				continue
+			}
+			for i := range *t.PartitionResponses {
+				p := &(*t.PartitionResponses)[i]
+				partitions[partitionKey{deref(t.Name), t.TopicId, p.Index}] = p
+			}
+		}
+	}
+
+	var results []Result
+	for _, b := range req.Batches {
+		key := partitionKey{partition: b.Partition}
+		if req.Request.ApiVersion >= 13 {
+			key.topicId = req.topicIds[b.Topic]
+		} else {
+			key.topic = b.Topic
+		}
+
+		p, ok := partitions[key]
+		if !ok {
+			results = append(results, a.failed(b, fmt.Errorf("%w: %s", ErrMissingPartitionResponse, b.TopicPartition))...)
+			continue
+		}
+
+		var err error
+		if a.Producer != nil {
+			err = a.Producer.HandleProduceResponse(producerstate.TopicPartition{Topic: b.Topic, Partition: b.Partition}, b.Batch, p)
+		} else if p.ErrorCode != errorcodes.None {
+			err = errorcodes.ToError(p.ErrorCode, p.ErrorMessage)
+		}
+
+		if err == nil {
+			a.done(b)
+			results = append(results, Result{TopicPartition: b.TopicPartition, Records: len(b.Batch.Records), BaseOffset: p.BaseOffset, LogAppendTimeMs: p.LogAppendTimeMs})
+			continue
+		}
+		results = append(results, a.failed(b, err)...)
+	}
+	return results
+}
+
+// HandleRequestError handles a request which got no response, for example because the connection
+// failed. The batches are sent again.
+func (a *Accumulator) HandleRequestError(req *Request, err error) []Result {
+	var results []Result
+	for _, b := range req.Batches {
+		results = append(results, a.retry(b, err)...)
+	}
+	return results
+}
+
+// Expire fails the queued batches which were not delivered within DeliveryTimeout.
+func (a *Accumulator) Expire() []Result {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.Now()
+	var results []Result
+	for _, tp := range a.sortedPartitions() {
+		queue := a.partitions[tp]
+		var kept []*Batch
+		for _, b := range queue {
+			if now.Sub(b.Created) >= a.DeliveryTimeout {
+				results = append(results, Result{TopicPartition: tp, Records: len(b.Batch.Records), BaseOffset: -1, LogAppendTimeMs: -1, Err: ErrDeliveryTimeout})
+				continue
+			}
+			kept = append(kept, b)
+		}
+		if len(kept) == 0 {
+			delete(a.partitions, tp)
+		} else {
+			a.partitions[tp] = kept
+		}
+	}
+	return results
+}
+
+// Abort fails all queued batches, for example when the transaction is aborted.
+func (a *Accumulator) Abort(err error) []Result {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var results []Result
+	for _, tp := range a.sortedPartitions() {
+		for _, b := range a.partitions[tp] {
+			results = append(results, Result{TopicPartition: tp, Records: len(b.Batch.Records), BaseOffset: -1, LogAppendTimeMs: -1, Err: err})
+		}
+		delete(a.partitions, tp)
+	}
+	return results
+}
+
+// failed decides what happens with a batch after the error.
+func (a *Accumulator) failed(b *Batch, err error) []Result {
+	var kafkaErr *errorcodes.Error
+	tooLarge := errors.As(err, &kafkaErr) && (kafkaErr.Code == errorcodes.MessageTooLarge || kafkaErr.Code == errorcodes.RecordListTooLarge)
+
+	switch {
+	case a.Producer != nil && (a.Producer.State() == producerstate.AbortableError || a.Producer.State() == producerstate.FatalError):
+		return a.fail(b, err)
+	case tooLarge && len(b.Batch.Records) > 1:
+		a.split(b)
+		return nil
+	case errors.Is(err, producerstate.ErrEpochBumpRequired) || errors.Is(err, producerstate.ErrStaleBatch):
+		restamp(b)
+		return a.retry(b, err)
+	case producerstate.Retriable(err):
+		return a.retry(b, err)
+	default:
+		return a.fail(b, err)
+	}
+}
+
+// retry queues the batch again, or fails it when its delivery timeout passed.
+func (a *Accumulator) retry(b *Batch, err error) []Result {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.release(b.TopicPartition)
+	now := a.Now()
+	if now.Sub(b.Created) >= a.DeliveryTimeout {
+		return []Result{{TopicPartition: b.TopicPartition, Records: len(b.Batch.Records), BaseOffset: -1, LogAppendTimeMs: -1, Err: fmt.Errorf("%w: %w", ErrDeliveryTimeout, err)}}
+	}
+	b.retryAt = now.Add(a.RetryBackoff)
+	a.requeue(b.TopicPartition, b)
+	return nil
+}
+
+// split replaces the batch by two batches with half of its records each and queues them again.
+func (a *Accumulator) split(b *Batch) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.release(b.TopicPartition)
+	half := len(b.Batch.Records) / 2
+	var halves []*Batch
+	for _, recs := range [][]records.Record{b.Batch.Records[:half], b.Batch.Records[half:]} {
+		batch := records.NewRecordBatch(0, b.Batch.Compression())
+		size := records.BatchHeaderSize
+		for _, r := range recs {
+			timestamp := b.Batch.Timestamp(r)
+			var delta int64
+			if len(batch.Records) > 0 {
+				delta = timestamp - batch.BaseTimestamp
+			}
+			size += recordSize(int32(len(batch.Records)), delta, r.Key, r.Value, r.Headers)
+			batch.AppendRecord(timestamp, r.Key, r.Value, r.Headers)
+		}
+		halves = append(halves, &Batch{TopicPartition: b.TopicPartition, Batch: batch, Created: b.Created, Attempts: b.Attempts, id: b.id, size: size})
+	}
+	a.requeue(b.TopicPartition, halves...)
+}
+
+func (a *Accumulator) fail(b *Batch, err error) []Result {
+	a.done(b)
+	return []Result{{TopicPartition: b.TopicPartition, Records: len(b.Batch.Records), BaseOffset: -1, LogAppendTimeMs: -1, Err: err}}
+}
+
+func (a *Accumulator) done(b *Batch) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.release(b.TopicPartition)
+}
+
+func (a *Accumulator) release(tp TopicPartition) {
+	if a.inflight[tp] > 1 {
+		a.inflight[tp]--
+	} else {
+		delete(a.inflight, tp)
+	}
+}
+
+// restamp resets the producer state of the batch so that it is stamped again when it is drained.
+func restamp(b *Batch) {
+	b.stamped = false
+	b.Batch.ProducerId = records.NoProducerId
+	b.Batch.ProducerEpoch = records.NoProducerEpoch
+	b.Batch.BaseSequence = records.NoSequence
+	b.Batch.Attributes &^= records.TransactionalAttribute
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
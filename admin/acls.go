@@ -0,0 +1,242 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/scholzj/go-kafka-protocol/api/createacls"
+	"github.com/scholzj/go-kafka-protocol/api/deleteacls"
+	"github.com/scholzj/go-kafka-protocol/api/describeacls"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+// ACL resource types. ResourceAny only matches in filters.
+const (
+	ResourceAny             int8 = 1
+	ResourceTopic           int8 = 2
+	ResourceGroup           int8 = 3
+	ResourceCluster         int8 = 4
+	ResourceTransactionalId int8 = 5
+	ResourceDelegationToken int8 = 6
+	ResourceUser            int8 = 7
+)
+
+// ACL resource pattern types. PatternAny and PatternMatch only match in filters; PatternMatch matches
+// every ACL which applies to the resource name, including prefixed and wildcard ACLs.
+const (
+	PatternAny      int8 = 1
+	PatternMatch    int8 = 2
+	PatternLiteral  int8 = 3
+	PatternPrefixed int8 = 4
+)
+
+// ACL operations. OperationAny only matches in filters.
+const (
+	OperationAny             int8 = 1
+	OperationAll             int8 = 2
+	OperationRead            int8 = 3
+	OperationWrite           int8 = 4
+	OperationCreate          int8 = 5
+	OperationDelete          int8 = 6
+	OperationAlter           int8 = 7
+	OperationDescribe        int8 = 8
+	OperationClusterAction   int8 = 9
+	OperationDescribeConfigs int8 = 10
+	OperationAlterConfigs    int8 = 11
+	OperationIdempotentWrite int8 = 12
+	OperationCreateTokens    int8 = 13
+	OperationDescribeTokens  int8 = 14
+	OperationTwoPhaseCommit  int8 = 15
+)
+
+// ACL permission types. PermissionAny only matches in filters.
+const (
+	PermissionAny   int8 = 1
+	PermissionDeny  int8 = 2
+	PermissionAllow int8 = 3
+)
+
+// Acl allows or denies a principal, like "User:alice", an operation on a resource from a host, or from
+// any host with "*".
+type Acl struct {
+	ResourceType int8
+	ResourceName string
+	PatternType  int8
+	Principal    string
+	Host         string
+	Operation    int8
+	Permission   int8
+}
+
+// AclFilter matches ACLs. Nil names, principals and hosts match any value.
+type AclFilter struct {
+	ResourceType int8
+	ResourceName *string
+	PatternType  int8
+	Principal    *string
+	Host         *string
+	Operation    int8
+	Permission   int8
+}
+
+// DeletedAcl is an ACL matched by a filter of DeleteAcls, with the error of its deletion.
+type DeletedAcl struct {
+	Acl Acl
+	Err error
+}
+
+// DeleteAclsResult is the result of a filter of DeleteAcls.
+type DeleteAclsResult struct {
+	Filter  AclFilter
+	Deleted []DeletedAcl
+	Err     error
+}
+
+// CreateAcls creates the ACLs on any broker and returns their errors, in the order of the ACLs.
+func (a *Admin) CreateAcls(ctx context.Context, acls []Acl) ([]error, error) {
+	nodeId, err := a.anyBroker(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &createacls.CreateAclsResponse{}
+	err = a.send(ctx, nodeId, messages.CreateAcls, func(version int16) (protocol.RequestBody, error) {
+		creations := make([]createacls.CreateAclsRequestCreation, 0, len(acls))
+		for _, acl := range acls {
+			creations = append(creations, createacls.CreateAclsRequestCreation{
+				ResourceType:        acl.ResourceType,
+				ResourceName:        &acl.ResourceName,
+				ResourcePatternType: acl.PatternType,
+				Principal:           &acl.Principal,
+				Host:                &acl.Host,
+				Operation:           acl.Operation,
+				PermissionType:      acl.Permission,
+			})
+		}
+		return &createacls.CreateAclsRequest{ApiVersion: version, Creations: &creations}, nil
+	}, res)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]error, len(acls))
+	for i := range acls {
+		if res.Results == nil || i >= len(*res.Results) {
+			results[i] = fmt.Errorf("%w: %+v", ErrMissingResult, acls[i])
+			continue
+		}
+		r := (*res.Results)[i]
+		results[i] = errorcodes.ToError(r.ErrorCode, r.ErrorMessage)
+	}
+	return results, nil
+}
+
+// DescribeAcls returns the ACLs matching the filter.
+func (a *Admin) DescribeAcls(ctx context.Context, filter AclFilter) ([]Acl, error) {
+	nodeId, err := a.anyBroker(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &describeacls.DescribeAclsResponse{}
+	err = a.send(ctx, nodeId, messages.DescribeAcls, func(version int16) (protocol.RequestBody, error) {
+		return &describeacls.DescribeAclsRequest{
+			ApiVersion:         version,
+			ResourceTypeFilter: filter.ResourceType,
+			ResourceNameFilter: filter.ResourceName,
+			PatternTypeFilter:  filter.PatternType,
+			PrincipalFilter:    filter.Principal,
+			HostFilter:         filter.Host,
+			Operation:          filter.Operation,
+			PermissionType:     filter.Permission,
+		}, nil
+	}, res)
+	if err != nil {
+		return nil, err
+	}
+	if err := errorcodes.ToError(res.ErrorCode, res.ErrorMessage); err != nil {
+		return nil, err
+	}
+
+	var acls []Acl
+	if res.Resources == nil {
+		return acls, nil
+	}
+	for _, r := range *res.Resources {
+		if r.Acls == nil {
+			continue
+		}
+		for _, acl := range *r.Acls {
+			acls = append(acls, Acl{
+				ResourceType: r.ResourceType,
+				ResourceName: deref(r.ResourceName),
+				PatternType:  r.PatternType,
+				Principal:    deref(acl.Principal),
+				Host:         deref(acl.Host),
+				Operation:    acl.Operation,
+				Permission:   acl.PermissionType,
+			})
+		}
+	}
+	return acls, nil
+}
+
+// DeleteAcls deletes the ACLs matching the filters on any broker. The results are in the order of the
+// filters.
+func (a *Admin) DeleteAcls(ctx context.Context, filters []AclFilter) ([]DeleteAclsResult, error) {
+	nodeId, err := a.anyBroker(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &deleteacls.DeleteAclsResponse{}
+	err = a.send(ctx, nodeId, messages.DeleteAcls, func(version int16) (protocol.RequestBody, error) {
+		requested := make([]deleteacls.DeleteAclsRequestFilter, 0, len(filters))
+		for _, f := range filters {
+			requested = append(requested, deleteacls.DeleteAclsRequestFilter{
+				ResourceTypeFilter: f.ResourceType,
+				ResourceNameFilter: f.ResourceName,
+				PatternTypeFilter:  f.PatternType,
+				PrincipalFilter:    f.Principal,
+				HostFilter:         f.Host,
+				Operation:          f.Operation,
+				PermissionType:     f.Permission,
+			})
+		}
+		return &deleteacls.DeleteAclsRequest{ApiVersion: version, Filters: &requested}, nil
+	}, res)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]DeleteAclsResult, len(filters))
+	for i, f := range filters {
+		results[i].Filter = f
+		if res.FilterResults == nil || i >= len(*res.FilterResults) {
+			results[i].Err = fmt.Errorf("%w: %+v", ErrMissingResult, f)
+			continue
+		}
+		r := (*res.FilterResults)[i]
+		results[i].Err = errorcodes.ToError(r.ErrorCode, r.ErrorMessage)
+		if r.MatchingAcls == nil {
+			continue
+		}
+		for _, m := range *r.MatchingAcls {
+			results[i].Deleted = append(results[i].Deleted, DeletedAcl{
+				Acl: Acl{
+					ResourceType: m.ResourceType,
+					ResourceName: deref(m.ResourceName),
+					PatternType:  m.PatternType,
+					Principal:    deref(m.Principal),
+					Host:         deref(m.Host),
+					Operation:    m.Operation,
+					Permission:   m.PermissionType,
+				},
+				Err: errorcodes.ToError(m.ErrorCode, m.ErrorMessage),
+			})
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,408 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/createpartitions"
+	"github.com/scholzj/go-kafka-protocol/api/createtopics"
+	"github.com/scholzj/go-kafka-protocol/api/deletetopics"
+	"github.com/scholzj/go-kafka-protocol/api/metadata"
+	"github.com/scholzj/go-kafka-protocol/client"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+// Admin operations on top of a client.Client. The operations take and return plain Go values instead of
+// the request and response structs, build the request for the version negotiated with the broker and
+// send it to the broker which has to handle it: the controller for topic and partition changes, the
+// broker itself for its own configurations and log directories, and any broker otherwise. Errors of the
+// single resources are returned with their results, while the returned error reports a failure of the
+// whole operation.
+
+// DefaultTimeout is the time the brokers are given to complete an operation, like the Java admin client
+// default.
+const DefaultTimeout = 30 * time.Second
+
+var (
+	ErrUnknownBrokerResource = errors.New("the broker resource name is not a node id")
+	ErrMissingResult         = errors.New("the response has no result for the resource")
+)
+
+// Admin is safe for concurrent use.
+type Admin struct {
+	Client  *client.Client
+	Timeout time.Duration // Sent as the timeout of the operations which wait for the controller.
+}
+
+// TopicPartition identifies a partition by topic name.
+type TopicPartition struct {
+	Topic     string
+	Partition int32
+}
+
+func (tp TopicPartition) String() string {
+	return fmt.Sprintf("%s-%d", tp.Topic, tp.Partition)
+}
+
+func NewAdmin(c *client.Client) *Admin {
+	return &Admin{Client: c, Timeout: DefaultTimeout}
+}
+
+////////////////////
+// Routing
+////////////////////
+
+// send negotiates the version of the API with the broker, builds the request for that version and
+// sends it.
+func (a *Admin) send(ctx context.Context, nodeId int32, apiKey int16, build func(version int16) (protocol.RequestBody, error), res protocol.ResponseBody) error {
+	version, err := a.Client.Version(ctx, nodeId, apiKey)
+	if err != nil {
+		return err
+	}
+	req, err := build(version)
+	if err != nil {
+		return err
+	}
+	return a.Client.Send(ctx, nodeId, apiKey, version, req, res)
+}
+
+// sendToController sends the request to the controller. When the broker is not the controller anymore,
+// which it reports for every resource of the request, the metadata is refreshed and the request is sent
+// once more to the new controller.
+func (a *Admin) sendToController(ctx context.Context, apiKey int16, build func(version int16) (protocol.RequestBody, error), res protocol.ResponseBody, notController func() bool) error {
+	for attempt := 0; ; attempt++ {
+		nodeId, err := a.controller(ctx, attempt > 0)
+		if err != nil {
+			return err
+		}
+		if err := a.send(ctx, nodeId, apiKey, build, res); err != nil {
+			return err
+		}
+		if attempt > 0 || !notController() {
+			return nil
+		}
+	}
+}
+
+// controller returns the node id of the controller from the metadata, refreshing the metadata when the
+// controller is not known or refresh is set. With KRaft, this is a broker which forwards the requests to
+// the active controller.
+func (a *Admin) controller(ctx context.Context, refresh bool) (int32, error) {
+	if !refresh {
+		if id := a.Client.Cache.ControllerId(); id >= 0 {
+			if _, ok := a.Client.Cache.Broker(id); ok {
+				return id, nil
+			}
+		}
+	}
+
+	if err := a.refreshBrokers(ctx); err != nil {
+		return -1, err
+	}
+	id := a.Client.Cache.ControllerId()
+	if _, ok := a.Client.Cache.Broker(id); !ok {
+		return -1, fmt.Errorf("%w: %d", client.ErrUnknownBroker, id)
+	}
+	return id, nil
+}
+
+// anyBroker returns the broker with the lowest node id, so that requests which any broker can handle
+// use the same connection.
+func (a *Admin) anyBroker(ctx context.Context) (int32, error) {
+	brokers := a.Client.Cache.Brokers()
+	if len(brokers) == 0 {
+		if err := a.refreshBrokers(ctx); err != nil {
+			return -1, err
+		}
+		brokers = a.Client.Cache.Brokers()
+		if len(brokers) == 0 {
+			return -1, client.ErrNoBrokers
+		}
+	}
+	return brokers[0].NodeId, nil
+}
+
+// refreshBrokers refreshes the brokers and the controller without the metadata of any topic.
+func (a *Admin) refreshBrokers(ctx context.Context) error {
+	_, err := a.Client.Metadata(ctx, &metadata.MetadataRequest{Topics: &[]metadata.MetadataRequestTopic{}})
+	return err
+}
+
+func (a *Admin) timeoutMs() int32 {
+	return int32(a.Timeout.Milliseconds())
+}
+
+////////////////////
+// Topics
+////////////////////
+
+// NewTopic describes a topic to create. NumPartitions and ReplicationFactor can be -1 to use the broker
+// defaults, and must be -1 when Assignments places the replicas of the partitions explicitly.
+type NewTopic struct {
+	Name              string
+	NumPartitions     int32
+	ReplicationFactor int16
+	Assignments       map[int32][]int32 // Replicas by partition index.
+	Configs           map[string]string
+}
+
+// CreateTopicResult is the result of creating one topic. NumPartitions, ReplicationFactor and Configs are
+// only returned by brokers supporting version 5 and are -1 and nil otherwise; TopicId requires version 7.
+type CreateTopicResult struct {
+	Name              string
+	TopicId           uuid.UUID
+	NumPartitions     int32
+	ReplicationFactor int16
+	Configs           []ConfigEntry
+	Err               error
+}
+
+// CreateTopics creates the topics, or only validates them with validateOnly. The results are in the
+// order of the topics.
+func (a *Admin) CreateTopics(ctx context.Context, topics []NewTopic, validateOnly bool) ([]CreateTopicResult, error) {
+	build := func(version int16) (protocol.RequestBody, error) {
+		req := &createtopics.CreateTopicsRequest{ApiVersion: version, TimeoutMs: a.timeoutMs(), ValidateOnly: validateOnly}
+		creatable := make([]createtopics.CreateTopicsRequestTopic, 0, len(topics))
+		for _, topic := range topics {
+			assignments := make([]createtopics.CreateTopicsRequestTopicAssignment, 0, len(topic.Assignments))
+			for _, index := range sortedKeys(topic.Assignments) {
+				brokerIds := append([]int32(nil), topic.Assignments[index]...)
+				assignments = append(assignments, createtopics.CreateTopicsRequestTopicAssignment{PartitionIndex: index, BrokerIds: &brokerIds})
+			}
+			configs := make([]createtopics.CreateTopicsRequestTopicConfig, 0, len(topic.Configs))
+			for _, name := range sortedKeys(topic.Configs) {
+				value := topic.Configs[name]
+				configs = append(configs, createtopics.CreateTopicsRequestTopicConfig{Name: &name, Value: &value})
+			}
+			creatable = append(creatable, createtopics.CreateTopicsRequestTopic{
+				Name:              &topic.Name,
+				NumPartitions:     topic.NumPartitions,
+				ReplicationFactor: topic.ReplicationFactor,
+				Assignments:       &assignments,
+				Configs:           &configs,
+			})
+		}
+		req.Topics = &creatable
+		return req, nil
+	}
+
+	res := &createtopics.CreateTopicsResponse{}
+	notController := func() bool {
+		return res.Topics != nil && allErrors(len(*res.Topics), func(i int) int16 { return (*res.Topics)[i].ErrorCode }, errorcodes.NotController)
+	}
+	if err := a.sendToController(ctx, messages.CreateTopics, build, res, notController); err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]createtopics.CreateTopicsResponseTopic)
+	if res.Topics != nil {
+		for _, t := range *res.Topics {
+			byName[deref(t.Name)] = t
+		}
+	}
+	results := make([]CreateTopicResult, 0, len(topics))
+	for _, topic := range topics {
+		result := CreateTopicResult{Name: topic.Name, NumPartitions: -1, ReplicationFactor: -1}
+		t, ok := byName[topic.Name]
+		if !ok {
+			result.Err = fmt.Errorf("%w: %s", ErrMissingResult, topic.Name)
+			results = append(results, result)
+			continue
+		}
+		result.TopicId = t.TopicId
+		result.Err = errorcodes.ToError(t.ErrorCode, t.ErrorMessage)
+		if res.ApiVersion >= 5 {
+			result.NumPartitions = t.NumPartitions
+			result.ReplicationFactor = t.ReplicationFactor
+			if t.Configs != nil {
+				for _, c := range *t.Configs {
+					result.Configs = append(result.Configs, ConfigEntry{
+						Name:      deref(c.Name),
+						Value:     c.Value,
+						ReadOnly:  c.ReadOnly,
+						Source:    c.ConfigSource,
+						Sensitive: c.IsSensitive,
+					})
+				}
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// DeleteTopics deletes the topics by name and returns the error of every topic, nil when it was
+// deleted.
+func (a *Admin) DeleteTopics(ctx context.Context, names ...string) (map[string]error, error) {
+	build := func(version int16) (protocol.RequestBody, error) {
+		req := &deletetopics.DeleteTopicsRequest{ApiVersion: version, TimeoutMs: a.timeoutMs()}
+		if version >= 6 {
+			topics := make([]deletetopics.DeleteTopicsRequestTopic, 0, len(names))
+			for _, name := range names {
+				topics = append(topics, deletetopics.DeleteTopicsRequestTopic{Name: &name})
+			}
+			req.Topics = &topics
+		} else {
+			req.TopicNames = &names
+		}
+		return req, nil
+	}
+
+	res, err := a.deleteTopics(ctx, build)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]error, len(names))
+	for _, name := range names {
+		results[name] = fmt.Errorf("%w: %s", ErrMissingResult, name)
+	}
+	for _, r := range *res.Responses {
+		if name := deref(r.Name); name != "" {
+			results[name] = errorcodes.ToError(r.ErrorCode, r.ErrorMessage)
+		}
+	}
+	return results, nil
+}
+
+// DeleteTopicIds deletes the topics by id and returns the error of every topic, nil when it was
+// deleted. Brokers without support for topic ids (version 6) get the names of the topics from the
+// metadata cache.
+func (a *Admin) DeleteTopicIds(ctx context.Context, ids ...uuid.UUID) (map[uuid.UUID]error, error) {
+	build := func(version int16) (protocol.RequestBody, error) {
+		req := &deletetopics.DeleteTopicsRequest{ApiVersion: version, TimeoutMs: a.timeoutMs()}
+		if version >= 6 {
+			topics := make([]deletetopics.DeleteTopicsRequestTopic, 0, len(ids))
+			for _, id := range ids {
+				topics = append(topics, deletetopics.DeleteTopicsRequestTopic{TopicId: id})
+			}
+			req.Topics = &topics
+			return req, nil
+		}
+
+		names := make([]string, 0, len(ids))
+		for _, id := range ids {
+			name, ok := a.Client.Cache.TopicName(id)
+			if !ok {
+				return nil, fmt.Errorf("%w: the name of topic %s is not known for version %d", client.ErrUnsupportedVersion, id, version)
+			}
+			names = append(names, name)
+		}
+		req.TopicNames = &names
+		return req, nil
+	}
+
+	res, err := a.deleteTopics(ctx, build)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[uuid.UUID]error, len(ids))
+	for _, id := range ids {
+		results[id] = fmt.Errorf("%w: %s", ErrMissingResult, id)
+	}
+	for _, r := range *res.Responses {
+		id := r.TopicId
+		if res.ApiVersion < 6 {
+			id, _ = a.Client.Cache.TopicId(deref(r.Name))
+		}
+		if _, ok := results[id]; ok {
+			results[id] = errorcodes.ToError(r.ErrorCode, r.ErrorMessage)
+		}
+	}
+	return results, nil
+}
+
+func (a *Admin) deleteTopics(ctx context.Context, build func(version int16) (protocol.RequestBody, error)) (*deletetopics.DeleteTopicsResponse, error) {
+	res := &deletetopics.DeleteTopicsResponse{}
+	notController := func() bool {
+		return res.Responses != nil && allErrors(len(*res.Responses), func(i int) int16 { return (*res.Responses)[i].ErrorCode }, errorcodes.NotController)
+	}
+	if err := a.sendToController(ctx, messages.DeleteTopics, build, res, notController); err != nil {
+		return nil, err
+	}
+	if res.Responses == nil {
+		res.Responses = &[]deletetopics.DeleteTopicsResponseResponse{}
+	}
+	return res, nil
+}
+
+// NewPartitions increases the number of partitions of a topic to Count. Assignments optionally places
+// the replicas of each new partition.
+type NewPartitions struct {
+	Count       int32
+	Assignments [][]int32
+}
+
+// CreatePartitions adds partitions to the topics, or only validates the change with validateOnly, and
+// returns the error of every topic.
+func (a *Admin) CreatePartitions(ctx context.Context, partitions map[string]NewPartitions, validateOnly bool) (map[string]error, error) {
+	build := func(version int16) (protocol.RequestBody, error) {
+		topics := make([]createpartitions.CreatePartitionsRequestTopic, 0, len(partitions))
+		for _, name := range sortedKeys(partitions) {
+			p := partitions[name]
+			topic := createpartitions.CreatePartitionsRequestTopic{Name: &name, Count: p.Count}
+			if p.Assignments != nil {
+				assignments := make([]createpartitions.CreatePartitionsRequestTopicAssignment, 0, len(p.Assignments))
+				for _, brokerIds := range p.Assignments {
+					brokerIds := append([]int32(nil), brokerIds...)
+					assignments = append(assignments, createpartitions.CreatePartitionsRequestTopicAssignment{BrokerIds: &brokerIds})
+				}
+				topic.Assignments = &assignments
+			}
+			topics = append(topics, topic)
+		}
+		return &createpartitions.CreatePartitionsRequest{ApiVersion: version, Topics: &topics, TimeoutMs: a.timeoutMs(), ValidateOnly: validateOnly}, nil
+	}
+
+	res := &createpartitions.CreatePartitionsResponse{}
+	notController := func() bool {
+		return res.Results != nil && allErrors(len(*res.Results), func(i int) int16 { return (*res.Results)[i].ErrorCode }, errorcodes.NotController)
+	}
+	if err := a.sendToController(ctx, messages.CreatePartitions, build, res, notController); err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]error, len(partitions))
+	for name := range partitions {
+		results[name] = fmt.Errorf("%w: %s", ErrMissingResult, name)
+	}
+	if res.Results != nil {
+		for _, r := range *res.Results {
+			results[deref(r.Name)] = errorcodes.ToError(r.ErrorCode, r.ErrorMessage)
+		}
+	}
+	return results, nil
+}
+
+// allErrors reports whether the n results all have the error code.
+func allErrors(n int, errorCode func(i int) int16, code int16) bool {
+	for i := 0; i < n; i++ {
+		if errorCode(i) != code {
+			return false
+		}
+	}
+	return n > 0
+}
+
+func sortedKeys[K string | int32, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,544 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scholzj/go-kafka-protocol/api/alterpartitionreassignments"
+	"github.com/scholzj/go-kafka-protocol/api/alteruserscramcredentials"
+	"github.com/scholzj/go-kafka-protocol/api/createacls"
+	"github.com/scholzj/go-kafka-protocol/api/createdelegationtoken"
+	"github.com/scholzj/go-kafka-protocol/api/createtopics"
+	"github.com/scholzj/go-kafka-protocol/api/deleteacls"
+	"github.com/scholzj/go-kafka-protocol/api/deletetopics"
+	"github.com/scholzj/go-kafka-protocol/api/describeacls"
+	"github.com/scholzj/go-kafka-protocol/api/describeconfigs"
+	"github.com/scholzj/go-kafka-protocol/api/describedelegationtoken"
+	"github.com/scholzj/go-kafka-protocol/api/describelogdirs"
+	"github.com/scholzj/go-kafka-protocol/api/describeuserscramcredentials"
+	"github.com/scholzj/go-kafka-protocol/api/electleaders"
+	"github.com/scholzj/go-kafka-protocol/api/expiredelegationtoken"
+	"github.com/scholzj/go-kafka-protocol/api/incrementalalterconfigs"
+	"github.com/scholzj/go-kafka-protocol/api/listpartitionreassignments"
+	"github.com/scholzj/go-kafka-protocol/api/renewdelegationtoken"
+	"github.com/scholzj/go-kafka-protocol/client"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/mockbroker"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+	"github.com/scholzj/go-kafka-protocol/sasl"
+)
+
+func str(s string) *string {
+	return &s
+}
+
+func testAdmin(t *testing.T) (*mockbroker.Cluster, *Admin, context.Context) {
+	t.Helper()
+
+	cluster, err := mockbroker.NewCluster(3)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	c := client.NewClient(cluster.Addresses()...)
+	t.Cleanup(func() {
+		c.Close()
+		cluster.Close()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return cluster, NewAdmin(c), ctx
+}
+
+func TestTopics(t *testing.T) {
+	cluster, a, ctx := testAdmin(t)
+
+	// The first request is rejected as if the controller had moved.
+	var mu sync.Mutex
+	var nodes []int32
+	cluster.Handle(messages.CreateTopics, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		req := body.(*createtopics.CreateTopicsRequest)
+		mu.Lock()
+		nodes = append(nodes, b.NodeId)
+		attempt := len(nodes)
+		mu.Unlock()
+
+		res := &createtopics.CreateTopicsResponse{ApiVersion: req.ApiVersion, Topics: &[]createtopics.CreateTopicsResponseTopic{}}
+		for _, topic := range *req.Topics {
+			result := createtopics.CreateTopicsResponseTopic{Name: topic.Name, NumPartitions: topic.NumPartitions, ReplicationFactor: topic.ReplicationFactor}
+			if attempt == 1 {
+				result.ErrorCode = errorcodes.NotController
+			} else if id, err := cluster.CreateTopic(*topic.Name, topic.NumPartitions, topic.ReplicationFactor); err != nil {
+				result.ErrorCode = errorcodes.TopicAlreadyExists
+			} else {
+				result.TopicId = id
+				result.Configs = &[]createtopics.CreateTopicsResponseTopicConfig{}
+				for _, c := range *topic.Configs {
+					*result.Configs = append(*result.Configs, createtopics.CreateTopicsResponseTopicConfig{Name: c.Name, Value: c.Value, ConfigSource: DynamicTopicConfigSource})
+				}
+			}
+			*res.Topics = append(*res.Topics, result)
+		}
+		return res, nil
+	})
+	var deletedVersions []int16
+	cluster.Handle(messages.DeleteTopics, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		req := body.(*deletetopics.DeleteTopicsRequest)
+		mu.Lock()
+		deletedVersions = append(deletedVersions, req.ApiVersion)
+		mu.Unlock()
+
+		var names []string
+		if req.ApiVersion >= 6 {
+			for _, topic := range *req.Topics {
+				names = append(names, *topic.Name)
+			}
+		} else {
+			names = *req.TopicNames
+		}
+		res := &deletetopics.DeleteTopicsResponse{ApiVersion: req.ApiVersion, Responses: &[]deletetopics.DeleteTopicsResponseResponse{}}
+		for _, name := range names {
+			cluster.DeleteTopic(name)
+			*res.Responses = append(*res.Responses, deletetopics.DeleteTopicsResponseResponse{Name: str(name)})
+		}
+		return res, nil
+	})
+
+	results, err := a.CreateTopics(ctx, []NewTopic{
+		{Name: "orders", NumPartitions: 3, ReplicationFactor: 2, Configs: map[string]string{"retention.ms": "1000"}},
+		{Name: "payments", NumPartitions: 1, ReplicationFactor: 1},
+	}, false)
+	if err != nil {
+		t.Fatalf("CreateTopics: %v", err)
+	}
+	if len(nodes) != 2 || nodes[0] != 0 || nodes[1] != 0 {
+		t.Errorf("unexpected controller requests %v", nodes)
+	}
+	if r := results[0]; r.Err != nil || r.NumPartitions != 3 || len(r.Configs) != 1 || *r.Configs[0].Value != "1000" {
+		t.Errorf("unexpected result %+v", r)
+	}
+
+	results, err = a.CreateTopics(ctx, []NewTopic{{Name: "orders", NumPartitions: 1, ReplicationFactor: 1}}, false)
+	var kafkaErr *errorcodes.Error
+	if err != nil || !errors.As(results[0].Err, &kafkaErr) || kafkaErr.Code != errorcodes.TopicAlreadyExists {
+		t.Errorf("unexpected result %+v (%v)", results, err)
+	}
+
+	deletedNames, err := a.DeleteTopics(ctx, "orders")
+	if err != nil || len(deletedNames) != 1 || deletedNames["orders"] != nil {
+		t.Errorf("unexpected results %v (%v)", deletedNames, err)
+	}
+	// Below version 6, the ids are translated to names.
+	cluster.SetMaxVersion(messages.DeleteTopics, 5)
+	old := NewAdmin(client.NewClient(cluster.Addresses()...))
+	defer old.Client.Close()
+	if err := old.Client.RefreshMetadata(ctx); err != nil {
+		t.Fatalf("RefreshMetadata: %v", err)
+	}
+	id, _ := old.Client.Cache.TopicId("payments")
+	deleted, err := old.DeleteTopicIds(ctx, id)
+	if err != nil || len(deleted) != 1 || deleted[id] != nil {
+		t.Errorf("unexpected results %v (%v)", deleted, err)
+	}
+	if len(deletedVersions) != 2 || deletedVersions[0] != 6 || deletedVersions[1] != 5 {
+		t.Errorf("unexpected versions %v", deletedVersions)
+	}
+}
+
+func TestConfigs(t *testing.T) {
+	cluster, a, ctx := testAdmin(t)
+
+	var mu sync.Mutex
+	configs := map[ConfigResource]map[string]string{}
+	cluster.Handle(messages.DescribeConfigs, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		req := body.(*describeconfigs.DescribeConfigsRequest)
+		mu.Lock()
+		defer mu.Unlock()
+
+		res := &describeconfigs.DescribeConfigsResponse{ApiVersion: req.ApiVersion, Results: &[]describeconfigs.DescribeConfigsResponseResult{}}
+		for _, r := range *req.Resources {
+			result := describeconfigs.DescribeConfigsResponseResult{ResourceType: r.ResourceType, ResourceName: r.ResourceName, Configs: &[]describeconfigs.DescribeConfigsResponseResultConfig{}}
+			switch r.ResourceType {
+			case BrokerConfigResource:
+				if *r.ResourceName != strconv.Itoa(int(b.NodeId)) {
+					result.ErrorCode = errorcodes.InvalidRequest
+				}
+				*result.Configs = append(*result.Configs, describeconfigs.DescribeConfigsResponseResultConfig{Name: str("broker.id"), Value: str(*r.ResourceName), ReadOnly: true, ConfigSource: StaticBrokerConfigSource, Synonyms: &[]describeconfigs.DescribeConfigsResponseResultConfigSynonym{}})
+			case TopicConfigResource:
+				for name, value := range configs[ConfigResource{r.ResourceType, *r.ResourceName}] {
+					*result.Configs = append(*result.Configs, describeconfigs.DescribeConfigsResponseResultConfig{Name: str(name), Value: str(value), ConfigSource: DynamicTopicConfigSource, Synonyms: &[]describeconfigs.DescribeConfigsResponseResultConfigSynonym{}})
+				}
+			}
+			*res.Results = append(*res.Results, result)
+		}
+		return res, nil
+	})
+	cluster.Handle(messages.IncrementalAlterConfigs, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		req := body.(*incrementalalterconfigs.IncrementalAlterConfigsRequest)
+		mu.Lock()
+		defer mu.Unlock()
+
+		res := &incrementalalterconfigs.IncrementalAlterConfigsResponse{ApiVersion: req.ApiVersion, Responses: &[]incrementalalterconfigs.IncrementalAlterConfigsResponseResponse{}}
+		for _, r := range *req.Resources {
+			result := incrementalalterconfigs.IncrementalAlterConfigsResponseResponse{ResourceType: r.ResourceType, ResourceName: r.ResourceName}
+			resource := ConfigResource{r.ResourceType, *r.ResourceName}
+			if configs[resource] == nil {
+				configs[resource] = map[string]string{}
+			}
+			for _, c := range *r.Configs {
+				switch c.ConfigOperation {
+				case ConfigSet:
+					configs[resource][*c.Name] = *c.Value
+				case ConfigDelete:
+					delete(configs[resource], *c.Name)
+				default:
+					result.ErrorCode = errorcodes.InvalidConfig
+				}
+			}
+			*res.Responses = append(*res.Responses, result)
+		}
+		return res, nil
+	})
+
+	orders := ConfigResource{TopicConfigResource, "orders"}
+	payments := ConfigResource{TopicConfigResource, "payments"}
+	altered, err := a.IncrementalAlterConfigs(ctx, map[ConfigResource][]AlterConfigOp{
+		orders:   {{Name: "retention.ms", Op: ConfigSet, Value: str("1000")}, {Name: "cleanup.policy", Op: ConfigSet, Value: str("compact")}},
+		payments: {{Name: "cleanup.policy", Op: ConfigAppend, Value: str("delete")}},
+	}, false)
+	if err != nil || altered[orders] != nil || altered[payments] == nil {
+		t.Fatalf("unexpected results %v (%v)", altered, err)
+	}
+
+	// Broker configurations are described by the broker itself.
+	results, err := a.DescribeConfigs(ctx, []ConfigResource{orders, {BrokerConfigResource, "2"}, {BrokerConfigResource, "1"}}, DescribeConfigsOptions{IncludeSynonyms: true})
+	if err != nil || len(results) != 3 {
+		t.Fatalf("unexpected results %+v (%v)", results, err)
+	}
+	if r := results[0]; r.Err != nil || len(r.Configs) != 2 || r.Configs[0].Source != DynamicTopicConfigSource {
+		t.Errorf("unexpected result %+v", r)
+	}
+	for _, r := range results[1:] {
+		if r.Err != nil || len(r.Configs) != 1 || *r.Configs[0].Value != r.Resource.Name || !r.Configs[0].ReadOnly {
+			t.Errorf("unexpected result %+v", r)
+		}
+	}
+
+	if _, err := a.DescribeConfigs(ctx, []ConfigResource{{BrokerLoggerConfigResource, ""}}, DescribeConfigsOptions{}); !errors.Is(err, ErrUnknownBrokerResource) {
+		t.Errorf("unexpected error %v", err)
+	}
+	results, err = a.DescribeConfigs(ctx, []ConfigResource{{BrokerConfigResource, "7"}}, DescribeConfigsOptions{})
+	if err != nil || !errors.Is(results[0].Err, client.ErrUnknownBroker) {
+		t.Errorf("unexpected results %+v (%v)", results, err)
+	}
+}
+
+func TestAcls(t *testing.T) {
+	cluster, a, ctx := testAdmin(t)
+
+	var mu sync.Mutex
+	var acls []Acl
+	matches := func(f AclFilter, acl Acl) bool {
+		return (f.ResourceType == ResourceAny || f.ResourceType == acl.ResourceType) &&
+			(f.ResourceName == nil || *f.ResourceName == acl.ResourceName) &&
+			(f.Principal == nil || *f.Principal == acl.Principal) &&
+			(f.Operation == OperationAny || f.Operation == acl.Operation)
+	}
+	cluster.Handle(messages.CreateAcls, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		req := body.(*createacls.CreateAclsRequest)
+		mu.Lock()
+		defer mu.Unlock()
+
+		res := &createacls.CreateAclsResponse{ApiVersion: req.ApiVersion, Results: &[]createacls.CreateAclsResponseResult{}}
+		for _, c := range *req.Creations {
+			result := createacls.CreateAclsResponseResult{}
+			if c.ResourcePatternType == PatternAny {
+				result.ErrorCode = errorcodes.InvalidRequest
+			} else {
+				acls = append(acls, Acl{c.ResourceType, *c.ResourceName, c.ResourcePatternType, *c.Principal, *c.Host, c.Operation, c.PermissionType})
+			}
+			*res.Results = append(*res.Results, result)
+		}
+		return res, nil
+	})
+	cluster.Handle(messages.DescribeAcls, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		req := body.(*describeacls.DescribeAclsRequest)
+		mu.Lock()
+		defer mu.Unlock()
+
+		res := &describeacls.DescribeAclsResponse{ApiVersion: req.ApiVersion, Resources: &[]describeacls.DescribeAclsResponseResource{}}
+		filter := AclFilter{req.ResourceTypeFilter, req.ResourceNameFilter, req.PatternTypeFilter, req.PrincipalFilter, req.HostFilter, req.Operation, req.PermissionType}
+		for _, acl := range acls {
+			if matches(filter, acl) {
+				*res.Resources = append(*res.Resources, describeacls.DescribeAclsResponseResource{
+					ResourceType: acl.ResourceType,
+					ResourceName: str(acl.ResourceName),
+					PatternType:  acl.PatternType,
+					Acls:         &[]describeacls.DescribeAclsResponseResourceAcl{{Principal: str(acl.Principal), Host: str(acl.Host), Operation: acl.Operation, PermissionType: acl.Permission}},
+				})
+			}
+		}
+		return res, nil
+	})
+	cluster.Handle(messages.DeleteAcls, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		req := body.(*deleteacls.DeleteAclsRequest)
+		mu.Lock()
+		defer mu.Unlock()
+
+		res := &deleteacls.DeleteAclsResponse{ApiVersion: req.ApiVersion, FilterResults: &[]deleteacls.DeleteAclsResponseFilterResult{}}
+		for _, f := range *req.Filters {
+			filter := AclFilter{f.ResourceTypeFilter, f.ResourceNameFilter, f.PatternTypeFilter, f.PrincipalFilter, f.HostFilter, f.Operation, f.PermissionType}
+			result := deleteacls.DeleteAclsResponseFilterResult{MatchingAcls: &[]deleteacls.DeleteAclsResponseFilterResultMatchingAcl{}}
+			kept := acls[:0]
+			for _, acl := range acls {
+				if !matches(filter, acl) {
+					kept = append(kept, acl)
+					continue
+				}
+				*result.MatchingAcls = append(*result.MatchingAcls, deleteacls.DeleteAclsResponseFilterResultMatchingAcl{
+					ResourceType: acl.ResourceType, ResourceName: str(acl.ResourceName), PatternType: acl.PatternType,
+					Principal: str(acl.Principal), Host: str(acl.Host), Operation: acl.Operation, PermissionType: acl.Permission,
+				})
+			}
+			acls = kept
+			*res.FilterResults = append(*res.FilterResults, result)
+		}
+		return res, nil
+	})
+
+	errs, err := a.CreateAcls(ctx, []Acl{
+		{ResourceTopic, "orders", PatternLiteral, "User:alice", "*", OperationRead, PermissionAllow},
+		{ResourceTopic, "orders", PatternLiteral, "User:bob", "*", OperationWrite, PermissionAllow},
+		{ResourceGroup, "group", PatternPrefixed, "User:alice", "*", OperationRead, PermissionAllow},
+		{ResourceTopic, "orders", PatternAny, "User:alice", "*", OperationRead, PermissionAllow},
+	})
+	if err != nil || len(errs) != 4 || errs[0] != nil || errs[3] == nil {
+		t.Fatalf("unexpected results %v (%v)", errs, err)
+	}
+
+	described, err := a.DescribeAcls(ctx, AclFilter{ResourceType: ResourceAny, PatternType: PatternAny, Principal: str("User:alice"), Operation: OperationAny, Permission: PermissionAny})
+	if err != nil || len(described) != 2 || described[1].ResourceName != "group" || described[1].PatternType != PatternPrefixed {
+		t.Errorf("unexpected ACLs %+v (%v)", described, err)
+	}
+
+	results, err := a.DeleteAcls(ctx, []AclFilter{
+		{ResourceType: ResourceTopic, PatternType: PatternAny, Operation: OperationAny, Permission: PermissionAny},
+		{ResourceType: ResourceCluster, PatternType: PatternAny, Operation: OperationAny, Permission: PermissionAny},
+	})
+	if err != nil || len(results) != 2 || len(results[0].Deleted) != 2 || results[0].Deleted[1].Acl.Principal != "User:bob" || len(results[1].Deleted) != 0 {
+		t.Errorf("unexpected results %+v (%v)", results, err)
+	}
+}
+
+func TestPartitions(t *testing.T) {
+	cluster, a, ctx := testAdmin(t)
+	cluster.SetMaxVersion(messages.ElectLeaders, 0)
+	cluster.SetMaxVersion(messages.DescribeLogDirs, 3)
+
+	var mu sync.Mutex
+	reassignments := map[TopicPartition][]int32{}
+	cluster.Handle(messages.ElectLeaders, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		req := body.(*electleaders.ElectLeadersRequest)
+		res := &electleaders.ElectLeadersResponse{ApiVersion: req.ApiVersion, ReplicaElectionResults: &[]electleaders.ElectLeadersResponseReplicaElectionResult{}}
+		for _, t := range *req.TopicPartitions {
+			result := electleaders.ElectLeadersResponseReplicaElectionResult{Topic: t.Topic, PartitionResult: &[]electleaders.ElectLeadersResponseReplicaElectionResultPartitionResult{}}
+			for _, p := range *t.Partitions {
+				r := electleaders.ElectLeadersResponseReplicaElectionResultPartitionResult{PartitionId: p}
+				if p > 0 {
+					r.ErrorCode = errorcodes.ElectionNotNeeded
+				}
+				*result.PartitionResult = append(*result.PartitionResult, r)
+			}
+			*res.ReplicaElectionResults = append(*res.ReplicaElectionResults, result)
+		}
+		return res, nil
+	})
+	cluster.Handle(messages.AlterPartitionReassignments, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		req := body.(*alterpartitionreassignments.AlterPartitionReassignmentsRequest)
+		mu.Lock()
+		defer mu.Unlock()
+
+		res := &alterpartitionreassignments.AlterPartitionReassignmentsResponse{ApiVersion: req.ApiVersion, Responses: &[]alterpartitionreassignments.AlterPartitionReassignmentsResponseResponse{}}
+		for _, t := range *req.Topics {
+			result := alterpartitionreassignments.AlterPartitionReassignmentsResponseResponse{Name: t.Name, Partitions: &[]alterpartitionreassignments.AlterPartitionReassignmentsResponseResponsePartition{}}
+			for _, p := range *t.Partitions {
+				r := alterpartitionreassignments.AlterPartitionReassignmentsResponseResponsePartition{PartitionIndex: p.PartitionIndex}
+				tp := TopicPartition{*t.Name, p.PartitionIndex}
+				if p.Replicas != nil {
+					reassignments[tp] = *p.Replicas
+				} else if _, ok := reassignments[tp]; ok {
+					delete(reassignments, tp)
+				} else {
+					r.ErrorCode = errorcodes.NoReassignmentInProgress
+				}
+				*result.Partitions = append(*result.Partitions, r)
+			}
+			*res.Responses = append(*res.Responses, result)
+		}
+		return res, nil
+	})
+	cluster.Handle(messages.ListPartitionReassignments, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		req := body.(*listpartitionreassignments.ListPartitionReassignmentsRequest)
+		mu.Lock()
+		defer mu.Unlock()
+
+		res := &listpartitionreassignments.ListPartitionReassignmentsResponse{ApiVersion: req.ApiVersion, Topics: &[]listpartitionreassignments.ListPartitionReassignmentsResponseTopic{}}
+		for tp, replicas := range reassignments {
+			*res.Topics = append(*res.Topics, listpartitionreassignments.ListPartitionReassignmentsResponseTopic{
+				Name: str(tp.Topic),
+				Partitions: &[]listpartitionreassignments.ListPartitionReassignmentsResponseTopicPartition{
+					{PartitionIndex: tp.Partition, Replicas: &replicas, AddingReplicas: &[]int32{replicas[len(replicas)-1]}, RemovingReplicas: &[]int32{}},
+				},
+			})
+		}
+		return res, nil
+	})
+	cluster.Handle(messages.DescribeLogDirs, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		req := body.(*describelogdirs.DescribeLogDirsRequest)
+		return &describelogdirs.DescribeLogDirsResponse{ApiVersion: req.ApiVersion, Results: &[]describelogdirs.DescribeLogDirsResponseResult{{
+			LogDir:      str("/var/lib/kafka/" + strconv.Itoa(int(b.NodeId))),
+			TotalBytes:  1000,
+			UsableBytes: 500,
+			Topics: &[]describelogdirs.DescribeLogDirsResponseResultTopic{{
+				Name:       str("orders"),
+				Partitions: &[]describelogdirs.DescribeLogDirsResponseResultTopicPartition{{PartitionIndex: b.NodeId, PartitionSize: 100}},
+			}},
+		}}}, nil
+	})
+
+	orders0 := TopicPartition{"orders", 0}
+	orders1 := TopicPartition{"orders", 1}
+	elected, err := a.ElectLeaders(ctx, PreferredElection, []TopicPartition{orders1, orders0})
+	var kafkaErr *errorcodes.Error
+	if err != nil || elected[orders0] != nil || !errors.As(elected[orders1], &kafkaErr) || kafkaErr.Code != errorcodes.ElectionNotNeeded {
+		t.Errorf("unexpected results %v (%v)", elected, err)
+	}
+	if _, err := a.ElectLeaders(ctx, UncleanElection, []TopicPartition{orders0}); !errors.Is(err, client.ErrUnsupportedVersion) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	altered, err := a.AlterPartitionReassignments(ctx, map[TopicPartition][]int32{orders0: {1, 2}, orders1: nil}, false)
+	if err != nil || altered[orders0] != nil || altered[orders1] == nil {
+		t.Errorf("unexpected results %v (%v)", altered, err)
+	}
+	listed, err := a.ListPartitionReassignments(ctx, nil)
+	if err != nil || len(listed) != 1 || len(listed[orders0].Replicas) != 2 || listed[orders0].AddingReplicas[0] != 2 {
+		t.Errorf("unexpected reassignments %+v (%v)", listed, err)
+	}
+
+	dirs, err := a.DescribeLogDirs(ctx, nil, []TopicPartition{orders0})
+	if err != nil || len(dirs) != 3 {
+		t.Fatalf("unexpected log dirs %+v (%v)", dirs, err)
+	}
+	for i, d := range dirs {
+		if d.NodeId != int32(i) || d.Err != nil || len(d.LogDirs) != 1 || d.LogDirs[0].TotalBytes != -1 || d.LogDirs[0].Replicas[0].Partition != int32(i) {
+			t.Errorf("unexpected log dirs %+v", d)
+		}
+	}
+}
+
+func TestScramCredentials(t *testing.T) {
+	cluster, a, ctx := testAdmin(t)
+
+	credentials := sasl.NewCredentials()
+	cluster.Handle(messages.AlterUserScramCredentials, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		return credentials.AlterUserScramCredentials(body.(*alteruserscramcredentials.AlterUserScramCredentialsRequest)), nil
+	})
+	cluster.Handle(messages.DescribeUserScramCredentials, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		return credentials.DescribeUserScramCredentials(body.(*describeuserscramcredentials.DescribeUserScramCredentialsRequest)), nil
+	})
+
+	errs, err := a.UpsertScramPasswords(ctx, sasl.ScramSha512, map[string]string{"alice": "secret", "bob": "password"})
+	if err != nil || len(errs) != 2 || errs["alice"] != nil || errs["bob"] != nil {
+		t.Fatalf("UpsertScramPasswords: %v %v", errs, err)
+	}
+	if c, ok := credentials.ScramCredential(sasl.ScramSha512, "alice"); !ok || c.Iterations != sasl.DefaultScramIterations {
+		t.Errorf("unexpected credential %+v", c)
+	}
+
+	upsertion, err := sasl.NewScramUpsertion(sasl.ScramSha256, "alice", "secret", 8192)
+	if err != nil {
+		t.Fatalf("NewScramUpsertion: %v", err)
+	}
+	errs, err = a.AlterUserScramCredentials(ctx, []alteruserscramcredentials.AlterUserScramCredentialsRequestUpsertion{upsertion},
+		[]alteruserscramcredentials.AlterUserScramCredentialsRequestDeletion{sasl.ScramDeletion(sasl.ScramSha512, "bob"), sasl.ScramDeletion(sasl.ScramSha512, "carol")})
+	if err != nil || errs["alice"] != nil || errs["bob"] != nil {
+		t.Fatalf("AlterUserScramCredentials: %v %v", errs, err)
+	}
+	var kafkaErr *errorcodes.Error
+	if !errors.As(errs["carol"], &kafkaErr) || kafkaErr.Code != errorcodes.ResourceNotFound {
+		t.Errorf("unexpected error %v", errs["carol"])
+	}
+
+	described, err := a.DescribeUserScramCredentials(ctx)
+	if err != nil || len(described) != 1 || described[0].User != "alice" || described[0].Err != nil ||
+		len(described[0].Credentials) != 2 || described[0].Credentials[0] != (sasl.ScramCredentialInfo{Mechanism: sasl.ScramSha256, Iterations: 8192}) {
+		t.Fatalf("unexpected credentials %+v (%v)", described, err)
+	}
+	described, err = a.DescribeUserScramCredentials(ctx, "alice", "bob")
+	if err != nil || len(described) != 2 || described[0].Err != nil || !errors.As(described[1].Err, &kafkaErr) || kafkaErr.Code != errorcodes.ResourceNotFound {
+		t.Fatalf("unexpected credentials %+v (%v)", described, err)
+	}
+}
+
+func TestDelegationTokens(t *testing.T) {
+	cluster, a, ctx := testAdmin(t)
+
+	// The client is authenticated as alice.
+	tokens := sasl.NewDelegationTokens([]byte("secret"))
+	alice := &sasl.Identity{Principal: "alice"}
+	cluster.Handle(messages.CreateDelegationToken, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		return tokens.CreateDelegationToken(alice, body.(*createdelegationtoken.CreateDelegationTokenRequest)), nil
+	})
+	cluster.Handle(messages.RenewDelegationToken, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		return tokens.RenewDelegationToken(alice, body.(*renewdelegationtoken.RenewDelegationTokenRequest)), nil
+	})
+	cluster.Handle(messages.ExpireDelegationToken, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		return tokens.ExpireDelegationToken(alice, body.(*expiredelegationtoken.ExpireDelegationTokenRequest)), nil
+	})
+	cluster.Handle(messages.DescribeDelegationToken, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		return tokens.DescribeDelegationToken(alice, body.(*describedelegationtoken.DescribeDelegationTokenRequest)), nil
+	})
+
+	token, err := a.CreateDelegationToken(ctx, NewDelegationToken{Renewers: []string{"User:bob"}, MaxLifetime: 48 * time.Hour})
+	if err != nil || token.Owner != "User:alice" || token.Requester != "User:alice" || !sasl.CheckDelegationTokenHmac([]byte("secret"), token.TokenId, token.Hmac) ||
+		token.ExpiryTime.Sub(token.IssueTime) != 24*time.Hour || token.MaxTime.Sub(token.IssueTime) != 48*time.Hour {
+		t.Fatalf("unexpected token %+v (%v)", token, err)
+	}
+	other, err := a.CreateDelegationToken(ctx, NewDelegationToken{Owner: "User:carol", Renewers: []string{"Group:admins"}})
+	var kafkaErr *errorcodes.Error
+	if !errors.As(err, &kafkaErr) || kafkaErr.Code != errorcodes.InvalidPrincipalType {
+		t.Errorf("unexpected token %+v (%v)", other, err)
+	}
+	if other, err = a.CreateDelegationToken(ctx, NewDelegationToken{Owner: "carol"}); err != nil || other.Owner != "User:carol" || other.Requester != "User:alice" {
+		t.Fatalf("unexpected token %+v (%v)", other, err)
+	}
+
+	if expiry, err := a.RenewDelegationToken(ctx, token.Hmac, 36*time.Hour); err != nil || expiry.Sub(token.IssueTime) < 36*time.Hour || expiry.After(token.MaxTime) {
+		t.Errorf("unexpected expiry %v (%v)", expiry, err)
+	}
+	if expiry, err := a.RenewDelegationToken(ctx, other.Hmac, -1); err != nil || expiry.Before(other.ExpiryTime) {
+		t.Errorf("unexpected expiry %v (%v) of the token created for carol", expiry, err)
+	}
+
+	described, err := a.DescribeDelegationTokens(ctx, "User:bob")
+	if err != nil || len(described) != 1 || described[0].TokenId != token.TokenId || described[0].Requester != "User:alice" ||
+		len(described[0].Renewers) != 1 || described[0].Renewers[0] != "User:bob" || described[0].Password() != token.Password() {
+		t.Fatalf("unexpected tokens %+v (%v)", described, err)
+	}
+	if described, err = a.DescribeDelegationTokens(ctx); err != nil || len(described) != 2 {
+		t.Fatalf("unexpected tokens %+v (%v)", described, err)
+	}
+
+	if _, err := a.ExpireDelegationToken(ctx, token.Hmac, -1); err != nil {
+		t.Fatalf("ExpireDelegationToken: %v", err)
+	}
+	if _, err := a.ExpireDelegationToken(ctx, token.Hmac, -1); !errors.As(err, &kafkaErr) || kafkaErr.Code != errorcodes.DelegationTokenNotFound {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/scholzj/go-kafka-protocol/api/describeconfigs"
+	"github.com/scholzj/go-kafka-protocol/api/incrementalalterconfigs"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+// Config resource types.
+const (
+	TopicConfigResource         int8 = 2
+	BrokerConfigResource        int8 = 4 // An empty name is the default configuration of all brokers.
+	BrokerLoggerConfigResource  int8 = 8
+	ClientMetricsConfigResource int8 = 16
+	GroupConfigResource         int8 = 32
+)
+
+// Operations of IncrementalAlterConfigs. Append and subtract apply to list configurations.
+const (
+	ConfigSet      int8 = 0
+	ConfigDelete   int8 = 1
+	ConfigAppend   int8 = 2
+	ConfigSubtract int8 = 3
+)
+
+// Config sources.
+const (
+	UnknownConfigSource              int8 = 0
+	DynamicTopicConfigSource         int8 = 1
+	DynamicBrokerConfigSource        int8 = 2
+	DynamicDefaultBrokerConfigSource int8 = 3
+	StaticBrokerConfigSource         int8 = 4
+	DefaultConfigSource              int8 = 5
+	DynamicBrokerLoggerConfigSource  int8 = 6
+	ClientMetricsConfigSource        int8 = 7
+	GroupConfigSource                int8 = 8
+)
+
+// ConfigResource identifies the resource of configurations, like a topic by name or a broker by node id.
+type ConfigResource struct {
+	Type int8
+	Name string
+}
+
+// ConfigEntry is a configuration of a resource. The value of sensitive configurations is nil.
+type ConfigEntry struct {
+	Name          string
+	Value         *string
+	ReadOnly      bool
+	Sensitive     bool
+	Source        int8
+	Type          int8    // Requires version 3 of DescribeConfigs, 0 otherwise.
+	Documentation *string // Only with IncludeDocumentation and version 3 of DescribeConfigs.
+	Synonyms      []ConfigSynonym
+}
+
+// ConfigSynonym is a configuration which sets the value of a configuration entry, ordered by precedence.
+type ConfigSynonym struct {
+	Name   string
+	Value  *string
+	Source int8
+}
+
+// DescribeConfigsOptions selects the configurations to describe.
+type DescribeConfigsOptions struct {
+	Keys                 []string // The configurations to describe, or nil for all of them.
+	IncludeSynonyms      bool
+	IncludeDocumentation bool
+}
+
+// ResourceConfigs are the configurations of a resource.
+type ResourceConfigs struct {
+	Resource ConfigResource
+	Configs  []ConfigEntry
+	Err      error
+}
+
+// AlterConfigOp alters one configuration of a resource. The value is ignored by ConfigDelete.
+type AlterConfigOp struct {
+	Name  string
+	Op    int8
+	Value *string
+}
+
+// DescribeConfigs describes the configurations of the resources. The configurations of a broker and its
+// loggers are described by that broker, those of other resources by any broker. The results are in the
+// order of the resources.
+func (a *Admin) DescribeConfigs(ctx context.Context, resources []ConfigResource, opts DescribeConfigsOptions) ([]ResourceConfigs, error) {
+	byNode, err := a.configNodes(ctx, resources)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[ConfigResource]ResourceConfigs, len(resources))
+	var mu sync.Mutex
+	fanOut(byNode, func(nodeId int32, resources []ConfigResource) {
+		res := &describeconfigs.DescribeConfigsResponse{}
+		err := a.send(ctx, nodeId, messages.DescribeConfigs, func(version int16) (protocol.RequestBody, error) {
+			requested := make([]describeconfigs.DescribeConfigsRequestResource, 0, len(resources))
+			for _, r := range resources {
+				resource := describeconfigs.DescribeConfigsRequestResource{ResourceType: r.Type, ResourceName: &r.Name}
+				if opts.Keys != nil {
+					keys := append([]string(nil), opts.Keys...)
+					resource.ConfigurationKeys = &keys
+				}
+				requested = append(requested, resource)
+			}
+			return &describeconfigs.DescribeConfigsRequest{
+				ApiVersion:           version,
+				Resources:            &requested,
+				IncludeSynonyms:      opts.IncludeSynonyms,
+				IncludeDocumentation: opts.IncludeDocumentation,
+			}, nil
+		}, res)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			for _, r := range resources {
+				results[r] = ResourceConfigs{Resource: r, Err: err}
+			}
+			return
+		}
+		if res.Results == nil {
+			return
+		}
+		for _, r := range *res.Results {
+			resource := ConfigResource{Type: r.ResourceType, Name: deref(r.ResourceName)}
+			result := ResourceConfigs{Resource: resource, Err: errorcodes.ToError(r.ErrorCode, r.ErrorMessage)}
+			if r.Configs != nil {
+				for _, c := range *r.Configs {
+					entry := ConfigEntry{
+						Name:          deref(c.Name),
+						Value:         c.Value,
+						ReadOnly:      c.ReadOnly,
+						Sensitive:     c.IsSensitive,
+						Source:        c.ConfigSource,
+						Type:          c.ConfigType,
+						Documentation: c.Documentation,
+					}
+					if c.Synonyms != nil {
+						for _, s := range *c.Synonyms {
+							entry.Synonyms = append(entry.Synonyms, ConfigSynonym{Name: deref(s.Name), Value: s.Value, Source: s.Source})
+						}
+					}
+					result.Configs = append(result.Configs, entry)
+				}
+			}
+			results[resource] = result
+		}
+	})
+
+	ordered := make([]ResourceConfigs, 0, len(resources))
+	for _, r := range resources {
+		result, ok := results[r]
+		if !ok {
+			result = ResourceConfigs{Resource: r, Err: fmt.Errorf("%w: %v", ErrMissingResult, r)}
+		}
+		ordered = append(ordered, result)
+	}
+	return ordered, nil
+}
+
+// IncrementalAlterConfigs alters the configurations of the resources, or only validates the changes
+// with validateOnly, and returns the error of every resource. Like DescribeConfigs, the configurations
+// of a broker and its loggers are altered by that broker.
+func (a *Admin) IncrementalAlterConfigs(ctx context.Context, alterations map[ConfigResource][]AlterConfigOp, validateOnly bool) (map[ConfigResource]error, error) {
+	resources := make([]ConfigResource, 0, len(alterations))
+	for r := range alterations {
+		resources = append(resources, r)
+	}
+	byNode, err := a.configNodes(ctx, resources)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[ConfigResource]error, len(resources))
+	for _, r := range resources {
+		results[r] = fmt.Errorf("%w: %v", ErrMissingResult, r)
+	}
+	var mu sync.Mutex
+	fanOut(byNode, func(nodeId int32, resources []ConfigResource) {
+		res := &incrementalalterconfigs.IncrementalAlterConfigsResponse{}
+		err := a.send(ctx, nodeId, messages.IncrementalAlterConfigs, func(version int16) (protocol.RequestBody, error) {
+			requested := make([]incrementalalterconfigs.IncrementalAlterConfigsRequestResource, 0, len(resources))
+			for _, r := range resources {
+				configs := make([]incrementalalterconfigs.IncrementalAlterConfigsRequestResourceConfig, 0, len(alterations[r]))
+				for _, op := range alterations[r] {
+					configs = append(configs, incrementalalterconfigs.IncrementalAlterConfigsRequestResourceConfig{Name: &op.Name, ConfigOperation: op.Op, Value: op.Value})
+				}
+				requested = append(requested, incrementalalterconfigs.IncrementalAlterConfigsRequestResource{ResourceType: r.Type, ResourceName: &r.Name, Configs: &configs})
+			}
+			return &incrementalalterconfigs.IncrementalAlterConfigsRequest{ApiVersion: version, Resources: &requested, ValidateOnly: validateOnly}, nil
+		}, res)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			for _, r := range resources {
+				results[r] = err
+			}
+			return
+		}
+		if res.Responses == nil {
+			return
+		}
+		for _, r := range *res.Responses {
+			results[ConfigResource{Type: r.ResourceType, Name: deref(r.ResourceName)}] = errorcodes.ToError(r.ErrorCode, r.ErrorMessage)
+		}
+	})
+	return results, nil
+}
+
+// configNodes groups the resources by the broker which has to handle them.
+func (a *Admin) configNodes(ctx context.Context, resources []ConfigResource) (map[int32][]ConfigResource, error) {
+	byNode := make(map[int32][]ConfigResource)
+	anyBroker := int32(-1)
+	for _, r := range resources {
+		if r.Type == BrokerLoggerConfigResource || (r.Type == BrokerConfigResource && r.Name != "") {
+			nodeId, err := strconv.ParseInt(r.Name, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %q", ErrUnknownBrokerResource, r.Name)
+			}
+			byNode[int32(nodeId)] = append(byNode[int32(nodeId)], r)
+			continue
+		}
+
+		if anyBroker < 0 {
+			var err error
+			if anyBroker, err = a.anyBroker(ctx); err != nil {
+				return nil, err
+			}
+		}
+		byNode[anyBroker] = append(byNode[anyBroker], r)
+	}
+	return byNode, nil
+}
+
+// fanOut calls send for every node concurrently and waits for all of them.
+func fanOut[T any](byNode map[int32][]T, send func(nodeId int32, items []T)) {
+	var wg sync.WaitGroup
+	for nodeId, items := range byNode {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			send(nodeId, items)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,206 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/scholzj/go-kafka-protocol/api/createdelegationtoken"
+	"github.com/scholzj/go-kafka-protocol/api/describedelegationtoken"
+	"github.com/scholzj/go-kafka-protocol/api/expiredelegationtoken"
+	"github.com/scholzj/go-kafka-protocol/api/renewdelegationtoken"
+	"github.com/scholzj/go-kafka-protocol/client"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+	"github.com/scholzj/go-kafka-protocol/sasl"
+)
+
+// NewDelegationToken is a delegation token to create.
+type NewDelegationToken struct {
+	Owner       string        // The principal of the owner, like "User:alice", or empty for the client. Requires version 3.
+	Renewers    []string      // The principals which can renew and expire the token besides the owner and the requester.
+	MaxLifetime time.Duration // Zero for the maximum lifetime of the brokers.
+}
+
+// CreateDelegationToken creates a delegation token. The client has to be authenticated, and not with a
+// delegation token. The returned token authenticates clients with its Scram method.
+func (a *Admin) CreateDelegationToken(ctx context.Context, token NewDelegationToken) (sasl.DelegationToken, error) {
+	nodeId, err := a.anyBroker(ctx)
+	if err != nil {
+		return sasl.DelegationToken{}, err
+	}
+
+	res := &createdelegationtoken.CreateDelegationTokenResponse{}
+	err = a.send(ctx, nodeId, messages.CreateDelegationToken, func(version int16) (protocol.RequestBody, error) {
+		req := &createdelegationtoken.CreateDelegationTokenRequest{ApiVersion: version, MaxLifetimeMs: -1}
+		if token.Owner != "" {
+			if version < 3 {
+				return nil, fmt.Errorf("%w: creating a token for another owner requires version 3 of CreateDelegationToken", client.ErrUnsupportedVersion)
+			}
+			ownerType, ownerName := splitPrincipal(token.Owner)
+			req.OwnerPrincipalType, req.OwnerPrincipalName = &ownerType, &ownerName
+		}
+		if token.MaxLifetime > 0 {
+			req.MaxLifetimeMs = token.MaxLifetime.Milliseconds()
+		}
+		renewers := make([]createdelegationtoken.CreateDelegationTokenRequestRenewer, 0, len(token.Renewers))
+		for _, r := range token.Renewers {
+			renewerType, renewerName := splitPrincipal(r)
+			renewers = append(renewers, createdelegationtoken.CreateDelegationTokenRequestRenewer{PrincipalType: &renewerType, PrincipalName: &renewerName})
+		}
+		req.Renewers = &renewers
+		return req, nil
+	}, res)
+	if err != nil {
+		return sasl.DelegationToken{}, err
+	}
+	if err := errorcodes.ToError(res.ErrorCode, nil); err != nil {
+		return sasl.DelegationToken{}, err
+	}
+
+	created := sasl.DelegationToken{
+		TokenId:    deref(res.TokenId),
+		Owner:      joinPrincipal(res.PrincipalType, res.PrincipalName),
+		Requester:  joinPrincipal(res.TokenRequesterPrincipalType, res.TokenRequesterPrincipalName),
+		Renewers:   token.Renewers,
+		IssueTime:  timestamp(res.IssueTimestampMs),
+		ExpiryTime: timestamp(res.ExpiryTimestampMs),
+		MaxTime:    timestamp(res.MaxTimestampMs),
+	}
+	if res.Hmac != nil {
+		created.Hmac = *res.Hmac
+	}
+	if res.ApiVersion < 3 {
+		created.Requester = created.Owner
+	}
+	return created, nil
+}
+
+// RenewDelegationToken extends the expiry of the token with the HMAC by the period, or by the default of
+// the brokers with a negative period, and returns the new expiry. Tokens are never renewed beyond their
+// maximum lifetime.
+func (a *Admin) RenewDelegationToken(ctx context.Context, hmac []byte, period time.Duration) (time.Time, error) {
+	nodeId, err := a.anyBroker(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	res := &renewdelegationtoken.RenewDelegationTokenResponse{}
+	err = a.send(ctx, nodeId, messages.RenewDelegationToken, func(version int16) (protocol.RequestBody, error) {
+		return &renewdelegationtoken.RenewDelegationTokenRequest{ApiVersion: version, Hmac: &hmac, RenewPeriodMs: periodMs(period)}, nil
+	}, res)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := errorcodes.ToError(res.ErrorCode, nil); err != nil {
+		return time.Time{}, err
+	}
+	return timestamp(res.ExpiryTimestampMs), nil
+}
+
+// ExpireDelegationToken shortens the expiry of the token with the HMAC to the period, or expires it
+// immediately with a negative period, and returns the new expiry.
+func (a *Admin) ExpireDelegationToken(ctx context.Context, hmac []byte, period time.Duration) (time.Time, error) {
+	nodeId, err := a.anyBroker(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	res := &expiredelegationtoken.ExpireDelegationTokenResponse{}
+	err = a.send(ctx, nodeId, messages.ExpireDelegationToken, func(version int16) (protocol.RequestBody, error) {
+		return &expiredelegationtoken.ExpireDelegationTokenRequest{ApiVersion: version, Hmac: &hmac, ExpiryTimePeriodMs: periodMs(period)}, nil
+	}, res)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := errorcodes.ToError(res.ErrorCode, nil); err != nil {
+		return time.Time{}, err
+	}
+	return timestamp(res.ExpiryTimestampMs), nil
+}
+
+// DescribeDelegationTokens describes the tokens owned or renewable by the owners, like "User:alice", or
+// all tokens the client may describe without owners.
+func (a *Admin) DescribeDelegationTokens(ctx context.Context, owners ...string) ([]sasl.DelegationToken, error) {
+	nodeId, err := a.anyBroker(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &describedelegationtoken.DescribeDelegationTokenResponse{}
+	err = a.send(ctx, nodeId, messages.DescribeDelegationToken, func(version int16) (protocol.RequestBody, error) {
+		req := &describedelegationtoken.DescribeDelegationTokenRequest{ApiVersion: version}
+		if len(owners) > 0 {
+			requested := make([]describedelegationtoken.DescribeDelegationTokenRequestOwner, 0, len(owners))
+			for _, o := range owners {
+				ownerType, ownerName := splitPrincipal(o)
+				requested = append(requested, describedelegationtoken.DescribeDelegationTokenRequestOwner{PrincipalType: &ownerType, PrincipalName: &ownerName})
+			}
+			req.Owners = &requested
+		}
+		return req, nil
+	}, res)
+	if err != nil {
+		return nil, err
+	}
+	if err := errorcodes.ToError(res.ErrorCode, nil); err != nil {
+		return nil, err
+	}
+
+	var tokens []sasl.DelegationToken
+	if res.Tokens != nil {
+		for _, t := range *res.Tokens {
+			token := sasl.DelegationToken{
+				TokenId:    deref(t.TokenId),
+				Owner:      joinPrincipal(t.PrincipalType, t.PrincipalName),
+				Requester:  joinPrincipal(t.TokenRequesterPrincipalType, t.TokenRequesterPrincipalName),
+				IssueTime:  timestamp(t.IssueTimestamp),
+				ExpiryTime: timestamp(t.ExpiryTimestamp),
+				MaxTime:    timestamp(t.MaxTimestamp),
+			}
+			if t.Hmac != nil {
+				token.Hmac = *t.Hmac
+			}
+			if res.ApiVersion < 3 {
+				token.Requester = token.Owner
+			}
+			if t.Renewers != nil {
+				for _, r := range *t.Renewers {
+					token.Renewers = append(token.Renewers, joinPrincipal(r.PrincipalType, r.PrincipalName))
+				}
+			}
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// splitPrincipal splits a principal into its type and name, with the type User when it has none.
+func splitPrincipal(principal string) (string, string) {
+	if principalType, name, ok := strings.Cut(principal, ":"); ok {
+		return principalType, name
+	}
+	return "User", principal
+}
+
+func joinPrincipal(principalType *string, name *string) string {
+	return deref(principalType) + ":" + deref(name)
+}
+
+// periodMs returns the period in milliseconds, or -1 for a negative period.
+func periodMs(period time.Duration) int64 {
+	if period < 0 {
+		return -1
+	}
+	return period.Milliseconds()
+}
+
+// timestamp returns the time of a timestamp in milliseconds, or the zero time for -1.
+func timestamp(ms int64) time.Time {
+	if ms < 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
@@ -0,0 +1,342 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/scholzj/go-kafka-protocol/api/alterpartitionreassignments"
+	"github.com/scholzj/go-kafka-protocol/api/describelogdirs"
+	"github.com/scholzj/go-kafka-protocol/api/electleaders"
+	"github.com/scholzj/go-kafka-protocol/api/listpartitionreassignments"
+	"github.com/scholzj/go-kafka-protocol/client"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+// Election types of ElectLeaders.
+const (
+	PreferredElection int8 = 0
+	UncleanElection   int8 = 1
+)
+
+// Reassignment is an ongoing reassignment of a partition.
+type Reassignment struct {
+	Replicas         []int32
+	AddingReplicas   []int32
+	RemovingReplicas []int32
+}
+
+// LogDir is a log directory of a broker with the replicas it holds. TotalBytes and UsableBytes are -1
+// for brokers older than version 4 of DescribeLogDirs.
+type LogDir struct {
+	Path        string
+	TotalBytes  int64
+	UsableBytes int64
+	Cordoned    bool
+	Replicas    []ReplicaInfo
+	Err         error
+}
+
+// ReplicaInfo describes the log of a replica in a log directory. A future log is the target of a move
+// between log directories.
+type ReplicaInfo struct {
+	TopicPartition
+	Size      int64
+	OffsetLag int64
+	Future    bool
+}
+
+// BrokerLogDirs are the log directories of a broker.
+type BrokerLogDirs struct {
+	NodeId  int32
+	LogDirs []LogDir
+	Err     error
+}
+
+////////////////////
+// Leader elections
+////////////////////
+
+// ElectLeaders elects the leaders of the partitions, or of all partitions when partitions is nil, and
+// returns the error of every partition. Brokers older than version 1 only support PreferredElection.
+func (a *Admin) ElectLeaders(ctx context.Context, electionType int8, partitions []TopicPartition) (map[TopicPartition]error, error) {
+	build := func(version int16) (protocol.RequestBody, error) {
+		if version < 1 && electionType != PreferredElection {
+			return nil, fmt.Errorf("%w: election type %d requires version 1 of ElectLeaders", client.ErrUnsupportedVersion, electionType)
+		}
+		req := &electleaders.ElectLeadersRequest{ApiVersion: version, ElectionType: electionType, TimeoutMs: a.timeoutMs()}
+		if partitions != nil {
+			topics, byTopic := groupByTopic(partitions)
+			requested := make([]electleaders.ElectLeadersRequestTopicPartition, 0, len(topics))
+			for _, topic := range topics {
+				indexes := byTopic[topic]
+				requested = append(requested, electleaders.ElectLeadersRequestTopicPartition{Topic: &topic, Partitions: &indexes})
+			}
+			req.TopicPartitions = &requested
+		}
+		return req, nil
+	}
+
+	res := &electleaders.ElectLeadersResponse{}
+	notController := func() bool {
+		if res.ErrorCode == errorcodes.NotController {
+			return true
+		}
+		var codes []int16
+		if res.ReplicaElectionResults != nil {
+			for _, t := range *res.ReplicaElectionResults {
+				if t.PartitionResult != nil {
+					for _, p := range *t.PartitionResult {
+						codes = append(codes, p.ErrorCode)
+					}
+				}
+			}
+		}
+		return allErrors(len(codes), func(i int) int16 { return codes[i] }, errorcodes.NotController)
+	}
+	if err := a.sendToController(ctx, messages.ElectLeaders, build, res, notController); err != nil {
+		return nil, err
+	}
+	if res.ApiVersion >= 1 {
+		if err := errorcodes.ToError(res.ErrorCode, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make(map[TopicPartition]error, len(partitions))
+	for _, tp := range partitions {
+		results[tp] = fmt.Errorf("%w: %v", ErrMissingResult, tp)
+	}
+	if res.ReplicaElectionResults != nil {
+		for _, t := range *res.ReplicaElectionResults {
+			if t.PartitionResult == nil {
+				continue
+			}
+			for _, p := range *t.PartitionResult {
+				results[TopicPartition{Topic: deref(t.Topic), Partition: p.PartitionId}] = errorcodes.ToError(p.ErrorCode, p.ErrorMessage)
+			}
+		}
+	}
+	return results, nil
+}
+
+////////////////////
+// Reassignments
+////////////////////
+
+// AlterPartitionReassignments starts reassigning the partitions to their replicas, or cancels the
+// ongoing reassignment of a partition with nil replicas, and returns the error of every partition.
+// Brokers older than version 1 always allow a change of the replication factor, so disallowing it
+// requires version 1.
+func (a *Admin) AlterPartitionReassignments(ctx context.Context, reassignments map[TopicPartition][]int32, allowReplicationFactorChange bool) (map[TopicPartition]error, error) {
+	build := func(version int16) (protocol.RequestBody, error) {
+		if version < 1 && !allowReplicationFactorChange {
+			return nil, fmt.Errorf("%w: disallowing replication factor changes requires version 1 of AlterPartitionReassignments", client.ErrUnsupportedVersion)
+		}
+
+		partitions := make([]TopicPartition, 0, len(reassignments))
+		for tp := range reassignments {
+			partitions = append(partitions, tp)
+		}
+		topics, byTopic := groupByTopic(partitions)
+		requested := make([]alterpartitionreassignments.AlterPartitionReassignmentsRequestTopic, 0, len(topics))
+		for _, topic := range topics {
+			reassigned := make([]alterpartitionreassignments.AlterPartitionReassignmentsRequestTopicPartition, 0, len(byTopic[topic]))
+			for _, index := range byTopic[topic] {
+				p := alterpartitionreassignments.AlterPartitionReassignmentsRequestTopicPartition{PartitionIndex: index}
+				if replicas := reassignments[TopicPartition{Topic: topic, Partition: index}]; replicas != nil {
+					replicas = append([]int32(nil), replicas...)
+					p.Replicas = &replicas
+				}
+				reassigned = append(reassigned, p)
+			}
+			requested = append(requested, alterpartitionreassignments.AlterPartitionReassignmentsRequestTopic{Name: &topic, Partitions: &reassigned})
+		}
+		return &alterpartitionreassignments.AlterPartitionReassignmentsRequest{
+			ApiVersion:                   version,
+			TimeoutMs:                    a.timeoutMs(),
+			AllowReplicationFactorChange: allowReplicationFactorChange,
+			Topics:                       &requested,
+		}, nil
+	}
+
+	res := &alterpartitionreassignments.AlterPartitionReassignmentsResponse{}
+	notController := func() bool { return res.ErrorCode == errorcodes.NotController }
+	if err := a.sendToController(ctx, messages.AlterPartitionReassignments, build, res, notController); err != nil {
+		return nil, err
+	}
+	if err := errorcodes.ToError(res.ErrorCode, res.ErrorMessage); err != nil {
+		return nil, err
+	}
+
+	results := make(map[TopicPartition]error, len(reassignments))
+	for tp := range reassignments {
+		results[tp] = fmt.Errorf("%w: %v", ErrMissingResult, tp)
+	}
+	if res.Responses != nil {
+		for _, t := range *res.Responses {
+			if t.Partitions == nil {
+				continue
+			}
+			for _, p := range *t.Partitions {
+				results[TopicPartition{Topic: deref(t.Name), Partition: p.PartitionIndex}] = errorcodes.ToError(p.ErrorCode, p.ErrorMessage)
+			}
+		}
+	}
+	return results, nil
+}
+
+// ListPartitionReassignments returns the ongoing reassignments of the partitions, or of all partitions
+// when partitions is nil. Partitions without an ongoing reassignment are not returned.
+func (a *Admin) ListPartitionReassignments(ctx context.Context, partitions []TopicPartition) (map[TopicPartition]Reassignment, error) {
+	build := func(version int16) (protocol.RequestBody, error) {
+		req := &listpartitionreassignments.ListPartitionReassignmentsRequest{ApiVersion: version, TimeoutMs: a.timeoutMs()}
+		if partitions != nil {
+			topics, byTopic := groupByTopic(partitions)
+			requested := make([]listpartitionreassignments.ListPartitionReassignmentsRequestTopic, 0, len(topics))
+			for _, topic := range topics {
+				indexes := byTopic[topic]
+				requested = append(requested, listpartitionreassignments.ListPartitionReassignmentsRequestTopic{Name: &topic, PartitionIndexes: &indexes})
+			}
+			req.Topics = &requested
+		}
+		return req, nil
+	}
+
+	res := &listpartitionreassignments.ListPartitionReassignmentsResponse{}
+	notController := func() bool { return res.ErrorCode == errorcodes.NotController }
+	if err := a.sendToController(ctx, messages.ListPartitionReassignments, build, res, notController); err != nil {
+		return nil, err
+	}
+	if err := errorcodes.ToError(res.ErrorCode, res.ErrorMessage); err != nil {
+		return nil, err
+	}
+
+	reassignments := make(map[TopicPartition]Reassignment)
+	if res.Topics == nil {
+		return reassignments, nil
+	}
+	for _, t := range *res.Topics {
+		if t.Partitions == nil {
+			continue
+		}
+		for _, p := range *t.Partitions {
+			reassignments[TopicPartition{Topic: deref(t.Name), Partition: p.PartitionIndex}] = Reassignment{
+				Replicas:         derefSlice(p.Replicas),
+				AddingReplicas:   derefSlice(p.AddingReplicas),
+				RemovingReplicas: derefSlice(p.RemovingReplicas),
+			}
+		}
+	}
+	return reassignments, nil
+}
+
+////////////////////
+// Log directories
+////////////////////
+
+// DescribeLogDirs describes the log directories of the brokers, or of all brokers when brokers is nil,
+// with the replicas of the partitions, or of all partitions when partitions is nil. Every broker is
+// asked concurrently, and the results are ordered by node id.
+func (a *Admin) DescribeLogDirs(ctx context.Context, brokers []int32, partitions []TopicPartition) ([]BrokerLogDirs, error) {
+	if brokers == nil {
+		if err := a.refreshBrokers(ctx); err != nil {
+			return nil, err
+		}
+		for _, b := range a.Client.Cache.Brokers() {
+			brokers = append(brokers, b.NodeId)
+		}
+	}
+
+	byNode := make(map[int32][]struct{}, len(brokers))
+	for _, nodeId := range brokers {
+		byNode[nodeId] = nil
+	}
+	var mu sync.Mutex
+	results := make([]BrokerLogDirs, 0, len(brokers))
+	fanOut(byNode, func(nodeId int32, _ []struct{}) {
+		result := a.describeLogDirs(ctx, nodeId, partitions)
+
+		mu.Lock()
+		defer mu.Unlock()
+		results = append(results, result)
+	})
+	sort.Slice(results, func(i, j int) bool { return results[i].NodeId < results[j].NodeId })
+	return results, nil
+}
+
+func (a *Admin) describeLogDirs(ctx context.Context, nodeId int32, partitions []TopicPartition) BrokerLogDirs {
+	res := &describelogdirs.DescribeLogDirsResponse{}
+	err := a.send(ctx, nodeId, messages.DescribeLogDirs, func(version int16) (protocol.RequestBody, error) {
+		req := &describelogdirs.DescribeLogDirsRequest{ApiVersion: version}
+		if partitions != nil {
+			topics, byTopic := groupByTopic(partitions)
+			requested := make([]describelogdirs.DescribeLogDirsRequestTopic, 0, len(topics))
+			for _, topic := range topics {
+				indexes := byTopic[topic]
+				requested = append(requested, describelogdirs.DescribeLogDirsRequestTopic{Topic: &topic, Partitions: &indexes})
+			}
+			req.Topics = &requested
+		}
+		return req, nil
+	}, res)
+	if err != nil {
+		return BrokerLogDirs{NodeId: nodeId, Err: err}
+	}
+	if res.ApiVersion >= 3 {
+		if err := errorcodes.ToError(res.ErrorCode, nil); err != nil {
+			return BrokerLogDirs{NodeId: nodeId, Err: err}
+		}
+	}
+
+	result := BrokerLogDirs{NodeId: nodeId}
+	if res.Results == nil {
+		return result
+	}
+	for _, r := range *res.Results {
+		dir := LogDir{Path: deref(r.LogDir), TotalBytes: -1, UsableBytes: -1, Cordoned: r.IsCordoned, Err: errorcodes.ToError(r.ErrorCode, nil)}
+		if res.ApiVersion >= 4 {
+			dir.TotalBytes, dir.UsableBytes = r.TotalBytes, r.UsableBytes
+		}
+		if r.Topics != nil {
+			for _, t := range *r.Topics {
+				if t.Partitions == nil {
+					continue
+				}
+				for _, p := range *t.Partitions {
+					dir.Replicas = append(dir.Replicas, ReplicaInfo{
+						TopicPartition: TopicPartition{Topic: deref(t.Name), Partition: p.PartitionIndex},
+						Size:           p.PartitionSize,
+						OffsetLag:      p.OffsetLag,
+						Future:         p.IsFutureKey,
+					})
+				}
+			}
+		}
+		result.LogDirs = append(result.LogDirs, dir)
+	}
+	return result
+}
+
+// groupByTopic returns the topics of the partitions in alphabetical order and their sorted partition
+// indexes.
+func groupByTopic(partitions []TopicPartition) ([]string, map[string][]int32) {
+	byTopic := make(map[string][]int32)
+	for _, tp := range partitions {
+		byTopic[tp.Topic] = append(byTopic[tp.Topic], tp.Partition)
+	}
+	for _, indexes := range byTopic {
+		sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	}
+	return sortedKeys(byTopic), byTopic
+}
+
+func derefSlice(s *[]int32) []int32 {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
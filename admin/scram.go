@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/scholzj/go-kafka-protocol/api/alteruserscramcredentials"
+	"github.com/scholzj/go-kafka-protocol/api/describeuserscramcredentials"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+	"github.com/scholzj/go-kafka-protocol/sasl"
+)
+
+// UserScramCredentials are the SCRAM credentials of a user.
+type UserScramCredentials struct {
+	User        string
+	Credentials []sasl.ScramCredentialInfo
+	Err         error
+}
+
+// UpsertScramPasswords sets the passwords of the users for the mechanism, salted by the client with
+// sasl.NewScramUpsertion and the default iterations, and returns the error of every user.
+func (a *Admin) UpsertScramPasswords(ctx context.Context, mechanism sasl.ScramMechanism, passwords map[string]string) (map[string]error, error) {
+	upsertions := make([]alteruserscramcredentials.AlterUserScramCredentialsRequestUpsertion, 0, len(passwords))
+	for _, user := range sortedKeys(passwords) {
+		upsertion, err := sasl.NewScramUpsertion(mechanism, user, passwords[user], 0)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", user, err)
+		}
+		upsertions = append(upsertions, upsertion)
+	}
+	return a.AlterUserScramCredentials(ctx, upsertions, nil)
+}
+
+// AlterUserScramCredentials applies the upsertions, built with sasl.NewScramUpsertion, and the
+// deletions, built with sasl.ScramDeletion, and returns the error of every user.
+func (a *Admin) AlterUserScramCredentials(ctx context.Context, upsertions []alteruserscramcredentials.AlterUserScramCredentialsRequestUpsertion, deletions []alteruserscramcredentials.AlterUserScramCredentialsRequestDeletion) (map[string]error, error) {
+	nodeId, err := a.anyBroker(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &alteruserscramcredentials.AlterUserScramCredentialsResponse{}
+	err = a.send(ctx, nodeId, messages.AlterUserScramCredentials, func(version int16) (protocol.RequestBody, error) {
+		return &alteruserscramcredentials.AlterUserScramCredentialsRequest{ApiVersion: version, Upsertions: &upsertions, Deletions: &deletions}, nil
+	}, res)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]error)
+	for _, u := range upsertions {
+		results[deref(u.Name)] = fmt.Errorf("%w: %s", ErrMissingResult, deref(u.Name))
+	}
+	for _, d := range deletions {
+		results[deref(d.Name)] = fmt.Errorf("%w: %s", ErrMissingResult, deref(d.Name))
+	}
+	if res.Results != nil {
+		for _, r := range *res.Results {
+			results[deref(r.User)] = errorcodes.ToError(r.ErrorCode, r.ErrorMessage)
+		}
+	}
+	return results, nil
+}
+
+// DescribeUserScramCredentials describes the SCRAM credentials of the users, or of all users without
+// users. Users without credentials are returned with an error.
+func (a *Admin) DescribeUserScramCredentials(ctx context.Context, users ...string) ([]UserScramCredentials, error) {
+	nodeId, err := a.anyBroker(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &describeuserscramcredentials.DescribeUserScramCredentialsResponse{}
+	err = a.send(ctx, nodeId, messages.DescribeUserScramCredentials, func(version int16) (protocol.RequestBody, error) {
+		req := &describeuserscramcredentials.DescribeUserScramCredentialsRequest{ApiVersion: version}
+		if len(users) > 0 {
+			requested := make([]describeuserscramcredentials.DescribeUserScramCredentialsRequestUser, 0, len(users))
+			for _, user := range users {
+				requested = append(requested, describeuserscramcredentials.DescribeUserScramCredentialsRequestUser{Name: &user})
+			}
+			req.Users = &requested
+		}
+		return req, nil
+	}, res)
+	if err != nil {
+		return nil, err
+	}
+	infos, errs, err := sasl.ScramCredentialInfos(res)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []UserScramCredentials
+	if res.Results != nil {
+		for _, r := range *res.Results {
+			user := deref(r.User)
+			results = append(results, UserScramCredentials{User: user, Credentials: infos[user], Err: errs[user]})
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,343 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/scholzj/go-kafka-protocol/api/findcoordinator"
+	"github.com/scholzj/go-kafka-protocol/api/metadata"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/metadatacache"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+	"github.com/scholzj/go-kafka-protocol/sasl"
+)
+
+// A small client which bootstraps from seed brokers, negotiates the API versions with every broker,
+// keeps the cluster metadata in a metadatacache.Cache and routes requests to the leaders of partitions
+// and to the group and transaction coordinators. It sends one request at a time per broker connection.
+
+const (
+	DefaultClientId        = "go-kafka-protocol"
+	DefaultSoftwareName    = "go-kafka-protocol"
+	DefaultSoftwareVersion = "0.0.0"
+	DefaultDialTimeout     = 10 * time.Second
+)
+
+// Coordinator key types of FindCoordinator.
+const (
+	GroupCoordinator       int8 = 0
+	TransactionCoordinator int8 = 1
+	ShareCoordinator       int8 = 2
+)
+
+// AnyBroker can be passed as node id to send a request to any broker.
+const AnyBroker int32 = -1
+
+var (
+	ErrNoBrokers          = errors.New("none of the brokers could be reached")
+	ErrUnknownBroker      = errors.New("the address of the broker is not known")
+	ErrUnsupportedVersion = errors.New("the broker does not support a version of the API known to the client")
+	ErrConnectionClosed   = errors.New("the connection is closed")
+	ErrClientClosed       = errors.New("the client is closed")
+)
+
+// Client is safe for concurrent use.
+type Client struct {
+	SeedBrokers     []string // host:port addresses used to bootstrap.
+	ClientId        string
+	SoftwareName    string // Sent with ApiVersions v3+ (KIP-511).
+	SoftwareVersion string
+	Dial            func(ctx context.Context, network string, address string) (net.Conn, error)
+	SASL            sasl.Mechanism // Authenticates every connection when set, see sasl.Authenticate.
+	Cache           *metadatacache.Cache
+
+	mu           sync.Mutex
+	conns        map[int32]*conn // Seed brokers use negative ids starting at -2.
+	coordinators map[coordinatorKey]metadatacache.Broker
+	closed       bool
+}
+
+type coordinatorKey struct {
+	keyType int8
+	key     string
+}
+
+func NewClient(seedBrokers ...string) *Client {
+	dialer := &net.Dialer{Timeout: DefaultDialTimeout}
+	return &Client{
+		SeedBrokers:     seedBrokers,
+		ClientId:        DefaultClientId,
+		SoftwareName:    DefaultSoftwareName,
+		SoftwareVersion: DefaultSoftwareVersion,
+		Dial:            dialer.DialContext,
+		Cache:           metadatacache.NewCache(),
+		conns:           make(map[int32]*conn),
+		coordinators:    make(map[coordinatorKey]metadatacache.Broker),
+	}
+}
+
+// Close closes all connections.
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for id, cn := range c.conns {
+		cn.close()
+		delete(c.conns, id)
+	}
+}
+
+////////////////////
+// Connections
+////////////////////
+
+// connection returns an open connection to the broker, or to any broker for AnyBroker.
+func (c *Client) connection(ctx context.Context, nodeId int32) (*conn, error) {
+	if nodeId == AnyBroker {
+		return c.anyConnection(ctx)
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClientClosed
+	}
+	if cn, ok := c.conns[nodeId]; ok && !cn.isBroken() {
+		c.mu.Unlock()
+		return cn, nil
+	}
+	address, ok := c.address(nodeId)
+	c.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownBroker, nodeId)
+	}
+
+	return c.open(ctx, nodeId, address)
+}
+
+// address returns the address of a broker from the metadata or from a coordinator lookup.
+func (c *Client) address(nodeId int32) (string, bool) {
+	if b, ok := c.Cache.Broker(nodeId); ok {
+		return b.Address(), true
+	}
+	for _, b := range c.coordinators {
+		if b.NodeId == nodeId {
+			return b.Address(), true
+		}
+	}
+	return "", false
+}
+
+// open dials the broker and keeps the connection, unless another connection was opened concurrently.
+func (c *Client) open(ctx context.Context, nodeId int32, address string) (*conn, error) {
+	cn, err := c.dial(ctx, nodeId, address)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		cn.close()
+		return nil, ErrClientClosed
+	}
+	if existing, ok := c.conns[nodeId]; ok && !existing.isBroken() {
+		cn.close()
+		return existing, nil
+	}
+	c.conns[nodeId] = cn
+	return cn, nil
+}
+
+// anyConnection returns an open connection, or connects to a known broker or, when none of them can be
+// reached, to a seed broker.
+func (c *Client) anyConnection(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClientClosed
+	}
+	for _, cn := range c.conns {
+		if !cn.isBroken() {
+			c.mu.Unlock()
+			return cn, nil
+		}
+	}
+	c.mu.Unlock()
+
+	var errs []error
+	for _, b := range c.Cache.Brokers() {
+		cn, err := c.open(ctx, b.NodeId, b.Address())
+		if err == nil {
+			return cn, nil
+		}
+		errs = append(errs, err)
+	}
+	for i, address := range c.SeedBrokers {
+		cn, err := c.open(ctx, int32(-2-i), address)
+		if err == nil {
+			return cn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("%w: %w", ErrNoBrokers, errors.Join(errs...))
+}
+
+// Version returns the highest version of the API supported by the client and the broker.
+func (c *Client) Version(ctx context.Context, nodeId int32, apiKey int16) (int16, error) {
+	cn, err := c.connection(ctx, nodeId)
+	if err != nil {
+		return -1, err
+	}
+	return cn.version(apiKey, -1)
+}
+
+// Send sends the request body as it is, with its ApiVersion, and decodes the response into res. A nil
+// res sends a request which gets no response, like a Produce request with Acks 0.
+func (c *Client) Send(ctx context.Context, nodeId int32, apiKey int16, version int16, req protocol.RequestBody, res protocol.ResponseBody) error {
+	cn, err := c.connection(ctx, nodeId)
+	if err != nil {
+		return err
+	}
+	return cn.roundTrip(ctx, apiKey, version, req, res)
+}
+
+// call sends the request with the highest version supported by both sides, limited to maxVersion when
+// it is not negative. The version is stored in the ApiVersion field of the request.
+func (c *Client) call(ctx context.Context, nodeId int32, apiKey int16, maxVersion int16, version *int16, req protocol.RequestBody, res protocol.ResponseBody) error {
+	cn, err := c.connection(ctx, nodeId)
+	if err != nil {
+		return err
+	}
+	*version, err = cn.version(apiKey, maxVersion)
+	if err != nil {
+		return err
+	}
+	return cn.roundTrip(ctx, apiKey, *version, req, res)
+}
+
+////////////////////
+// Metadata
+////////////////////
+
+// Metadata sends the Metadata request to any broker and updates the metadata cache with the response.
+func (c *Client) Metadata(ctx context.Context, req *metadata.MetadataRequest) (*metadata.MetadataResponse, error) {
+	res := &metadata.MetadataResponse{}
+	if err := c.call(ctx, AnyBroker, messages.Metadata, -1, &req.ApiVersion, req, res); err != nil {
+		return nil, err
+	}
+	c.Cache.UpdateMetadata(res)
+	return res, nil
+}
+
+// RefreshMetadata refreshes the metadata of the topics, or of all topics when none are given.
+func (c *Client) RefreshMetadata(ctx context.Context, topics ...string) error {
+	req := &metadata.MetadataRequest{}
+	if len(topics) > 0 {
+		requested := make([]metadata.MetadataRequestTopic, 0, len(topics))
+		for _, topic := range topics {
+			requested = append(requested, metadata.MetadataRequestTopic{Name: &topic})
+		}
+		req.Topics = &requested
+	}
+	_, err := c.Metadata(ctx, req)
+	return err
+}
+
+// Leader returns the node id of the leader of the partition, refreshing the metadata of the topic when
+// the leader is not known.
+func (c *Client) Leader(ctx context.Context, topic string, partition int32) (int32, error) {
+	tp := metadatacache.TopicPartition{Topic: topic, Partition: partition}
+	if b, _, err := c.Cache.Leader(tp); err == nil {
+		return b.NodeId, nil
+	}
+
+	if err := c.RefreshMetadata(ctx, topic); err != nil {
+		return -1, err
+	}
+	b, _, err := c.Cache.Leader(tp)
+	if err != nil {
+		return -1, err
+	}
+	return b.NodeId, nil
+}
+
+////////////////////
+// Coordinators
+////////////////////
+
+// FindCoordinator returns the coordinator of the group (GroupCoordinator), transactional id
+// (TransactionCoordinator) or share partition (ShareCoordinator). The coordinator is cached until a
+// request to it fails with NOT_COORDINATOR or COORDINATOR_NOT_AVAILABLE.
+func (c *Client) FindCoordinator(ctx context.Context, keyType int8, key string) (metadatacache.Broker, error) {
+	ck := coordinatorKey{keyType, key}
+	c.mu.Lock()
+	b, ok := c.coordinators[ck]
+	c.mu.Unlock()
+	if ok {
+		return b, nil
+	}
+
+	req := &findcoordinator.FindCoordinatorRequest{Key: &key, KeyType: keyType, CoordinatorKeys: &[]string{key}}
+	res := &findcoordinator.FindCoordinatorResponse{}
+	if err := c.call(ctx, AnyBroker, messages.FindCoordinator, -1, &req.ApiVersion, req, res); err != nil {
+		return metadatacache.Broker{}, err
+	}
+
+	errorCode, errorMessage := res.ErrorCode, res.ErrorMessage
+	b = metadatacache.Broker{NodeId: res.NodeId, Host: deref(res.Host), Port: res.Port}
+	if res.ApiVersion >= 4 {
+		if res.Coordinators == nil || len(*res.Coordinators) != 1 {
+			return metadatacache.Broker{}, fmt.Errorf("unexpected FindCoordinator response for %s", key)
+		}
+		coordinator := (*res.Coordinators)[0]
+		errorCode, errorMessage = coordinator.ErrorCode, coordinator.ErrorMessage
+		b = metadatacache.Broker{NodeId: coordinator.NodeId, Host: deref(coordinator.Host), Port: coordinator.Port}
+	}
+	if errorCode != errorcodes.None {
+		return metadatacache.Broker{}, errorcodes.ToError(errorCode, errorMessage)
+	}
+
+	c.mu.Lock()
+	c.coordinators[ck] = b
+	c.mu.Unlock()
+	return b, nil
+}
+
+// coordinatorCall sends the request to the coordinator of the key. The cached coordinator is forgotten
+// when the request fails or errorCode returns NOT_COORDINATOR or COORDINATOR_NOT_AVAILABLE, so that the
+// next request looks it up again.
+func (c *Client) coordinatorCall(ctx context.Context, keyType int8, key string, apiKey int16, maxVersion int16, version *int16, req protocol.RequestBody, res protocol.ResponseBody, errorCode func() int16) error {
+	coordinator, err := c.FindCoordinator(ctx, keyType, key)
+	if err != nil {
+		return err
+	}
+
+	err = c.call(ctx, coordinator.NodeId, apiKey, maxVersion, version, req, res)
+	if err != nil || (errorCode != nil && isCoordinatorError(errorCode())) {
+		c.mu.Lock()
+		delete(c.coordinators, coordinatorKey{keyType, key})
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// isCoordinatorError reports whether the error code makes the client look up the coordinator again.
+func isCoordinatorError(code int16) bool {
+	return code == errorcodes.NotCoordinator || code == errorcodes.CoordinatorNotAvailable
+}
+
+func deref[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
@@ -0,0 +1,367 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scholzj/go-kafka-protocol/api/fetch"
+	"github.com/scholzj/go-kafka-protocol/api/heartbeat"
+	"github.com/scholzj/go-kafka-protocol/api/joingroup"
+	"github.com/scholzj/go-kafka-protocol/api/listoffsets"
+	"github.com/scholzj/go-kafka-protocol/api/metadata"
+	"github.com/scholzj/go-kafka-protocol/api/offsetcommit"
+	"github.com/scholzj/go-kafka-protocol/api/produce"
+	"github.com/scholzj/go-kafka-protocol/api/saslauthenticate"
+	"github.com/scholzj/go-kafka-protocol/api/saslhandshake"
+	"github.com/scholzj/go-kafka-protocol/compression"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/metadatacache"
+	"github.com/scholzj/go-kafka-protocol/mockbroker"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+	"github.com/scholzj/go-kafka-protocol/records"
+	"github.com/scholzj/go-kafka-protocol/sasl"
+)
+
+func str(s string) *string {
+	return &s
+}
+
+// testCluster starts a cluster of three brokers with the topic orders and a client bootstrapping from
+// the first broker.
+func testCluster(t *testing.T) (*mockbroker.Cluster, *Client) {
+	t.Helper()
+
+	cluster, err := mockbroker.NewCluster(3)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	if _, err := cluster.CreateTopic("orders", 3, 2); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	c := NewClient(cluster.Addresses()[0])
+	t.Cleanup(func() {
+		c.Close()
+		cluster.Close()
+	})
+	return cluster, c
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// produceRequest builds a request with the name and the id of the topic, for every version.
+func produceRequest(c *Client, topic string, partition int32, values ...string) *produce.ProduceRequest {
+	batch := records.NewRecordBatch(0, compression.None)
+	for _, value := range values {
+		batch.AppendRecord(1700000000000, nil, []byte(value), nil)
+	}
+	data, _ := records.WriteRecordBatches([]*records.RecordBatch{batch})
+	topicId, _ := c.Cache.TopicId(topic)
+	return &produce.ProduceRequest{
+		Acks:      -1,
+		TimeoutMs: 30000,
+		TopicData: &[]produce.ProduceRequestTopicData{{
+			Name:          &topic,
+			TopicId:       topicId,
+			PartitionData: &[]produce.ProduceRequestTopicDataPartitionData{{Index: partition, Records: &data}},
+		}},
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	_, c := testCluster(t)
+	ctx := testContext(t)
+
+	if err := c.RefreshMetadata(ctx); err != nil {
+		t.Fatalf("RefreshMetadata: %v", err)
+	}
+	if len(c.Cache.Brokers()) != 3 || c.Cache.NumPartitions("orders") != 3 {
+		t.Fatalf("unexpected metadata: brokers %v, topics %v", c.Cache.Brokers(), c.Cache.Topics())
+	}
+	if id := c.Cache.ClusterId(); id == nil || *id != mockbroker.DefaultClusterId {
+		t.Errorf("unexpected cluster id %v", id)
+	}
+
+	leader, err := c.Leader(ctx, "orders", 1)
+	if err != nil || leader != 1 {
+		t.Errorf("unexpected leader %d (%v)", leader, err)
+	}
+	if _, err := c.Leader(ctx, "payments", 0); !errors.Is(err, metadatacache.ErrUnknownTopic) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	res, err := c.Metadata(ctx, &metadata.MetadataRequest{Topics: &[]metadata.MetadataRequestTopic{{Name: str("payments")}}})
+	if err != nil || res.ApiVersion != 13 || (*res.Topics)[0].ErrorCode != errorcodes.UnknownTopicOrPartition {
+		t.Errorf("unexpected response %+v (%v)", res, err)
+	}
+}
+
+func TestVersionNegotiation(t *testing.T) {
+	cluster, c := testCluster(t)
+	ctx := testContext(t)
+	cluster.SetMaxVersion(messages.ApiVersions, 2)
+	cluster.SetMaxVersion(messages.Metadata, 8)
+
+	if version, err := c.Version(ctx, AnyBroker, messages.Metadata); err != nil || version != 8 {
+		t.Fatalf("unexpected version %d (%v)", version, err)
+	}
+	res, err := c.Metadata(ctx, &metadata.MetadataRequest{})
+	if err != nil || res.ApiVersion != 8 || len(*res.Topics) != 1 {
+		t.Fatalf("unexpected response %+v (%v)", res, err)
+	}
+
+	cluster.SetMaxVersion(messages.Fetch, 3)
+	if _, err := c.Version(ctx, 2, messages.Fetch); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestProduceAndFetch(t *testing.T) {
+	cluster, c := testCluster(t)
+	ctx := testContext(t)
+
+	leader, err := c.Leader(ctx, "orders", 1)
+	if err != nil {
+		t.Fatalf("Leader: %v", err)
+	}
+	for i, values := range [][]string{{"a", "b"}, {"c"}} {
+		res, err := c.Produce(ctx, leader, produceRequest(c, "orders", 1, values...))
+		if err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+		p := (*(*res.Responses)[0].PartitionResponses)[0]
+		if p.ErrorCode != errorcodes.None || p.BaseOffset != int64(2*i) {
+			t.Fatalf("unexpected partition response %+v", p)
+		}
+	}
+
+	// Without acknowledgements, the request gets no response.
+	req := produceRequest(c, "orders", 1, "d")
+	req.Acks = 0
+	if res, err := c.Produce(ctx, leader, req); err != nil || res != nil {
+		t.Fatalf("unexpected response %+v (%v)", res, err)
+	}
+
+	offsets, err := c.ListOffsets(ctx, leader, &listoffsets.ListOffsetsRequest{ReplicaId: -1, Topics: &[]listoffsets.ListOffsetsRequestTopic{{
+		Name:       str("orders"),
+		Partitions: &[]listoffsets.ListOffsetsRequestTopicPartition{{PartitionIndex: 1, CurrentLeaderEpoch: -1, Timestamp: mockbroker.LatestTimestamp}},
+	}}})
+	if err != nil || (*(*offsets.Topics)[0].Partitions)[0].Offset != 4 {
+		t.Fatalf("unexpected offsets %+v (%v)", offsets, err)
+	}
+
+	topicId, _ := c.Cache.TopicId("orders")
+	res, err := c.Fetch(ctx, leader, &fetch.FetchRequest{ReplicaId: -1, MaxBytes: 1 << 20, SessionEpoch: -1, Topics: &[]fetch.FetchRequestTopic{{
+		Topic:      str("orders"),
+		TopicId:    topicId,
+		Partitions: &[]fetch.FetchRequestTopicPartition{{Partition: 1, CurrentLeaderEpoch: -1, FetchOffset: 2, LastFetchedEpoch: -1, LogStartOffset: -1, PartitionMaxBytes: 1 << 20}},
+	}}, ForgottenTopicsData: &[]fetch.FetchRequestForgottenTopicsData{}, RackId: str("")})
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	p := (*(*res.Responses)[0].Partitions)[0]
+	batches, err := records.ReadRecordBatches(*p.Records)
+	if err != nil || len(batches) != 2 || batches[0].BaseOffset != 2 || string(batches[1].Records[0].Value) != "d" || p.HighWatermark != 4 {
+		t.Fatalf("unexpected fetch response %+v: %+v (%v)", p, batches, err)
+	}
+
+	// The leader hint of a rejected batch moves the partition in the cache.
+	if err := cluster.SetLeader("orders", 1, 2); err != nil {
+		t.Fatalf("SetLeader: %v", err)
+	}
+	produced, err := c.Produce(ctx, leader, produceRequest(c, "orders", 1, "e"))
+	if err != nil || (*(*produced.Responses)[0].PartitionResponses)[0].ErrorCode != errorcodes.NotLeaderOrFollower {
+		t.Fatalf("unexpected response %+v (%v)", produced, err)
+	}
+	if leader, err := c.Leader(ctx, "orders", 1); err != nil || leader != 2 {
+		t.Errorf("unexpected leader %d (%v)", leader, err)
+	}
+}
+
+func TestCoordinator(t *testing.T) {
+	cluster, c := testCluster(t)
+	ctx := testContext(t)
+
+	var mu sync.Mutex
+	var calls []int32
+	cluster.Handle(messages.JoinGroup, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		req := body.(*joingroup.JoinGroupRequest)
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls = append(calls, b.NodeId)
+		res := &joingroup.JoinGroupResponse{ApiVersion: req.ApiVersion, GenerationId: 1, ProtocolName: str("range"), Leader: str("member"), MemberId: str("member"), Members: &[]joingroup.JoinGroupResponseMember{}}
+		if len(calls) == 1 {
+			res.ErrorCode = errorcodes.NotCoordinator
+		}
+		return res, nil
+	})
+
+	coordinator, err := c.FindCoordinator(ctx, GroupCoordinator, "group")
+	if err != nil || coordinator.NodeId != cluster.Coordinator("group") {
+		t.Fatalf("unexpected coordinator %+v (%v)", coordinator, err)
+	}
+
+	join := func() *joingroup.JoinGroupResponse {
+		res, err := c.JoinGroup(ctx, &joingroup.JoinGroupRequest{GroupId: str("group"), SessionTimeoutMs: 10000, RebalanceTimeoutMs: 10000, MemberId: str(""), ProtocolType: str("consumer"), Protocols: &[]joingroup.JoinGroupRequestProtocol{}})
+		if err != nil {
+			t.Fatalf("JoinGroup: %v", err)
+		}
+		return res
+	}
+	if res := join(); res.ErrorCode != errorcodes.NotCoordinator {
+		t.Fatalf("unexpected response %+v", res)
+	}
+	c.mu.Lock()
+	cached := len(c.coordinators)
+	c.mu.Unlock()
+	if cached != 0 {
+		t.Errorf("the coordinator was not forgotten")
+	}
+	if res := join(); res.ErrorCode != errorcodes.None || res.GenerationId != 1 {
+		t.Fatalf("unexpected response %+v", res)
+	}
+	if len(calls) != 2 || calls[0] != coordinator.NodeId || calls[1] != coordinator.NodeId {
+		t.Errorf("unexpected calls %v", calls)
+	}
+
+	// A coordinator error of a partition forgets the coordinator as well.
+	cluster.Handle(messages.OffsetCommit, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		req := body.(*offsetcommit.OffsetCommitRequest)
+		partitions := []offsetcommit.OffsetCommitResponseTopicPartition{{PartitionIndex: 0}, {PartitionIndex: 1, ErrorCode: errorcodes.NotCoordinator}}
+		return &offsetcommit.OffsetCommitResponse{ApiVersion: req.ApiVersion, Topics: &[]offsetcommit.OffsetCommitResponseTopic{{Name: str("orders"), Partitions: &partitions}}}, nil
+	})
+	if _, err := c.FindCoordinator(ctx, GroupCoordinator, "group"); err != nil {
+		t.Fatalf("FindCoordinator: %v", err)
+	}
+	partitions := []offsetcommit.OffsetCommitRequestTopicPartition{{PartitionIndex: 0, CommittedOffset: 10}, {PartitionIndex: 1, CommittedOffset: 10}}
+	if _, err := c.OffsetCommit(ctx, &offsetcommit.OffsetCommitRequest{GroupId: str("group"), MemberId: str(""), GenerationIdOrMemberEpoch: -1,
+		Topics: &[]offsetcommit.OffsetCommitRequestTopic{{Name: str("orders"), Partitions: &partitions}}}); err != nil {
+		t.Fatalf("OffsetCommit: %v", err)
+	}
+	c.mu.Lock()
+	cached = len(c.coordinators)
+	c.mu.Unlock()
+	if cached != 0 {
+		t.Errorf("the coordinator was not forgotten after a partition error")
+	}
+
+	if _, err := c.Heartbeat(ctx, &heartbeat.HeartbeatRequest{}); !errors.Is(err, ErrMissingKey) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	cluster, c := testCluster(t)
+	ctx := testContext(t)
+
+	if _, err := c.Version(ctx, 7, messages.Metadata); !errors.Is(err, ErrUnknownBroker) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// A request which is not answered in time fails, and the connection is opened again for the next one.
+	release := make(chan struct{})
+	cluster.Handle(messages.Heartbeat, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		<-release
+		return &heartbeat.HeartbeatResponse{ApiVersion: body.(*heartbeat.HeartbeatRequest).ApiVersion}, nil
+	})
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err := c.Heartbeat(timeout, &heartbeat.HeartbeatRequest{GroupId: str("group"), MemberId: str("member")})
+	close(release)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if res, err := c.Heartbeat(ctx, &heartbeat.HeartbeatRequest{GroupId: str("group"), MemberId: str("member")}); err != nil || res.ErrorCode != errorcodes.None {
+		t.Fatalf("unexpected response %+v (%v)", res, err)
+	}
+
+	c.Close()
+	if err := c.RefreshMetadata(ctx); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	unreachable := NewClient("127.0.0.1:1")
+	if err := unreachable.RefreshMetadata(ctx); !errors.Is(err, ErrNoBrokers) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestSASL(t *testing.T) {
+	cluster, c := testCluster(t)
+	ctx := testContext(t)
+
+	var mu sync.Mutex
+	handshakes := 0
+	cluster.Handle(messages.SaslHandshake, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		req := body.(*saslhandshake.SaslHandshakeRequest)
+		mu.Lock()
+		defer mu.Unlock()
+		handshakes++
+		if *req.Mechanism != "PLAIN" {
+			return &saslhandshake.SaslHandshakeResponse{ApiVersion: req.ApiVersion, ErrorCode: errorcodes.UnsupportedSaslMechanism, Mechanisms: &[]string{"PLAIN"}}, nil
+		}
+		return &saslhandshake.SaslHandshakeResponse{ApiVersion: req.ApiVersion, Mechanisms: &[]string{"PLAIN"}}, nil
+	})
+	cluster.Handle(messages.SaslAuthenticate, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		req := body.(*saslauthenticate.SaslAuthenticateRequest)
+		res := &saslauthenticate.SaslAuthenticateResponse{ApiVersion: req.ApiVersion, AuthBytes: &[]byte{}}
+		if string(*req.AuthBytes) != "\x00alice\x00secret" {
+			res.ErrorCode, res.ErrorMessage = errorcodes.SaslAuthenticationFailed, str("invalid credentials")
+			return res, nil
+		}
+		res.SessionLifetimeMs = 60000
+		return res, nil
+	})
+
+	c.SASL = sasl.NewPlain("alice", "secret")
+	if _, err := c.Metadata(ctx, &metadata.MetadataRequest{}); err != nil {
+		t.Fatalf("Metadata: %v", err)
+	}
+
+	// A connection with an expiring session authenticates again before the next request.
+	c.mu.Lock()
+	cn := c.conns[-2] // The seed broker.
+	c.mu.Unlock()
+	cn.mu.Lock()
+	if cn.reauthAt.IsZero() || cn.expiresAt.Sub(cn.reauthAt) < 3*time.Second {
+		t.Errorf("unexpected re-authentication at %v, expiring at %v", cn.reauthAt, cn.expiresAt)
+	}
+	cn.reauthAt = time.Now()
+	cn.mu.Unlock()
+	if _, err := c.Metadata(ctx, &metadata.MetadataRequest{}); err != nil || cn.isBroken() {
+		t.Fatalf("Metadata: %v", err)
+	}
+	mu.Lock()
+	if handshakes != 2 {
+		t.Errorf("%d handshakes, expected 2", handshakes)
+	}
+	mu.Unlock()
+
+	// An expired session needs a new connection.
+	cn.mu.Lock()
+	cn.expiresAt = time.Now()
+	cn.mu.Unlock()
+	if _, err := c.Metadata(ctx, &metadata.MetadataRequest{}); err != nil || !cn.isBroken() {
+		t.Fatalf("Metadata: %v", err)
+	}
+
+	other := NewClient(cluster.Addresses()...)
+	defer other.Close()
+	other.SASL = sasl.NewPlain("alice", "wrong")
+	if _, err := other.Metadata(ctx, &metadata.MetadataRequest{}); !errors.Is(err, sasl.ErrAuthenticationFailed) {
+		t.Errorf("unexpected error %v", err)
+	}
+	other.SASL = sasl.NewScram(sasl.ScramSha256, "alice", "secret")
+	if _, err := other.Metadata(ctx, &metadata.MetadataRequest{}); !errors.Is(err, sasl.ErrUnsupportedMechanism) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/scholzj/go-kafka-protocol/api/apiversions"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+	"github.com/scholzj/go-kafka-protocol/sasl"
+)
+
+// versionRange is the range of versions of an API supported by a broker.
+type versionRange struct {
+	min int16
+	max int16
+}
+
+// conn is a connection to a broker. Requests are sent one at a time and wait for their response.
+type conn struct {
+	nodeId    int32
+	address   string
+	clientId  string
+	mechanism sasl.Mechanism
+
+	// session is held by requests and exclusively while the connection authenticates again, so no
+	// request is sent between the SASL requests.
+	session sync.RWMutex
+
+	mu            sync.Mutex
+	netConn       net.Conn
+	correlationId int32
+	versions      map[int16]versionRange
+	broken        bool
+	reauthAt      time.Time // When the SASL session should be authenticated again, zero if never.
+	expiresAt     time.Time // When the broker closes the connection unless it authenticated again.
+}
+
+// dial opens the connection, negotiates the API versions with ApiVersions and authenticates with the
+// SASL mechanism of the client.
+func (c *Client) dial(ctx context.Context, nodeId int32, address string) (*conn, error) {
+	netConn, err := c.Dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+
+	cn := &conn{nodeId: nodeId, address: address, clientId: c.ClientId, mechanism: c.SASL, netConn: netConn}
+	if err := cn.negotiate(ctx, c.ClientId, c.SoftwareName, c.SoftwareVersion); err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("failed to negotiate API versions with %s: %w", address, err)
+	}
+	if cn.mechanism != nil {
+		if err := cn.authenticate(ctx); err != nil {
+			netConn.Close()
+			return nil, fmt.Errorf("failed to authenticate with %s: %w", address, err)
+		}
+	}
+	return cn, nil
+}
+
+// authenticate authenticates the connection with the SASL mechanism and schedules the next
+// authentication when the session expires.
+func (cn *conn) authenticate(ctx context.Context) error {
+	start := time.Now()
+	session, err := sasl.Authenticate(ctx, saslConn{cn}, cn.mechanism)
+	if err != nil {
+		return err
+	}
+
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	cn.reauthAt, cn.expiresAt = time.Time{}, time.Time{}
+	if session.Lifetime > 0 {
+		cn.reauthAt = start.Add(session.Reauthenticate())
+		cn.expiresAt = start.Add(session.Lifetime)
+	}
+	return nil
+}
+
+// reauthenticate authenticates the connection again when its SASL session is about to expire
+// (KIP-368). The broker closes the connection when this fails.
+func (cn *conn) reauthenticate(ctx context.Context) error {
+	if !cn.reauthDue() {
+		return nil
+	}
+	cn.session.Lock()
+	defer cn.session.Unlock()
+
+	if !cn.reauthDue() {
+		return nil
+	}
+	if err := cn.authenticate(ctx); err != nil {
+		cn.close()
+		return fmt.Errorf("failed to authenticate again with %s: %w", cn.address, err)
+	}
+	return nil
+}
+
+func (cn *conn) reauthDue() bool {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+
+	return !cn.reauthAt.IsZero() && !time.Now().Before(cn.reauthAt)
+}
+
+// saslConn sends the SASL requests of the connection.
+type saslConn struct {
+	cn *conn
+}
+
+func (s saslConn) Version(apiKey int16) (int16, error) {
+	return s.cn.version(apiKey, -1)
+}
+
+func (s saslConn) RoundTrip(ctx context.Context, apiKey int16, version int16, req protocol.RequestBody, res protocol.ResponseBody) error {
+	return s.cn.request(ctx, apiKey, version, req, res)
+}
+
+// negotiate sends ApiVersions with the highest version known to this library. A broker which does not
+// support it answers with version 0 and its supported range of ApiVersions, and the request is sent
+// again with the highest version supported by both sides.
+func (cn *conn) negotiate(ctx context.Context, clientId string, softwareName string, softwareVersion string) error {
+	_, version, _ := messages.VersionRange(messages.ApiVersions)
+	for {
+		req := &apiversions.ApiVersionsRequest{ApiVersion: version, ClientSoftwareName: &softwareName, ClientSoftwareVersion: &softwareVersion}
+		response, err := cn.exchange(ctx, clientId, messages.ApiVersions, version, req, true)
+		if err != nil {
+			return err
+		}
+
+		// The error code comes first in every version.
+		body := response.Body.Bytes()
+		if len(body) >= 2 && int16(binary.BigEndian.Uint16(body)) == errorcodes.UnsupportedVersion {
+			response.ApiVersion = 0
+		}
+		res := &apiversions.ApiVersionsResponse{}
+		if err := res.Read(&response); err != nil {
+			return fmt.Errorf("failed to decode the ApiVersions response: %w", err)
+		}
+
+		if res.ErrorCode == errorcodes.UnsupportedVersion && version > 0 {
+			next := int16(0)
+			if res.ApiKeys != nil {
+				for _, k := range *res.ApiKeys {
+					if k.ApiKey == messages.ApiVersions && k.MaxVersion < version {
+						next = k.MaxVersion
+					}
+				}
+			}
+			version = next
+			continue
+		}
+		if res.ErrorCode != errorcodes.None {
+			return errorcodes.ToError(res.ErrorCode, nil)
+		}
+
+		cn.versions = make(map[int16]versionRange)
+		if res.ApiKeys != nil {
+			for _, k := range *res.ApiKeys {
+				cn.versions[k.ApiKey] = versionRange{min: k.MinVersion, max: k.MaxVersion}
+			}
+		}
+		return nil
+	}
+}
+
+// version returns the highest version of the API supported by this library and the broker, limited to
+// maxVersion when it is not negative.
+func (cn *conn) version(apiKey int16, maxVersion int16) (int16, error) {
+	clientMin, clientMax, ok := messages.VersionRange(apiKey)
+	if !ok {
+		return -1, fmt.Errorf("%w: unknown API key %d", ErrUnsupportedVersion, apiKey)
+	}
+	if maxVersion >= 0 && maxVersion < clientMax {
+		clientMax = maxVersion
+	}
+
+	broker, ok := cn.versions[apiKey]
+	if !ok {
+		return -1, fmt.Errorf("%w: broker %d does not support %s", ErrUnsupportedVersion, cn.nodeId, messages.Name(apiKey))
+	}
+	version := min(clientMax, broker.max)
+	if version < max(clientMin, broker.min) {
+		return -1, fmt.Errorf("%w: broker %d supports %s versions %d-%d", ErrUnsupportedVersion, cn.nodeId, messages.Name(apiKey), broker.min, broker.max)
+	}
+	return version, nil
+}
+
+// roundTrip sends the request and decodes the response into res. Without res, no response is expected,
+// as for Produce requests with Acks 0. An expiring SASL session is authenticated again first.
+func (cn *conn) roundTrip(ctx context.Context, apiKey int16, version int16, req protocol.RequestBody, res protocol.ResponseBody) error {
+	if err := cn.reauthenticate(ctx); err != nil {
+		return err
+	}
+	cn.session.RLock()
+	defer cn.session.RUnlock()
+
+	return cn.request(ctx, apiKey, version, req, res)
+}
+
+// request sends the request and decodes the response into res.
+func (cn *conn) request(ctx context.Context, apiKey int16, version int16, req protocol.RequestBody, res protocol.ResponseBody) error {
+	response, err := cn.exchange(ctx, cn.clientId, apiKey, version, req, res != nil)
+	if err != nil || res == nil {
+		return err
+	}
+	if err := res.Read(&response); err != nil {
+		return fmt.Errorf("failed to decode the %s response: %w", messages.Name(apiKey), err)
+	}
+	return nil
+}
+
+// exchange writes the request and reads the response when one is expected. The connection is closed
+// when the I/O fails, including when the context ends while waiting.
+func (cn *conn) exchange(ctx context.Context, clientId string, apiKey int16, version int16, req protocol.RequestBody, expectResponse bool) (protocol.Response, error) {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+
+	if cn.broken {
+		return protocol.Response{}, ErrConnectionClosed
+	}
+
+	body := bytes.NewBuffer(make([]byte, 0))
+	if err := req.Write(body); err != nil {
+		return protocol.Response{}, fmt.Errorf("failed to encode the %s request: %w", messages.Name(apiKey), err)
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := cn.netConn.SetDeadline(deadline); err != nil {
+		return protocol.Response{}, cn.fail(err)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		cn.netConn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	cn.correlationId++
+	header := protocol.RequestHeader{ApiKey: apiKey, ApiVersion: version, CorrelationId: cn.correlationId, ClientId: &clientId}
+	request := protocol.Request{RequestHeader: header, Body: body}
+	if err := request.Write(cn.netConn); err != nil {
+		return protocol.Response{}, cn.fail(ctxErr(ctx, err))
+	}
+	if !expectResponse {
+		return protocol.Response{}, nil
+	}
+
+	response, err := protocol.ReadResponse(cn.netConn, map[int32]protocol.RequestHeader{header.CorrelationId: header})
+	if err != nil {
+		return protocol.Response{}, cn.fail(ctxErr(ctx, err))
+	}
+	return response, nil
+}
+
+// fail closes the connection after an I/O error.
+func (cn *conn) fail(err error) error {
+	cn.broken = true
+	cn.netConn.Close()
+	return err
+}
+
+func (cn *conn) close() {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+
+	cn.broken = true
+	cn.netConn.Close()
+}
+
+// isBroken returns whether the connection is closed. A connection whose SASL session expired has been
+// closed by the broker and is closed as well.
+func (cn *conn) isBroken() bool {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+
+	if !cn.broken && !cn.expiresAt.IsZero() && !time.Now().Before(cn.expiresAt) {
+		cn.broken = true
+		cn.netConn.Close()
+	}
+	return cn.broken
+}
+
+// ctxErr returns the error of the context when the I/O failed because the context ended. The connection
+// deadline can pass just before the context notices its own.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %w", ctx.Err(), err)
+	}
+	if deadline, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) && !time.Now().Before(deadline) {
+		return fmt.Errorf("%w: %w", context.DeadlineExceeded, err)
+	}
+	return err
+}
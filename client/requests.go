@@ -0,0 +1,283 @@
+package client
+
+import (
+	"context"
+	"errors"
+
+	"github.com/scholzj/go-kafka-protocol/api/addoffsetstotxn"
+	"github.com/scholzj/go-kafka-protocol/api/addpartitionstotxn"
+	"github.com/scholzj/go-kafka-protocol/api/describesharegroupoffsets"
+	"github.com/scholzj/go-kafka-protocol/api/endtxn"
+	"github.com/scholzj/go-kafka-protocol/api/fetch"
+	"github.com/scholzj/go-kafka-protocol/api/heartbeat"
+	"github.com/scholzj/go-kafka-protocol/api/initproducerid"
+	"github.com/scholzj/go-kafka-protocol/api/joingroup"
+	"github.com/scholzj/go-kafka-protocol/api/leavegroup"
+	"github.com/scholzj/go-kafka-protocol/api/listoffsets"
+	"github.com/scholzj/go-kafka-protocol/api/offsetcommit"
+	"github.com/scholzj/go-kafka-protocol/api/offsetfetch"
+	"github.com/scholzj/go-kafka-protocol/api/produce"
+	"github.com/scholzj/go-kafka-protocol/api/syncgroup"
+	"github.com/scholzj/go-kafka-protocol/api/txnoffsetcommit"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+)
+
+// The typed request methods send the request with the highest version supported by the client and the
+// broker, which replaces the ApiVersion of the request. Fields which do not exist in that version are
+// not sent, so a request can set, for example, both the name and the id of a topic. To build a request
+// for one specific version, use Version and Send instead.
+
+var ErrMissingKey = errors.New("the request has no group or transactional id to find its coordinator")
+
+// The versions of AddPartitionsToTxn from 4 are only used between brokers (KIP-890).
+const maxClientAddPartitionsToTxnVersion = 3
+
+////////////////////
+// Partition leaders
+////////////////////
+
+// Produce sends the request to the broker, which must lead all its partitions. A request with Acks 0
+// gets no response and returns nil.
+func (c *Client) Produce(ctx context.Context, nodeId int32, req *produce.ProduceRequest) (*produce.ProduceResponse, error) {
+	if req.Acks == 0 {
+		return nil, c.call(ctx, nodeId, messages.Produce, -1, &req.ApiVersion, req, nil)
+	}
+
+	res := &produce.ProduceResponse{}
+	if err := c.call(ctx, nodeId, messages.Produce, -1, &req.ApiVersion, req, res); err != nil {
+		return nil, err
+	}
+	c.Cache.ApplyProduceResponse(res)
+	return res, nil
+}
+
+// Fetch sends the request to the broker, which must lead (or follow, for a preferred read replica) all
+// its partitions.
+func (c *Client) Fetch(ctx context.Context, nodeId int32, req *fetch.FetchRequest) (*fetch.FetchResponse, error) {
+	res := &fetch.FetchResponse{}
+	if err := c.call(ctx, nodeId, messages.Fetch, -1, &req.ApiVersion, req, res); err != nil {
+		return nil, err
+	}
+	c.Cache.ApplyFetchResponse(res)
+	return res, nil
+}
+
+// ListOffsets sends the request to the broker, which must lead all its partitions.
+func (c *Client) ListOffsets(ctx context.Context, nodeId int32, req *listoffsets.ListOffsetsRequest) (*listoffsets.ListOffsetsResponse, error) {
+	res := &listoffsets.ListOffsetsResponse{}
+	if err := c.call(ctx, nodeId, messages.ListOffsets, -1, &req.ApiVersion, req, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+////////////////////
+// Group coordinator
+////////////////////
+
+// JoinGroup sends the request to the coordinator of the group.
+func (c *Client) JoinGroup(ctx context.Context, req *joingroup.JoinGroupRequest) (*joingroup.JoinGroupResponse, error) {
+	if req.GroupId == nil {
+		return nil, ErrMissingKey
+	}
+	res := &joingroup.JoinGroupResponse{}
+	if err := c.coordinatorCall(ctx, GroupCoordinator, *req.GroupId, messages.JoinGroup, -1, &req.ApiVersion, req, res, func() int16 { return res.ErrorCode }); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SyncGroup sends the request to the coordinator of the group.
+func (c *Client) SyncGroup(ctx context.Context, req *syncgroup.SyncGroupRequest) (*syncgroup.SyncGroupResponse, error) {
+	if req.GroupId == nil {
+		return nil, ErrMissingKey
+	}
+	res := &syncgroup.SyncGroupResponse{}
+	if err := c.coordinatorCall(ctx, GroupCoordinator, *req.GroupId, messages.SyncGroup, -1, &req.ApiVersion, req, res, func() int16 { return res.ErrorCode }); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Heartbeat sends the request to the coordinator of the group.
+func (c *Client) Heartbeat(ctx context.Context, req *heartbeat.HeartbeatRequest) (*heartbeat.HeartbeatResponse, error) {
+	if req.GroupId == nil {
+		return nil, ErrMissingKey
+	}
+	res := &heartbeat.HeartbeatResponse{}
+	if err := c.coordinatorCall(ctx, GroupCoordinator, *req.GroupId, messages.Heartbeat, -1, &req.ApiVersion, req, res, func() int16 { return res.ErrorCode }); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// LeaveGroup sends the request to the coordinator of the group.
+func (c *Client) LeaveGroup(ctx context.Context, req *leavegroup.LeaveGroupRequest) (*leavegroup.LeaveGroupResponse, error) {
+	if req.GroupId == nil {
+		return nil, ErrMissingKey
+	}
+	res := &leavegroup.LeaveGroupResponse{}
+	if err := c.coordinatorCall(ctx, GroupCoordinator, *req.GroupId, messages.LeaveGroup, -1, &req.ApiVersion, req, res, func() int16 { return res.ErrorCode }); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// OffsetCommit sends the request to the coordinator of the group.
+func (c *Client) OffsetCommit(ctx context.Context, req *offsetcommit.OffsetCommitRequest) (*offsetcommit.OffsetCommitResponse, error) {
+	if req.GroupId == nil {
+		return nil, ErrMissingKey
+	}
+	res := &offsetcommit.OffsetCommitResponse{}
+	errorCode := func() int16 {
+		for _, topic := range deref(res.Topics) {
+			for _, partition := range deref(topic.Partitions) {
+				if isCoordinatorError(partition.ErrorCode) {
+					return partition.ErrorCode
+				}
+			}
+		}
+		return errorcodes.None
+	}
+	if err := c.coordinatorCall(ctx, GroupCoordinator, *req.GroupId, messages.OffsetCommit, -1, &req.ApiVersion, req, res, errorCode); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// OffsetFetch sends the request to the coordinator of the group. From version 8 the request can carry
+// several groups; it is sent to the coordinator of the first one, so all of them must share it. Use
+// GroupId as well as Groups for the request to work with every version.
+func (c *Client) OffsetFetch(ctx context.Context, req *offsetfetch.OffsetFetchRequest) (*offsetfetch.OffsetFetchResponse, error) {
+	var groupId *string
+	if req.Groups != nil && len(*req.Groups) > 0 {
+		groupId = (*req.Groups)[0].GroupId
+	} else {
+		groupId = req.GroupId
+	}
+	if groupId == nil {
+		return nil, ErrMissingKey
+	}
+
+	res := &offsetfetch.OffsetFetchResponse{}
+	errorCode := func() int16 {
+		if res.Groups != nil && len(*res.Groups) > 0 {
+			return (*res.Groups)[0].ErrorCode
+		}
+		return res.ErrorCode
+	}
+	if err := c.coordinatorCall(ctx, GroupCoordinator, *groupId, messages.OffsetFetch, -1, &req.ApiVersion, req, res, errorCode); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// TxnOffsetCommit sends the request to the coordinator of the group.
+func (c *Client) TxnOffsetCommit(ctx context.Context, req *txnoffsetcommit.TxnOffsetCommitRequest) (*txnoffsetcommit.TxnOffsetCommitResponse, error) {
+	if req.GroupId == nil {
+		return nil, ErrMissingKey
+	}
+	res := &txnoffsetcommit.TxnOffsetCommitResponse{}
+	errorCode := func() int16 {
+		for _, topic := range deref(res.Topics) {
+			for _, partition := range deref(topic.Partitions) {
+				if isCoordinatorError(partition.ErrorCode) {
+					return partition.ErrorCode
+				}
+			}
+		}
+		return errorcodes.None
+	}
+	if err := c.coordinatorCall(ctx, GroupCoordinator, *req.GroupId, messages.TxnOffsetCommit, -1, &req.ApiVersion, req, res, errorCode); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DescribeShareGroupOffsets sends the request to the coordinator of the first group, so all groups of
+// the request must share it.
+func (c *Client) DescribeShareGroupOffsets(ctx context.Context, req *describesharegroupoffsets.DescribeShareGroupOffsetsRequest) (*describesharegroupoffsets.DescribeShareGroupOffsetsResponse, error) {
+	if req.Groups == nil || len(*req.Groups) == 0 || (*req.Groups)[0].GroupId == nil {
+		return nil, ErrMissingKey
+	}
+
+	res := &describesharegroupoffsets.DescribeShareGroupOffsetsResponse{}
+	errorCode := func() int16 {
+		if res.Groups != nil && len(*res.Groups) > 0 {
+			return (*res.Groups)[0].ErrorCode
+		}
+		return 0
+	}
+	if err := c.coordinatorCall(ctx, GroupCoordinator, *(*req.Groups)[0].GroupId, messages.DescribeShareGroupOffsets, -1, &req.ApiVersion, req, res, errorCode); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+////////////////////
+// Transaction coordinator
+////////////////////
+
+// InitProducerId sends the request to the coordinator of the transactional id, or to any broker for an
+// idempotent producer without one.
+func (c *Client) InitProducerId(ctx context.Context, req *initproducerid.InitProducerIdRequest) (*initproducerid.InitProducerIdResponse, error) {
+	res := &initproducerid.InitProducerIdResponse{}
+	var err error
+	if req.TransactionalId == nil {
+		err = c.call(ctx, AnyBroker, messages.InitProducerId, -1, &req.ApiVersion, req, res)
+	} else {
+		err = c.coordinatorCall(ctx, TransactionCoordinator, *req.TransactionalId, messages.InitProducerId, -1, &req.ApiVersion, req, res, func() int16 { return res.ErrorCode })
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// AddPartitionsToTxn sends the request to the coordinator of the transactional id. Only the client
+// versions up to 3 are used.
+func (c *Client) AddPartitionsToTxn(ctx context.Context, req *addpartitionstotxn.AddPartitionsToTxnRequest) (*addpartitionstotxn.AddPartitionsToTxnResponse, error) {
+	if req.V3AndBelowTransactionalId == nil {
+		return nil, ErrMissingKey
+	}
+	res := &addpartitionstotxn.AddPartitionsToTxnResponse{}
+	errorCode := func() int16 {
+		for _, topic := range deref(res.ResultsByTopicV3AndBelow) {
+			for _, partition := range deref(topic.ResultsByPartition) {
+				if isCoordinatorError(partition.PartitionErrorCode) {
+					return partition.PartitionErrorCode
+				}
+			}
+		}
+		return errorcodes.None
+	}
+	if err := c.coordinatorCall(ctx, TransactionCoordinator, *req.V3AndBelowTransactionalId, messages.AddPartitionsToTxn, maxClientAddPartitionsToTxnVersion, &req.ApiVersion, req, res, errorCode); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// AddOffsetsToTxn sends the request to the coordinator of the transactional id.
+func (c *Client) AddOffsetsToTxn(ctx context.Context, req *addoffsetstotxn.AddOffsetsToTxnRequest) (*addoffsetstotxn.AddOffsetsToTxnResponse, error) {
+	if req.TransactionalId == nil {
+		return nil, ErrMissingKey
+	}
+	res := &addoffsetstotxn.AddOffsetsToTxnResponse{}
+	if err := c.coordinatorCall(ctx, TransactionCoordinator, *req.TransactionalId, messages.AddOffsetsToTxn, -1, &req.ApiVersion, req, res, func() int16 { return res.ErrorCode }); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// EndTxn sends the request to the coordinator of the transactional id.
+func (c *Client) EndTxn(ctx context.Context, req *endtxn.EndTxnRequest) (*endtxn.EndTxnResponse, error) {
+	if req.TransactionalId == nil {
+		return nil, ErrMissingKey
+	}
+	res := &endtxn.EndTxnResponse{}
+	if err := c.coordinatorCall(ctx, TransactionCoordinator, *req.TransactionalId, messages.EndTxn, -1, &req.ApiVersion, req, res, func() int16 { return res.ErrorCode }); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,423 @@
+package clustermetadata
+
+import (
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+////////////////////
+// Broker, controller, feature and configuration records
+////////////////////
+
+// Values of the Fenced and InControlledShutdown fields of BrokerRegistrationChangeRecord.
+const (
+	FencingChangeNone    int8 = 0
+	FencingChangeFence   int8 = 1
+	FencingChangeUnfence int8 = -1
+
+	ControlledShutdownChangeNone  int8 = 0
+	ControlledShutdownChangeEnter int8 = 1
+)
+
+// Endpoint is a listener of a broker or a controller.
+type Endpoint struct {
+	Name             string // The name of the endpoint. (versions: 0+)
+	Host             string // The hostname. (versions: 0+)
+	Port             uint16 // The port. (versions: 0+)
+	SecurityProtocol int16  // The security protocol. (versions: 0+)
+}
+
+func decodeEndpoint(d *protocol.Decoder) Endpoint {
+	endpoint := Endpoint{Name: d.String(), Host: d.String(), Port: d.Uint16(), SecurityProtocol: d.Int16()}
+	d.TaggedFields(noTaggedFields)
+	return endpoint
+}
+
+func encodeEndpoint(e *protocol.Encoder, endpoint Endpoint) {
+	e.String(endpoint.Name)
+	e.String(endpoint.Host)
+	e.Uint16(endpoint.Port)
+	e.Int16(endpoint.SecurityProtocol)
+	e.TaggedFields(nil)
+}
+
+// Feature is a feature supported by a broker or a controller.
+type Feature struct {
+	Name                string // The feature name. (versions: 0+)
+	MinSupportedVersion int16  // The minimum supported feature level. (versions: 0+)
+	MaxSupportedVersion int16  // The maximum supported feature level. (versions: 0+)
+}
+
+func decodeFeature(d *protocol.Decoder) Feature {
+	feature := Feature{Name: d.String(), MinSupportedVersion: d.Int16(), MaxSupportedVersion: d.Int16()}
+	d.TaggedFields(noTaggedFields)
+	return feature
+}
+
+func encodeFeature(e *protocol.Encoder, feature Feature) {
+	e.String(feature.Name)
+	e.Int16(feature.MinSupportedVersion)
+	e.Int16(feature.MaxSupportedVersion)
+	e.TaggedFields(nil)
+}
+
+// RegisterBrokerRecord registers a broker.
+type RegisterBrokerRecord struct {
+	BrokerId             int32       // The broker id. (versions: 0+)
+	IsMigratingZkBroker  bool        // True if the registering broker is a ZK broker. (versions: 2+)
+	IncarnationId        uuid.UUID   // The incarnation ID of the broker process. (versions: 0+)
+	BrokerEpoch          int64       // The broker epoch assigned by the controller. (versions: 0+)
+	EndPoints            []Endpoint  // The endpoints that can be used to communicate with this broker. (versions: 0+)
+	Features             []Feature   // The features on this broker. (versions: 0+)
+	Rack                 *string     // The broker rack. (versions: 0+, nullable: 0+)
+	Fenced               bool        // True if the broker is fenced. (versions: 0+)
+	InControlledShutdown bool        // True if the broker is in controlled shutdown. (versions: 1+)
+	LogDirs              []uuid.UUID // Log directories configured in this broker which are available. (versions: 3+)
+}
+
+func (m *RegisterBrokerRecord) Type() RecordType { return RegisterBrokerRecordType }
+
+func (m *RegisterBrokerRecord) decode(d *protocol.Decoder, version int16) {
+	m.Fenced = true
+
+	m.BrokerId = d.Int32()
+	if version >= 2 {
+		m.IsMigratingZkBroker = d.Bool()
+	}
+	m.IncarnationId = d.UUID()
+	m.BrokerEpoch = d.Int64()
+	m.EndPoints = protocol.DecodeArray(d, decodeEndpoint)
+	m.Features = protocol.DecodeArray(d, decodeFeature)
+	m.Rack = d.NullableString()
+	m.Fenced = d.Bool()
+	if version >= 1 {
+		m.InControlledShutdown = d.Bool()
+	}
+	if version >= 3 {
+		m.LogDirs = decodeUUIDs(d)
+	}
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *RegisterBrokerRecord) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.BrokerId)
+	if version >= 2 {
+		e.Bool(m.IsMigratingZkBroker)
+	}
+	e.UUID(m.IncarnationId)
+	e.Int64(m.BrokerEpoch)
+	protocol.EncodeArray(e, m.EndPoints, encodeEndpoint)
+	protocol.EncodeArray(e, m.Features, encodeFeature)
+	e.NullableString(m.Rack)
+	e.Bool(m.Fenced)
+	if version >= 1 {
+		e.Bool(m.InControlledShutdown)
+	}
+	if version >= 3 {
+		encodeUUIDs(e, m.LogDirs)
+	}
+	e.TaggedFields(nil)
+}
+
+// UnregisterBrokerRecord removes a broker registration.
+type UnregisterBrokerRecord struct {
+	BrokerId    int32 // The broker id. (versions: 0+)
+	BrokerEpoch int64 // The broker epoch. (versions: 0+)
+}
+
+func (m *UnregisterBrokerRecord) Type() RecordType { return UnregisterBrokerRecordType }
+
+func (m *UnregisterBrokerRecord) decode(d *protocol.Decoder, version int16) {
+	m.BrokerId = d.Int32()
+	m.BrokerEpoch = d.Int64()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *UnregisterBrokerRecord) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.BrokerId)
+	e.Int64(m.BrokerEpoch)
+	e.TaggedFields(nil)
+}
+
+// FenceBrokerRecord fences a broker. Newer controllers write a BrokerRegistrationChangeRecord instead.
+type FenceBrokerRecord struct {
+	Id    int32 // The broker ID to fence. It will be removed from all ISRs. (versions: 0+)
+	Epoch int64 // The epoch of the broker to fence. (versions: 0+)
+}
+
+func (m *FenceBrokerRecord) Type() RecordType { return FenceBrokerRecordType }
+
+func (m *FenceBrokerRecord) decode(d *protocol.Decoder, version int16) {
+	m.Id = d.Int32()
+	m.Epoch = d.Int64()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *FenceBrokerRecord) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.Id)
+	e.Int64(m.Epoch)
+	e.TaggedFields(nil)
+}
+
+// UnfenceBrokerRecord unfences a broker. Newer controllers write a BrokerRegistrationChangeRecord
+// instead.
+type UnfenceBrokerRecord struct {
+	Id    int32 // The broker ID to unfence. (versions: 0+)
+	Epoch int64 // The epoch of the broker to unfence. (versions: 0+)
+}
+
+func (m *UnfenceBrokerRecord) Type() RecordType { return UnfenceBrokerRecordType }
+
+func (m *UnfenceBrokerRecord) decode(d *protocol.Decoder, version int16) {
+	m.Id = d.Int32()
+	m.Epoch = d.Int64()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *UnfenceBrokerRecord) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.Id)
+	e.Int64(m.Epoch)
+	e.TaggedFields(nil)
+}
+
+// BrokerRegistrationChangeRecord changes the fencing, controlled shutdown or log directories of a
+// registered broker.
+type BrokerRegistrationChangeRecord struct {
+	BrokerId             int32       // The broker id. (versions: 0+)
+	BrokerEpoch          int64       // The broker epoch assigned by the controller. (versions: 0+)
+	Fenced               int8        // tag 0: -1 if the broker has been unfenced, 0 if no change, 1 if the broker has been fenced. (versions: 0+)
+	InControlledShutdown int8        // tag 1: 0 if no change, 1 if the broker is in controlled shutdown. (versions: 1+)
+	LogDirs              []uuid.UUID // tag 2: Log directories configured in this broker which are available. (versions: 2+, nullable: 2+)
+}
+
+func (m *BrokerRegistrationChangeRecord) Type() RecordType {
+	return BrokerRegistrationChangeRecordType
+}
+
+func (m *BrokerRegistrationChangeRecord) decode(d *protocol.Decoder, version int16) {
+	m.BrokerId = d.Int32()
+	m.BrokerEpoch = d.Int64()
+	d.TaggedFields(func(d *protocol.Decoder, tag uint64) bool {
+		switch {
+		case tag == 0:
+			m.Fenced = d.Int8()
+		case tag == 1 && version >= 1:
+			m.InControlledShutdown = d.Int8()
+		case tag == 2 && version >= 2:
+			m.LogDirs = decodeUUIDs(d)
+		default:
+			return false
+		}
+		return true
+	})
+}
+
+func (m *BrokerRegistrationChangeRecord) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.BrokerId)
+	e.Int64(m.BrokerEpoch)
+
+	var tagged []protocol.TaggedField
+	if m.Fenced != FencingChangeNone {
+		tagged = e.Tagged(tagged, 0, func(e *protocol.Encoder) { e.Int8(m.Fenced) })
+	}
+	if version >= 1 && m.InControlledShutdown != ControlledShutdownChangeNone {
+		tagged = e.Tagged(tagged, 1, func(e *protocol.Encoder) { e.Int8(m.InControlledShutdown) })
+	}
+	if version >= 2 && m.LogDirs != nil {
+		tagged = e.Tagged(tagged, 2, func(e *protocol.Encoder) { encodeNullableUUIDs(e, m.LogDirs) })
+	}
+	e.TaggedFields(tagged)
+}
+
+// RegisterControllerRecord registers a controller (KIP-919).
+type RegisterControllerRecord struct {
+	ControllerId     int32      // The controller id. (versions: 0+)
+	IncarnationId    uuid.UUID  // The incarnation ID of the controller process. (versions: 0+)
+	ZkMigrationReady bool       // Set if the required configurations for ZK migration are present. (versions: 0+)
+	EndPoints        []Endpoint // The endpoints that can be used to communicate with this controller. (versions: 0+)
+	Features         []Feature  // The features on this controller. (versions: 0+)
+}
+
+func (m *RegisterControllerRecord) Type() RecordType { return RegisterControllerRecordType }
+
+func (m *RegisterControllerRecord) decode(d *protocol.Decoder, version int16) {
+	m.ControllerId = d.Int32()
+	m.IncarnationId = d.UUID()
+	m.ZkMigrationReady = d.Bool()
+	m.EndPoints = protocol.DecodeArray(d, decodeEndpoint)
+	m.Features = protocol.DecodeArray(d, decodeFeature)
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *RegisterControllerRecord) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.ControllerId)
+	e.UUID(m.IncarnationId)
+	e.Bool(m.ZkMigrationReady)
+	protocol.EncodeArray(e, m.EndPoints, encodeEndpoint)
+	protocol.EncodeArray(e, m.Features, encodeFeature)
+	e.TaggedFields(nil)
+}
+
+// FeatureLevelRecord sets the finalized level of a feature. Level 0 removes the feature.
+type FeatureLevelRecord struct {
+	Name         string // The feature name. (versions: 0+)
+	FeatureLevel int16  // The current finalized feature level of this feature for the cluster, a value of 0 means feature not supported. (versions: 0+)
+}
+
+func (m *FeatureLevelRecord) Type() RecordType { return FeatureLevelRecordType }
+
+func (m *FeatureLevelRecord) decode(d *protocol.Decoder, version int16) {
+	m.Name = d.String()
+	m.FeatureLevel = d.Int16()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *FeatureLevelRecord) encode(e *protocol.Encoder, version int16) {
+	e.String(m.Name)
+	e.Int16(m.FeatureLevel)
+	e.TaggedFields(nil)
+}
+
+// ProducerIdsRecord records the producer id block handed out to a broker.
+type ProducerIdsRecord struct {
+	BrokerId       int32 // The ID of the requesting broker. (versions: 0+)
+	BrokerEpoch    int64 // The epoch of the requesting broker. (versions: 0+)
+	NextProducerId int64 // The next producerId that will be assigned (i.e. the first one after this block). (versions: 0+)
+}
+
+func (m *ProducerIdsRecord) Type() RecordType { return ProducerIdsRecordType }
+
+func (m *ProducerIdsRecord) decode(d *protocol.Decoder, version int16) {
+	m.BrokerId = d.Int32()
+	m.BrokerEpoch = d.Int64()
+	m.NextProducerId = d.Int64()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ProducerIdsRecord) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.BrokerId)
+	e.Int64(m.BrokerEpoch)
+	e.Int64(m.NextProducerId)
+	e.TaggedFields(nil)
+}
+
+// ConfigRecord sets or (with a null value) deletes a dynamic configuration.
+type ConfigRecord struct {
+	ResourceType int8    // The type of resource this configuration applies to. (versions: 0+)
+	ResourceName string  // The name of the resource this configuration applies to. (versions: 0+)
+	Name         string  // The name of the configuration key. (versions: 0+)
+	Value        *string // The value of the configuration, or null if it should be deleted. (versions: 0+, nullable: 0+)
+}
+
+func (m *ConfigRecord) Type() RecordType { return ConfigRecordType }
+
+func (m *ConfigRecord) decode(d *protocol.Decoder, version int16) {
+	m.ResourceType = d.Int8()
+	m.ResourceName = d.String()
+	m.Name = d.String()
+	m.Value = d.NullableString()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ConfigRecord) encode(e *protocol.Encoder, version int16) {
+	e.Int8(m.ResourceType)
+	e.String(m.ResourceName)
+	e.String(m.Name)
+	e.NullableString(m.Value)
+	e.TaggedFields(nil)
+}
+
+// NoOpRecord is appended by the controller to advance the high watermark when there is nothing
+// else to write.
+type NoOpRecord struct{}
+
+func (m *NoOpRecord) Type() RecordType { return NoOpRecordType }
+
+func (m *NoOpRecord) decode(d *protocol.Decoder, version int16) {
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *NoOpRecord) encode(e *protocol.Encoder, version int16) {
+	e.TaggedFields(nil)
+}
+
+// ZkMigrationStateRecord records the state of a ZooKeeper to KRaft migration.
+type ZkMigrationStateRecord struct {
+	ZkMigrationState int8 // One of the possible migration states. (versions: 0+)
+}
+
+func (m *ZkMigrationStateRecord) Type() RecordType { return ZkMigrationStateRecordType }
+
+func (m *ZkMigrationStateRecord) decode(d *protocol.Decoder, version int16) {
+	m.ZkMigrationState = d.Int8()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ZkMigrationStateRecord) encode(e *protocol.Encoder, version int16) {
+	e.Int8(m.ZkMigrationState)
+	e.TaggedFields(nil)
+}
+
+// BeginTransactionRecord starts a metadata transaction: the records up to the matching
+// EndTransactionRecord or AbortTransactionRecord are applied atomically.
+type BeginTransactionRecord struct {
+	Name *string // tag 0: An optional textual description of this transaction. (versions: 0+, nullable: 0+)
+}
+
+func (m *BeginTransactionRecord) Type() RecordType { return BeginTransactionRecordType }
+
+func (m *BeginTransactionRecord) decode(d *protocol.Decoder, version int16) {
+	d.TaggedFields(func(d *protocol.Decoder, tag uint64) bool {
+		if tag != 0 {
+			return false
+		}
+		m.Name = d.NullableString()
+		return true
+	})
+}
+
+func (m *BeginTransactionRecord) encode(e *protocol.Encoder, version int16) {
+	var tagged []protocol.TaggedField
+	if m.Name != nil {
+		tagged = e.Tagged(tagged, 0, func(e *protocol.Encoder) { e.NullableString(m.Name) })
+	}
+	e.TaggedFields(tagged)
+}
+
+// EndTransactionRecord commits a metadata transaction.
+type EndTransactionRecord struct{}
+
+func (m *EndTransactionRecord) Type() RecordType { return EndTransactionRecordType }
+
+func (m *EndTransactionRecord) decode(d *protocol.Decoder, version int16) {
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *EndTransactionRecord) encode(e *protocol.Encoder, version int16) {
+	e.TaggedFields(nil)
+}
+
+// AbortTransactionRecord aborts a metadata transaction.
+type AbortTransactionRecord struct {
+	Reason *string // tag 0: An optional textual reason for the abort. (versions: 0+, nullable: 0+)
+}
+
+func (m *AbortTransactionRecord) Type() RecordType { return AbortTransactionRecordType }
+
+func (m *AbortTransactionRecord) decode(d *protocol.Decoder, version int16) {
+	d.TaggedFields(func(d *protocol.Decoder, tag uint64) bool {
+		if tag != 0 {
+			return false
+		}
+		m.Reason = d.NullableString()
+		return true
+	})
+}
+
+func (m *AbortTransactionRecord) encode(e *protocol.Encoder, version int16) {
+	var tagged []protocol.TaggedField
+	if m.Reason != nil {
+		tagged = e.Tagged(tagged, 0, func(e *protocol.Encoder) { e.NullableString(m.Reason) })
+	}
+	e.TaggedFields(tagged)
+}
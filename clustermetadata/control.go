@@ -0,0 +1,269 @@
+package clustermetadata
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/compression"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+////////////////////
+// KRaft control records
+////////////////////
+
+// The KRaft layer writes control batches into the metadata log and snapshots: leader changes, the
+// snapshot header and footer, and (KIP-853) the kraft.version and the voter set. Their values are
+// flexible messages without a frame; each message starts with its own version field.
+
+// ControlMessage is the value of a KRaft control record.
+type ControlMessage interface {
+	ControlType() records.ControlRecordType
+	decode(d *protocol.Decoder)
+	encode(e *protocol.Encoder)
+}
+
+// LeaderChangeMessage is written by a new leader at the start of its epoch.
+type LeaderChangeMessage struct {
+	Version        int16   // The version of the leader change message. (versions: 0+)
+	LeaderId       int32   // The ID of the newly elected leader. (versions: 0+)
+	Voters         []int32 // The set of voters in the quorum for this epoch. (versions: 0+)
+	GrantingVoters []int32 // The voters who voted for the leader at the time of election. (versions: 0+)
+}
+
+func (m *LeaderChangeMessage) ControlType() records.ControlRecordType {
+	return records.ControlLeaderChange
+}
+
+func decodeVoterId(d *protocol.Decoder) int32 {
+	id := d.Int32()
+	d.TaggedFields(noTaggedFields)
+	return id
+}
+
+func encodeVoterId(e *protocol.Encoder, id int32) {
+	e.Int32(id)
+	e.TaggedFields(nil)
+}
+
+func (m *LeaderChangeMessage) decode(d *protocol.Decoder) {
+	m.Version = d.Int16()
+	m.LeaderId = d.Int32()
+	m.Voters = protocol.DecodeArray(d, decodeVoterId)
+	m.GrantingVoters = protocol.DecodeArray(d, decodeVoterId)
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *LeaderChangeMessage) encode(e *protocol.Encoder) {
+	e.Int16(m.Version)
+	e.Int32(m.LeaderId)
+	protocol.EncodeArray(e, m.Voters, encodeVoterId)
+	protocol.EncodeArray(e, m.GrantingVoters, encodeVoterId)
+	e.TaggedFields(nil)
+}
+
+// SnapshotHeaderRecord is the first record of a snapshot.
+type SnapshotHeaderRecord struct {
+	Version                   int16 // The version of the snapshot header record. (versions: 0+)
+	LastContainedLogTimestamp int64 // The append time of the last record from the log contained in this snapshot. (versions: 0+)
+}
+
+func (m *SnapshotHeaderRecord) ControlType() records.ControlRecordType {
+	return records.ControlSnapshotHeader
+}
+
+func (m *SnapshotHeaderRecord) decode(d *protocol.Decoder) {
+	m.Version = d.Int16()
+	m.LastContainedLogTimestamp = d.Int64()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *SnapshotHeaderRecord) encode(e *protocol.Encoder) {
+	e.Int16(m.Version)
+	e.Int64(m.LastContainedLogTimestamp)
+	e.TaggedFields(nil)
+}
+
+// SnapshotFooterRecord is the last record of a snapshot.
+type SnapshotFooterRecord struct {
+	Version int16 // The version of the snapshot footer record. (versions: 0+)
+}
+
+func (m *SnapshotFooterRecord) ControlType() records.ControlRecordType {
+	return records.ControlSnapshotFooter
+}
+
+func (m *SnapshotFooterRecord) decode(d *protocol.Decoder) {
+	m.Version = d.Int16()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *SnapshotFooterRecord) encode(e *protocol.Encoder) {
+	e.Int16(m.Version)
+	e.TaggedFields(nil)
+}
+
+// KRaftVersionRecord records the finalized kraft.version.
+type KRaftVersionRecord struct {
+	Version      int16 // The version of the kraft version record. (versions: 0+)
+	KRaftVersion int16 // The kraft protocol version. (versions: 0+)
+}
+
+func (m *KRaftVersionRecord) ControlType() records.ControlRecordType {
+	return records.ControlKRaftVersion
+}
+
+func (m *KRaftVersionRecord) decode(d *protocol.Decoder) {
+	m.Version = d.Int16()
+	m.KRaftVersion = d.Int16()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *KRaftVersionRecord) encode(e *protocol.Encoder) {
+	e.Int16(m.Version)
+	e.Int16(m.KRaftVersion)
+	e.TaggedFields(nil)
+}
+
+// VoterEndpoint is a listener of a voter.
+type VoterEndpoint struct {
+	Name string // The name of the endpoint. (versions: 0+)
+	Host string // The hostname. (versions: 0+)
+	Port uint16 // The port. (versions: 0+)
+}
+
+// Voter is a member of the voter set.
+type Voter struct {
+	VoterId                  int32           // The replica id of the voter in the topic partition. (versions: 0+)
+	VoterDirectoryId         uuid.UUID       // The directory id of the voter in the topic partition. (versions: 0+)
+	Endpoints                []VoterEndpoint // The endpoint that can be used to communicate with the voter. (versions: 0+)
+	MinSupportedKRaftVersion int16           // The minimum supported KRaft protocol version. (versions: 0+)
+	MaxSupportedKRaftVersion int16           // The maximum supported KRaft protocol version. (versions: 0+)
+}
+
+func decodeVoterEndpoint(d *protocol.Decoder) VoterEndpoint {
+	endpoint := VoterEndpoint{Name: d.String(), Host: d.String(), Port: d.Uint16()}
+	d.TaggedFields(noTaggedFields)
+	return endpoint
+}
+
+func encodeVoterEndpoint(e *protocol.Encoder, endpoint VoterEndpoint) {
+	e.String(endpoint.Name)
+	e.String(endpoint.Host)
+	e.Uint16(endpoint.Port)
+	e.TaggedFields(nil)
+}
+
+func decodeVoter(d *protocol.Decoder) Voter {
+	voter := Voter{VoterId: d.Int32(), VoterDirectoryId: d.UUID()}
+	voter.Endpoints = protocol.DecodeArray(d, decodeVoterEndpoint)
+
+	// KRaftVersionFeature struct
+	voter.MinSupportedKRaftVersion = d.Int16()
+	voter.MaxSupportedKRaftVersion = d.Int16()
+	d.TaggedFields(noTaggedFields)
+
+	d.TaggedFields(noTaggedFields)
+	return voter
+}
+
+func encodeVoter(e *protocol.Encoder, voter Voter) {
+	e.Int32(voter.VoterId)
+	e.UUID(voter.VoterDirectoryId)
+	protocol.EncodeArray(e, voter.Endpoints, encodeVoterEndpoint)
+
+	// KRaftVersionFeature struct
+	e.Int16(voter.MinSupportedKRaftVersion)
+	e.Int16(voter.MaxSupportedKRaftVersion)
+	e.TaggedFields(nil)
+
+	e.TaggedFields(nil)
+}
+
+// VotersRecord records the voter set (KIP-853).
+type VotersRecord struct {
+	Version int16   // The version of the voters record. (versions: 0+)
+	Voters  []Voter // The set of voters in the quorum for this epoch. (versions: 0+)
+}
+
+func (m *VotersRecord) ControlType() records.ControlRecordType {
+	return records.ControlKRaftVoters
+}
+
+func (m *VotersRecord) decode(d *protocol.Decoder) {
+	m.Version = d.Int16()
+	m.Voters = protocol.DecodeArray(d, decodeVoter)
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *VotersRecord) encode(e *protocol.Encoder) {
+	e.Int16(m.Version)
+	protocol.EncodeArray(e, m.Voters, encodeVoter)
+	e.TaggedFields(nil)
+}
+
+// NewControlMessage returns an empty control message of the given type, or nil for the types that
+// are not KRaft control records (the transaction markers).
+func NewControlMessage(t records.ControlRecordType) ControlMessage {
+	switch t {
+	case records.ControlLeaderChange:
+		return &LeaderChangeMessage{}
+	case records.ControlSnapshotHeader:
+		return &SnapshotHeaderRecord{}
+	case records.ControlSnapshotFooter:
+		return &SnapshotFooterRecord{}
+	case records.ControlKRaftVersion:
+		return &KRaftVersionRecord{}
+	case records.ControlKRaftVoters:
+		return &VotersRecord{}
+	default:
+		return nil
+	}
+}
+
+// DecodeControlRecord decodes a record of a KRaft control batch.
+func DecodeControlRecord(record records.Record) (ControlMessage, error) {
+	t, err := records.ControlType(record)
+	if err != nil {
+		return nil, err
+	}
+
+	message := NewControlMessage(t)
+	if message == nil {
+		return nil, fmt.Errorf("control record %s is not a KRaft control record", t)
+	}
+
+	r := bytes.NewReader(record.Value)
+	d := protocol.NewDecoder(r, true)
+	message.decode(d)
+	if err := d.Err(); err != nil {
+		return nil, fmt.Errorf("control record %s: %w", t, err)
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("control record %s: %d bytes after the record", t, r.Len())
+	}
+
+	return message, nil
+}
+
+// NewControlBatch encodes KRaft control messages into a control batch.
+func NewControlBatch(baseOffset int64, leaderEpoch int32, timestamp int64, messages []ControlMessage) (*records.RecordBatch, error) {
+	batch := records.NewRecordBatch(baseOffset, compression.None)
+	batch.PartitionLeaderEpoch = leaderEpoch
+	batch.Attributes |= records.ControlAttribute
+
+	for _, message := range messages {
+		buf := bytes.NewBuffer(make([]byte, 0))
+		e := protocol.NewEncoder(buf, true)
+		message.encode(e)
+		if err := e.Err(); err != nil {
+			return nil, fmt.Errorf("control record %s: %w", message.ControlType(), err)
+		}
+
+		batch.AppendRecord(timestamp, records.ControlRecordKey(message.ControlType()), buf.Bytes(), nil)
+	}
+
+	return batch, nil
+}
@@ -0,0 +1,660 @@
+package clustermetadata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/fetch"
+	"github.com/scholzj/go-kafka-protocol/api/fetchsnapshot"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// MetadataTopic is the name of the KRaft metadata log. It has a single partition.
+const MetadataTopic = "__cluster_metadata"
+
+// MetadataTopicId is the fixed topic id of the KRaft metadata log.
+var MetadataTopicId = uuid.UUID{15: 1}
+
+var (
+	ErrSnapshotRequired = errors.New("the metadata log was truncated past the fetch offset, a snapshot is required")
+	ErrDivergingLog     = errors.New("the fetched metadata log diverges from the applied records")
+	ErrInvalidSnapshot  = errors.New("invalid metadata snapshot")
+)
+
+// Topic is a topic in the metadata image.
+type Topic struct {
+	Name       string
+	Id         uuid.UUID
+	Partitions map[int32]*Partition
+}
+
+// Partition is the state of a partition in the metadata image.
+type Partition struct {
+	Replicas               []int32
+	Isr                    []int32
+	RemovingReplicas       []int32
+	AddingReplicas         []int32
+	Leader                 int32
+	LeaderRecoveryState    int8
+	LeaderEpoch            int32
+	PartitionEpoch         int32
+	Directories            []uuid.UUID
+	EligibleLeaderReplicas []int32
+	LastKnownElr           []int32
+}
+
+// Broker is a broker registration in the metadata image.
+type Broker struct {
+	Id                   int32
+	Epoch                int64
+	IncarnationId        uuid.UUID
+	EndPoints            []Endpoint
+	Features             []Feature
+	Rack                 *string
+	Fenced               bool
+	InControlledShutdown bool
+	IsMigratingZkBroker  bool
+	LogDirs              []uuid.UUID
+}
+
+// Controller is a controller registration in the metadata image.
+type Controller struct {
+	Id               int32
+	IncarnationId    uuid.UUID
+	ZkMigrationReady bool
+	EndPoints        []Endpoint
+	Features         []Feature
+}
+
+// ConfigResource identifies the resource of dynamic configurations, such as a topic (type 2) or a
+// broker (type 4). The cluster-wide default broker configuration uses an empty broker name.
+type ConfigResource struct {
+	Type int8
+	Name string
+}
+
+// ScramCredentialKey identifies the SCRAM credential of a user for one mechanism.
+type ScramCredentialKey struct {
+	Name      string
+	Mechanism int8
+}
+
+// Image is the state of the cluster metadata built by applying the records of the metadata log in
+// order, as the brokers and controllers do. It starts from an optional snapshot and follows the
+// log from there. The image is not safe for concurrent use.
+type Image struct {
+	Offset       int64 // The offset of the last applied record, or -1.
+	Epoch        int32 // The leader epoch of the last applied record, or -1.
+	LeaderId     int32 // The last leader announced by a leader change record, or -1.
+	Voters       []Voter
+	KRaftVersion int16
+
+	Topics           map[uuid.UUID]*Topic
+	Brokers          map[int32]*Broker
+	Controllers      map[int32]*Controller
+	Configs          map[ConfigResource]map[string]string
+	Features         map[string]int16
+	Acls             map[uuid.UUID]AccessControlEntryRecord
+	ClientQuotas     map[string]map[string]float64
+	ScramCredentials map[ScramCredentialKey]UserScramCredentialRecord
+	DelegationTokens map[string]DelegationTokenRecord
+	NextProducerId   int64
+	ZkMigrationState int8
+
+	topicsByName map[string]uuid.UUID
+
+	// The records of an open metadata transaction, applied when it ends.
+	inTransaction bool
+	pending       []Record
+}
+
+func NewImage() *Image {
+	return &Image{
+		Offset:           -1,
+		Epoch:            -1,
+		LeaderId:         -1,
+		Topics:           make(map[uuid.UUID]*Topic),
+		Brokers:          make(map[int32]*Broker),
+		Controllers:      make(map[int32]*Controller),
+		Configs:          make(map[ConfigResource]map[string]string),
+		Features:         make(map[string]int16),
+		Acls:             make(map[uuid.UUID]AccessControlEntryRecord),
+		ClientQuotas:     make(map[string]map[string]float64),
+		ScramCredentials: make(map[ScramCredentialKey]UserScramCredentialRecord),
+		DelegationTokens: make(map[string]DelegationTokenRecord),
+		topicsByName:     make(map[string]uuid.UUID),
+	}
+}
+
+// TopicByName returns the topic with the given name, or nil.
+func (i *Image) TopicByName(name string) *Topic {
+	id, ok := i.topicsByName[name]
+	if !ok {
+		return nil
+	}
+	return i.Topics[id]
+}
+
+// NextOffset returns the offset to continue fetching the metadata log from.
+func (i *Image) NextOffset() int64 {
+	return i.Offset + 1
+}
+
+// InTransaction reports whether a metadata transaction is open. Its records are applied only once
+// it ends.
+func (i *Image) InTransaction() bool {
+	return i.inTransaction
+}
+
+////////////////////
+// Snapshots and log batches
+////////////////////
+
+// LoadSnapshot replaces the image with the contents of a snapshot. The snapshot covers the log up to
+// endOffset (exclusive), and its records must start with a snapshot header and end with a footer.
+func (i *Image) LoadSnapshot(endOffset int64, epoch int32, data []byte) error {
+	batches, err := records.ReadRecordBatches(data)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+	}
+	if err := checkSnapshotFraming(batches); err != nil {
+		return err
+	}
+
+	image := NewImage()
+	for _, batch := range batches {
+		if err := image.applyBatch(batch, false); err != nil {
+			return err
+		}
+	}
+	if image.inTransaction {
+		return fmt.Errorf("%w: the snapshot ends inside a metadata transaction", ErrInvalidSnapshot)
+	}
+
+	image.Offset = endOffset - 1
+	image.Epoch = epoch
+	*i = *image
+	return nil
+}
+
+func checkSnapshotFraming(batches []*records.RecordBatch) error {
+	controlType := func(batch *records.RecordBatch, index int) records.ControlRecordType {
+		if !batch.IsControl() || len(batch.Records) == 0 {
+			return -1
+		}
+		t, _ := records.ControlType(batch.Records[index])
+		return t
+	}
+
+	if len(batches) == 0 || controlType(batches[0], 0) != records.ControlSnapshotHeader {
+		return fmt.Errorf("%w: missing snapshot header", ErrInvalidSnapshot)
+	}
+	last := batches[len(batches)-1]
+	if controlType(last, len(last.Records)-1) != records.ControlSnapshotFooter {
+		return fmt.Errorf("%w: missing snapshot footer", ErrInvalidSnapshot)
+	}
+
+	return nil
+}
+
+// ApplyBatch applies the records of a batch from the metadata log. Records at or below the current
+// offset have been applied already and are skipped.
+func (i *Image) ApplyBatch(batch *records.RecordBatch) error {
+	return i.applyBatch(batch, true)
+}
+
+func (i *Image) applyBatch(batch *records.RecordBatch, fromLog bool) error {
+	if fromLog && batch.LastOffset() <= i.Offset {
+		return nil
+	}
+
+	var metadata []Record
+	if !batch.IsControl() {
+		var err error
+		if metadata, err = DecodeBatch(batch); err != nil {
+			return err
+		}
+	}
+
+	for index, record := range batch.Records {
+		offset := batch.Offset(record)
+		if fromLog && offset <= i.Offset {
+			continue
+		}
+
+		if batch.IsControl() {
+			if err := i.applyControl(record); err != nil {
+				return fmt.Errorf("offset %d: %w", offset, err)
+			}
+		} else if err := i.Apply(metadata[index]); err != nil {
+			return fmt.Errorf("offset %d: %w", offset, err)
+		}
+
+		if fromLog {
+			i.Offset = offset
+			i.Epoch = batch.PartitionLeaderEpoch
+		}
+	}
+
+	return nil
+}
+
+func (i *Image) applyControl(record records.Record) error {
+	t, err := records.ControlType(record)
+	if err != nil {
+		return err
+	}
+	if t == records.ControlAbort || t == records.ControlCommit {
+		return nil
+	}
+
+	message, err := DecodeControlRecord(record)
+	if err != nil {
+		return err
+	}
+
+	switch m := message.(type) {
+	case *LeaderChangeMessage:
+		i.LeaderId = m.LeaderId
+	case *VotersRecord:
+		i.Voters = m.Voters
+	case *KRaftVersionRecord:
+		i.KRaftVersion = m.KRaftVersion
+	}
+
+	return nil
+}
+
+// ApplyFetchPartition applies the committed records (below the high watermark) of the metadata log
+// partition from a Fetch response. When the leader has truncated its log past the fetch offset, the
+// returned error wraps ErrSnapshotRequired and the partition's SnapshotId names the snapshot to
+// fetch with FetchSnapshot.
+func (i *Image) ApplyFetchPartition(partition *fetch.FetchResponseResponsePartition) error {
+	if err := errorcodes.ToError(partition.ErrorCode, nil); err != nil {
+		return err
+	}
+	if partition.SnapshotId != nil && partition.SnapshotId.EndOffset >= 0 {
+		return fmt.Errorf("%w: snapshot end offset %d, epoch %d", ErrSnapshotRequired, partition.SnapshotId.EndOffset, partition.SnapshotId.Epoch)
+	}
+	if partition.DivergingEpoch != nil && partition.DivergingEpoch.EndOffset >= 0 {
+		return fmt.Errorf("%w: epoch %d ends at offset %d", ErrDivergingLog, partition.DivergingEpoch.Epoch, partition.DivergingEpoch.EndOffset)
+	}
+	if partition.Records == nil {
+		return nil
+	}
+
+	batches, err := records.ReadRecordBatches(*partition.Records)
+	if err != nil {
+		return err
+	}
+
+	for _, batch := range batches {
+		if batch.BaseOffset >= partition.HighWatermark {
+			break
+		}
+
+		if batch.LastOffset() >= partition.HighWatermark {
+			// Only part of the batch is committed.
+			committed := *batch
+			committed.Records = make([]records.Record, 0, len(batch.Records))
+			for _, record := range batch.Records {
+				if batch.Offset(record) < partition.HighWatermark {
+					committed.Records = append(committed.Records, record)
+				}
+			}
+			committed.LastOffsetDelta = int32(partition.HighWatermark - 1 - batch.BaseOffset)
+			batch = &committed
+		}
+
+		if err := i.ApplyBatch(batch); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SnapshotDownload assembles a snapshot fetched in chunks with FetchSnapshot requests.
+type SnapshotDownload struct {
+	EndOffset int64
+	Epoch     int32
+	Size      int64
+	data      bytes.Buffer
+}
+
+func NewSnapshotDownload(endOffset int64, epoch int32) *SnapshotDownload {
+	return &SnapshotDownload{EndOffset: endOffset, Epoch: epoch, Size: -1}
+}
+
+// Position returns the position to request the next chunk from.
+func (s *SnapshotDownload) Position() int64 {
+	return int64(s.data.Len())
+}
+
+// Done reports whether the whole snapshot has been received.
+func (s *SnapshotDownload) Done() bool {
+	return s.Size >= 0 && s.Position() >= s.Size
+}
+
+// Add adds the chunk from a FetchSnapshot response partition. Chunks must arrive in order.
+func (s *SnapshotDownload) Add(partition *fetchsnapshot.FetchSnapshotResponseTopicPartition) error {
+	if err := errorcodes.ToError(partition.ErrorCode, nil); err != nil {
+		return err
+	}
+	if partition.SnapshotId == nil || partition.SnapshotId.EndOffset != s.EndOffset || partition.SnapshotId.Epoch != s.Epoch {
+		return fmt.Errorf("%w: the response is for a different snapshot", ErrInvalidSnapshot)
+	}
+	if partition.Position != s.Position() {
+		return fmt.Errorf("%w: chunk at position %d, expected %d", ErrInvalidSnapshot, partition.Position, s.Position())
+	}
+
+	s.Size = partition.Size
+	if partition.UnalignedRecords != nil {
+		s.data.Write(*partition.UnalignedRecords)
+	}
+	if s.Position() > s.Size {
+		return fmt.Errorf("%w: received %d bytes of a %d byte snapshot", ErrInvalidSnapshot, s.Position(), s.Size)
+	}
+
+	return nil
+}
+
+// LoadInto replaces the image with the downloaded snapshot.
+func (s *SnapshotDownload) LoadInto(image *Image) error {
+	if !s.Done() {
+		return fmt.Errorf("%w: only %d of %d bytes were received", ErrInvalidSnapshot, s.Position(), s.Size)
+	}
+	return image.LoadSnapshot(s.EndOffset, s.Epoch, s.data.Bytes())
+}
+
+////////////////////
+// Applying metadata records
+////////////////////
+
+// Apply applies a single metadata record. Records of an open metadata transaction are held back
+// until the transaction ends.
+func (i *Image) Apply(record Record) error {
+	switch record.Message.(type) {
+	case *BeginTransactionRecord:
+		if i.inTransaction {
+			return errors.New("BeginTransactionRecord inside a metadata transaction")
+		}
+		i.inTransaction = true
+		i.pending = nil
+		return nil
+	case *EndTransactionRecord:
+		if !i.inTransaction {
+			return errors.New("EndTransactionRecord outside of a metadata transaction")
+		}
+		pending := i.pending
+		i.inTransaction = false
+		i.pending = nil
+		for _, r := range pending {
+			if err := i.apply(r.Message); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *AbortTransactionRecord:
+		if !i.inTransaction {
+			return errors.New("AbortTransactionRecord outside of a metadata transaction")
+		}
+		i.inTransaction = false
+		i.pending = nil
+		return nil
+	}
+
+	if i.inTransaction {
+		i.pending = append(i.pending, record)
+		return nil
+	}
+	return i.apply(record.Message)
+}
+
+func (i *Image) apply(message Message) error {
+	switch m := message.(type) {
+	case *RegisterBrokerRecord:
+		i.Brokers[m.BrokerId] = &Broker{
+			Id:                   m.BrokerId,
+			Epoch:                m.BrokerEpoch,
+			IncarnationId:        m.IncarnationId,
+			EndPoints:            m.EndPoints,
+			Features:             m.Features,
+			Rack:                 m.Rack,
+			Fenced:               m.Fenced,
+			InControlledShutdown: m.InControlledShutdown,
+			IsMigratingZkBroker:  m.IsMigratingZkBroker,
+			LogDirs:              m.LogDirs,
+		}
+	case *UnregisterBrokerRecord:
+		if _, err := i.broker(m.BrokerId, m.BrokerEpoch); err != nil {
+			return err
+		}
+		delete(i.Brokers, m.BrokerId)
+	case *FenceBrokerRecord:
+		broker, err := i.broker(m.Id, m.Epoch)
+		if err != nil {
+			return err
+		}
+		broker.Fenced = true
+	case *UnfenceBrokerRecord:
+		broker, err := i.broker(m.Id, m.Epoch)
+		if err != nil {
+			return err
+		}
+		broker.Fenced = false
+	case *BrokerRegistrationChangeRecord:
+		broker, err := i.broker(m.BrokerId, m.BrokerEpoch)
+		if err != nil {
+			return err
+		}
+		switch m.Fenced {
+		case FencingChangeFence:
+			broker.Fenced = true
+		case FencingChangeUnfence:
+			broker.Fenced = false
+		}
+		if m.InControlledShutdown == ControlledShutdownChangeEnter {
+			broker.InControlledShutdown = true
+		}
+		if m.LogDirs != nil {
+			broker.LogDirs = m.LogDirs
+		}
+	case *RegisterControllerRecord:
+		i.Controllers[m.ControllerId] = &Controller{
+			Id:               m.ControllerId,
+			IncarnationId:    m.IncarnationId,
+			ZkMigrationReady: m.ZkMigrationReady,
+			EndPoints:        m.EndPoints,
+			Features:         m.Features,
+		}
+	case *TopicRecord:
+		if id, ok := i.topicsByName[m.Name]; ok && id != m.TopicId {
+			return fmt.Errorf("topic %s already exists with id %s", m.Name, id)
+		}
+		i.Topics[m.TopicId] = &Topic{Name: m.Name, Id: m.TopicId, Partitions: make(map[int32]*Partition)}
+		i.topicsByName[m.Name] = m.TopicId
+	case *PartitionRecord:
+		topic, err := i.topic(m.TopicId)
+		if err != nil {
+			return err
+		}
+		topic.Partitions[m.PartitionId] = &Partition{
+			Replicas:               m.Replicas,
+			Isr:                    m.Isr,
+			RemovingReplicas:       m.RemovingReplicas,
+			AddingReplicas:         m.AddingReplicas,
+			Leader:                 m.Leader,
+			LeaderRecoveryState:    m.LeaderRecoveryState,
+			LeaderEpoch:            m.LeaderEpoch,
+			PartitionEpoch:         m.PartitionEpoch,
+			Directories:            m.Directories,
+			EligibleLeaderReplicas: m.EligibleLeaderReplicas,
+			LastKnownElr:           m.LastKnownElr,
+		}
+	case *PartitionChangeRecord:
+		topic, err := i.topic(m.TopicId)
+		if err != nil {
+			return err
+		}
+		partition, ok := topic.Partitions[m.PartitionId]
+		if !ok {
+			return fmt.Errorf("unknown partition %s-%d", topic.Name, m.PartitionId)
+		}
+		partition.merge(m)
+	case *RemoveTopicRecord:
+		topic, err := i.topic(m.TopicId)
+		if err != nil {
+			return err
+		}
+		delete(i.Topics, m.TopicId)
+		delete(i.topicsByName, topic.Name)
+		delete(i.Configs, ConfigResource{Type: topicResourceType, Name: topic.Name})
+	case *ClearElrRecord:
+		for _, topic := range i.Topics {
+			if m.TopicName != nil && *m.TopicName != topic.Name {
+				continue
+			}
+			for _, partition := range topic.Partitions {
+				partition.EligibleLeaderReplicas = []int32{}
+				partition.LastKnownElr = []int32{}
+			}
+		}
+	case *ConfigRecord:
+		resource := ConfigResource{Type: m.ResourceType, Name: m.ResourceName}
+		if m.Value == nil {
+			delete(i.Configs[resource], m.Name)
+			if len(i.Configs[resource]) == 0 {
+				delete(i.Configs, resource)
+			}
+		} else {
+			if i.Configs[resource] == nil {
+				i.Configs[resource] = make(map[string]string)
+			}
+			i.Configs[resource][m.Name] = *m.Value
+		}
+	case *FeatureLevelRecord:
+		if m.FeatureLevel == 0 {
+			delete(i.Features, m.Name)
+		} else {
+			i.Features[m.Name] = m.FeatureLevel
+		}
+	case *AccessControlEntryRecord:
+		i.Acls[m.Id] = *m
+	case *RemoveAccessControlEntryRecord:
+		if _, ok := i.Acls[m.Id]; !ok {
+			return fmt.Errorf("unknown ACL %s", m.Id)
+		}
+		delete(i.Acls, m.Id)
+	case *ClientQuotaRecord:
+		entity := ClientQuotaEntity(m.Entity)
+		if m.Remove {
+			delete(i.ClientQuotas[entity], m.Key)
+			if len(i.ClientQuotas[entity]) == 0 {
+				delete(i.ClientQuotas, entity)
+			}
+		} else {
+			if i.ClientQuotas[entity] == nil {
+				i.ClientQuotas[entity] = make(map[string]float64)
+			}
+			i.ClientQuotas[entity][m.Key] = m.Value
+		}
+	case *UserScramCredentialRecord:
+		i.ScramCredentials[ScramCredentialKey{Name: m.Name, Mechanism: m.Mechanism}] = *m
+	case *RemoveUserScramCredentialRecord:
+		delete(i.ScramCredentials, ScramCredentialKey{Name: m.Name, Mechanism: m.Mechanism})
+	case *DelegationTokenRecord:
+		i.DelegationTokens[m.TokenId] = *m
+	case *RemoveDelegationTokenRecord:
+		delete(i.DelegationTokens, m.TokenId)
+	case *ProducerIdsRecord:
+		if m.NextProducerId < i.NextProducerId {
+			return fmt.Errorf("next producer id %d is below the current %d", m.NextProducerId, i.NextProducerId)
+		}
+		i.NextProducerId = m.NextProducerId
+	case *ZkMigrationStateRecord:
+		i.ZkMigrationState = m.ZkMigrationState
+	case *NoOpRecord:
+	default:
+		return fmt.Errorf("cannot apply %s", message.Type())
+	}
+
+	return nil
+}
+
+// topicResourceType is the config resource type of topics.
+const topicResourceType int8 = 2
+
+func (i *Image) topic(id uuid.UUID) (*Topic, error) {
+	topic, ok := i.Topics[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown topic id %s", id)
+	}
+	return topic, nil
+}
+
+func (i *Image) broker(id int32, epoch int64) (*Broker, error) {
+	broker, ok := i.Brokers[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown broker %d", id)
+	}
+	if broker.Epoch != epoch {
+		return nil, fmt.Errorf("broker %d has epoch %d, not %d", id, broker.Epoch, epoch)
+	}
+	return broker, nil
+}
+
+// merge applies a partition change. Every change bumps the partition epoch, and a new leader also
+// bumps the leader epoch.
+func (p *Partition) merge(change *PartitionChangeRecord) {
+	if change.Replicas != nil {
+		p.Replicas = change.Replicas
+	}
+	if change.Isr != nil {
+		p.Isr = change.Isr
+	}
+	if change.RemovingReplicas != nil {
+		p.RemovingReplicas = change.RemovingReplicas
+	}
+	if change.AddingReplicas != nil {
+		p.AddingReplicas = change.AddingReplicas
+	}
+	if change.Leader != NoLeaderChange {
+		p.Leader = change.Leader
+		p.LeaderEpoch++
+	}
+	if change.LeaderRecoveryState != NoLeaderRecoveryStateChange {
+		p.LeaderRecoveryState = change.LeaderRecoveryState
+	}
+	if change.Directories != nil {
+		p.Directories = change.Directories
+	}
+	if change.EligibleLeaderReplicas != nil {
+		p.EligibleLeaderReplicas = change.EligibleLeaderReplicas
+	}
+	if change.LastKnownElr != nil {
+		p.LastKnownElr = change.LastKnownElr
+	}
+	p.PartitionEpoch++
+}
+
+// ClientQuotaEntity returns the key of a client quota entity in Image.ClientQuotas, such as
+// "client-id=app,user=alice". A default entity part has the name <default>.
+func ClientQuotaEntity(entity []EntityData) string {
+	parts := make([]string, 0, len(entity))
+	for _, e := range entity {
+		name := "<default>"
+		if e.EntityName != nil {
+			name = *e.EntityName
+		}
+		parts = append(parts, e.EntityType+"="+name)
+	}
+
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
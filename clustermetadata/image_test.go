@@ -0,0 +1,137 @@
+package clustermetadata
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/fetch"
+	"github.com/scholzj/go-kafka-protocol/api/fetchsnapshot"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+func testSnapshot(t *testing.T) []byte {
+	t.Helper()
+
+	header, _ := NewControlBatch(0, 2, 1000, []ControlMessage{&SnapshotHeaderRecord{LastContainedLogTimestamp: 999}})
+	body, err := NewBatch(1, 2, 1000, []Record{
+		{0, &FeatureLevelRecord{Name: "metadata.version", FeatureLevel: 21}},
+		{3, &RegisterBrokerRecord{BrokerId: 1, BrokerEpoch: 10, EndPoints: []Endpoint{{Name: "PLAIN", Host: "broker-1", Port: 9092}}, Features: []Feature{}}},
+		{0, &TopicRecord{Name: "orders", TopicId: topicId}},
+		{1, &PartitionRecord{PartitionId: 0, TopicId: topicId, Replicas: []int32{1, 2}, Isr: []int32{1, 2}, RemovingReplicas: []int32{}, AddingReplicas: []int32{}, Leader: 1, LeaderEpoch: 3, PartitionEpoch: 5, Directories: []uuid.UUID{dirId, dirId}}},
+		{0, &ConfigRecord{ResourceType: 2, ResourceName: "orders", Name: "retention.ms", Value: ptr("1000")}},
+		{0, &ProducerIdsRecord{BrokerId: 1, BrokerEpoch: 10, NextProducerId: 1000}},
+	})
+	if err != nil {
+		t.Fatalf("NewBatch: %v", err)
+	}
+	footer, _ := NewControlBatch(7, 2, 1000, []ControlMessage{&SnapshotFooterRecord{}})
+
+	data, err := records.WriteRecordBatches([]*records.RecordBatch{header, body, footer})
+	if err != nil {
+		t.Fatalf("WriteRecordBatches: %v", err)
+	}
+	return data
+}
+
+func TestImageFromSnapshotAndLog(t *testing.T) {
+	snapshot := testSnapshot(t)
+
+	// Download the snapshot in two chunks, as FetchSnapshot hands it out.
+	download := NewSnapshotDownload(100, 2)
+	for _, chunk := range [][]byte{snapshot[:40], snapshot[40:]} {
+		err := download.Add(&fetchsnapshot.FetchSnapshotResponseTopicPartition{
+			SnapshotId:       &fetchsnapshot.FetchSnapshotResponseTopicPartitionSnapshotId{EndOffset: 100, Epoch: 2},
+			Size:             int64(len(snapshot)),
+			Position:         download.Position(),
+			UnalignedRecords: &chunk,
+		})
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	image := NewImage()
+	if err := download.LoadInto(image); err != nil {
+		t.Fatalf("LoadInto: %v", err)
+	}
+	if image.NextOffset() != 100 || image.Epoch != 2 || image.Features["metadata.version"] != 21 || image.NextProducerId != 1000 {
+		t.Fatalf("unexpected image after snapshot: offset=%d epoch=%d features=%v", image.Offset, image.Epoch, image.Features)
+	}
+
+	// Follow the log from the snapshot end offset.
+	changes, _ := NewBatch(100, 3, 2000, []Record{
+		{0, &PartitionChangeRecord{TopicId: topicId, PartitionId: 0, Leader: 2, Isr: []int32{2}, LeaderRecoveryState: NoLeaderRecoveryStateChange}},
+		{1, &BrokerRegistrationChangeRecord{BrokerId: 1, BrokerEpoch: 10, Fenced: FencingChangeFence, InControlledShutdown: ControlledShutdownChangeEnter}},
+		{0, &BeginTransactionRecord{}},
+		{0, &ConfigRecord{ResourceType: 2, ResourceName: "orders", Name: "cleanup.policy", Value: ptr("compact")}},
+		{0, &AbortTransactionRecord{}},
+		{0, &BeginTransactionRecord{}},
+		{0, &AccessControlEntryRecord{Id: aclId, ResourceType: 2, ResourceName: "orders", Principal: "User:alice", Host: "*"}},
+		{0, &EndTransactionRecord{}},
+	})
+	leaderChange, _ := NewControlBatch(108, 3, 2000, []ControlMessage{&LeaderChangeMessage{LeaderId: 3000}})
+	uncommitted, _ := NewBatch(109, 3, 2000, []Record{
+		{0, &RemoveTopicRecord{TopicId: topicId}},
+	})
+	data, _ := records.WriteRecordBatches([]*records.RecordBatch{changes, leaderChange, uncommitted})
+
+	partition := &fetch.FetchResponseResponsePartition{HighWatermark: 109, Records: &data}
+	if err := image.ApplyFetchPartition(partition); err != nil {
+		t.Fatalf("ApplyFetchPartition: %v", err)
+	}
+
+	orders := image.TopicByName("orders")
+	if orders == nil {
+		t.Fatal("topic orders is missing")
+	}
+	p := orders.Partitions[0]
+	if p.Leader != 2 || p.LeaderEpoch != 4 || p.PartitionEpoch != 6 || !reflect.DeepEqual(p.Isr, []int32{2}) || !reflect.DeepEqual(p.Replicas, []int32{1, 2}) {
+		t.Errorf("unexpected partition %+v", p)
+	}
+	if broker := image.Brokers[1]; !broker.Fenced || !broker.InControlledShutdown {
+		t.Errorf("unexpected broker %+v", broker)
+	}
+	if _, ok := image.Configs[ConfigResource{Type: 2, Name: "orders"}]["cleanup.policy"]; ok {
+		t.Error("a config from an aborted metadata transaction was applied")
+	}
+	if _, ok := image.Acls[aclId]; !ok {
+		t.Error("the ACL from a committed metadata transaction is missing")
+	}
+	if image.Offset != 108 || image.Epoch != 3 || image.LeaderId != 3000 {
+		t.Errorf("offset=%d epoch=%d leader=%d", image.Offset, image.Epoch, image.LeaderId)
+	}
+
+	// Once the high watermark moves, the next fetch returns the rest, and the topic is removed
+	// together with its configuration.
+	partition.HighWatermark = 110
+	if err := image.ApplyFetchPartition(partition); err != nil {
+		t.Fatalf("ApplyFetchPartition: %v", err)
+	}
+	if image.TopicByName("orders") != nil || len(image.Topics) != 0 || len(image.Configs) != 0 || image.Offset != 109 {
+		t.Errorf("topic was not removed: topics=%v configs=%v offset=%d", image.Topics, image.Configs, image.Offset)
+	}
+}
+
+func TestImageFetchErrors(t *testing.T) {
+	image := NewImage()
+
+	err := image.ApplyFetchPartition(&fetch.FetchResponseResponsePartition{
+		SnapshotId: &fetch.FetchResponseResponsePartitionSnapshotId{EndOffset: 100, Epoch: 2},
+	})
+	if !errors.Is(err, ErrSnapshotRequired) {
+		t.Errorf("ApplyFetchPartition error = %v, want ErrSnapshotRequired", err)
+	}
+
+	body, _ := NewBatch(0, 0, 0, []Record{{0, &TopicRecord{Name: "orders", TopicId: topicId}}})
+	data, _ := records.WriteRecordBatches([]*records.RecordBatch{body})
+	if err := image.LoadSnapshot(1, 0, data); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("LoadSnapshot without header error = %v, want ErrInvalidSnapshot", err)
+	}
+
+	change := Record{0, &PartitionChangeRecord{TopicId: topicId, Leader: NoLeaderChange, LeaderRecoveryState: NoLeaderRecoveryStateChange}}
+	if err := image.Apply(change); err == nil {
+		t.Error("Apply must reject a change of an unknown topic")
+	}
+}
@@ -0,0 +1,244 @@
+package clustermetadata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/scholzj/go-kafka-protocol/compression"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// The records of the KRaft __cluster_metadata log. Each record value is a frame made of the frame
+// version, the record type and the record version (all unsigned varints) followed by the record
+// itself. All metadata records are flexible in every version. Records use nil slices for null
+// arrays; in PartitionChangeRecord a null array means that the field did not change.
+
+// RecordType is the frame type id of a metadata record (the apiKey of its schema).
+type RecordType int16
+
+const (
+	RegisterBrokerRecordType            RecordType = 0
+	UnregisterBrokerRecordType          RecordType = 1
+	TopicRecordType                     RecordType = 2
+	PartitionRecordType                 RecordType = 3
+	ConfigRecordType                    RecordType = 4
+	PartitionChangeRecordType           RecordType = 5
+	AccessControlEntryRecordType        RecordType = 6
+	FenceBrokerRecordType               RecordType = 7
+	UnfenceBrokerRecordType             RecordType = 8
+	RemoveTopicRecordType               RecordType = 9
+	DelegationTokenRecordType           RecordType = 10
+	UserScramCredentialRecordType       RecordType = 11
+	FeatureLevelRecordType              RecordType = 12
+	ClientQuotaRecordType               RecordType = 14
+	ProducerIdsRecordType               RecordType = 15
+	BrokerRegistrationChangeRecordType  RecordType = 17
+	RemoveAccessControlEntryRecordType  RecordType = 18
+	NoOpRecordType                      RecordType = 20
+	ZkMigrationStateRecordType          RecordType = 21
+	RemoveUserScramCredentialRecordType RecordType = 22
+	BeginTransactionRecordType          RecordType = 23
+	EndTransactionRecordType            RecordType = 24
+	AbortTransactionRecordType          RecordType = 25
+	RemoveDelegationTokenRecordType     RecordType = 26
+	RegisterControllerRecordType        RecordType = 27
+	ClearElrRecordType                  RecordType = 28
+)
+
+// FrameVersion is the only metadata record frame version.
+const FrameVersion = 0
+
+var (
+	ErrUnknownRecordType       = errors.New("unknown metadata record type")
+	ErrUnsupportedVersion      = errors.New("unsupported metadata record version")
+	ErrUnsupportedFrameVersion = errors.New("unsupported metadata record frame version")
+)
+
+// Message is a metadata record.
+type Message interface {
+	Type() RecordType
+	decode(d *protocol.Decoder, version int16)
+	encode(e *protocol.Encoder, version int16)
+}
+
+// noTaggedFields is the tagged fields decoder of records without tagged fields.
+func noTaggedFields(*protocol.Decoder, uint64) bool {
+	return false
+}
+
+type schema struct {
+	name       string
+	maxVersion int16
+	newMessage func() Message
+}
+
+var schemas = map[RecordType]schema{
+	RegisterBrokerRecordType:            {"RegisterBrokerRecord", 3, func() Message { return &RegisterBrokerRecord{} }},
+	UnregisterBrokerRecordType:          {"UnregisterBrokerRecord", 0, func() Message { return &UnregisterBrokerRecord{} }},
+	TopicRecordType:                     {"TopicRecord", 0, func() Message { return &TopicRecord{} }},
+	PartitionRecordType:                 {"PartitionRecord", 2, func() Message { return &PartitionRecord{} }},
+	ConfigRecordType:                    {"ConfigRecord", 0, func() Message { return &ConfigRecord{} }},
+	PartitionChangeRecordType:           {"PartitionChangeRecord", 2, func() Message { return &PartitionChangeRecord{} }},
+	AccessControlEntryRecordType:        {"AccessControlEntryRecord", 0, func() Message { return &AccessControlEntryRecord{} }},
+	FenceBrokerRecordType:               {"FenceBrokerRecord", 0, func() Message { return &FenceBrokerRecord{} }},
+	UnfenceBrokerRecordType:             {"UnfenceBrokerRecord", 0, func() Message { return &UnfenceBrokerRecord{} }},
+	RemoveTopicRecordType:               {"RemoveTopicRecord", 0, func() Message { return &RemoveTopicRecord{} }},
+	DelegationTokenRecordType:           {"DelegationTokenRecord", 0, func() Message { return &DelegationTokenRecord{} }},
+	UserScramCredentialRecordType:       {"UserScramCredentialRecord", 0, func() Message { return &UserScramCredentialRecord{} }},
+	FeatureLevelRecordType:              {"FeatureLevelRecord", 0, func() Message { return &FeatureLevelRecord{} }},
+	ClientQuotaRecordType:               {"ClientQuotaRecord", 0, func() Message { return &ClientQuotaRecord{} }},
+	ProducerIdsRecordType:               {"ProducerIdsRecord", 0, func() Message { return &ProducerIdsRecord{} }},
+	BrokerRegistrationChangeRecordType:  {"BrokerRegistrationChangeRecord", 2, func() Message { return &BrokerRegistrationChangeRecord{} }},
+	RemoveAccessControlEntryRecordType:  {"RemoveAccessControlEntryRecord", 0, func() Message { return &RemoveAccessControlEntryRecord{} }},
+	NoOpRecordType:                      {"NoOpRecord", 0, func() Message { return &NoOpRecord{} }},
+	ZkMigrationStateRecordType:          {"ZkMigrationStateRecord", 0, func() Message { return &ZkMigrationStateRecord{} }},
+	RemoveUserScramCredentialRecordType: {"RemoveUserScramCredentialRecord", 0, func() Message { return &RemoveUserScramCredentialRecord{} }},
+	BeginTransactionRecordType:          {"BeginTransactionRecord", 0, func() Message { return &BeginTransactionRecord{} }},
+	EndTransactionRecordType:            {"EndTransactionRecord", 0, func() Message { return &EndTransactionRecord{} }},
+	AbortTransactionRecordType:          {"AbortTransactionRecord", 0, func() Message { return &AbortTransactionRecord{} }},
+	RemoveDelegationTokenRecordType:     {"RemoveDelegationTokenRecord", 0, func() Message { return &RemoveDelegationTokenRecord{} }},
+	RegisterControllerRecordType:        {"RegisterControllerRecord", 0, func() Message { return &RegisterControllerRecord{} }},
+	ClearElrRecordType:                  {"ClearElrRecord", 0, func() Message { return &ClearElrRecord{} }},
+}
+
+func (t RecordType) String() string {
+	if s, ok := schemas[t]; ok {
+		return s.name
+	}
+	return fmt.Sprintf("UnknownRecord(%d)", int16(t))
+}
+
+// HighestSupportedVersion returns the highest version of the record type this package can decode,
+// or -1 for an unknown type.
+func (t RecordType) HighestSupportedVersion() int16 {
+	if s, ok := schemas[t]; ok {
+		return s.maxVersion
+	}
+	return -1
+}
+
+// NewMessage returns an empty record of the given type, or nil for an unknown type.
+func NewMessage(t RecordType) Message {
+	if s, ok := schemas[t]; ok {
+		return s.newMessage()
+	}
+	return nil
+}
+
+// Record is a metadata record together with the version it is serialized in.
+type Record struct {
+	Version int16
+	Message Message
+}
+
+// DecodeRecord decodes the value of a metadata log record.
+func DecodeRecord(value []byte) (Record, error) {
+	r := bytes.NewReader(value)
+
+	frameVersion, err := protocol.ReadUvarint(r)
+	if err != nil {
+		return Record{}, fmt.Errorf("metadata record frame: %w", err)
+	}
+	if frameVersion != FrameVersion {
+		return Record{}, fmt.Errorf("%w %d", ErrUnsupportedFrameVersion, frameVersion)
+	}
+
+	recordType, err := protocol.ReadUvarint(r)
+	if err != nil {
+		return Record{}, fmt.Errorf("metadata record frame: %w", err)
+	}
+	version, err := protocol.ReadUvarint(r)
+	if err != nil {
+		return Record{}, fmt.Errorf("metadata record frame: %w", err)
+	}
+
+	t := RecordType(recordType)
+	s, ok := schemas[t]
+	if !ok || recordType > 0x7fff {
+		return Record{}, fmt.Errorf("%w %d", ErrUnknownRecordType, recordType)
+	}
+	if version > uint64(s.maxVersion) {
+		return Record{}, fmt.Errorf("%w: %s version %d", ErrUnsupportedVersion, t, version)
+	}
+
+	message := s.newMessage()
+	d := protocol.NewDecoder(r, true)
+	message.decode(d, int16(version))
+	if err := d.Err(); err != nil {
+		return Record{}, fmt.Errorf("%s version %d: %w", t, version, err)
+	}
+	if r.Len() != 0 {
+		return Record{}, fmt.Errorf("%s version %d: %d bytes after the record", t, version, r.Len())
+	}
+
+	return Record{Version: int16(version), Message: message}, nil
+}
+
+// EncodeRecord encodes a metadata record into the value of a metadata log record.
+func EncodeRecord(record Record) ([]byte, error) {
+	if record.Message == nil {
+		return nil, errors.New("metadata record has no message")
+	}
+
+	t := record.Message.Type()
+	if record.Version < 0 || record.Version > t.HighestSupportedVersion() {
+		return nil, fmt.Errorf("%w: %s version %d", ErrUnsupportedVersion, t, record.Version)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0))
+	if err := protocol.WriteUvarint(buf, FrameVersion); err != nil {
+		return nil, err
+	}
+	if err := protocol.WriteUvarint(buf, uint64(t)); err != nil {
+		return nil, err
+	}
+	if err := protocol.WriteUvarint(buf, uint64(record.Version)); err != nil {
+		return nil, err
+	}
+
+	e := protocol.NewEncoder(buf, true)
+	record.Message.encode(e, record.Version)
+	if err := e.Err(); err != nil {
+		return nil, fmt.Errorf("%s version %d: %w", t, record.Version, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DecodeBatch decodes the metadata records of a batch from the metadata log. Control batches carry
+// no metadata records and decode to an empty slice; use DecodeControlRecord for their records.
+func DecodeBatch(batch *records.RecordBatch) ([]Record, error) {
+	decoded := make([]Record, 0, len(batch.Records))
+	if batch.IsControl() {
+		return decoded, nil
+	}
+
+	for _, record := range batch.Records {
+		r, err := DecodeRecord(record.Value)
+		if err != nil {
+			return nil, fmt.Errorf("offset %d: %w", batch.Offset(record), err)
+		}
+		decoded = append(decoded, r)
+	}
+
+	return decoded, nil
+}
+
+// NewBatch encodes metadata records into a batch, as the active controller appends them to the
+// metadata log.
+func NewBatch(baseOffset int64, leaderEpoch int32, timestamp int64, metadataRecords []Record) (*records.RecordBatch, error) {
+	batch := records.NewRecordBatch(baseOffset, compression.None)
+	batch.PartitionLeaderEpoch = leaderEpoch
+
+	for _, record := range metadataRecords {
+		value, err := EncodeRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		batch.AppendRecord(timestamp, nil, value, nil)
+	}
+
+	return batch, nil
+}
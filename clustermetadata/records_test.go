@@ -0,0 +1,195 @@
+package clustermetadata
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+var (
+	topicId  = uuid.MustParse("5d5e6f70-8192-4a3b-8c4d-5e6f708192a3")
+	dirId    = uuid.MustParse("0a1b2c3d-4e5f-4071-8293-a4b5c6d7e8f9")
+	aclId    = uuid.MustParse("11111111-2222-4333-8444-555555555555")
+	brokerId = uuid.MustParse("99999999-8888-4777-8666-555555555555")
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+	tests := []Record{
+		{0, &RegisterBrokerRecord{BrokerId: 1, IncarnationId: brokerId, BrokerEpoch: 10, EndPoints: []Endpoint{{Name: "PLAIN", Host: "broker-1", Port: 9092}}, Features: []Feature{{Name: "metadata.version", MinSupportedVersion: 1, MaxSupportedVersion: 21}}, Rack: ptr("rack-a"), Fenced: true}},
+		{3, &RegisterBrokerRecord{BrokerId: 1, IsMigratingZkBroker: true, IncarnationId: brokerId, BrokerEpoch: 10, EndPoints: []Endpoint{}, Features: []Feature{}, InControlledShutdown: true, LogDirs: []uuid.UUID{dirId}}},
+		{0, &UnregisterBrokerRecord{BrokerId: 1, BrokerEpoch: 10}},
+		{0, &TopicRecord{Name: "orders", TopicId: topicId}},
+		{0, &PartitionRecord{PartitionId: 2, TopicId: topicId, Replicas: []int32{1, 2, 3}, Isr: []int32{1, 2}, RemovingReplicas: []int32{}, AddingReplicas: []int32{}, Leader: 1, LeaderRecoveryState: LeaderRecovering, LeaderEpoch: 4, PartitionEpoch: 7}},
+		{2, &PartitionRecord{PartitionId: 2, TopicId: topicId, Replicas: []int32{1}, Isr: []int32{1}, RemovingReplicas: []int32{}, AddingReplicas: []int32{}, Leader: 1, Directories: []uuid.UUID{dirId}, EligibleLeaderReplicas: []int32{}, LastKnownElr: []int32{3}}},
+		{0, &ConfigRecord{ResourceType: 2, ResourceName: "orders", Name: "retention.ms", Value: ptr("1000")}},
+		{0, &ConfigRecord{ResourceType: 2, ResourceName: "orders", Name: "retention.ms"}},
+		{0, &PartitionChangeRecord{PartitionId: 2, TopicId: topicId, Isr: []int32{1}, Leader: NoLeaderChange, LeaderRecoveryState: NoLeaderRecoveryStateChange}},
+		{2, &PartitionChangeRecord{PartitionId: 2, TopicId: topicId, Leader: 3, Replicas: []int32{3, 1}, RemovingReplicas: []int32{2}, AddingReplicas: []int32{3}, LeaderRecoveryState: LeaderRecovered, EligibleLeaderReplicas: []int32{}, LastKnownElr: []int32{2}, Directories: []uuid.UUID{dirId, dirId}}},
+		{0, &AccessControlEntryRecord{Id: aclId, ResourceType: 2, ResourceName: "orders", PatternType: 3, Principal: "User:alice", Host: "*", Operation: 3, PermissionType: 3}},
+		{0, &FenceBrokerRecord{Id: 1, Epoch: 10}},
+		{0, &UnfenceBrokerRecord{Id: 1, Epoch: 10}},
+		{0, &RemoveTopicRecord{TopicId: topicId}},
+		{0, &DelegationTokenRecord{Owner: "User:alice", Requester: "User:alice", Renewers: []string{"User:bob"}, IssueTimestamp: 1, MaxTimestamp: 3, ExpirationTimestamp: 2, TokenId: "token"}},
+		{0, &UserScramCredentialRecord{Name: "alice", Mechanism: 1, Salt: []byte("salt"), StoredKey: []byte("stored"), ServerKey: []byte("server"), Iterations: 4096}},
+		{0, &FeatureLevelRecord{Name: "metadata.version", FeatureLevel: 21}},
+		{0, &ClientQuotaRecord{Entity: []EntityData{{EntityType: "user", EntityName: ptr("alice")}, {EntityType: "client-id"}}, Key: "producer_byte_rate", Value: 1024.5}},
+		{0, &ProducerIdsRecord{BrokerId: 1, BrokerEpoch: 10, NextProducerId: 2000}},
+		{2, &BrokerRegistrationChangeRecord{BrokerId: 1, BrokerEpoch: 10, Fenced: FencingChangeUnfence, InControlledShutdown: ControlledShutdownChangeEnter, LogDirs: []uuid.UUID{dirId}}},
+		{0, &RemoveAccessControlEntryRecord{Id: aclId}},
+		{0, &NoOpRecord{}},
+		{0, &ZkMigrationStateRecord{ZkMigrationState: 2}},
+		{0, &RemoveUserScramCredentialRecord{Name: "alice", Mechanism: 1}},
+		{0, &BeginTransactionRecord{Name: ptr("bootstrap")}},
+		{0, &EndTransactionRecord{}},
+		{0, &AbortTransactionRecord{Reason: ptr("controller failover")}},
+		{0, &RemoveDelegationTokenRecord{TokenId: "token"}},
+		{0, &RegisterControllerRecord{ControllerId: 3000, IncarnationId: brokerId, ZkMigrationReady: true, EndPoints: []Endpoint{{Name: "CONTROLLER", Host: "controller", Port: 9093}}, Features: []Feature{}}},
+		{0, &ClearElrRecord{TopicName: ptr("orders")}},
+	}
+
+	covered := make(map[RecordType]bool)
+	for _, test := range tests {
+		t.Run(test.Message.Type().String(), func(t *testing.T) {
+			value, err := EncodeRecord(test)
+			if err != nil {
+				t.Fatalf("EncodeRecord: %v", err)
+			}
+
+			decoded, err := DecodeRecord(value)
+			if err != nil {
+				t.Fatalf("DecodeRecord: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, test) {
+				t.Errorf("round trip mismatch:\n got %+v\nwant %+v", decoded.Message, test.Message)
+			}
+		})
+		covered[test.Message.Type()] = true
+	}
+
+	for recordType := range schemas {
+		if !covered[recordType] {
+			t.Errorf("%s is not covered by the round trip test", recordType)
+		}
+	}
+}
+
+func TestDecodeRecordKnownBytes(t *testing.T) {
+	value := []byte{
+		0, 2, 0, // frame version, TopicRecord, version 0
+		4, 'f', 'o', 'o', // name
+	}
+	value = append(value, topicId[:]...)
+	value = append(value, 0) // no tagged fields
+
+	record, err := DecodeRecord(value)
+	if err != nil {
+		t.Fatalf("DecodeRecord: %v", err)
+	}
+	if topic, ok := record.Message.(*TopicRecord); !ok || topic.Name != "foo" || topic.TopicId != topicId {
+		t.Errorf("unexpected record %+v", record.Message)
+	}
+
+	encoded, _ := EncodeRecord(record)
+	if !bytes.Equal(encoded, value) {
+		t.Errorf("EncodeRecord:\n got %x\nwant %x", encoded, value)
+	}
+}
+
+func TestDecodeRecordSkipsUnknownTaggedFields(t *testing.T) {
+	value := []byte{0, 9, 0}
+	value = append(value, topicId[:]...)
+	value = append(value, 1, 42, 2, 0xaa, 0xbb) // tag 42 with two bytes
+
+	record, err := DecodeRecord(value)
+	if err != nil {
+		t.Fatalf("DecodeRecord: %v", err)
+	}
+	if remove := record.Message.(*RemoveTopicRecord); remove.TopicId != topicId {
+		t.Errorf("unexpected record %+v", remove)
+	}
+}
+
+func TestDecodeRecordErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		value []byte
+		want  error
+	}{
+		{"frame version", []byte{1, 2, 0}, ErrUnsupportedFrameVersion},
+		{"unknown type", []byte{0, 13, 0, 0}, ErrUnknownRecordType},
+		{"unsupported version", []byte{0, 2, 1}, ErrUnsupportedVersion},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := DecodeRecord(test.value); !errors.Is(err, test.want) {
+				t.Errorf("DecodeRecord error = %v, want %v", err, test.want)
+			}
+		})
+	}
+
+	if _, err := DecodeRecord([]byte{0, 2, 0, 4, 'f'}); err == nil {
+		t.Error("DecodeRecord must fail on a truncated record")
+	}
+}
+
+func TestBatches(t *testing.T) {
+	metadata := []Record{
+		{0, &TopicRecord{Name: "orders", TopicId: topicId}},
+		{1, &PartitionRecord{PartitionId: 0, TopicId: topicId, Replicas: []int32{1}, Isr: []int32{1}, RemovingReplicas: []int32{}, AddingReplicas: []int32{}, Leader: 1, Directories: []uuid.UUID{dirId}}},
+	}
+	batch, err := NewBatch(10, 3, 1000, metadata)
+	if err != nil {
+		t.Fatalf("NewBatch: %v", err)
+	}
+
+	control, err := NewControlBatch(12, 3, 1000, []ControlMessage{
+		&LeaderChangeMessage{LeaderId: 3000, Voters: []int32{3000, 3001}, GrantingVoters: []int32{3001}},
+		&VotersRecord{Voters: []Voter{{VoterId: 3000, VoterDirectoryId: dirId, Endpoints: []VoterEndpoint{{Name: "CONTROLLER", Host: "controller", Port: 9093}}, MaxSupportedKRaftVersion: 1}}},
+	})
+	if err != nil {
+		t.Fatalf("NewControlBatch: %v", err)
+	}
+
+	data, _ := records.WriteRecordBatches([]*records.RecordBatch{batch, control})
+	batches, err := records.ReadRecordBatches(data)
+	if err != nil {
+		t.Fatalf("ReadRecordBatches: %v", err)
+	}
+
+	decoded, err := DecodeBatch(batches[0])
+	if err != nil {
+		t.Fatalf("DecodeBatch: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, metadata) {
+		t.Errorf("DecodeBatch = %+v", decoded)
+	}
+
+	if decoded, _ := DecodeBatch(batches[1]); len(decoded) != 0 {
+		t.Errorf("a control batch decoded to %d metadata records", len(decoded))
+	}
+
+	leaderChange, err := DecodeControlRecord(batches[1].Records[0])
+	if err != nil {
+		t.Fatalf("DecodeControlRecord: %v", err)
+	}
+	if lc, ok := leaderChange.(*LeaderChangeMessage); !ok || lc.LeaderId != 3000 || !reflect.DeepEqual(lc.GrantingVoters, []int32{3001}) {
+		t.Errorf("unexpected leader change %+v", leaderChange)
+	}
+
+	voters, err := DecodeControlRecord(batches[1].Records[1])
+	if err != nil {
+		t.Fatalf("DecodeControlRecord: %v", err)
+	}
+	if v, ok := voters.(*VotersRecord); !ok || len(v.Voters) != 1 || v.Voters[0].Endpoints[0].Port != 9093 || v.Voters[0].MaxSupportedKRaftVersion != 1 {
+		t.Errorf("unexpected voters %+v", voters)
+	}
+}
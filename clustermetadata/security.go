@@ -0,0 +1,217 @@
+package clustermetadata
+
+import (
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+////////////////////
+// ACL, credential, delegation token and quota records
+////////////////////
+
+// AccessControlEntryRecord creates an ACL.
+type AccessControlEntryRecord struct {
+	Id             uuid.UUID // The ACL ID. (versions: 0+)
+	ResourceType   int8      // The resource type. (versions: 0+)
+	ResourceName   string    // The resource name. (versions: 0+)
+	PatternType    int8      // The pattern type (literal, prefixed, etc.) (versions: 0+)
+	Principal      string    // The principal. (versions: 0+)
+	Host           string    // The host. (versions: 0+)
+	Operation      int8      // The operation type. (versions: 0+)
+	PermissionType int8      // The permission type (allow, deny). (versions: 0+)
+}
+
+func (m *AccessControlEntryRecord) Type() RecordType { return AccessControlEntryRecordType }
+
+func (m *AccessControlEntryRecord) decode(d *protocol.Decoder, version int16) {
+	m.Id = d.UUID()
+	m.ResourceType = d.Int8()
+	m.ResourceName = d.String()
+	m.PatternType = d.Int8()
+	m.Principal = d.String()
+	m.Host = d.String()
+	m.Operation = d.Int8()
+	m.PermissionType = d.Int8()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *AccessControlEntryRecord) encode(e *protocol.Encoder, version int16) {
+	e.UUID(m.Id)
+	e.Int8(m.ResourceType)
+	e.String(m.ResourceName)
+	e.Int8(m.PatternType)
+	e.String(m.Principal)
+	e.String(m.Host)
+	e.Int8(m.Operation)
+	e.Int8(m.PermissionType)
+	e.TaggedFields(nil)
+}
+
+// RemoveAccessControlEntryRecord deletes an ACL.
+type RemoveAccessControlEntryRecord struct {
+	Id uuid.UUID // The ID of the ACL to remove. (versions: 0+)
+}
+
+func (m *RemoveAccessControlEntryRecord) Type() RecordType {
+	return RemoveAccessControlEntryRecordType
+}
+
+func (m *RemoveAccessControlEntryRecord) decode(d *protocol.Decoder, version int16) {
+	m.Id = d.UUID()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *RemoveAccessControlEntryRecord) encode(e *protocol.Encoder, version int16) {
+	e.UUID(m.Id)
+	e.TaggedFields(nil)
+}
+
+// UserScramCredentialRecord creates or replaces the SCRAM credential of a user for one mechanism.
+type UserScramCredentialRecord struct {
+	Name       string // The user name. (versions: 0+)
+	Mechanism  int8   // The SCRAM mechanism. (versions: 0+)
+	Salt       []byte // A random salt generated by the client. (versions: 0+)
+	StoredKey  []byte // The key used to verify the client proof. (versions: 0+)
+	ServerKey  []byte // The key used to compute the server signature. (versions: 0+)
+	Iterations int32  // The number of iterations used in the SCRAM credential. (versions: 0+)
+}
+
+func (m *UserScramCredentialRecord) Type() RecordType { return UserScramCredentialRecordType }
+
+func (m *UserScramCredentialRecord) decode(d *protocol.Decoder, version int16) {
+	m.Name = d.String()
+	m.Mechanism = d.Int8()
+	m.Salt = d.Bytes()
+	m.StoredKey = d.Bytes()
+	m.ServerKey = d.Bytes()
+	m.Iterations = d.Int32()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *UserScramCredentialRecord) encode(e *protocol.Encoder, version int16) {
+	e.String(m.Name)
+	e.Int8(m.Mechanism)
+	e.Bytes(m.Salt)
+	e.Bytes(m.StoredKey)
+	e.Bytes(m.ServerKey)
+	e.Int32(m.Iterations)
+	e.TaggedFields(nil)
+}
+
+// RemoveUserScramCredentialRecord deletes the SCRAM credential of a user for one mechanism.
+type RemoveUserScramCredentialRecord struct {
+	Name      string // The user name. (versions: 0+)
+	Mechanism int8   // The SCRAM mechanism. (versions: 0+)
+}
+
+func (m *RemoveUserScramCredentialRecord) Type() RecordType {
+	return RemoveUserScramCredentialRecordType
+}
+
+func (m *RemoveUserScramCredentialRecord) decode(d *protocol.Decoder, version int16) {
+	m.Name = d.String()
+	m.Mechanism = d.Int8()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *RemoveUserScramCredentialRecord) encode(e *protocol.Encoder, version int16) {
+	e.String(m.Name)
+	e.Int8(m.Mechanism)
+	e.TaggedFields(nil)
+}
+
+// DelegationTokenRecord creates or renews a delegation token.
+type DelegationTokenRecord struct {
+	Owner               string   // The delegation token owner. (versions: 0+)
+	Requester           string   // The principal that requested the token. (versions: 0+)
+	Renewers            []string // The principals which have renewed this token. (versions: 0+)
+	IssueTimestamp      int64    // The time at which this timestamp was issued. (versions: 0+)
+	MaxTimestamp        int64    // The time at which this token cannot be renewed any more. (versions: 0+)
+	ExpirationTimestamp int64    // The next time at which this token must be renewed. (versions: 0+)
+	TokenId             string   // The token id. (versions: 0+)
+}
+
+func (m *DelegationTokenRecord) Type() RecordType { return DelegationTokenRecordType }
+
+func (m *DelegationTokenRecord) decode(d *protocol.Decoder, version int16) {
+	m.Owner = d.String()
+	m.Requester = d.String()
+	m.Renewers = protocol.DecodeArray(d, (*protocol.Decoder).String)
+	m.IssueTimestamp = d.Int64()
+	m.MaxTimestamp = d.Int64()
+	m.ExpirationTimestamp = d.Int64()
+	m.TokenId = d.String()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *DelegationTokenRecord) encode(e *protocol.Encoder, version int16) {
+	e.String(m.Owner)
+	e.String(m.Requester)
+	protocol.EncodeArray(e, m.Renewers, (*protocol.Encoder).String)
+	e.Int64(m.IssueTimestamp)
+	e.Int64(m.MaxTimestamp)
+	e.Int64(m.ExpirationTimestamp)
+	e.String(m.TokenId)
+	e.TaggedFields(nil)
+}
+
+// RemoveDelegationTokenRecord deletes a delegation token.
+type RemoveDelegationTokenRecord struct {
+	TokenId string // The delegation token id. (versions: 0+)
+}
+
+func (m *RemoveDelegationTokenRecord) Type() RecordType { return RemoveDelegationTokenRecordType }
+
+func (m *RemoveDelegationTokenRecord) decode(d *protocol.Decoder, version int16) {
+	m.TokenId = d.String()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *RemoveDelegationTokenRecord) encode(e *protocol.Encoder, version int16) {
+	e.String(m.TokenId)
+	e.TaggedFields(nil)
+}
+
+// EntityData is one part of a client quota entity. A null name is the default entity.
+type EntityData struct {
+	EntityType string  // The entity type. (versions: 0+)
+	EntityName *string // The name of the entity, or null if the default. (versions: 0+, nullable: 0+)
+}
+
+func decodeEntityData(d *protocol.Decoder) EntityData {
+	entity := EntityData{EntityType: d.String(), EntityName: d.NullableString()}
+	d.TaggedFields(noTaggedFields)
+	return entity
+}
+
+func encodeEntityData(e *protocol.Encoder, entity EntityData) {
+	e.String(entity.EntityType)
+	e.NullableString(entity.EntityName)
+	e.TaggedFields(nil)
+}
+
+// ClientQuotaRecord sets or removes a client quota.
+type ClientQuotaRecord struct {
+	Entity []EntityData // The quota entity to alter. (versions: 0+)
+	Key    string       // The quota configuration key. (versions: 0+)
+	Value  float64      // The value to set, otherwise ignored if the value is to be removed. (versions: 0+)
+	Remove bool         // Whether the quota configuration value should be removed, otherwise set. (versions: 0+)
+}
+
+func (m *ClientQuotaRecord) Type() RecordType { return ClientQuotaRecordType }
+
+func (m *ClientQuotaRecord) decode(d *protocol.Decoder, version int16) {
+	m.Entity = protocol.DecodeArray(d, decodeEntityData)
+	m.Key = d.String()
+	m.Value = d.Float64()
+	m.Remove = d.Bool()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ClientQuotaRecord) encode(e *protocol.Encoder, version int16) {
+	protocol.EncodeArray(e, m.Entity, encodeEntityData)
+	e.String(m.Key)
+	e.Float64(m.Value)
+	e.Bool(m.Remove)
+	e.TaggedFields(nil)
+}
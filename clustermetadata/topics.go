@@ -0,0 +1,272 @@
+package clustermetadata
+
+import (
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+////////////////////
+// Topic and partition records
+////////////////////
+
+const (
+	// NoLeader is the leader of a partition without a leader.
+	NoLeader int32 = -1
+
+	// NoLeaderChange is the PartitionChangeRecord leader when the leader did not change.
+	NoLeaderChange int32 = -2
+
+	// NoLeaderRecoveryStateChange is the PartitionChangeRecord leader recovery state when it did
+	// not change.
+	NoLeaderRecoveryStateChange int8 = -1
+)
+
+// Leader recovery states.
+const (
+	LeaderRecovered  int8 = 0
+	LeaderRecovering int8 = 1
+)
+
+func decodeInt32s(d *protocol.Decoder) []int32 {
+	return protocol.DecodeArray(d, (*protocol.Decoder).Int32)
+}
+
+func encodeInt32s(e *protocol.Encoder, values []int32) {
+	protocol.EncodeArray(e, values, (*protocol.Encoder).Int32)
+}
+
+func encodeNullableInt32s(e *protocol.Encoder, values []int32) {
+	protocol.EncodeNullableArray(e, values, (*protocol.Encoder).Int32)
+}
+
+func decodeUUIDs(d *protocol.Decoder) []uuid.UUID {
+	return protocol.DecodeArray(d, (*protocol.Decoder).UUID)
+}
+
+func encodeUUIDs(e *protocol.Encoder, values []uuid.UUID) {
+	protocol.EncodeArray(e, values, (*protocol.Encoder).UUID)
+}
+
+func encodeNullableUUIDs(e *protocol.Encoder, values []uuid.UUID) {
+	protocol.EncodeNullableArray(e, values, (*protocol.Encoder).UUID)
+}
+
+// TopicRecord creates a topic.
+type TopicRecord struct {
+	Name    string    // The topic name. (versions: 0+)
+	TopicId uuid.UUID // The unique ID of this topic. (versions: 0+)
+}
+
+func (m *TopicRecord) Type() RecordType { return TopicRecordType }
+
+func (m *TopicRecord) decode(d *protocol.Decoder, version int16) {
+	m.Name = d.String()
+	m.TopicId = d.UUID()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *TopicRecord) encode(e *protocol.Encoder, version int16) {
+	e.String(m.Name)
+	e.UUID(m.TopicId)
+	e.TaggedFields(nil)
+}
+
+// PartitionRecord creates a partition or replaces its whole state (in snapshots).
+type PartitionRecord struct {
+	PartitionId            int32       // The partition id. (versions: 0+)
+	TopicId                uuid.UUID   // The unique ID of this topic. (versions: 0+)
+	Replicas               []int32     // The replicas of this partition, sorted by preferred order. (versions: 0+)
+	Isr                    []int32     // The in-sync replicas of this partition. (versions: 0+)
+	RemovingReplicas       []int32     // The replicas that we are in the process of removing. (versions: 0+)
+	AddingReplicas         []int32     // The replicas that we are in the process of adding. (versions: 0+)
+	Leader                 int32       // The lead replica, or -1 if there is no leader. (versions: 0+)
+	LeaderRecoveryState    int8        // tag 0: 1 if the partition is recovering from an unclean leader election; 0 otherwise. (versions: 0+)
+	LeaderEpoch            int32       // The epoch of the partition leader. (versions: 0+)
+	PartitionEpoch         int32       // An epoch that gets incremented each time we change anything in the partition. (versions: 0+)
+	Directories            []uuid.UUID // The log directory hosting each replica, sorted in the same exact order as the Replicas field. (versions: 1+)
+	EligibleLeaderReplicas []int32     // tag 1: The eligible leader replicas of this partition. (versions: 2+, nullable: 2+)
+	LastKnownElr           []int32     // tag 2: The last known eligible leader replicas of this partition. (versions: 2+, nullable: 2+)
+}
+
+func (m *PartitionRecord) Type() RecordType { return PartitionRecordType }
+
+func (m *PartitionRecord) decode(d *protocol.Decoder, version int16) {
+	m.Leader = NoLeader
+	m.LeaderEpoch = -1
+
+	m.PartitionId = d.Int32()
+	m.TopicId = d.UUID()
+	m.Replicas = decodeInt32s(d)
+	m.Isr = decodeInt32s(d)
+	m.RemovingReplicas = decodeInt32s(d)
+	m.AddingReplicas = decodeInt32s(d)
+	m.Leader = d.Int32()
+	m.LeaderEpoch = d.Int32()
+	m.PartitionEpoch = d.Int32()
+	if version >= 1 {
+		m.Directories = decodeUUIDs(d)
+	}
+
+	d.TaggedFields(func(d *protocol.Decoder, tag uint64) bool {
+		switch {
+		case tag == 0:
+			m.LeaderRecoveryState = d.Int8()
+		case tag == 1 && version >= 2:
+			m.EligibleLeaderReplicas = decodeInt32s(d)
+		case tag == 2 && version >= 2:
+			m.LastKnownElr = decodeInt32s(d)
+		default:
+			return false
+		}
+		return true
+	})
+}
+
+func (m *PartitionRecord) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.PartitionId)
+	e.UUID(m.TopicId)
+	encodeInt32s(e, m.Replicas)
+	encodeInt32s(e, m.Isr)
+	encodeInt32s(e, m.RemovingReplicas)
+	encodeInt32s(e, m.AddingReplicas)
+	e.Int32(m.Leader)
+	e.Int32(m.LeaderEpoch)
+	e.Int32(m.PartitionEpoch)
+	if version >= 1 {
+		encodeUUIDs(e, m.Directories)
+	}
+
+	var tagged []protocol.TaggedField
+	if m.LeaderRecoveryState != LeaderRecovered {
+		tagged = e.Tagged(tagged, 0, func(e *protocol.Encoder) { e.Int8(m.LeaderRecoveryState) })
+	}
+	if version >= 2 && m.EligibleLeaderReplicas != nil {
+		tagged = e.Tagged(tagged, 1, func(e *protocol.Encoder) { encodeNullableInt32s(e, m.EligibleLeaderReplicas) })
+	}
+	if version >= 2 && m.LastKnownElr != nil {
+		tagged = e.Tagged(tagged, 2, func(e *protocol.Encoder) { encodeNullableInt32s(e, m.LastKnownElr) })
+	}
+	e.TaggedFields(tagged)
+}
+
+// PartitionChangeRecord changes the state of a partition. Fields that did not change are null (nil
+// arrays), NoLeaderChange or NoLeaderRecoveryStateChange.
+type PartitionChangeRecord struct {
+	PartitionId            int32       // The partition id. (versions: 0+)
+	TopicId                uuid.UUID   // The unique ID of this topic. (versions: 0+)
+	Isr                    []int32     // tag 0: null if the ISR didn't change; the new in-sync replicas otherwise. (versions: 0+, nullable: 0+)
+	Leader                 int32       // tag 1: -1 if there is now no leader; -2 if the leader didn't change; the new leader otherwise. (versions: 0+)
+	Replicas               []int32     // tag 2: null if the replicas didn't change; the new replicas otherwise. (versions: 0+, nullable: 0+)
+	RemovingReplicas       []int32     // tag 3: null if the removing replicas didn't change; the new removing replicas otherwise. (versions: 0+, nullable: 0+)
+	AddingReplicas         []int32     // tag 4: null if the adding replicas didn't change; the new adding replicas otherwise. (versions: 0+, nullable: 0+)
+	LeaderRecoveryState    int8        // tag 5: -1 if it didn't change; 0 if the leader was elected from the ISR or recovered from an unclean election; 1 if the leader that was elected using unclean leader election and it is still recovering. (versions: 0+)
+	EligibleLeaderReplicas []int32     // tag 6: null if the ELR didn't change; the new eligible leader replicas otherwise. (versions: 1+, nullable: 1+)
+	LastKnownElr           []int32     // tag 7: null if the LastKnownElr didn't change; the last known eligible leader replicas otherwise. (versions: 1+, nullable: 1+)
+	Directories            []uuid.UUID // tag 8: null if the log dirs didn't change; the new log directory for each replica otherwise. (versions: 2+, nullable: 2+)
+}
+
+func (m *PartitionChangeRecord) Type() RecordType { return PartitionChangeRecordType }
+
+func (m *PartitionChangeRecord) decode(d *protocol.Decoder, version int16) {
+	m.Leader = NoLeaderChange
+	m.LeaderRecoveryState = NoLeaderRecoveryStateChange
+
+	m.PartitionId = d.Int32()
+	m.TopicId = d.UUID()
+
+	d.TaggedFields(func(d *protocol.Decoder, tag uint64) bool {
+		switch {
+		case tag == 0:
+			m.Isr = decodeInt32s(d)
+		case tag == 1:
+			m.Leader = d.Int32()
+		case tag == 2:
+			m.Replicas = decodeInt32s(d)
+		case tag == 3:
+			m.RemovingReplicas = decodeInt32s(d)
+		case tag == 4:
+			m.AddingReplicas = decodeInt32s(d)
+		case tag == 5:
+			m.LeaderRecoveryState = d.Int8()
+		case tag == 6 && version >= 1:
+			m.EligibleLeaderReplicas = decodeInt32s(d)
+		case tag == 7 && version >= 1:
+			m.LastKnownElr = decodeInt32s(d)
+		case tag == 8 && version >= 2:
+			m.Directories = decodeUUIDs(d)
+		default:
+			return false
+		}
+		return true
+	})
+}
+
+func (m *PartitionChangeRecord) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.PartitionId)
+	e.UUID(m.TopicId)
+
+	var tagged []protocol.TaggedField
+	if m.Isr != nil {
+		tagged = e.Tagged(tagged, 0, func(e *protocol.Encoder) { encodeNullableInt32s(e, m.Isr) })
+	}
+	if m.Leader != NoLeaderChange {
+		tagged = e.Tagged(tagged, 1, func(e *protocol.Encoder) { e.Int32(m.Leader) })
+	}
+	if m.Replicas != nil {
+		tagged = e.Tagged(tagged, 2, func(e *protocol.Encoder) { encodeNullableInt32s(e, m.Replicas) })
+	}
+	if m.RemovingReplicas != nil {
+		tagged = e.Tagged(tagged, 3, func(e *protocol.Encoder) { encodeNullableInt32s(e, m.RemovingReplicas) })
+	}
+	if m.AddingReplicas != nil {
+		tagged = e.Tagged(tagged, 4, func(e *protocol.Encoder) { encodeNullableInt32s(e, m.AddingReplicas) })
+	}
+	if m.LeaderRecoveryState != NoLeaderRecoveryStateChange {
+		tagged = e.Tagged(tagged, 5, func(e *protocol.Encoder) { e.Int8(m.LeaderRecoveryState) })
+	}
+	if version >= 1 && m.EligibleLeaderReplicas != nil {
+		tagged = e.Tagged(tagged, 6, func(e *protocol.Encoder) { encodeNullableInt32s(e, m.EligibleLeaderReplicas) })
+	}
+	if version >= 1 && m.LastKnownElr != nil {
+		tagged = e.Tagged(tagged, 7, func(e *protocol.Encoder) { encodeNullableInt32s(e, m.LastKnownElr) })
+	}
+	if version >= 2 && m.Directories != nil {
+		tagged = e.Tagged(tagged, 8, func(e *protocol.Encoder) { encodeNullableUUIDs(e, m.Directories) })
+	}
+	e.TaggedFields(tagged)
+}
+
+// RemoveTopicRecord deletes a topic and all of its partitions.
+type RemoveTopicRecord struct {
+	TopicId uuid.UUID // The topic to remove. All associated partitions will be removed as well. (versions: 0+)
+}
+
+func (m *RemoveTopicRecord) Type() RecordType { return RemoveTopicRecordType }
+
+func (m *RemoveTopicRecord) decode(d *protocol.Decoder, version int16) {
+	m.TopicId = d.UUID()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *RemoveTopicRecord) encode(e *protocol.Encoder, version int16) {
+	e.UUID(m.TopicId)
+	e.TaggedFields(nil)
+}
+
+// ClearElrRecord clears the eligible leader replicas of one topic, or of all topics when TopicName
+// is null.
+type ClearElrRecord struct {
+	TopicName *string // The name of the topic to clear the ELR of, or null for all topics. (versions: 0+, nullable: 0+)
+}
+
+func (m *ClearElrRecord) Type() RecordType { return ClearElrRecordType }
+
+func (m *ClearElrRecord) decode(d *protocol.Decoder, version int16) {
+	m.TopicName = d.NullableString()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ClearElrRecord) encode(e *protocol.Encoder, version int16) {
+	e.NullableString(m.TopicName)
+	e.TaggedFields(nil)
+}
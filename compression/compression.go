@@ -0,0 +1,151 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Type is the Kafka compression codec as used in the record batch attributes and in the
+// CompressionType fields of the telemetry APIs.
+type Type int8
+
+const (
+	None   Type = 0
+	Gzip   Type = 1
+	Snappy Type = 2
+	Lz4    Type = 3
+	Zstd   Type = 4
+)
+
+func (t Type) String() string {
+	switch t {
+	case None:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Snappy:
+		return "snappy"
+	case Lz4:
+		return "lz4"
+	case Zstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("unknown(%d)", int8(t))
+	}
+}
+
+// maxDecompressedSize caps how large decompressed data may grow. Compressed payloads come from the
+// wire, and a small crafted payload could otherwise expand into an enormous allocation.
+const maxDecompressedSize = 512 * 1024 * 1024
+
+var ErrUnsupportedCodec = errors.New("unsupported compression codec")
+
+// Codec compresses and decompresses data in the framing Kafka uses for a compression type (for
+// example the xerial framing for snappy and the LZ4 frame format for lz4).
+type Codec interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	codecsLock sync.RWMutex
+	codecs     = map[Type]Codec{
+		Gzip:   gzipCodec{},
+		Snappy: snappyCodec{},
+		Lz4:    lz4Codec{},
+	}
+)
+
+// Register installs a codec for a compression type, replacing the built-in one if there is any. zstd
+// has no built-in codec to keep this module free of heavy dependencies; applications which need it
+// register an implementation (for example one backed by github.com/klauspost/compress/zstd).
+func Register(t Type, codec Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+
+	codecs[t] = codec
+}
+
+func lookup(t Type) (Codec, error) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+
+	codec, ok := codecs[t]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCodec, t)
+	}
+
+	return codec, nil
+}
+
+// Compress compresses the data with the codec of the compression type. None returns the data as is.
+func Compress(t Type, data []byte) ([]byte, error) {
+	if t == None {
+		return data, nil
+	}
+
+	codec, err := lookup(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return codec.Compress(data)
+}
+
+// Decompress decompresses the data with the codec of the compression type. None returns the data as
+// is.
+func Decompress(t Type, data []byte) ([]byte, error) {
+	if t == None {
+		return data, nil
+	}
+
+	codec, err := lookup(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return codec.Decompress(data)
+}
+
+////////////////////
+// gzip
+////////////////////
+
+type gzipCodec struct{}
+
+func (gzipCodec) Compress(data []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0))
+
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	buf := bytes.NewBuffer(make([]byte, 0))
+	n, err := io.Copy(buf, io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > maxDecompressedSize {
+		return nil, fmt.Errorf("decompressed size exceeds %d bytes", maxDecompressedSize)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,126 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testPayloads() map[string][]byte {
+	random := make([]byte, 5000)
+	seed := uint32(1)
+	for i := range random {
+		seed = seed*1664525 + 1013904223
+		random[i] = byte(seed >> 24)
+	}
+
+	return map[string][]byte{
+		"empty":      {},
+		"short":      []byte("abc"),
+		"repetitive": []byte(strings.Repeat("kafka telemetry ", 10000)),
+		"random":     random,
+		"mixed":      append([]byte(strings.Repeat("a", 70000)), random...),
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, codec := range []Type{None, Gzip, Snappy, Lz4} {
+		for name, payload := range testPayloads() {
+			t.Run(codec.String()+"/"+name, func(t *testing.T) {
+				compressed, err := Compress(codec, payload)
+				if err != nil {
+					t.Fatalf("Compress: %v", err)
+				}
+
+				decompressed, err := Decompress(codec, compressed)
+				if err != nil {
+					t.Fatalf("Decompress: %v", err)
+				}
+				if !bytes.Equal(decompressed, payload) {
+					t.Fatalf("round trip mismatch: got %d bytes, want %d", len(decompressed), len(payload))
+				}
+
+				if name == "repetitive" && codec != None && len(compressed) >= len(payload)/10 {
+					t.Errorf("repetitive data compressed to %d of %d bytes", len(compressed), len(payload))
+				}
+			})
+		}
+	}
+}
+
+func TestSnappyRawBlock(t *testing.T) {
+	// Uncompressed length 11, one literal of 11 bytes.
+	raw := append([]byte{0x0b, 10 << 2}, []byte("hello world")...)
+	got, err := Decompress(Snappy, raw)
+	if err != nil || string(got) != "hello world" {
+		t.Errorf("Decompress(raw) = (%q, %v)", got, err)
+	}
+
+	// A copy that points before the start of the output is corrupt.
+	if _, err := Decompress(Snappy, []byte{0x08, 0x00, 'a', 0x01 | 3<<2, 0x05}); err == nil {
+		t.Error("Decompress must reject out of range copies")
+	}
+}
+
+func TestLz4FrameVectors(t *testing.T) {
+	want := strings.Repeat("kafka telemetry ", 40) + "end"
+
+	vectors := map[string]string{
+		// lz4 CLI with content size, block checksums and content checksum
+		"checksums": "04224d187c4083020000000000003f1d000000ff016b61666b612074656c656d65747279201000ffff5d507920656e64a8aca05a0000000030bbb230",
+		// lz4 CLI with dependent blocks and no checksums
+		"plain": "04224d186040821d000000ff016b61666b612074656c656d65747279201000ffff5d507920656e6400000000",
+	}
+
+	for name, vector := range vectors {
+		t.Run(name, func(t *testing.T) {
+			data, _ := hex.DecodeString(vector)
+			got, err := Decompress(Lz4, data)
+			if err != nil {
+				t.Fatalf("Decompress: %v", err)
+			}
+			if string(got) != want {
+				t.Errorf("Decompress() = %q", got)
+			}
+
+			data[len(data)-10] ^= 0xFF
+			if got, err := Decompress(Lz4, data); err == nil && string(got) == want {
+				t.Error("corrupted frame decoded to the original content")
+			}
+		})
+	}
+}
+
+func TestXxh32(t *testing.T) {
+	// Reference values of xxHash32 with seed 0.
+	if got := xxh32([]byte{}, 0); got != 0x02CC5D05 {
+		t.Errorf("xxh32(\"\") = %#x", got)
+	}
+	if got := xxh32([]byte("abc"), 0); got != 0x32D153FF {
+		t.Errorf("xxh32(\"abc\") = %#x", got)
+	}
+}
+
+func TestUnsupportedCodec(t *testing.T) {
+	if _, err := Decompress(Zstd, []byte{1, 2, 3}); !errors.Is(err, ErrUnsupportedCodec) {
+		t.Errorf("Decompress(Zstd) error = %v, want ErrUnsupportedCodec", err)
+	}
+
+	Register(Zstd, identityCodec{})
+	defer func() {
+		codecsLock.Lock()
+		delete(codecs, Zstd)
+		codecsLock.Unlock()
+	}()
+
+	if got, err := Decompress(Zstd, []byte{1, 2, 3}); err != nil || !bytes.Equal(got, []byte{1, 2, 3}) {
+		t.Errorf("Decompress(Zstd) with a registered codec = (%v, %v)", got, err)
+	}
+}
+
+type identityCodec struct{}
+
+func (identityCodec) Compress(data []byte) ([]byte, error)   { return data, nil }
+func (identityCodec) Decompress(data []byte) ([]byte, error) { return data, nil }
@@ -0,0 +1,382 @@
+package compression
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// Kafka uses the LZ4 frame format (KafkaLZ4BlockOutputStream) for lz4 compressed data.
+
+const (
+	lz4FrameMagic     = 0x184D2204
+	lz4SkippableMagic = 0x184D2A50 // the low nibble may be anything
+	lz4BlockMaxSize   = 64 * 1024
+	lz4BDBlock64KB    = 4 << 4
+	lz4FLGVersion     = 1 << 6
+	lz4FLGBlockIndep  = 1 << 5
+	lz4FLGBlockCheck  = 1 << 4
+	lz4FLGContentSize = 1 << 3
+	lz4FLGContentChk  = 1 << 2
+	lz4FLGDictId      = 1 << 0
+	lz4UncompressedBF = 0x80000000
+	lz4MinMatch       = 4
+	lz4LastLiterals   = 5  // the last 5 bytes of a block are always literals
+	lz4MFLimit        = 12 // the last match must start at least 12 bytes before the end of the block
+)
+
+var errCorruptLz4 = errors.New("corrupt lz4 data")
+
+type lz4Codec struct{}
+
+func (lz4Codec) Compress(data []byte) ([]byte, error) {
+	flg := byte(lz4FLGVersion | lz4FLGBlockIndep)
+	bd := byte(lz4BDBlock64KB)
+
+	out := binary.LittleEndian.AppendUint32(make([]byte, 0, len(data)+16), lz4FrameMagic)
+	out = append(out, flg, bd, byte(xxh32([]byte{flg, bd}, 0)>>8))
+
+	for start := 0; start < len(data); start += lz4BlockMaxSize {
+		end := min(start+lz4BlockMaxSize, len(data))
+		block := data[start:end]
+
+		compressed := lz4EncodeBlock(block)
+		if len(compressed) < len(block) {
+			out = binary.LittleEndian.AppendUint32(out, uint32(len(compressed)))
+			out = append(out, compressed...)
+		} else {
+			out = binary.LittleEndian.AppendUint32(out, uint32(len(block))|lz4UncompressedBF)
+			out = append(out, block...)
+		}
+	}
+
+	// End mark
+	return binary.LittleEndian.AppendUint32(out, 0), nil
+}
+
+func (lz4Codec) Decompress(data []byte) ([]byte, error) {
+	out := make([]byte, 0, preallocLen(len(data)*2))
+
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errCorruptLz4
+		}
+
+		magic := binary.LittleEndian.Uint32(data)
+		if magic&0xFFFFFFF0 == lz4SkippableMagic {
+			if len(data) < 8 {
+				return nil, errCorruptLz4
+			}
+			size := int(binary.LittleEndian.Uint32(data[4:]))
+			if size < 0 || 8+size > len(data) {
+				return nil, errCorruptLz4
+			}
+			data = data[8+size:]
+			continue
+		}
+		if magic != lz4FrameMagic {
+			return nil, fmt.Errorf("%w: bad frame magic %#x", errCorruptLz4, magic)
+		}
+
+		var err error
+		out, data, err = lz4DecodeFrame(out, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+func lz4DecodeFrame(out []byte, data []byte) ([]byte, []byte, error) {
+	if len(data) < 7 {
+		return nil, nil, errCorruptLz4
+	}
+
+	flg := data[4]
+	if flg>>6 != 1 {
+		return nil, nil, fmt.Errorf("%w: unsupported frame version %d", errCorruptLz4, flg>>6)
+	}
+
+	descriptorLen := 2
+	if flg&lz4FLGContentSize != 0 {
+		descriptorLen += 8
+	}
+	if flg&lz4FLGDictId != 0 {
+		return nil, nil, fmt.Errorf("%w: dictionaries are not supported", errCorruptLz4)
+	}
+	if len(data) < 4+descriptorLen+1 {
+		return nil, nil, errCorruptLz4
+	}
+
+	descriptor := data[4 : 4+descriptorLen]
+	checksum := data[4+descriptorLen]
+	// Kafka clients before KIP-57 computed the header checksum over the magic number as well; accept
+	// both so that data written by old clients can still be read.
+	if checksum != byte(xxh32(descriptor, 0)>>8) && checksum != byte(xxh32(data[:4+descriptorLen], 0)>>8) {
+		return nil, nil, fmt.Errorf("%w: header checksum mismatch", errCorruptLz4)
+	}
+	data = data[4+descriptorLen+1:]
+
+	frameStart := len(out)
+	for {
+		if len(data) < 4 {
+			return nil, nil, errCorruptLz4
+		}
+
+		blockSize := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+		if blockSize == 0 {
+			break
+		}
+
+		uncompressed := blockSize&lz4UncompressedBF != 0
+		size := int(blockSize &^ lz4UncompressedBF)
+		if size > len(data) {
+			return nil, nil, errCorruptLz4
+		}
+		block := data[:size]
+		data = data[size:]
+
+		if flg&lz4FLGBlockCheck != 0 {
+			if len(data) < 4 {
+				return nil, nil, errCorruptLz4
+			}
+			if binary.LittleEndian.Uint32(data) != xxh32(block, 0) {
+				return nil, nil, fmt.Errorf("%w: block checksum mismatch", errCorruptLz4)
+			}
+			data = data[4:]
+		}
+
+		var err error
+		if uncompressed {
+			out = append(out, block...)
+		} else {
+			// Dependent blocks may reference data of previous blocks, so the whole frame is decoded
+			// into one continuous buffer.
+			out, err = lz4DecodeBlock(out, block)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if len(out) > maxDecompressedSize {
+			return nil, nil, fmt.Errorf("decompressed size exceeds %d bytes", maxDecompressedSize)
+		}
+	}
+
+	if flg&lz4FLGContentChk != 0 {
+		if len(data) < 4 {
+			return nil, nil, errCorruptLz4
+		}
+		if binary.LittleEndian.Uint32(data) != xxh32(out[frameStart:], 0) {
+			return nil, nil, fmt.Errorf("%w: content checksum mismatch", errCorruptLz4)
+		}
+		data = data[4:]
+	}
+
+	return out, data, nil
+}
+
+// lz4DecodeBlock decodes the sequences of an LZ4 block and appends the result to dst.
+func lz4DecodeBlock(dst []byte, src []byte) ([]byte, error) {
+	for len(src) > 0 {
+		token := src[0]
+		src = src[1:]
+
+		literalLen, n, err := lz4ReadLength(src, int(token>>4))
+		if err != nil {
+			return nil, err
+		}
+		src = src[n:]
+		if literalLen > len(src) {
+			return nil, errCorruptLz4
+		}
+		dst = append(dst, src[:literalLen]...)
+		src = src[literalLen:]
+
+		// The last sequence has only literals.
+		if len(src) == 0 {
+			break
+		}
+
+		if len(src) < 2 {
+			return nil, errCorruptLz4
+		}
+		offset := int(binary.LittleEndian.Uint16(src))
+		src = src[2:]
+		if offset == 0 || offset > len(dst) {
+			return nil, errCorruptLz4
+		}
+
+		matchLen, n, err := lz4ReadLength(src, int(token&0x0F))
+		if err != nil {
+			return nil, err
+		}
+		src = src[n:]
+		matchLen += lz4MinMatch
+
+		if len(dst)+matchLen > maxDecompressedSize {
+			return nil, fmt.Errorf("decompressed size exceeds %d bytes", maxDecompressedSize)
+		}
+		start := len(dst) - offset
+		for i := 0; i < matchLen; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+
+	return dst, nil
+}
+
+// lz4ReadLength reads the extension bytes of a literal or match length whose 4-bit value is 15.
+func lz4ReadLength(src []byte, length int) (int, int, error) {
+	n := 0
+	if length == 15 {
+		for {
+			if n >= len(src) {
+				return 0, 0, errCorruptLz4
+			}
+			b := src[n]
+			n++
+			length += int(b)
+			if length > maxDecompressedSize {
+				return 0, 0, errCorruptLz4
+			}
+			if b != 255 {
+				break
+			}
+		}
+	}
+
+	return length, n, nil
+}
+
+// lz4EncodeBlock encodes one block with a greedy matcher over 4-byte hashes, respecting the end of
+// block rules of the LZ4 block format.
+func lz4EncodeBlock(src []byte) []byte {
+	dst := make([]byte, 0, len(src))
+	table := make(map[uint32]int)
+
+	literalStart := 0
+	matchLimit := len(src) - lz4LastLiterals
+	for i := 0; i+lz4MFLimit <= len(src); {
+		key := binary.LittleEndian.Uint32(src[i:])
+		candidate, ok := table[key]
+		table[key] = i
+
+		if !ok || i-candidate > 0xFFFF {
+			i++
+			continue
+		}
+
+		matchLen := lz4MinMatch
+		for i+matchLen < matchLimit && src[candidate+matchLen] == src[i+matchLen] {
+			matchLen++
+		}
+
+		dst = lz4EmitSequence(dst, src[literalStart:i], i-candidate, matchLen)
+		i += matchLen
+		literalStart = i
+	}
+
+	// Last sequence: literals only.
+	return lz4EmitSequence(dst, src[literalStart:], 0, 0)
+}
+
+func lz4EmitSequence(dst []byte, literals []byte, offset int, matchLen int) []byte {
+	tokenPos := len(dst)
+	dst = append(dst, 0)
+
+	token := byte(0)
+	if len(literals) >= 15 {
+		token = 15 << 4
+		dst = lz4AppendLength(dst, len(literals)-15)
+	} else {
+		token = byte(len(literals)) << 4
+	}
+	dst = append(dst, literals...)
+
+	if matchLen > 0 {
+		dst = append(dst, byte(offset), byte(offset>>8))
+
+		ml := matchLen - lz4MinMatch
+		if ml >= 15 {
+			token |= 15
+			dst = lz4AppendLength(dst, ml-15)
+		} else {
+			token |= byte(ml)
+		}
+	}
+
+	dst[tokenPos] = token
+	return dst
+}
+
+func lz4AppendLength(dst []byte, length int) []byte {
+	for length >= 255 {
+		dst = append(dst, 255)
+		length -= 255
+	}
+	return append(dst, byte(length))
+}
+
+////////////////////
+// xxHash32 (used for the LZ4 frame checksums)
+////////////////////
+
+const (
+	xxhPrime1 uint32 = 2654435761
+	xxhPrime2 uint32 = 2246822519
+	xxhPrime3 uint32 = 3266489917
+	xxhPrime4 uint32 = 668265263
+	xxhPrime5 uint32 = 374761393
+)
+
+func xxh32(data []byte, seed uint32) uint32 {
+	var h uint32
+	n := len(data)
+
+	if n >= 16 {
+		v1 := seed + xxhPrime1 + xxhPrime2
+		v2 := seed + xxhPrime2
+		v3 := seed
+		v4 := seed - xxhPrime1
+		for len(data) >= 16 {
+			v1 = xxhRound(v1, binary.LittleEndian.Uint32(data[0:]))
+			v2 = xxhRound(v2, binary.LittleEndian.Uint32(data[4:]))
+			v3 = xxhRound(v3, binary.LittleEndian.Uint32(data[8:]))
+			v4 = xxhRound(v4, binary.LittleEndian.Uint32(data[12:]))
+			data = data[16:]
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) + bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = seed + xxhPrime5
+	}
+
+	h += uint32(n)
+
+	for len(data) >= 4 {
+		h += binary.LittleEndian.Uint32(data) * xxhPrime3
+		h = bits.RotateLeft32(h, 17) * xxhPrime4
+		data = data[4:]
+	}
+	for _, b := range data {
+		h += uint32(b) * xxhPrime5
+		h = bits.RotateLeft32(h, 11) * xxhPrime1
+	}
+
+	h ^= h >> 15
+	h *= xxhPrime2
+	h ^= h >> 13
+	h *= xxhPrime3
+	h ^= h >> 16
+
+	return h
+}
+
+func xxhRound(acc uint32, input uint32) uint32 {
+	acc += input * xxhPrime2
+	acc = bits.RotateLeft32(acc, 13)
+	return acc * xxhPrime1
+}
@@ -0,0 +1,243 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Kafka's Java clients write snappy data in the xerial stream framing: a magic header followed by
+// chunks which are each an int32 length and a raw snappy block. Raw blocks without the framing are
+// accepted when decompressing as well.
+
+var xerialHeader = []byte{0x82, 'S', 'N', 'A', 'P', 'P', 'Y', 0}
+
+const (
+	xerialVersion = 1
+	// xerialBlockSize matches the default block size of the xerial SnappyOutputStream.
+	xerialBlockSize = 32 * 1024
+)
+
+var errCorruptSnappy = errors.New("corrupt snappy data")
+
+type snappyCodec struct{}
+
+func (snappyCodec) Compress(data []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)/2+32))
+	buf.Write(xerialHeader)
+	_ = binary.Write(buf, binary.BigEndian, int32(xerialVersion))
+	_ = binary.Write(buf, binary.BigEndian, int32(xerialVersion))
+
+	for start := 0; start < len(data); start += xerialBlockSize {
+		end := min(start+xerialBlockSize, len(data))
+
+		block := snappyEncodeBlock(data[start:end])
+		_ = binary.Write(buf, binary.BigEndian, int32(len(block)))
+		buf.Write(block)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (snappyCodec) Decompress(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, xerialHeader) {
+		return snappyDecodeBlock(data)
+	}
+
+	if len(data) < len(xerialHeader)+8 {
+		return nil, errCorruptSnappy
+	}
+
+	out := make([]byte, 0)
+	rest := data[len(xerialHeader)+8:]
+	for len(rest) > 0 {
+		if len(rest) < 4 {
+			return nil, errCorruptSnappy
+		}
+
+		length := int(binary.BigEndian.Uint32(rest))
+		rest = rest[4:]
+		if length < 0 || length > len(rest) {
+			return nil, errCorruptSnappy
+		}
+
+		block, err := snappyDecodeBlock(rest[:length])
+		if err != nil {
+			return nil, err
+		}
+		if len(out)+len(block) > maxDecompressedSize {
+			return nil, fmt.Errorf("decompressed size exceeds %d bytes", maxDecompressedSize)
+		}
+
+		out = append(out, block...)
+		rest = rest[length:]
+	}
+
+	return out, nil
+}
+
+// snappyDecodeBlock decodes a raw snappy block: the uvarint uncompressed length followed by literal
+// and copy elements.
+func snappyDecodeBlock(src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 || length > maxDecompressedSize {
+		return nil, errCorruptSnappy
+	}
+	src = src[n:]
+
+	dst := make([]byte, 0, preallocLen(int(length)))
+	for len(src) > 0 {
+		tag := src[0]
+		src = src[1:]
+
+		switch tag & 0x03 {
+		case 0x00: // literal
+			litLen := int(tag >> 2)
+			if litLen >= 60 {
+				extra := litLen - 59
+				if len(src) < extra {
+					return nil, errCorruptSnappy
+				}
+				litLen = 0
+				for i := 0; i < extra; i++ {
+					litLen |= int(src[i]) << (8 * i)
+				}
+				src = src[extra:]
+			}
+			litLen++
+
+			if litLen <= 0 || litLen > len(src) || uint64(len(dst)+litLen) > length {
+				return nil, errCorruptSnappy
+			}
+			dst = append(dst, src[:litLen]...)
+			src = src[litLen:]
+			continue
+
+		case 0x01: // copy with a 1-byte offset
+			if len(src) < 1 {
+				return nil, errCorruptSnappy
+			}
+			copyLen := 4 + int(tag>>2)&0x07
+			offset := int(tag>>5)<<8 | int(src[0])
+			src = src[1:]
+			if err := snappyCopy(&dst, offset, copyLen, length); err != nil {
+				return nil, err
+			}
+
+		case 0x02: // copy with a 2-byte offset
+			if len(src) < 2 {
+				return nil, errCorruptSnappy
+			}
+			copyLen := 1 + int(tag>>2)
+			offset := int(binary.LittleEndian.Uint16(src))
+			src = src[2:]
+			if err := snappyCopy(&dst, offset, copyLen, length); err != nil {
+				return nil, err
+			}
+
+		case 0x03: // copy with a 4-byte offset
+			if len(src) < 4 {
+				return nil, errCorruptSnappy
+			}
+			copyLen := 1 + int(tag>>2)
+			offset := int(binary.LittleEndian.Uint32(src))
+			src = src[4:]
+			if err := snappyCopy(&dst, offset, copyLen, length); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if uint64(len(dst)) != length {
+		return nil, errCorruptSnappy
+	}
+
+	return dst, nil
+}
+
+// snappyCopy appends copyLen bytes starting offset bytes back. The ranges may overlap, which repeats
+// the pattern, so the bytes are copied one at a time.
+func snappyCopy(dst *[]byte, offset int, copyLen int, length uint64) error {
+	if offset <= 0 || offset > len(*dst) || uint64(len(*dst)+copyLen) > length {
+		return errCorruptSnappy
+	}
+
+	start := len(*dst) - offset
+	for i := 0; i < copyLen; i++ {
+		*dst = append(*dst, (*dst)[start+i])
+	}
+
+	return nil
+}
+
+// snappyEncodeBlock encodes a raw snappy block with a greedy matcher over 4-byte hashes. It favours
+// simplicity over compression ratio; any spec-compliant decoder can read its output.
+func snappyEncodeBlock(src []byte) []byte {
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)+len(src)/6+16), uint64(len(src)))
+
+	table := make(map[uint32]int)
+	literalStart := 0
+	for i := 0; i+4 <= len(src); {
+		key := binary.LittleEndian.Uint32(src[i:])
+		candidate, ok := table[key]
+		table[key] = i
+
+		if !ok || i-candidate > 0xFFFF {
+			i++
+			continue
+		}
+
+		matchLen := 4
+		for i+matchLen < len(src) && src[candidate+matchLen] == src[i+matchLen] {
+			matchLen++
+		}
+
+		dst = snappyEmitLiteral(dst, src[literalStart:i])
+		for remaining := matchLen; remaining > 0; {
+			// Copies with a 2-byte offset hold at most 64 bytes; keep at least 4 for the last one.
+			chunk := min(remaining, 64)
+			if remaining > 64 && remaining-64 < 4 {
+				chunk = 60
+			}
+			dst = append(dst, byte(chunk-1)<<2|0x02, byte(i-candidate), byte((i-candidate)>>8))
+			remaining -= chunk
+		}
+
+		i += matchLen
+		literalStart = i
+	}
+
+	return snappyEmitLiteral(dst, src[literalStart:])
+}
+
+func snappyEmitLiteral(dst []byte, literal []byte) []byte {
+	if len(literal) == 0 {
+		return dst
+	}
+
+	n := len(literal) - 1
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2)
+	case n < 1<<8:
+		dst = append(dst, 60<<2, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+
+	return append(dst, literal...)
+}
+
+// preallocLen bounds the initial capacity for a declared (and therefore untrusted) length.
+func preallocLen(length int) int {
+	const maxPrealloc = 64 * 1024
+	if length < maxPrealloc {
+		return length
+	}
+	return maxPrealloc
+}
@@ -0,0 +1,391 @@
+package consumeroffsets
+
+import (
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+////////////////////
+// KIP-848 consumer groups
+////////////////////
+
+// The keys of the consumer group records are not flexible, all values are flexible from version 0.
+
+// ConsumerGroupMetadataKey is the key of the epoch of a consumer group.
+type ConsumerGroupMetadataKey struct {
+	GroupId string // The group id. (versions: 3)
+}
+
+func (m *ConsumerGroupMetadataKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+}
+
+func (m *ConsumerGroupMetadataKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+}
+
+// ConsumerGroupMetadataValue is the epoch of a consumer group.
+type ConsumerGroupMetadataValue struct {
+	Epoch int32 // The group epoch. (versions: 0+)
+}
+
+func (m *ConsumerGroupMetadataValue) decode(d *protocol.Decoder, version int16) {
+	m.Epoch = d.Int32()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ConsumerGroupMetadataValue) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.Epoch)
+	e.TaggedFields(nil)
+}
+
+// ConsumerGroupPartitionMetadataKey is the key of the metadata of the topics subscribed by a
+// consumer group.
+type ConsumerGroupPartitionMetadataKey struct {
+	GroupId string // The group id. (versions: 4)
+}
+
+func (m *ConsumerGroupPartitionMetadataKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+}
+
+func (m *ConsumerGroupPartitionMetadataKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+}
+
+// ConsumerGroupPartitionMetadataValue is the metadata of the topics subscribed by a consumer group,
+// used to detect the metadata changes which require a new assignment.
+type ConsumerGroupPartitionMetadataValue struct {
+	Topics []TopicMetadata // The subscribed topics. (versions: 0+)
+}
+
+// TopicMetadata is the metadata of a subscribed topic.
+type TopicMetadata struct {
+	TopicId           uuid.UUID           // The topic id. (versions: 0+)
+	TopicName         string              // The topic name. (versions: 0+)
+	NumPartitions     int32               // The number of partitions. (versions: 0+)
+	PartitionMetadata []PartitionMetadata // The racks of the partitions. (versions: 0+)
+}
+
+// PartitionMetadata holds the racks of the replicas of a partition.
+type PartitionMetadata struct {
+	Partition int32    // The partition index. (versions: 0+)
+	Racks     []string // The racks of the replicas. (versions: 0+)
+}
+
+func decodeTopicMetadata(d *protocol.Decoder) []TopicMetadata {
+	return protocol.DecodeArray(d, func(d *protocol.Decoder) TopicMetadata {
+		var topic TopicMetadata
+		topic.TopicId = d.UUID()
+		topic.TopicName = d.String()
+		topic.NumPartitions = d.Int32()
+		topic.PartitionMetadata = protocol.DecodeArray(d, func(d *protocol.Decoder) PartitionMetadata {
+			var partition PartitionMetadata
+			partition.Partition = d.Int32()
+			partition.Racks = protocol.DecodeArray(d, (*protocol.Decoder).String)
+			d.TaggedFields(noTaggedFields)
+			return partition
+		})
+		d.TaggedFields(noTaggedFields)
+		return topic
+	})
+}
+
+func encodeTopicMetadata(e *protocol.Encoder, values []TopicMetadata) {
+	protocol.EncodeArray(e, values, func(e *protocol.Encoder, topic TopicMetadata) {
+		e.UUID(topic.TopicId)
+		e.String(topic.TopicName)
+		e.Int32(topic.NumPartitions)
+		protocol.EncodeArray(e, topic.PartitionMetadata, func(e *protocol.Encoder, partition PartitionMetadata) {
+			e.Int32(partition.Partition)
+			protocol.EncodeArray(e, partition.Racks, (*protocol.Encoder).String)
+			e.TaggedFields(nil)
+		})
+		e.TaggedFields(nil)
+	})
+}
+
+func (m *ConsumerGroupPartitionMetadataValue) decode(d *protocol.Decoder, version int16) {
+	m.Topics = decodeTopicMetadata(d)
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ConsumerGroupPartitionMetadataValue) encode(e *protocol.Encoder, version int16) {
+	encodeTopicMetadata(e, m.Topics)
+	e.TaggedFields(nil)
+}
+
+// ConsumerGroupMemberMetadataKey is the key of the metadata of a consumer group member.
+type ConsumerGroupMemberMetadataKey struct {
+	GroupId  string // The group id. (versions: 5)
+	MemberId string // The member id. (versions: 5)
+}
+
+func (m *ConsumerGroupMemberMetadataKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+	m.MemberId = d.String()
+}
+
+func (m *ConsumerGroupMemberMetadataKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+	e.String(m.MemberId)
+}
+
+// ConsumerGroupMemberMetadataValue is the metadata of a consumer group member.
+type ConsumerGroupMemberMetadataValue struct {
+	InstanceId            *string                // The static member instance id. (versions: 0+, nullable: 0+)
+	RackId                *string                // The rack of the member. (versions: 0+, nullable: 0+)
+	ClientId              string                 // The client id. (versions: 0+)
+	ClientHost            string                 // The client host. (versions: 0+)
+	SubscribedTopicNames  []string               // The subscribed topic names. (versions: 0+)
+	SubscribedTopicRegex  *string                // The subscribed topic regular expression. (versions: 0+, nullable: 0+)
+	RebalanceTimeoutMs    int32                  // The rebalance timeout. (versions: 0+, default: -1)
+	ServerAssignor        *string                // The server side assignor selected by the member. (versions: 0+, nullable: 0+)
+	ClassicMemberMetadata *ClassicMemberMetadata // tag 0: The metadata of a member using the classic protocol. (versions: 0+, nullable: 0+)
+}
+
+// ClassicMemberMetadata is the metadata of a consumer group member which joined with the classic
+// protocol.
+type ClassicMemberMetadata struct {
+	SessionTimeoutMs   int32             // The session timeout. (versions: 0+)
+	SupportedProtocols []ClassicProtocol // The protocols supported by the member. (versions: 0+)
+}
+
+// ClassicProtocol is a protocol of a classic member with its metadata.
+type ClassicProtocol struct {
+	Name     string // The protocol name. (versions: 0+)
+	Metadata []byte // The protocol metadata. (versions: 0+)
+}
+
+func (m *ConsumerGroupMemberMetadataValue) decode(d *protocol.Decoder, version int16) {
+	m.InstanceId = d.NullableString()
+	m.RackId = d.NullableString()
+	m.ClientId = d.String()
+	m.ClientHost = d.String()
+	m.SubscribedTopicNames = protocol.DecodeArray(d, (*protocol.Decoder).String)
+	m.SubscribedTopicRegex = d.NullableString()
+	m.RebalanceTimeoutMs = d.Int32()
+	m.ServerAssignor = d.NullableString()
+	d.TaggedFields(func(d *protocol.Decoder, tag uint64) bool {
+		if tag != 0 {
+			return false
+		}
+		if d.Int8() < 0 {
+			m.ClassicMemberMetadata = nil
+			return true
+		}
+		m.ClassicMemberMetadata = &ClassicMemberMetadata{}
+		m.ClassicMemberMetadata.SessionTimeoutMs = d.Int32()
+		m.ClassicMemberMetadata.SupportedProtocols = protocol.DecodeArray(d, func(d *protocol.Decoder) ClassicProtocol {
+			var p ClassicProtocol
+			p.Name = d.String()
+			p.Metadata = d.Bytes()
+			d.TaggedFields(noTaggedFields)
+			return p
+		})
+		d.TaggedFields(noTaggedFields)
+		return true
+	})
+}
+
+func (m *ConsumerGroupMemberMetadataValue) encode(e *protocol.Encoder, version int16) {
+	e.NullableString(m.InstanceId)
+	e.NullableString(m.RackId)
+	e.String(m.ClientId)
+	e.String(m.ClientHost)
+	protocol.EncodeArray(e, m.SubscribedTopicNames, (*protocol.Encoder).String)
+	e.NullableString(m.SubscribedTopicRegex)
+	e.Int32(m.RebalanceTimeoutMs)
+	e.NullableString(m.ServerAssignor)
+
+	var tagged []protocol.TaggedField
+	if m.ClassicMemberMetadata != nil {
+		tagged = e.Tagged(tagged, 0, func(e *protocol.Encoder) {
+			e.Int8(1)
+			e.Int32(m.ClassicMemberMetadata.SessionTimeoutMs)
+			protocol.EncodeArray(e, m.ClassicMemberMetadata.SupportedProtocols, func(e *protocol.Encoder, p ClassicProtocol) {
+				e.String(p.Name)
+				e.Bytes(p.Metadata)
+				e.TaggedFields(nil)
+			})
+			e.TaggedFields(nil)
+		})
+	}
+	e.TaggedFields(tagged)
+}
+
+// ConsumerGroupTargetAssignmentMetadataKey is the key of the epoch of the target assignment of a
+// consumer group.
+type ConsumerGroupTargetAssignmentMetadataKey struct {
+	GroupId string // The group id. (versions: 6)
+}
+
+func (m *ConsumerGroupTargetAssignmentMetadataKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+}
+
+func (m *ConsumerGroupTargetAssignmentMetadataKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+}
+
+// ConsumerGroupTargetAssignmentMetadataValue is the epoch of the target assignment of a consumer
+// group.
+type ConsumerGroupTargetAssignmentMetadataValue struct {
+	AssignmentEpoch int32 // The group epoch of the target assignment. (versions: 0+)
+}
+
+func (m *ConsumerGroupTargetAssignmentMetadataValue) decode(d *protocol.Decoder, version int16) {
+	m.AssignmentEpoch = d.Int32()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ConsumerGroupTargetAssignmentMetadataValue) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.AssignmentEpoch)
+	e.TaggedFields(nil)
+}
+
+// ConsumerGroupTargetAssignmentMemberKey is the key of the target assignment of a consumer group
+// member.
+type ConsumerGroupTargetAssignmentMemberKey struct {
+	GroupId  string // The group id. (versions: 7)
+	MemberId string // The member id. (versions: 7)
+}
+
+func (m *ConsumerGroupTargetAssignmentMemberKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+	m.MemberId = d.String()
+}
+
+func (m *ConsumerGroupTargetAssignmentMemberKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+	e.String(m.MemberId)
+}
+
+// ConsumerGroupTargetAssignmentMemberValue is the target assignment of a consumer group member.
+type ConsumerGroupTargetAssignmentMemberValue struct {
+	TopicPartitions []TopicPartitions // The assigned partitions. (versions: 0+)
+}
+
+// TopicPartitions are partitions of a topic.
+type TopicPartitions struct {
+	TopicId    uuid.UUID // The topic id. (versions: 0+)
+	Partitions []int32   // The partition indexes. (versions: 0+)
+}
+
+func decodeTopicPartitions(d *protocol.Decoder) []TopicPartitions {
+	return protocol.DecodeArray(d, func(d *protocol.Decoder) TopicPartitions {
+		var tp TopicPartitions
+		tp.TopicId = d.UUID()
+		tp.Partitions = protocol.DecodeArray(d, (*protocol.Decoder).Int32)
+		d.TaggedFields(noTaggedFields)
+		return tp
+	})
+}
+
+func encodeTopicPartitions(e *protocol.Encoder, values []TopicPartitions) {
+	protocol.EncodeArray(e, values, func(e *protocol.Encoder, tp TopicPartitions) {
+		e.UUID(tp.TopicId)
+		protocol.EncodeArray(e, tp.Partitions, (*protocol.Encoder).Int32)
+		e.TaggedFields(nil)
+	})
+}
+
+func (m *ConsumerGroupTargetAssignmentMemberValue) decode(d *protocol.Decoder, version int16) {
+	m.TopicPartitions = decodeTopicPartitions(d)
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ConsumerGroupTargetAssignmentMemberValue) encode(e *protocol.Encoder, version int16) {
+	encodeTopicPartitions(e, m.TopicPartitions)
+	e.TaggedFields(nil)
+}
+
+// Consumer group member states stored in ConsumerGroupCurrentMemberAssignmentValue.
+const (
+	MemberStateStable               int8 = 0
+	MemberStateUnrevokedPartitions  int8 = 1
+	MemberStateUnreleasedPartitions int8 = 2
+	MemberStateUnknown              int8 = 127
+)
+
+// ConsumerGroupCurrentMemberAssignmentKey is the key of the current assignment of a consumer
+// group member.
+type ConsumerGroupCurrentMemberAssignmentKey struct {
+	GroupId  string // The group id. (versions: 8)
+	MemberId string // The member id. (versions: 8)
+}
+
+func (m *ConsumerGroupCurrentMemberAssignmentKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+	m.MemberId = d.String()
+}
+
+func (m *ConsumerGroupCurrentMemberAssignmentKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+	e.String(m.MemberId)
+}
+
+// ConsumerGroupCurrentMemberAssignmentValue is the current assignment of a consumer group member.
+type ConsumerGroupCurrentMemberAssignmentValue struct {
+	MemberEpoch                 int32             // The current member epoch. (versions: 0+)
+	PreviousMemberEpoch         int32             // The previous member epoch. (versions: 0+)
+	State                       int8              // The member state. (versions: 0+)
+	AssignedPartitions          []TopicPartitions // The partitions assigned to the member. (versions: 0+)
+	PartitionsPendingRevocation []TopicPartitions // The partitions the member must still revoke. (versions: 0+)
+}
+
+func (m *ConsumerGroupCurrentMemberAssignmentValue) decode(d *protocol.Decoder, version int16) {
+	m.MemberEpoch = d.Int32()
+	m.PreviousMemberEpoch = d.Int32()
+	m.State = d.Int8()
+	m.AssignedPartitions = decodeTopicPartitions(d)
+	m.PartitionsPendingRevocation = decodeTopicPartitions(d)
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ConsumerGroupCurrentMemberAssignmentValue) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.MemberEpoch)
+	e.Int32(m.PreviousMemberEpoch)
+	e.Int8(m.State)
+	encodeTopicPartitions(e, m.AssignedPartitions)
+	encodeTopicPartitions(e, m.PartitionsPendingRevocation)
+	e.TaggedFields(nil)
+}
+
+// ConsumerGroupRegularExpressionKey is the key of the topics resolved for a regular expression
+// subscribed by consumer group members.
+type ConsumerGroupRegularExpressionKey struct {
+	GroupId           string // The group id. (versions: 16)
+	RegularExpression string // The regular expression. (versions: 16)
+}
+
+func (m *ConsumerGroupRegularExpressionKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+	m.RegularExpression = d.String()
+}
+
+func (m *ConsumerGroupRegularExpressionKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+	e.String(m.RegularExpression)
+}
+
+// ConsumerGroupRegularExpressionValue holds the topics matching a subscribed regular expression.
+type ConsumerGroupRegularExpressionValue struct {
+	Topics    []string // The topics matching the regular expression. (versions: 0+)
+	Version   int64    // The version of the metadata image used to resolve the topics. (versions: 0+)
+	Timestamp int64    // The time of the resolution. (versions: 0+)
+}
+
+func (m *ConsumerGroupRegularExpressionValue) decode(d *protocol.Decoder, version int16) {
+	m.Topics = protocol.DecodeArray(d, (*protocol.Decoder).String)
+	m.Version = d.Int64()
+	m.Timestamp = d.Int64()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ConsumerGroupRegularExpressionValue) encode(e *protocol.Encoder, version int16) {
+	protocol.EncodeArray(e, m.Topics, (*protocol.Encoder).String)
+	e.Int64(m.Version)
+	e.Int64(m.Timestamp)
+	e.TaggedFields(nil)
+}
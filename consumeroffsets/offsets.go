@@ -0,0 +1,162 @@
+package consumeroffsets
+
+import (
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+////////////////////
+// Offset commits and classic groups
+////////////////////
+
+// NoExpireTimestamp is the OffsetCommitValue expire timestamp of offsets that expire with their group.
+const NoExpireTimestamp int64 = -1
+
+// OffsetCommitKey is the key of a committed offset. Key versions 0 and 1 have the same schema.
+type OffsetCommitKey struct {
+	Group     string // The group id. (versions: 0-1)
+	Topic     string // The topic name. (versions: 0-1)
+	Partition int32  // The partition index. (versions: 0-1)
+}
+
+func (m *OffsetCommitKey) decode(d *protocol.Decoder, version int16) {
+	m.Group = d.String()
+	m.Topic = d.String()
+	m.Partition = d.Int32()
+}
+
+func (m *OffsetCommitKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.Group)
+	e.String(m.Topic)
+	e.Int32(m.Partition)
+}
+
+// OffsetCommitValue is a committed offset.
+type OffsetCommitValue struct {
+	Offset          int64  // The committed offset. (versions: 0+)
+	LeaderEpoch     int32  // The leader epoch of the last consumed record, or -1. (versions: 3+, default: -1)
+	Metadata        string // The metadata committed with the offset. (versions: 0+)
+	CommitTimestamp int64  // The time of the commit. (versions: 0+)
+	ExpireTimestamp int64  // The time after which the offset expires. (versions: 1, default: -1)
+}
+
+func (m *OffsetCommitValue) decode(d *protocol.Decoder, version int16) {
+	m.Offset = d.Int64()
+	m.LeaderEpoch = -1
+	if version >= 3 {
+		m.LeaderEpoch = d.Int32()
+	}
+	m.Metadata = d.String()
+	m.CommitTimestamp = d.Int64()
+	m.ExpireTimestamp = NoExpireTimestamp
+	if version == 1 {
+		m.ExpireTimestamp = d.Int64()
+	}
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *OffsetCommitValue) encode(e *protocol.Encoder, version int16) {
+	e.Int64(m.Offset)
+	if version >= 3 {
+		e.Int32(m.LeaderEpoch)
+	}
+	e.String(m.Metadata)
+	e.Int64(m.CommitTimestamp)
+	if version == 1 {
+		e.Int64(m.ExpireTimestamp)
+	}
+	e.TaggedFields(nil)
+}
+
+// GroupMetadataKey is the key of the metadata of a classic group.
+type GroupMetadataKey struct {
+	Group string // The group id. (versions: 2)
+}
+
+func (m *GroupMetadataKey) decode(d *protocol.Decoder, version int16) {
+	m.Group = d.String()
+}
+
+func (m *GroupMetadataKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.Group)
+}
+
+// GroupMetadataValue is the metadata of a classic group and its members.
+type GroupMetadataValue struct {
+	ProtocolType          string           // The protocol type, for example consumer. (versions: 0+)
+	Generation            int32            // The generation of the group. (versions: 0+)
+	Protocol              *string          // The selected protocol, or null for an empty group. (versions: 0+, nullable: 0+)
+	Leader                *string          // The member id of the leader, or null. (versions: 0+, nullable: 0+)
+	CurrentStateTimestamp int64            // The time of the last state change, or -1. (versions: 2+, default: -1)
+	Members               []MemberMetadata // The members of the group. (versions: 0+)
+}
+
+// MemberMetadata is a member of a classic group.
+type MemberMetadata struct {
+	MemberId         string  // The member id. (versions: 0+)
+	GroupInstanceId  *string // The static member instance id. (versions: 3+, nullable: 3+)
+	ClientId         string  // The client id. (versions: 0+)
+	ClientHost       string  // The client host. (versions: 0+)
+	RebalanceTimeout int32   // The rebalance timeout; the session timeout in version 0. (versions: 1+)
+	SessionTimeout   int32   // The session timeout. (versions: 0+)
+	Subscription     []byte  // The serialized subscription of the member. (versions: 0+)
+	Assignment       []byte  // The serialized assignment of the member. (versions: 0+)
+}
+
+func (m *GroupMetadataValue) decode(d *protocol.Decoder, version int16) {
+	m.ProtocolType = d.String()
+	m.Generation = d.Int32()
+	m.Protocol = d.NullableString()
+	m.Leader = d.NullableString()
+	m.CurrentStateTimestamp = -1
+	if version >= 2 {
+		m.CurrentStateTimestamp = d.Int64()
+	}
+	m.Members = protocol.DecodeArray(d, func(d *protocol.Decoder) MemberMetadata {
+		var member MemberMetadata
+		member.MemberId = d.String()
+		if version >= 3 {
+			member.GroupInstanceId = d.NullableString()
+		}
+		member.ClientId = d.String()
+		member.ClientHost = d.String()
+		if version >= 1 {
+			member.RebalanceTimeout = d.Int32()
+		}
+		member.SessionTimeout = d.Int32()
+		if version == 0 {
+			// Version 0 has a single timeout used for both.
+			member.RebalanceTimeout = member.SessionTimeout
+		}
+		member.Subscription = d.Bytes()
+		member.Assignment = d.Bytes()
+		d.TaggedFields(noTaggedFields)
+		return member
+	})
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *GroupMetadataValue) encode(e *protocol.Encoder, version int16) {
+	e.String(m.ProtocolType)
+	e.Int32(m.Generation)
+	e.NullableString(m.Protocol)
+	e.NullableString(m.Leader)
+	if version >= 2 {
+		e.Int64(m.CurrentStateTimestamp)
+	}
+	protocol.EncodeArray(e, m.Members, func(e *protocol.Encoder, member MemberMetadata) {
+		e.String(member.MemberId)
+		if version >= 3 {
+			e.NullableString(member.GroupInstanceId)
+		}
+		e.String(member.ClientId)
+		e.String(member.ClientHost)
+		if version >= 1 {
+			e.Int32(member.RebalanceTimeout)
+		}
+		e.Int32(member.SessionTimeout)
+		e.Bytes(member.Subscription)
+		e.Bytes(member.Assignment)
+		e.TaggedFields(nil)
+	})
+	e.TaggedFields(nil)
+}
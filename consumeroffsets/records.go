@@ -0,0 +1,253 @@
+package consumeroffsets
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/scholzj/go-kafka-protocol/protocol"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// The records of the __consumer_offsets topic written by the group coordinator. The key and the
+// value each start with an int16 version. The key version identifies the record type (OffsetCommit
+// keys use versions 0 and 1), the value version is the version of the value schema. A null value
+// is a tombstone that deletes the key.
+
+// Topic is the name of the internal offsets topic.
+const Topic = "__consumer_offsets"
+
+// Record types, identified by the version of their key.
+const (
+	OffsetCommitKeyVersion                          int16 = 1
+	GroupMetadataKeyVersion                         int16 = 2
+	ConsumerGroupMetadataKeyVersion                 int16 = 3
+	ConsumerGroupPartitionMetadataKeyVersion        int16 = 4
+	ConsumerGroupMemberMetadataKeyVersion           int16 = 5
+	ConsumerGroupTargetAssignmentMetadataKeyVersion int16 = 6
+	ConsumerGroupTargetAssignmentMemberKeyVersion   int16 = 7
+	ConsumerGroupCurrentMemberAssignmentKeyVersion  int16 = 8
+	ShareGroupPartitionMetadataKeyVersion           int16 = 9
+	ShareGroupMemberMetadataKeyVersion              int16 = 10
+	ShareGroupMetadataKeyVersion                    int16 = 11
+	ShareGroupTargetAssignmentMetadataKeyVersion    int16 = 12
+	ShareGroupTargetAssignmentMemberKeyVersion      int16 = 13
+	ShareGroupCurrentMemberAssignmentKeyVersion     int16 = 14
+	ShareGroupStatePartitionMetadataKeyVersion      int16 = 15
+	ConsumerGroupRegularExpressionKeyVersion        int16 = 16
+	StreamsGroupMetadataKeyVersion                  int16 = 17
+	StreamsGroupPartitionMetadataKeyVersion         int16 = 18
+	StreamsGroupMemberMetadataKeyVersion            int16 = 19
+	StreamsGroupTargetAssignmentMetadataKeyVersion  int16 = 20
+	StreamsGroupTargetAssignmentMemberKeyVersion    int16 = 21
+	StreamsGroupCurrentMemberAssignmentKeyVersion   int16 = 22
+	StreamsGroupTopologyKeyVersion                  int16 = 23
+)
+
+var (
+	ErrUnknownRecordType  = errors.New("unknown offsets topic record type")
+	ErrUnsupportedVersion = errors.New("unsupported offsets topic value version")
+)
+
+// Message is a key or a value of an offsets topic record.
+type Message interface {
+	decode(d *protocol.Decoder, version int16)
+	encode(e *protocol.Encoder, version int16)
+}
+
+type recordType struct {
+	name             string
+	newKey           func() Message
+	newValue         func() Message
+	maxValueVersion  int16
+	flexibleValuesAt int16 // The first flexible value version.
+}
+
+var recordTypes = map[int16]recordType{
+	0:                                        {"OffsetCommit", func() Message { return &OffsetCommitKey{} }, func() Message { return &OffsetCommitValue{} }, 4, 4},
+	OffsetCommitKeyVersion:                   {"OffsetCommit", func() Message { return &OffsetCommitKey{} }, func() Message { return &OffsetCommitValue{} }, 4, 4},
+	GroupMetadataKeyVersion:                  {"GroupMetadata", func() Message { return &GroupMetadataKey{} }, func() Message { return &GroupMetadataValue{} }, 4, 4},
+	ConsumerGroupMetadataKeyVersion:          {"ConsumerGroupMetadata", func() Message { return &ConsumerGroupMetadataKey{} }, func() Message { return &ConsumerGroupMetadataValue{} }, 0, 0},
+	ConsumerGroupPartitionMetadataKeyVersion: {"ConsumerGroupPartitionMetadata", func() Message { return &ConsumerGroupPartitionMetadataKey{} }, func() Message { return &ConsumerGroupPartitionMetadataValue{} }, 0, 0},
+	ConsumerGroupMemberMetadataKeyVersion:    {"ConsumerGroupMemberMetadata", func() Message { return &ConsumerGroupMemberMetadataKey{} }, func() Message { return &ConsumerGroupMemberMetadataValue{} }, 0, 0},
+	ConsumerGroupTargetAssignmentMetadataKeyVersion: {"ConsumerGroupTargetAssignmentMetadata", func() Message { return &ConsumerGroupTargetAssignmentMetadataKey{} },
+		func() Message { return &ConsumerGroupTargetAssignmentMetadataValue{} }, 0, 0},
+	ConsumerGroupTargetAssignmentMemberKeyVersion: {"ConsumerGroupTargetAssignmentMember", func() Message { return &ConsumerGroupTargetAssignmentMemberKey{} },
+		func() Message { return &ConsumerGroupTargetAssignmentMemberValue{} }, 0, 0},
+	ConsumerGroupCurrentMemberAssignmentKeyVersion: {"ConsumerGroupCurrentMemberAssignment", func() Message { return &ConsumerGroupCurrentMemberAssignmentKey{} },
+		func() Message { return &ConsumerGroupCurrentMemberAssignmentValue{} }, 0, 0},
+	ShareGroupPartitionMetadataKeyVersion: {"ShareGroupPartitionMetadata", func() Message { return &ShareGroupPartitionMetadataKey{} },
+		func() Message { return &ShareGroupPartitionMetadataValue{} }, 0, 0},
+	ShareGroupMemberMetadataKeyVersion: {"ShareGroupMemberMetadata", func() Message { return &ShareGroupMemberMetadataKey{} },
+		func() Message { return &ShareGroupMemberMetadataValue{} }, 0, 0},
+	ShareGroupMetadataKeyVersion: {"ShareGroupMetadata", func() Message { return &ShareGroupMetadataKey{} },
+		func() Message { return &ShareGroupMetadataValue{} }, 0, 0},
+	ShareGroupTargetAssignmentMetadataKeyVersion: {"ShareGroupTargetAssignmentMetadata", func() Message { return &ShareGroupTargetAssignmentMetadataKey{} },
+		func() Message { return &ShareGroupTargetAssignmentMetadataValue{} }, 0, 0},
+	ShareGroupTargetAssignmentMemberKeyVersion: {"ShareGroupTargetAssignmentMember", func() Message { return &ShareGroupTargetAssignmentMemberKey{} },
+		func() Message { return &ShareGroupTargetAssignmentMemberValue{} }, 0, 0},
+	ShareGroupCurrentMemberAssignmentKeyVersion: {"ShareGroupCurrentMemberAssignment", func() Message { return &ShareGroupCurrentMemberAssignmentKey{} },
+		func() Message { return &ShareGroupCurrentMemberAssignmentValue{} }, 0, 0},
+	ShareGroupStatePartitionMetadataKeyVersion: {"ShareGroupStatePartitionMetadata", func() Message { return &ShareGroupStatePartitionMetadataKey{} },
+		func() Message { return &ShareGroupStatePartitionMetadataValue{} }, 0, 0},
+	ConsumerGroupRegularExpressionKeyVersion: {"ConsumerGroupRegularExpression", func() Message { return &ConsumerGroupRegularExpressionKey{} },
+		func() Message { return &ConsumerGroupRegularExpressionValue{} }, 0, 0},
+	StreamsGroupMetadataKeyVersion: {"StreamsGroupMetadata", func() Message { return &StreamsGroupMetadataKey{} },
+		func() Message { return &StreamsGroupMetadataValue{} }, 0, 0},
+	StreamsGroupPartitionMetadataKeyVersion: {"StreamsGroupPartitionMetadata", func() Message { return &StreamsGroupPartitionMetadataKey{} },
+		func() Message { return &StreamsGroupPartitionMetadataValue{} }, 0, 0},
+	StreamsGroupMemberMetadataKeyVersion: {"StreamsGroupMemberMetadata", func() Message { return &StreamsGroupMemberMetadataKey{} },
+		func() Message { return &StreamsGroupMemberMetadataValue{} }, 0, 0},
+	StreamsGroupTargetAssignmentMetadataKeyVersion: {"StreamsGroupTargetAssignmentMetadata", func() Message { return &StreamsGroupTargetAssignmentMetadataKey{} },
+		func() Message { return &StreamsGroupTargetAssignmentMetadataValue{} }, 0, 0},
+	StreamsGroupTargetAssignmentMemberKeyVersion: {"StreamsGroupTargetAssignmentMember", func() Message { return &StreamsGroupTargetAssignmentMemberKey{} },
+		func() Message { return &StreamsGroupTargetAssignmentMemberValue{} }, 0, 0},
+	StreamsGroupCurrentMemberAssignmentKeyVersion: {"StreamsGroupCurrentMemberAssignment", func() Message { return &StreamsGroupCurrentMemberAssignmentKey{} },
+		func() Message { return &StreamsGroupCurrentMemberAssignmentValue{} }, 0, 0},
+	StreamsGroupTopologyKeyVersion: {"StreamsGroupTopology", func() Message { return &StreamsGroupTopologyKey{} },
+		func() Message { return &StreamsGroupTopologyValue{} }, 0, 0},
+}
+
+// Record is a decoded offsets topic record. Value is nil for a tombstone.
+type Record struct {
+	KeyVersion   int16
+	Key          Message
+	ValueVersion int16
+	Value        Message
+}
+
+// Type returns the name of the record type, such as OffsetCommit.
+func (r Record) Type() string {
+	if t, ok := recordTypes[r.KeyVersion]; ok {
+		return t.name
+	}
+	return fmt.Sprintf("Unknown(%d)", r.KeyVersion)
+}
+
+// DecodeRecord decodes the key and the value of an offsets topic record. Keys are never flexible.
+func DecodeRecord(key []byte, value []byte) (Record, error) {
+	kr := bytes.NewReader(key)
+	keyVersion, err := protocol.ReadInt16(kr)
+	if err != nil {
+		return Record{}, fmt.Errorf("offsets topic record key: %w", err)
+	}
+
+	t, ok := recordTypes[keyVersion]
+	if !ok {
+		return Record{}, fmt.Errorf("%w: key version %d", ErrUnknownRecordType, keyVersion)
+	}
+
+	record := Record{KeyVersion: keyVersion, Key: t.newKey()}
+	if err := decodeMessage(kr, record.Key, keyVersion, false); err != nil {
+		return Record{}, fmt.Errorf("%sKey: %w", t.name, err)
+	}
+
+	if value == nil {
+		return record, nil
+	}
+
+	vr := bytes.NewReader(value)
+	if record.ValueVersion, err = protocol.ReadInt16(vr); err != nil {
+		return Record{}, fmt.Errorf("%sValue: %w", t.name, err)
+	}
+	if record.ValueVersion < 0 || record.ValueVersion > t.maxValueVersion {
+		return Record{}, fmt.Errorf("%w: %sValue version %d", ErrUnsupportedVersion, t.name, record.ValueVersion)
+	}
+
+	record.Value = t.newValue()
+	if err := decodeMessage(vr, record.Value, record.ValueVersion, record.ValueVersion >= t.flexibleValuesAt); err != nil {
+		return Record{}, fmt.Errorf("%sValue version %d: %w", t.name, record.ValueVersion, err)
+	}
+
+	return record, nil
+}
+
+func decodeMessage(r *bytes.Reader, message Message, version int16, flexible bool) error {
+	d := protocol.NewDecoder(r, flexible)
+	message.decode(d, version)
+	if err := d.Err(); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%d bytes after the message", r.Len())
+	}
+	return nil
+}
+
+// EncodeRecord encodes an offsets topic record into its key and value. A record without a value
+// encodes to a tombstone with a nil value.
+func EncodeRecord(record Record) ([]byte, []byte, error) {
+	t, ok := recordTypes[record.KeyVersion]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: key version %d", ErrUnknownRecordType, record.KeyVersion)
+	}
+
+	key, err := encodeMessage(record.Key, record.KeyVersion, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%sKey: %w", t.name, err)
+	}
+	if record.Value == nil {
+		return key, nil, nil
+	}
+
+	if record.ValueVersion < 0 || record.ValueVersion > t.maxValueVersion {
+		return nil, nil, fmt.Errorf("%w: %sValue version %d", ErrUnsupportedVersion, t.name, record.ValueVersion)
+	}
+	value, err := encodeMessage(record.Value, record.ValueVersion, record.ValueVersion >= t.flexibleValuesAt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%sValue version %d: %w", t.name, record.ValueVersion, err)
+	}
+
+	return key, value, nil
+}
+
+func encodeMessage(message Message, version int16, flexible bool) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0))
+	if err := protocol.WriteInt16(buf, version); err != nil {
+		return nil, err
+	}
+
+	e := protocol.NewEncoder(buf, flexible)
+	message.encode(e, version)
+	if err := e.Err(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DecodeBatch decodes the records of a data batch of the offsets topic. Control batches (the
+// markers of transactional offset commits) decode to an empty slice. Records of types this package
+// does not know, written by newer group coordinators, are not decoded: they are returned with only
+// their KeyVersion, so that Type reports them as unknown. State.ApplyBatch skips them the same way.
+func DecodeBatch(batch *records.RecordBatch) ([]Record, error) {
+	decoded := make([]Record, 0, len(batch.Records))
+	if batch.IsControl() {
+		return decoded, nil
+	}
+
+	for _, record := range batch.Records {
+		r, err := decodeBatchRecord(record)
+		if err != nil {
+			return nil, fmt.Errorf("offset %d: %w", batch.Offset(record), err)
+		}
+		decoded = append(decoded, r)
+	}
+
+	return decoded, nil
+}
+
+// decodeBatchRecord decodes a record of a data batch, returning a record of an unknown type with
+// only its key version and a nil Key.
+func decodeBatchRecord(record records.Record) (Record, error) {
+	r, err := DecodeRecord(record.Key, record.Value)
+	if errors.Is(err, ErrUnknownRecordType) {
+		return Record{KeyVersion: int16(binary.BigEndian.Uint16(record.Key))}, nil
+	}
+	return r, err
+}
+
+func noTaggedFields(*protocol.Decoder, uint64) bool {
+	return false
+}
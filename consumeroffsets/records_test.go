@@ -0,0 +1,276 @@
+package consumeroffsets
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/compression"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+var topicId = uuid.MustParse("5d5e6f70-8192-4a3b-8c4d-5e6f708192a3")
+
+func TestRecordRoundTrip(t *testing.T) {
+	offsetKey := &OffsetCommitKey{Group: "group", Topic: "orders", Partition: 3}
+	member := MemberMetadata{MemberId: "consumer-1", ClientId: "client", ClientHost: "/10.0.0.1", RebalanceTimeout: 60000, SessionTimeout: 45000, Subscription: []byte{0, 1}, Assignment: []byte{}}
+	static := member
+	static.GroupInstanceId = ptr("instance-1")
+	sameTimeouts := member
+	sameTimeouts.RebalanceTimeout = sameTimeouts.SessionTimeout
+
+	tests := []Record{
+		{0, offsetKey, 0, &OffsetCommitValue{Offset: 100, LeaderEpoch: -1, Metadata: "m", CommitTimestamp: 5, ExpireTimestamp: NoExpireTimestamp}},
+		{1, offsetKey, 1, &OffsetCommitValue{Offset: 100, LeaderEpoch: -1, CommitTimestamp: 5, ExpireTimestamp: 6}},
+		{1, offsetKey, 2, &OffsetCommitValue{Offset: 100, LeaderEpoch: -1, CommitTimestamp: 5, ExpireTimestamp: NoExpireTimestamp}},
+		{1, offsetKey, 3, &OffsetCommitValue{Offset: 100, LeaderEpoch: 7, CommitTimestamp: 5, ExpireTimestamp: NoExpireTimestamp}},
+		{1, offsetKey, 4, &OffsetCommitValue{Offset: 100, LeaderEpoch: 7, Metadata: "m", CommitTimestamp: 5, ExpireTimestamp: NoExpireTimestamp}},
+		{1, offsetKey, 0, nil},
+		{2, &GroupMetadataKey{Group: "group"}, 0, &GroupMetadataValue{ProtocolType: "consumer", Generation: 1, Protocol: ptr("range"), Leader: ptr("consumer-1"), CurrentStateTimestamp: -1, Members: []MemberMetadata{sameTimeouts}}},
+		{2, &GroupMetadataKey{Group: "group"}, 1, &GroupMetadataValue{ProtocolType: "consumer", Generation: 1, CurrentStateTimestamp: -1, Members: []MemberMetadata{member}}},
+		{2, &GroupMetadataKey{Group: "group"}, 2, &GroupMetadataValue{ProtocolType: "consumer", Generation: 2, CurrentStateTimestamp: 1000, Members: []MemberMetadata{}}},
+		{2, &GroupMetadataKey{Group: "group"}, 3, &GroupMetadataValue{ProtocolType: "consumer", Generation: 3, Protocol: ptr("range"), Leader: ptr("consumer-1"), CurrentStateTimestamp: 1000, Members: []MemberMetadata{static}}},
+		{2, &GroupMetadataKey{Group: "group"}, 4, &GroupMetadataValue{ProtocolType: "consumer", Generation: 4, CurrentStateTimestamp: 1000, Members: []MemberMetadata{static}}},
+		{3, &ConsumerGroupMetadataKey{GroupId: "cg"}, 0, &ConsumerGroupMetadataValue{Epoch: 5}},
+		{4, &ConsumerGroupPartitionMetadataKey{GroupId: "cg"}, 0, &ConsumerGroupPartitionMetadataValue{Topics: []TopicMetadata{{TopicId: topicId, TopicName: "orders", NumPartitions: 2, PartitionMetadata: []PartitionMetadata{{Partition: 0, Racks: []string{"a", "b"}}}}}}},
+		{5, &ConsumerGroupMemberMetadataKey{GroupId: "cg", MemberId: "m1"}, 0, &ConsumerGroupMemberMetadataValue{RackId: ptr("a"), ClientId: "client", ClientHost: "/10.0.0.1", SubscribedTopicNames: []string{"orders"}, RebalanceTimeoutMs: 60000, ServerAssignor: ptr("uniform")}},
+		{5, &ConsumerGroupMemberMetadataKey{GroupId: "cg", MemberId: "m2"}, 0, &ConsumerGroupMemberMetadataValue{InstanceId: ptr("i"), ClientId: "client", ClientHost: "/10.0.0.2", SubscribedTopicNames: []string{}, SubscribedTopicRegex: ptr("orders.*"), RebalanceTimeoutMs: -1,
+			ClassicMemberMetadata: &ClassicMemberMetadata{SessionTimeoutMs: 45000, SupportedProtocols: []ClassicProtocol{{Name: "range", Metadata: []byte{1}}}}}},
+		{6, &ConsumerGroupTargetAssignmentMetadataKey{GroupId: "cg"}, 0, &ConsumerGroupTargetAssignmentMetadataValue{AssignmentEpoch: 5}},
+		{7, &ConsumerGroupTargetAssignmentMemberKey{GroupId: "cg", MemberId: "m1"}, 0, &ConsumerGroupTargetAssignmentMemberValue{TopicPartitions: []TopicPartitions{{TopicId: topicId, Partitions: []int32{0, 1}}}}},
+		{8, &ConsumerGroupCurrentMemberAssignmentKey{GroupId: "cg", MemberId: "m1"}, 0, &ConsumerGroupCurrentMemberAssignmentValue{MemberEpoch: 5, PreviousMemberEpoch: 4, State: MemberStateUnrevokedPartitions,
+			AssignedPartitions: []TopicPartitions{{TopicId: topicId, Partitions: []int32{0}}}, PartitionsPendingRevocation: []TopicPartitions{{TopicId: topicId, Partitions: []int32{1}}}}},
+		{9, &ShareGroupPartitionMetadataKey{GroupId: "sg"}, 0, &ShareGroupPartitionMetadataValue{Topics: []TopicMetadata{{TopicId: topicId, TopicName: "orders", NumPartitions: 2, PartitionMetadata: []PartitionMetadata{}}}}},
+		{10, &ShareGroupMemberMetadataKey{GroupId: "sg", MemberId: "m1"}, 0, &ShareGroupMemberMetadataValue{ClientId: "client", ClientHost: "/10.0.0.1", SubscribedTopicNames: []string{"orders"}}},
+		{11, &ShareGroupMetadataKey{GroupId: "sg"}, 0, &ShareGroupMetadataValue{Epoch: 3}},
+		{12, &ShareGroupTargetAssignmentMetadataKey{GroupId: "sg"}, 0, &ShareGroupTargetAssignmentMetadataValue{AssignmentEpoch: 3}},
+		{13, &ShareGroupTargetAssignmentMemberKey{GroupId: "sg", MemberId: "m1"}, 0, &ShareGroupTargetAssignmentMemberValue{TopicPartitions: []TopicPartitions{{TopicId: topicId, Partitions: []int32{0, 1}}}}},
+		{14, &ShareGroupCurrentMemberAssignmentKey{GroupId: "sg", MemberId: "m1"}, 0, &ShareGroupCurrentMemberAssignmentValue{MemberEpoch: 3, PreviousMemberEpoch: 2, State: MemberStateStable,
+			AssignedPartitions: []TopicPartitions{{TopicId: topicId, Partitions: []int32{0, 1}}}}},
+		{15, &ShareGroupStatePartitionMetadataKey{GroupId: "sg"}, 0, &ShareGroupStatePartitionMetadataValue{InitializingTopics: []TopicPartitionsInfo{{TopicId: topicId, TopicName: "orders", Partitions: []int32{1}}},
+			InitializedTopics: []TopicPartitionsInfo{{TopicId: topicId, TopicName: "orders", Partitions: []int32{0}}}, DeletingTopics: []TopicInfo{{TopicId: topicId, TopicName: "old"}}}},
+		{16, &ConsumerGroupRegularExpressionKey{GroupId: "cg", RegularExpression: "orders.*"}, 0, &ConsumerGroupRegularExpressionValue{Topics: []string{"orders", "orders-dlq"}, Version: 42, Timestamp: 1000}},
+		{17, &StreamsGroupMetadataKey{GroupId: "app"}, 0, &StreamsGroupMetadataValue{Epoch: 4, MetadataHash: -12345}},
+		{18, &StreamsGroupPartitionMetadataKey{GroupId: "app"}, 0, &StreamsGroupPartitionMetadataValue{Topics: []StreamsTopicMetadata{{TopicId: topicId, TopicName: "orders", NumPartitions: 2}}}},
+		{19, &StreamsGroupMemberMetadataKey{GroupId: "app", MemberId: "m1"}, 0, &StreamsGroupMemberMetadataValue{RackId: ptr("a"), ClientId: "client", ClientHost: "/10.0.0.1", RebalanceTimeoutMs: 60000, TopologyEpoch: 1,
+			ProcessId: "process", UserEndpoint: &StreamsEndpoint{Host: "app-0", Port: 8080}, ClientTags: []KeyValue{{Key: "zone", Value: "a"}}}},
+		{19, &StreamsGroupMemberMetadataKey{GroupId: "app", MemberId: "m2"}, 0, &StreamsGroupMemberMetadataValue{InstanceId: ptr("i"), ClientId: "client", ClientHost: "/10.0.0.2", TopologyEpoch: 1, ProcessId: "process", ClientTags: []KeyValue{}}},
+		{20, &StreamsGroupTargetAssignmentMetadataKey{GroupId: "app"}, 0, &StreamsGroupTargetAssignmentMetadataValue{AssignmentEpoch: 4}},
+		{21, &StreamsGroupTargetAssignmentMemberKey{GroupId: "app", MemberId: "m1"}, 0, &StreamsGroupTargetAssignmentMemberValue{ActiveTasks: []TaskIds{{SubtopologyId: "0", Partitions: []int32{0, 1}}},
+			StandbyTasks: []TaskIds{}, WarmupTasks: []TaskIds{{SubtopologyId: "1", Partitions: []int32{0}}}}},
+		{22, &StreamsGroupCurrentMemberAssignmentKey{GroupId: "app", MemberId: "m1"}, 0, &StreamsGroupCurrentMemberAssignmentValue{MemberEpoch: 4, PreviousMemberEpoch: 3, State: MemberStateUnrevokedPartitions,
+			ActiveTasks: []TaskIds{{SubtopologyId: "0", Partitions: []int32{0}}}, StandbyTasks: []TaskIds{}, WarmupTasks: []TaskIds{}, ActiveTasksPendingRevocation: []TaskIds{{SubtopologyId: "0", Partitions: []int32{1}}}}},
+		{23, &StreamsGroupTopologyKey{GroupId: "app"}, 0, &StreamsGroupTopologyValue{Epoch: 1, Subtopologies: []Subtopology{{SubtopologyId: "0", SourceTopics: []string{"orders"}, SourceTopicRegex: []string{},
+			StateChangelogTopics:    []StreamsTopicInfo{{Name: "app-store-changelog", TopicConfigs: []KeyValue{{Key: "cleanup.policy", Value: "compact"}}}},
+			RepartitionSinkTopics:   []string{"app-repartition"},
+			RepartitionSourceTopics: []StreamsTopicInfo{{Name: "app-repartition", Partitions: 2, ReplicationFactor: 3, TopicConfigs: []KeyValue{}}},
+			CopartitionGroups:       []CopartitionGroup{{SourceTopics: []int16{0}, SourceTopicRegex: []int16{}, RepartitionSourceTopics: []int16{0}}}}}}},
+	}
+
+	covered := make(map[int16]bool)
+	for _, test := range tests {
+		t.Run(test.Type(), func(t *testing.T) {
+			key, value, err := EncodeRecord(test)
+			if err != nil {
+				t.Fatalf("EncodeRecord: %v", err)
+			}
+			if (value == nil) != (test.Value == nil) {
+				t.Fatalf("tombstone mismatch: value %v", value)
+			}
+
+			decoded, err := DecodeRecord(key, value)
+			if err != nil {
+				t.Fatalf("DecodeRecord: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, test) {
+				t.Errorf("round trip of key version %d, value version %d:\n got %+v\nwant %+v", test.KeyVersion, test.ValueVersion, decoded, test)
+			}
+		})
+		covered[test.KeyVersion] = true
+	}
+
+	for keyVersion := range recordTypes {
+		if !covered[keyVersion] {
+			t.Errorf("key version %d is not covered", keyVersion)
+		}
+	}
+}
+
+func TestDecodeKnownBytes(t *testing.T) {
+	key := []byte{0, 1, 0, 1, 'g', 0, 1, 't', 0, 0, 0, 2}
+	value := []byte{
+		0, 1, // version
+		0, 0, 0, 0, 0, 0, 0, 100, // offset
+		0, 0, // metadata
+		0, 0, 0, 0, 0, 0, 0, 5, // commit timestamp
+		0, 0, 0, 0, 0, 0, 0, 6, // expire timestamp
+	}
+
+	record, err := DecodeRecord(key, value)
+	if err != nil {
+		t.Fatalf("DecodeRecord: %v", err)
+	}
+	want := Record{1, &OffsetCommitKey{Group: "g", Topic: "t", Partition: 2}, 1, &OffsetCommitValue{Offset: 100, LeaderEpoch: -1, CommitTimestamp: 5, ExpireTimestamp: 6}}
+	if !reflect.DeepEqual(record, want) {
+		t.Errorf("got %+v, want %+v", record, want)
+	}
+
+	// The flexible version 4 with an unknown tagged field.
+	value = []byte{0, 4, 0, 0, 0, 0, 0, 0, 0, 100, 0, 0, 0, 7, 1, 0, 0, 0, 0, 0, 0, 0, 5, 1, 9, 2, 0xff, 0xff}
+	record, err = DecodeRecord(key, value)
+	if err != nil {
+		t.Fatalf("DecodeRecord: %v", err)
+	}
+	if v := record.Value.(*OffsetCommitValue); v.Offset != 100 || v.LeaderEpoch != 7 || v.CommitTimestamp != 5 {
+		t.Errorf("unexpected value %+v", v)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	if _, err := DecodeRecord([]byte{0, 99, 0, 0}, nil); !errors.Is(err, ErrUnknownRecordType) {
+		t.Errorf("unknown key version error = %v", err)
+	}
+	if _, err := DecodeRecord([]byte{0, 2, 0, 1, 'g'}, []byte{0, 5}); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("unsupported value version error = %v", err)
+	}
+	if _, err := DecodeRecord([]byte{0, 2, 0, 5, 'g'}, nil); err == nil {
+		t.Error("expected an error for a truncated key")
+	}
+	if _, err := DecodeRecord([]byte{0, 2, 0, 1, 'g', 0}, nil); err == nil {
+		t.Error("expected an error for trailing key bytes")
+	}
+}
+
+func batchOf(t *testing.T, baseOffset int64, producerId int64, transactional bool, recs ...Record) *records.RecordBatch {
+	t.Helper()
+
+	batch := records.NewRecordBatch(baseOffset, compression.None)
+	if transactional {
+		batch.Attributes |= records.TransactionalAttribute
+		batch.ProducerId = producerId
+		batch.ProducerEpoch = 0
+	}
+	for _, r := range recs {
+		key, value, err := EncodeRecord(r)
+		if err != nil {
+			t.Fatalf("EncodeRecord: %v", err)
+		}
+		batch.AppendRecord(1000, key, value, nil)
+	}
+	return batch
+}
+
+func commit(group string, partition int32, offset int64) Record {
+	return Record{1, &OffsetCommitKey{Group: group, Topic: "orders", Partition: partition}, 3, &OffsetCommitValue{Offset: offset, LeaderEpoch: 1, CommitTimestamp: 1000, ExpireTimestamp: NoExpireTimestamp}}
+}
+
+func TestStateReplay(t *testing.T) {
+	state := NewState()
+
+	batches := []*records.RecordBatch{
+		batchOf(t, 0, -1, false,
+			commit("group", 0, 10),
+			commit("group", 1, 20),
+			Record{2, &GroupMetadataKey{Group: "group"}, 3, &GroupMetadataValue{ProtocolType: "consumer", Generation: 1, CurrentStateTimestamp: 1000, Members: []MemberMetadata{}}},
+			Record{3, &ConsumerGroupMetadataKey{GroupId: "cg"}, 0, &ConsumerGroupMetadataValue{Epoch: 2}},
+			Record{5, &ConsumerGroupMemberMetadataKey{GroupId: "cg", MemberId: "m1"}, 0, &ConsumerGroupMemberMetadataValue{ClientId: "c", SubscribedTopicNames: []string{"orders"}}},
+			Record{7, &ConsumerGroupTargetAssignmentMemberKey{GroupId: "cg", MemberId: "m1"}, 0, &ConsumerGroupTargetAssignmentMemberValue{TopicPartitions: []TopicPartitions{}}},
+			Record{6, &ConsumerGroupTargetAssignmentMetadataKey{GroupId: "cg"}, 0, &ConsumerGroupTargetAssignmentMetadataValue{AssignmentEpoch: 2}},
+		),
+		// The offset of partition 0 is deleted, the member metadata is deleted but its assignment remains.
+		batchOf(t, 7, -1, false,
+			Record{1, &OffsetCommitKey{Group: "group", Topic: "orders", Partition: 0}, 0, nil},
+			Record{5, &ConsumerGroupMemberMetadataKey{GroupId: "cg", MemberId: "m1"}, 0, nil},
+		),
+		batchOf(t, 9, 42, true, commit("group", 1, 25)),
+		batchOf(t, 10, 43, true, commit("group", 1, 99)),
+		records.NewEndTransactionMarkerBatch(11, 1000, 43, 0, records.EndTransactionMarker{Type: records.ControlAbort}),
+	}
+	for _, batch := range batches {
+		if err := state.ApplyBatch(batch); err != nil {
+			t.Fatalf("ApplyBatch: %v", err)
+		}
+	}
+
+	if _, ok := state.Offsets[OffsetKey{"group", "orders", 0}]; ok {
+		t.Error("the deleted offset is still present")
+	}
+	if v := state.Offsets[OffsetKey{"group", "orders", 1}]; v == nil || v.Offset != 20 {
+		t.Errorf("offset before the commit marker = %+v, want 20", v)
+	}
+	if state.PendingTransactions() != 1 {
+		t.Errorf("PendingTransactions = %d, want 1", state.PendingTransactions())
+	}
+
+	if err := state.ApplyBatch(records.NewEndTransactionMarkerBatch(12, 1000, 42, 0, records.EndTransactionMarker{Type: records.ControlCommit})); err != nil {
+		t.Fatalf("ApplyBatch: %v", err)
+	}
+	if v := state.Offsets[OffsetKey{"group", "orders", 1}]; v == nil || v.Offset != 25 {
+		t.Errorf("offset after the commit marker = %+v, want 25", v)
+	}
+	if state.PendingTransactions() != 0 || state.NextOffset() != 13 {
+		t.Errorf("PendingTransactions = %d, NextOffset = %d", state.PendingTransactions(), state.NextOffset())
+	}
+	if offsets := state.GroupOffsets("group"); len(offsets) != 1 {
+		t.Errorf("GroupOffsets = %v", offsets)
+	}
+
+	if group := state.ClassicGroups["group"]; group == nil || group.Generation != 1 {
+		t.Errorf("classic group = %+v", group)
+	}
+	cg := state.ConsumerGroups["cg"]
+	if cg == nil || cg.Epoch != 2 || cg.AssignmentEpoch != 2 {
+		t.Fatalf("consumer group = %+v", cg)
+	}
+	if m := cg.Members["m1"]; m == nil || m.Metadata != nil || m.TargetAssignment == nil {
+		t.Errorf("member = %+v", m)
+	}
+
+	// Replaying an already applied batch changes nothing; deleting the last member record removes
+	// the member, the group tombstone removes the group.
+	if err := state.ApplyBatch(batches[0]); err != nil {
+		t.Fatalf("ApplyBatch: %v", err)
+	}
+	if _, ok := state.Offsets[OffsetKey{"group", "orders", 0}]; ok {
+		t.Error("an already applied batch was replayed")
+	}
+	state.Apply(Record{7, &ConsumerGroupTargetAssignmentMemberKey{GroupId: "cg", MemberId: "m1"}, 0, nil})
+	if len(cg.Members) != 0 {
+		t.Errorf("members = %v", cg.Members)
+	}
+	state.Apply(Record{3, &ConsumerGroupMetadataKey{GroupId: "cg"}, 0, nil})
+	if len(state.ConsumerGroups) != 0 {
+		t.Errorf("consumer groups = %v", state.ConsumerGroups)
+	}
+}
+
+func TestStateSkipsUnknownRecordTypes(t *testing.T) {
+	batch := records.NewRecordBatch(0, compression.None)
+	batch.AppendRecord(1000, []byte{0, 99, 0, 1, 's'}, []byte{0, 0, 0}, nil)
+	key, value, _ := EncodeRecord(commit("group", 0, 10))
+	batch.AppendRecord(1000, key, value, nil)
+
+	state := NewState()
+	if err := state.ApplyBatch(batch); err != nil {
+		t.Fatalf("ApplyBatch: %v", err)
+	}
+	if state.Skipped != 1 || len(state.Offsets) != 1 {
+		t.Errorf("Skipped = %d, offsets = %d", state.Skipped, len(state.Offsets))
+	}
+
+	decoded, err := DecodeBatch(batch)
+	if err != nil {
+		t.Fatalf("DecodeBatch: %v", err)
+	}
+	if len(decoded) != 2 || decoded[0].Key != nil || decoded[0].Value != nil || decoded[0].Type() != "Unknown(99)" || decoded[1].Type() != "OffsetCommit" {
+		t.Errorf("DecodeBatch = %+v", decoded)
+	}
+	if _, err := DecodeRecord(batch.Records[0].Key, batch.Records[0].Value); !errors.Is(err, ErrUnknownRecordType) {
+		t.Errorf("DecodeRecord error = %v, want ErrUnknownRecordType", err)
+	}
+}
@@ -0,0 +1,290 @@
+package consumeroffsets
+
+import (
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+////////////////////
+// KIP-932 share groups
+////////////////////
+
+// The keys of the share group records are not flexible, all values are flexible from version 0.
+
+// ShareGroupPartitionMetadataKey is the key of the metadata of the topics subscribed by a share
+// group.
+type ShareGroupPartitionMetadataKey struct {
+	GroupId string // The group id. (versions: 9)
+}
+
+func (m *ShareGroupPartitionMetadataKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+}
+
+func (m *ShareGroupPartitionMetadataKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+}
+
+// ShareGroupPartitionMetadataValue is the metadata of the topics subscribed by a share group.
+type ShareGroupPartitionMetadataValue struct {
+	Topics []TopicMetadata // The subscribed topics. (versions: 0+)
+}
+
+func (m *ShareGroupPartitionMetadataValue) decode(d *protocol.Decoder, version int16) {
+	m.Topics = decodeTopicMetadata(d)
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ShareGroupPartitionMetadataValue) encode(e *protocol.Encoder, version int16) {
+	encodeTopicMetadata(e, m.Topics)
+	e.TaggedFields(nil)
+}
+
+// ShareGroupMemberMetadataKey is the key of the metadata of a share group member.
+type ShareGroupMemberMetadataKey struct {
+	GroupId  string // The group id. (versions: 10)
+	MemberId string // The member id. (versions: 10)
+}
+
+func (m *ShareGroupMemberMetadataKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+	m.MemberId = d.String()
+}
+
+func (m *ShareGroupMemberMetadataKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+	e.String(m.MemberId)
+}
+
+// ShareGroupMemberMetadataValue is the metadata of a share group member.
+type ShareGroupMemberMetadataValue struct {
+	RackId               *string  // The rack of the member. (versions: 0+, nullable: 0+)
+	ClientId             string   // The client id. (versions: 0+)
+	ClientHost           string   // The client host. (versions: 0+)
+	SubscribedTopicNames []string // The subscribed topic names. (versions: 0+)
+}
+
+func (m *ShareGroupMemberMetadataValue) decode(d *protocol.Decoder, version int16) {
+	m.RackId = d.NullableString()
+	m.ClientId = d.String()
+	m.ClientHost = d.String()
+	m.SubscribedTopicNames = protocol.DecodeArray(d, (*protocol.Decoder).String)
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ShareGroupMemberMetadataValue) encode(e *protocol.Encoder, version int16) {
+	e.NullableString(m.RackId)
+	e.String(m.ClientId)
+	e.String(m.ClientHost)
+	protocol.EncodeArray(e, m.SubscribedTopicNames, (*protocol.Encoder).String)
+	e.TaggedFields(nil)
+}
+
+// ShareGroupMetadataKey is the key of the epoch of a share group.
+type ShareGroupMetadataKey struct {
+	GroupId string // The group id. (versions: 11)
+}
+
+func (m *ShareGroupMetadataKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+}
+
+func (m *ShareGroupMetadataKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+}
+
+// ShareGroupMetadataValue is the epoch of a share group.
+type ShareGroupMetadataValue struct {
+	Epoch int32 // The group epoch. (versions: 0+)
+}
+
+func (m *ShareGroupMetadataValue) decode(d *protocol.Decoder, version int16) {
+	m.Epoch = d.Int32()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ShareGroupMetadataValue) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.Epoch)
+	e.TaggedFields(nil)
+}
+
+// ShareGroupTargetAssignmentMetadataKey is the key of the epoch of the target assignment of a
+// share group.
+type ShareGroupTargetAssignmentMetadataKey struct {
+	GroupId string // The group id. (versions: 12)
+}
+
+func (m *ShareGroupTargetAssignmentMetadataKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+}
+
+func (m *ShareGroupTargetAssignmentMetadataKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+}
+
+// ShareGroupTargetAssignmentMetadataValue is the epoch of the target assignment of a share group.
+type ShareGroupTargetAssignmentMetadataValue struct {
+	AssignmentEpoch int32 // The group epoch of the target assignment. (versions: 0+)
+}
+
+func (m *ShareGroupTargetAssignmentMetadataValue) decode(d *protocol.Decoder, version int16) {
+	m.AssignmentEpoch = d.Int32()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ShareGroupTargetAssignmentMetadataValue) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.AssignmentEpoch)
+	e.TaggedFields(nil)
+}
+
+// ShareGroupTargetAssignmentMemberKey is the key of the target assignment of a share group member.
+type ShareGroupTargetAssignmentMemberKey struct {
+	GroupId  string // The group id. (versions: 13)
+	MemberId string // The member id. (versions: 13)
+}
+
+func (m *ShareGroupTargetAssignmentMemberKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+	m.MemberId = d.String()
+}
+
+func (m *ShareGroupTargetAssignmentMemberKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+	e.String(m.MemberId)
+}
+
+// ShareGroupTargetAssignmentMemberValue is the target assignment of a share group member.
+type ShareGroupTargetAssignmentMemberValue struct {
+	TopicPartitions []TopicPartitions // The assigned partitions. (versions: 0+)
+}
+
+func (m *ShareGroupTargetAssignmentMemberValue) decode(d *protocol.Decoder, version int16) {
+	m.TopicPartitions = decodeTopicPartitions(d)
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ShareGroupTargetAssignmentMemberValue) encode(e *protocol.Encoder, version int16) {
+	encodeTopicPartitions(e, m.TopicPartitions)
+	e.TaggedFields(nil)
+}
+
+// ShareGroupCurrentMemberAssignmentKey is the key of the current assignment of a share group
+// member.
+type ShareGroupCurrentMemberAssignmentKey struct {
+	GroupId  string // The group id. (versions: 14)
+	MemberId string // The member id. (versions: 14)
+}
+
+func (m *ShareGroupCurrentMemberAssignmentKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+	m.MemberId = d.String()
+}
+
+func (m *ShareGroupCurrentMemberAssignmentKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+	e.String(m.MemberId)
+}
+
+// ShareGroupCurrentMemberAssignmentValue is the current assignment of a share group member. Share
+// group members never revoke partitions.
+type ShareGroupCurrentMemberAssignmentValue struct {
+	MemberEpoch         int32             // The current member epoch. (versions: 0+)
+	PreviousMemberEpoch int32             // The previous member epoch. (versions: 0+)
+	State               int8              // The member state. (versions: 0+)
+	AssignedPartitions  []TopicPartitions // The partitions assigned to the member. (versions: 0+)
+}
+
+func (m *ShareGroupCurrentMemberAssignmentValue) decode(d *protocol.Decoder, version int16) {
+	m.MemberEpoch = d.Int32()
+	m.PreviousMemberEpoch = d.Int32()
+	m.State = d.Int8()
+	m.AssignedPartitions = decodeTopicPartitions(d)
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ShareGroupCurrentMemberAssignmentValue) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.MemberEpoch)
+	e.Int32(m.PreviousMemberEpoch)
+	e.Int8(m.State)
+	encodeTopicPartitions(e, m.AssignedPartitions)
+	e.TaggedFields(nil)
+}
+
+// ShareGroupStatePartitionMetadataKey is the key of the partitions of a share group with share
+// group state.
+type ShareGroupStatePartitionMetadataKey struct {
+	GroupId string // The group id. (versions: 15)
+}
+
+func (m *ShareGroupStatePartitionMetadataKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+}
+
+func (m *ShareGroupStatePartitionMetadataKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+}
+
+// ShareGroupStatePartitionMetadataValue tracks the partitions whose share group state is being
+// initialized, is initialized or is being deleted.
+type ShareGroupStatePartitionMetadataValue struct {
+	InitializingTopics []TopicPartitionsInfo // The partitions whose state is being initialized. (versions: 0+)
+	InitializedTopics  []TopicPartitionsInfo // The partitions with initialized state. (versions: 0+)
+	DeletingTopics     []TopicInfo           // The topics whose state is being deleted. (versions: 0+)
+}
+
+// TopicPartitionsInfo are partitions of a topic with its name.
+type TopicPartitionsInfo struct {
+	TopicId    uuid.UUID // The topic id. (versions: 0+)
+	TopicName  string    // The topic name. (versions: 0+)
+	Partitions []int32   // The partition indexes. (versions: 0+)
+}
+
+// TopicInfo identifies a topic.
+type TopicInfo struct {
+	TopicId   uuid.UUID // The topic id. (versions: 0+)
+	TopicName string    // The topic name. (versions: 0+)
+}
+
+func decodeTopicPartitionsInfo(d *protocol.Decoder) []TopicPartitionsInfo {
+	return protocol.DecodeArray(d, func(d *protocol.Decoder) TopicPartitionsInfo {
+		var topic TopicPartitionsInfo
+		topic.TopicId = d.UUID()
+		topic.TopicName = d.String()
+		topic.Partitions = protocol.DecodeArray(d, (*protocol.Decoder).Int32)
+		d.TaggedFields(noTaggedFields)
+		return topic
+	})
+}
+
+func encodeTopicPartitionsInfo(e *protocol.Encoder, values []TopicPartitionsInfo) {
+	protocol.EncodeArray(e, values, func(e *protocol.Encoder, topic TopicPartitionsInfo) {
+		e.UUID(topic.TopicId)
+		e.String(topic.TopicName)
+		protocol.EncodeArray(e, topic.Partitions, (*protocol.Encoder).Int32)
+		e.TaggedFields(nil)
+	})
+}
+
+func (m *ShareGroupStatePartitionMetadataValue) decode(d *protocol.Decoder, version int16) {
+	m.InitializingTopics = decodeTopicPartitionsInfo(d)
+	m.InitializedTopics = decodeTopicPartitionsInfo(d)
+	m.DeletingTopics = protocol.DecodeArray(d, func(d *protocol.Decoder) TopicInfo {
+		var topic TopicInfo
+		topic.TopicId = d.UUID()
+		topic.TopicName = d.String()
+		d.TaggedFields(noTaggedFields)
+		return topic
+	})
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ShareGroupStatePartitionMetadataValue) encode(e *protocol.Encoder, version int16) {
+	encodeTopicPartitionsInfo(e, m.InitializingTopics)
+	encodeTopicPartitionsInfo(e, m.InitializedTopics)
+	protocol.EncodeArray(e, m.DeletingTopics, func(e *protocol.Encoder, topic TopicInfo) {
+		e.UUID(topic.TopicId)
+		e.String(topic.TopicName)
+		e.TaggedFields(nil)
+	})
+	e.TaggedFields(nil)
+}
@@ -0,0 +1,217 @@
+package consumeroffsets
+
+import (
+	"fmt"
+
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// OffsetKey identifies a committed offset.
+type OffsetKey struct {
+	Group     string
+	Topic     string
+	Partition int32
+}
+
+// ConsumerGroup is the state of a KIP-848 consumer group.
+type ConsumerGroup struct {
+	GroupId              string
+	Epoch                int32
+	AssignmentEpoch      int32
+	SubscriptionMetadata []TopicMetadata
+	Members              map[string]*ConsumerGroupMember
+}
+
+// ConsumerGroupMember is a member of a consumer group. Each part is nil until its record was
+// replayed.
+type ConsumerGroupMember struct {
+	MemberId          string
+	Metadata          *ConsumerGroupMemberMetadataValue
+	TargetAssignment  *ConsumerGroupTargetAssignmentMemberValue
+	CurrentAssignment *ConsumerGroupCurrentMemberAssignmentValue
+}
+
+func (m *ConsumerGroupMember) empty() bool {
+	return m.Metadata == nil && m.TargetAssignment == nil && m.CurrentAssignment == nil
+}
+
+// State is the group coordinator state rebuilt by replaying a partition of the offsets topic:
+// the committed offsets, the classic groups and the consumer groups.
+type State struct {
+	Offsets        map[OffsetKey]*OffsetCommitValue
+	ClassicGroups  map[string]*GroupMetadataValue
+	ConsumerGroups map[string]*ConsumerGroup
+	Skipped        int // The number of records of types this package does not know, written by newer group coordinators.
+
+	nextOffset int64
+	pending    map[int64][]Record // Transactional offset commits by producer id.
+}
+
+// NewState returns an empty state.
+func NewState() *State {
+	return &State{
+		Offsets:        make(map[OffsetKey]*OffsetCommitValue),
+		ClassicGroups:  make(map[string]*GroupMetadataValue),
+		ConsumerGroups: make(map[string]*ConsumerGroup),
+		pending:        make(map[int64][]Record),
+	}
+}
+
+// NextOffset returns the offset of the next batch to apply.
+func (s *State) NextOffset() int64 {
+	return s.nextOffset
+}
+
+// GroupOffsets returns the committed offsets of a group.
+func (s *State) GroupOffsets(group string) map[OffsetKey]*OffsetCommitValue {
+	offsets := make(map[OffsetKey]*OffsetCommitValue)
+	for key, value := range s.Offsets {
+		if key.Group == group {
+			offsets[key] = value
+		}
+	}
+	return offsets
+}
+
+// PendingTransactions returns the number of producers with transactional offset commits which
+// were neither committed nor aborted yet.
+func (s *State) PendingTransactions() int {
+	return len(s.pending)
+}
+
+// ApplyBatch replays a batch of the offsets topic. Batches below NextOffset were already applied
+// and are ignored. Offsets committed in a transaction become visible with the commit marker and
+// are dropped with the abort marker.
+func (s *State) ApplyBatch(batch *records.RecordBatch) error {
+	if batch.NextOffset() <= s.nextOffset {
+		return nil
+	}
+
+	if batch.IsControl() {
+		for _, record := range batch.Records {
+			marker, err := records.DecodeEndTransactionMarker(record)
+			if err != nil {
+				return fmt.Errorf("offset %d: %w", batch.Offset(record), err)
+			}
+			if marker.Type == records.ControlCommit {
+				for _, r := range s.pending[batch.ProducerId] {
+					s.Apply(r)
+				}
+			}
+			delete(s.pending, batch.ProducerId)
+		}
+		s.nextOffset = batch.NextOffset()
+		return nil
+	}
+
+	for _, record := range batch.Records {
+		if batch.Offset(record) < s.nextOffset {
+			continue
+		}
+
+		r, err := decodeBatchRecord(record)
+		if err != nil {
+			return fmt.Errorf("offset %d: %w", batch.Offset(record), err)
+		}
+		if r.Key == nil {
+			s.Skipped++
+			continue
+		}
+
+		if batch.IsTransactional() {
+			s.pending[batch.ProducerId] = append(s.pending[batch.ProducerId], r)
+		} else {
+			s.Apply(r)
+		}
+	}
+
+	s.nextOffset = batch.NextOffset()
+	return nil
+}
+
+// Apply replays a single record. A tombstone deletes what its key identifies.
+func (s *State) Apply(record Record) {
+	switch key := record.Key.(type) {
+	case *OffsetCommitKey:
+		k := OffsetKey{Group: key.Group, Topic: key.Topic, Partition: key.Partition}
+		if value, ok := record.Value.(*OffsetCommitValue); ok {
+			s.Offsets[k] = value
+		} else {
+			delete(s.Offsets, k)
+		}
+
+	case *GroupMetadataKey:
+		if value, ok := record.Value.(*GroupMetadataValue); ok {
+			s.ClassicGroups[key.Group] = value
+		} else {
+			delete(s.ClassicGroups, key.Group)
+		}
+
+	case *ConsumerGroupMetadataKey:
+		if value, ok := record.Value.(*ConsumerGroupMetadataValue); ok {
+			s.consumerGroup(key.GroupId).Epoch = value.Epoch
+		} else {
+			delete(s.ConsumerGroups, key.GroupId)
+		}
+
+	case *ConsumerGroupPartitionMetadataKey:
+		if value, ok := record.Value.(*ConsumerGroupPartitionMetadataValue); ok {
+			s.consumerGroup(key.GroupId).SubscriptionMetadata = value.Topics
+		} else if group, ok := s.ConsumerGroups[key.GroupId]; ok {
+			group.SubscriptionMetadata = nil
+		}
+
+	case *ConsumerGroupTargetAssignmentMetadataKey:
+		if value, ok := record.Value.(*ConsumerGroupTargetAssignmentMetadataValue); ok {
+			s.consumerGroup(key.GroupId).AssignmentEpoch = value.AssignmentEpoch
+		} else if group, ok := s.ConsumerGroups[key.GroupId]; ok {
+			group.AssignmentEpoch = 0
+		}
+
+	case *ConsumerGroupMemberMetadataKey:
+		value, _ := record.Value.(*ConsumerGroupMemberMetadataValue)
+		s.updateMember(key.GroupId, key.MemberId, value != nil, func(m *ConsumerGroupMember) { m.Metadata = value })
+
+	case *ConsumerGroupTargetAssignmentMemberKey:
+		value, _ := record.Value.(*ConsumerGroupTargetAssignmentMemberValue)
+		s.updateMember(key.GroupId, key.MemberId, value != nil, func(m *ConsumerGroupMember) { m.TargetAssignment = value })
+
+	case *ConsumerGroupCurrentMemberAssignmentKey:
+		value, _ := record.Value.(*ConsumerGroupCurrentMemberAssignmentValue)
+		s.updateMember(key.GroupId, key.MemberId, value != nil, func(m *ConsumerGroupMember) { m.CurrentAssignment = value })
+	}
+}
+
+func (s *State) consumerGroup(groupId string) *ConsumerGroup {
+	group, ok := s.ConsumerGroups[groupId]
+	if !ok {
+		group = &ConsumerGroup{GroupId: groupId, Members: make(map[string]*ConsumerGroupMember)}
+		s.ConsumerGroups[groupId] = group
+	}
+	return group
+}
+
+// updateMember applies a member record. Tombstones never create a group or a member, and a member
+// is removed once all its records were deleted.
+func (s *State) updateMember(groupId string, memberId string, create bool, update func(m *ConsumerGroupMember)) {
+	var group *ConsumerGroup
+	if create {
+		group = s.consumerGroup(groupId)
+	} else if group = s.ConsumerGroups[groupId]; group == nil {
+		return
+	}
+
+	member, ok := group.Members[memberId]
+	if !ok {
+		if !create {
+			return
+		}
+		member = &ConsumerGroupMember{MemberId: memberId}
+		group.Members[memberId] = member
+	}
+
+	update(member)
+	if member.empty() {
+		delete(group.Members, memberId)
+	}
+}
@@ -0,0 +1,443 @@
+package consumeroffsets
+
+import (
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+////////////////////
+// KIP-1071 streams groups
+////////////////////
+
+// The keys of the streams group records are not flexible, all values are flexible from version 0.
+
+// StreamsGroupMetadataKey is the key of the epoch of a streams group.
+type StreamsGroupMetadataKey struct {
+	GroupId string // The group id. (versions: 17)
+}
+
+func (m *StreamsGroupMetadataKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+}
+
+func (m *StreamsGroupMetadataKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+}
+
+// StreamsGroupMetadataValue is the epoch of a streams group.
+type StreamsGroupMetadataValue struct {
+	Epoch        int32 // The group epoch. (versions: 0+)
+	MetadataHash int64 // The hash of the metadata of the subscribed topics. (versions: 0+)
+}
+
+func (m *StreamsGroupMetadataValue) decode(d *protocol.Decoder, version int16) {
+	m.Epoch = d.Int32()
+	m.MetadataHash = d.Int64()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *StreamsGroupMetadataValue) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.Epoch)
+	e.Int64(m.MetadataHash)
+	e.TaggedFields(nil)
+}
+
+// StreamsGroupPartitionMetadataKey is the key of the metadata of the topics subscribed by a
+// streams group.
+type StreamsGroupPartitionMetadataKey struct {
+	GroupId string // The group id. (versions: 18)
+}
+
+func (m *StreamsGroupPartitionMetadataKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+}
+
+func (m *StreamsGroupPartitionMetadataKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+}
+
+// StreamsGroupPartitionMetadataValue is the metadata of the topics subscribed by a streams group.
+type StreamsGroupPartitionMetadataValue struct {
+	Topics []StreamsTopicMetadata // The subscribed topics. (versions: 0+)
+}
+
+// StreamsTopicMetadata is the metadata of a topic subscribed by a streams group.
+type StreamsTopicMetadata struct {
+	TopicId       uuid.UUID // The topic id. (versions: 0+)
+	TopicName     string    // The topic name. (versions: 0+)
+	NumPartitions int32     // The number of partitions. (versions: 0+)
+}
+
+func (m *StreamsGroupPartitionMetadataValue) decode(d *protocol.Decoder, version int16) {
+	m.Topics = protocol.DecodeArray(d, func(d *protocol.Decoder) StreamsTopicMetadata {
+		var topic StreamsTopicMetadata
+		topic.TopicId = d.UUID()
+		topic.TopicName = d.String()
+		topic.NumPartitions = d.Int32()
+		d.TaggedFields(noTaggedFields)
+		return topic
+	})
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *StreamsGroupPartitionMetadataValue) encode(e *protocol.Encoder, version int16) {
+	protocol.EncodeArray(e, m.Topics, func(e *protocol.Encoder, topic StreamsTopicMetadata) {
+		e.UUID(topic.TopicId)
+		e.String(topic.TopicName)
+		e.Int32(topic.NumPartitions)
+		e.TaggedFields(nil)
+	})
+	e.TaggedFields(nil)
+}
+
+// StreamsGroupMemberMetadataKey is the key of the metadata of a streams group member.
+type StreamsGroupMemberMetadataKey struct {
+	GroupId  string // The group id. (versions: 19)
+	MemberId string // The member id. (versions: 19)
+}
+
+func (m *StreamsGroupMemberMetadataKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+	m.MemberId = d.String()
+}
+
+func (m *StreamsGroupMemberMetadataKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+	e.String(m.MemberId)
+}
+
+// StreamsGroupMemberMetadataValue is the metadata of a streams group member.
+type StreamsGroupMemberMetadataValue struct {
+	InstanceId         *string          // The static member instance id. (versions: 0+, nullable: 0+)
+	RackId             *string          // The rack of the member. (versions: 0+, nullable: 0+)
+	ClientId           string           // The client id. (versions: 0+)
+	ClientHost         string           // The client host. (versions: 0+)
+	RebalanceTimeoutMs int32            // The rebalance timeout. (versions: 0+)
+	TopologyEpoch      int32            // The epoch of the topology of the member. (versions: 0+)
+	ProcessId          string           // The id of the process of the member. (versions: 0+)
+	UserEndpoint       *StreamsEndpoint // The endpoint for interactive queries. (versions: 0+, nullable: 0+)
+	ClientTags         []KeyValue       // The client tags used by rack aware assignment. (versions: 0+)
+}
+
+// StreamsEndpoint is the host and the port of an endpoint of a streams group member.
+type StreamsEndpoint struct {
+	Host string // The host. (versions: 0+)
+	Port uint16 // The port. (versions: 0+)
+}
+
+// KeyValue is a key with its value.
+type KeyValue struct {
+	Key   string // The key. (versions: 0+)
+	Value string // The value. (versions: 0+)
+}
+
+func decodeKeyValues(d *protocol.Decoder) []KeyValue {
+	return protocol.DecodeArray(d, func(d *protocol.Decoder) KeyValue {
+		var kv KeyValue
+		kv.Key = d.String()
+		kv.Value = d.String()
+		d.TaggedFields(noTaggedFields)
+		return kv
+	})
+}
+
+func encodeKeyValues(e *protocol.Encoder, values []KeyValue) {
+	protocol.EncodeArray(e, values, func(e *protocol.Encoder, kv KeyValue) {
+		e.String(kv.Key)
+		e.String(kv.Value)
+		e.TaggedFields(nil)
+	})
+}
+
+func (m *StreamsGroupMemberMetadataValue) decode(d *protocol.Decoder, version int16) {
+	m.InstanceId = d.NullableString()
+	m.RackId = d.NullableString()
+	m.ClientId = d.String()
+	m.ClientHost = d.String()
+	m.RebalanceTimeoutMs = d.Int32()
+	m.TopologyEpoch = d.Int32()
+	m.ProcessId = d.String()
+	m.UserEndpoint = nil
+	if d.Int8() >= 0 {
+		m.UserEndpoint = &StreamsEndpoint{}
+		m.UserEndpoint.Host = d.String()
+		m.UserEndpoint.Port = d.Uint16()
+		d.TaggedFields(noTaggedFields)
+	}
+	m.ClientTags = decodeKeyValues(d)
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *StreamsGroupMemberMetadataValue) encode(e *protocol.Encoder, version int16) {
+	e.NullableString(m.InstanceId)
+	e.NullableString(m.RackId)
+	e.String(m.ClientId)
+	e.String(m.ClientHost)
+	e.Int32(m.RebalanceTimeoutMs)
+	e.Int32(m.TopologyEpoch)
+	e.String(m.ProcessId)
+	if m.UserEndpoint == nil {
+		e.Int8(-1)
+	} else {
+		e.Int8(1)
+		e.String(m.UserEndpoint.Host)
+		e.Uint16(m.UserEndpoint.Port)
+		e.TaggedFields(nil)
+	}
+	encodeKeyValues(e, m.ClientTags)
+	e.TaggedFields(nil)
+}
+
+// StreamsGroupTargetAssignmentMetadataKey is the key of the epoch of the target assignment of a
+// streams group.
+type StreamsGroupTargetAssignmentMetadataKey struct {
+	GroupId string // The group id. (versions: 20)
+}
+
+func (m *StreamsGroupTargetAssignmentMetadataKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+}
+
+func (m *StreamsGroupTargetAssignmentMetadataKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+}
+
+// StreamsGroupTargetAssignmentMetadataValue is the epoch of the target assignment of a streams
+// group.
+type StreamsGroupTargetAssignmentMetadataValue struct {
+	AssignmentEpoch int32 // The group epoch of the target assignment. (versions: 0+)
+}
+
+func (m *StreamsGroupTargetAssignmentMetadataValue) decode(d *protocol.Decoder, version int16) {
+	m.AssignmentEpoch = d.Int32()
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *StreamsGroupTargetAssignmentMetadataValue) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.AssignmentEpoch)
+	e.TaggedFields(nil)
+}
+
+// StreamsGroupTargetAssignmentMemberKey is the key of the target assignment of a streams group
+// member.
+type StreamsGroupTargetAssignmentMemberKey struct {
+	GroupId  string // The group id. (versions: 21)
+	MemberId string // The member id. (versions: 21)
+}
+
+func (m *StreamsGroupTargetAssignmentMemberKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+	m.MemberId = d.String()
+}
+
+func (m *StreamsGroupTargetAssignmentMemberKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+	e.String(m.MemberId)
+}
+
+// StreamsGroupTargetAssignmentMemberValue is the target assignment of a streams group member.
+type StreamsGroupTargetAssignmentMemberValue struct {
+	ActiveTasks  []TaskIds // The assigned active tasks. (versions: 0+)
+	StandbyTasks []TaskIds // The assigned standby tasks. (versions: 0+)
+	WarmupTasks  []TaskIds // The assigned warm-up tasks. (versions: 0+)
+}
+
+// TaskIds are the tasks of a subtopology, identified by their partitions.
+type TaskIds struct {
+	SubtopologyId string  // The subtopology id. (versions: 0+)
+	Partitions    []int32 // The partitions of the tasks. (versions: 0+)
+}
+
+func decodeTaskIds(d *protocol.Decoder) []TaskIds {
+	return protocol.DecodeArray(d, func(d *protocol.Decoder) TaskIds {
+		var tasks TaskIds
+		tasks.SubtopologyId = d.String()
+		tasks.Partitions = protocol.DecodeArray(d, (*protocol.Decoder).Int32)
+		d.TaggedFields(noTaggedFields)
+		return tasks
+	})
+}
+
+func encodeTaskIds(e *protocol.Encoder, values []TaskIds) {
+	protocol.EncodeArray(e, values, func(e *protocol.Encoder, tasks TaskIds) {
+		e.String(tasks.SubtopologyId)
+		protocol.EncodeArray(e, tasks.Partitions, (*protocol.Encoder).Int32)
+		e.TaggedFields(nil)
+	})
+}
+
+func (m *StreamsGroupTargetAssignmentMemberValue) decode(d *protocol.Decoder, version int16) {
+	m.ActiveTasks = decodeTaskIds(d)
+	m.StandbyTasks = decodeTaskIds(d)
+	m.WarmupTasks = decodeTaskIds(d)
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *StreamsGroupTargetAssignmentMemberValue) encode(e *protocol.Encoder, version int16) {
+	encodeTaskIds(e, m.ActiveTasks)
+	encodeTaskIds(e, m.StandbyTasks)
+	encodeTaskIds(e, m.WarmupTasks)
+	e.TaggedFields(nil)
+}
+
+// StreamsGroupCurrentMemberAssignmentKey is the key of the current assignment of a streams group
+// member.
+type StreamsGroupCurrentMemberAssignmentKey struct {
+	GroupId  string // The group id. (versions: 22)
+	MemberId string // The member id. (versions: 22)
+}
+
+func (m *StreamsGroupCurrentMemberAssignmentKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+	m.MemberId = d.String()
+}
+
+func (m *StreamsGroupCurrentMemberAssignmentKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+	e.String(m.MemberId)
+}
+
+// StreamsGroupCurrentMemberAssignmentValue is the current assignment of a streams group member.
+type StreamsGroupCurrentMemberAssignmentValue struct {
+	MemberEpoch                  int32     // The current member epoch. (versions: 0+)
+	PreviousMemberEpoch          int32     // The previous member epoch. (versions: 0+)
+	State                        int8      // The member state. (versions: 0+)
+	ActiveTasks                  []TaskIds // The active tasks assigned to the member. (versions: 0+)
+	StandbyTasks                 []TaskIds // The standby tasks assigned to the member. (versions: 0+)
+	WarmupTasks                  []TaskIds // The warm-up tasks assigned to the member. (versions: 0+)
+	ActiveTasksPendingRevocation []TaskIds // The active tasks the member must still revoke. (versions: 0+)
+}
+
+func (m *StreamsGroupCurrentMemberAssignmentValue) decode(d *protocol.Decoder, version int16) {
+	m.MemberEpoch = d.Int32()
+	m.PreviousMemberEpoch = d.Int32()
+	m.State = d.Int8()
+	m.ActiveTasks = decodeTaskIds(d)
+	m.StandbyTasks = decodeTaskIds(d)
+	m.WarmupTasks = decodeTaskIds(d)
+	m.ActiveTasksPendingRevocation = decodeTaskIds(d)
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *StreamsGroupCurrentMemberAssignmentValue) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.MemberEpoch)
+	e.Int32(m.PreviousMemberEpoch)
+	e.Int8(m.State)
+	encodeTaskIds(e, m.ActiveTasks)
+	encodeTaskIds(e, m.StandbyTasks)
+	encodeTaskIds(e, m.WarmupTasks)
+	encodeTaskIds(e, m.ActiveTasksPendingRevocation)
+	e.TaggedFields(nil)
+}
+
+// StreamsGroupTopologyKey is the key of the topology of a streams group.
+type StreamsGroupTopologyKey struct {
+	GroupId string // The group id. (versions: 23)
+}
+
+func (m *StreamsGroupTopologyKey) decode(d *protocol.Decoder, version int16) {
+	m.GroupId = d.String()
+}
+
+func (m *StreamsGroupTopologyKey) encode(e *protocol.Encoder, version int16) {
+	e.String(m.GroupId)
+}
+
+// StreamsGroupTopologyValue is the topology of a streams group.
+type StreamsGroupTopologyValue struct {
+	Epoch         int32         // The topology epoch. (versions: 0+)
+	Subtopologies []Subtopology // The subtopologies. (versions: 0+)
+}
+
+// Subtopology is a subtopology of a streams group topology.
+type Subtopology struct {
+	SubtopologyId           string             // The subtopology id. (versions: 0+)
+	SourceTopics            []string           // The source topics. (versions: 0+)
+	SourceTopicRegex        []string           // The regular expressions of the source topics. (versions: 0+)
+	StateChangelogTopics    []StreamsTopicInfo // The changelog topics of the state stores. (versions: 0+)
+	RepartitionSinkTopics   []string           // The repartition topics the subtopology writes to. (versions: 0+)
+	RepartitionSourceTopics []StreamsTopicInfo // The repartition topics the subtopology reads from. (versions: 0+)
+	CopartitionGroups       []CopartitionGroup // The groups of source topics which must be co-partitioned. (versions: 0+)
+}
+
+// StreamsTopicInfo describes an internal topic of a streams group.
+type StreamsTopicInfo struct {
+	Name              string     // The topic name. (versions: 0+)
+	Partitions        int32      // The number of partitions, or 0 if not set. (versions: 0+)
+	ReplicationFactor int16      // The replication factor, or 0 if not set. (versions: 0+)
+	TopicConfigs      []KeyValue // The topic configuration. (versions: 0+)
+}
+
+// CopartitionGroup is a group of co-partitioned source topics, identified by their indexes in the
+// subtopology.
+type CopartitionGroup struct {
+	SourceTopics            []int16 // The indexes of the source topics. (versions: 0+)
+	SourceTopicRegex        []int16 // The indexes of the source topic regular expressions. (versions: 0+)
+	RepartitionSourceTopics []int16 // The indexes of the repartition source topics. (versions: 0+)
+}
+
+func decodeStreamsTopicInfo(d *protocol.Decoder) []StreamsTopicInfo {
+	return protocol.DecodeArray(d, func(d *protocol.Decoder) StreamsTopicInfo {
+		var topic StreamsTopicInfo
+		topic.Name = d.String()
+		topic.Partitions = d.Int32()
+		topic.ReplicationFactor = d.Int16()
+		topic.TopicConfigs = decodeKeyValues(d)
+		d.TaggedFields(noTaggedFields)
+		return topic
+	})
+}
+
+func encodeStreamsTopicInfo(e *protocol.Encoder, values []StreamsTopicInfo) {
+	protocol.EncodeArray(e, values, func(e *protocol.Encoder, topic StreamsTopicInfo) {
+		e.String(topic.Name)
+		e.Int32(topic.Partitions)
+		e.Int16(topic.ReplicationFactor)
+		encodeKeyValues(e, topic.TopicConfigs)
+		e.TaggedFields(nil)
+	})
+}
+
+func (m *StreamsGroupTopologyValue) decode(d *protocol.Decoder, version int16) {
+	m.Epoch = d.Int32()
+	m.Subtopologies = protocol.DecodeArray(d, func(d *protocol.Decoder) Subtopology {
+		var s Subtopology
+		s.SubtopologyId = d.String()
+		s.SourceTopics = protocol.DecodeArray(d, (*protocol.Decoder).String)
+		s.SourceTopicRegex = protocol.DecodeArray(d, (*protocol.Decoder).String)
+		s.StateChangelogTopics = decodeStreamsTopicInfo(d)
+		s.RepartitionSinkTopics = protocol.DecodeArray(d, (*protocol.Decoder).String)
+		s.RepartitionSourceTopics = decodeStreamsTopicInfo(d)
+		s.CopartitionGroups = protocol.DecodeArray(d, func(d *protocol.Decoder) CopartitionGroup {
+			var g CopartitionGroup
+			g.SourceTopics = protocol.DecodeArray(d, (*protocol.Decoder).Int16)
+			g.SourceTopicRegex = protocol.DecodeArray(d, (*protocol.Decoder).Int16)
+			g.RepartitionSourceTopics = protocol.DecodeArray(d, (*protocol.Decoder).Int16)
+			d.TaggedFields(noTaggedFields)
+			return g
+		})
+		d.TaggedFields(noTaggedFields)
+		return s
+	})
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *StreamsGroupTopologyValue) encode(e *protocol.Encoder, version int16) {
+	e.Int32(m.Epoch)
+	protocol.EncodeArray(e, m.Subtopologies, func(e *protocol.Encoder, s Subtopology) {
+		e.String(s.SubtopologyId)
+		protocol.EncodeArray(e, s.SourceTopics, (*protocol.Encoder).String)
+		protocol.EncodeArray(e, s.SourceTopicRegex, (*protocol.Encoder).String)
+		encodeStreamsTopicInfo(e, s.StateChangelogTopics)
+		protocol.EncodeArray(e, s.RepartitionSinkTopics, (*protocol.Encoder).String)
+		encodeStreamsTopicInfo(e, s.RepartitionSourceTopics)
+		protocol.EncodeArray(e, s.CopartitionGroups, func(e *protocol.Encoder, g CopartitionGroup) {
+			protocol.EncodeArray(e, g.SourceTopics, (*protocol.Encoder).Int16)
+			protocol.EncodeArray(e, g.SourceTopicRegex, (*protocol.Encoder).Int16)
+			protocol.EncodeArray(e, g.RepartitionSourceTopics, (*protocol.Encoder).Int16)
+			e.TaggedFields(nil)
+		})
+		e.TaggedFields(nil)
+	})
+	e.TaggedFields(nil)
+}
@@ -0,0 +1,491 @@
+package errorcodes
+
+import "fmt"
+
+// Named constants for every Kafka protocol error code.
+const (
+	UnknownServerError                 int16 = -1
+	None                               int16 = 0
+	OffsetOutOfRange                   int16 = 1
+	CorruptMessage                     int16 = 2
+	UnknownTopicOrPartition            int16 = 3
+	InvalidFetchSize                   int16 = 4
+	LeaderNotAvailable                 int16 = 5
+	NotLeaderOrFollower                int16 = 6
+	RequestTimedOut                    int16 = 7
+	BrokerNotAvailable                 int16 = 8
+	ReplicaNotAvailable                int16 = 9
+	MessageTooLarge                    int16 = 10
+	StaleControllerEpoch               int16 = 11
+	OffsetMetadataTooLarge             int16 = 12
+	NetworkException                   int16 = 13
+	CoordinatorLoadInProgress          int16 = 14
+	CoordinatorNotAvailable            int16 = 15
+	NotCoordinator                     int16 = 16
+	InvalidTopicException              int16 = 17
+	RecordListTooLarge                 int16 = 18
+	NotEnoughReplicas                  int16 = 19
+	NotEnoughReplicasAfterAppend       int16 = 20
+	InvalidRequiredAcks                int16 = 21
+	IllegalGeneration                  int16 = 22
+	InconsistentGroupProtocol          int16 = 23
+	InvalidGroupId                     int16 = 24
+	UnknownMemberId                    int16 = 25
+	InvalidSessionTimeout              int16 = 26
+	RebalanceInProgress                int16 = 27
+	InvalidCommitOffsetSize            int16 = 28
+	TopicAuthorizationFailed           int16 = 29
+	GroupAuthorizationFailed           int16 = 30
+	ClusterAuthorizationFailed         int16 = 31
+	InvalidTimestamp                   int16 = 32
+	UnsupportedSaslMechanism           int16 = 33
+	IllegalSaslState                   int16 = 34
+	UnsupportedVersion                 int16 = 35
+	TopicAlreadyExists                 int16 = 36
+	InvalidPartitions                  int16 = 37
+	InvalidReplicationFactor           int16 = 38
+	InvalidReplicaAssignment           int16 = 39
+	InvalidConfig                      int16 = 40
+	NotController                      int16 = 41
+	InvalidRequest                     int16 = 42
+	UnsupportedForMessageFormat        int16 = 43
+	PolicyViolation                    int16 = 44
+	OutOfOrderSequenceNumber           int16 = 45
+	DuplicateSequenceNumber            int16 = 46
+	InvalidProducerEpoch               int16 = 47
+	InvalidTxnState                    int16 = 48
+	InvalidProducerIdMapping           int16 = 49
+	InvalidTransactionTimeout          int16 = 50
+	ConcurrentTransactions             int16 = 51
+	TransactionCoordinatorFenced       int16 = 52
+	TransactionalIdAuthorizationFailed int16 = 53
+	SecurityDisabled                   int16 = 54
+	OperationNotAttempted              int16 = 55
+	KafkaStorageError                  int16 = 56
+	LogDirNotFound                     int16 = 57
+	SaslAuthenticationFailed           int16 = 58
+	UnknownProducerId                  int16 = 59
+	ReassignmentInProgress             int16 = 60
+	DelegationTokenAuthDisabled        int16 = 61
+	DelegationTokenNotFound            int16 = 62
+	DelegationTokenOwnerMismatch       int16 = 63
+	DelegationTokenRequestNotAllowed   int16 = 64
+	DelegationTokenAuthorizationFailed int16 = 65
+	DelegationTokenExpired             int16 = 66
+	InvalidPrincipalType               int16 = 67
+	NonEmptyGroup                      int16 = 68
+	GroupIdNotFound                    int16 = 69
+	FetchSessionIdNotFound             int16 = 70
+	InvalidFetchSessionEpoch           int16 = 71
+	ListenerNotFound                   int16 = 72
+	TopicDeletionDisabled              int16 = 73
+	FencedLeaderEpoch                  int16 = 74
+	UnknownLeaderEpoch                 int16 = 75
+	UnsupportedCompressionType         int16 = 76
+	StaleBrokerEpoch                   int16 = 77
+	OffsetNotAvailable                 int16 = 78
+	MemberIdRequired                   int16 = 79
+	PreferredLeaderNotAvailable        int16 = 80
+	GroupMaxSizeReached                int16 = 81
+	FencedInstanceId                   int16 = 82
+	EligibleLeadersNotAvailable        int16 = 83
+	ElectionNotNeeded                  int16 = 84
+	NoReassignmentInProgress           int16 = 85
+	GroupSubscribedToTopic             int16 = 86
+	InvalidRecord                      int16 = 87
+	UnstableOffsetCommit               int16 = 88
+	ThrottlingQuotaExceeded            int16 = 89
+	ProducerFenced                     int16 = 90
+	ResourceNotFound                   int16 = 91
+	DuplicateResource                  int16 = 92
+	UnacceptableCredential             int16 = 93
+	InconsistentVoterSet               int16 = 94
+	InvalidUpdateVersion               int16 = 95
+	FeatureUpdateFailed                int16 = 96
+	PrincipalDeserializationFailure    int16 = 97
+	SnapshotNotFound                   int16 = 98
+	PositionOutOfRange                 int16 = 99
+	UnknownTopicId                     int16 = 100
+	DuplicateBrokerRegistration        int16 = 101
+	BrokerIdNotRegistered              int16 = 102
+	InconsistentTopicId                int16 = 103
+	InconsistentClusterId              int16 = 104
+	TransactionalIdNotFound            int16 = 105
+	FetchSessionTopicIdError           int16 = 106
+	IneligibleReplica                  int16 = 107
+	NewLeaderElected                   int16 = 108
+	OffsetMovedToTieredStorage         int16 = 109
+	FencedMemberEpoch                  int16 = 110
+	UnreleasedInstanceId               int16 = 111
+	UnsupportedAssignor                int16 = 112
+	StaleMemberEpoch                   int16 = 113
+	MismatchedEndpointType             int16 = 114
+	UnsupportedEndpointType            int16 = 115
+	UnknownControllerId                int16 = 116
+	UnknownSubscriptionId              int16 = 117
+	TelemetryTooLarge                  int16 = 118
+	InvalidRegistration                int16 = 119
+	TransactionAbortable               int16 = 120
+	InvalidRecordState                 int16 = 121
+	ShareSessionNotFound               int16 = 122
+	InvalidShareSessionEpoch           int16 = 123
+	FencedStateEpoch                   int16 = 124
+	InvalidVoterKey                    int16 = 125
+	DuplicateVoter                     int16 = 126
+	VoterNotFound                      int16 = 127
+	InvalidRegularExpression           int16 = 128
+	RebootstrapRequired                int16 = 129
+	StreamsInvalidTopology             int16 = 130
+	StreamsInvalidTopologyEpoch        int16 = 131
+	StreamsTopologyFenced              int16 = 132
+	ShareSessionLimitReached           int16 = 133
+)
+
+// Name returns the Kafka name of the error code (for example "NOT_LEADER_OR_FOLLOWER"), or
+// "UNKNOWN" if the error code is not recognised.
+func Name(code int16) string {
+	switch code {
+	case UnknownServerError:
+		return "UNKNOWN_SERVER_ERROR"
+	case None:
+		return "NONE"
+	case OffsetOutOfRange:
+		return "OFFSET_OUT_OF_RANGE"
+	case CorruptMessage:
+		return "CORRUPT_MESSAGE"
+	case UnknownTopicOrPartition:
+		return "UNKNOWN_TOPIC_OR_PARTITION"
+	case InvalidFetchSize:
+		return "INVALID_FETCH_SIZE"
+	case LeaderNotAvailable:
+		return "LEADER_NOT_AVAILABLE"
+	case NotLeaderOrFollower:
+		return "NOT_LEADER_OR_FOLLOWER"
+	case RequestTimedOut:
+		return "REQUEST_TIMED_OUT"
+	case BrokerNotAvailable:
+		return "BROKER_NOT_AVAILABLE"
+	case ReplicaNotAvailable:
+		return "REPLICA_NOT_AVAILABLE"
+	case MessageTooLarge:
+		return "MESSAGE_TOO_LARGE"
+	case StaleControllerEpoch:
+		return "STALE_CONTROLLER_EPOCH"
+	case OffsetMetadataTooLarge:
+		return "OFFSET_METADATA_TOO_LARGE"
+	case NetworkException:
+		return "NETWORK_EXCEPTION"
+	case CoordinatorLoadInProgress:
+		return "COORDINATOR_LOAD_IN_PROGRESS"
+	case CoordinatorNotAvailable:
+		return "COORDINATOR_NOT_AVAILABLE"
+	case NotCoordinator:
+		return "NOT_COORDINATOR"
+	case InvalidTopicException:
+		return "INVALID_TOPIC_EXCEPTION"
+	case RecordListTooLarge:
+		return "RECORD_LIST_TOO_LARGE"
+	case NotEnoughReplicas:
+		return "NOT_ENOUGH_REPLICAS"
+	case NotEnoughReplicasAfterAppend:
+		return "NOT_ENOUGH_REPLICAS_AFTER_APPEND"
+	case InvalidRequiredAcks:
+		return "INVALID_REQUIRED_ACKS"
+	case IllegalGeneration:
+		return "ILLEGAL_GENERATION"
+	case InconsistentGroupProtocol:
+		return "INCONSISTENT_GROUP_PROTOCOL"
+	case InvalidGroupId:
+		return "INVALID_GROUP_ID"
+	case UnknownMemberId:
+		return "UNKNOWN_MEMBER_ID"
+	case InvalidSessionTimeout:
+		return "INVALID_SESSION_TIMEOUT"
+	case RebalanceInProgress:
+		return "REBALANCE_IN_PROGRESS"
+	case InvalidCommitOffsetSize:
+		return "INVALID_COMMIT_OFFSET_SIZE"
+	case TopicAuthorizationFailed:
+		return "TOPIC_AUTHORIZATION_FAILED"
+	case GroupAuthorizationFailed:
+		return "GROUP_AUTHORIZATION_FAILED"
+	case ClusterAuthorizationFailed:
+		return "CLUSTER_AUTHORIZATION_FAILED"
+	case InvalidTimestamp:
+		return "INVALID_TIMESTAMP"
+	case UnsupportedSaslMechanism:
+		return "UNSUPPORTED_SASL_MECHANISM"
+	case IllegalSaslState:
+		return "ILLEGAL_SASL_STATE"
+	case UnsupportedVersion:
+		return "UNSUPPORTED_VERSION"
+	case TopicAlreadyExists:
+		return "TOPIC_ALREADY_EXISTS"
+	case InvalidPartitions:
+		return "INVALID_PARTITIONS"
+	case InvalidReplicationFactor:
+		return "INVALID_REPLICATION_FACTOR"
+	case InvalidReplicaAssignment:
+		return "INVALID_REPLICA_ASSIGNMENT"
+	case InvalidConfig:
+		return "INVALID_CONFIG"
+	case NotController:
+		return "NOT_CONTROLLER"
+	case InvalidRequest:
+		return "INVALID_REQUEST"
+	case UnsupportedForMessageFormat:
+		return "UNSUPPORTED_FOR_MESSAGE_FORMAT"
+	case PolicyViolation:
+		return "POLICY_VIOLATION"
+	case OutOfOrderSequenceNumber:
+		return "OUT_OF_ORDER_SEQUENCE_NUMBER"
+	case DuplicateSequenceNumber:
+		return "DUPLICATE_SEQUENCE_NUMBER"
+	case InvalidProducerEpoch:
+		return "INVALID_PRODUCER_EPOCH"
+	case InvalidTxnState:
+		return "INVALID_TXN_STATE"
+	case InvalidProducerIdMapping:
+		return "INVALID_PRODUCER_ID_MAPPING"
+	case InvalidTransactionTimeout:
+		return "INVALID_TRANSACTION_TIMEOUT"
+	case ConcurrentTransactions:
+		return "CONCURRENT_TRANSACTIONS"
+	case TransactionCoordinatorFenced:
+		return "TRANSACTION_COORDINATOR_FENCED"
+	case TransactionalIdAuthorizationFailed:
+		return "TRANSACTIONAL_ID_AUTHORIZATION_FAILED"
+	case SecurityDisabled:
+		return "SECURITY_DISABLED"
+	case OperationNotAttempted:
+		return "OPERATION_NOT_ATTEMPTED"
+	case KafkaStorageError:
+		return "KAFKA_STORAGE_ERROR"
+	case LogDirNotFound:
+		return "LOG_DIR_NOT_FOUND"
+	case SaslAuthenticationFailed:
+		return "SASL_AUTHENTICATION_FAILED"
+	case UnknownProducerId:
+		return "UNKNOWN_PRODUCER_ID"
+	case ReassignmentInProgress:
+		return "REASSIGNMENT_IN_PROGRESS"
+	case DelegationTokenAuthDisabled:
+		return "DELEGATION_TOKEN_AUTH_DISABLED"
+	case DelegationTokenNotFound:
+		return "DELEGATION_TOKEN_NOT_FOUND"
+	case DelegationTokenOwnerMismatch:
+		return "DELEGATION_TOKEN_OWNER_MISMATCH"
+	case DelegationTokenRequestNotAllowed:
+		return "DELEGATION_TOKEN_REQUEST_NOT_ALLOWED"
+	case DelegationTokenAuthorizationFailed:
+		return "DELEGATION_TOKEN_AUTHORIZATION_FAILED"
+	case DelegationTokenExpired:
+		return "DELEGATION_TOKEN_EXPIRED"
+	case InvalidPrincipalType:
+		return "INVALID_PRINCIPAL_TYPE"
+	case NonEmptyGroup:
+		return "NON_EMPTY_GROUP"
+	case GroupIdNotFound:
+		return "GROUP_ID_NOT_FOUND"
+	case FetchSessionIdNotFound:
+		return "FETCH_SESSION_ID_NOT_FOUND"
+	case InvalidFetchSessionEpoch:
+		return "INVALID_FETCH_SESSION_EPOCH"
+	case ListenerNotFound:
+		return "LISTENER_NOT_FOUND"
+	case TopicDeletionDisabled:
+		return "TOPIC_DELETION_DISABLED"
+	case FencedLeaderEpoch:
+		return "FENCED_LEADER_EPOCH"
+	case UnknownLeaderEpoch:
+		return "UNKNOWN_LEADER_EPOCH"
+	case UnsupportedCompressionType:
+		return "UNSUPPORTED_COMPRESSION_TYPE"
+	case StaleBrokerEpoch:
+		return "STALE_BROKER_EPOCH"
+	case OffsetNotAvailable:
+		return "OFFSET_NOT_AVAILABLE"
+	case MemberIdRequired:
+		return "MEMBER_ID_REQUIRED"
+	case PreferredLeaderNotAvailable:
+		return "PREFERRED_LEADER_NOT_AVAILABLE"
+	case GroupMaxSizeReached:
+		return "GROUP_MAX_SIZE_REACHED"
+	case FencedInstanceId:
+		return "FENCED_INSTANCE_ID"
+	case EligibleLeadersNotAvailable:
+		return "ELIGIBLE_LEADERS_NOT_AVAILABLE"
+	case ElectionNotNeeded:
+		return "ELECTION_NOT_NEEDED"
+	case NoReassignmentInProgress:
+		return "NO_REASSIGNMENT_IN_PROGRESS"
+	case GroupSubscribedToTopic:
+		return "GROUP_SUBSCRIBED_TO_TOPIC"
+	case InvalidRecord:
+		return "INVALID_RECORD"
+	case UnstableOffsetCommit:
+		return "UNSTABLE_OFFSET_COMMIT"
+	case ThrottlingQuotaExceeded:
+		return "THROTTLING_QUOTA_EXCEEDED"
+	case ProducerFenced:
+		return "PRODUCER_FENCED"
+	case ResourceNotFound:
+		return "RESOURCE_NOT_FOUND"
+	case DuplicateResource:
+		return "DUPLICATE_RESOURCE"
+	case UnacceptableCredential:
+		return "UNACCEPTABLE_CREDENTIAL"
+	case InconsistentVoterSet:
+		return "INCONSISTENT_VOTER_SET"
+	case InvalidUpdateVersion:
+		return "INVALID_UPDATE_VERSION"
+	case FeatureUpdateFailed:
+		return "FEATURE_UPDATE_FAILED"
+	case PrincipalDeserializationFailure:
+		return "PRINCIPAL_DESERIALIZATION_FAILURE"
+	case SnapshotNotFound:
+		return "SNAPSHOT_NOT_FOUND"
+	case PositionOutOfRange:
+		return "POSITION_OUT_OF_RANGE"
+	case UnknownTopicId:
+		return "UNKNOWN_TOPIC_ID"
+	case DuplicateBrokerRegistration:
+		return "DUPLICATE_BROKER_REGISTRATION"
+	case BrokerIdNotRegistered:
+		return "BROKER_ID_NOT_REGISTERED"
+	case InconsistentTopicId:
+		return "INCONSISTENT_TOPIC_ID"
+	case InconsistentClusterId:
+		return "INCONSISTENT_CLUSTER_ID"
+	case TransactionalIdNotFound:
+		return "TRANSACTIONAL_ID_NOT_FOUND"
+	case FetchSessionTopicIdError:
+		return "FETCH_SESSION_TOPIC_ID_ERROR"
+	case IneligibleReplica:
+		return "INELIGIBLE_REPLICA"
+	case NewLeaderElected:
+		return "NEW_LEADER_ELECTED"
+	case OffsetMovedToTieredStorage:
+		return "OFFSET_MOVED_TO_TIERED_STORAGE"
+	case FencedMemberEpoch:
+		return "FENCED_MEMBER_EPOCH"
+	case UnreleasedInstanceId:
+		return "UNRELEASED_INSTANCE_ID"
+	case UnsupportedAssignor:
+		return "UNSUPPORTED_ASSIGNOR"
+	case StaleMemberEpoch:
+		return "STALE_MEMBER_EPOCH"
+	case MismatchedEndpointType:
+		return "MISMATCHED_ENDPOINT_TYPE"
+	case UnsupportedEndpointType:
+		return "UNSUPPORTED_ENDPOINT_TYPE"
+	case UnknownControllerId:
+		return "UNKNOWN_CONTROLLER_ID"
+	case UnknownSubscriptionId:
+		return "UNKNOWN_SUBSCRIPTION_ID"
+	case TelemetryTooLarge:
+		return "TELEMETRY_TOO_LARGE"
+	case InvalidRegistration:
+		return "INVALID_REGISTRATION"
+	case TransactionAbortable:
+		return "TRANSACTION_ABORTABLE"
+	case InvalidRecordState:
+		return "INVALID_RECORD_STATE"
+	case ShareSessionNotFound:
+		return "SHARE_SESSION_NOT_FOUND"
+	case InvalidShareSessionEpoch:
+		return "INVALID_SHARE_SESSION_EPOCH"
+	case FencedStateEpoch:
+		return "FENCED_STATE_EPOCH"
+	case InvalidVoterKey:
+		return "INVALID_VOTER_KEY"
+	case DuplicateVoter:
+		return "DUPLICATE_VOTER"
+	case VoterNotFound:
+		return "VOTER_NOT_FOUND"
+	case InvalidRegularExpression:
+		return "INVALID_REGULAR_EXPRESSION"
+	case RebootstrapRequired:
+		return "REBOOTSTRAP_REQUIRED"
+	case StreamsInvalidTopology:
+		return "STREAMS_INVALID_TOPOLOGY"
+	case StreamsInvalidTopologyEpoch:
+		return "STREAMS_INVALID_TOPOLOGY_EPOCH"
+	case StreamsTopologyFenced:
+		return "STREAMS_TOPOLOGY_FENCED"
+	case ShareSessionLimitReached:
+		return "SHARE_SESSION_LIMIT_REACHED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Retriable reports whether Kafka marks the error as retriable, that is whether the same request may
+// succeed when it is sent again (possibly after refreshing metadata or the coordinator).
+func Retriable(code int16) bool {
+	switch code {
+	case CorruptMessage,
+		UnknownTopicOrPartition,
+		LeaderNotAvailable,
+		NotLeaderOrFollower,
+		RequestTimedOut,
+		ReplicaNotAvailable,
+		NetworkException,
+		CoordinatorLoadInProgress,
+		CoordinatorNotAvailable,
+		NotCoordinator,
+		NotEnoughReplicas,
+		NotEnoughReplicasAfterAppend,
+		NotController,
+		ConcurrentTransactions,
+		KafkaStorageError,
+		FetchSessionIdNotFound,
+		InvalidFetchSessionEpoch,
+		ListenerNotFound,
+		FencedLeaderEpoch,
+		UnknownLeaderEpoch,
+		OffsetNotAvailable,
+		PreferredLeaderNotAvailable,
+		EligibleLeadersNotAvailable,
+		UnstableOffsetCommit,
+		ThrottlingQuotaExceeded,
+		UnknownTopicId,
+		InconsistentTopicId,
+		FetchSessionTopicIdError,
+		UnknownControllerId,
+		ShareSessionNotFound,
+		InvalidShareSessionEpoch:
+		return true
+	default:
+		return false
+	}
+}
+
+// Error is a non-zero Kafka error code returned by a broker, together with the optional error message
+// the response carried.
+type Error struct {
+	Code    int16
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s (%d): %s", Name(e.Code), e.Code, e.Message)
+	}
+	return fmt.Sprintf("%s (%d)", Name(e.Code), e.Code)
+}
+
+// ToError converts an error code and its optional message from a response into an error. It returns
+// nil for None.
+func ToError(code int16, message *string) error {
+	if code == None {
+		return nil
+	}
+
+	err := &Error{Code: code}
+	if message != nil {
+		err.Message = *message
+	}
+
+	return err
+}
@@ -0,0 +1,46 @@
+package errorcodes
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestName(t *testing.T) {
+	cases := []struct {
+		code int16
+		want string
+	}{
+		{UnknownServerError, "UNKNOWN_SERVER_ERROR"},
+		{None, "NONE"},
+		{NotLeaderOrFollower, "NOT_LEADER_OR_FOLLOWER"},
+		{FetchSessionIdNotFound, "FETCH_SESSION_ID_NOT_FOUND"},
+		{ShareSessionLimitReached, "SHARE_SESSION_LIMIT_REACHED"},
+		{30000, "UNKNOWN"},
+	}
+
+	for _, c := range cases {
+		if got := Name(c.code); got != c.want {
+			t.Errorf("Name(%d) = %q, want %q", c.code, got, c.want)
+		}
+	}
+}
+
+func TestToError(t *testing.T) {
+	if err := ToError(None, nil); err != nil {
+		t.Errorf("ToError(None) = %v, want nil", err)
+	}
+
+	message := "not the leader"
+	err := ToError(NotLeaderOrFollower, &message)
+	var kafkaErr *Error
+	if !errors.As(err, &kafkaErr) || kafkaErr.Code != NotLeaderOrFollower {
+		t.Fatalf("ToError() = %v, want *Error with code %d", err, NotLeaderOrFollower)
+	}
+	if got := err.Error(); got != "NOT_LEADER_OR_FOLLOWER (6): not the leader" {
+		t.Errorf("Error() = %q", got)
+	}
+
+	if !Retriable(NotLeaderOrFollower) || Retriable(TopicAuthorizationFailed) {
+		t.Error("Retriable() does not match Kafka's classification")
+	}
+}
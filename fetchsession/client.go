@@ -0,0 +1,284 @@
+package fetchsession
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/fetch"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+// Session IDs and epochs as defined by KIP-227.
+const (
+	InvalidSessionId int32 = 0
+	InitialEpoch     int32 = 0  // Creates a new session (or sends a sessionless full fetch)
+	FinalEpoch       int32 = -1 // Closes the session (or sends a sessionless full fetch)
+)
+
+// TopicPartition identifies a partition within a fetch session. Fetch v13+ addresses topics by ID,
+// older versions by name; only the field used by the negotiated version needs to be set.
+type TopicPartition struct {
+	Topic     string
+	TopicId   uuid.UUID
+	Partition int32
+}
+
+func (tp TopicPartition) String() string {
+	if tp.TopicId != uuid.Nil {
+		return fmt.Sprintf("%s(%s)-%d", tp.Topic, tp.TopicId, tp.Partition)
+	}
+	return fmt.Sprintf("%s-%d", tp.Topic, tp.Partition)
+}
+
+// FetchPartition is the per-partition fetch state which is sent in FetchRequestTopicPartition.
+type FetchPartition struct {
+	TopicPartition
+	FetchOffset        int64
+	LogStartOffset     int64
+	PartitionMaxBytes  int32
+	CurrentLeaderEpoch int32
+	LastFetchedEpoch   int32
+}
+
+// sameFetchState compares everything but the partition key.
+func (p FetchPartition) sameFetchState(other FetchPartition) bool {
+	return p.FetchOffset == other.FetchOffset &&
+		p.LogStartOffset == other.LogStartOffset &&
+		p.PartitionMaxBytes == other.PartitionMaxBytes &&
+		p.CurrentLeaderEpoch == other.CurrentLeaderEpoch &&
+		p.LastFetchedEpoch == other.LastFetchedEpoch
+}
+
+// RequestSummary describes how a request built by Client.BuildRequest relates to the session.
+type RequestSummary struct {
+	Full      bool
+	Added     []TopicPartition
+	Altered   []TopicPartition
+	Forgotten []TopicPartition
+}
+
+// Client keeps the client side of a KIP-227 fetch session with a single broker: it decides whether
+// the next Fetch request is full or incremental, which partitions have to be sent and which have to
+// be forgotten, and advances the session ID and epoch based on the responses. A Client is not safe
+// for concurrent use; a consumer or follower keeps one per broker and uses it from its fetch loop.
+type Client struct {
+	sessionId int32
+	epoch     int32
+
+	// partitions are the partitions the broker knows about in the current session, in request order.
+	partitions []FetchPartition
+	// pending is the state sent with the last request, which becomes the session state once the
+	// broker accepted it.
+	pending []FetchPartition
+}
+
+func NewClient() *Client {
+	return &Client{sessionId: InvalidSessionId, epoch: InitialEpoch}
+}
+
+func (c *Client) SessionId() int32 {
+	return c.sessionId
+}
+
+func (c *Client) Epoch() int32 {
+	return c.epoch
+}
+
+// Close makes the next request close the session. That request is a full fetch and the response is
+// sessionless.
+func (c *Client) Close() {
+	if c.sessionId != InvalidSessionId {
+		c.epoch = FinalEpoch
+	}
+}
+
+// BuildRequest fills the SessionId, SessionEpoch, Topics and ForgottenTopicsData of the request for
+// the desired partitions. The ApiVersion of the request must already be set, because it decides
+// whether topics are addressed by name or by ID. Versions before 7 do not support sessions and
+// always get a full request.
+func (c *Client) BuildRequest(req *fetch.FetchRequest, next []FetchPartition) RequestSummary {
+	c.pending = append([]FetchPartition{}, next...)
+
+	if req.ApiVersion < 7 || c.epoch == InitialEpoch || c.epoch == FinalEpoch {
+		req.SessionId = c.sessionId
+		req.SessionEpoch = c.epoch
+		if req.ApiVersion < 7 {
+			req.SessionId = InvalidSessionId
+			req.SessionEpoch = FinalEpoch
+		}
+
+		setTopics(req, next)
+		req.ForgottenTopicsData = &[]fetch.FetchRequestForgottenTopicsData{}
+
+		summary := RequestSummary{Full: true}
+		for _, p := range next {
+			summary.Added = append(summary.Added, p.TopicPartition)
+		}
+		return summary
+	}
+
+	current := make(map[TopicPartition]FetchPartition, len(c.partitions))
+	for _, p := range c.partitions {
+		current[p.TopicPartition] = p
+	}
+
+	summary := RequestSummary{}
+	toSend := make([]FetchPartition, 0)
+	wanted := make(map[TopicPartition]bool, len(next))
+	for _, p := range next {
+		wanted[p.TopicPartition] = true
+
+		old, ok := current[p.TopicPartition]
+		if !ok {
+			summary.Added = append(summary.Added, p.TopicPartition)
+			toSend = append(toSend, p)
+		} else if !old.sameFetchState(p) {
+			summary.Altered = append(summary.Altered, p.TopicPartition)
+			toSend = append(toSend, p)
+		}
+	}
+
+	for _, p := range c.partitions {
+		if !wanted[p.TopicPartition] {
+			summary.Forgotten = append(summary.Forgotten, p.TopicPartition)
+		}
+	}
+
+	req.SessionId = c.sessionId
+	req.SessionEpoch = c.epoch
+	setTopics(req, toSend)
+	setForgotten(req, summary.Forgotten)
+
+	return summary
+}
+
+// HandleResponse advances the session after a Fetch response. It returns the top-level error of the
+// response (if any); session errors reset the session so that the next request is a full fetch.
+func (c *Client) HandleResponse(res *fetch.FetchResponse) error {
+	if res.ApiVersion < 7 {
+		c.partitions = c.pending
+		return errorcodes.ToError(res.ErrorCode, nil)
+	}
+
+	if res.ErrorCode != errorcodes.None {
+		if res.ErrorCode == errorcodes.FetchSessionIdNotFound {
+			// The broker does not know the session anymore; start from scratch.
+			c.sessionId = InvalidSessionId
+		}
+		// Any other error: send a full request which closes the existing session and opens a new one.
+		c.epoch = InitialEpoch
+		c.partitions = nil
+
+		return errorcodes.ToError(res.ErrorCode, nil)
+	}
+
+	if c.epoch == FinalEpoch {
+		c.sessionId = InvalidSessionId
+		c.epoch = InitialEpoch
+		c.partitions = nil
+		return nil
+	}
+
+	if c.epoch == InitialEpoch {
+		c.partitions = c.pending
+		if res.SessionId == InvalidSessionId {
+			// The broker did not create a session (for example because its cache is full).
+			c.sessionId = InvalidSessionId
+			return nil
+		}
+
+		c.sessionId = res.SessionId
+		c.epoch = nextEpoch(InitialEpoch)
+		return nil
+	}
+
+	c.partitions = c.pending
+	c.epoch = nextEpoch(c.epoch)
+
+	return nil
+}
+
+// nextEpoch wraps around to 1, since 0 and -1 have special meaning.
+func nextEpoch(epoch int32) int32 {
+	if epoch < 0 {
+		return epoch
+	} else if epoch == math.MaxInt32 {
+		return 1
+	} else {
+		return epoch + 1
+	}
+}
+
+////////////////////
+// Request building
+////////////////////
+
+func setTopics(req *fetch.FetchRequest, partitions []FetchPartition) {
+	topics := make([]fetch.FetchRequestTopic, 0)
+	index := make(map[TopicPartition]int)
+
+	for _, p := range partitions {
+		key := topicKey(req.ApiVersion, p.TopicPartition)
+		i, ok := index[key]
+		if !ok {
+			topic := fetch.FetchRequestTopic{Partitions: &[]fetch.FetchRequestTopicPartition{}}
+			if req.ApiVersion >= 13 {
+				topic.TopicId = p.TopicId
+			} else {
+				name := p.Topic
+				topic.Topic = &name
+			}
+
+			topics = append(topics, topic)
+			i = len(topics) - 1
+			index[key] = i
+		}
+
+		*topics[i].Partitions = append(*topics[i].Partitions, fetch.FetchRequestTopicPartition{
+			Partition:          p.Partition,
+			CurrentLeaderEpoch: p.CurrentLeaderEpoch,
+			FetchOffset:        p.FetchOffset,
+			LastFetchedEpoch:   p.LastFetchedEpoch,
+			LogStartOffset:     p.LogStartOffset,
+			PartitionMaxBytes:  p.PartitionMaxBytes,
+		})
+	}
+
+	req.Topics = &topics
+}
+
+func setForgotten(req *fetch.FetchRequest, partitions []TopicPartition) {
+	forgotten := make([]fetch.FetchRequestForgottenTopicsData, 0)
+	index := make(map[TopicPartition]int)
+
+	for _, p := range partitions {
+		key := topicKey(req.ApiVersion, p)
+		i, ok := index[key]
+		if !ok {
+			topic := fetch.FetchRequestForgottenTopicsData{Partitions: &[]int32{}}
+			if req.ApiVersion >= 13 {
+				topic.TopicId = p.TopicId
+			} else {
+				name := p.Topic
+				topic.Topic = &name
+			}
+
+			forgotten = append(forgotten, topic)
+			i = len(forgotten) - 1
+			index[key] = i
+		}
+
+		*forgotten[i].Partitions = append(*forgotten[i].Partitions, p.Partition)
+	}
+
+	req.ForgottenTopicsData = &forgotten
+}
+
+// topicKey groups partitions by the topic identifier used in the given version.
+func topicKey(version int16, tp TopicPartition) TopicPartition {
+	if version >= 13 {
+		return TopicPartition{TopicId: tp.TopicId}
+	}
+	return TopicPartition{Topic: tp.Topic}
+}
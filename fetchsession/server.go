@@ -0,0 +1,323 @@
+package fetchsession
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/scholzj/go-kafka-protocol/api/fetch"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+// DefaultMaxSessions matches the broker default of max.incremental.fetch.session.cache.slots.
+const DefaultMaxSessions = 1000
+
+// servedState is what the broker last returned for a partition. An incremental response only
+// includes partitions for which it changed.
+type servedState struct {
+	highWatermark    int64
+	lastStableOffset int64
+	logStartOffset   int64
+}
+
+type serverPartition struct {
+	FetchPartition
+	served servedState
+}
+
+type serverSession struct {
+	id         int32
+	epoch      int32 // the epoch expected in the next request
+	partitions []*serverPartition
+	index      map[TopicPartition]*serverPartition
+	lastUsed   time.Time
+}
+
+// ServerCache is the broker side of KIP-227 for broker stand-ins such as mock brokers and proxies
+// which terminate Fetch requests. It keeps the partitions of every session, expands incremental
+// requests into the full partition set and strips unchanged partitions from incremental responses.
+// It is safe for concurrent use.
+type ServerCache struct {
+	// Now is the clock used for the least-recently-used eviction. It defaults to time.Now.
+	Now func() time.Time
+
+	mu          sync.Mutex
+	maxSessions int
+	sessions    map[int32]*serverSession
+	rand        *rand.Rand
+}
+
+func NewServerCache(maxSessions int) *ServerCache {
+	return &ServerCache{
+		Now:         time.Now,
+		maxSessions: maxSessions,
+		sessions:    make(map[int32]*serverSession),
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Len returns the number of cached sessions.
+func (c *ServerCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.sessions)
+}
+
+// ServerContext is the result of handling one Fetch request. The broker serves Partitions() and
+// passes its full response through FinishResponse.
+type ServerContext struct {
+	cache      *ServerCache
+	apiVersion int16
+	session    *serverSession
+	full       bool
+	partitions []FetchPartition
+
+	// ErrorCode is the top-level session error (FETCH_SESSION_ID_NOT_FOUND or
+	// INVALID_FETCH_SESSION_EPOCH), or None.
+	ErrorCode int16
+}
+
+// Partitions returns every partition the request asks for: for an incremental request this is the
+// whole session, not only the partitions which were sent.
+func (ctx *ServerContext) Partitions() []FetchPartition {
+	return ctx.partitions
+}
+
+// SessionId returns the ID the response has to carry, 0 for sessionless fetches.
+func (ctx *ServerContext) SessionId() int32 {
+	if ctx.session == nil {
+		return InvalidSessionId
+	}
+	return ctx.session.id
+}
+
+// NewContext handles the session part of a Fetch request.
+func (c *ServerCache) NewContext(req *fetch.FetchRequest) *ServerContext {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ctx := &ServerContext{cache: c, apiVersion: req.ApiVersion, ErrorCode: errorcodes.None}
+	requested := requestedPartitions(req)
+
+	if req.ApiVersion < 7 {
+		ctx.full = true
+		ctx.partitions = requested
+		return ctx
+	}
+
+	if req.SessionEpoch == InitialEpoch || req.SessionEpoch == FinalEpoch {
+		ctx.full = true
+		ctx.partitions = requested
+
+		// A full request with a session ID closes that session.
+		if req.SessionId != InvalidSessionId {
+			delete(c.sessions, req.SessionId)
+		}
+
+		if req.SessionEpoch == InitialEpoch {
+			ctx.session = c.createSession(requested)
+		}
+		return ctx
+	}
+
+	session, ok := c.sessions[req.SessionId]
+	if !ok {
+		ctx.ErrorCode = errorcodes.FetchSessionIdNotFound
+		return ctx
+	}
+	if session.epoch != req.SessionEpoch {
+		ctx.ErrorCode = errorcodes.InvalidFetchSessionEpoch
+		return ctx
+	}
+
+	for _, p := range requested {
+		if existing, ok := session.index[p.TopicPartition]; ok {
+			existing.FetchPartition = p
+		} else {
+			added := &serverPartition{FetchPartition: p}
+			session.partitions = append(session.partitions, added)
+			session.index[p.TopicPartition] = added
+		}
+	}
+
+	for _, tp := range forgottenPartitions(req) {
+		if _, ok := session.index[tp]; !ok {
+			continue
+		}
+		delete(session.index, tp)
+		for i, p := range session.partitions {
+			if p.TopicPartition == tp {
+				session.partitions = append(session.partitions[:i], session.partitions[i+1:]...)
+				break
+			}
+		}
+	}
+
+	session.epoch = nextEpoch(session.epoch)
+	session.lastUsed = c.Now()
+
+	ctx.session = session
+	for _, p := range session.partitions {
+		ctx.partitions = append(ctx.partitions, p.FetchPartition)
+	}
+
+	return ctx
+}
+
+// createSession adds a new session, evicting the least recently used one when the cache is full. It
+// returns nil when the cache has no room at all (the fetch is then served sessionless).
+func (c *ServerCache) createSession(partitions []FetchPartition) *serverSession {
+	if c.maxSessions <= 0 {
+		return nil
+	}
+
+	if len(c.sessions) >= c.maxSessions {
+		ids := make([]int32, 0, len(c.sessions))
+		for id := range c.sessions {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return c.sessions[ids[i]].lastUsed.Before(c.sessions[ids[j]].lastUsed) })
+		delete(c.sessions, ids[0])
+	}
+
+	id := InvalidSessionId
+	for id == InvalidSessionId || c.sessions[id] != nil {
+		id = c.rand.Int31()
+	}
+
+	session := &serverSession{
+		id:       id,
+		epoch:    nextEpoch(InitialEpoch),
+		index:    make(map[TopicPartition]*serverPartition, len(partitions)),
+		lastUsed: c.Now(),
+	}
+	for _, p := range partitions {
+		sp := &serverPartition{FetchPartition: p}
+		session.partitions = append(session.partitions, sp)
+		session.index[p.TopicPartition] = sp
+	}
+	c.sessions[id] = session
+
+	return session
+}
+
+// FinishResponse sets the session fields of a response built for Partitions(). For incremental
+// fetches it removes the partitions which neither carry records or an error nor changed their
+// high watermark, last stable offset or log start offset since the last response. Session errors
+// replace the response content with the top-level error.
+func (ctx *ServerContext) FinishResponse(res *fetch.FetchResponse) {
+	if ctx.ErrorCode != errorcodes.None {
+		res.ErrorCode = ctx.ErrorCode
+		res.SessionId = InvalidSessionId
+		res.Responses = &[]fetch.FetchResponseResponse{}
+		return
+	}
+
+	res.SessionId = ctx.SessionId()
+	if ctx.session == nil || res.Responses == nil {
+		return
+	}
+
+	ctx.cache.mu.Lock()
+	defer ctx.cache.mu.Unlock()
+
+	topics := make([]fetch.FetchResponseResponse, 0, len(*res.Responses))
+	for _, topic := range *res.Responses {
+		if topic.Partitions == nil {
+			continue
+		}
+
+		partitions := make([]fetch.FetchResponseResponsePartition, 0, len(*topic.Partitions))
+		for _, partition := range *topic.Partitions {
+			tp := TopicPartition{TopicId: topic.TopicId, Partition: partition.PartitionIndex}
+			if ctx.apiVersion < 13 && topic.Topic != nil {
+				tp = TopicPartition{Topic: *topic.Topic, Partition: partition.PartitionIndex}
+			}
+
+			cached, ok := ctx.session.index[tp]
+			if !ok {
+				partitions = append(partitions, partition)
+				continue
+			}
+
+			served := servedState{
+				highWatermark:    partition.HighWatermark,
+				lastStableOffset: partition.LastStableOffset,
+				logStartOffset:   partition.LogStartOffset,
+			}
+			changed := cached.served != served
+			cached.served = served
+
+			if ctx.full || changed || partition.ErrorCode != errorcodes.None || (partition.Records != nil && len(*partition.Records) > 0) {
+				partitions = append(partitions, partition)
+			}
+		}
+
+		if len(partitions) > 0 {
+			topic.Partitions = &partitions
+			topics = append(topics, topic)
+		}
+	}
+
+	res.Responses = &topics
+}
+
+////////////////////
+// Request parsing
+////////////////////
+
+func requestedPartitions(req *fetch.FetchRequest) []FetchPartition {
+	partitions := make([]FetchPartition, 0)
+	if req.Topics == nil {
+		return partitions
+	}
+
+	for _, topic := range *req.Topics {
+		if topic.Partitions == nil {
+			continue
+		}
+
+		for _, p := range *topic.Partitions {
+			tp := TopicPartition{TopicId: topic.TopicId, Partition: p.Partition}
+			if req.ApiVersion < 13 && topic.Topic != nil {
+				tp = TopicPartition{Topic: *topic.Topic, Partition: p.Partition}
+			}
+
+			partitions = append(partitions, FetchPartition{
+				TopicPartition:     tp,
+				FetchOffset:        p.FetchOffset,
+				LogStartOffset:     p.LogStartOffset,
+				PartitionMaxBytes:  p.PartitionMaxBytes,
+				CurrentLeaderEpoch: p.CurrentLeaderEpoch,
+				LastFetchedEpoch:   p.LastFetchedEpoch,
+			})
+		}
+	}
+
+	return partitions
+}
+
+func forgottenPartitions(req *fetch.FetchRequest) []TopicPartition {
+	partitions := make([]TopicPartition, 0)
+	if req.ForgottenTopicsData == nil {
+		return partitions
+	}
+
+	for _, topic := range *req.ForgottenTopicsData {
+		if topic.Partitions == nil {
+			continue
+		}
+
+		for _, p := range *topic.Partitions {
+			tp := TopicPartition{TopicId: topic.TopicId, Partition: p}
+			if req.ApiVersion < 13 && topic.Topic != nil {
+				tp = TopicPartition{Topic: *topic.Topic, Partition: p}
+			}
+			partitions = append(partitions, tp)
+		}
+	}
+
+	return partitions
+}
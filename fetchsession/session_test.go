@@ -0,0 +1,172 @@
+package fetchsession
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/fetch"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+// roundTrip encodes and decodes the request so that the tests exercise what goes over the wire.
+func roundTrip(t *testing.T, req *fetch.FetchRequest) *fetch.FetchRequest {
+	t.Helper()
+
+	rackId := ""
+	req.RackId = &rackId
+
+	var buf bytes.Buffer
+	if err := req.Write(&buf); err != nil {
+		t.Fatalf("FetchRequest.Write: %v", err)
+	}
+
+	out := &fetch.FetchRequest{}
+	request := &protocol.Request{Body: bytes.NewBuffer(buf.Bytes())}
+	request.ApiVersion = req.ApiVersion
+	if err := out.Read(request); err != nil {
+		t.Fatalf("FetchRequest.Read: %v", err)
+	}
+
+	return out
+}
+
+// respond builds a full response for the partitions the server context asks for.
+func respond(ctx *ServerContext, version int16, highWatermark map[int32]int64) *fetch.FetchResponse {
+	topics := make([]fetch.FetchResponseResponse, 0)
+	for _, p := range ctx.Partitions() {
+		partitions := []fetch.FetchResponseResponsePartition{{
+			PartitionIndex:   p.Partition,
+			HighWatermark:    highWatermark[p.Partition],
+			LastStableOffset: highWatermark[p.Partition],
+			Records:          &[]byte{},
+		}}
+		topics = append(topics, fetch.FetchResponseResponse{TopicId: p.TopicId, Partitions: &partitions})
+	}
+
+	res := &fetch.FetchResponse{ApiVersion: version, Responses: &topics}
+	ctx.FinishResponse(res)
+	return res
+}
+
+func countPartitions(res *fetch.FetchResponse) int {
+	count := 0
+	for _, topic := range *res.Responses {
+		count += len(*topic.Partitions)
+	}
+	return count
+}
+
+func TestIncrementalFetchSession(t *testing.T) {
+	const version int16 = 13
+	topicId := uuid.New()
+	p0 := FetchPartition{TopicPartition: TopicPartition{TopicId: topicId, Partition: 0}, FetchOffset: 10, PartitionMaxBytes: 1024}
+	p1 := FetchPartition{TopicPartition: TopicPartition{TopicId: topicId, Partition: 1}, FetchOffset: 20, PartitionMaxBytes: 1024}
+	p2 := FetchPartition{TopicPartition: TopicPartition{TopicId: topicId, Partition: 2}, FetchOffset: 30, PartitionMaxBytes: 1024}
+
+	client := NewClient()
+	server := NewServerCache(DefaultMaxSessions)
+
+	// The first request is full and creates the session.
+	req := &fetch.FetchRequest{ApiVersion: version}
+	summary := client.BuildRequest(req, []FetchPartition{p0, p1})
+	if !summary.Full || req.SessionId != InvalidSessionId || req.SessionEpoch != InitialEpoch {
+		t.Fatalf("first request: summary=%+v session=%d epoch=%d", summary, req.SessionId, req.SessionEpoch)
+	}
+
+	ctx := server.NewContext(roundTrip(t, req))
+	res := respond(ctx, version, map[int32]int64{0: 100, 1: 200})
+	if res.SessionId == InvalidSessionId || countPartitions(res) != 2 {
+		t.Fatalf("first response: session=%d partitions=%d", res.SessionId, countPartitions(res))
+	}
+	if err := client.HandleResponse(res); err != nil {
+		t.Fatalf("HandleResponse: %v", err)
+	}
+	if client.SessionId() != res.SessionId || client.Epoch() != 1 {
+		t.Fatalf("client session=%d epoch=%d", client.SessionId(), client.Epoch())
+	}
+
+	// The second request moves p0, forgets p1 and adds p2.
+	p0.FetchOffset = 15
+	req = &fetch.FetchRequest{ApiVersion: version}
+	summary = client.BuildRequest(req, []FetchPartition{p0, p2})
+	if summary.Full || len(summary.Altered) != 1 || len(summary.Added) != 1 || len(summary.Forgotten) != 1 {
+		t.Fatalf("second request summary = %+v", summary)
+	}
+
+	ctx = server.NewContext(roundTrip(t, req))
+	if ctx.ErrorCode != errorcodes.None {
+		t.Fatalf("second request error %d", ctx.ErrorCode)
+	}
+	got := ctx.Partitions()
+	if len(got) != 2 || got[0].Partition != 0 || got[0].FetchOffset != 15 || got[1].Partition != 2 {
+		t.Fatalf("server partitions = %+v", got)
+	}
+
+	// Only p2 is new to the response; p0's high watermark did not change, so it is left out.
+	res = respond(ctx, version, map[int32]int64{0: 100, 2: 300})
+	if countPartitions(res) != 1 || (*(*res.Responses)[0].Partitions)[0].PartitionIndex != 2 {
+		t.Fatalf("incremental response = %s", res.PrettyPrint())
+	}
+	if err := client.HandleResponse(res); err != nil {
+		t.Fatalf("HandleResponse: %v", err)
+	}
+
+	// An unchanged third request sends no partitions at all.
+	req = &fetch.FetchRequest{ApiVersion: version}
+	summary = client.BuildRequest(req, []FetchPartition{p0, p2})
+	if len(*req.Topics) != 0 || len(*req.ForgottenTopicsData) != 0 || req.SessionEpoch != 2 {
+		t.Fatalf("third request: summary=%+v epoch=%d", summary, req.SessionEpoch)
+	}
+	ctx = server.NewContext(roundTrip(t, req))
+	if len(ctx.Partitions()) != 2 {
+		t.Fatalf("server must expand the empty incremental request to the session, got %+v", ctx.Partitions())
+	}
+}
+
+func TestFetchSessionErrorsResetTheClient(t *testing.T) {
+	client := NewClient()
+	server := NewServerCache(DefaultMaxSessions)
+	p0 := FetchPartition{TopicPartition: TopicPartition{Topic: "orders", Partition: 0}, FetchOffset: 1}
+
+	req := &fetch.FetchRequest{ApiVersion: 12}
+	client.BuildRequest(req, []FetchPartition{p0})
+	ctx := server.NewContext(roundTrip(t, req))
+	if err := client.HandleResponse(respond(ctx, 12, nil)); err != nil {
+		t.Fatalf("HandleResponse: %v", err)
+	}
+
+	// The broker loses the session (for example it was restarted).
+	server = NewServerCache(DefaultMaxSessions)
+
+	req = &fetch.FetchRequest{ApiVersion: 12}
+	client.BuildRequest(req, []FetchPartition{p0})
+	ctx = server.NewContext(roundTrip(t, req))
+	if ctx.ErrorCode != errorcodes.FetchSessionIdNotFound {
+		t.Fatalf("ErrorCode = %d, want FETCH_SESSION_ID_NOT_FOUND", ctx.ErrorCode)
+	}
+	if err := client.HandleResponse(respond(ctx, 12, nil)); err == nil {
+		t.Fatal("HandleResponse must return the session error")
+	}
+
+	req = &fetch.FetchRequest{ApiVersion: 12}
+	if summary := client.BuildRequest(req, []FetchPartition{p0}); !summary.Full || req.SessionId != InvalidSessionId {
+		t.Errorf("after FETCH_SESSION_ID_NOT_FOUND the next request must be a new full fetch, got %+v", summary)
+	}
+}
+
+func TestServerCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	server := NewServerCache(1)
+
+	first := server.NewContext(&fetch.FetchRequest{ApiVersion: 12, Topics: &[]fetch.FetchRequestTopic{}})
+	second := server.NewContext(&fetch.FetchRequest{ApiVersion: 12, Topics: &[]fetch.FetchRequestTopic{}})
+	if server.Len() != 1 || first.SessionId() == second.SessionId() {
+		t.Fatalf("Len()=%d first=%d second=%d", server.Len(), first.SessionId(), second.SessionId())
+	}
+
+	ctx := server.NewContext(&fetch.FetchRequest{ApiVersion: 12, SessionId: first.SessionId(), SessionEpoch: 1})
+	if ctx.ErrorCode != errorcodes.FetchSessionIdNotFound {
+		t.Errorf("evicted session: ErrorCode = %d", ctx.ErrorCode)
+	}
+}
@@ -0,0 +1,181 @@
+package forwarding
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"github.com/scholzj/go-kafka-protocol/api/envelope"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+// ForwardedRequest is the content of an EnvelopeRequest: the embedded client request with its typed
+// body, and the identity and address of the client the forwarding broker authenticated.
+type ForwardedRequest struct {
+	Request       protocol.Request
+	Body          protocol.RequestBody
+	Principal     *KafkaPrincipal
+	ClientAddress net.IP
+}
+
+// UnwrapRequest decodes the embedded request header and body of an envelope. The body is decoded
+// with the struct registered in messages.NewRequestBody; for an unknown API key an error is returned
+// together with the raw request, so that callers can still forward it.
+func UnwrapRequest(env *envelope.EnvelopeRequest) (*ForwardedRequest, error) {
+	if env == nil || env.RequestData == nil {
+		return nil, fmt.Errorf("envelope has no request data")
+	}
+
+	forwarded := &ForwardedRequest{}
+
+	request, err := protocol.ReadRequest(sizePrefixed(*env.RequestData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the embedded request: %w", err)
+	}
+	forwarded.Request = request
+
+	if env.RequestPrincipal != nil {
+		principal, err := DecodePrincipal(*env.RequestPrincipal)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode the request principal: %w", err)
+		}
+		forwarded.Principal = &principal
+	}
+
+	if env.ClientHostAddress != nil {
+		address := *env.ClientHostAddress
+		if len(address) != net.IPv4len && len(address) != net.IPv6len {
+			return nil, fmt.Errorf("invalid client host address length %d", len(address))
+		}
+		forwarded.ClientAddress = net.IP(address)
+	}
+
+	body, ok := messages.NewRequestBody(request.ApiKey)
+	if !ok {
+		return forwarded, fmt.Errorf("unknown API key %d in the embedded request", request.ApiKey)
+	}
+
+	err = body.Read(&forwarded.Request)
+	if err != nil {
+		return forwarded, fmt.Errorf("failed to decode the embedded %s request: %w", messages.Name(request.ApiKey), err)
+	}
+	forwarded.Body = body
+
+	return forwarded, nil
+}
+
+// WrapRequest builds the envelope a broker sends to the controller to forward a client request. The
+// body must have its ApiVersion set to header.ApiVersion. The principal and client address are
+// optional.
+func WrapRequest(version int16, header protocol.RequestHeader, body protocol.RequestBody, principal *KafkaPrincipal, clientAddress net.IP) (*envelope.EnvelopeRequest, error) {
+	bodyBuf := bytes.NewBuffer(make([]byte, 0))
+	if err := body.Write(bodyBuf); err != nil {
+		return nil, err
+	}
+
+	request := protocol.Request{RequestHeader: header, Body: bodyBuf}
+	buf := bytes.NewBuffer(make([]byte, 0))
+	if err := request.Write(buf); err != nil {
+		return nil, err
+	}
+	requestData := stripSize(buf.Bytes())
+
+	env := &envelope.EnvelopeRequest{ApiVersion: version, RequestData: &requestData}
+
+	if principal != nil {
+		principalData, err := EncodePrincipal(*principal)
+		if err != nil {
+			return nil, err
+		}
+		env.RequestPrincipal = &principalData
+	}
+
+	address := []byte{}
+	if clientAddress != nil {
+		if ipv4 := clientAddress.To4(); ipv4 != nil {
+			address = []byte(ipv4)
+		} else {
+			address = []byte(clientAddress.To16())
+		}
+	}
+	env.ClientHostAddress = &address
+
+	return env, nil
+}
+
+// UnwrapResponse decodes the embedded response of an EnvelopeResponse. The header of the forwarded
+// request is needed to know which API key and version the embedded response uses. An envelope-level
+// error is returned as an error.
+func UnwrapResponse(env *envelope.EnvelopeResponse, requestHeader protocol.RequestHeader) (protocol.Response, protocol.ResponseBody, error) {
+	if env == nil {
+		return protocol.Response{}, nil, fmt.Errorf("envelope response is nil")
+	}
+
+	if err := errorcodes.ToError(env.ErrorCode, nil); err != nil {
+		return protocol.Response{}, nil, err
+	}
+
+	if env.ResponseData == nil {
+		return protocol.Response{}, nil, fmt.Errorf("envelope response has no response data")
+	}
+
+	correlations := map[int32]protocol.RequestHeader{requestHeader.CorrelationId: requestHeader}
+	response, err := protocol.ReadResponse(sizePrefixed(*env.ResponseData), correlations)
+	if err != nil {
+		return response, nil, fmt.Errorf("failed to decode the embedded response: %w", err)
+	}
+
+	body, ok := messages.NewResponseBody(response.ApiKey)
+	if !ok {
+		return response, nil, fmt.Errorf("unknown API key %d in the embedded response", response.ApiKey)
+	}
+
+	err = body.Read(&response)
+	if err != nil {
+		return response, nil, fmt.Errorf("failed to decode the embedded %s response: %w", messages.Name(response.ApiKey), err)
+	}
+
+	return response, body, nil
+}
+
+// WrapResponse builds the EnvelopeResponse a controller returns for a forwarded request. The body
+// must have its ApiVersion set to requestHeader.ApiVersion.
+func WrapResponse(version int16, requestHeader protocol.RequestHeader, body protocol.ResponseBody) (*envelope.EnvelopeResponse, error) {
+	bodyBuf := bytes.NewBuffer(make([]byte, 0))
+	if err := body.Write(bodyBuf); err != nil {
+		return nil, err
+	}
+
+	response := protocol.Response{
+		ResponseHeader: protocol.ResponseHeader{
+			ApiKey:        requestHeader.ApiKey,
+			ApiVersion:    requestHeader.ApiVersion,
+			CorrelationId: requestHeader.CorrelationId,
+			ClientId:      requestHeader.ClientId,
+		},
+		Body: bodyBuf,
+	}
+	buf := bytes.NewBuffer(make([]byte, 0))
+	if err := response.Write(buf); err != nil {
+		return nil, err
+	}
+	responseData := stripSize(buf.Bytes())
+
+	return &envelope.EnvelopeResponse{ApiVersion: version, ResponseData: &responseData, ErrorCode: errorcodes.None}, nil
+}
+
+// The embedded request and response data are the header and body without the int32 size prefix used
+// on the wire, so the framing readers and writers of the protocol package need it added or removed.
+
+func sizePrefixed(data []byte) *bytes.Buffer {
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)+4))
+	_ = protocol.WriteInt32(buf, int32(len(data)))
+	buf.Write(data)
+	return buf
+}
+
+func stripSize(framed []byte) []byte {
+	return append([]byte{}, framed[4:]...)
+}
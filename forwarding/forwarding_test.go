@@ -0,0 +1,122 @@
+package forwarding
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/scholzj/go-kafka-protocol/api/envelope"
+	"github.com/scholzj/go-kafka-protocol/api/metadata"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+func strPtr(s string) *string { return &s }
+
+func TestPrincipalRoundTrip(t *testing.T) {
+	principal := KafkaPrincipal{PrincipalType: "User", Name: "alice", TokenAuthenticated: true}
+
+	encoded, err := EncodePrincipal(principal)
+	if err != nil {
+		t.Fatalf("EncodePrincipal: %v", err)
+	}
+
+	// int16 version 0, compact strings "User" and "alice", bool true, no tagged fields
+	want := []byte{0, 0, 5, 'U', 's', 'e', 'r', 6, 'a', 'l', 'i', 'c', 'e', 1, 0}
+	if !bytes.Equal(encoded, want) {
+		t.Fatalf("EncodePrincipal() = %x, want %x", encoded, want)
+	}
+
+	decoded, err := DecodePrincipal(encoded)
+	if err != nil {
+		t.Fatalf("DecodePrincipal: %v", err)
+	}
+	if decoded != principal || decoded.String() != "User:alice" {
+		t.Errorf("DecodePrincipal() = %+v", decoded)
+	}
+
+	if _, err := DecodePrincipal([]byte{0, 1, 5, 'U', 's', 'e', 'r'}); err == nil {
+		t.Error("DecodePrincipal must reject unsupported versions")
+	}
+}
+
+func TestEnvelopeRequestRoundTrip(t *testing.T) {
+	header := protocol.RequestHeader{ApiKey: 3, ApiVersion: 12, CorrelationId: 42, ClientId: strPtr("admin-client")}
+	body := &metadata.MetadataRequest{
+		ApiVersion:             12,
+		Topics:                 &[]metadata.MetadataRequestTopic{{Name: strPtr("orders")}},
+		AllowAutoTopicCreation: true,
+	}
+	principal := &KafkaPrincipal{PrincipalType: "User", Name: "bob"}
+
+	env, err := WrapRequest(2, header, body, principal, net.ParseIP("10.0.0.7"))
+	if err != nil {
+		t.Fatalf("WrapRequest: %v", err)
+	}
+
+	// Send the envelope itself over the wire.
+	var buf bytes.Buffer
+	if err := env.Write(&buf); err != nil {
+		t.Fatalf("EnvelopeRequest.Write: %v", err)
+	}
+	decodedEnv := &envelope.EnvelopeRequest{}
+	request := &protocol.Request{Body: bytes.NewBuffer(buf.Bytes())}
+	request.ApiVersion = 2
+	if err := decodedEnv.Read(request); err != nil {
+		t.Fatalf("EnvelopeRequest.Read: %v", err)
+	}
+
+	forwarded, err := UnwrapRequest(decodedEnv)
+	if err != nil {
+		t.Fatalf("UnwrapRequest: %v", err)
+	}
+
+	if forwarded.Request.ApiKey != 3 || forwarded.Request.ApiVersion != 12 || forwarded.Request.CorrelationId != 42 || *forwarded.Request.ClientId != "admin-client" {
+		t.Errorf("unexpected embedded header %+v", forwarded.Request.RequestHeader)
+	}
+	if forwarded.Principal == nil || *forwarded.Principal != *principal {
+		t.Errorf("Principal = %+v, want %+v", forwarded.Principal, principal)
+	}
+	if !forwarded.ClientAddress.Equal(net.ParseIP("10.0.0.7")) || len(forwarded.ClientAddress) != net.IPv4len {
+		t.Errorf("ClientAddress = %v", forwarded.ClientAddress)
+	}
+
+	got, ok := forwarded.Body.(*metadata.MetadataRequest)
+	if !ok {
+		t.Fatalf("Body is %T, want *metadata.MetadataRequest", forwarded.Body)
+	}
+	if len(*got.Topics) != 1 || *(*got.Topics)[0].Name != "orders" || !got.AllowAutoTopicCreation {
+		t.Errorf("unexpected body %s", got.PrettyPrint())
+	}
+}
+
+func TestEnvelopeResponseRoundTrip(t *testing.T) {
+	header := protocol.RequestHeader{ApiKey: 3, ApiVersion: 12, CorrelationId: 7}
+	body := &metadata.MetadataResponse{
+		ApiVersion:   12,
+		Brokers:      &[]metadata.MetadataResponseBroker{},
+		ClusterId:    strPtr("cluster"),
+		ControllerId: 1,
+		Topics:       &[]metadata.MetadataResponseTopic{},
+	}
+
+	env, err := WrapResponse(2, header, body)
+	if err != nil {
+		t.Fatalf("WrapResponse: %v", err)
+	}
+
+	response, decoded, err := UnwrapResponse(env, header)
+	if err != nil {
+		t.Fatalf("UnwrapResponse: %v", err)
+	}
+	if response.CorrelationId != 7 {
+		t.Errorf("CorrelationId = %d, want 7", response.CorrelationId)
+	}
+	if got := decoded.(*metadata.MetadataResponse); *got.ClusterId != "cluster" || got.ControllerId != 1 {
+		t.Errorf("unexpected body %s", got.PrettyPrint())
+	}
+
+	env.ErrorCode = 41 // NOT_CONTROLLER
+	if _, _, err := UnwrapResponse(env, header); err == nil {
+		t.Error("UnwrapResponse must return the envelope error")
+	}
+}
@@ -0,0 +1,91 @@
+package forwarding
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+// principalDataVersion is the highest version of DefaultPrincipalData, the schema the default
+// principal serde (DefaultKafkaPrincipalBuilder) uses for Envelope RequestPrincipal.
+const principalDataVersion int16 = 0
+
+// KafkaPrincipal is the authenticated identity of the client whose request was forwarded.
+type KafkaPrincipal struct {
+	PrincipalType      string
+	Name               string
+	TokenAuthenticated bool
+}
+
+func (p KafkaPrincipal) String() string {
+	return p.PrincipalType + ":" + p.Name
+}
+
+// EncodePrincipal serializes the principal in the default principal serde format: a version-prefixed
+// (int16) DefaultPrincipalData message.
+func EncodePrincipal(principal KafkaPrincipal) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0))
+
+	if err := protocol.WriteInt16(buf, principalDataVersion); err != nil {
+		return nil, err
+	}
+
+	if err := protocol.WriteCompactString(buf, principal.PrincipalType); err != nil {
+		return nil, err
+	}
+
+	if err := protocol.WriteCompactString(buf, principal.Name); err != nil {
+		return nil, err
+	}
+
+	if err := protocol.WriteBool(buf, principal.TokenAuthenticated); err != nil {
+		return nil, err
+	}
+
+	if err := protocol.WriteRawTaggedFields(buf, []protocol.TaggedField{}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DecodePrincipal parses a principal serialized by the default principal serde. Unknown tagged fields
+// added by newer versions are skipped.
+func DecodePrincipal(data []byte) (KafkaPrincipal, error) {
+	principal := KafkaPrincipal{}
+	r := bytes.NewReader(data)
+
+	version, err := protocol.ReadInt16(r)
+	if err != nil {
+		return principal, err
+	}
+	if version < 0 || version > principalDataVersion {
+		return principal, fmt.Errorf("unsupported DefaultPrincipalData version %d", version)
+	}
+
+	principal.PrincipalType, err = protocol.ReadCompactString(r)
+	if err != nil {
+		return principal, err
+	}
+
+	principal.Name, err = protocol.ReadCompactString(r)
+	if err != nil {
+		return principal, err
+	}
+
+	principal.TokenAuthenticated, err = protocol.ReadBool(r)
+	if err != nil {
+		return principal, err
+	}
+
+	if _, err = protocol.ReadRawTaggedFields(r); err != nil {
+		return principal, err
+	}
+
+	if r.Len() != 0 {
+		return principal, fmt.Errorf("%d unexpected trailing bytes after the principal", r.Len())
+	}
+
+	return principal, nil
+}
@@ -2,4 +2,4 @@ module github.com/scholzj/go-kafka-protocol
 
 go 1.23.5
 
-require github.com/google/uuid v1.6.0 // indirect
+require github.com/google/uuid v1.6.0
@@ -0,0 +1,358 @@
+package lag
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/describesharegroupoffsets"
+	"github.com/scholzj/go-kafka-protocol/api/metadata"
+	"github.com/scholzj/go-kafka-protocol/api/offsetfetch"
+	"github.com/scholzj/go-kafka-protocol/client"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+// The lag of a group is computed from the offsets it committed, fetched with OffsetFetch for classic and
+// consumer groups and with DescribeShareGroupOffsets for share groups, and from the log start and end
+// offsets of the partitions, listed with ListOffsets by their leaders. Like the kafka-consumer-groups
+// tool, the lag of a partition is its log end offset minus the committed offset, but never negative,
+// for example after the log was truncated below the committed offset.
+
+// Isolation levels of ListOffsets. With ReadCommitted, the log end offset is the last stable offset.
+const (
+	ReadUncommitted int8 = 0
+	ReadCommitted   int8 = 1
+)
+
+// Status is the state of the committed offset of a partition.
+type Status int
+
+const (
+	Committed Status = iota // The group committed an offset of the partition.
+	NoCommit                // The group committed no offset of the partition of a topic it consumes.
+	Deleted                 // The group committed an offset of a partition which does not exist anymore.
+	Failed                  // An offset of the partition could not be fetched or listed.
+)
+
+func (s Status) String() string {
+	switch s {
+	case Committed:
+		return "Committed"
+	case NoCommit:
+		return "NoCommit"
+	case Deleted:
+		return "Deleted"
+	case Failed:
+		return "Failed"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
+// TopicPartition identifies a partition by topic name. A deleted topic known only by its id, from
+// version 10 of OffsetFetch, is named by its id.
+type TopicPartition struct {
+	Topic     string
+	Partition int32
+}
+
+func (tp TopicPartition) String() string {
+	return fmt.Sprintf("%s-%d", tp.Topic, tp.Partition)
+}
+
+// PartitionLag is the lag of a group on one partition. Offsets which are not known are -1.
+type PartitionLag struct {
+	TopicPartition
+	Status               Status
+	CommittedOffset      int64 // For share groups, the start offset of the share-partition.
+	CommittedLeaderEpoch int32
+	Metadata             *string
+	LogStartOffset       int64
+	LogEndOffset         int64
+	Lag                  int64
+	Err                  error
+}
+
+// Report is the lag of a group on the partitions of the topics it committed offsets for, or of the
+// requested topics.
+type Report struct {
+	GroupId    string
+	ShareGroup bool
+	Partitions []PartitionLag // Ordered by topic and partition.
+	TotalLag   int64          // The sum of the known lags.
+}
+
+// TopicLag returns the sum of the known lags on the partitions of the topic.
+func (r *Report) TopicLag(topic string) int64 {
+	var lag int64
+	for _, p := range r.Partitions {
+		if p.Topic == topic && p.Lag > 0 {
+			lag += p.Lag
+		}
+	}
+	return lag
+}
+
+// Calculator is safe for concurrent use.
+type Calculator struct {
+	Client         *client.Client
+	IsolationLevel int8
+}
+
+// committed is an offset committed by a group. brokerLag is the lag computed by the broker for a share
+// group, or -1.
+type committed struct {
+	offset      int64
+	leaderEpoch int32
+	metadata    *string
+	brokerLag   int64
+	err         error
+}
+
+func NewCalculator(c *client.Client) *Calculator {
+	return &Calculator{Client: c, IsolationLevel: ReadUncommitted}
+}
+
+// GroupLag computes the lag of a classic or consumer group on the topics, or on all topics it committed
+// offsets for when no topics are given.
+func (c *Calculator) GroupLag(ctx context.Context, groupId string, topics ...string) (*Report, error) {
+	// All committed offsets are fetched and filtered afterwards, so that the offsets of deleted
+	// partitions are found as well.
+	req := &offsetfetch.OffsetFetchRequest{
+		GroupId: &groupId,
+		Groups:  &[]offsetfetch.OffsetFetchRequestGroup{{GroupId: &groupId, MemberEpoch: -1}},
+	}
+	res, err := c.Client.OffsetFetch(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	commits := make(map[TopicPartition]committed)
+	var names []string
+	var ids []uuid.UUID
+	add := func(name string, id uuid.UUID, index int32, offset int64, leaderEpoch int32, metadata *string, errorCode int16) {
+		if name == "" {
+			ids = append(ids, id)
+			name = id.String()
+		} else {
+			names = append(names, name)
+		}
+		commits[TopicPartition{name, index}] = committed{offset, leaderEpoch, metadata, -1, errorcodes.ToError(errorCode, nil)}
+	}
+	if res.ApiVersion < 8 {
+		if err := errorcodes.ToError(res.ErrorCode, nil); err != nil {
+			return nil, err
+		}
+		for _, t := range deref(res.Topics) {
+			for _, p := range deref(t.Partitions) {
+				add(derefString(t.Name), uuid.Nil, p.PartitionIndex, p.CommittedOffset, p.CommittedLeaderEpoch, p.Metadata, p.ErrorCode)
+			}
+		}
+	} else {
+		groups := deref(res.Groups)
+		if len(groups) != 1 {
+			return nil, fmt.Errorf("unexpected OffsetFetch response for %d groups", len(groups))
+		}
+		if err := errorcodes.ToError(groups[0].ErrorCode, nil); err != nil {
+			return nil, err
+		}
+		for _, t := range deref(groups[0].Topics) {
+			for _, p := range deref(t.Partitions) {
+				add(derefString(t.Name), t.TopicId, p.PartitionIndex, p.CommittedOffset, p.CommittedLeaderEpoch, p.Metadata, p.ErrorCode)
+			}
+		}
+	}
+
+	return c.report(ctx, groupId, false, topics, commits, names, ids)
+}
+
+// ShareGroupLag computes the lag of a share group on the topics, or on all topics it consumes when no
+// topics are given. The committed offset of a share-partition is its start offset. Brokers supporting
+// version 1 of DescribeShareGroupOffsets compute the lag, which leaves out records that were acknowledged
+// beyond the start offset; otherwise the lag is computed from the start offset.
+func (c *Calculator) ShareGroupLag(ctx context.Context, groupId string, topics ...string) (*Report, error) {
+	req := &describesharegroupoffsets.DescribeShareGroupOffsetsRequest{
+		Groups: &[]describesharegroupoffsets.DescribeShareGroupOffsetsRequestGroup{{GroupId: &groupId}},
+	}
+	res, err := c.Client.DescribeShareGroupOffsets(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	groups := deref(res.Groups)
+	if len(groups) != 1 {
+		return nil, fmt.Errorf("unexpected DescribeShareGroupOffsets response for %d groups", len(groups))
+	}
+	if err := errorcodes.ToError(groups[0].ErrorCode, groups[0].ErrorMessage); err != nil {
+		return nil, err
+	}
+
+	commits := make(map[TopicPartition]committed)
+	var names []string
+	for _, t := range deref(groups[0].Topics) {
+		name := derefString(t.TopicName)
+		names = append(names, name)
+		for _, p := range deref(t.Partitions) {
+			brokerLag := int64(-1)
+			if res.ApiVersion >= 1 {
+				brokerLag = p.Lag
+			}
+			commits[TopicPartition{name, p.PartitionIndex}] = committed{p.StartOffset, p.LeaderEpoch, nil, brokerLag, errorcodes.ToError(p.ErrorCode, p.ErrorMessage)}
+		}
+	}
+
+	return c.report(ctx, groupId, true, topics, commits, names, nil)
+}
+
+// report refreshes the metadata of the topics, lists the offsets of the partitions which still exist
+// and computes their lag.
+func (c *Calculator) report(ctx context.Context, groupId string, share bool, topics []string, commits map[TopicPartition]committed, names []string, ids []uuid.UUID) (*Report, error) {
+	if err := c.refreshMetadata(ctx, append(names, topics...), ids); err != nil {
+		return nil, err
+	}
+
+	// A topic committed by id only is named once its name is known.
+	renamed := make(map[TopicPartition]TopicPartition)
+	for tp := range commits {
+		if id, err := uuid.Parse(tp.Topic); err == nil {
+			if name, ok := c.Client.Cache.TopicName(id); ok {
+				renamed[tp] = TopicPartition{name, tp.Partition}
+			}
+		}
+	}
+	for from, to := range renamed {
+		commits[to] = commits[from]
+		delete(commits, from)
+	}
+
+	inScope := func(topic string) bool { return true }
+	if len(topics) > 0 {
+		requested := make(map[string]bool, len(topics))
+		for _, topic := range topics {
+			requested[topic] = true
+		}
+		inScope = func(topic string) bool { return requested[topic] }
+	}
+
+	report := &Report{GroupId: groupId, ShareGroup: share}
+	scoped := make(map[string]bool)
+	for tp := range commits {
+		if inScope(tp.Topic) {
+			scoped[tp.Topic] = true
+		}
+	}
+	for _, topic := range topics {
+		scoped[topic] = true
+	}
+
+	var live []TopicPartition
+	for topic := range scoped {
+		partitions := c.Client.Cache.Partitions(topic)
+		exists := make(map[int32]bool, len(partitions))
+		for _, p := range partitions {
+			tp := TopicPartition{topic, p.Partition}
+			exists[p.Partition] = true
+			live = append(live, tp)
+
+			result := PartitionLag{TopicPartition: tp, Status: NoCommit, CommittedOffset: -1, CommittedLeaderEpoch: -1}
+			if commit, ok := commits[tp]; ok && commit.err != nil {
+				result.Status, result.Err = Failed, commit.err
+			} else if ok && commit.offset >= 0 {
+				result.Status, result.CommittedOffset, result.CommittedLeaderEpoch, result.Metadata = Committed, commit.offset, commit.leaderEpoch, commit.metadata
+			}
+			report.Partitions = append(report.Partitions, result)
+		}
+		for tp, commit := range commits {
+			if tp.Topic == topic && !exists[tp.Partition] {
+				report.Partitions = append(report.Partitions, PartitionLag{
+					TopicPartition:       tp,
+					Status:               Deleted,
+					CommittedOffset:      commit.offset,
+					CommittedLeaderEpoch: commit.leaderEpoch,
+					Metadata:             commit.metadata,
+					LogStartOffset:       -1,
+					LogEndOffset:         -1,
+					Lag:                  -1,
+				})
+			}
+		}
+	}
+	sort.Slice(report.Partitions, func(i, j int) bool {
+		a, b := report.Partitions[i], report.Partitions[j]
+		return a.Topic < b.Topic || (a.Topic == b.Topic && a.Partition < b.Partition)
+	})
+
+	starts := c.listOffsets(ctx, live, earliestTimestamp)
+	ends := c.listOffsets(ctx, live, latestTimestamp)
+	for i := range report.Partitions {
+		p := &report.Partitions[i]
+		if p.Status == Deleted {
+			continue
+		}
+		start, end := starts[p.TopicPartition], ends[p.TopicPartition]
+		p.LogStartOffset, p.LogEndOffset, p.Lag = start.offset, end.offset, -1
+		if p.Status == Failed {
+			continue
+		}
+		if err := firstError(start.err, end.err); err != nil {
+			p.Status, p.Err = Failed, err
+			continue
+		}
+		if p.Status == Committed {
+			if brokerLag := commits[p.TopicPartition].brokerLag; brokerLag >= 0 {
+				p.Lag = brokerLag
+			} else {
+				p.Lag = max(p.LogEndOffset-p.CommittedOffset, 0)
+			}
+			report.TotalLag += p.Lag
+		}
+	}
+	return report, nil
+}
+
+// refreshMetadata refreshes the metadata of the topics by name and by id. Topics which do not exist
+// anymore are removed from the metadata cache.
+func (c *Calculator) refreshMetadata(ctx context.Context, names []string, ids []uuid.UUID) error {
+	requested := make([]metadata.MetadataRequestTopic, 0, len(names)+len(ids))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			requested = append(requested, metadata.MetadataRequestTopic{Name: &name})
+		}
+	}
+	for _, id := range ids {
+		if id != uuid.Nil && !seen[id.String()] {
+			seen[id.String()] = true
+			requested = append(requested, metadata.MetadataRequestTopic{TopicId: id})
+		}
+	}
+	if len(requested) == 0 {
+		return nil
+	}
+	_, err := c.Client.Metadata(ctx, &metadata.MetadataRequest{Topics: &requested})
+	return err
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deref[T any](s *[]T) []T {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,244 @@
+package lag
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/describesharegroupoffsets"
+	"github.com/scholzj/go-kafka-protocol/api/metadata"
+	"github.com/scholzj/go-kafka-protocol/api/offsetfetch"
+	"github.com/scholzj/go-kafka-protocol/api/produce"
+	"github.com/scholzj/go-kafka-protocol/client"
+	"github.com/scholzj/go-kafka-protocol/compression"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/mockbroker"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// testCluster creates the topic orders with 5, 3 and 4 records in its partitions, and the deleted topic
+// payments.
+func testCluster(t *testing.T) (*mockbroker.Cluster, map[string]uuid.UUID) {
+	t.Helper()
+
+	cluster, err := mockbroker.NewCluster(3)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	t.Cleanup(cluster.Close)
+
+	ids := make(map[string]uuid.UUID)
+	for _, topic := range []string{"orders", "payments"} {
+		if ids[topic], err = cluster.CreateTopic(topic, 3, 1); err != nil {
+			t.Fatalf("CreateTopic: %v", err)
+		}
+	}
+	cluster.DeleteTopic("payments")
+
+	for partition, count := range []int{5, 3, 4} {
+		batch := records.NewRecordBatch(0, compression.None)
+		for i := 0; i < count; i++ {
+			batch.AppendRecord(1000, nil, []byte("value"), nil)
+		}
+		data, _ := records.WriteRecordBatches([]*records.RecordBatch{batch})
+		leader, _ := cluster.Broker(int32(partition))
+		leader.Handle(messages.Produce, &produce.ProduceRequest{ApiVersion: 12, Acks: -1, TopicData: &[]produce.ProduceRequestTopicData{{
+			Name:          str("orders"),
+			PartitionData: &[]produce.ProduceRequestTopicDataPartitionData{{Index: int32(partition), Records: &data}},
+		}}})
+	}
+	return cluster, ids
+}
+
+func testCalculator(t *testing.T, cluster *mockbroker.Cluster) (*Calculator, context.Context) {
+	c := client.NewClient(cluster.Addresses()...)
+	t.Cleanup(c.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return NewCalculator(c), ctx
+}
+
+func str(s string) *string {
+	return &s
+}
+
+func TestGroupLag(t *testing.T) {
+	commits := []struct {
+		topic     string
+		partition int32
+		offset    int64
+	}{
+		{"orders", 0, 2},
+		{"orders", 2, 4},
+		{"orders", 5, 7},
+		{"payments", 0, 1},
+	}
+
+	for _, version := range []int16{7, 9, 10} {
+		cluster, ids := testCluster(t)
+		cluster.SetMaxVersion(messages.OffsetFetch, version)
+		cluster.Handle(messages.OffsetFetch, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+			req := body.(*offsetfetch.OffsetFetchRequest)
+			res := &offsetfetch.OffsetFetchResponse{ApiVersion: req.ApiVersion}
+			if req.ApiVersion < 8 {
+				res.Topics = &[]offsetfetch.OffsetFetchResponseTopic{}
+				for _, c := range commits {
+					*res.Topics = append(*res.Topics, offsetfetch.OffsetFetchResponseTopic{Name: str(c.topic), Partitions: &[]offsetfetch.OffsetFetchResponseTopicPartition{
+						{PartitionIndex: c.partition, CommittedOffset: c.offset, CommittedLeaderEpoch: -1},
+					}})
+				}
+				return res, nil
+			}
+			topics := []offsetfetch.OffsetFetchResponseGroupTopic{}
+			for _, c := range commits {
+				topic := offsetfetch.OffsetFetchResponseGroupTopic{Name: str(c.topic), Partitions: &[]offsetfetch.OffsetFetchResponseGroupTopicPartition{
+					{PartitionIndex: c.partition, CommittedOffset: c.offset, CommittedLeaderEpoch: -1},
+				}}
+				if req.ApiVersion >= 10 {
+					topic.Name, topic.TopicId = nil, ids[c.topic]
+				}
+				topics = append(topics, topic)
+			}
+			res.Groups = &[]offsetfetch.OffsetFetchResponseGroup{{GroupId: (*req.Groups)[0].GroupId, Topics: &topics}}
+			return res, nil
+		})
+		calculator, ctx := testCalculator(t, cluster)
+
+		report, err := calculator.GroupLag(ctx, "group")
+		if err != nil {
+			t.Fatalf("v%d: GroupLag: %v", version, err)
+		}
+		deleted := "payments"
+		if version >= 10 {
+			deleted = ids["payments"].String()
+		}
+		expected := []PartitionLag{
+			{TopicPartition: TopicPartition{"orders", 0}, Status: Committed, CommittedOffset: 2, LogEndOffset: 5, Lag: 3},
+			{TopicPartition: TopicPartition{"orders", 1}, Status: NoCommit, CommittedOffset: -1, LogEndOffset: 3, Lag: -1},
+			{TopicPartition: TopicPartition{"orders", 2}, Status: Committed, CommittedOffset: 4, LogEndOffset: 4, Lag: 0},
+			{TopicPartition: TopicPartition{"orders", 5}, Status: Deleted, CommittedOffset: 7, LogStartOffset: -1, LogEndOffset: -1, Lag: -1},
+			{TopicPartition: TopicPartition{deleted, 0}, Status: Deleted, CommittedOffset: 1, LogStartOffset: -1, LogEndOffset: -1, Lag: -1},
+		}
+		if len(report.Partitions) != len(expected) || report.TotalLag != 3 || report.TopicLag("orders") != 3 {
+			t.Fatalf("v%d: unexpected report %+v", version, report)
+		}
+		// Deleted topics known only by id sort by their id.
+		byPartition := make(map[TopicPartition]PartitionLag)
+		for _, p := range report.Partitions {
+			byPartition[p.TopicPartition] = p
+		}
+		for _, e := range expected {
+			p := byPartition[e.TopicPartition]
+			if p.TopicPartition != e.TopicPartition || p.Status != e.Status || p.CommittedOffset != e.CommittedOffset || p.LogStartOffset != e.LogStartOffset ||
+				p.LogEndOffset != e.LogEndOffset || p.Lag != e.Lag || p.Err != nil {
+				t.Errorf("v%d: unexpected lag of %v: %+v", version, e.TopicPartition, p)
+			}
+		}
+
+		report, err = calculator.GroupLag(ctx, "group", "orders")
+		if err != nil || len(report.Partitions) != 4 {
+			t.Errorf("v%d: unexpected report %+v (%v)", version, report, err)
+		}
+	}
+}
+
+func TestGroupLagErrors(t *testing.T) {
+	cluster, _ := testCluster(t)
+	cluster.Handle(messages.OffsetFetch, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		req := body.(*offsetfetch.OffsetFetchRequest)
+		return &offsetfetch.OffsetFetchResponse{ApiVersion: req.ApiVersion, Groups: &[]offsetfetch.OffsetFetchResponseGroup{{
+			GroupId:   (*req.Groups)[0].GroupId,
+			ErrorCode: errorcodes.GroupIdNotFound,
+			Topics:    &[]offsetfetch.OffsetFetchResponseGroupTopic{},
+		}}}, nil
+	})
+	calculator, ctx := testCalculator(t, cluster)
+
+	var kafkaErr *errorcodes.Error
+	if _, err := calculator.GroupLag(ctx, "group"); !errors.As(err, &kafkaErr) || kafkaErr.Code != errorcodes.GroupIdNotFound {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestGroupLagRefreshesUnavailableLeaders(t *testing.T) {
+	cluster, ids := testCluster(t)
+	cluster.Handle(messages.OffsetFetch, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		req := body.(*offsetfetch.OffsetFetchRequest)
+		topics := []offsetfetch.OffsetFetchResponseGroupTopic{{TopicId: ids["orders"], Partitions: &[]offsetfetch.OffsetFetchResponseGroupTopicPartition{
+			{PartitionIndex: 1, CommittedOffset: 1, CommittedLeaderEpoch: -1},
+		}}}
+		return &offsetfetch.OffsetFetchResponse{ApiVersion: req.ApiVersion, Groups: &[]offsetfetch.OffsetFetchResponseGroup{{GroupId: (*req.Groups)[0].GroupId, Topics: &topics}}}, nil
+	})
+	// The first metadata of the topic has no leader of partition 1, like during a leader election.
+	var mu sync.Mutex
+	elected := false
+	cluster.Handle(messages.Metadata, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+		res, err := b.HandleBuiltIn(messages.Metadata, body)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, topic := range *res.(*metadata.MetadataResponse).Topics {
+			for i, p := range *topic.Partitions {
+				if derefString(topic.Name) == "orders" && p.PartitionIndex == 1 && !elected {
+					(*topic.Partitions)[i].LeaderId = -1
+					elected = true
+				}
+			}
+		}
+		return res, err
+	})
+	calculator, ctx := testCalculator(t, cluster)
+
+	report, err := calculator.GroupLag(ctx, "group")
+	if err != nil || len(report.Partitions) != 3 {
+		t.Fatalf("unexpected report %+v (%v)", report, err)
+	}
+	if p := report.Partitions[1]; p.TopicPartition != (TopicPartition{"orders", 1}) || p.Err != nil || p.LogEndOffset != 3 || p.Lag != 2 {
+		t.Errorf("unexpected lag %+v (%v)", p, p.Err)
+	}
+}
+
+func TestShareGroupLag(t *testing.T) {
+	for _, version := range []int16{0, 1} {
+		cluster, _ := testCluster(t)
+		cluster.SetMaxVersion(messages.DescribeShareGroupOffsets, version)
+		cluster.Handle(messages.DescribeShareGroupOffsets, func(b *mockbroker.Broker, body protocol.RequestBody) (protocol.ResponseBody, error) {
+			req := body.(*describesharegroupoffsets.DescribeShareGroupOffsetsRequest)
+			return &describesharegroupoffsets.DescribeShareGroupOffsetsResponse{ApiVersion: req.ApiVersion, Groups: &[]describesharegroupoffsets.DescribeShareGroupOffsetsResponseGroup{{
+				GroupId: (*req.Groups)[0].GroupId,
+				Topics: &[]describesharegroupoffsets.DescribeShareGroupOffsetsResponseGroupTopic{{
+					TopicName: str("orders"),
+					Partitions: &[]describesharegroupoffsets.DescribeShareGroupOffsetsResponseGroupTopicPartition{
+						{PartitionIndex: 0, StartOffset: 1, LeaderEpoch: 0, Lag: 2},
+						{PartitionIndex: 1, StartOffset: -1, LeaderEpoch: 0, Lag: -1},
+						{PartitionIndex: 2, StartOffset: 0, LeaderEpoch: 0, ErrorCode: errorcodes.CoordinatorLoadInProgress},
+					},
+				}},
+			}}}, nil
+		})
+		calculator, ctx := testCalculator(t, cluster)
+
+		report, err := calculator.ShareGroupLag(ctx, "share")
+		if err != nil || !report.ShareGroup || len(report.Partitions) != 3 {
+			t.Fatalf("v%d: unexpected report %+v (%v)", version, report, err)
+		}
+		// The lag computed by the broker leaves out acknowledged records.
+		expectedLag := int64(4)
+		if version >= 1 {
+			expectedLag = 2
+		}
+		if p := report.Partitions[0]; p.Status != Committed || p.Lag != expectedLag || report.TotalLag != expectedLag {
+			t.Errorf("v%d: unexpected lag %+v", version, p)
+		}
+		if p := report.Partitions[1]; p.Status != NoCommit || p.Lag != -1 || p.LogEndOffset != 3 {
+			t.Errorf("v%d: unexpected lag %+v", version, p)
+		}
+		if p := report.Partitions[2]; p.Status != Failed || p.Err == nil || p.Lag != -1 {
+			t.Errorf("v%d: unexpected lag %+v", version, p)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package lag
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/scholzj/go-kafka-protocol/api/listoffsets"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/metadatacache"
+)
+
+// Timestamps of ListOffsets for the log start and end offsets.
+const (
+	earliestTimestamp int64 = -2
+	latestTimestamp   int64 = -1
+)
+
+// Attempts to list the offsets of a partition, refreshing its leader between them.
+const maxListAttempts = 3
+
+// listed is the offset of a partition returned by ListOffsets, or the error of the partition.
+type listed struct {
+	offset int64
+	err    error
+}
+
+// listOffsets lists the offsets of the partitions at the timestamp from their leaders, all leaders
+// concurrently. Partitions which fail with a retriable error, like a moved leader, or have no leader in
+// the cache are tried again after the metadata of their topics is refreshed.
+func (c *Calculator) listOffsets(ctx context.Context, partitions []TopicPartition, timestamp int64) map[TopicPartition]listed {
+	results := make(map[TopicPartition]listed, len(partitions))
+	pending := partitions
+	for attempt := 1; len(pending) > 0; attempt++ {
+		byLeader := make(map[int32][]TopicPartition)
+		epochs := make(map[TopicPartition]int32)
+		for _, tp := range pending {
+			leader, epoch, err := c.Client.Cache.Leader(metadatacache.TopicPartition{Topic: tp.Topic, Partition: tp.Partition})
+			if err != nil {
+				results[tp] = listed{-1, err}
+				continue
+			}
+			byLeader[leader.NodeId] = append(byLeader[leader.NodeId], tp)
+			epochs[tp] = epoch
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for nodeId, tps := range byLeader {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				listedByLeader := c.listLeaderOffsets(ctx, nodeId, tps, epochs, timestamp)
+
+				mu.Lock()
+				defer mu.Unlock()
+				for tp, l := range listedByLeader {
+					results[tp] = l
+				}
+			}()
+		}
+		wg.Wait()
+
+		pending = nil
+		topics := make(map[string]bool)
+		for tp, l := range results {
+			if retriable(l.err) && attempt < maxListAttempts {
+				pending = append(pending, tp)
+				topics[tp.Topic] = true
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+		names := make([]string, 0, len(topics))
+		for topic := range topics {
+			names = append(names, topic)
+		}
+		if err := c.Client.RefreshMetadata(ctx, names...); err != nil {
+			break
+		}
+	}
+	return results
+}
+
+// retriable reports whether listing the offsets of a partition may succeed after its metadata is
+// refreshed: for retriable errors of the partition and while the cache knows no leader of it.
+func retriable(err error) bool {
+	var kafkaErr *errorcodes.Error
+	return (errors.As(err, &kafkaErr) && errorcodes.Retriable(kafkaErr.Code)) || errors.Is(err, metadatacache.ErrLeaderNotAvailable)
+}
+
+// listLeaderOffsets sends one ListOffsets request for the partitions to their leader.
+func (c *Calculator) listLeaderOffsets(ctx context.Context, nodeId int32, partitions []TopicPartition, epochs map[TopicPartition]int32, timestamp int64) map[TopicPartition]listed {
+	byTopic := make(map[string][]listoffsets.ListOffsetsRequestTopicPartition)
+	var order []string
+	for _, tp := range partitions {
+		if _, ok := byTopic[tp.Topic]; !ok {
+			order = append(order, tp.Topic)
+		}
+		byTopic[tp.Topic] = append(byTopic[tp.Topic], listoffsets.ListOffsetsRequestTopicPartition{PartitionIndex: tp.Partition, CurrentLeaderEpoch: epochs[tp], Timestamp: timestamp})
+	}
+	topics := make([]listoffsets.ListOffsetsRequestTopic, 0, len(order))
+	for _, topic := range order {
+		requested := byTopic[topic]
+		topics = append(topics, listoffsets.ListOffsetsRequestTopic{Name: &topic, Partitions: &requested})
+	}
+
+	results := make(map[TopicPartition]listed, len(partitions))
+	res, err := c.Client.ListOffsets(ctx, nodeId, &listoffsets.ListOffsetsRequest{ReplicaId: -1, IsolationLevel: c.IsolationLevel, Topics: &topics})
+	if err != nil {
+		for _, tp := range partitions {
+			results[tp] = listed{-1, err}
+		}
+		return results
+	}
+	for _, tp := range partitions {
+		results[tp] = listed{-1, errorcodes.ToError(errorcodes.UnknownTopicOrPartition, nil)}
+	}
+	for _, t := range deref(res.Topics) {
+		for _, p := range deref(t.Partitions) {
+			tp := TopicPartition{derefString(t.Name), p.PartitionIndex}
+			if err := errorcodes.ToError(p.ErrorCode, nil); err != nil {
+				results[tp] = listed{-1, err}
+			} else {
+				results[tp] = listed{p.Offset, nil}
+			}
+		}
+	}
+	return results
+}
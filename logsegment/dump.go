@@ -0,0 +1,69 @@
+package logsegment
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// DumpBatch writes a batch in the format of kafka-dump-log.sh. With records set, every record is
+// written on its own line after the batch.
+//
+//goland:noinspection GoUnhandledErrorResult
+func DumpBatch(w io.Writer, batch *records.RecordBatch, position int64, size int64, withRecords bool) {
+	lastSequence := batch.BaseSequence
+	if batch.BaseSequence >= 0 {
+		lastSequence = int32((int64(batch.BaseSequence) + int64(batch.LastOffsetDelta)) % (1 << 31))
+	}
+
+	deleteHorizon := "OptionalLong.empty"
+	if batch.HasDeleteHorizon() {
+		deleteHorizon = fmt.Sprintf("OptionalLong[%d]", batch.BaseTimestamp)
+	}
+
+	fmt.Fprintf(w, "baseOffset: %d lastOffset: %d count: %d baseSequence: %d lastSequence: %d producerId: %d producerEpoch: %d partitionLeaderEpoch: %d isTransactional: %v isControl: %v deleteHorizonMs: %s position: %d %s: %d size: %d magic: %d compresscodec: %s crc: %d isvalid: true\n",
+		batch.BaseOffset, batch.LastOffset(), len(batch.Records), batch.BaseSequence, lastSequence, batch.ProducerId, batch.ProducerEpoch,
+		batch.PartitionLeaderEpoch, batch.IsTransactional(), batch.IsControl(), deleteHorizon, position, timestampType(batch),
+		batch.MaxTimestamp, size, batch.Magic, batch.Compression(), batch.Crc)
+
+	if !withRecords {
+		return
+	}
+
+	for i, record := range batch.Records {
+		sequence := int32(-1)
+		if batch.BaseSequence >= 0 {
+			sequence = int32((int64(batch.BaseSequence) + int64(record.OffsetDelta)) % (1 << 31))
+		}
+
+		headerKeys := make([]string, 0, len(record.Headers))
+		for _, header := range record.Headers {
+			headerKeys = append(headerKeys, header.Key)
+		}
+
+		fmt.Fprintf(w, "| offset: %d %s: %d keySize: %d valueSize: %d sequence: %d headerKeys: %v",
+			batch.Offset(record), timestampType(batch), batch.Timestamp(record), nullableLen(record.Key), nullableLen(record.Value), sequence, headerKeys)
+
+		if batch.IsControl() {
+			if t, err := records.ControlType(batch.Records[i]); err == nil {
+				fmt.Fprintf(w, " controlType: %s(%d)", t, int16(t))
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func timestampType(batch *records.RecordBatch) string {
+	if batch.TimestampType() == records.LogAppendTime {
+		return "LogAppendTime"
+	}
+	return "CreateTime"
+}
+
+func nullableLen(value []byte) int {
+	if value == nil {
+		return -1
+	}
+	return len(value)
+}
@@ -0,0 +1,151 @@
+package logsegment
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+const (
+	offsetIndexEntrySize = 8
+	timeIndexEntrySize   = 12
+)
+
+// OffsetIndexEntry maps an offset to the position of the batch containing it.
+type OffsetIndexEntry struct {
+	Offset   int64
+	Position int64
+}
+
+// OffsetIndex is the sparse .index of a segment. On disk each entry is the offset relative to the
+// base offset (int32) and the position in the .log file (int32).
+type OffsetIndex struct {
+	BaseOffset int64
+	Entries    []OffsetIndexEntry
+}
+
+// ReadOffsetIndex decodes an offset index. The index of the active segment is preallocated and
+// zero filled, so decoding stops at the first empty entry after the first one, like Kafka trims an
+// index to its valid entries. An empty first entry only counts when a valid entry follows it; on its
+// own it is the empty index of a new segment, which it would not change lookups of anyway.
+func ReadOffsetIndex(data []byte, baseOffset int64) (*OffsetIndex, error) {
+	if len(data)%offsetIndexEntrySize != 0 {
+		return nil, fmt.Errorf("%w: offset index size %d is not a multiple of %d", ErrCorruptIndex, len(data), offsetIndexEntrySize)
+	}
+
+	index := &OffsetIndex{BaseOffset: baseOffset}
+	emptyFirst := false
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		relativeOffset, err := protocol.ReadInt32(r)
+		if err != nil {
+			return nil, err
+		}
+		position, err := protocol.ReadInt32(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if relativeOffset == 0 && position == 0 {
+			if len(index.Entries) > 0 || emptyFirst {
+				break
+			}
+			emptyFirst = true
+			continue
+		}
+		if emptyFirst && len(index.Entries) == 0 {
+			index.Entries = append(index.Entries, OffsetIndexEntry{Offset: baseOffset, Position: 0})
+		}
+		if relativeOffset < 0 || position < 0 {
+			return nil, fmt.Errorf("%w: negative entry (%d, %d)", ErrCorruptIndex, relativeOffset, position)
+		}
+
+		entry := OffsetIndexEntry{Offset: baseOffset + int64(relativeOffset), Position: int64(position)}
+		if last := len(index.Entries) - 1; last >= 0 && (entry.Offset <= index.Entries[last].Offset || entry.Position <= index.Entries[last].Position) {
+			return nil, fmt.Errorf("%w: entry for offset %d at position %d does not follow offset %d at position %d", ErrCorruptIndex, entry.Offset, entry.Position, index.Entries[last].Offset, index.Entries[last].Position)
+		}
+		index.Entries = append(index.Entries, entry)
+	}
+
+	return index, nil
+}
+
+// Lookup returns the entry with the largest offset at or below the offset. When there is none, it
+// returns the start of the segment.
+func (i *OffsetIndex) Lookup(offset int64) OffsetIndexEntry {
+	n := sort.Search(len(i.Entries), func(j int) bool { return i.Entries[j].Offset > offset })
+	if n == 0 {
+		return OffsetIndexEntry{Offset: i.BaseOffset, Position: 0}
+	}
+	return i.Entries[n-1]
+}
+
+// TimeIndexEntry maps a timestamp to the offset from which all records have a timestamp at or after
+// it.
+type TimeIndexEntry struct {
+	Timestamp int64
+	Offset    int64
+}
+
+// TimeIndex is the sparse .timeindex of a segment. On disk each entry is the timestamp (int64) and
+// the offset relative to the base offset (int32).
+type TimeIndex struct {
+	BaseOffset int64
+	Entries    []TimeIndexEntry
+}
+
+// ReadTimeIndex decodes a time index. Like the offset index it may be zero filled at the end, and an
+// empty first entry only counts when a valid entry follows it.
+func ReadTimeIndex(data []byte, baseOffset int64) (*TimeIndex, error) {
+	if len(data)%timeIndexEntrySize != 0 {
+		return nil, fmt.Errorf("%w: time index size %d is not a multiple of %d", ErrCorruptIndex, len(data), timeIndexEntrySize)
+	}
+
+	index := &TimeIndex{BaseOffset: baseOffset}
+	emptyFirst := false
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		timestamp, err := protocol.ReadInt64(r)
+		if err != nil {
+			return nil, err
+		}
+		relativeOffset, err := protocol.ReadInt32(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if timestamp == 0 && relativeOffset == 0 {
+			if len(index.Entries) > 0 || emptyFirst {
+				break
+			}
+			emptyFirst = true
+			continue
+		}
+		if emptyFirst && len(index.Entries) == 0 {
+			index.Entries = append(index.Entries, TimeIndexEntry{Timestamp: 0, Offset: baseOffset})
+		}
+		if relativeOffset < 0 {
+			return nil, fmt.Errorf("%w: negative relative offset %d", ErrCorruptIndex, relativeOffset)
+		}
+
+		entry := TimeIndexEntry{Timestamp: timestamp, Offset: baseOffset + int64(relativeOffset)}
+		if last := len(index.Entries) - 1; last >= 0 && (entry.Timestamp < index.Entries[last].Timestamp || entry.Offset < index.Entries[last].Offset) {
+			return nil, fmt.Errorf("%w: entry for timestamp %d at offset %d does not follow timestamp %d at offset %d", ErrCorruptIndex, entry.Timestamp, entry.Offset, index.Entries[last].Timestamp, index.Entries[last].Offset)
+		}
+		index.Entries = append(index.Entries, entry)
+	}
+
+	return index, nil
+}
+
+// Lookup returns the entry with the largest timestamp at or below the timestamp. When there is
+// none, it returns the start of the segment with timestamp -1.
+func (i *TimeIndex) Lookup(timestamp int64) TimeIndexEntry {
+	n := sort.Search(len(i.Entries), func(j int) bool { return i.Entries[j].Timestamp > timestamp })
+	if n == 0 {
+		return TimeIndexEntry{Timestamp: -1, Offset: i.BaseOffset}
+	}
+	return i.Entries[n-1]
+}
@@ -0,0 +1,235 @@
+package logsegment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/clustermetadata"
+	"github.com/scholzj/go-kafka-protocol/compression"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// writeSegment writes a segment of three batches (offsets 100-101, 102-104 and 105) with an index
+// entry and a time index entry per batch, zero padded like the indexes of an active segment.
+func writeSegment(t *testing.T, dir string) []int64 {
+	t.Helper()
+
+	var log, index, timeIndex bytes.Buffer
+	var positions []int64
+	offset := int64(100)
+	for i, count := range []int{2, 3, 1} {
+		batch := records.NewRecordBatch(offset, compression.None)
+		for j := 0; j < count; j++ {
+			batch.AppendRecord(int64(1000*(i+1)+j), nil, []byte("value"), nil)
+		}
+
+		positions = append(positions, int64(log.Len()))
+		index.Write(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, uint32(batch.LastOffset()-100)), uint32(log.Len())))
+		timeIndex.Write(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint64(nil, uint64(batch.MaxTimestamp)), uint32(batch.LastOffset()-100)))
+		if err := batch.Write(&log); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		offset = batch.NextOffset()
+	}
+	index.Write(make([]byte, 8*4))
+	timeIndex.Write(make([]byte, 12*4))
+
+	for suffix, data := range map[string][]byte{LogFileSuffix: log.Bytes(), IndexFileSuffix: index.Bytes(), TimeIndexFileSuffix: timeIndex.Bytes()} {
+		if err := os.WriteFile(filepath.Join(dir, FileName(100, suffix)), data, 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	return positions
+}
+
+func TestSegment(t *testing.T) {
+	dir := t.TempDir()
+	positions := writeSegment(t, dir)
+
+	segment, err := OpenSegment(dir, 100)
+	if err != nil {
+		t.Fatalf("OpenSegment: %v", err)
+	}
+	if len(segment.Index.Entries) != 3 || len(segment.TimeIndex.Entries) != 3 {
+		t.Fatalf("got %d index and %d time index entries", len(segment.Index.Entries), len(segment.TimeIndex.Entries))
+	}
+	if err := segment.Verify(); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	batch, position, err := segment.FindOffset(103)
+	if err != nil || batch == nil || batch.BaseOffset != 102 || position != positions[1] {
+		t.Errorf("FindOffset(103) = %v at %d, %v", batch, position, err)
+	}
+	if batch, _, _ := segment.FindOffset(200); batch != nil {
+		t.Errorf("FindOffset past the end returned batch %d", batch.BaseOffset)
+	}
+
+	batch, _, err = segment.FindTimestamp(2001)
+	if err != nil || batch == nil || batch.BaseOffset != 102 {
+		t.Errorf("FindTimestamp(2001) = %v, %v", batch, err)
+	}
+	batch, _, _ = segment.FindTimestamp(2500)
+	if batch == nil || batch.BaseOffset != 105 {
+		t.Errorf("FindTimestamp(2500) = %v", batch)
+	}
+
+	var dump strings.Builder
+	err = segment.Batches(func(batch *records.RecordBatch, position int64, size int64) error {
+		DumpBatch(&dump, batch, position, size, true)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Batches: %v", err)
+	}
+	if !strings.HasPrefix(dump.String(), "baseOffset: 100 lastOffset: 101 count: 2 baseSequence: -1 lastSequence: -1 producerId: -1") ||
+		!strings.Contains(dump.String(), "| offset: 101 CreateTime: 1001 keySize: -1 valueSize: 5 sequence: -1 headerKeys: []\n") {
+		t.Errorf("unexpected dump:\n%s", dump.String())
+	}
+}
+
+func TestSegmentCorruption(t *testing.T) {
+	dir := t.TempDir()
+	positions := writeSegment(t, dir)
+	logPath := filepath.Join(dir, FileName(100, LogFileSuffix))
+	data, _ := os.ReadFile(logPath)
+
+	// A flipped bit in the second batch fails its CRC.
+	corrupt := bytes.Clone(data)
+	corrupt[positions[2]-3] ^= 0x01
+	os.WriteFile(logPath, corrupt, 0o644)
+	segment, _ := OpenSegment(dir, 100)
+	if err := segment.Verify(); !errors.Is(err, ErrCorruptLog) || !strings.Contains(err.Error(), fmt.Sprintf("position %d", positions[1])) {
+		t.Errorf("Verify error = %v, want ErrCorruptLog", err)
+	}
+
+	// A truncated last batch.
+	os.WriteFile(logPath, data[:len(data)-5], 0o644)
+	if err := segment.Verify(); !errors.Is(err, ErrCorruptLog) {
+		t.Errorf("Verify of a truncated segment error = %v, want ErrCorruptLog", err)
+	}
+
+	// An index entry that points into the middle of a batch.
+	os.WriteFile(logPath, data, 0o644)
+	segment.Index.Entries[1].Position++
+	if err := segment.Verify(); !errors.Is(err, ErrCorruptIndex) {
+		t.Errorf("Verify with a bad index entry error = %v, want ErrCorruptIndex", err)
+	}
+
+	// Index entries must increase.
+	if _, err := ReadOffsetIndex([]byte{0, 0, 0, 5, 0, 0, 0, 9, 0, 0, 0, 4, 0, 0, 0, 20}, 0); !errors.Is(err, ErrCorruptIndex) {
+		t.Errorf("ReadOffsetIndex error = %v, want ErrCorruptIndex", err)
+	}
+}
+
+func TestEmptyActiveSegment(t *testing.T) {
+	dir := t.TempDir()
+	for suffix, data := range map[string][]byte{LogFileSuffix: nil, IndexFileSuffix: make([]byte, 8*16), TimeIndexFileSuffix: make([]byte, 12*16)} {
+		if err := os.WriteFile(filepath.Join(dir, FileName(100, suffix)), data, 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	segment, err := OpenSegment(dir, 100)
+	if err != nil {
+		t.Fatalf("OpenSegment: %v", err)
+	}
+	if len(segment.Index.Entries) != 0 || len(segment.TimeIndex.Entries) != 0 {
+		t.Errorf("got %d index and %d time index entries, want none", len(segment.Index.Entries), len(segment.TimeIndex.Entries))
+	}
+	if err := segment.Verify(); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if batch, _, err := segment.FindOffset(100); batch != nil || err != nil {
+		t.Errorf("FindOffset(100) = %v, %v", batch, err)
+	}
+
+	// An empty first entry counts when a valid entry follows it.
+	index, err := ReadOffsetIndex([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 5, 0, 0, 0, 9, 0, 0, 0, 0, 0, 0, 0, 0}, 100)
+	if err != nil || len(index.Entries) != 2 || index.Entries[0] != (OffsetIndexEntry{Offset: 100}) || index.Entries[1] != (OffsetIndexEntry{Offset: 105, Position: 9}) {
+		t.Errorf("ReadOffsetIndex = %+v, %v", index, err)
+	}
+}
+
+func TestLegacyMessageSet(t *testing.T) {
+	// A magic v1 message with a null key and value: CRC, magic, attributes, timestamp, key and value.
+	message := binary.BigEndian.AppendUint32(nil, 0)
+	message = append(message, 1, 0)
+	message = binary.BigEndian.AppendUint64(message, 1000)
+	message = binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(message, 0xffffffff), 0xffffffff)
+	data := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint64(nil, 100), uint32(len(message)))
+	data = append(data, message...)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, FileName(100, LogFileSuffix)), data, 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	segment, err := OpenSegment(dir, 100)
+	if err != nil {
+		t.Fatalf("OpenSegment: %v", err)
+	}
+	if err := segment.Verify(); !errors.Is(err, ErrUnsupportedBatch) || !errors.Is(err, records.ErrUnsupportedMagic) || errors.Is(err, ErrCorruptLog) {
+		t.Errorf("Verify error = %v, want ErrUnsupportedBatch", err)
+	}
+}
+
+func TestParseFileNames(t *testing.T) {
+	if offset, suffix, err := ParseFileName("/data/orders-0/00000000000000000042.timeindex"); err != nil || offset != 42 || suffix != TimeIndexFileSuffix {
+		t.Errorf("ParseFileName = %d %q %v", offset, suffix, err)
+	}
+	if _, _, err := ParseFileName("leader-epoch-checkpoint"); err == nil {
+		t.Error("ParseFileName must reject other files")
+	}
+
+	id := SnapshotId{EndOffset: 1000, Epoch: 3}
+	if name := SnapshotFileName(id); name != "00000000000000001000-0000000003.checkpoint" {
+		t.Errorf("SnapshotFileName = %s", name)
+	}
+	if parsed, err := ParseSnapshotFileName("00000000000000001000-0000000003.checkpoint"); err != nil || parsed != id {
+		t.Errorf("ParseSnapshotFileName = %+v, %v", parsed, err)
+	}
+}
+
+func TestReadSnapshot(t *testing.T) {
+	topicId := uuid.New()
+	header, _ := clustermetadata.NewControlBatch(0, 3, 5000, []clustermetadata.ControlMessage{&clustermetadata.SnapshotHeaderRecord{LastContainedLogTimestamp: 4999}})
+	body, _ := clustermetadata.NewBatch(1, 3, 5000, []clustermetadata.Record{{Version: 0, Message: &clustermetadata.TopicRecord{Name: "orders", TopicId: topicId}}})
+	footer, _ := clustermetadata.NewControlBatch(2, 3, 5000, []clustermetadata.ControlMessage{&clustermetadata.SnapshotFooterRecord{}})
+	data, _ := records.WriteRecordBatches([]*records.RecordBatch{header, body, footer})
+
+	path := filepath.Join(t.TempDir(), SnapshotFileName(SnapshotId{EndOffset: 1000, Epoch: 3}))
+	os.WriteFile(path, data, 0o644)
+
+	snapshot, err := ReadSnapshot(path)
+	if err != nil {
+		t.Fatalf("ReadSnapshot: %v", err)
+	}
+	if snapshot.Id.EndOffset != 1000 || snapshot.LastContainedLogTimestamp != 4999 || len(snapshot.Batches) != 3 {
+		t.Errorf("unexpected snapshot %+v", snapshot)
+	}
+
+	image := clustermetadata.NewImage()
+	if err := image.LoadSnapshot(snapshot.Id.EndOffset, snapshot.Id.Epoch, snapshot.Data); err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	if image.TopicByName("orders") == nil || image.NextOffset() != 1000 {
+		t.Errorf("unexpected image from the snapshot")
+	}
+
+	// A snapshot without its footer is incomplete.
+	noFooter, _ := records.WriteRecordBatches([]*records.RecordBatch{header, body})
+	if _, err := DecodeSnapshot(snapshot.Id, noFooter); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Errorf("DecodeSnapshot error = %v, want ErrCorruptSnapshot", err)
+	}
+	if _, err := DecodeSnapshot(snapshot.Id, data[:len(data)-1]); !errors.Is(err, ErrCorruptSnapshot) {
+		t.Errorf("DecodeSnapshot of a truncated snapshot error = %v, want ErrCorruptSnapshot", err)
+	}
+}
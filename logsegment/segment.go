@@ -0,0 +1,253 @@
+package logsegment
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// Readers for the files in a partition directory of a broker or controller log dir: the .log
+// segments holding the record batches, their .index and .timeindex files, and the .checkpoint
+// snapshots of the KRaft metadata log.
+
+const (
+	LogFileSuffix       = ".log"
+	IndexFileSuffix     = ".index"
+	TimeIndexFileSuffix = ".timeindex"
+)
+
+var (
+	ErrCorruptLog       = errors.New("corrupt log segment")
+	ErrCorruptIndex     = errors.New("corrupt index")
+	ErrUnsupportedBatch = errors.New("unsupported record batch")
+)
+
+// FileName returns the name of a segment file for the base offset, such as
+// 00000000000000000042.log.
+func FileName(baseOffset int64, suffix string) string {
+	return fmt.Sprintf("%020d%s", baseOffset, suffix)
+}
+
+// ParseFileName returns the base offset and the suffix of a segment file name.
+func ParseFileName(name string) (int64, string, error) {
+	name = filepath.Base(name)
+	ext := filepath.Ext(name)
+	offset, err := strconv.ParseInt(strings.TrimSuffix(name, ext), 10, 64)
+	if err != nil || offset < 0 {
+		return 0, "", fmt.Errorf("%s is not a log segment file name", name)
+	}
+	return offset, ext, nil
+}
+
+// LogReader iterates over the record batches of a .log segment.
+type LogReader struct {
+	r        *bufio.Reader
+	position int64
+}
+
+func NewLogReader(r io.Reader) *LogReader {
+	return &LogReader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// Position returns the byte position of the next batch.
+func (l *LogReader) Position() int64 {
+	return l.position
+}
+
+// Next returns the next batch and its size in bytes, or io.EOF at the end of the segment. A batch
+// that is cut short or fails its CRC check is reported as ErrCorruptLog with its position, and a
+// message set of magic v0 or v1 as ErrUnsupportedBatch.
+func (l *LogReader) Next() (*records.RecordBatch, int64, error) {
+	if _, err := l.r.Peek(1); err == io.EOF {
+		return nil, 0, io.EOF
+	}
+
+	counter := &countingReader{r: l.r}
+	batch, err := records.ReadRecordBatch(counter)
+	if err != nil {
+		if errors.Is(err, records.ErrUnsupportedMagic) {
+			return nil, 0, fmt.Errorf("%w: batch at position %d: %w", ErrUnsupportedBatch, l.position, err)
+		}
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, fmt.Errorf("%w: batch at position %d: %w", ErrCorruptLog, l.position, err)
+	}
+
+	size := counter.n
+	l.position += size
+	return batch, size, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Segment is a log segment with its indexes. The indexes are optional; without them lookups scan
+// the segment from its start.
+type Segment struct {
+	BaseOffset int64
+	Log        string // The path of the .log file.
+	Index      *OffsetIndex
+	TimeIndex  *TimeIndex
+}
+
+// OpenSegment opens the segment with the base offset in dir and reads its indexes, if present.
+func OpenSegment(dir string, baseOffset int64) (*Segment, error) {
+	segment := &Segment{BaseOffset: baseOffset, Log: filepath.Join(dir, FileName(baseOffset, LogFileSuffix))}
+	if _, err := os.Stat(segment.Log); err != nil {
+		return nil, err
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, FileName(baseOffset, IndexFileSuffix))); err == nil {
+		if segment.Index, err = ReadOffsetIndex(data, baseOffset); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, FileName(baseOffset, TimeIndexFileSuffix))); err == nil {
+		if segment.TimeIndex, err = ReadTimeIndex(data, baseOffset); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return segment, nil
+}
+
+// Batches calls fn for every batch of the segment with its position and size.
+func (s *Segment) Batches(fn func(batch *records.RecordBatch, position int64, size int64) error) error {
+	return s.scan(0, func(batch *records.RecordBatch, position int64, size int64) (bool, error) {
+		return true, fn(batch, position, size)
+	})
+}
+
+// scan reads the batches from the position on until fn returns false.
+func (s *Segment) scan(position int64, fn func(batch *records.RecordBatch, position int64, size int64) (bool, error)) error {
+	f, err := os.Open(s.Log)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(position, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := NewLogReader(f)
+	for {
+		batchPosition := position + reader.Position()
+		batch, size, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		more, err := fn(batch, batchPosition, size)
+		if err != nil || !more {
+			return err
+		}
+	}
+}
+
+// FindOffset returns the batch containing the offset and its position, using the offset index to
+// skip to the right part of the segment. It returns nil when the segment ends before the offset.
+func (s *Segment) FindOffset(offset int64) (*records.RecordBatch, int64, error) {
+	start := int64(0)
+	if s.Index != nil {
+		start = s.Index.Lookup(offset).Position
+	}
+
+	var found *records.RecordBatch
+	var foundPosition int64
+	err := s.scan(start, func(batch *records.RecordBatch, position int64, _ int64) (bool, error) {
+		if batch.LastOffset() >= offset {
+			found, foundPosition = batch, position
+			return false, nil
+		}
+		return true, nil
+	})
+
+	return found, foundPosition, err
+}
+
+// FindTimestamp returns the first batch with a timestamp at or after the timestamp, as used by
+// ListOffsets. It returns nil when there is no such batch in the segment.
+func (s *Segment) FindTimestamp(timestamp int64) (*records.RecordBatch, int64, error) {
+	start := int64(0)
+	if s.TimeIndex != nil && s.Index != nil {
+		start = s.Index.Lookup(s.TimeIndex.Lookup(timestamp).Offset).Position
+	}
+
+	var found *records.RecordBatch
+	var foundPosition int64
+	err := s.scan(start, func(batch *records.RecordBatch, position int64, _ int64) (bool, error) {
+		if batch.MaxTimestamp >= timestamp {
+			found, foundPosition = batch, position
+			return false, nil
+		}
+		return true, nil
+	})
+
+	return found, foundPosition, err
+}
+
+// Verify reads the whole segment and checks that every batch is intact, that the offsets increase,
+// and that the index entries point at batches containing their offsets.
+func (s *Segment) Verify() error {
+	positions := make(map[int64]*records.RecordBatch)
+	lastOffset := s.BaseOffset - 1
+
+	err := s.Batches(func(batch *records.RecordBatch, position int64, _ int64) error {
+		if batch.BaseOffset <= lastOffset {
+			return fmt.Errorf("%w: batch at position %d starts at offset %d, after offset %d", ErrCorruptLog, position, batch.BaseOffset, lastOffset)
+		}
+		lastOffset = batch.LastOffset()
+		positions[position] = batch
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.Index != nil {
+		for _, entry := range s.Index.Entries {
+			batch, ok := positions[entry.Position]
+			if !ok {
+				return fmt.Errorf("%w: offset %d points at position %d, which is not a batch boundary", ErrCorruptIndex, entry.Offset, entry.Position)
+			}
+			if entry.Offset < batch.BaseOffset || entry.Offset > batch.LastOffset() {
+				return fmt.Errorf("%w: offset %d points at the batch of offsets %d to %d", ErrCorruptIndex, entry.Offset, batch.BaseOffset, batch.LastOffset())
+			}
+		}
+	}
+
+	if s.TimeIndex != nil {
+		for _, entry := range s.TimeIndex.Entries {
+			if entry.Offset > lastOffset {
+				return fmt.Errorf("%w: timestamp %d points at offset %d past the end of the segment", ErrCorruptIndex, entry.Timestamp, entry.Offset)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package logsegment
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/scholzj/go-kafka-protocol/clustermetadata"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+const SnapshotFileSuffix = ".checkpoint"
+
+var ErrCorruptSnapshot = errors.New("corrupt snapshot")
+
+// SnapshotId identifies a raft snapshot: it contains the log up to EndOffset (exclusive), and the
+// last contained record has the leader Epoch.
+type SnapshotId struct {
+	EndOffset int64
+	Epoch     int32
+}
+
+// SnapshotFileName returns the name of a snapshot file, such as
+// 00000000000000001000-0000000003.checkpoint.
+func SnapshotFileName(id SnapshotId) string {
+	return fmt.Sprintf("%020d-%010d%s", id.EndOffset, id.Epoch, SnapshotFileSuffix)
+}
+
+// ParseSnapshotFileName returns the snapshot id of a snapshot file name.
+func ParseSnapshotFileName(name string) (SnapshotId, error) {
+	name = filepath.Base(name)
+	if !strings.HasSuffix(name, SnapshotFileSuffix) {
+		return SnapshotId{}, fmt.Errorf("%s is not a snapshot file name", name)
+	}
+
+	offset, epoch, ok := strings.Cut(strings.TrimSuffix(name, SnapshotFileSuffix), "-")
+	endOffset, offsetErr := strconv.ParseInt(offset, 10, 64)
+	leaderEpoch, epochErr := strconv.ParseInt(epoch, 10, 32)
+	if !ok || offsetErr != nil || epochErr != nil || endOffset < 0 || leaderEpoch < 0 {
+		return SnapshotId{}, fmt.Errorf("%s is not a snapshot file name", name)
+	}
+
+	return SnapshotId{EndOffset: endOffset, Epoch: int32(leaderEpoch)}, nil
+}
+
+// Snapshot is a raft snapshot read from a .checkpoint file.
+type Snapshot struct {
+	Id                        SnapshotId
+	LastContainedLogTimestamp int64
+	Batches                   []*records.RecordBatch
+	Data                      []byte // The raw snapshot, for example for clustermetadata.Image.LoadSnapshot.
+}
+
+// ReadSnapshot reads and validates a snapshot file.
+func ReadSnapshot(path string) (*Snapshot, error) {
+	id, err := ParseSnapshotFileName(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeSnapshot(id, data)
+}
+
+// DecodeSnapshot validates a snapshot: every batch must be complete and intact, the first record must
+// be the snapshot header and the last record the snapshot footer.
+func DecodeSnapshot(id SnapshotId, data []byte) (*Snapshot, error) {
+	snapshot := &Snapshot{Id: id, Data: data}
+
+	reader := NewLogReader(bytes.NewReader(data))
+	for {
+		batch, _, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCorruptSnapshot, err)
+		}
+		snapshot.Batches = append(snapshot.Batches, batch)
+	}
+
+	if len(snapshot.Batches) == 0 {
+		return nil, fmt.Errorf("%w: the snapshot is empty", ErrCorruptSnapshot)
+	}
+
+	first := snapshot.Batches[0]
+	header, err := controlMessage(first, 0)
+	if err != nil {
+		return nil, err
+	}
+	h, ok := header.(*clustermetadata.SnapshotHeaderRecord)
+	if !ok {
+		return nil, fmt.Errorf("%w: the snapshot does not start with a snapshot header", ErrCorruptSnapshot)
+	}
+	snapshot.LastContainedLogTimestamp = h.LastContainedLogTimestamp
+
+	last := snapshot.Batches[len(snapshot.Batches)-1]
+	footer, err := controlMessage(last, len(last.Records)-1)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := footer.(*clustermetadata.SnapshotFooterRecord); !ok {
+		return nil, fmt.Errorf("%w: the snapshot does not end with a snapshot footer", ErrCorruptSnapshot)
+	}
+
+	return snapshot, nil
+}
+
+func controlMessage(batch *records.RecordBatch, index int) (clustermetadata.ControlMessage, error) {
+	if !batch.IsControl() || index < 0 || index >= len(batch.Records) {
+		return nil, nil
+	}
+
+	message, err := clustermetadata.DecodeControlRecord(batch.Records[index])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorruptSnapshot, err)
+	}
+	return message, nil
+}
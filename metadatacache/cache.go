@@ -0,0 +1,453 @@
+package metadatacache
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/describetopicpartitions"
+	"github.com/scholzj/go-kafka-protocol/api/metadata"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+// DefaultMaxAge matches the default of metadata.max.age.ms.
+const DefaultMaxAge = 5 * time.Minute
+
+// NoLeader is the leader id of a partition without a leader.
+const NoLeader int32 = -1
+
+var (
+	ErrUnknownTopic       = errors.New("unknown topic")
+	ErrUnknownPartition   = errors.New("unknown partition")
+	ErrLeaderNotAvailable = errors.New("the leader of the partition is not available")
+)
+
+// TopicPartition identifies a partition by topic name.
+type TopicPartition struct {
+	Topic     string
+	Partition int32
+}
+
+func (tp TopicPartition) String() string {
+	return fmt.Sprintf("%s-%d", tp.Topic, tp.Partition)
+}
+
+// Broker is a broker of the cluster. A nil Rack means that the broker has no rack.
+type Broker struct {
+	NodeId int32
+	Host   string
+	Port   int32
+	Rack   *string
+}
+
+// Address returns the host:port address of the broker.
+func (b Broker) Address() string {
+	return net.JoinHostPort(b.Host, strconv.Itoa(int(b.Port)))
+}
+
+// Partition is the cached metadata of a partition. The eligible leader replicas are only known from
+// DescribeTopicPartitions responses.
+type Partition struct {
+	TopicPartition
+	TopicId                uuid.UUID
+	ErrorCode              int16
+	LeaderId               int32
+	LeaderEpoch            int32 // -1 when unknown (Metadata before v7).
+	Replicas               []int32
+	Isr                    []int32
+	OfflineReplicas        []int32
+	EligibleLeaderReplicas []int32
+	LastKnownElr           []int32
+}
+
+type topicEntry struct {
+	name       string
+	id         uuid.UUID
+	internal   bool
+	partitions map[int32]*Partition
+	updated    time.Time
+}
+
+// Cache maps topics and partitions to their leaders and brokers. It is updated from Metadata and
+// DescribeTopicPartitions responses and from the leader hints of Produce and Fetch responses. It is
+// safe for concurrent use.
+type Cache struct {
+	// Now is the clock used to expire topics. It defaults to time.Now and can be replaced in tests.
+	Now func() time.Time
+
+	// MaxAge is how long a topic stays in the cache without being updated by a Metadata or
+	// DescribeTopicPartitions response.
+	MaxAge time.Duration
+
+	mu           sync.Mutex
+	clusterId    *string
+	controllerId int32
+	brokers      map[int32]Broker
+	topics       map[string]*topicEntry
+	topicNames   map[uuid.UUID]string
+}
+
+func NewCache() *Cache {
+	return &Cache{
+		Now:          time.Now,
+		MaxAge:       DefaultMaxAge,
+		controllerId: -1,
+		brokers:      make(map[int32]Broker),
+		topics:       make(map[string]*topicEntry),
+		topicNames:   make(map[uuid.UUID]string),
+	}
+}
+
+////////////////////
+// Updates
+////////////////////
+
+// UpdateMetadata applies a Metadata response. The brokers of the response replace the known brokers.
+// Topics without an error replace the cached ones, and topics which do not exist anymore are removed.
+// Topics with other errors, such as LEADER_NOT_AVAILABLE during a topic creation, keep their cached
+// metadata. A partition with an older leader epoch than the cached one is ignored, since the response
+// came from a broker which has not seen the latest leader change yet (KIP-320).
+func (c *Cache) UpdateMetadata(res *metadata.MetadataResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if res.Brokers != nil {
+		c.brokers = make(map[int32]Broker, len(*res.Brokers))
+		for _, b := range *res.Brokers {
+			c.brokers[b.NodeId] = Broker{NodeId: b.NodeId, Host: deref(b.Host), Port: b.Port, Rack: b.Rack}
+		}
+	}
+	if res.ApiVersion >= 2 {
+		c.clusterId = res.ClusterId
+	}
+	if res.ApiVersion >= 1 {
+		c.controllerId = res.ControllerId
+	}
+
+	if res.Topics == nil {
+		return
+	}
+	for _, t := range *res.Topics {
+		if c.removeUnknown(t.ErrorCode, deref(t.Name), t.TopicId) || t.ErrorCode != errorcodes.None {
+			continue
+		}
+
+		var partitions []Partition
+		if t.Partitions != nil {
+			for _, p := range *t.Partitions {
+				leaderEpoch := p.LeaderEpoch
+				if res.ApiVersion < 7 {
+					leaderEpoch = -1
+				}
+				partitions = append(partitions, Partition{
+					ErrorCode:       p.ErrorCode,
+					TopicPartition:  TopicPartition{Partition: p.PartitionIndex},
+					LeaderId:        p.LeaderId,
+					LeaderEpoch:     leaderEpoch,
+					Replicas:        derefSlice(p.ReplicaNodes),
+					Isr:             derefSlice(p.IsrNodes),
+					OfflineReplicas: derefSlice(p.OfflineReplicas),
+				})
+			}
+		}
+		c.updateTopic(deref(t.Name), t.TopicId, t.IsInternal, partitions, true)
+	}
+}
+
+// UpdateDescribeTopicPartitions applies a DescribeTopicPartitions response. The response can be one
+// page of a paginated description, so its partitions are merged into the cached ones instead of
+// replacing them.
+func (c *Cache) UpdateDescribeTopicPartitions(res *describetopicpartitions.DescribeTopicPartitionsResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if res.Topics == nil {
+		return
+	}
+	for _, t := range *res.Topics {
+		if c.removeUnknown(t.ErrorCode, deref(t.Name), t.TopicId) || t.ErrorCode != errorcodes.None {
+			continue
+		}
+
+		var partitions []Partition
+		if t.Partitions != nil {
+			for _, p := range *t.Partitions {
+				partitions = append(partitions, Partition{
+					ErrorCode:              p.ErrorCode,
+					TopicPartition:         TopicPartition{Partition: p.PartitionIndex},
+					LeaderId:               p.LeaderId,
+					LeaderEpoch:            p.LeaderEpoch,
+					Replicas:               derefSlice(p.ReplicaNodes),
+					Isr:                    derefSlice(p.IsrNodes),
+					OfflineReplicas:        derefSlice(p.OfflineReplicas),
+					EligibleLeaderReplicas: derefSlice(p.EligibleLeaderReplicas),
+					LastKnownElr:           derefSlice(p.LastKnownElr),
+				})
+			}
+		}
+		c.updateTopic(deref(t.Name), t.TopicId, t.IsInternal, partitions, false)
+	}
+}
+
+// removeUnknown removes a topic which does not exist anymore and returns whether it did.
+func (c *Cache) removeUnknown(errorCode int16, name string, id uuid.UUID) bool {
+	if errorCode != errorcodes.UnknownTopicOrPartition && errorCode != errorcodes.UnknownTopicId {
+		return false
+	}
+
+	if name == "" {
+		name = c.topicNames[id]
+	}
+	if entry, ok := c.topics[name]; ok {
+		delete(c.topicNames, entry.id)
+		delete(c.topics, name)
+	}
+	return true
+}
+
+func (c *Cache) updateTopic(name string, id uuid.UUID, internal bool, partitions []Partition, replace bool) {
+	entry, ok := c.topics[name]
+	if !ok || (id != uuid.Nil && entry.id != uuid.Nil && entry.id != id) {
+		// A topic which was deleted and created again starts from scratch.
+		if ok {
+			delete(c.topicNames, entry.id)
+		}
+		entry = &topicEntry{name: name, partitions: make(map[int32]*Partition)}
+		c.topics[name] = entry
+	}
+	if id != uuid.Nil {
+		entry.id = id
+		c.topicNames[id] = name
+	}
+	entry.internal = internal
+	entry.updated = c.Now()
+
+	old := entry.partitions
+	if replace {
+		entry.partitions = make(map[int32]*Partition, len(partitions))
+	}
+	for _, p := range partitions {
+		p.Topic = name
+		p.TopicId = entry.id
+		if cached, ok := old[p.Partition]; ok && p.LeaderEpoch >= 0 && cached.LeaderEpoch > p.LeaderEpoch {
+			entry.partitions[p.Partition] = cached
+			continue
+		}
+		partition := p
+		entry.partitions[p.Partition] = &partition
+	}
+}
+
+// Expire removes the topics which were not updated within MaxAge and returns their names.
+func (c *Cache) Expire() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expired []string
+	now := c.Now()
+	for name, entry := range c.topics {
+		if now.Sub(entry.updated) > c.MaxAge {
+			expired = append(expired, name)
+			delete(c.topicNames, entry.id)
+			delete(c.topics, name)
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+////////////////////
+// Lookups
+////////////////////
+
+func (c *Cache) ClusterId() *string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.clusterId
+}
+
+// ControllerId returns the controller id of the last Metadata response, or -1.
+func (c *Cache) ControllerId() int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.controllerId
+}
+
+func (c *Cache) Broker(nodeId int32) (Broker, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, ok := c.brokers[nodeId]
+	return b, ok
+}
+
+// Brokers returns the known brokers ordered by node id.
+func (c *Cache) Brokers() []Broker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	brokers := make([]Broker, 0, len(c.brokers))
+	for _, b := range c.brokers {
+		brokers = append(brokers, b)
+	}
+	sort.Slice(brokers, func(i, j int) bool { return brokers[i].NodeId < brokers[j].NodeId })
+	return brokers
+}
+
+// Topics returns the names of the cached topics in alphabetical order.
+func (c *Cache) Topics() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	names := make([]string, 0, len(c.topics))
+	for name := range c.topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *Cache) TopicId(name string) (uuid.UUID, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.topics[name]
+	if !ok || entry.id == uuid.Nil {
+		return uuid.Nil, false
+	}
+	return entry.id, true
+}
+
+func (c *Cache) TopicName(id uuid.UUID) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name, ok := c.topicNames[id]
+	return name, ok
+}
+
+// IsInternal returns whether the topic is an internal topic, such as __consumer_offsets.
+func (c *Cache) IsInternal(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.topics[name]
+	return ok && entry.internal
+}
+
+// Partition returns a copy of the cached metadata of the partition.
+func (c *Cache) Partition(tp TopicPartition) (Partition, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, err := c.partition(tp)
+	if err != nil {
+		return Partition{}, err
+	}
+	return *p, nil
+}
+
+func (c *Cache) partition(tp TopicPartition) (*Partition, error) {
+	entry, ok := c.topics[tp.Topic]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownTopic, tp.Topic)
+	}
+	p, ok := entry.partitions[tp.Partition]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownPartition, tp)
+	}
+	return p, nil
+}
+
+// Partitions returns the partitions of the topic ordered by index.
+func (c *Cache) Partitions(topic string) []Partition {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.topics[topic]
+	if !ok {
+		return nil
+	}
+	partitions := make([]Partition, 0, len(entry.partitions))
+	for _, p := range entry.partitions {
+		partitions = append(partitions, *p)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].Partition < partitions[j].Partition })
+	return partitions
+}
+
+// NumPartitions returns the number of partitions of the topic, or 0 for an unknown topic.
+func (c *Cache) NumPartitions(topic string) int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.topics[topic]; ok {
+		return int32(len(entry.partitions))
+	}
+	return 0
+}
+
+// AvailablePartitions returns the partitions of the topic with a known leader, ordered by index, as
+// used by the partitioners for records without a key.
+func (c *Cache) AvailablePartitions(topic string) []int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.topics[topic]
+	if !ok {
+		return nil
+	}
+	var available []int32
+	for index, p := range entry.partitions {
+		if _, ok := c.brokers[p.LeaderId]; ok && p.LeaderId != NoLeader {
+			available = append(available, index)
+		}
+	}
+	sort.Slice(available, func(i, j int) bool { return available[i] < available[j] })
+	return available
+}
+
+// Leader returns the leader broker of the partition and its leader epoch.
+func (c *Cache) Leader(tp TopicPartition) (Broker, int32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, err := c.partition(tp)
+	if err != nil {
+		return Broker{}, -1, err
+	}
+	b, ok := c.brokers[p.LeaderId]
+	if p.LeaderId == NoLeader || !ok {
+		return Broker{}, p.LeaderEpoch, fmt.Errorf("%w: %s", ErrLeaderNotAvailable, tp)
+	}
+	return b, p.LeaderEpoch, nil
+}
+
+// Rack returns the rack of the broker, or nil when the broker is unknown or has no rack.
+func (c *Cache) Rack(nodeId int32) *string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.brokers[nodeId].Rack
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func derefSlice(s *[]int32) []int32 {
+	if s == nil {
+		return nil
+	}
+	return append([]int32(nil), *s...)
+}
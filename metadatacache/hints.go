@@ -0,0 +1,141 @@
+package metadatacache
+
+import (
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/fetch"
+	"github.com/scholzj/go-kafka-protocol/api/produce"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+// Produce (v10+) and Fetch (v12+, with endpoints from v16) responses carry the current leader of the
+// partitions which failed with NOT_LEADER_OR_FOLLOWER or FENCED_LEADER_EPOCH, together with the
+// endpoints of these leaders (KIP-951). Applying them moves the requests to the new leader without
+// waiting for a Metadata response.
+
+// leaderHint is the new leader of a partition from a Produce or Fetch response.
+type leaderHint struct {
+	topic       string
+	topicId     uuid.UUID
+	partition   int32
+	errorCode   int16
+	leaderId    int32
+	leaderEpoch int32
+}
+
+// ApplyProduceResponse applies the leader hints of a Produce response and returns the partitions which
+// moved to a new leader.
+func (c *Cache) ApplyProduceResponse(res *produce.ProduceResponse) []TopicPartition {
+	var endpoints []Broker
+	if res.NodeEndpoints != nil {
+		for _, e := range *res.NodeEndpoints {
+			endpoints = append(endpoints, Broker{NodeId: e.NodeId, Host: deref(e.Host), Port: e.Port, Rack: e.Rack})
+		}
+	}
+
+	var hints []leaderHint
+	if res.Responses != nil {
+		for _, t := range *res.Responses {
+			if t.PartitionResponses == nil {
+				continue
+			}
+			for _, p := range *t.PartitionResponses {
+				if p.CurrentLeader == nil {
+					continue
+				}
+				hints = append(hints, leaderHint{
+					topic:       deref(t.Name),
+					topicId:     t.TopicId,
+					partition:   p.Index,
+					errorCode:   p.ErrorCode,
+					leaderId:    p.CurrentLeader.LeaderId,
+					leaderEpoch: p.CurrentLeader.LeaderEpoch,
+				})
+			}
+		}
+	}
+
+	return c.applyHints(endpoints, hints)
+}
+
+// ApplyFetchResponse applies the leader hints of a Fetch response and returns the partitions which
+// moved to a new leader.
+func (c *Cache) ApplyFetchResponse(res *fetch.FetchResponse) []TopicPartition {
+	var endpoints []Broker
+	if res.NodeEndpoints != nil {
+		for _, e := range *res.NodeEndpoints {
+			endpoints = append(endpoints, Broker{NodeId: e.NodeId, Host: deref(e.Host), Port: e.Port, Rack: e.Rack})
+		}
+	}
+
+	var hints []leaderHint
+	if res.Responses != nil {
+		for _, t := range *res.Responses {
+			if t.Partitions == nil {
+				continue
+			}
+			for _, p := range *t.Partitions {
+				if p.CurrentLeader == nil {
+					continue
+				}
+				hints = append(hints, leaderHint{
+					topic:       deref(t.Topic),
+					topicId:     t.TopicId,
+					partition:   p.PartitionIndex,
+					errorCode:   p.ErrorCode,
+					leaderId:    p.CurrentLeader.LeaderId,
+					leaderEpoch: p.CurrentLeader.LeaderEpoch,
+				})
+			}
+		}
+	}
+
+	return c.applyHints(endpoints, hints)
+}
+
+// applyHints mirrors Metadata.updatePartitionLeadership of the Java client: the endpoints are added to
+// the known brokers, and a partition moves to the hinted leader only when the hint has a newer leader
+// epoch and the leader broker is known.
+func (c *Cache) applyHints(endpoints []Broker, hints []leaderHint) []TopicPartition {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, b := range endpoints {
+		c.brokers[b.NodeId] = b
+	}
+
+	var updated []TopicPartition
+	for _, hint := range hints {
+		if hint.errorCode != errorcodes.NotLeaderOrFollower && hint.errorCode != errorcodes.FencedLeaderEpoch {
+			continue
+		}
+		if hint.leaderId < 0 || hint.leaderEpoch < 0 {
+			continue
+		}
+		if _, ok := c.brokers[hint.leaderId]; !ok {
+			continue
+		}
+
+		name := hint.topic
+		if name == "" {
+			name = c.topicNames[hint.topicId]
+		}
+		p, err := c.partition(TopicPartition{Topic: name, Partition: hint.partition})
+		if err != nil || (p.LeaderEpoch >= 0 && hint.leaderEpoch <= p.LeaderEpoch) {
+			continue
+		}
+
+		p.LeaderId = hint.leaderId
+		p.LeaderEpoch = hint.leaderEpoch
+		updated = append(updated, p.TopicPartition)
+	}
+
+	sort.Slice(updated, func(i, j int) bool {
+		if updated[i].Topic != updated[j].Topic {
+			return updated[i].Topic < updated[j].Topic
+		}
+		return updated[i].Partition < updated[j].Partition
+	})
+	return updated
+}
@@ -0,0 +1,217 @@
+package metadatacache
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/describetopicpartitions"
+	"github.com/scholzj/go-kafka-protocol/api/fetch"
+	"github.com/scholzj/go-kafka-protocol/api/metadata"
+	"github.com/scholzj/go-kafka-protocol/api/produce"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+var ordersId = uuid.MustParse("5b4a5a34-8e1a-4d0e-9d3f-0a4b6c7d8e9f")
+
+func str(s string) *string {
+	return &s
+}
+
+func metadataPartition(index int32, leader int32, epoch int32, replicas ...int32) metadata.MetadataResponseTopicPartition {
+	return metadata.MetadataResponseTopicPartition{
+		PartitionIndex:  index,
+		LeaderId:        leader,
+		LeaderEpoch:     epoch,
+		ReplicaNodes:    &replicas,
+		IsrNodes:        &replicas,
+		OfflineReplicas: &[]int32{},
+	}
+}
+
+func metadataResponse(partitions ...metadata.MetadataResponseTopicPartition) *metadata.MetadataResponse {
+	return &metadata.MetadataResponse{
+		ApiVersion: 12,
+		Brokers: &[]metadata.MetadataResponseBroker{
+			{NodeId: 1, Host: str("broker-1"), Port: 9092, Rack: str("zone-a")},
+			{NodeId: 2, Host: str("broker-2"), Port: 9092, Rack: str("zone-b")},
+		},
+		ClusterId:    str("cluster"),
+		ControllerId: 1,
+		Topics: &[]metadata.MetadataResponseTopic{
+			{Name: str("orders"), TopicId: ordersId, Partitions: &partitions},
+			{Name: str("__consumer_offsets"), TopicId: uuid.New(), IsInternal: true, Partitions: &[]metadata.MetadataResponseTopicPartition{}},
+		},
+	}
+}
+
+func TestUpdateMetadata(t *testing.T) {
+	c := NewCache()
+	c.UpdateMetadata(metadataResponse(metadataPartition(0, 1, 5, 1, 2), metadataPartition(1, 2, 3, 2, 1), metadataPartition(2, -1, 7, 3)))
+
+	if id := c.ClusterId(); id == nil || *id != "cluster" || c.ControllerId() != 1 {
+		t.Errorf("unexpected cluster id %v and controller %d", id, c.ControllerId())
+	}
+	if !reflect.DeepEqual(c.Topics(), []string{"__consumer_offsets", "orders"}) || !c.IsInternal("__consumer_offsets") || c.IsInternal("orders") {
+		t.Errorf("unexpected topics %v", c.Topics())
+	}
+	if id, ok := c.TopicId("orders"); !ok || id != ordersId {
+		t.Errorf("unexpected topic id %s", id)
+	}
+	if name, ok := c.TopicName(ordersId); !ok || name != "orders" {
+		t.Errorf("unexpected topic name %s", name)
+	}
+
+	leader, epoch, err := c.Leader(TopicPartition{"orders", 1})
+	if err != nil || leader.NodeId != 2 || leader.Address() != "broker-2:9092" || epoch != 3 {
+		t.Errorf("unexpected leader %+v with epoch %d (%v)", leader, epoch, err)
+	}
+	if rack := c.Rack(2); rack == nil || *rack != "zone-b" {
+		t.Errorf("unexpected rack %v", rack)
+	}
+	if _, _, err := c.Leader(TopicPartition{"orders", 2}); !errors.Is(err, ErrLeaderNotAvailable) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, _, err := c.Leader(TopicPartition{"orders", 3}); !errors.Is(err, ErrUnknownPartition) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, _, err := c.Leader(TopicPartition{"payments", 0}); !errors.Is(err, ErrUnknownTopic) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if available := c.AvailablePartitions("orders"); !reflect.DeepEqual(available, []int32{0, 1}) || c.NumPartitions("orders") != 3 {
+		t.Errorf("unexpected available partitions %v", available)
+	}
+
+	// A broker which has not seen the new leader yet does not move the partition back.
+	c.UpdateMetadata(metadataResponse(metadataPartition(0, 2, 4, 1, 2), metadataPartition(1, 1, 4, 2, 1)))
+	if p, _ := c.Partition(TopicPartition{"orders", 0}); p.LeaderId != 1 || p.LeaderEpoch != 5 {
+		t.Errorf("unexpected partition %+v", p)
+	}
+	if p, _ := c.Partition(TopicPartition{"orders", 1}); p.LeaderId != 1 || p.LeaderEpoch != 4 {
+		t.Errorf("unexpected partition %+v", p)
+	}
+	if c.NumPartitions("orders") != 2 {
+		t.Errorf("the partitions were not replaced")
+	}
+
+	// A topic with another error keeps its metadata, a deleted one is removed.
+	c.UpdateMetadata(&metadata.MetadataResponse{ApiVersion: 12, Topics: &[]metadata.MetadataResponseTopic{{ErrorCode: errorcodes.LeaderNotAvailable, Name: str("orders"), TopicId: ordersId}}})
+	if c.NumPartitions("orders") != 2 {
+		t.Errorf("the topic with an error was changed")
+	}
+	c.UpdateMetadata(&metadata.MetadataResponse{ApiVersion: 12, Topics: &[]metadata.MetadataResponseTopic{{ErrorCode: errorcodes.UnknownTopicId, TopicId: ordersId}}})
+	if _, ok := c.TopicName(ordersId); ok || c.NumPartitions("orders") != 0 {
+		t.Errorf("the deleted topic is still cached")
+	}
+}
+
+func TestRecreatedTopic(t *testing.T) {
+	c := NewCache()
+	c.UpdateMetadata(metadataResponse(metadataPartition(0, 1, 5, 1, 2)))
+
+	newId := uuid.New()
+	response := metadataResponse(metadataPartition(0, 2, 0, 2))
+	(*response.Topics)[0].TopicId = newId
+	c.UpdateMetadata(response)
+
+	if _, ok := c.TopicName(ordersId); ok {
+		t.Error("the old topic id is still known")
+	}
+	if p, _ := c.Partition(TopicPartition{"orders", 0}); p.TopicId != newId || p.LeaderId != 2 || p.LeaderEpoch != 0 {
+		t.Errorf("unexpected partition %+v", p)
+	}
+}
+
+func TestUpdateDescribeTopicPartitions(t *testing.T) {
+	c := NewCache()
+	c.UpdateMetadata(metadataResponse(metadataPartition(0, 1, 5, 1, 2), metadataPartition(1, 2, 3, 2, 1)))
+
+	c.UpdateDescribeTopicPartitions(&describetopicpartitions.DescribeTopicPartitionsResponse{Topics: &[]describetopicpartitions.DescribeTopicPartitionsResponseTopic{{
+		Name:    str("orders"),
+		TopicId: ordersId,
+		Partitions: &[]describetopicpartitions.DescribeTopicPartitionsResponseTopicPartition{{
+			PartitionIndex:         1,
+			LeaderId:               1,
+			LeaderEpoch:            4,
+			ReplicaNodes:           &[]int32{2, 1},
+			IsrNodes:               &[]int32{1},
+			EligibleLeaderReplicas: &[]int32{2},
+			LastKnownElr:           &[]int32{},
+			OfflineReplicas:        &[]int32{},
+		}},
+	}}})
+
+	if c.NumPartitions("orders") != 2 {
+		t.Fatalf("the page replaced the other partitions")
+	}
+	if p, _ := c.Partition(TopicPartition{"orders", 1}); p.LeaderId != 1 || !reflect.DeepEqual(p.EligibleLeaderReplicas, []int32{2}) {
+		t.Errorf("unexpected partition %+v", p)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := NewCache()
+	c.Now = func() time.Time { return now }
+	c.UpdateMetadata(metadataResponse(metadataPartition(0, 1, 5, 1, 2)))
+
+	now = now.Add(DefaultMaxAge)
+	if expired := c.Expire(); len(expired) != 0 {
+		t.Fatalf("unexpected expired topics %v", expired)
+	}
+	now = now.Add(time.Second)
+	if expired := c.Expire(); !reflect.DeepEqual(expired, []string{"__consumer_offsets", "orders"}) {
+		t.Fatalf("unexpected expired topics %v", expired)
+	}
+	if _, ok := c.TopicName(ordersId); ok || len(c.Topics()) != 0 {
+		t.Error("the expired topics are still cached")
+	}
+}
+
+func TestApplyProduceResponse(t *testing.T) {
+	c := NewCache()
+	c.UpdateMetadata(metadataResponse(metadataPartition(0, 1, 5, 1, 2, 3), metadataPartition(1, 1, 5, 1, 2, 3)))
+
+	response := &produce.ProduceResponse{
+		ApiVersion: 13,
+		Responses: &[]produce.ProduceResponseResponse{{TopicId: ordersId, PartitionResponses: &[]produce.ProduceResponseResponsePartitionResponse{
+			{Index: 0, ErrorCode: errorcodes.NotLeaderOrFollower, CurrentLeader: &produce.ProduceResponseResponsePartitionResponseCurrentLeader{LeaderId: 3, LeaderEpoch: 6}},
+			{Index: 1, ErrorCode: errorcodes.NotLeaderOrFollower, CurrentLeader: &produce.ProduceResponseResponsePartitionResponseCurrentLeader{LeaderId: 3, LeaderEpoch: 5}},
+		}}},
+		NodeEndpoints: &[]produce.ProduceResponseNodeEndpoint{{NodeId: 3, Host: str("broker-3"), Port: 9094}},
+	}
+
+	if updated := c.ApplyProduceResponse(response); !reflect.DeepEqual(updated, []TopicPartition{{"orders", 0}}) {
+		t.Fatalf("unexpected updated partitions %v", updated)
+	}
+	if leader, epoch, err := c.Leader(TopicPartition{"orders", 0}); err != nil || leader.Address() != "broker-3:9094" || epoch != 6 {
+		t.Errorf("unexpected leader %+v with epoch %d (%v)", leader, epoch, err)
+	}
+	if p, _ := c.Partition(TopicPartition{"orders", 1}); p.LeaderId != 1 {
+		t.Errorf("a hint without a newer epoch moved the leader: %+v", p)
+	}
+}
+
+func TestApplyFetchResponse(t *testing.T) {
+	c := NewCache()
+	c.UpdateMetadata(metadataResponse(metadataPartition(0, 1, 5, 1, 2, 4), metadataPartition(1, 1, 5, 1, 2, 4), metadataPartition(2, 1, 5, 1, 2, 4)))
+
+	response := &fetch.FetchResponse{
+		ApiVersion: 12,
+		Responses: &[]fetch.FetchResponseResponse{{Topic: str("orders"), Partitions: &[]fetch.FetchResponseResponsePartition{
+			{PartitionIndex: 0, ErrorCode: errorcodes.FencedLeaderEpoch, CurrentLeader: &fetch.FetchResponseResponsePartitionCurrentLeader{LeaderId: 2, LeaderEpoch: 7}},
+			{PartitionIndex: 1, ErrorCode: errorcodes.NotLeaderOrFollower, CurrentLeader: &fetch.FetchResponseResponsePartitionCurrentLeader{LeaderId: 4, LeaderEpoch: 7}},
+			{PartitionIndex: 2, ErrorCode: errorcodes.None, CurrentLeader: &fetch.FetchResponseResponsePartitionCurrentLeader{LeaderId: 2, LeaderEpoch: 7}},
+		}}},
+	}
+
+	// Broker 4 is unknown without endpoints, and hints of successful partitions are ignored.
+	if updated := c.ApplyFetchResponse(response); !reflect.DeepEqual(updated, []TopicPartition{{"orders", 0}}) {
+		t.Fatalf("unexpected updated partitions %v", updated)
+	}
+	if leader, _, _ := c.Leader(TopicPartition{"orders", 0}); leader.NodeId != 2 {
+		t.Errorf("unexpected leader %+v", leader)
+	}
+}
@@ -0,0 +1,382 @@
+package mockbroker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// A stand-in for a Kafka cluster in the same process, for testing clients end to end. Every broker
+// listens on a local TCP port. The brokers answer ApiVersions, Metadata, FindCoordinator, Produce, Fetch
+// and ListOffsets from a shared in-memory state; other APIs can be served by registering a Handler.
+
+// DefaultClusterId is the cluster id of a new cluster.
+const DefaultClusterId = "mockbroker-cluster0"
+
+var (
+	ErrUnsupportedRequest = errors.New("the mock broker does not handle this request")
+	ErrTopicExists        = errors.New("the topic already exists")
+	ErrUnknownBroker      = errors.New("unknown broker")
+)
+
+// Handler handles a request received by the broker. A nil response body sends no response.
+type Handler func(b *Broker, body protocol.RequestBody) (protocol.ResponseBody, error)
+
+// Cluster is a set of mock brokers sharing topics, partitions and their logs. It is safe for
+// concurrent use.
+type Cluster struct {
+	ClusterId string
+
+	mu          sync.Mutex
+	brokers     []*Broker
+	topics      map[string]*topic
+	handlers    map[int16]Handler
+	maxVersions map[int16]int16
+}
+
+// Broker is a mock broker of a cluster.
+type Broker struct {
+	NodeId int32
+	Rack   *string
+
+	cluster  *Cluster
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+}
+
+type topic struct {
+	name       string
+	id         uuid.UUID
+	partitions []*partition
+}
+
+type partition struct {
+	leader    int32
+	epoch     int32
+	replicas  []int32
+	log       []*records.RecordBatch
+	endOffset int64
+}
+
+// NewCluster starts a cluster with the brokers 0 to brokers-1 listening on local ports.
+func NewCluster(brokers int) (*Cluster, error) {
+	c := &Cluster{
+		ClusterId:   DefaultClusterId,
+		topics:      make(map[string]*topic),
+		handlers:    make(map[int16]Handler),
+		maxVersions: make(map[int16]int16),
+	}
+
+	for i := 0; i < brokers; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("failed to listen: %w", err)
+		}
+		b := &Broker{NodeId: int32(i), cluster: c, listener: listener, conns: make(map[net.Conn]bool)}
+		c.brokers = append(c.brokers, b)
+		b.wg.Add(1)
+		go b.accept()
+	}
+	return c, nil
+}
+
+// Close stops all brokers and closes their connections.
+func (c *Cluster) Close() {
+	for _, b := range c.brokers {
+		b.close()
+	}
+}
+
+// Brokers returns the brokers of the cluster.
+func (c *Cluster) Brokers() []*Broker {
+	return append([]*Broker(nil), c.brokers...)
+}
+
+// Broker returns the broker with the node id.
+func (c *Cluster) Broker(nodeId int32) (*Broker, bool) {
+	if nodeId < 0 || int(nodeId) >= len(c.brokers) {
+		return nil, false
+	}
+	return c.brokers[nodeId], true
+}
+
+// Addresses returns the addresses of all brokers, to be used as seed brokers.
+func (c *Cluster) Addresses() []string {
+	addresses := make([]string, 0, len(c.brokers))
+	for _, b := range c.brokers {
+		addresses = append(addresses, b.Address())
+	}
+	return addresses
+}
+
+// Handle registers a handler for the API, replacing the built-in one.
+func (c *Cluster) Handle(apiKey int16, handler Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.handlers[apiKey] = handler
+}
+
+// SetMaxVersion limits the versions of the API the brokers support, for example to test the version
+// negotiation of clients.
+func (c *Cluster) SetMaxVersion(apiKey int16, version int16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxVersions[apiKey] = version
+}
+
+// versionRange returns the versions of the API supported by the brokers.
+func (c *Cluster) versionRange(apiKey int16) (int16, int16, bool) {
+	minVersion, maxVersion, ok := messages.VersionRange(apiKey)
+	if limit, limited := c.maxVersions[apiKey]; limited && limit < maxVersion {
+		maxVersion = limit
+	}
+	return minVersion, maxVersion, ok && minVersion <= maxVersion
+}
+
+// CreateTopic creates a topic. The leaders and replicas of the partitions are assigned to the brokers
+// round robin.
+func (c *Cluster) CreateTopic(name string, partitions int32, replicationFactor int16) (uuid.UUID, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.topics[name]; ok {
+		return uuid.Nil, fmt.Errorf("%w: %s", ErrTopicExists, name)
+	}
+	if int(replicationFactor) > len(c.brokers) || replicationFactor < 1 {
+		return uuid.Nil, fmt.Errorf("invalid replication factor %d for %d brokers", replicationFactor, len(c.brokers))
+	}
+
+	t := &topic{name: name, id: uuid.New()}
+	for i := int32(0); i < partitions; i++ {
+		var replicas []int32
+		for r := int32(0); r < int32(replicationFactor); r++ {
+			replicas = append(replicas, (i+r)%int32(len(c.brokers)))
+		}
+		t.partitions = append(t.partitions, &partition{leader: replicas[0], replicas: replicas})
+	}
+	c.topics[name] = t
+	return t.id, nil
+}
+
+// DeleteTopic deletes a topic with its logs.
+func (c *Cluster) DeleteTopic(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.topics, name)
+}
+
+// SetLeader moves the leadership of the partition to the broker and bumps the leader epoch.
+func (c *Cluster) SetLeader(topic string, index int32, nodeId int32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := c.partition(topic, index)
+	if p == nil {
+		return fmt.Errorf("unknown partition %s-%d", topic, index)
+	}
+	if _, ok := c.Broker(nodeId); !ok {
+		return fmt.Errorf("%w: %d", ErrUnknownBroker, nodeId)
+	}
+	p.leader = nodeId
+	p.epoch++
+	return nil
+}
+
+// Log returns the batches written to the partition.
+func (c *Cluster) Log(topic string, index int32) []*records.RecordBatch {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := c.partition(topic, index)
+	if p == nil {
+		return nil
+	}
+	return append([]*records.RecordBatch(nil), p.log...)
+}
+
+func (c *Cluster) partition(name string, index int32) *partition {
+	t, ok := c.topics[name]
+	if !ok || index < 0 || int(index) >= len(t.partitions) {
+		return nil
+	}
+	return t.partitions[index]
+}
+
+func (c *Cluster) topicById(id uuid.UUID) *topic {
+	for _, t := range c.topics {
+		if t.id == id {
+			return t
+		}
+	}
+	return nil
+}
+
+// Coordinator returns the node id of the coordinator of a group or transactional id.
+func (c *Cluster) Coordinator(key string) int32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int32(h.Sum32() % uint32(len(c.brokers)))
+}
+
+////////////////////
+// Brokers
+////////////////////
+
+// Address returns the host:port address of the broker.
+func (b *Broker) Address() string {
+	return b.listener.Addr().String()
+}
+
+func (b *Broker) hostPort() (string, int32) {
+	host, port, _ := net.SplitHostPort(b.Address())
+	p, _ := strconv.Atoi(port)
+	return host, int32(p)
+}
+
+func (b *Broker) accept() {
+	defer b.wg.Done()
+
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		b.mu.Lock()
+		b.conns[conn] = true
+		b.mu.Unlock()
+
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.Serve(conn)
+
+			b.mu.Lock()
+			delete(b.conns, conn)
+			b.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// close stops accepting connections, closes the open ones and waits for them to finish.
+func (b *Broker) close() {
+	b.listener.Close()
+
+	b.mu.Lock()
+	for conn := range b.conns {
+		conn.Close()
+	}
+	b.mu.Unlock()
+
+	b.wg.Wait()
+}
+
+// Handle handles a request body and returns the response body with the same API version, or nil when
+// no response is sent.
+func (b *Broker) Handle(apiKey int16, body protocol.RequestBody) (protocol.ResponseBody, error) {
+	c := b.cluster
+	c.mu.Lock()
+	handler, ok := c.handlers[apiKey]
+	c.mu.Unlock()
+	if ok {
+		return handler(b, body)
+	}
+	return b.HandleBuiltIn(apiKey, body)
+}
+
+// HandleBuiltIn handles the request with the built-in handler of the API, for example for a registered
+// handler which only changes the built-in response.
+func (b *Broker) HandleBuiltIn(apiKey int16, body protocol.RequestBody) (protocol.ResponseBody, error) {
+	c := b.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch apiKey {
+	case messages.ApiVersions:
+		return b.apiVersions(body)
+	case messages.Metadata:
+		return b.metadata(body)
+	case messages.FindCoordinator:
+		return b.findCoordinator(body)
+	case messages.Produce:
+		return b.produce(body)
+	case messages.Fetch:
+		return b.fetch(body)
+	case messages.ListOffsets:
+		return b.listOffsets(body)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedRequest, messages.Name(apiKey))
+	}
+}
+
+// Serve reads requests from the connection and writes the responses until the connection fails or is
+// closed. Like a Kafka broker, it closes the connection after a request it cannot handle.
+func (b *Broker) Serve(conn io.ReadWriter) error {
+	for {
+		request, err := protocol.ReadRequest(conn)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		var responseBody protocol.ResponseBody
+		b.cluster.mu.Lock()
+		minVersion, maxVersion, ok := b.cluster.versionRange(request.ApiKey)
+		b.cluster.mu.Unlock()
+		if request.ApiKey == messages.ApiVersions && (!ok || request.ApiVersion > maxVersion) {
+			// An unsupported version of ApiVersions is answered with version 0 (KIP-511).
+			request.ApiVersion = 0
+			responseBody = b.unsupportedApiVersions()
+		} else {
+			if !ok || request.ApiVersion < minVersion || request.ApiVersion > maxVersion {
+				return fmt.Errorf("unsupported version %d of %s", request.ApiVersion, messages.Name(request.ApiKey))
+			}
+			body, ok := messages.NewRequestBody(request.ApiKey)
+			if !ok {
+				return fmt.Errorf("unknown API key %d", request.ApiKey)
+			}
+			if err := body.Read(&request); err != nil {
+				return fmt.Errorf("failed to decode the %s request: %w", messages.Name(request.ApiKey), err)
+			}
+			responseBody, err = b.Handle(request.ApiKey, body)
+			if err != nil {
+				return err
+			}
+		}
+		if responseBody == nil {
+			continue
+		}
+
+		buf := bytes.NewBuffer(make([]byte, 0))
+		if err := responseBody.Write(buf); err != nil {
+			return fmt.Errorf("failed to encode the %s response: %w", messages.Name(request.ApiKey), err)
+		}
+		response := protocol.Response{
+			ResponseHeader: protocol.ResponseHeader{ApiKey: request.ApiKey, ApiVersion: request.ApiVersion, CorrelationId: request.CorrelationId},
+			Body:           buf,
+		}
+		if err := response.Write(conn); err != nil {
+			return err
+		}
+	}
+}
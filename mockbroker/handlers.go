@@ -0,0 +1,369 @@
+package mockbroker
+
+import (
+	"sort"
+
+	"github.com/scholzj/go-kafka-protocol/api/apiversions"
+	"github.com/scholzj/go-kafka-protocol/api/fetch"
+	"github.com/scholzj/go-kafka-protocol/api/findcoordinator"
+	"github.com/scholzj/go-kafka-protocol/api/listoffsets"
+	"github.com/scholzj/go-kafka-protocol/api/metadata"
+	"github.com/scholzj/go-kafka-protocol/api/produce"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// The special timestamps of ListOffsets.
+const (
+	LatestTimestamp   int64 = -1
+	EarliestTimestamp int64 = -2
+)
+
+// authorizedOperationsOmitted is the value of the authorized operations fields when they were not
+// requested.
+const authorizedOperationsOmitted int32 = -2147483648
+
+////////////////////
+// ApiVersions
+////////////////////
+
+func (b *Broker) supportedApiKeys() []apiversions.ApiVersionsResponseApiKey {
+	var keys []apiversions.ApiVersionsResponseApiKey
+	for apiKey := int16(0); apiKey < 100; apiKey++ {
+		if minVersion, maxVersion, ok := b.cluster.versionRange(apiKey); ok {
+			keys = append(keys, apiversions.ApiVersionsResponseApiKey{ApiKey: apiKey, MinVersion: minVersion, MaxVersion: maxVersion})
+		}
+	}
+	return keys
+}
+
+func (b *Broker) apiVersions(body protocol.RequestBody) (protocol.ResponseBody, error) {
+	req := body.(*apiversions.ApiVersionsRequest)
+	keys := b.supportedApiKeys()
+	return &apiversions.ApiVersionsResponse{ApiVersion: req.ApiVersion, ApiKeys: &keys, FinalizedFeaturesEpoch: -1}, nil
+}
+
+func (b *Broker) unsupportedApiVersions() protocol.ResponseBody {
+	b.cluster.mu.Lock()
+	defer b.cluster.mu.Unlock()
+
+	keys := b.supportedApiKeys()
+	return &apiversions.ApiVersionsResponse{ApiVersion: 0, ErrorCode: errorcodes.UnsupportedVersion, ApiKeys: &keys}
+}
+
+////////////////////
+// Metadata and coordinators
+////////////////////
+
+func (b *Broker) metadata(body protocol.RequestBody) (protocol.ResponseBody, error) {
+	req := body.(*metadata.MetadataRequest)
+	c := b.cluster
+
+	brokers := make([]metadata.MetadataResponseBroker, 0, len(c.brokers))
+	for _, broker := range c.brokers {
+		host, port := broker.hostPort()
+		brokers = append(brokers, metadata.MetadataResponseBroker{NodeId: broker.NodeId, Host: &host, Port: port, Rack: broker.Rack})
+	}
+
+	var requested []*topic
+	topics := []metadata.MetadataResponseTopic{}
+	if req.Topics == nil || (req.ApiVersion == 0 && len(*req.Topics) == 0) {
+		for _, t := range c.topics {
+			requested = append(requested, t)
+		}
+		sort.Slice(requested, func(i, j int) bool { return requested[i].name < requested[j].name })
+	} else {
+		for _, rt := range *req.Topics {
+			var t *topic
+			if rt.Name != nil {
+				t = c.topics[*rt.Name]
+			} else {
+				t = c.topicById(rt.TopicId)
+			}
+			if t == nil {
+				errorCode := errorcodes.UnknownTopicOrPartition
+				if rt.Name == nil {
+					errorCode = errorcodes.UnknownTopicId
+				}
+				topics = append(topics, metadata.MetadataResponseTopic{ErrorCode: errorCode, Name: rt.Name, TopicId: rt.TopicId, Partitions: &[]metadata.MetadataResponseTopicPartition{}, TopicAuthorizedOperations: authorizedOperationsOmitted})
+				continue
+			}
+			requested = append(requested, t)
+		}
+	}
+
+	for _, t := range requested {
+		partitions := make([]metadata.MetadataResponseTopicPartition, 0, len(t.partitions))
+		for i, p := range t.partitions {
+			replicas := append([]int32(nil), p.replicas...)
+			partitions = append(partitions, metadata.MetadataResponseTopicPartition{
+				PartitionIndex:  int32(i),
+				LeaderId:        p.leader,
+				LeaderEpoch:     p.epoch,
+				ReplicaNodes:    &replicas,
+				IsrNodes:        &replicas,
+				OfflineReplicas: &[]int32{},
+			})
+		}
+		topics = append(topics, metadata.MetadataResponseTopic{Name: &t.name, TopicId: t.id, Partitions: &partitions, TopicAuthorizedOperations: authorizedOperationsOmitted})
+	}
+
+	return &metadata.MetadataResponse{
+		ApiVersion:                  req.ApiVersion,
+		Brokers:                     &brokers,
+		ClusterId:                   &c.ClusterId,
+		ControllerId:                c.brokers[0].NodeId,
+		Topics:                      &topics,
+		ClusterAuthorizedOperations: authorizedOperationsOmitted,
+	}, nil
+}
+
+func (b *Broker) findCoordinator(body protocol.RequestBody) (protocol.ResponseBody, error) {
+	req := body.(*findcoordinator.FindCoordinatorRequest)
+	c := b.cluster
+
+	if req.ApiVersion < 4 {
+		coordinator := c.brokers[c.Coordinator(deref(req.Key))]
+		host, port := coordinator.hostPort()
+		return &findcoordinator.FindCoordinatorResponse{ApiVersion: req.ApiVersion, NodeId: coordinator.NodeId, Host: &host, Port: port}, nil
+	}
+
+	coordinators := []findcoordinator.FindCoordinatorResponseCoordinator{}
+	if req.CoordinatorKeys != nil {
+		for _, key := range *req.CoordinatorKeys {
+			coordinator := c.brokers[c.Coordinator(key)]
+			host, port := coordinator.hostPort()
+			coordinators = append(coordinators, findcoordinator.FindCoordinatorResponseCoordinator{Key: &key, NodeId: coordinator.NodeId, Host: &host, Port: port})
+		}
+	}
+	return &findcoordinator.FindCoordinatorResponse{ApiVersion: req.ApiVersion, Coordinators: &coordinators}, nil
+}
+
+////////////////////
+// Produce, Fetch and ListOffsets
+////////////////////
+
+// lookup returns the partition of a request and the error code for a partition which is unknown or
+// not led by the broker.
+func (b *Broker) lookup(t *topic, index int32, byId bool) (*partition, int16) {
+	switch {
+	case t == nil && byId:
+		return nil, errorcodes.UnknownTopicId
+	case t == nil || index < 0 || int(index) >= len(t.partitions):
+		return nil, errorcodes.UnknownTopicOrPartition
+	case t.partitions[index].leader != b.NodeId:
+		return t.partitions[index], errorcodes.NotLeaderOrFollower
+	default:
+		return t.partitions[index], errorcodes.None
+	}
+}
+
+func (b *Broker) produce(body protocol.RequestBody) (protocol.ResponseBody, error) {
+	req := body.(*produce.ProduceRequest)
+	c := b.cluster
+	byId := req.ApiVersion >= 13
+
+	res := &produce.ProduceResponse{ApiVersion: req.ApiVersion, Responses: &[]produce.ProduceResponseResponse{}}
+	leaders := make(map[int32]bool)
+	if req.TopicData != nil {
+		for _, td := range *req.TopicData {
+			var t *topic
+			if byId {
+				t = c.topicById(td.TopicId)
+			} else {
+				t = c.topics[deref(td.Name)]
+			}
+
+			partitions := []produce.ProduceResponseResponsePartitionResponse{}
+			if td.PartitionData != nil {
+				for _, pd := range *td.PartitionData {
+					pr := produce.ProduceResponseResponsePartitionResponse{
+						Index:           pd.Index,
+						BaseOffset:      -1,
+						LogAppendTimeMs: -1,
+						LogStartOffset:  -1,
+						RecordErrors:    &[]produce.ProduceResponseResponsePartitionResponseRecordError{},
+						CurrentLeader:   &produce.ProduceResponseResponsePartitionResponseCurrentLeader{LeaderId: -1, LeaderEpoch: -1},
+					}
+					p, errorCode := b.lookup(t, pd.Index, byId)
+					if errorCode == errorcodes.NotLeaderOrFollower {
+						pr.CurrentLeader = &produce.ProduceResponseResponsePartitionResponseCurrentLeader{LeaderId: p.leader, LeaderEpoch: p.epoch}
+						leaders[p.leader] = true
+					}
+					if errorCode == errorcodes.None {
+						errorCode = p.append(pd.Records, &pr)
+					}
+					pr.ErrorCode = errorCode
+					partitions = append(partitions, pr)
+				}
+			}
+			*res.Responses = append(*res.Responses, produce.ProduceResponseResponse{Name: td.Name, TopicId: td.TopicId, PartitionResponses: &partitions})
+		}
+	}
+
+	if len(leaders) > 0 {
+		endpoints := []produce.ProduceResponseNodeEndpoint{}
+		for _, broker := range c.brokers {
+			if leaders[broker.NodeId] {
+				host, port := broker.hostPort()
+				endpoints = append(endpoints, produce.ProduceResponseNodeEndpoint{NodeId: broker.NodeId, Host: &host, Port: port, Rack: broker.Rack})
+			}
+		}
+		res.NodeEndpoints = &endpoints
+	}
+
+	if req.Acks == 0 {
+		return nil, nil
+	}
+	return res, nil
+}
+
+// append assigns offsets to the batches and appends them to the log.
+func (p *partition) append(data *[]byte, pr *produce.ProduceResponseResponsePartitionResponse) int16 {
+	if data == nil {
+		return errorcodes.InvalidRecord
+	}
+	batches, err := records.ReadRecordBatches(*data)
+	if err != nil || len(batches) == 0 {
+		return errorcodes.CorruptMessage
+	}
+
+	pr.BaseOffset = p.endOffset
+	for _, batch := range batches {
+		batch.BaseOffset = p.endOffset
+		batch.PartitionLeaderEpoch = p.epoch
+		p.log = append(p.log, batch)
+		p.endOffset = batch.NextOffset()
+	}
+	pr.LogStartOffset = 0
+	return errorcodes.None
+}
+
+func (b *Broker) fetch(body protocol.RequestBody) (protocol.ResponseBody, error) {
+	req := body.(*fetch.FetchRequest)
+	c := b.cluster
+	byId := req.ApiVersion >= 13
+
+	responses := []fetch.FetchResponseResponse{}
+	if req.Topics != nil {
+		for _, ft := range *req.Topics {
+			var t *topic
+			if byId {
+				t = c.topicById(ft.TopicId)
+			} else {
+				t = c.topics[deref(ft.Topic)]
+			}
+
+			partitions := []fetch.FetchResponseResponsePartition{}
+			if ft.Partitions != nil {
+				for _, fp := range *ft.Partitions {
+					pr := fetch.FetchResponseResponsePartition{
+						PartitionIndex:       fp.Partition,
+						HighWatermark:        -1,
+						LastStableOffset:     -1,
+						LogStartOffset:       -1,
+						PreferredReadReplica: -1,
+					}
+					p, errorCode := b.lookup(t, fp.Partition, byId)
+					if errorCode == errorcodes.NotLeaderOrFollower {
+						pr.CurrentLeader = &fetch.FetchResponseResponsePartitionCurrentLeader{LeaderId: p.leader, LeaderEpoch: p.epoch}
+					}
+					if errorCode == errorcodes.None {
+						errorCode = p.read(fp.FetchOffset, fp.PartitionMaxBytes, &pr)
+					}
+					pr.ErrorCode = errorCode
+					partitions = append(partitions, pr)
+				}
+			}
+			responses = append(responses, fetch.FetchResponseResponse{Topic: ft.Topic, TopicId: ft.TopicId, Partitions: &partitions})
+		}
+	}
+
+	return &fetch.FetchResponse{ApiVersion: req.ApiVersion, Responses: &responses}, nil
+}
+
+// read returns the batches from the offset on, at least one and otherwise up to maxBytes.
+func (p *partition) read(offset int64, maxBytes int32, pr *fetch.FetchResponseResponsePartition) int16 {
+	if offset < 0 || offset > p.endOffset {
+		return errorcodes.OffsetOutOfRange
+	}
+
+	var batches []*records.RecordBatch
+	var data []byte
+	for _, batch := range p.log {
+		if batch.LastOffset() < offset {
+			continue
+		}
+		encoded, err := records.WriteRecordBatches(append(batches, batch))
+		if err != nil {
+			return errorcodes.UnknownServerError
+		}
+		if len(batches) > 0 && len(encoded) > int(maxBytes) {
+			break
+		}
+		batches = append(batches, batch)
+		data = encoded
+	}
+
+	pr.HighWatermark = p.endOffset
+	pr.LastStableOffset = p.endOffset
+	pr.LogStartOffset = 0
+	pr.Records = &data
+	return errorcodes.None
+}
+
+func (b *Broker) listOffsets(body protocol.RequestBody) (protocol.ResponseBody, error) {
+	req := body.(*listoffsets.ListOffsetsRequest)
+	c := b.cluster
+
+	topics := []listoffsets.ListOffsetsResponseTopic{}
+	if req.Topics != nil {
+		for _, lt := range *req.Topics {
+			t := c.topics[deref(lt.Name)]
+			partitions := []listoffsets.ListOffsetsResponseTopicPartition{}
+			if lt.Partitions != nil {
+				for _, lp := range *lt.Partitions {
+					pr := listoffsets.ListOffsetsResponseTopicPartition{PartitionIndex: lp.PartitionIndex, Timestamp: -1, Offset: -1, LeaderEpoch: -1}
+					p, errorCode := b.lookup(t, lp.PartitionIndex, false)
+					if errorCode == errorcodes.None {
+						pr.Offset, pr.Timestamp = p.offsetForTimestamp(lp.Timestamp)
+						pr.LeaderEpoch = p.epoch
+					}
+					pr.ErrorCode = errorCode
+					partitions = append(partitions, pr)
+				}
+			}
+			topics = append(topics, listoffsets.ListOffsetsResponseTopic{Name: lt.Name, Partitions: &partitions})
+		}
+	}
+
+	return &listoffsets.ListOffsetsResponse{ApiVersion: req.ApiVersion, Topics: &topics}, nil
+}
+
+// offsetForTimestamp returns the offset and timestamp of the first record with a timestamp at or after
+// the timestamp, or the earliest or latest offset for the special timestamps.
+func (p *partition) offsetForTimestamp(timestamp int64) (int64, int64) {
+	switch timestamp {
+	case LatestTimestamp:
+		return p.endOffset, -1
+	case EarliestTimestamp:
+		return 0, -1
+	}
+
+	for _, batch := range p.log {
+		for _, r := range batch.Records {
+			if ts := batch.Timestamp(r); ts >= timestamp {
+				return batch.Offset(r), ts
+			}
+		}
+	}
+	return -1, -1
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
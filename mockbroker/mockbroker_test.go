@@ -0,0 +1,131 @@
+package mockbroker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/scholzj/go-kafka-protocol/api/fetch"
+	"github.com/scholzj/go-kafka-protocol/api/listoffsets"
+	"github.com/scholzj/go-kafka-protocol/api/metadata"
+	"github.com/scholzj/go-kafka-protocol/api/produce"
+	"github.com/scholzj/go-kafka-protocol/compression"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+func str(s string) *string {
+	return &s
+}
+
+func produceRequest(version int16, topic string, partition int32, values ...string) *produce.ProduceRequest {
+	batch := records.NewRecordBatch(0, compression.None)
+	for i, value := range values {
+		batch.AppendRecord(int64(1000+i), nil, []byte(value), nil)
+	}
+	data, _ := records.WriteRecordBatches([]*records.RecordBatch{batch})
+	return &produce.ProduceRequest{
+		ApiVersion: version,
+		Acks:       -1,
+		TopicData: &[]produce.ProduceRequestTopicData{{
+			Name:          &topic,
+			PartitionData: &[]produce.ProduceRequestTopicDataPartitionData{{Index: partition, Records: &data}},
+		}},
+	}
+}
+
+func TestCreateTopic(t *testing.T) {
+	c, err := NewCluster(3)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.CreateTopic("orders", 4, 2); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	if _, err := c.CreateTopic("orders", 1, 1); !errors.Is(err, ErrTopicExists) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := c.CreateTopic("payments", 1, 4); err == nil {
+		t.Errorf("expected an error for a replication factor above the number of brokers")
+	}
+
+	b, _ := c.Broker(0)
+	body, err := b.Handle(messages.Metadata, &metadata.MetadataRequest{ApiVersion: 12})
+	if err != nil {
+		t.Fatalf("Metadata: %v", err)
+	}
+	res := body.(*metadata.MetadataResponse)
+	if len(*res.Brokers) != 3 || len(*res.Topics) != 1 {
+		t.Fatalf("unexpected response %+v", res)
+	}
+	for i, p := range *(*res.Topics)[0].Partitions {
+		if p.LeaderId != int32(i%3) || len(*p.ReplicaNodes) != 2 || (*p.ReplicaNodes)[1] != int32((i+1)%3) {
+			t.Errorf("unexpected partition %+v", p)
+		}
+	}
+}
+
+func TestProduceFetch(t *testing.T) {
+	c, err := NewCluster(2)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer c.Close()
+	c.CreateTopic("orders", 2, 2)
+	leader, _ := c.Broker(1)
+	follower, _ := c.Broker(0)
+
+	for i, values := range [][]string{{"a", "b", "c"}, {"d"}} {
+		body, err := leader.Handle(messages.Produce, produceRequest(9, "orders", 1, values...))
+		if err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+		p := (*(*body.(*produce.ProduceResponse).Responses)[0].PartitionResponses)[0]
+		if p.ErrorCode != errorcodes.None || p.BaseOffset != int64(3*i) {
+			t.Errorf("unexpected partition response %+v", p)
+		}
+	}
+	if log := c.Log("orders", 1); len(log) != 2 || log[1].BaseOffset != 3 {
+		t.Errorf("unexpected log %+v", log)
+	}
+
+	// Only the leader accepts records, and points to itself.
+	body, _ := follower.Handle(messages.Produce, produceRequest(12, "orders", 1, "e"))
+	res := body.(*produce.ProduceResponse)
+	p := (*(*res.Responses)[0].PartitionResponses)[0]
+	if p.ErrorCode != errorcodes.NotLeaderOrFollower || p.CurrentLeader.LeaderId != 1 || len(*res.NodeEndpoints) != 1 {
+		t.Errorf("unexpected response %+v", res)
+	}
+
+	body, _ = leader.Handle(messages.Fetch, &fetch.FetchRequest{ApiVersion: 12, Topics: &[]fetch.FetchRequestTopic{{
+		Topic:      str("orders"),
+		Partitions: &[]fetch.FetchRequestTopicPartition{{Partition: 1, FetchOffset: 1, PartitionMaxBytes: 1}},
+	}}})
+	fp := (*(*body.(*fetch.FetchResponse).Responses)[0].Partitions)[0]
+	batches, err := records.ReadRecordBatches(*fp.Records)
+	if err != nil || len(batches) != 1 || batches[0].BaseOffset != 0 || fp.HighWatermark != 4 {
+		t.Errorf("unexpected fetch response %+v: %+v (%v)", fp, batches, err)
+	}
+
+	for _, tc := range []struct {
+		timestamp int64
+		offset    int64
+	}{
+		{EarliestTimestamp, 0},
+		{LatestTimestamp, 4},
+		{1001, 1},
+		{1002, 2},
+		{5000, -1},
+	} {
+		body, _ := leader.Handle(messages.ListOffsets, &listoffsets.ListOffsetsRequest{ApiVersion: 7, Topics: &[]listoffsets.ListOffsetsRequestTopic{{
+			Name:       str("orders"),
+			Partitions: &[]listoffsets.ListOffsetsRequestTopicPartition{{PartitionIndex: 1, Timestamp: tc.timestamp}},
+		}}})
+		lp := (*(*body.(*listoffsets.ListOffsetsResponse).Topics)[0].Partitions)[0]
+		if lp.Offset != tc.offset {
+			t.Errorf("unexpected offset %d for timestamp %d", lp.Offset, tc.timestamp)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package mockcontroller
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/allocateproducerids"
+	"github.com/scholzj/go-kafka-protocol/api/brokerheartbeat"
+	"github.com/scholzj/go-kafka-protocol/api/brokerregistration"
+	"github.com/scholzj/go-kafka-protocol/api/controllerregistration"
+	"github.com/scholzj/go-kafka-protocol/api/unregisterbroker"
+	"github.com/scholzj/go-kafka-protocol/clustermetadata"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+// checkBroker returns the error code for a request from a broker with the epoch.
+func (c *Controller) checkBroker(id int32, epoch int64) (*clustermetadata.Broker, int16) {
+	broker, ok := c.image.Brokers[id]
+	if !ok {
+		return nil, errorcodes.BrokerIdNotRegistered
+	}
+	if broker.Epoch != epoch {
+		return nil, errorcodes.StaleBrokerEpoch
+	}
+	return broker, errorcodes.None
+}
+
+// checkFeatures verifies that a broker or controller supports every finalized feature level.
+func (c *Controller) checkFeatures(supported map[string][2]int16) error {
+	for name, level := range c.image.Features {
+		versions, ok := supported[name]
+		if !ok {
+			versions = [2]int16{0, 0}
+		}
+		if level < versions[0] || level > versions[1] {
+			return fmt.Errorf("feature %s is finalized at level %d, but only levels %d to %d are supported", name, level, versions[0], versions[1])
+		}
+	}
+	return nil
+}
+
+// expireSessions fences the brokers which did not send a heartbeat within the session timeout.
+func (c *Controller) expireSessions() error {
+	now := c.Now()
+	for _, id := range c.brokerIds() {
+		broker := c.image.Brokers[id]
+		if broker.Fenced || now.Sub(c.lastHeartbeat[id]) <= c.SessionTimeout {
+			continue
+		}
+		if err := c.fence(broker); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Controller) brokerIds() []int32 {
+	ids := make([]int32, 0, len(c.image.Brokers))
+	for id := range c.image.Brokers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// fence fences the broker and moves it out of all ISRs and leaderships.
+func (c *Controller) fence(broker *clustermetadata.Broker) error {
+	changes := c.removeFromPartitions(broker.Id)
+	changes = append(changes, &clustermetadata.BrokerRegistrationChangeRecord{BrokerId: broker.Id, BrokerEpoch: broker.Epoch, Fenced: clustermetadata.FencingChangeFence})
+	_, err := c.append(changes...)
+	return err
+}
+
+func (c *Controller) brokerRegistration(req *brokerregistration.BrokerRegistrationRequest) (*brokerregistration.BrokerRegistrationResponse, error) {
+	response := &brokerregistration.BrokerRegistrationResponse{ApiVersion: req.ApiVersion, BrokerEpoch: -1}
+
+	if req.ClusterId == nil || *req.ClusterId != c.ClusterId {
+		response.ErrorCode = errorcodes.InconsistentClusterId
+		return response, nil
+	}
+
+	// A different process may only take over the broker id once the old one lost its session.
+	if existing, ok := c.image.Brokers[req.BrokerId]; ok && existing.IncarnationId != req.IncarnationId && !existing.Fenced {
+		response.ErrorCode = errorcodes.DuplicateBrokerRegistration
+		return response, nil
+	}
+
+	supported := make(map[string][2]int16)
+	var features []clustermetadata.Feature
+	if req.Features != nil {
+		for _, f := range *req.Features {
+			supported[deref(f.Name)] = [2]int16{f.MinSupportedVersion, f.MaxSupportedVersion}
+			features = append(features, clustermetadata.Feature{Name: deref(f.Name), MinSupportedVersion: f.MinSupportedVersion, MaxSupportedVersion: f.MaxSupportedVersion})
+		}
+	}
+	if err := c.checkFeatures(supported); err != nil {
+		response.ErrorCode = errorcodes.UnsupportedVersion
+		return response, nil
+	}
+
+	var endpoints []clustermetadata.Endpoint
+	if req.Listeners != nil {
+		for _, l := range *req.Listeners {
+			endpoints = append(endpoints, clustermetadata.Endpoint{Name: deref(l.Name), Host: deref(l.Host), Port: l.Port, SecurityProtocol: l.SecurityProtocol})
+		}
+	}
+	var logDirs []uuid.UUID
+	if req.LogDirs != nil {
+		logDirs = *req.LogDirs
+	}
+
+	// The broker epoch is the offset of the registration record.
+	epoch, err := c.append(&clustermetadata.RegisterBrokerRecord{
+		BrokerId:            req.BrokerId,
+		IsMigratingZkBroker: req.IsMigratingZkBroker,
+		IncarnationId:       req.IncarnationId,
+		BrokerEpoch:         c.image.NextOffset(),
+		EndPoints:           endpoints,
+		Features:            features,
+		Rack:                req.Rack,
+		Fenced:              true,
+		LogDirs:             logDirs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.lastHeartbeat[req.BrokerId] = c.Now()
+	response.BrokerEpoch = epoch
+	return response, nil
+}
+
+// brokerHeartbeat keeps the session of the broker alive and moves it through its states: a new
+// registration is fenced until the broker caught up with the metadata log up to its registration,
+// and a broker which wants to shut down enters controlled shutdown until it leads no partitions.
+func (c *Controller) brokerHeartbeat(req *brokerheartbeat.BrokerHeartbeatRequest) (*brokerheartbeat.BrokerHeartbeatResponse, error) {
+	response := &brokerheartbeat.BrokerHeartbeatResponse{ApiVersion: req.ApiVersion, IsFenced: true}
+
+	broker, errorCode := c.checkBroker(req.BrokerId, req.BrokerEpoch)
+	if errorCode != errorcodes.None {
+		response.ErrorCode = errorCode
+		return response, nil
+	}
+	c.lastHeartbeat[broker.Id] = c.Now()
+
+	if req.OfflineLogDirs != nil && len(*req.OfflineLogDirs) > 0 {
+		offline := make(map[uuid.UUID]bool)
+		for _, dir := range *req.OfflineLogDirs {
+			offline[dir] = true
+		}
+		online := make([]uuid.UUID, 0, len(broker.LogDirs))
+		for _, dir := range broker.LogDirs {
+			if !offline[dir] {
+				online = append(online, dir)
+			}
+		}
+		if len(online) != len(broker.LogDirs) {
+			if _, err := c.append(&clustermetadata.BrokerRegistrationChangeRecord{BrokerId: broker.Id, BrokerEpoch: broker.Epoch, LogDirs: online}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	caughtUp := req.CurrentMetadataOffset >= broker.Epoch
+	response.IsCaughtUp = caughtUp
+
+	switch {
+	case req.WantShutDown && broker.Fenced:
+		response.ShouldShutDown = true
+	case req.WantShutDown:
+		if !broker.InControlledShutdown {
+			changes := c.removeFromPartitions(broker.Id)
+			changes = append(changes, &clustermetadata.BrokerRegistrationChangeRecord{BrokerId: broker.Id, BrokerEpoch: broker.Epoch, InControlledShutdown: clustermetadata.ControlledShutdownChangeEnter})
+			if _, err := c.append(changes...); err != nil {
+				return nil, err
+			}
+		}
+		if !c.leadsPartitions(broker.Id) {
+			if err := c.fence(broker); err != nil {
+				return nil, err
+			}
+			response.ShouldShutDown = true
+		}
+	case req.WantFence && !broker.Fenced:
+		if err := c.fence(broker); err != nil {
+			return nil, err
+		}
+	case !req.WantFence && broker.Fenced && caughtUp && !broker.InControlledShutdown:
+		// Partitions which lost their leader while the broker was fenced can be led by it again.
+		changes := []clustermetadata.Message{&clustermetadata.BrokerRegistrationChangeRecord{BrokerId: broker.Id, BrokerEpoch: broker.Epoch, Fenced: clustermetadata.FencingChangeUnfence}}
+		changes = append(changes, c.electLeaderless(broker.Id)...)
+		if _, err := c.append(changes...); err != nil {
+			return nil, err
+		}
+	}
+
+	response.IsFenced = broker.Fenced
+	return response, nil
+}
+
+func (c *Controller) unregisterBroker(req *unregisterbroker.UnregisterBrokerRequest) (*unregisterbroker.UnregisterBrokerResponse, error) {
+	response := &unregisterbroker.UnregisterBrokerResponse{ApiVersion: req.ApiVersion}
+
+	broker, ok := c.image.Brokers[req.BrokerId]
+	if !ok {
+		response.ErrorCode = errorcodes.BrokerIdNotRegistered
+		return response, nil
+	}
+
+	changes := c.removeFromPartitions(broker.Id)
+	changes = append(changes, &clustermetadata.UnregisterBrokerRecord{BrokerId: broker.Id, BrokerEpoch: broker.Epoch})
+	if _, err := c.append(changes...); err != nil {
+		return nil, err
+	}
+	delete(c.lastHeartbeat, broker.Id)
+	return response, nil
+}
+
+func (c *Controller) controllerRegistration(req *controllerregistration.ControllerRegistrationRequest) (*controllerregistration.ControllerRegistrationResponse, error) {
+	response := &controllerregistration.ControllerRegistrationResponse{ApiVersion: req.ApiVersion}
+
+	supported := make(map[string][2]int16)
+	var features []clustermetadata.Feature
+	if req.Features != nil {
+		for _, f := range *req.Features {
+			supported[deref(f.Name)] = [2]int16{f.MinSupportedVersion, f.MaxSupportedVersion}
+			features = append(features, clustermetadata.Feature{Name: deref(f.Name), MinSupportedVersion: f.MinSupportedVersion, MaxSupportedVersion: f.MaxSupportedVersion})
+		}
+	}
+	if err := c.checkFeatures(supported); err != nil {
+		message := err.Error()
+		response.ErrorCode = errorcodes.UnsupportedVersion
+		response.ErrorMessage = &message
+		return response, nil
+	}
+
+	var endpoints []clustermetadata.Endpoint
+	if req.Listeners != nil {
+		for _, l := range *req.Listeners {
+			endpoints = append(endpoints, clustermetadata.Endpoint{Name: deref(l.Name), Host: deref(l.Host), Port: l.Port, SecurityProtocol: l.SecurityProtocol})
+		}
+	}
+
+	_, err := c.append(&clustermetadata.RegisterControllerRecord{
+		ControllerId:     req.ControllerId,
+		IncarnationId:    req.IncarnationId,
+		ZkMigrationReady: req.ZkMigrationReady,
+		EndPoints:        endpoints,
+		Features:         features,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// allocateProducerIds hands out the next block of producer ids. The block is recorded before it is
+// returned, so a block is never handed out twice.
+func (c *Controller) allocateProducerIds(req *allocateproducerids.AllocateProducerIdsRequest) (*allocateproducerids.AllocateProducerIdsResponse, error) {
+	response := &allocateproducerids.AllocateProducerIdsResponse{ApiVersion: req.ApiVersion}
+
+	if _, errorCode := c.checkBroker(req.BrokerId, req.BrokerEpoch); errorCode != errorcodes.None {
+		response.ErrorCode = errorCode
+		return response, nil
+	}
+
+	start := c.image.NextProducerId
+	_, err := c.append(&clustermetadata.ProducerIdsRecord{BrokerId: req.BrokerId, BrokerEpoch: req.BrokerEpoch, NextProducerId: start + int64(c.ProducerIdBlockSize)})
+	if err != nil {
+		return nil, err
+	}
+
+	response.ProducerIdStart = start
+	response.ProducerIdLen = c.ProducerIdBlockSize
+	return response, nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,199 @@
+package mockcontroller
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/scholzj/go-kafka-protocol/api/allocateproducerids"
+	"github.com/scholzj/go-kafka-protocol/api/alterpartition"
+	"github.com/scholzj/go-kafka-protocol/api/assignreplicastodirs"
+	"github.com/scholzj/go-kafka-protocol/api/brokerheartbeat"
+	"github.com/scholzj/go-kafka-protocol/api/brokerregistration"
+	"github.com/scholzj/go-kafka-protocol/api/controllerregistration"
+	"github.com/scholzj/go-kafka-protocol/api/unregisterbroker"
+	"github.com/scholzj/go-kafka-protocol/clustermetadata"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// A stand-in for the active KRaft controller, for testing broker-side components. Every change is
+// written as metadata records to an in-memory metadata log and applied to a clustermetadata.Image,
+// the same way the real controller does it, so that broker epochs are record offsets and the log can
+// be served to brokers or replayed.
+
+// DefaultSessionTimeout matches the default of broker.session.timeout.ms.
+const DefaultSessionTimeout = 9 * time.Second
+
+// DefaultProducerIdBlockSize matches the size of the producer id blocks handed out by Kafka.
+const DefaultProducerIdBlockSize int32 = 1000
+
+// DefaultClusterId is the cluster id of a new controller.
+const DefaultClusterId = "mockcontroller-cluster0"
+
+var ErrUnsupportedRequest = errors.New("the mock controller does not handle this request")
+
+// Controller is the mock controller. It is safe for concurrent use.
+type Controller struct {
+	// Now is the clock used for broker sessions and record timestamps. It defaults to time.Now and can
+	// be replaced in tests.
+	Now func() time.Time
+
+	ClusterId           string
+	SessionTimeout      time.Duration
+	ProducerIdBlockSize int32
+	Epoch               int32 // The leader epoch of the metadata log batches.
+
+	mu            sync.Mutex
+	image         *clustermetadata.Image
+	log           []*records.RecordBatch
+	lastHeartbeat map[int32]time.Time
+}
+
+func NewController() *Controller {
+	return &Controller{
+		Now:                 time.Now,
+		ClusterId:           DefaultClusterId,
+		SessionTimeout:      DefaultSessionTimeout,
+		ProducerIdBlockSize: DefaultProducerIdBlockSize,
+		Epoch:               1,
+		image:               clustermetadata.NewImage(),
+		lastHeartbeat:       make(map[int32]time.Time),
+	}
+}
+
+// View calls fn with the metadata image while holding the lock of the controller. The image must not
+// be changed or retained.
+func (c *Controller) View(fn func(image *clustermetadata.Image)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fn(c.image)
+}
+
+// Log returns the batches of the metadata log written so far.
+func (c *Controller) Log() []*records.RecordBatch {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]*records.RecordBatch(nil), c.log...)
+}
+
+// LogEndOffset returns the offset of the next metadata record.
+func (c *Controller) LogEndOffset() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.image.NextOffset()
+}
+
+// SetFeature finalizes a feature level, for example metadata.version. Brokers and controllers which
+// do not support the level cannot register afterwards.
+func (c *Controller) SetFeature(name string, level int16) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := c.append(&clustermetadata.FeatureLevelRecord{Name: name, FeatureLevel: level})
+	return err
+}
+
+// append writes the messages as one batch to the metadata log and applies them to the image. It
+// returns the offset of the first record.
+func (c *Controller) append(messages ...clustermetadata.Message) (int64, error) {
+	if len(messages) == 0 {
+		return c.image.NextOffset(), nil
+	}
+
+	metadataRecords := make([]clustermetadata.Record, 0, len(messages))
+	for _, m := range messages {
+		metadataRecords = append(metadataRecords, clustermetadata.Record{Version: m.Type().HighestSupportedVersion(), Message: m})
+	}
+
+	baseOffset := c.image.NextOffset()
+	batch, err := clustermetadata.NewBatch(baseOffset, c.Epoch, c.Now().UnixMilli(), metadataRecords)
+	if err != nil {
+		return -1, err
+	}
+	if err := c.image.ApplyBatch(batch); err != nil {
+		return -1, fmt.Errorf("failed to apply the metadata records: %w", err)
+	}
+
+	c.log = append(c.log, batch)
+	return baseOffset, nil
+}
+
+////////////////////
+// Request handling
+////////////////////
+
+// Handle handles a request body and returns the response body with the same API version.
+func (c *Controller) Handle(body protocol.RequestBody) (protocol.ResponseBody, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.expireSessions(); err != nil {
+		return nil, err
+	}
+
+	switch req := body.(type) {
+	case *brokerregistration.BrokerRegistrationRequest:
+		return c.brokerRegistration(req)
+	case *brokerheartbeat.BrokerHeartbeatRequest:
+		return c.brokerHeartbeat(req)
+	case *unregisterbroker.UnregisterBrokerRequest:
+		return c.unregisterBroker(req)
+	case *controllerregistration.ControllerRegistrationRequest:
+		return c.controllerRegistration(req)
+	case *allocateproducerids.AllocateProducerIdsRequest:
+		return c.allocateProducerIds(req)
+	case *alterpartition.AlterPartitionRequest:
+		return c.alterPartition(req)
+	case *assignreplicastodirs.AssignReplicasToDirsRequest:
+		return c.assignReplicasToDirs(req)
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedRequest, body)
+	}
+}
+
+// Serve reads requests from the connection and writes the responses until the connection fails or
+// is closed. It returns nil when the connection is closed between two requests.
+func (c *Controller) Serve(conn io.ReadWriter) error {
+	for {
+		request, err := protocol.ReadRequest(conn)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		body, ok := messages.NewRequestBody(request.ApiKey)
+		if !ok {
+			return fmt.Errorf("unknown API key %d", request.ApiKey)
+		}
+		if err := body.Read(&request); err != nil {
+			return fmt.Errorf("failed to decode the %s request: %w", messages.Name(request.ApiKey), err)
+		}
+
+		responseBody, err := c.Handle(body)
+		if err != nil {
+			return err
+		}
+
+		buf := bytes.NewBuffer(make([]byte, 0))
+		if err := responseBody.Write(buf); err != nil {
+			return fmt.Errorf("failed to encode the %s response: %w", messages.Name(request.ApiKey), err)
+		}
+		response := protocol.Response{
+			ResponseHeader: protocol.ResponseHeader{ApiKey: request.ApiKey, ApiVersion: request.ApiVersion, CorrelationId: request.CorrelationId},
+			Body:           buf,
+		}
+		if err := response.Write(conn); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,524 @@
+package mockcontroller
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/allocateproducerids"
+	"github.com/scholzj/go-kafka-protocol/api/alterpartition"
+	"github.com/scholzj/go-kafka-protocol/api/assignreplicastodirs"
+	"github.com/scholzj/go-kafka-protocol/api/brokerheartbeat"
+	"github.com/scholzj/go-kafka-protocol/api/brokerregistration"
+	"github.com/scholzj/go-kafka-protocol/api/controllerregistration"
+	"github.com/scholzj/go-kafka-protocol/api/metadata"
+	"github.com/scholzj/go-kafka-protocol/api/unregisterbroker"
+	"github.com/scholzj/go-kafka-protocol/clustermetadata"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+type testController struct {
+	*Controller
+	t   *testing.T
+	now time.Time
+}
+
+func newTestController(t *testing.T) *testController {
+	tc := &testController{Controller: NewController(), t: t, now: time.Unix(1700000000, 0)}
+	tc.Now = func() time.Time { return tc.now }
+	return tc
+}
+
+func (tc *testController) handle(body protocol.RequestBody) protocol.ResponseBody {
+	tc.t.Helper()
+
+	response, err := tc.Handle(body)
+	if err != nil {
+		tc.t.Fatalf("Handle(%T): %v", body, err)
+	}
+	return response
+}
+
+func (tc *testController) register(id int32, incarnation uuid.UUID) *brokerregistration.BrokerRegistrationResponse {
+	tc.t.Helper()
+
+	clusterId := tc.ClusterId
+	name, host := "BROKER", "localhost"
+	return tc.handle(&brokerregistration.BrokerRegistrationRequest{
+		ApiVersion:    4,
+		BrokerId:      id,
+		ClusterId:     &clusterId,
+		IncarnationId: incarnation,
+		Listeners:     &[]brokerregistration.BrokerRegistrationRequestListener{{Name: &name, Host: &host, Port: uint16(9092 + id)}},
+		Features:      &[]brokerregistration.BrokerRegistrationRequestFeature{},
+		LogDirs:       &[]uuid.UUID{{byte(id)}},
+	}).(*brokerregistration.BrokerRegistrationResponse)
+}
+
+func (tc *testController) heartbeat(id int32, epoch int64, wantFence bool, wantShutDown bool) *brokerheartbeat.BrokerHeartbeatResponse {
+	tc.t.Helper()
+
+	return tc.handle(&brokerheartbeat.BrokerHeartbeatRequest{
+		ApiVersion:            1,
+		BrokerId:              id,
+		BrokerEpoch:           epoch,
+		CurrentMetadataOffset: tc.LogEndOffset(),
+		WantFence:             wantFence,
+		WantShutDown:          wantShutDown,
+	}).(*brokerheartbeat.BrokerHeartbeatResponse)
+}
+
+// startBrokers registers and unfences the brokers and returns their epochs.
+func (tc *testController) startBrokers(ids ...int32) map[int32]int64 {
+	tc.t.Helper()
+
+	epochs := make(map[int32]int64)
+	for _, id := range ids {
+		response := tc.register(id, uuid.New())
+		if response.ErrorCode != errorcodes.None {
+			tc.t.Fatalf("registration of broker %d failed with %s", id, errorcodes.Name(response.ErrorCode))
+		}
+		epochs[id] = response.BrokerEpoch
+		if hb := tc.heartbeat(id, response.BrokerEpoch, false, false); hb.IsFenced {
+			tc.t.Fatalf("broker %d is still fenced", id)
+		}
+	}
+	return epochs
+}
+
+func (tc *testController) partition(topicId uuid.UUID, index int32) clustermetadata.Partition {
+	var partition clustermetadata.Partition
+	tc.View(func(image *clustermetadata.Image) {
+		partition = *image.Topics[topicId].Partitions[index]
+	})
+	return partition
+}
+
+func (tc *testController) broker(id int32) *clustermetadata.Broker {
+	var broker *clustermetadata.Broker
+	tc.View(func(image *clustermetadata.Image) {
+		if b, ok := image.Brokers[id]; ok {
+			copied := *b
+			broker = &copied
+		}
+	})
+	return broker
+}
+
+func TestBrokerRegistration(t *testing.T) {
+	tc := newTestController(t)
+
+	incarnation := uuid.New()
+	first := tc.register(1, incarnation)
+	if first.ErrorCode != errorcodes.None || first.BrokerEpoch != 0 {
+		t.Fatalf("unexpected response %+v", first)
+	}
+	second := tc.register(2, uuid.New())
+	if second.ErrorCode != errorcodes.None || second.BrokerEpoch != 1 {
+		t.Fatalf("unexpected response %+v", second)
+	}
+	if b := tc.broker(1); b == nil || !b.Fenced || b.Epoch != 0 || len(b.EndPoints) != 1 || b.EndPoints[0].Port != 9093 {
+		t.Fatalf("unexpected broker %+v", b)
+	}
+
+	// The same process can register again, for example after a lost response.
+	if again := tc.register(1, incarnation); again.ErrorCode != errorcodes.None || again.BrokerEpoch != 2 {
+		t.Errorf("unexpected response %+v", again)
+	}
+
+	// Another process cannot take over an active broker id.
+	tc.heartbeat(2, second.BrokerEpoch, false, false)
+	if duplicate := tc.register(2, uuid.New()); duplicate.ErrorCode != errorcodes.DuplicateBrokerRegistration {
+		t.Errorf("unexpected error %s", errorcodes.Name(duplicate.ErrorCode))
+	}
+
+	clusterId := "other"
+	wrongCluster := tc.handle(&brokerregistration.BrokerRegistrationRequest{ApiVersion: 4, BrokerId: 3, ClusterId: &clusterId}).(*brokerregistration.BrokerRegistrationResponse)
+	if wrongCluster.ErrorCode != errorcodes.InconsistentClusterId || wrongCluster.BrokerEpoch != -1 {
+		t.Errorf("unexpected response %+v", wrongCluster)
+	}
+
+	if err := tc.SetFeature("metadata.version", 20); err != nil {
+		t.Fatalf("SetFeature: %v", err)
+	}
+	if unsupported := tc.register(3, uuid.New()); unsupported.ErrorCode != errorcodes.UnsupportedVersion {
+		t.Errorf("unexpected error %s", errorcodes.Name(unsupported.ErrorCode))
+	}
+}
+
+func TestControllerRegistration(t *testing.T) {
+	tc := newTestController(t)
+	if err := tc.SetFeature("metadata.version", 20); err != nil {
+		t.Fatalf("SetFeature: %v", err)
+	}
+
+	name := "metadata.version"
+	request := &controllerregistration.ControllerRegistrationRequest{
+		ControllerId:  3000,
+		IncarnationId: uuid.New(),
+		Listeners:     &[]controllerregistration.ControllerRegistrationRequestListener{},
+		Features:      &[]controllerregistration.ControllerRegistrationRequestFeature{{Name: &name, MinSupportedVersion: 1, MaxSupportedVersion: 19}},
+	}
+	if response := tc.handle(request).(*controllerregistration.ControllerRegistrationResponse); response.ErrorCode != errorcodes.UnsupportedVersion || response.ErrorMessage == nil {
+		t.Errorf("unexpected response %+v", response)
+	}
+
+	(*request.Features)[0].MaxSupportedVersion = 21
+	if response := tc.handle(request).(*controllerregistration.ControllerRegistrationResponse); response.ErrorCode != errorcodes.None {
+		t.Fatalf("unexpected error %s", errorcodes.Name(response.ErrorCode))
+	}
+	tc.View(func(image *clustermetadata.Image) {
+		if _, ok := image.Controllers[3000]; !ok {
+			t.Error("the controller was not registered")
+		}
+	})
+}
+
+func TestBrokerHeartbeat(t *testing.T) {
+	tc := newTestController(t)
+	epoch := tc.register(1, uuid.New()).BrokerEpoch
+
+	behind := tc.handle(&brokerheartbeat.BrokerHeartbeatRequest{BrokerId: 1, BrokerEpoch: epoch, CurrentMetadataOffset: -1}).(*brokerheartbeat.BrokerHeartbeatResponse)
+	if behind.IsCaughtUp || !behind.IsFenced {
+		t.Errorf("unexpected response %+v", behind)
+	}
+	if caughtUp := tc.heartbeat(1, epoch, false, false); !caughtUp.IsCaughtUp || caughtUp.IsFenced {
+		t.Errorf("unexpected response %+v", caughtUp)
+	}
+	if fenced := tc.heartbeat(1, epoch, true, false); !fenced.IsFenced {
+		t.Errorf("unexpected response %+v", fenced)
+	}
+
+	if stale := tc.heartbeat(1, epoch+1, false, false); stale.ErrorCode != errorcodes.StaleBrokerEpoch {
+		t.Errorf("unexpected error %s", errorcodes.Name(stale.ErrorCode))
+	}
+	if unknown := tc.heartbeat(2, 0, false, false); unknown.ErrorCode != errorcodes.BrokerIdNotRegistered {
+		t.Errorf("unexpected error %s", errorcodes.Name(unknown.ErrorCode))
+	}
+
+	offline := tc.handle(&brokerheartbeat.BrokerHeartbeatRequest{ApiVersion: 1, BrokerId: 1, BrokerEpoch: epoch, CurrentMetadataOffset: tc.LogEndOffset(), OfflineLogDirs: &[]uuid.UUID{{1}}}).(*brokerheartbeat.BrokerHeartbeatResponse)
+	if offline.ErrorCode != errorcodes.None {
+		t.Fatalf("unexpected error %s", errorcodes.Name(offline.ErrorCode))
+	}
+	if b := tc.broker(1); len(b.LogDirs) != 0 {
+		t.Errorf("the offline log directory was not removed: %v", b.LogDirs)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	tc := newTestController(t)
+	epochs := tc.startBrokers(1, 2, 3)
+	topicId, err := tc.CreateTopic("orders", [][]int32{{1, 2, 3}, {3}})
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	tc.now = tc.now.Add(5 * time.Second)
+	tc.heartbeat(2, epochs[2], false, false)
+	tc.heartbeat(3, epochs[3], false, false)
+	tc.now = tc.now.Add(5 * time.Second)
+	tc.heartbeat(2, epochs[2], false, false)
+
+	if b := tc.broker(1); !b.Fenced {
+		t.Fatal("broker 1 did not lose its session")
+	}
+	if b := tc.broker(3); b.Fenced {
+		t.Fatal("broker 3 lost its session")
+	}
+	if p := tc.partition(topicId, 0); p.Leader != 2 || p.LeaderEpoch != 1 || !reflect.DeepEqual(p.Isr, []int32{2, 3}) {
+		t.Errorf("unexpected partition %+v", p)
+	}
+
+	// The only replica keeps its place in the ISR, and leads again once it is back.
+	tc.now = tc.now.Add(5 * time.Second)
+	tc.heartbeat(2, epochs[2], false, false)
+	if p := tc.partition(topicId, 1); p.Leader != clustermetadata.NoLeader || !reflect.DeepEqual(p.Isr, []int32{3}) {
+		t.Fatalf("unexpected partition %+v", p)
+	}
+	if response := tc.heartbeat(3, epochs[3], false, false); response.IsFenced {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	if p := tc.partition(topicId, 1); p.Leader != 3 || p.LeaderEpoch != 2 {
+		t.Errorf("unexpected partition %+v", p)
+	}
+}
+
+func TestControlledShutdown(t *testing.T) {
+	tc := newTestController(t)
+	epochs := tc.startBrokers(1, 2)
+	topicId, err := tc.CreateTopic("orders", [][]int32{{1, 2}, {2, 1}})
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	response := tc.heartbeat(1, epochs[1], false, true)
+	if !response.ShouldShutDown || !response.IsFenced {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	if b := tc.broker(1); !b.InControlledShutdown {
+		t.Error("broker 1 is not in controlled shutdown")
+	}
+	for index := int32(0); index < 2; index++ {
+		if p := tc.partition(topicId, index); p.Leader != 2 || !reflect.DeepEqual(p.Isr, []int32{2}) {
+			t.Errorf("unexpected partition %+v", p)
+		}
+	}
+
+	// A restarted broker registers with a new epoch and leaves the controlled shutdown.
+	epoch := tc.register(1, uuid.New()).BrokerEpoch
+	if response := tc.heartbeat(1, epoch, false, false); response.IsFenced {
+		t.Errorf("unexpected response %+v", response)
+	}
+	if b := tc.broker(1); b.InControlledShutdown {
+		t.Error("broker 1 is still in controlled shutdown")
+	}
+}
+
+func TestUnregisterBroker(t *testing.T) {
+	tc := newTestController(t)
+	tc.startBrokers(1, 2)
+	topicId, err := tc.CreateTopic("orders", [][]int32{{1, 2}})
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	if response := tc.handle(&unregisterbroker.UnregisterBrokerRequest{BrokerId: 1}).(*unregisterbroker.UnregisterBrokerResponse); response.ErrorCode != errorcodes.None {
+		t.Fatalf("unexpected error %s", errorcodes.Name(response.ErrorCode))
+	}
+	if tc.broker(1) != nil {
+		t.Error("broker 1 is still registered")
+	}
+	if p := tc.partition(topicId, 0); p.Leader != 2 || !reflect.DeepEqual(p.Isr, []int32{2}) {
+		t.Errorf("unexpected partition %+v", p)
+	}
+	if response := tc.handle(&unregisterbroker.UnregisterBrokerRequest{BrokerId: 1}).(*unregisterbroker.UnregisterBrokerResponse); response.ErrorCode != errorcodes.BrokerIdNotRegistered {
+		t.Errorf("unexpected error %s", errorcodes.Name(response.ErrorCode))
+	}
+}
+
+func TestAllocateProducerIds(t *testing.T) {
+	tc := newTestController(t)
+	epochs := tc.startBrokers(1, 2)
+
+	for i, id := range []int32{1, 2, 1} {
+		response := tc.handle(&allocateproducerids.AllocateProducerIdsRequest{BrokerId: id, BrokerEpoch: epochs[id]}).(*allocateproducerids.AllocateProducerIdsResponse)
+		if response.ErrorCode != errorcodes.None || response.ProducerIdStart != int64(i)*1000 || response.ProducerIdLen != DefaultProducerIdBlockSize {
+			t.Errorf("unexpected response %+v", response)
+		}
+	}
+
+	if response := tc.handle(&allocateproducerids.AllocateProducerIdsRequest{BrokerId: 1, BrokerEpoch: epochs[1] + 1}).(*allocateproducerids.AllocateProducerIdsResponse); response.ErrorCode != errorcodes.StaleBrokerEpoch {
+		t.Errorf("unexpected error %s", errorcodes.Name(response.ErrorCode))
+	}
+}
+
+func alterPartitionRequest(version int16, brokerId int32, brokerEpoch int64, topicId uuid.UUID, leaderEpoch int32, partitionEpoch int32, isr []int32, epochs map[int32]int64) *alterpartition.AlterPartitionRequest {
+	partition := alterpartition.AlterPartitionRequestTopicPartition{LeaderEpoch: leaderEpoch, PartitionEpoch: partitionEpoch}
+	if version >= 3 {
+		members := []alterpartition.AlterPartitionRequestTopicPartitionNewIsrWithEpoch{}
+		for _, id := range isr {
+			members = append(members, alterpartition.AlterPartitionRequestTopicPartitionNewIsrWithEpoch{BrokerId: id, BrokerEpoch: epochs[id]})
+		}
+		partition.NewIsrWithEpochs = &members
+	} else {
+		partition.NewIsr = &isr
+	}
+	return &alterpartition.AlterPartitionRequest{
+		ApiVersion:  version,
+		BrokerId:    brokerId,
+		BrokerEpoch: brokerEpoch,
+		Topics:      &[]alterpartition.AlterPartitionRequestTopic{{TopicId: topicId, Partitions: &[]alterpartition.AlterPartitionRequestTopicPartition{partition}}},
+	}
+}
+
+func TestAlterPartition(t *testing.T) {
+	tc := newTestController(t)
+	epochs := tc.startBrokers(1, 2, 3)
+	topicId, err := tc.CreateTopic("orders", [][]int32{{1, 2, 3}})
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	shrink := tc.handle(alterPartitionRequest(3, 1, epochs[1], topicId, 0, 0, []int32{1, 2}, epochs)).(*alterpartition.AlterPartitionResponse)
+	result := (*(*shrink.Topics)[0].Partitions)[0]
+	if result.ErrorCode != errorcodes.None || result.LeaderId != 1 || result.PartitionEpoch != 1 || !reflect.DeepEqual(*result.Isr, []int32{1, 2}) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	tc.heartbeat(3, epochs[3], true, false)
+
+	tests := []struct {
+		name    string
+		request *alterpartition.AlterPartitionRequest
+		code    int16
+	}{
+		{"stale broker epoch", alterPartitionRequest(3, 1, epochs[1]+1, topicId, 0, 1, []int32{1, 2}, epochs), errorcodes.StaleBrokerEpoch},
+		{"unknown topic", alterPartitionRequest(3, 1, epochs[1], uuid.New(), 0, 1, []int32{1, 2}, epochs), errorcodes.UnknownTopicId},
+		{"stale leader epoch", alterPartitionRequest(3, 1, epochs[1], topicId, -1, 1, []int32{1, 2}, epochs), errorcodes.FencedLeaderEpoch},
+		{"future leader epoch", alterPartitionRequest(3, 1, epochs[1], topicId, 1, 1, []int32{1, 2}, epochs), errorcodes.NotController},
+		{"not the leader", alterPartitionRequest(3, 2, epochs[2], topicId, 0, 1, []int32{1, 2}, epochs), errorcodes.InvalidRequest},
+		{"stale partition epoch", alterPartitionRequest(3, 1, epochs[1], topicId, 0, 0, []int32{1}, epochs), errorcodes.InvalidUpdateVersion},
+		{"without the leader", alterPartitionRequest(3, 1, epochs[1], topicId, 0, 1, []int32{2}, epochs), errorcodes.InvalidRequest},
+		{"fenced replica", alterPartitionRequest(3, 1, epochs[1], topicId, 0, 1, []int32{1, 2, 3}, epochs), errorcodes.IneligibleReplica},
+		{"not a replica", alterPartitionRequest(2, 1, epochs[1], topicId, 0, 1, []int32{1, 4}, epochs), errorcodes.IneligibleReplica},
+		{"stale replica epoch", alterPartitionRequest(3, 1, epochs[1], topicId, 0, 1, []int32{1, 2}, map[int32]int64{1: epochs[1], 2: epochs[2] + 1}), errorcodes.IneligibleReplica},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := tc.handle(test.request).(*alterpartition.AlterPartitionResponse)
+			code := response.ErrorCode
+			if code == errorcodes.None {
+				code = (*(*response.Topics)[0].Partitions)[0].ErrorCode
+			}
+			if code != test.code {
+				t.Errorf("expected %s, got %s", errorcodes.Name(test.code), errorcodes.Name(code))
+			}
+		})
+	}
+
+	if p := tc.partition(topicId, 0); p.PartitionEpoch != 1 || !reflect.DeepEqual(p.Isr, []int32{1, 2}) {
+		t.Errorf("a rejected request changed the partition %+v", p)
+	}
+
+	// Version 2 has no broker epochs in the ISR.
+	tc.heartbeat(3, epochs[3], false, false)
+	expand := tc.handle(alterPartitionRequest(2, 1, epochs[1], topicId, 0, 1, []int32{1, 2, 3}, nil)).(*alterpartition.AlterPartitionResponse)
+	if result := (*(*expand.Topics)[0].Partitions)[0]; result.ErrorCode != errorcodes.None || result.PartitionEpoch != 2 {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestAssignReplicasToDirs(t *testing.T) {
+	tc := newTestController(t)
+	epochs := tc.startBrokers(1, 2, 3)
+	topicId, err := tc.CreateTopic("orders", [][]int32{{1, 2}, {2, 3}})
+	if err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+
+	dir := uuid.New()
+	partitions := []assignreplicastodirs.AssignReplicasToDirsRequestDirectorieTopicPartition{{PartitionIndex: 0}, {PartitionIndex: 1}, {PartitionIndex: 2}}
+	request := &assignreplicastodirs.AssignReplicasToDirsRequest{
+		BrokerId:    2,
+		BrokerEpoch: epochs[2],
+		Directories: &[]assignreplicastodirs.AssignReplicasToDirsRequestDirectorie{{
+			Id:     dir,
+			Topics: &[]assignreplicastodirs.AssignReplicasToDirsRequestDirectorieTopic{{TopicId: topicId, Partitions: &partitions}},
+		}},
+	}
+	response := tc.handle(request).(*assignreplicastodirs.AssignReplicasToDirsResponse)
+	results := *(*(*response.Directories)[0].Topics)[0].Partitions
+	for i, code := range []int16{errorcodes.None, errorcodes.None, errorcodes.UnknownTopicOrPartition} {
+		if results[i].ErrorCode != code {
+			t.Errorf("partition %d: expected %s, got %s", i, errorcodes.Name(code), errorcodes.Name(results[i].ErrorCode))
+		}
+	}
+	if p := tc.partition(topicId, 0); !reflect.DeepEqual(p.Directories, []uuid.UUID{uuid.Nil, dir}) {
+		t.Errorf("unexpected directories %v", p.Directories)
+	}
+	if p := tc.partition(topicId, 1); !reflect.DeepEqual(p.Directories, []uuid.UUID{dir, uuid.Nil}) {
+		t.Errorf("unexpected directories %v", p.Directories)
+	}
+
+	request.BrokerId, request.BrokerEpoch = 1, epochs[1]
+	response = tc.handle(request).(*assignreplicastodirs.AssignReplicasToDirsResponse)
+	if code := (*(*(*response.Directories)[0].Topics)[0].Partitions)[1].ErrorCode; code != errorcodes.NotLeaderOrFollower {
+		t.Errorf("unexpected error %s", errorcodes.Name(code))
+	}
+}
+
+func TestUnsupportedRequest(t *testing.T) {
+	tc := newTestController(t)
+	if _, err := tc.Handle(&metadata.MetadataRequest{}); !errors.Is(err, ErrUnsupportedRequest) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	tc := newTestController(t)
+	client, server := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- tc.Serve(server) }()
+
+	clusterId := tc.ClusterId
+	requests := []protocol.RequestBody{
+		&brokerregistration.BrokerRegistrationRequest{ApiVersion: 4, BrokerId: 1, ClusterId: &clusterId, IncarnationId: uuid.New(), Listeners: &[]brokerregistration.BrokerRegistrationRequestListener{}, Features: &[]brokerregistration.BrokerRegistrationRequestFeature{}, LogDirs: &[]uuid.UUID{}},
+		&brokerheartbeat.BrokerHeartbeatRequest{ApiVersion: 1, BrokerId: 1, BrokerEpoch: 0, CurrentMetadataOffset: 0},
+	}
+	apiKeys := []int16{messages.BrokerRegistration, messages.BrokerHeartbeat}
+	apiVersions := []int16{4, 1}
+
+	for i, body := range requests {
+		buf := bytes.NewBuffer(make([]byte, 0))
+		if err := body.Write(buf); err != nil {
+			t.Fatalf("failed to encode the request: %v", err)
+		}
+		header := protocol.RequestHeader{ApiKey: apiKeys[i], ApiVersion: apiVersions[i], CorrelationId: int32(i)}
+		request := protocol.Request{RequestHeader: header, Body: buf}
+		if err := request.Write(client); err != nil {
+			t.Fatalf("failed to send the request: %v", err)
+		}
+
+		response, err := protocol.ReadResponse(client, map[int32]protocol.RequestHeader{int32(i): header})
+		if err != nil {
+			t.Fatalf("failed to read the response: %v", err)
+		}
+		responseBody, _ := messages.NewResponseBody(response.ApiKey)
+		if err := responseBody.Read(&response); err != nil {
+			t.Fatalf("failed to decode the response: %v", err)
+		}
+		switch r := responseBody.(type) {
+		case *brokerregistration.BrokerRegistrationResponse:
+			if r.ErrorCode != errorcodes.None || r.BrokerEpoch != 0 {
+				t.Errorf("unexpected response %+v", r)
+			}
+		case *brokerheartbeat.BrokerHeartbeatResponse:
+			if r.ErrorCode != errorcodes.None || r.IsFenced {
+				t.Errorf("unexpected response %+v", r)
+			}
+		}
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+}
+
+func TestLogReplay(t *testing.T) {
+	tc := newTestController(t)
+	epochs := tc.startBrokers(1, 2, 3)
+	if _, err := tc.CreateTopic("orders", [][]int32{{1, 2, 3}, {2, 3, 1}}); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	tc.heartbeat(2, epochs[2], false, true)
+	tc.handle(&allocateproducerids.AllocateProducerIdsRequest{BrokerId: 1, BrokerEpoch: epochs[1]})
+
+	replayed := clustermetadata.NewImage()
+	for _, batch := range tc.Log() {
+		if err := replayed.ApplyBatch(batch); err != nil {
+			t.Fatalf("ApplyBatch: %v", err)
+		}
+	}
+
+	tc.View(func(image *clustermetadata.Image) {
+		if replayed.Offset != image.Offset || replayed.NextProducerId != image.NextProducerId {
+			t.Errorf("unexpected replayed image at offset %d", replayed.Offset)
+		}
+		if !reflect.DeepEqual(replayed.Brokers, image.Brokers) {
+			t.Errorf("the replayed brokers differ")
+		}
+		if !reflect.DeepEqual(replayed.Topics, image.Topics) {
+			t.Errorf("the replayed topics differ")
+		}
+	})
+}
@@ -0,0 +1,399 @@
+package mockcontroller
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/alterpartition"
+	"github.com/scholzj/go-kafka-protocol/api/assignreplicastodirs"
+	"github.com/scholzj/go-kafka-protocol/clustermetadata"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+// CreateTopic creates a topic with the replica assignment of every partition. The leader of each
+// partition is its first eligible replica and the ISR holds all eligible replicas, or all replicas
+// when none is eligible yet.
+func (c *Controller) CreateTopic(name string, assignment [][]int32) (uuid.UUID, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.image.TopicByName(name) != nil {
+		return uuid.Nil, fmt.Errorf("topic %s already exists", name)
+	}
+
+	topicId := uuid.New()
+	changes := []clustermetadata.Message{&clustermetadata.TopicRecord{Name: name, TopicId: topicId}}
+	for index, replicas := range assignment {
+		if len(replicas) == 0 {
+			return uuid.Nil, fmt.Errorf("partition %d of topic %s has no replicas", index, name)
+		}
+
+		isr := c.eligibleReplicas(replicas)
+		leader := clustermetadata.NoLeader
+		if len(isr) > 0 {
+			leader = isr[0]
+		} else {
+			isr = append([]int32(nil), replicas...)
+		}
+
+		changes = append(changes, &clustermetadata.PartitionRecord{
+			PartitionId:      int32(index),
+			TopicId:          topicId,
+			Replicas:         append([]int32(nil), replicas...),
+			Isr:              isr,
+			RemovingReplicas: []int32{},
+			AddingReplicas:   []int32{},
+			Leader:           leader,
+			LeaderEpoch:      0,
+			PartitionEpoch:   0,
+			Directories:      make([]uuid.UUID, len(replicas)),
+		})
+	}
+
+	if _, err := c.append(changes...); err != nil {
+		return uuid.Nil, err
+	}
+	return topicId, nil
+}
+
+// eligible returns whether the broker can be in an ISR or lead a partition.
+func (c *Controller) eligible(id int32) bool {
+	broker, ok := c.image.Brokers[id]
+	return ok && !broker.Fenced && !broker.InControlledShutdown
+}
+
+func (c *Controller) eligibleReplicas(replicas []int32) []int32 {
+	eligible := make([]int32, 0, len(replicas))
+	for _, id := range replicas {
+		if c.eligible(id) {
+			eligible = append(eligible, id)
+		}
+	}
+	return eligible
+}
+
+type partitionRef struct {
+	topicId   uuid.UUID
+	partition int32
+	state     *clustermetadata.Partition
+}
+
+// partitions returns all partitions in a stable order.
+func (c *Controller) partitions() []partitionRef {
+	var refs []partitionRef
+	for topicId, topic := range c.image.Topics {
+		for index, state := range topic.Partitions {
+			refs = append(refs, partitionRef{topicId: topicId, partition: index, state: state})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].topicId != refs[j].topicId {
+			return refs[i].topicId.String() < refs[j].topicId.String()
+		}
+		return refs[i].partition < refs[j].partition
+	})
+	return refs
+}
+
+func (c *Controller) leadsPartitions(id int32) bool {
+	for _, p := range c.partitions() {
+		if p.state.Leader == id {
+			return true
+		}
+	}
+	return false
+}
+
+// removeFromPartitions returns the partition changes which remove the broker from the ISRs and
+// move its leaderships to another eligible ISR member. The last member of an ISR stays in it, and a
+// partition without another eligible ISR member is left without a leader.
+func (c *Controller) removeFromPartitions(id int32) []clustermetadata.Message {
+	var changes []clustermetadata.Message
+	for _, p := range c.partitions() {
+		if !contains(p.state.Isr, id) && p.state.Leader != id {
+			continue
+		}
+
+		change := &clustermetadata.PartitionChangeRecord{
+			TopicId:             p.topicId,
+			PartitionId:         p.partition,
+			Leader:              clustermetadata.NoLeaderChange,
+			LeaderRecoveryState: clustermetadata.NoLeaderRecoveryStateChange,
+		}
+		if isr := without(p.state.Isr, id); len(isr) > 0 && len(isr) != len(p.state.Isr) {
+			change.Isr = isr
+		}
+		if p.state.Leader == id {
+			change.Leader = clustermetadata.NoLeader
+			for _, replica := range p.state.Replicas {
+				if replica != id && contains(p.state.Isr, replica) && c.eligible(replica) {
+					change.Leader = replica
+					break
+				}
+			}
+		}
+		if change.Isr != nil || change.Leader != clustermetadata.NoLeaderChange {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// electLeaderless returns the partition changes which make the broker the leader of the partitions
+// without a leader which have it in their ISR.
+func (c *Controller) electLeaderless(id int32) []clustermetadata.Message {
+	var changes []clustermetadata.Message
+	for _, p := range c.partitions() {
+		if p.state.Leader == clustermetadata.NoLeader && contains(p.state.Isr, id) {
+			changes = append(changes, &clustermetadata.PartitionChangeRecord{
+				TopicId:             p.topicId,
+				PartitionId:         p.partition,
+				Leader:              id,
+				LeaderRecoveryState: clustermetadata.NoLeaderRecoveryStateChange,
+			})
+		}
+	}
+	return changes
+}
+
+// alterPartition validates ISR changes of partition leaders in the same order as Kafka: the leader
+// epoch, the leader, the leader recovery state, the partition epoch and finally the eligibility of
+// every new ISR member.
+func (c *Controller) alterPartition(req *alterpartition.AlterPartitionRequest) (*alterpartition.AlterPartitionResponse, error) {
+	response := &alterpartition.AlterPartitionResponse{ApiVersion: req.ApiVersion, Topics: &[]alterpartition.AlterPartitionResponseTopic{}}
+
+	if _, errorCode := c.checkBroker(req.BrokerId, req.BrokerEpoch); errorCode != errorcodes.None {
+		response.ErrorCode = errorCode
+		return response, nil
+	}
+	if req.Topics == nil {
+		return response, nil
+	}
+
+	for _, t := range *req.Topics {
+		topicResponse := alterpartition.AlterPartitionResponseTopic{TopicId: t.TopicId, Partitions: &[]alterpartition.AlterPartitionResponseTopicPartition{}}
+		topic := c.image.Topics[t.TopicId]
+
+		if t.Partitions != nil {
+			for _, p := range *t.Partitions {
+				result := alterpartition.AlterPartitionResponseTopicPartition{PartitionIndex: p.PartitionIndex, Isr: &[]int32{}}
+
+				var partition *clustermetadata.Partition
+				if topic != nil {
+					partition = topic.Partitions[p.PartitionIndex]
+				}
+				switch {
+				case topic == nil:
+					result.ErrorCode = errorcodes.UnknownTopicId
+				case partition == nil:
+					result.ErrorCode = errorcodes.UnknownTopicOrPartition
+				default:
+					result.ErrorCode = c.validateAlterPartition(req, p, partition)
+				}
+
+				if result.ErrorCode == errorcodes.None {
+					change := &clustermetadata.PartitionChangeRecord{
+						TopicId:             t.TopicId,
+						PartitionId:         p.PartitionIndex,
+						Leader:              clustermetadata.NoLeaderChange,
+						LeaderRecoveryState: clustermetadata.NoLeaderRecoveryStateChange,
+					}
+					if isr := requestedIsr(p); !sameMembers(isr, partition.Isr) {
+						change.Isr = isr
+					}
+					if p.LeaderRecoveryState != partition.LeaderRecoveryState {
+						change.LeaderRecoveryState = p.LeaderRecoveryState
+					}
+					if change.Isr != nil || change.LeaderRecoveryState != clustermetadata.NoLeaderRecoveryStateChange {
+						if _, err := c.append(change); err != nil {
+							return nil, err
+						}
+					}
+				}
+
+				if partition != nil && result.ErrorCode == errorcodes.None {
+					result.LeaderId = partition.Leader
+					result.LeaderEpoch = partition.LeaderEpoch
+					isr := append([]int32{}, partition.Isr...)
+					result.Isr = &isr
+					result.LeaderRecoveryState = partition.LeaderRecoveryState
+					result.PartitionEpoch = partition.PartitionEpoch
+				}
+				*topicResponse.Partitions = append(*topicResponse.Partitions, result)
+			}
+		}
+		*response.Topics = append(*response.Topics, topicResponse)
+	}
+	return response, nil
+}
+
+func (c *Controller) validateAlterPartition(req *alterpartition.AlterPartitionRequest, p alterpartition.AlterPartitionRequestTopicPartition, partition *clustermetadata.Partition) int16 {
+	switch {
+	case p.LeaderEpoch < partition.LeaderEpoch:
+		return errorcodes.FencedLeaderEpoch
+	case p.LeaderEpoch > partition.LeaderEpoch:
+		return errorcodes.NotController
+	case req.BrokerId != partition.Leader:
+		return errorcodes.InvalidRequest
+	case p.LeaderRecoveryState == 1 && partition.LeaderRecoveryState == 0:
+		// A recovered partition cannot go back to recovering.
+		return errorcodes.InvalidRequest
+	case p.PartitionEpoch < partition.PartitionEpoch:
+		return errorcodes.InvalidUpdateVersion
+	case p.PartitionEpoch > partition.PartitionEpoch:
+		return errorcodes.NotController
+	}
+
+	isr := requestedIsr(p)
+	if !contains(isr, partition.Leader) || len(isr) != len(unique(isr)) {
+		return errorcodes.InvalidRequest
+	}
+	if p.LeaderRecoveryState == 1 && len(isr) != 1 {
+		// A recovering partition only has the leader in its ISR.
+		return errorcodes.InvalidRequest
+	}
+
+	for _, id := range isr {
+		if !contains(partition.Replicas, id) || !c.eligible(id) {
+			return errorcodes.IneligibleReplica
+		}
+	}
+	if p.NewIsrWithEpochs != nil {
+		for _, member := range *p.NewIsrWithEpochs {
+			if member.BrokerEpoch != -1 && c.image.Brokers[member.BrokerId].Epoch != member.BrokerEpoch {
+				return errorcodes.IneligibleReplica
+			}
+		}
+	}
+	return errorcodes.None
+}
+
+// requestedIsr returns the new ISR of the request, from NewIsrWithEpochs in version 3+ or NewIsr
+// before.
+func requestedIsr(p alterpartition.AlterPartitionRequestTopicPartition) []int32 {
+	isr := []int32{}
+	if p.NewIsrWithEpochs != nil {
+		for _, member := range *p.NewIsrWithEpochs {
+			isr = append(isr, member.BrokerId)
+		}
+	} else if p.NewIsr != nil {
+		isr = append(isr, *p.NewIsr...)
+	}
+	return isr
+}
+
+// assignReplicasToDirs records the log directories of the replicas on the broker (KIP-858).
+func (c *Controller) assignReplicasToDirs(req *assignreplicastodirs.AssignReplicasToDirsRequest) (*assignreplicastodirs.AssignReplicasToDirsResponse, error) {
+	response := &assignreplicastodirs.AssignReplicasToDirsResponse{ApiVersion: req.ApiVersion, Directories: &[]assignreplicastodirs.AssignReplicasToDirsResponseDirectorie{}}
+
+	if _, errorCode := c.checkBroker(req.BrokerId, req.BrokerEpoch); errorCode != errorcodes.None {
+		response.ErrorCode = errorCode
+		return response, nil
+	}
+	if req.Directories == nil {
+		return response, nil
+	}
+
+	for _, dir := range *req.Directories {
+		dirResponse := assignreplicastodirs.AssignReplicasToDirsResponseDirectorie{Id: dir.Id, Topics: &[]assignreplicastodirs.AssignReplicasToDirsResponseDirectorieTopic{}}
+		if dir.Topics != nil {
+			for _, t := range *dir.Topics {
+				topicResponse := assignreplicastodirs.AssignReplicasToDirsResponseDirectorieTopic{TopicId: t.TopicId, Partitions: &[]assignreplicastodirs.AssignReplicasToDirsResponseDirectorieTopicPartition{}}
+				topic := c.image.Topics[t.TopicId]
+
+				if t.Partitions != nil {
+					for _, p := range *t.Partitions {
+						errorCode, err := c.assignReplicaToDir(topic, t.TopicId, p.PartitionIndex, req.BrokerId, dir.Id)
+						if err != nil {
+							return nil, err
+						}
+						*topicResponse.Partitions = append(*topicResponse.Partitions, assignreplicastodirs.AssignReplicasToDirsResponseDirectorieTopicPartition{PartitionIndex: p.PartitionIndex, ErrorCode: errorCode})
+					}
+				}
+				*dirResponse.Topics = append(*dirResponse.Topics, topicResponse)
+			}
+		}
+		*response.Directories = append(*response.Directories, dirResponse)
+	}
+	return response, nil
+}
+
+func (c *Controller) assignReplicaToDir(topic *clustermetadata.Topic, topicId uuid.UUID, index int32, brokerId int32, dir uuid.UUID) (int16, error) {
+	if topic == nil {
+		return errorcodes.UnknownTopicId, nil
+	}
+	partition, ok := topic.Partitions[index]
+	if !ok {
+		return errorcodes.UnknownTopicOrPartition, nil
+	}
+
+	position := -1
+	for i, replica := range partition.Replicas {
+		if replica == brokerId {
+			position = i
+		}
+	}
+	if position < 0 {
+		return errorcodes.NotLeaderOrFollower, nil
+	}
+
+	directories := make([]uuid.UUID, len(partition.Replicas))
+	copy(directories, partition.Directories)
+	if directories[position] == dir {
+		return errorcodes.None, nil
+	}
+	directories[position] = dir
+
+	_, err := c.append(&clustermetadata.PartitionChangeRecord{
+		TopicId:             topicId,
+		PartitionId:         index,
+		Leader:              clustermetadata.NoLeaderChange,
+		LeaderRecoveryState: clustermetadata.NoLeaderRecoveryStateChange,
+		Directories:         directories,
+	})
+	return errorcodes.None, err
+}
+
+func contains(ids []int32, id int32) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func without(ids []int32, id int32) []int32 {
+	result := make([]int32, 0, len(ids))
+	for _, i := range ids {
+		if i != id {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+func unique(ids []int32) []int32 {
+	seen := make(map[int32]bool)
+	result := make([]int32, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func sameMembers(a []int32, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, id := range a {
+		if !contains(b, id) {
+			return false
+		}
+	}
+	return true
+}
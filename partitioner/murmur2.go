@@ -0,0 +1,54 @@
+package partitioner
+
+// Murmur2 returns the 32-bit murmur2 hash of the data with the seed used by Kafka. It matches
+// org.apache.kafka.common.utils.Utils.murmur2, including the signed result.
+func Murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+
+	length := len(data)
+	h := seed ^ uint32(length)
+
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// ToPositive converts a hash to a non-negative number by clearing the sign bit, like
+// Utils.toPositive. Unlike math.Abs it maps math.MinInt32 to 0 instead of overflowing.
+func ToPositive(n int32) int32 {
+	return n & 0x7fffffff
+}
+
+// KeyPartition returns the partition of a record with the key, the same way as the default
+// partitioner of the Java producer. Records with the same key are always sent to the same partition
+// as long as the number of partitions does not change.
+func KeyPartition(key []byte, numPartitions int32) int32 {
+	return ToPositive(Murmur2(key)) % numPartitions
+}
@@ -0,0 +1,177 @@
+package partitioner
+
+import (
+	"math"
+	"testing"
+)
+
+// The vectors are taken from UtilsTest.testMurmur2 of the Java client.
+func TestMurmur2(t *testing.T) {
+	tests := []struct {
+		data     []byte
+		expected int32
+	}{
+		{[]byte("21"), -973932308},
+		{[]byte("foobar"), -790332482},
+		{[]byte("a-little-bit-long-string"), -985981536},
+		{[]byte("a-little-bit-longer-string"), -1486304829},
+		{[]byte("lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8"), -58897971},
+		{[]byte{'a', 'b', 'c'}, 479470107},
+	}
+
+	for _, test := range tests {
+		if got := Murmur2(test.data); got != test.expected {
+			t.Errorf("Murmur2(%q) = %d, expected %d", test.data, got, test.expected)
+		}
+	}
+}
+
+func TestToPositive(t *testing.T) {
+	tests := []struct {
+		n        int32
+		expected int32
+	}{
+		{0, 0},
+		{1, 1},
+		{-1, math.MaxInt32},
+		{math.MinInt32, 0},
+		{math.MaxInt32, math.MaxInt32},
+		{-790332482, 1357151166},
+	}
+
+	for _, test := range tests {
+		if got := ToPositive(test.n); got != test.expected {
+			t.Errorf("ToPositive(%d) = %d, expected %d", test.n, got, test.expected)
+		}
+	}
+}
+
+func TestKeyPartition(t *testing.T) {
+	tests := []struct {
+		key           string
+		numPartitions int32
+		expected      int32
+	}{
+		{"foobar", 1, 0},
+		{"foobar", 3, 1357151166 % 3},
+		{"21", 12, ToPositive(-973932308) % 12},
+		{"abc", 100, 479470107 % 100},
+		{"", 7, ToPositive(Murmur2(nil)) % 7},
+	}
+
+	for _, test := range tests {
+		if got := KeyPartition([]byte(test.key), test.numPartitions); got != test.expected {
+			t.Errorf("KeyPartition(%q, %d) = %d, expected %d", test.key, test.numPartitions, got, test.expected)
+		}
+	}
+}
+
+// sequence returns a Random function which returns the numbers in order and then repeats the last one.
+func sequence(numbers ...int32) func() int32 {
+	return func() int32 {
+		n := numbers[0]
+		if len(numbers) > 1 {
+			numbers = numbers[1:]
+		}
+		return n
+	}
+}
+
+func TestSticky(t *testing.T) {
+	s := NewSticky()
+	s.Random = sequence(4, 4, 5, 7)
+	available := []int32{0, 2, 4}
+
+	first := s.Partition("orders", 6, available)
+	if first != 2 || s.Partition("orders", 6, available) != 2 {
+		t.Fatalf("unexpected partition %d", first)
+	}
+
+	// The same random partition is skipped.
+	if next := s.OnNewBatch("orders", 6, available, 2); next != 4 {
+		t.Fatalf("unexpected partition %d", next)
+	}
+	// A batch of an older partition does not switch again.
+	if next := s.OnNewBatch("orders", 6, available, 2); next != 4 {
+		t.Fatalf("unexpected partition %d", next)
+	}
+
+	if only := s.OnNewBatch("orders", 6, []int32{5}, 4); only != 5 {
+		t.Errorf("unexpected partition %d", only)
+	}
+	if none := s.Partition("payments", 6, nil); none != 7%6 {
+		t.Errorf("unexpected partition %d", none)
+	}
+}
+
+func TestUniformSticky(t *testing.T) {
+	u := NewUniformSticky()
+	u.BatchSize = 100
+	u.Random = sequence(1, 2, 3)
+	available := []int32{0, 1, 2, 3}
+
+	if p := u.Partition("orders", []byte("foobar"), 4, available); p != KeyPartition([]byte("foobar"), 4) {
+		t.Errorf("unexpected partition %d for a key", p)
+	}
+	if p := u.Partition("orders", nil, 4, available); p != 1 {
+		t.Fatalf("unexpected partition %d", p)
+	}
+
+	// Switching waits for the batch to be full, but not longer than twice the batch size.
+	u.Update("orders", 1, 150, false, 4, available)
+	if p := u.Partition("orders", nil, 4, available); p != 1 {
+		t.Fatalf("unexpected partition %d", p)
+	}
+	u.Update("orders", 3, 1000, true, 4, available)
+	if p := u.Partition("orders", nil, 4, available); p != 1 {
+		t.Fatalf("an update of another partition switched to %d", p)
+	}
+	u.Update("orders", 1, 50, false, 4, available)
+	if p := u.Partition("orders", nil, 4, available); p != 2 {
+		t.Fatalf("unexpected partition %d", p)
+	}
+	u.Update("orders", 2, 100, true, 4, available)
+	if p := u.Partition("orders", nil, 4, available); p != 3 {
+		t.Fatalf("unexpected partition %d", p)
+	}
+
+	u.IgnoreKeys = true
+	if p := u.Partition("orders", []byte("foobar"), 4, available); p != 3 {
+		t.Errorf("unexpected partition %d with ignored keys", p)
+	}
+}
+
+func TestUniformStickyAdaptive(t *testing.T) {
+	u := NewUniformSticky()
+
+	// Queue sizes 0, 2 and 1 give the frequencies 3, 1 and 2 and the table 3, 4, 6.
+	u.SetQueueSizes("orders", []int32{10, 11, 12}, []int{0, 2, 1})
+	tests := []struct {
+		random   int32
+		expected int32
+	}{
+		{0, 10},
+		{2, 10},
+		{3, 11},
+		{4, 12},
+		{5, 12},
+		{6, 10},
+		{-1, 10}, // math.MaxInt32 % 6 == 1
+	}
+
+	for _, test := range tests {
+		u.Random = sequence(test.random)
+		u.topics["orders"].started = false
+		if got := u.Partition("orders", nil, 13, nil); got != test.expected {
+			t.Errorf("random %d: expected partition %d, got %d", test.random, test.expected, got)
+		}
+	}
+
+	// Equal queues fall back to the available partitions.
+	u.SetQueueSizes("orders", []int32{10, 11, 12}, []int{3, 3, 3})
+	u.Random = sequence(4)
+	u.topics["orders"].started = false
+	if got := u.Partition("orders", nil, 13, []int32{10, 11, 12}); got != 11 {
+		t.Errorf("unexpected partition %d", got)
+	}
+}
@@ -0,0 +1,227 @@
+package partitioner
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// DefaultBatchSize matches the default of batch.size. The uniform sticky partitioner switches to a new
+// partition after this many bytes.
+const DefaultBatchSize = 16384
+
+// The partitioners below decide the partition of records without a key. The available partitions
+// passed to them are the partitions with a known leader, as in Cluster.availablePartitionsForTopic of
+// the Java client. They can be empty, in which case any of the numPartitions partitions is used.
+
+////////////////////
+// Sticky partitioner (KIP-480)
+////////////////////
+
+// Sticky is the sticky partitioner of KIP-480, used for records without a key by the Java producers
+// from 2.4 to 3.2. It sends records to the same partition until the batch of the partition is
+// completed, and then picks another available partition at random. It is safe for concurrent use.
+type Sticky struct {
+	// Random returns a non-negative random number. It defaults to rand.Int31 and can be replaced in
+	// tests.
+	Random func() int32
+
+	mu         sync.Mutex
+	partitions map[string]int32
+}
+
+func NewSticky() *Sticky {
+	return &Sticky{
+		Random:     rand.Int31,
+		partitions: make(map[string]int32),
+	}
+}
+
+// Partition returns the current partition of the topic, picking one for the first record.
+func (s *Sticky) Partition(topic string, numPartitions int32, available []int32) int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if partition, ok := s.partitions[topic]; ok {
+		return partition
+	}
+	return s.next(topic, numPartitions, available, -1)
+}
+
+// OnNewBatch is called when a new batch is created for the previous partition. It switches the topic
+// to a different partition, unless another caller switched it already, and returns the new partition.
+func (s *Sticky) OnNewBatch(topic string, numPartitions int32, available []int32, previous int32) int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.next(topic, numPartitions, available, previous)
+}
+
+// next mirrors StickyPartitionCache.nextPartition.
+func (s *Sticky) next(topic string, numPartitions int32, available []int32, previous int32) int32 {
+	current, ok := s.partitions[topic]
+	if ok && current != previous {
+		return current
+	}
+
+	var partition int32
+	switch {
+	case len(available) < 1:
+		partition = ToPositive(s.Random()) % numPartitions
+	case len(available) == 1:
+		partition = available[0]
+	default:
+		for {
+			partition = available[ToPositive(s.Random())%int32(len(available))]
+			if !ok || partition != current {
+				break
+			}
+		}
+	}
+
+	s.partitions[topic] = partition
+	return partition
+}
+
+////////////////////
+// Uniform sticky partitioner (KIP-794)
+////////////////////
+
+// UniformSticky is the built-in partitioner of KIP-794, used by the Java producers since 3.3. Records
+// with a key are partitioned by KeyPartition. Records without a key are sent to the same partition
+// until BatchSize bytes were produced to it, and then to a random available partition. With
+// adaptive partitioning the random choice favours partitions with fewer queued batches (see
+// SetQueueSizes). It is safe for concurrent use.
+type UniformSticky struct {
+	// Random returns a non-negative random number. It defaults to rand.Int31 and can be replaced in
+	// tests.
+	Random func() int32
+
+	BatchSize  int  // The bytes produced to a partition before switching to the next one.
+	IgnoreKeys bool // Partition records with a key like records without one (partitioner.ignore.keys).
+
+	mu     sync.Mutex
+	topics map[string]*uniformTopic
+}
+
+type uniformTopic struct {
+	partition     int32
+	producedBytes int
+	started       bool
+	loadStats     *loadStats
+}
+
+// loadStats is the cumulative frequency table of the available partitions, where partitions with
+// fewer queued batches have higher frequencies.
+type loadStats struct {
+	cumulativeFrequencies []int
+	partitionIds          []int32
+}
+
+func NewUniformSticky() *UniformSticky {
+	return &UniformSticky{
+		Random:    rand.Int31,
+		BatchSize: DefaultBatchSize,
+		topics:    make(map[string]*uniformTopic),
+	}
+}
+
+func (u *UniformSticky) topic(name string) *uniformTopic {
+	t, ok := u.topics[name]
+	if !ok {
+		t = &uniformTopic{}
+		u.topics[name] = t
+	}
+	return t
+}
+
+// Partition returns the partition of the record.
+func (u *UniformSticky) Partition(topic string, key []byte, numPartitions int32, available []int32) int32 {
+	if key != nil && !u.IgnoreKeys {
+		return KeyPartition(key, numPartitions)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	t := u.topic(topic)
+	if !t.started {
+		t.partition = u.next(t, numPartitions, available)
+		t.started = true
+	}
+	return t.partition
+}
+
+// Update records that appendedBytes were appended to the partition. The topic switches to the next
+// partition once BatchSize bytes were produced and enableSwitch is true, which the Java producer sets
+// when all batches of the partition are full, or once twice as many bytes were produced. Updates of a
+// partition which is not the current one of the topic are ignored.
+func (u *UniformSticky) Update(topic string, partition int32, appendedBytes int, enableSwitch bool, numPartitions int32, available []int32) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	t := u.topic(topic)
+	if !t.started || t.partition != partition || u.BatchSize <= 0 {
+		return
+	}
+
+	t.producedBytes += appendedBytes
+	if (t.producedBytes >= u.BatchSize && enableSwitch) || t.producedBytes >= 2*u.BatchSize {
+		t.partition = u.next(t, numPartitions, available)
+		t.producedBytes = 0
+	}
+}
+
+// SetQueueSizes sets the number of queued batches of the available partitions of the topic for
+// adaptive partitioning (partitioner.adaptive.partitioning.enable). A partition is picked with a
+// probability proportional to the largest queue size plus one minus its own queue size. When all
+// queues have the same size, every available partition is equally likely.
+func (u *UniformSticky) SetQueueSizes(topic string, partitionIds []int32, queueSizes []int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	t := u.topic(topic)
+	t.loadStats = nil
+	if len(partitionIds) < 1 || len(partitionIds) != len(queueSizes) {
+		return
+	}
+
+	maxSize, allEqual := queueSizes[0], true
+	for _, size := range queueSizes[1:] {
+		if size != queueSizes[0] {
+			allEqual = false
+		}
+		if size > maxSize {
+			maxSize = size
+		}
+	}
+	if allEqual {
+		return
+	}
+
+	stats := &loadStats{cumulativeFrequencies: make([]int, len(queueSizes)), partitionIds: append([]int32(nil), partitionIds...)}
+	total := 0
+	for i, size := range queueSizes {
+		total += maxSize + 1 - size
+		stats.cumulativeFrequencies[i] = total
+	}
+	t.loadStats = stats
+}
+
+// next mirrors BuiltInPartitioner.nextPartition.
+func (u *UniformSticky) next(t *uniformTopic, numPartitions int32, available []int32) int32 {
+	random := int(ToPositive(u.Random()))
+
+	if stats := t.loadStats; stats != nil {
+		weighted := random % stats.cumulativeFrequencies[len(stats.cumulativeFrequencies)-1]
+		index := sort.Search(len(stats.cumulativeFrequencies), func(i int) bool {
+			return stats.cumulativeFrequencies[i] > weighted
+		})
+		return stats.partitionIds[index]
+	}
+
+	if len(available) > 0 {
+		return available[random%len(available)]
+	}
+	return int32(random % int(numPartitions))
+}
@@ -0,0 +1,467 @@
+package producerstate
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/scholzj/go-kafka-protocol/api/initproducerid"
+	"github.com/scholzj/go-kafka-protocol/api/produce"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// DefaultTransactionTimeout matches the default of transaction.timeout.ms.
+const DefaultTransactionTimeout = 60 * time.Second
+
+// State is the state of the producer. The names follow the states of the Java TransactionManager.
+type State int8
+
+const (
+	Uninitialized State = iota
+	Initializing
+	Ready
+	InTransaction
+	CommittingTransaction
+	AbortingTransaction
+	AbortableError // The transaction must be aborted before the producer can be used again
+	FatalError     // The producer cannot be used anymore
+)
+
+func (s State) String() string {
+	switch s {
+	case Uninitialized:
+		return "UNINITIALIZED"
+	case Initializing:
+		return "INITIALIZING"
+	case Ready:
+		return "READY"
+	case InTransaction:
+		return "IN_TRANSACTION"
+	case CommittingTransaction:
+		return "COMMITTING_TRANSACTION"
+	case AbortingTransaction:
+		return "ABORTING_TRANSACTION"
+	case AbortableError:
+		return "ABORTABLE_ERROR"
+	case FatalError:
+		return "FATAL_ERROR"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+var (
+	ErrInvalidState       = errors.New("invalid producer state")
+	ErrNotTransactional   = errors.New("the producer has no transactional id")
+	ErrEpochBumpRequired  = errors.New("the producer epoch must be bumped with InitProducerId")
+	ErrStaleBatch         = errors.New("the batch was stamped with an older producer id or epoch")
+	ErrPartitionNotInTxn  = errors.New("the partition has not been added to the transaction")
+	ErrUnsupportedVersion = errors.New("the API version does not support this operation")
+
+	// ErrEarlierBatchPending is returned for a batch which failed with OUT_OF_ORDER_SEQUENCE_NUMBER
+	// because an earlier batch of the partition is still being retried. It is retriable.
+	ErrEarlierBatchPending = errors.New("an earlier batch of the partition has not been acknowledged yet")
+)
+
+// TopicPartition identifies a partition. Transactions address topics by name, so the producer tracks
+// partitions by name also when the Produce requests use topic ids (v13+).
+type TopicPartition struct {
+	Topic     string
+	Partition int32
+}
+
+func (tp TopicPartition) String() string {
+	return fmt.Sprintf("%s-%d", tp.Topic, tp.Partition)
+}
+
+// inflightBatch is a stamped batch which has not been acknowledged yet.
+type inflightBatch struct {
+	baseSequence int32
+	count        int32
+}
+
+type partitionState struct {
+	nextSequence int32
+	lastAcked    int32 // The last sequence acknowledged by the leader, or -1.
+	inflight     []inflightBatch
+}
+
+// Producer keeps the producer id, epoch and sequence numbers of an idempotent producer and, when it
+// has a transactional id, the state of its transactions. It builds the InitProducerId,
+// AddPartitionsToTxn, AddOffsetsToTxn, TxnOffsetCommit and EndTxn requests and interprets their
+// responses as well as the Produce responses. Sending the requests to the right broker or coordinator
+// is left to the caller. It is safe for concurrent use.
+type Producer struct {
+	TransactionalId    string // Empty for an idempotent producer without transactions.
+	TransactionTimeout time.Duration
+
+	// TransactionVersion is the finalized transaction.version feature level of the cluster. From level
+	// 2 (KIP-890 part 2) partitions are added to transactions implicitly by Produce v12+, offsets by
+	// TxnOffsetCommit v5+, and EndTxn v5+ bumps the producer epoch after every transaction.
+	TransactionVersion int16
+
+	mu            sync.Mutex
+	state         State
+	producerId    int64
+	producerEpoch int16
+	epochBump     bool // The epoch must be bumped before the next batch or transaction.
+	lastError     error
+	partitions    map[TopicPartition]*partitionState
+
+	// The current transaction.
+	pendingPartitions map[TopicPartition]bool // Used, but not added with AddPartitionsToTxn yet
+	txnPartitions     map[TopicPartition]bool
+	pendingGroup      string
+	txnGroups         map[string]bool
+	previousState     State // The state to go back to when InitProducerId fails with a retriable error.
+}
+
+func NewProducer(transactionalId string) *Producer {
+	return &Producer{
+		TransactionalId:    transactionalId,
+		TransactionTimeout: DefaultTransactionTimeout,
+		producerId:         records.NoProducerId,
+		producerEpoch:      records.NoProducerEpoch,
+		partitions:         make(map[TopicPartition]*partitionState),
+		pendingPartitions:  make(map[TopicPartition]bool),
+		txnPartitions:      make(map[TopicPartition]bool),
+		txnGroups:          make(map[string]bool),
+	}
+}
+
+// State returns the current state.
+func (p *Producer) State() State {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.state
+}
+
+// ProducerIdAndEpoch returns the current producer id and epoch, or -1 and -1 before InitProducerId
+// completed.
+func (p *Producer) ProducerIdAndEpoch() (int64, int16) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.producerId, p.producerEpoch
+}
+
+// LastError returns the error which moved the producer to AbortableError or FatalError.
+func (p *Producer) LastError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.lastError
+}
+
+// NeedsInitProducerId returns whether an InitProducerId request must be sent before the next batch:
+// before the first one and after an error which requires a bump of the producer epoch.
+func (p *Producer) NeedsInitProducerId() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.state == Uninitialized || (p.state == Ready && p.epochBump)
+}
+
+func (p *Producer) transactional() bool {
+	return p.TransactionalId != ""
+}
+
+func (p *Producer) transactionV2() bool {
+	return p.transactional() && p.TransactionVersion >= 2
+}
+
+// transition moves the producer to the state or returns ErrInvalidState when the transition is not
+// allowed.
+func (p *Producer) transition(to State) error {
+	if p.state == FatalError {
+		return fmt.Errorf("%w: the producer failed: %w", ErrInvalidState, p.lastError)
+	}
+
+	var allowed bool
+	switch to {
+	case Initializing:
+		allowed = p.state == Uninitialized || p.state == Ready || p.state == Initializing
+	case Ready:
+		allowed = p.state == Initializing || p.state == CommittingTransaction || p.state == AbortingTransaction
+	case InTransaction:
+		allowed = p.state == Ready
+	case CommittingTransaction:
+		allowed = p.state == InTransaction
+	case AbortingTransaction:
+		allowed = p.state == InTransaction || p.state == AbortableError
+	case AbortableError:
+		allowed = p.state == InTransaction || p.state == CommittingTransaction || p.state == AbortableError
+	case FatalError:
+		allowed = true
+	}
+	if !allowed {
+		return fmt.Errorf("%w: cannot move from %s to %s", ErrInvalidState, p.state, to)
+	}
+
+	p.state = to
+	return nil
+}
+
+// fail moves the producer to FatalError, or to AbortableError when abortable is true and a transaction
+// is in progress, and returns the error.
+func (p *Producer) fail(err error, abortable bool) error {
+	if abortable && p.transition(AbortableError) == nil {
+		p.lastError = err
+		return err
+	}
+
+	p.state = FatalError
+	p.lastError = err
+	return err
+}
+
+// resetSequences starts the sequences of every partition from zero, as required for a new producer id
+// or epoch.
+func (p *Producer) resetSequences() {
+	p.partitions = make(map[TopicPartition]*partitionState)
+}
+
+////////////////////
+// Producer id and epoch
+////////////////////
+
+// InitProducerIdRequest returns the request which initializes the producer or bumps its epoch. The
+// current producer id and epoch are sent (v3+, KIP-360) when the epoch is bumped, so the coordinator
+// keeps the producer id; older versions allocate a new producer id instead.
+func (p *Producer) InitProducerIdRequest(version int16) (*initproducerid.InitProducerIdRequest, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	previous := p.state
+	if err := p.transition(Initializing); err != nil {
+		return nil, err
+	}
+	if previous != Initializing {
+		p.previousState = previous
+	}
+
+	request := &initproducerid.InitProducerIdRequest{
+		ApiVersion:           version,
+		TransactionTimeoutMs: int32(p.TransactionTimeout.Milliseconds()),
+		ProducerId:           records.NoProducerId,
+		ProducerEpoch:        records.NoProducerEpoch,
+	}
+	if p.transactional() {
+		request.TransactionalId = &p.TransactionalId
+	}
+	if version >= 3 && p.producerId != records.NoProducerId {
+		request.ProducerId = p.producerId
+		request.ProducerEpoch = p.producerEpoch
+	}
+	return request, nil
+}
+
+// HandleInitProducerIdResponse applies the new producer id and epoch and resets all sequences. A
+// retriable error returns the producer to the state before InitProducerIdRequest so that the request
+// can be sent again, possibly to a new coordinator.
+func (p *Producer) HandleInitProducerIdResponse(res *initproducerid.InitProducerIdResponse) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state != Initializing {
+		return fmt.Errorf("%w: unexpected InitProducerId response in state %s", ErrInvalidState, p.state)
+	}
+
+	if err := errorcodes.ToError(res.ErrorCode, nil); err != nil {
+		if errorcodes.Retriable(res.ErrorCode) {
+			p.state = p.previousState
+			return err
+		}
+		return p.fail(err, false)
+	}
+
+	p.producerId = res.ProducerId
+	p.producerEpoch = res.ProducerEpoch
+	p.epochBump = false
+	p.resetSequences()
+	return p.transition(Ready)
+}
+
+////////////////////
+// Produce
+////////////////////
+
+// incrementSequence adds to a sequence number, which wraps around to zero after math.MaxInt32.
+func incrementSequence(sequence int32, increment int32) int32 {
+	if sequence > math.MaxInt32-increment {
+		return increment - (math.MaxInt32 - sequence) - 1
+	}
+	return sequence + increment
+}
+
+// Stamp assigns the producer id, epoch and the next sequence number of the partition to the batch and
+// marks it as transactional inside a transaction. A batch which is retried because of a retriable error
+// must be sent again as it is; it must only be stamped again after ErrEpochBumpRequired or
+// ErrStaleBatch.
+//
+// With transaction version 1, the partition is added to the transaction with the next
+// AddPartitionsToTxn request and the batch must not be sent before that request succeeded (see
+// CanSend). With version 2 the Produce request adds the partition implicitly.
+func (p *Producer) Stamp(tp TopicPartition, batch *records.RecordBatch) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case p.state == FatalError || p.state == AbortableError:
+		return fmt.Errorf("%w: cannot produce in state %s: %w", ErrInvalidState, p.state, p.lastError)
+	case p.epochBump:
+		return ErrEpochBumpRequired
+	case p.transactional() && p.state != InTransaction:
+		return fmt.Errorf("%w: cannot produce outside of a transaction in state %s", ErrInvalidState, p.state)
+	case !p.transactional() && p.state != Ready:
+		return fmt.Errorf("%w: cannot produce in state %s", ErrInvalidState, p.state)
+	}
+
+	state, ok := p.partitions[tp]
+	if !ok {
+		state = &partitionState{lastAcked: -1}
+		p.partitions[tp] = state
+	}
+
+	count := int32(len(batch.Records))
+	batch.ProducerId = p.producerId
+	batch.ProducerEpoch = p.producerEpoch
+	batch.BaseSequence = state.nextSequence
+	if p.transactional() {
+		batch.Attributes |= records.TransactionalAttribute
+	}
+	state.inflight = append(state.inflight, inflightBatch{baseSequence: state.nextSequence, count: count})
+	state.nextSequence = incrementSequence(state.nextSequence, count)
+
+	if p.transactional() && !p.txnPartitions[tp] {
+		if p.transactionV2() {
+			p.txnPartitions[tp] = true
+		} else {
+			p.pendingPartitions[tp] = true
+		}
+	}
+	return nil
+}
+
+// CanSend returns whether batches of the partition can be sent, that is whether the partition was
+// added to the current transaction. It is always true without transactions.
+func (p *Producer) CanSend(tp TopicPartition) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return !p.transactional() || p.txnPartitions[tp]
+}
+
+// Retriable returns whether the batch or request should be sent again unchanged after the error.
+func Retriable(err error) bool {
+	if errors.Is(err, ErrEarlierBatchPending) {
+		return true
+	}
+	var kafkaErr *errorcodes.Error
+	return errors.As(err, &kafkaErr) && errorcodes.Retriable(kafkaErr.Code)
+}
+
+// HandleProduceResponse interprets the result of a stamped batch. It returns nil when the batch was
+// written, including a DUPLICATE_SEQUENCE_NUMBER for a batch that was already written before. Any
+// other result is returned as an error:
+//
+//   - a retriable error (see Retriable) means that the batch should be sent again unchanged;
+//   - ErrEpochBumpRequired means that an idempotent producer lost its sequence and the batch should be
+//     stamped again after InitProducerId bumped the epoch (KIP-360);
+//   - ErrStaleBatch means that the batch was stamped before the last epoch bump and should be stamped
+//     again;
+//   - any other error fails the batch. Inside a transaction it moves the producer to AbortableError
+//     or FatalError.
+func (p *Producer) HandleProduceResponse(tp TopicPartition, batch *records.RecordBatch, res *produce.ProduceResponseResponsePartitionResponse) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stale := batch.ProducerId != p.producerId || batch.ProducerEpoch != p.producerEpoch
+	if res.ErrorCode == errorcodes.None || res.ErrorCode == errorcodes.DuplicateSequenceNumber {
+		if !stale {
+			p.acknowledge(tp, batch.BaseSequence)
+		}
+		return nil
+	}
+
+	err := errorcodes.ToError(res.ErrorCode, res.ErrorMessage)
+	if stale {
+		return fmt.Errorf("%w: %w", ErrStaleBatch, err)
+	}
+	if errorcodes.Retriable(res.ErrorCode) {
+		return err
+	}
+
+	switch res.ErrorCode {
+	case errorcodes.OutOfOrderSequenceNumber, errorcodes.UnknownProducerId:
+		// A batch after an earlier batch which is still being retried fails as well. It succeeds when
+		// it is retried after the earlier one.
+		if state := p.partitions[tp]; state != nil && len(state.inflight) > 0 && state.inflight[0].baseSequence != batch.BaseSequence {
+			return fmt.Errorf("%w: %w", ErrEarlierBatchPending, err)
+		}
+		p.dropInflight(tp)
+		if p.transactional() {
+			// The epoch is bumped when the transaction is aborted.
+			p.epochBump = !p.transactionV2()
+			return p.fail(err, true)
+		}
+		p.epochBump = true
+		return fmt.Errorf("%w: %w", ErrEpochBumpRequired, err)
+	case errorcodes.ProducerFenced, errorcodes.TransactionalIdAuthorizationFailed, errorcodes.ClusterAuthorizationFailed:
+		p.dropInflight(tp)
+		return p.fail(err, false)
+	case errorcodes.InvalidProducerEpoch, errorcodes.InvalidTxnState:
+		p.dropInflight(tp)
+		if p.transactional() {
+			return p.fail(err, p.transactionV2())
+		}
+		p.epochBump = true
+		return fmt.Errorf("%w: %w", ErrEpochBumpRequired, err)
+	default:
+		// The failed batch leaves a gap in the sequence numbers of the partition.
+		p.dropInflight(tp)
+		if p.transactional() {
+			return p.fail(err, true)
+		}
+		p.epochBump = true
+		return err
+	}
+}
+
+// acknowledge removes the batch and every batch before it from the in-flight batches.
+func (p *Producer) acknowledge(tp TopicPartition, baseSequence int32) {
+	state := p.partitions[tp]
+	if state == nil {
+		return
+	}
+
+	for i, b := range state.inflight {
+		if b.baseSequence == baseSequence {
+			state.lastAcked = incrementSequence(b.baseSequence, b.count-1)
+			state.inflight = state.inflight[i+1:]
+			return
+		}
+	}
+}
+
+func (p *Producer) dropInflight(tp TopicPartition) {
+	if state := p.partitions[tp]; state != nil {
+		state.inflight = nil
+	}
+}
+
+// LastAckedSequence returns the last sequence number of the partition acknowledged by the leader, or
+// -1 when none was acknowledged with the current producer epoch.
+func (p *Producer) LastAckedSequence(tp TopicPartition) int32 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if state := p.partitions[tp]; state != nil {
+		return state.lastAcked
+	}
+	return -1
+}
@@ -0,0 +1,410 @@
+package producerstate
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/scholzj/go-kafka-protocol/api/addoffsetstotxn"
+	"github.com/scholzj/go-kafka-protocol/api/addpartitionstotxn"
+	"github.com/scholzj/go-kafka-protocol/api/endtxn"
+	"github.com/scholzj/go-kafka-protocol/api/initproducerid"
+	"github.com/scholzj/go-kafka-protocol/api/produce"
+	"github.com/scholzj/go-kafka-protocol/api/txnoffsetcommit"
+	"github.com/scholzj/go-kafka-protocol/compression"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+var orders0 = TopicPartition{Topic: "orders", Partition: 0}
+var orders1 = TopicPartition{Topic: "orders", Partition: 1}
+
+func newBatch(count int) *records.RecordBatch {
+	batch := records.NewRecordBatch(0, compression.None)
+	for i := 0; i < count; i++ {
+		batch.AppendRecord(1700000000000, nil, []byte("value"), nil)
+	}
+	return batch
+}
+
+func initialized(t *testing.T, transactionalId string, transactionVersion int16) *Producer {
+	t.Helper()
+
+	p := NewProducer(transactionalId)
+	p.TransactionVersion = transactionVersion
+	if !p.NeedsInitProducerId() {
+		t.Fatal("a new producer must be initialized")
+	}
+	request, err := p.InitProducerIdRequest(5)
+	if err != nil {
+		t.Fatalf("InitProducerIdRequest: %v", err)
+	}
+	if request.ProducerId != -1 || request.ProducerEpoch != -1 || request.TransactionTimeoutMs != 60000 {
+		t.Fatalf("unexpected request %+v", request)
+	}
+	if err := p.HandleInitProducerIdResponse(&initproducerid.InitProducerIdResponse{ApiVersion: 5, ProducerId: 1000, ProducerEpoch: 0}); err != nil {
+		t.Fatalf("HandleInitProducerIdResponse: %v", err)
+	}
+	return p
+}
+
+func stamp(t *testing.T, p *Producer, tp TopicPartition, count int) *records.RecordBatch {
+	t.Helper()
+
+	batch := newBatch(count)
+	if err := p.Stamp(tp, batch); err != nil {
+		t.Fatalf("Stamp: %v", err)
+	}
+	return batch
+}
+
+func produceResult(code int16) *produce.ProduceResponseResponsePartitionResponse {
+	return &produce.ProduceResponseResponsePartitionResponse{ErrorCode: code}
+}
+
+func TestIncrementSequence(t *testing.T) {
+	tests := []struct {
+		sequence  int32
+		increment int32
+		expected  int32
+	}{
+		{0, 5, 5},
+		{math.MaxInt32 - 5, 5, math.MaxInt32},
+		{math.MaxInt32 - 4, 5, 0},
+		{math.MaxInt32, 1, 0},
+		{math.MaxInt32 - 1, 10, 8},
+	}
+
+	for _, test := range tests {
+		if got := incrementSequence(test.sequence, test.increment); got != test.expected {
+			t.Errorf("incrementSequence(%d, %d) = %d, expected %d", test.sequence, test.increment, got, test.expected)
+		}
+	}
+}
+
+func TestIdempotentSequences(t *testing.T) {
+	p := initialized(t, "", 0)
+
+	first := stamp(t, p, orders0, 3)
+	second := stamp(t, p, orders0, 2)
+	other := stamp(t, p, orders1, 1)
+	if first.ProducerId != 1000 || first.ProducerEpoch != 0 || first.BaseSequence != 0 || second.BaseSequence != 3 || other.BaseSequence != 0 {
+		t.Fatalf("unexpected sequences %d, %d and %d", first.BaseSequence, second.BaseSequence, other.BaseSequence)
+	}
+	if first.IsTransactional() {
+		t.Error("an idempotent batch must not be transactional")
+	}
+
+	// The second batch fails because the first one is retried.
+	if err := p.HandleProduceResponse(orders0, first, produceResult(errorcodes.NotLeaderOrFollower)); !Retriable(err) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := p.HandleProduceResponse(orders0, second, produceResult(errorcodes.OutOfOrderSequenceNumber)); !Retriable(err) || !errors.Is(err, ErrEarlierBatchPending) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := p.HandleProduceResponse(orders0, first, produceResult(errorcodes.None)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := p.HandleProduceResponse(orders0, second, produceResult(errorcodes.DuplicateSequenceNumber)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if acked := p.LastAckedSequence(orders0); acked != 4 {
+		t.Errorf("unexpected last acknowledged sequence %d", acked)
+	}
+}
+
+func TestIdempotentEpochBump(t *testing.T) {
+	p := initialized(t, "", 0)
+
+	batch := stamp(t, p, orders0, 2)
+	stamp(t, p, orders1, 2)
+	if err := p.HandleProduceResponse(orders0, batch, produceResult(errorcodes.OutOfOrderSequenceNumber)); !errors.Is(err, ErrEpochBumpRequired) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := p.Stamp(orders0, newBatch(1)); !errors.Is(err, ErrEpochBumpRequired) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !p.NeedsInitProducerId() {
+		t.Fatal("the producer does not request an epoch bump")
+	}
+
+	// KIP-360 keeps the producer id.
+	request, err := p.InitProducerIdRequest(4)
+	if err != nil {
+		t.Fatalf("InitProducerIdRequest: %v", err)
+	}
+	if request.TransactionalId != nil || request.ProducerId != 1000 || request.ProducerEpoch != 0 {
+		t.Fatalf("unexpected request %+v", request)
+	}
+
+	// A retriable error allows the request to be sent again.
+	if err := p.HandleInitProducerIdResponse(&initproducerid.InitProducerIdResponse{ErrorCode: errorcodes.CoordinatorLoadInProgress}); !Retriable(err) || p.State() != Ready {
+		t.Fatalf("unexpected error %v in state %s", err, p.State())
+	}
+	if _, err := p.InitProducerIdRequest(4); err != nil {
+		t.Fatalf("InitProducerIdRequest: %v", err)
+	}
+	if err := p.HandleInitProducerIdResponse(&initproducerid.InitProducerIdResponse{ProducerId: 1000, ProducerEpoch: 1}); err != nil {
+		t.Fatalf("HandleInitProducerIdResponse: %v", err)
+	}
+
+	// The old batch is stale and every partition starts from zero again.
+	if err := p.HandleProduceResponse(orders0, batch, produceResult(errorcodes.NotLeaderOrFollower)); !errors.Is(err, ErrStaleBatch) {
+		t.Errorf("unexpected error %v", err)
+	}
+	for _, tp := range []TopicPartition{orders0, orders1} {
+		if b := stamp(t, p, tp, 1); b.ProducerEpoch != 1 || b.BaseSequence != 0 {
+			t.Errorf("%s: unexpected epoch %d and sequence %d", tp, b.ProducerEpoch, b.BaseSequence)
+		}
+	}
+
+	// The first version without KIP-360 does not send the producer id.
+	if request, _ := NewProducer("").InitProducerIdRequest(2); request.ProducerId != -1 {
+		t.Errorf("unexpected request %+v", request)
+	}
+}
+
+func TestTransactionV1(t *testing.T) {
+	p := initialized(t, "txn", 1)
+
+	if err := p.Stamp(orders0, newBatch(1)); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := p.BeginTransaction(); err != nil {
+		t.Fatalf("BeginTransaction: %v", err)
+	}
+
+	batch := stamp(t, p, orders0, 2)
+	stamp(t, p, orders1, 1)
+	stamp(t, p, TopicPartition{Topic: "audit", Partition: 3}, 1)
+	if !batch.IsTransactional() || p.CanSend(orders0) {
+		t.Fatal("the batch must wait for AddPartitionsToTxn")
+	}
+	if _, err := p.EndTxnRequest(3, true); !errors.Is(err, ErrPartitionNotInTxn) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	request, err := p.AddPartitionsToTxnRequest(3)
+	if err != nil {
+		t.Fatalf("AddPartitionsToTxnRequest: %v", err)
+	}
+	topics := *request.V3AndBelowTopics
+	if *request.V3AndBelowTransactionalId != "txn" || len(topics) != 2 || *topics[0].Name != "audit" || *topics[1].Name != "orders" || len(*topics[1].Partitions) != 2 {
+		t.Fatalf("unexpected request %+v", request)
+	}
+	if err := request.Write(new(bytes.Buffer)); err != nil {
+		t.Fatalf("failed to encode the request: %v", err)
+	}
+
+	orders, audit := "orders", "audit"
+	response := &addpartitionstotxn.AddPartitionsToTxnResponse{ApiVersion: 3, ResultsByTopicV3AndBelow: &[]addpartitionstotxn.AddPartitionsToTxnResponseResultsByTopicV3AndBelow{
+		{Name: &audit, ResultsByPartition: &[]addpartitionstotxn.AddPartitionsToTxnResponseResultsByTopicV3AndBelowResultsByPartition{{PartitionIndex: 3, PartitionErrorCode: errorcodes.ConcurrentTransactions}}},
+		{Name: &orders, ResultsByPartition: &[]addpartitionstotxn.AddPartitionsToTxnResponseResultsByTopicV3AndBelowResultsByPartition{{PartitionIndex: 0}, {PartitionIndex: 1}}},
+	}}
+	if err := p.HandleAddPartitionsToTxnResponse(response); !Retriable(err) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !p.CanSend(orders0) || p.CanSend(TopicPartition{Topic: "audit", Partition: 3}) {
+		t.Fatal("unexpected sendable partitions")
+	}
+	request, _ = p.AddPartitionsToTxnRequest(3)
+	if topics := *request.V3AndBelowTopics; len(topics) != 1 || *topics[0].Name != "audit" {
+		t.Fatalf("unexpected request %+v", request)
+	}
+	(*response.ResultsByTopicV3AndBelow)[0] = addpartitionstotxn.AddPartitionsToTxnResponseResultsByTopicV3AndBelow{Name: &audit, ResultsByPartition: &[]addpartitionstotxn.AddPartitionsToTxnResponseResultsByTopicV3AndBelowResultsByPartition{{PartitionIndex: 3}}}
+	if err := p.HandleAddPartitionsToTxnResponse(response); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// The offsets of the group need AddOffsetsToTxn first.
+	offsets := map[TopicPartition]CommittedOffset{{Topic: "input", Partition: 0}: {Offset: 42, LeaderEpoch: -1}}
+	if _, err := p.TxnOffsetCommitRequest(3, "group", GroupMetadata{GenerationId: -1}, offsets); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	addOffsets, err := p.AddOffsetsToTxnRequest(3, "group")
+	if err != nil || addOffsets == nil || *addOffsets.GroupId != "group" {
+		t.Fatalf("unexpected request %+v (%v)", addOffsets, err)
+	}
+	if err := p.HandleAddOffsetsToTxnResponse(&addoffsetstotxn.AddOffsetsToTxnResponse{}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	commit, err := p.TxnOffsetCommitRequest(3, "group", GroupMetadata{GenerationId: 5, MemberId: "member"}, offsets)
+	if err != nil {
+		t.Fatalf("TxnOffsetCommitRequest: %v", err)
+	}
+	if commit.GenerationId != 5 || *commit.MemberId != "member" || (*(*commit.Topics)[0].Partitions)[0].CommittedOffset != 42 {
+		t.Fatalf("unexpected request %+v", commit)
+	}
+	if err := p.HandleTxnOffsetCommitResponse("group", &txnoffsetcommit.TxnOffsetCommitResponse{Topics: &[]txnoffsetcommit.TxnOffsetCommitResponseTopic{}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	end, err := p.EndTxnRequest(3, true)
+	if err != nil || end == nil || !end.Committed || end.ProducerId != 1000 {
+		t.Fatalf("unexpected request %+v (%v)", end, err)
+	}
+	if err := p.HandleEndTxnResponse(&endtxn.EndTxnResponse{ApiVersion: 3, ErrorCode: errorcodes.ConcurrentTransactions}); !Retriable(err) || p.State() != CommittingTransaction {
+		t.Fatalf("unexpected error %v in state %s", err, p.State())
+	}
+	if err := p.HandleEndTxnResponse(&endtxn.EndTxnResponse{ApiVersion: 3}); err != nil || p.State() != Ready {
+		t.Fatalf("unexpected error %v in state %s", err, p.State())
+	}
+
+	// Version 1 keeps the epoch and the sequences.
+	if err := p.BeginTransaction(); err != nil {
+		t.Fatalf("BeginTransaction: %v", err)
+	}
+	if b := stamp(t, p, orders0, 1); b.ProducerEpoch != 0 || b.BaseSequence != 2 {
+		t.Errorf("unexpected epoch %d and sequence %d", b.ProducerEpoch, b.BaseSequence)
+	}
+}
+
+func TestTransactionV1AbortableError(t *testing.T) {
+	p := initialized(t, "txn", 1)
+	if err := p.BeginTransaction(); err != nil {
+		t.Fatalf("BeginTransaction: %v", err)
+	}
+	batch := stamp(t, p, orders0, 1)
+	if _, err := p.AddPartitionsToTxnRequest(3); err != nil {
+		t.Fatalf("AddPartitionsToTxnRequest: %v", err)
+	}
+	orders := "orders"
+	if err := p.HandleAddPartitionsToTxnResponse(&addpartitionstotxn.AddPartitionsToTxnResponse{ResultsByTopicV3AndBelow: &[]addpartitionstotxn.AddPartitionsToTxnResponseResultsByTopicV3AndBelow{
+		{Name: &orders, ResultsByPartition: &[]addpartitionstotxn.AddPartitionsToTxnResponseResultsByTopicV3AndBelowResultsByPartition{{PartitionIndex: 0}}},
+	}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := p.HandleProduceResponse(orders0, batch, produceResult(errorcodes.OutOfOrderSequenceNumber)); err == nil || p.State() != AbortableError {
+		t.Fatalf("unexpected error %v in state %s", err, p.State())
+	}
+	if err := p.Stamp(orders0, newBatch(1)); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := p.EndTxnRequest(3, true); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	end, err := p.EndTxnRequest(3, false)
+	if err != nil || end == nil || end.Committed {
+		t.Fatalf("unexpected request %+v (%v)", end, err)
+	}
+	if err := p.HandleEndTxnResponse(&endtxn.EndTxnResponse{ApiVersion: 3}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// The lost sequence requires an epoch bump before the next transaction.
+	if err := p.BeginTransaction(); !errors.Is(err, ErrEpochBumpRequired) || !p.NeedsInitProducerId() {
+		t.Fatalf("unexpected error %v", err)
+	}
+	init, err := p.InitProducerIdRequest(4)
+	if err != nil || *init.TransactionalId != "txn" || init.ProducerId != 1000 || init.ProducerEpoch != 0 {
+		t.Fatalf("unexpected request %+v (%v)", init, err)
+	}
+	if err := p.HandleInitProducerIdResponse(&initproducerid.InitProducerIdResponse{ProducerId: 1000, ProducerEpoch: 1}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := p.BeginTransaction(); err != nil {
+		t.Fatalf("BeginTransaction: %v", err)
+	}
+}
+
+func TestTransactionV2(t *testing.T) {
+	p := initialized(t, "txn", 2)
+	if err := p.BeginTransaction(); err != nil {
+		t.Fatalf("BeginTransaction: %v", err)
+	}
+
+	stamp(t, p, orders0, 3)
+	if !p.CanSend(orders0) {
+		t.Fatal("Produce adds the partition implicitly")
+	}
+	if request, err := p.AddPartitionsToTxnRequest(3); request != nil || err != nil {
+		t.Fatalf("unexpected request %+v (%v)", request, err)
+	}
+	if request, err := p.AddOffsetsToTxnRequest(4, "group"); request != nil || err != nil {
+		t.Fatalf("unexpected request %+v (%v)", request, err)
+	}
+	offsets := map[TopicPartition]CommittedOffset{{Topic: "input", Partition: 0}: {Offset: 42, LeaderEpoch: 3}}
+	if _, err := p.TxnOffsetCommitRequest(4, "group", GroupMetadata{GenerationId: -1}, offsets); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := p.TxnOffsetCommitRequest(5, "group", GroupMetadata{GenerationId: -1}, offsets); err != nil {
+		t.Fatalf("TxnOffsetCommitRequest: %v", err)
+	}
+	if _, err := p.EndTxnRequest(4, true); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if _, err := p.EndTxnRequest(5, true); err != nil {
+		t.Fatalf("EndTxnRequest: %v", err)
+	}
+	if err := p.HandleEndTxnResponse(&endtxn.EndTxnResponse{ApiVersion: 5, ProducerId: 1000, ProducerEpoch: 1}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if id, epoch := p.ProducerIdAndEpoch(); id != 1000 || epoch != 1 {
+		t.Fatalf("unexpected producer id %d and epoch %d", id, epoch)
+	}
+
+	// Every transaction starts with a new epoch and sequence zero.
+	if err := p.BeginTransaction(); err != nil {
+		t.Fatalf("BeginTransaction: %v", err)
+	}
+	if b := stamp(t, p, orders0, 1); b.ProducerEpoch != 1 || b.BaseSequence != 0 {
+		t.Errorf("unexpected epoch %d and sequence %d", b.ProducerEpoch, b.BaseSequence)
+	}
+}
+
+func TestEmptyTransaction(t *testing.T) {
+	p := initialized(t, "txn", 1)
+	if err := p.BeginTransaction(); err != nil {
+		t.Fatalf("BeginTransaction: %v", err)
+	}
+	if request, err := p.EndTxnRequest(3, true); request != nil || err != nil || p.State() != Ready {
+		t.Fatalf("unexpected request %+v (%v) in state %s", request, err, p.State())
+	}
+}
+
+func TestFatalErrors(t *testing.T) {
+	p := initialized(t, "txn", 1)
+	if err := p.BeginTransaction(); err != nil {
+		t.Fatalf("BeginTransaction: %v", err)
+	}
+	if _, err := p.AddOffsetsToTxnRequest(3, "group"); err != nil {
+		t.Fatalf("AddOffsetsToTxnRequest: %v", err)
+	}
+	if err := p.HandleAddOffsetsToTxnResponse(&addoffsetstotxn.AddOffsetsToTxnResponse{ErrorCode: errorcodes.ProducerFenced}); err == nil || p.State() != FatalError {
+		t.Fatalf("unexpected error %v in state %s", err, p.State())
+	}
+
+	var kafkaErr *errorcodes.Error
+	if !errors.As(p.LastError(), &kafkaErr) || kafkaErr.Code != errorcodes.ProducerFenced {
+		t.Errorf("unexpected last error %v", p.LastError())
+	}
+	if _, err := p.EndTxnRequest(3, false); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := p.InitProducerIdRequest(4); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if err := NewProducer("").BeginTransaction(); !errors.Is(err, ErrNotTransactional) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestTxnOffsetCommitFencedMember(t *testing.T) {
+	p := initialized(t, "txn", 2)
+	if err := p.BeginTransaction(); err != nil {
+		t.Fatalf("BeginTransaction: %v", err)
+	}
+
+	input := "input"
+	response := &txnoffsetcommit.TxnOffsetCommitResponse{Topics: &[]txnoffsetcommit.TxnOffsetCommitResponseTopic{
+		{Name: &input, Partitions: &[]txnoffsetcommit.TxnOffsetCommitResponseTopicPartition{{PartitionIndex: 0, ErrorCode: errorcodes.IllegalGeneration}}},
+	}}
+	if err := p.HandleTxnOffsetCommitResponse("group", response); err == nil || p.State() != AbortableError {
+		t.Fatalf("unexpected error %v in state %s", err, p.State())
+	}
+}
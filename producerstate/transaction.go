@@ -0,0 +1,402 @@
+package producerstate
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/scholzj/go-kafka-protocol/api/addoffsetstotxn"
+	"github.com/scholzj/go-kafka-protocol/api/addpartitionstotxn"
+	"github.com/scholzj/go-kafka-protocol/api/endtxn"
+	"github.com/scholzj/go-kafka-protocol/api/txnoffsetcommit"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+// GroupMetadata identifies the consumer group member whose offsets are committed with
+// TxnOffsetCommit v3+, so that the group coordinator can fence zombie members (KIP-447). A member
+// which does not know its generation uses generation -1 and an empty member id.
+type GroupMetadata struct {
+	GenerationId    int32
+	MemberId        string
+	GroupInstanceId *string
+}
+
+// CommittedOffset is the offset of a partition committed with TxnOffsetCommit.
+type CommittedOffset struct {
+	Offset      int64
+	LeaderEpoch int32 // The leader epoch of the last consumed record, or -1.
+	Metadata    *string
+}
+
+// BeginTransaction starts a transaction.
+func (p *Producer) BeginTransaction() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.transactional() {
+		return ErrNotTransactional
+	}
+	if p.state == Ready && p.epochBump {
+		return ErrEpochBumpRequired
+	}
+	if err := p.transition(InTransaction); err != nil {
+		return err
+	}
+
+	p.pendingPartitions = make(map[TopicPartition]bool)
+	p.txnPartitions = make(map[TopicPartition]bool)
+	p.pendingGroup = ""
+	p.txnGroups = make(map[string]bool)
+	return nil
+}
+
+// transactionError interprets the error code of a transaction coordinator response. It returns nil
+// for None, the error itself for retriable errors, and otherwise moves the producer to AbortableError
+// or FatalError.
+func (p *Producer) transactionError(code int16, abortable ...int16) error {
+	err := errorcodes.ToError(code, nil)
+	if err == nil || errorcodes.Retriable(code) {
+		return err
+	}
+
+	switch code {
+	case errorcodes.TransactionAbortable:
+		return p.fail(err, true)
+	case errorcodes.InvalidProducerEpoch:
+		// Transaction version 2 bumps the epoch after every transaction, so a late request of the last
+		// transaction only needs the current one to be aborted.
+		return p.fail(err, p.transactionV2())
+	}
+	for _, c := range abortable {
+		if c == code {
+			return p.fail(err, true)
+		}
+	}
+	return p.fail(err, false)
+}
+
+////////////////////
+// AddPartitionsToTxn and AddOffsetsToTxn
+////////////////////
+
+// AddPartitionsToTxnRequest returns the request which adds the partitions used since the last request
+// to the transaction, or nil when there are none. With transaction version 2 the partitions are added
+// implicitly and no request is needed. Clients use versions 0 to 3; later versions are only sent
+// between brokers.
+func (p *Producer) AddPartitionsToTxnRequest(version int16) (*addpartitionstotxn.AddPartitionsToTxnRequest, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.transactional() {
+		return nil, ErrNotTransactional
+	}
+	if version > 3 {
+		return nil, fmt.Errorf("%w: AddPartitionsToTxn version %d is only used between brokers", ErrUnsupportedVersion, version)
+	}
+	if p.state != InTransaction {
+		return nil, fmt.Errorf("%w: cannot add partitions in state %s", ErrInvalidState, p.state)
+	}
+	if len(p.pendingPartitions) == 0 {
+		return nil, nil
+	}
+
+	byTopic := make(map[string][]int32)
+	for tp := range p.pendingPartitions {
+		byTopic[tp.Topic] = append(byTopic[tp.Topic], tp.Partition)
+	}
+	names := make([]string, 0, len(byTopic))
+	for name := range byTopic {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	topics := make([]addpartitionstotxn.AddPartitionsToTxnRequestV3AndBelowTopic, 0, len(names))
+	for _, name := range names {
+		partitions := byTopic[name]
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+		topics = append(topics, addpartitionstotxn.AddPartitionsToTxnRequestV3AndBelowTopic{Name: &name, Partitions: &partitions})
+	}
+
+	return &addpartitionstotxn.AddPartitionsToTxnRequest{
+		ApiVersion:                version,
+		Transactions:              &[]addpartitionstotxn.AddPartitionsToTxnRequestTransaction{},
+		V3AndBelowTransactionalId: &p.TransactionalId,
+		V3AndBelowProducerId:      p.producerId,
+		V3AndBelowProducerEpoch:   p.producerEpoch,
+		V3AndBelowTopics:          &topics,
+	}, nil
+}
+
+// HandleAddPartitionsToTxnResponse marks the partitions which were added as sendable. It returns a
+// retriable error when some partitions still need to be added with a new request.
+func (p *Producer) HandleAddPartitionsToTxnResponse(res *addpartitionstotxn.AddPartitionsToTxnResponse) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state != InTransaction {
+		return fmt.Errorf("%w: unexpected AddPartitionsToTxn response in state %s", ErrInvalidState, p.state)
+	}
+	if res.ResultsByTopicV3AndBelow == nil {
+		return nil
+	}
+
+	var retriable error
+	for _, topic := range *res.ResultsByTopicV3AndBelow {
+		if topic.ResultsByPartition == nil {
+			continue
+		}
+
+		for _, result := range *topic.ResultsByPartition {
+			tp := TopicPartition{Topic: deref(topic.Name), Partition: result.PartitionIndex}
+			switch {
+			case result.PartitionErrorCode == errorcodes.None:
+				delete(p.pendingPartitions, tp)
+				p.txnPartitions[tp] = true
+			case result.PartitionErrorCode == errorcodes.OperationNotAttempted:
+				// Another partition of the request failed.
+				continue
+			case errorcodes.Retriable(result.PartitionErrorCode):
+				if retriable == nil {
+					retriable = fmt.Errorf("partition %s: %w", tp, errorcodes.ToError(result.PartitionErrorCode, nil))
+				}
+			default:
+				if err := p.transactionError(result.PartitionErrorCode, errorcodes.TopicAuthorizationFailed); err != nil {
+					return fmt.Errorf("partition %s: %w", tp, err)
+				}
+			}
+		}
+	}
+	return retriable
+}
+
+// AddOffsetsToTxnRequest returns the request which adds the offsets of the consumer group to the
+// transaction. It must succeed before TxnOffsetCommitRequest. With transaction version 2 the group is
+// added implicitly by TxnOffsetCommit and no request is needed.
+func (p *Producer) AddOffsetsToTxnRequest(version int16, groupId string) (*addoffsetstotxn.AddOffsetsToTxnRequest, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.transactional() {
+		return nil, ErrNotTransactional
+	}
+	if p.state != InTransaction {
+		return nil, fmt.Errorf("%w: cannot add offsets in state %s", ErrInvalidState, p.state)
+	}
+	if p.transactionV2() || p.txnGroups[groupId] {
+		return nil, nil
+	}
+
+	p.pendingGroup = groupId
+	return &addoffsetstotxn.AddOffsetsToTxnRequest{
+		ApiVersion:      version,
+		TransactionalId: &p.TransactionalId,
+		ProducerId:      p.producerId,
+		ProducerEpoch:   p.producerEpoch,
+		GroupId:         &groupId,
+	}, nil
+}
+
+func (p *Producer) HandleAddOffsetsToTxnResponse(res *addoffsetstotxn.AddOffsetsToTxnResponse) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state != InTransaction || p.pendingGroup == "" {
+		return fmt.Errorf("%w: unexpected AddOffsetsToTxn response in state %s", ErrInvalidState, p.state)
+	}
+
+	if err := p.transactionError(res.ErrorCode, errorcodes.GroupAuthorizationFailed); err != nil {
+		return err
+	}
+	p.txnGroups[p.pendingGroup] = true
+	p.pendingGroup = ""
+	return nil
+}
+
+////////////////////
+// TxnOffsetCommit
+////////////////////
+
+// TxnOffsetCommitRequest returns the request which commits the offsets of the consumer group as part
+// of the transaction. With transaction version 1 the group must have been added with AddOffsetsToTxn
+// first; version 2 requires TxnOffsetCommit v5+.
+func (p *Producer) TxnOffsetCommitRequest(version int16, groupId string, group GroupMetadata, offsets map[TopicPartition]CommittedOffset) (*txnoffsetcommit.TxnOffsetCommitRequest, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.transactional() {
+		return nil, ErrNotTransactional
+	}
+	if p.state != InTransaction {
+		return nil, fmt.Errorf("%w: cannot commit offsets in state %s", ErrInvalidState, p.state)
+	}
+	if p.transactionV2() && version < 5 {
+		return nil, fmt.Errorf("%w: transaction version 2 requires TxnOffsetCommit v5+, not v%d", ErrUnsupportedVersion, version)
+	}
+	if !p.transactionV2() && !p.txnGroups[groupId] {
+		return nil, fmt.Errorf("%w: group %s has not been added to the transaction", ErrInvalidState, groupId)
+	}
+
+	byTopic := make(map[string][]txnoffsetcommit.TxnOffsetCommitRequestTopicPartition)
+	for tp, offset := range offsets {
+		byTopic[tp.Topic] = append(byTopic[tp.Topic], txnoffsetcommit.TxnOffsetCommitRequestTopicPartition{
+			PartitionIndex:       tp.Partition,
+			CommittedOffset:      offset.Offset,
+			CommittedLeaderEpoch: offset.LeaderEpoch,
+			CommittedMetadata:    offset.Metadata,
+		})
+	}
+	names := make([]string, 0, len(byTopic))
+	for name := range byTopic {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	topics := make([]txnoffsetcommit.TxnOffsetCommitRequestTopic, 0, len(names))
+	for _, name := range names {
+		partitions := byTopic[name]
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i].PartitionIndex < partitions[j].PartitionIndex })
+		topics = append(topics, txnoffsetcommit.TxnOffsetCommitRequestTopic{Name: &name, Partitions: &partitions})
+	}
+
+	memberId := group.MemberId
+	return &txnoffsetcommit.TxnOffsetCommitRequest{
+		ApiVersion:      version,
+		TransactionalId: &p.TransactionalId,
+		GroupId:         &groupId,
+		ProducerId:      p.producerId,
+		ProducerEpoch:   p.producerEpoch,
+		GenerationId:    group.GenerationId,
+		MemberId:        &memberId,
+		GroupInstanceId: group.GroupInstanceId,
+		Topics:          &topics,
+	}, nil
+}
+
+// HandleTxnOffsetCommitResponse interprets the response of TxnOffsetCommitRequest. A retriable error
+// means that the request should be sent again. A fenced or unknown group member makes the transaction
+// abortable, since its offsets must not be committed.
+func (p *Producer) HandleTxnOffsetCommitResponse(groupId string, res *txnoffsetcommit.TxnOffsetCommitResponse) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state != InTransaction {
+		return fmt.Errorf("%w: unexpected TxnOffsetCommit response in state %s", ErrInvalidState, p.state)
+	}
+
+	var retriable error
+	if res.Topics != nil {
+		for _, topic := range *res.Topics {
+			if topic.Partitions == nil {
+				continue
+			}
+
+			for _, result := range *topic.Partitions {
+				tp := TopicPartition{Topic: deref(topic.Name), Partition: result.PartitionIndex}
+				switch {
+				case result.ErrorCode == errorcodes.None:
+					continue
+				case errorcodes.Retriable(result.ErrorCode):
+					if retriable == nil {
+						retriable = fmt.Errorf("partition %s: %w", tp, errorcodes.ToError(result.ErrorCode, nil))
+					}
+				default:
+					err := p.transactionError(result.ErrorCode,
+						errorcodes.UnknownMemberId,
+						errorcodes.IllegalGeneration,
+						errorcodes.FencedInstanceId,
+						errorcodes.GroupAuthorizationFailed,
+						errorcodes.TopicAuthorizationFailed)
+					return fmt.Errorf("partition %s: %w", tp, err)
+				}
+			}
+		}
+	}
+	if retriable != nil {
+		return retriable
+	}
+
+	p.txnGroups[groupId] = true
+	return nil
+}
+
+////////////////////
+// EndTxn
+////////////////////
+
+// EndTxnRequest returns the request which commits or aborts the transaction. A transaction without
+// partitions and offsets ends without a request, in which case nil is returned and the producer is
+// ready for the next transaction. A transaction in AbortableError can only be aborted.
+func (p *Producer) EndTxnRequest(version int16, commit bool) (*endtxn.EndTxnRequest, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.transactional() {
+		return nil, ErrNotTransactional
+	}
+	if p.transactionV2() && version < 5 {
+		return nil, fmt.Errorf("%w: transaction version 2 requires EndTxn v5+, not v%d", ErrUnsupportedVersion, version)
+	}
+	if commit && p.state == InTransaction && len(p.pendingPartitions) > 0 {
+		return nil, fmt.Errorf("%w: %d partitions wait for AddPartitionsToTxn", ErrPartitionNotInTxn, len(p.pendingPartitions))
+	}
+
+	to := AbortingTransaction
+	if commit {
+		to = CommittingTransaction
+	}
+	if err := p.transition(to); err != nil {
+		return nil, err
+	}
+
+	if len(p.txnPartitions) == 0 && len(p.txnGroups) == 0 {
+		p.lastError = nil
+		return nil, p.transition(Ready)
+	}
+
+	return &endtxn.EndTxnRequest{
+		ApiVersion:      version,
+		TransactionalId: &p.TransactionalId,
+		ProducerId:      p.producerId,
+		ProducerEpoch:   p.producerEpoch,
+		Committed:       commit,
+	}, nil
+}
+
+// HandleEndTxnResponse completes the transaction. With transaction version 2 the response carries the
+// bumped producer epoch (or a new producer id once the epoch is exhausted), and the sequences start
+// again from zero. A retriable error means that the same request should be sent again.
+func (p *Producer) HandleEndTxnResponse(res *endtxn.EndTxnResponse) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state != CommittingTransaction && p.state != AbortingTransaction {
+		return fmt.Errorf("%w: unexpected EndTxn response in state %s", ErrInvalidState, p.state)
+	}
+
+	if err := errorcodes.ToError(res.ErrorCode, nil); err != nil {
+		if errorcodes.Retriable(res.ErrorCode) {
+			return err
+		}
+		if res.ErrorCode == errorcodes.TransactionAbortable && p.state == CommittingTransaction {
+			return p.fail(err, true)
+		}
+		return p.fail(err, false)
+	}
+
+	if p.transactionV2() && res.ApiVersion >= 5 {
+		if res.ProducerId != p.producerId || res.ProducerEpoch != p.producerEpoch {
+			p.producerId = res.ProducerId
+			p.producerEpoch = res.ProducerEpoch
+			p.resetSequences()
+		}
+		p.epochBump = false
+	}
+	p.lastError = nil
+	return p.transition(Ready)
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,290 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/google/uuid"
+)
+
+////////////////////
+// Decoder and Encoder for versioned schema messages
+////////////////////
+
+// Decoder reads a versioned schema message (for example a KRaft metadata record or a record stored
+// in an internal topic) field by field. The first error sticks: every later read returns a zero
+// value, so a message can read all of its fields and check Err once at the end. Strings, bytes and
+// arrays use the compact encodings when the decoder is flexible.
+type Decoder struct {
+	r        io.Reader
+	flexible bool
+	err      error
+}
+
+func NewDecoder(r io.Reader, flexible bool) *Decoder {
+	return &Decoder{r: r, flexible: flexible}
+}
+
+// Err returns the first error the decoder ran into.
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+// Flexible reports whether the decoder reads the compact (flexible version) encodings.
+func (d *Decoder) Flexible() bool {
+	return d.flexible
+}
+
+// Fail records err as the decoder error unless an earlier error has been recorded already. Message
+// decoders use it to report semantic problems, such as a length that does not fit the field.
+func (d *Decoder) Fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func decode[T any](d *Decoder, read func(io.Reader) (T, error)) T {
+	var v T
+	if d.err != nil {
+		return v
+	}
+
+	v, d.err = read(d.r)
+	return v
+}
+
+func (d *Decoder) Bool() bool       { return decode(d, ReadBool) }
+func (d *Decoder) Int8() int8       { return decode(d, ReadInt8) }
+func (d *Decoder) Int16() int16     { return decode(d, ReadInt16) }
+func (d *Decoder) Int32() int32     { return decode(d, ReadInt32) }
+func (d *Decoder) Int64() int64     { return decode(d, ReadInt64) }
+func (d *Decoder) Uint16() uint16   { return decode(d, ReadUInt16) }
+func (d *Decoder) Float64() float64 { return decode(d, ReadFloat64) }
+func (d *Decoder) UUID() uuid.UUID  { return decode(d, ReadUUID) }
+
+func (d *Decoder) String() string {
+	if d.flexible {
+		return decode(d, ReadCompactString)
+	}
+	return decode(d, ReadString)
+}
+
+func (d *Decoder) NullableString() *string {
+	if d.flexible {
+		return decode(d, ReadNullableCompactString)
+	}
+	return decode(d, ReadNullableString)
+}
+
+func (d *Decoder) Bytes() []byte {
+	if d.flexible {
+		return decode(d, ReadCompactBytes)
+	}
+	return decode(d, ReadBytes)
+}
+
+// NullableBytes reads a nullable bytes field. A null value decodes to nil.
+func (d *Decoder) NullableBytes() []byte {
+	var v *[]byte
+	if d.flexible {
+		v = decode(d, ReadNullableCompactBytes)
+	} else {
+		v = decode(d, ReadNullableBytes)
+	}
+
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+// ArrayLength reads an array length and returns -1 for a null array.
+func (d *Decoder) ArrayLength() int {
+	if !d.flexible {
+		return int(d.Int32())
+	}
+
+	length := decode(d, ReadUvarint)
+	return int(length) - 1
+}
+
+// TaggedFields reads the tagged fields section of a flexible message. The field function decodes a
+// known tag from the decoder it is given and reports false for unknown tags, which are skipped. The
+// section is not present in non-flexible versions, so nothing is read for a non-flexible decoder.
+func (d *Decoder) TaggedFields(field func(d *Decoder, tag uint64) bool) {
+	if d.err != nil || !d.flexible {
+		return
+	}
+
+	d.err = ReadTaggedFields(d.r, func(r io.Reader, tag uint64, _ uint64) error {
+		tagged := &Decoder{r: r, flexible: true}
+		if !field(tagged, tag) {
+			_, err := io.Copy(io.Discard, r)
+			return err
+		}
+
+		return tagged.err
+	})
+}
+
+// DecodeArray reads an array whose elements are read by element. A null array decodes to nil.
+func DecodeArray[T any](d *Decoder, element func(*Decoder) T) []T {
+	length := d.ArrayLength()
+	if d.err != nil || length < 0 {
+		return nil
+	}
+
+	values := make([]T, 0, preallocLen(length))
+	for i := 0; i < length && d.err == nil; i++ {
+		values = append(values, element(d))
+	}
+
+	if d.err != nil {
+		return nil
+	}
+	return values
+}
+
+// Encoder writes a versioned schema message field by field. Like the Decoder, the first error sticks
+// and the remaining writes are skipped.
+type Encoder struct {
+	w        io.Writer
+	flexible bool
+	err      error
+}
+
+func NewEncoder(w io.Writer, flexible bool) *Encoder {
+	return &Encoder{w: w, flexible: flexible}
+}
+
+// Err returns the first error the encoder ran into.
+func (e *Encoder) Err() error {
+	return e.err
+}
+
+// Flexible reports whether the encoder writes the compact (flexible version) encodings.
+func (e *Encoder) Flexible() bool {
+	return e.flexible
+}
+
+// Fail records err as the encoder error unless an earlier error has been recorded already.
+func (e *Encoder) Fail(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+func encode[T any](e *Encoder, write func(io.Writer, T) error, v T) {
+	if e.err == nil {
+		e.err = write(e.w, v)
+	}
+}
+
+func (e *Encoder) Bool(v bool)       { encode(e, WriteBool, v) }
+func (e *Encoder) Int8(v int8)       { encode(e, WriteInt8, v) }
+func (e *Encoder) Int16(v int16)     { encode(e, WriteInt16, v) }
+func (e *Encoder) Int32(v int32)     { encode(e, WriteInt32, v) }
+func (e *Encoder) Int64(v int64)     { encode(e, WriteInt64, v) }
+func (e *Encoder) Uint16(v uint16)   { encode(e, WriteUint16, v) }
+func (e *Encoder) Float64(v float64) { encode(e, WriteFloat64, v) }
+func (e *Encoder) UUID(v uuid.UUID)  { encode(e, WriteUUID, v) }
+
+func (e *Encoder) String(v string) {
+	if e.flexible {
+		encode(e, WriteCompactString, v)
+	} else {
+		encode(e, WriteString, v)
+	}
+}
+
+func (e *Encoder) NullableString(v *string) {
+	if e.flexible {
+		encode(e, WriteNullableCompactString, v)
+	} else {
+		encode(e, WriteNullableString, v)
+	}
+}
+
+func (e *Encoder) Bytes(v []byte) {
+	if v == nil {
+		v = []byte{}
+	}
+
+	if e.flexible {
+		encode(e, WriteCompactBytes, v)
+	} else {
+		encode(e, WriteBytes, v)
+	}
+}
+
+// NullableBytes writes a nullable bytes field, encoding nil as null.
+func (e *Encoder) NullableBytes(v []byte) {
+	var value *[]byte
+	if v != nil {
+		value = &v
+	}
+
+	if e.flexible {
+		encode(e, WriteNullableCompactBytes, value)
+	} else {
+		encode(e, WriteNullableBytes, value)
+	}
+}
+
+// ArrayLength writes an array length. A negative length encodes a null array.
+func (e *Encoder) ArrayLength(length int) {
+	if !e.flexible {
+		e.Int32(int32(length))
+		return
+	}
+
+	if length < 0 {
+		encode(e, WriteUvarint, 0)
+	} else {
+		encode(e, WriteUvarint, uint64(length)+1)
+	}
+}
+
+// Tagged encodes the value written by value as the tagged field tag and appends it to fields.
+func (e *Encoder) Tagged(fields []TaggedField, tag uint64, value func(e *Encoder)) []TaggedField {
+	if e.err != nil {
+		return fields
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0))
+	tagged := &Encoder{w: buf, flexible: true}
+	value(tagged)
+	if tagged.err != nil {
+		e.err = fmt.Errorf("tagged field %d: %w", tag, tagged.err)
+		return fields
+	}
+
+	return append(fields, TaggedField{Tag: tag, Field: buf.Bytes()})
+}
+
+// TaggedFields writes the tagged fields section of a flexible message. Nothing is written for a
+// non-flexible encoder.
+func (e *Encoder) TaggedFields(fields []TaggedField) {
+	if e.flexible {
+		encode(e, WriteRawTaggedFields, fields)
+	}
+}
+
+// EncodeArray writes a non-nullable array, encoding nil as an empty array.
+func EncodeArray[T any](e *Encoder, values []T, element func(*Encoder, T)) {
+	e.ArrayLength(len(values))
+	for _, v := range values {
+		element(e, v)
+	}
+}
+
+// EncodeNullableArray writes a nullable array, encoding nil as null.
+func EncodeNullableArray[T any](e *Encoder, values []T, element func(*Encoder, T)) {
+	if values == nil {
+		e.ArrayLength(-1)
+		return
+	}
+
+	EncodeArray(e, values, element)
+}
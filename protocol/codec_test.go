@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEncoderDecoderRoundTrip(t *testing.T) {
+	for _, flexible := range []bool{false, true} {
+		buf := bytes.NewBuffer(make([]byte, 0))
+		e := NewEncoder(buf, flexible)
+		e.Int16(7)
+		e.String("name")
+		e.NullableString(nil)
+		e.NullableBytes(nil)
+		EncodeArray(e, []int32{1, 2}, (*Encoder).Int32)
+		EncodeNullableArray[int32](e, nil, (*Encoder).Int32)
+		var tagged []TaggedField
+		tagged = e.Tagged(tagged, 3, func(e *Encoder) { e.Int64(42) })
+		tagged = e.Tagged(tagged, 1, func(e *Encoder) { e.Bool(true) })
+		e.TaggedFields(tagged)
+		if err := e.Err(); err != nil {
+			t.Fatalf("flexible=%v: encode: %v", flexible, err)
+		}
+
+		d := NewDecoder(buf, flexible)
+		var tag3 int64
+		if v := d.Int16(); v != 7 {
+			t.Errorf("flexible=%v: Int16 = %d", flexible, v)
+		}
+		if v := d.String(); v != "name" {
+			t.Errorf("flexible=%v: String = %q", flexible, v)
+		}
+		if v := d.NullableString(); v != nil {
+			t.Errorf("flexible=%v: NullableString = %v", flexible, *v)
+		}
+		if v := d.NullableBytes(); v != nil {
+			t.Errorf("flexible=%v: NullableBytes = %v", flexible, v)
+		}
+		if v := DecodeArray(d, (*Decoder).Int32); !reflect.DeepEqual(v, []int32{1, 2}) {
+			t.Errorf("flexible=%v: array = %v", flexible, v)
+		}
+		if v := DecodeArray(d, (*Decoder).Int32); v != nil {
+			t.Errorf("flexible=%v: null array = %v", flexible, v)
+		}
+		d.TaggedFields(func(d *Decoder, tag uint64) bool {
+			if tag != 3 {
+				return false
+			}
+			tag3 = d.Int64()
+			return true
+		})
+		if err := d.Err(); err != nil {
+			t.Fatalf("flexible=%v: decode: %v", flexible, err)
+		}
+		if flexible && tag3 != 42 {
+			t.Errorf("tag 3 = %d", tag3)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("flexible=%v: %d bytes left", flexible, buf.Len())
+		}
+	}
+}
+
+func TestDecoderErrorSticks(t *testing.T) {
+	d := NewDecoder(bytes.NewReader([]byte{0, 1}), false)
+	if v := d.Int32(); v != 0 || d.Err() == nil {
+		t.Fatalf("Int32 on 2 bytes = %d, err %v", v, d.Err())
+	}
+
+	err := d.Err()
+	if v := d.Int8(); v != 0 || d.Err() != err {
+		t.Errorf("a read after an error returned %d and changed the error to %v", v, d.Err())
+	}
+}
@@ -0,0 +1,281 @@
+package raftsim
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A deterministic simulator of a KRaft quorum. The voters exchange Vote, BeginQuorumEpoch,
+// EndQuorumEpoch and Fetch requests and responses encoded as real Kafka frames over an in-memory
+// network. All time is simulated: nothing happens until the clock is advanced, and the same seed
+// replays the same run.
+
+// Defaults matching the controller.quorum.* configuration of Kafka, except for the request timeout
+// which is shorter to make lost messages recover faster.
+const (
+	DefaultElectionTimeout    = 1000 * time.Millisecond
+	DefaultElectionBackoffMax = 1000 * time.Millisecond
+	DefaultFetchTimeout       = 2000 * time.Millisecond
+	DefaultRequestTimeout     = 500 * time.Millisecond
+	DefaultFetchInterval      = 50 * time.Millisecond
+	DefaultLatency            = 1 * time.Millisecond
+)
+
+// DefaultClusterId is the cluster id used by a simulated quorum.
+const DefaultClusterId = "raftsim-cluster-0000000"
+
+// Config configures a simulated quorum.
+type Config struct {
+	Voters             []int32
+	ClusterId          string
+	Seed               int64
+	ElectionTimeout    time.Duration // The minimum election timeout; each timeout is randomized up to twice this.
+	ElectionBackoffMax time.Duration // The maximum backoff before a candidate retries an election.
+	FetchTimeout       time.Duration // How long a follower waits for the leader, and the leader for a majority of fetches.
+	RequestTimeout     time.Duration // How long a node waits for a response before it retries.
+	FetchInterval      time.Duration // The pause between two fetches of an up-to-date follower.
+	MaxFetchRecords    int           // The maximum number of records in a fetch response; 0 means no limit.
+}
+
+// NewConfig returns the default configuration for a quorum of the voters.
+func NewConfig(voters ...int32) Config {
+	return Config{
+		Voters:             voters,
+		ClusterId:          DefaultClusterId,
+		Seed:               1,
+		ElectionTimeout:    DefaultElectionTimeout,
+		ElectionBackoffMax: DefaultElectionBackoffMax,
+		FetchTimeout:       DefaultFetchTimeout,
+		RequestTimeout:     DefaultRequestTimeout,
+		FetchInterval:      DefaultFetchInterval,
+	}
+}
+
+////////////////////
+// Events and the clock
+////////////////////
+
+type event struct {
+	at  time.Duration
+	seq uint64
+	fn  func()
+}
+
+type events []*event
+
+func (e events) Len() int { return len(e) }
+func (e events) Less(i, j int) bool {
+	if e[i].at != e[j].at {
+		return e[i].at < e[j].at
+	}
+	return e[i].seq < e[j].seq
+}
+func (e events) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e *events) Push(x any)   { *e = append(*e, x.(*event)) }
+func (e *events) Pop() any {
+	old := *e
+	last := old[len(old)-1]
+	*e = old[:len(old)-1]
+	return last
+}
+
+////////////////////
+// Cluster
+////////////////////
+
+// Cluster is a simulated quorum with its network and clock.
+type Cluster struct {
+	Config  Config
+	Network *Network
+	Voters  map[int32]*Voter
+	Start   time.Time // The wall clock time at simulated time zero, used for timestamps.
+	Trace   io.Writer // Receives a line for every state change and message when set.
+	Errors  []error   // Failures to encode or decode a message, which indicate a bug.
+
+	rand  *rand.Rand
+	now   time.Duration
+	seq   uint64
+	queue events
+}
+
+// NewCluster creates the voters of the configuration. All start unattached in epoch 0 with empty
+// logs; their election timers run as soon as the clock advances.
+func NewCluster(config Config) *Cluster {
+	c := &Cluster{
+		Config: config,
+		Voters: make(map[int32]*Voter),
+		Start:  time.UnixMilli(0),
+		rand:   rand.New(rand.NewSource(config.Seed)),
+	}
+	c.Network = newNetwork(c)
+
+	for _, id := range config.Voters {
+		c.Voters[id] = newVoter(c, id, uuid.NewSHA1(uuid.NameSpaceOID, []byte(fmt.Sprintf("raftsim-%d", id))))
+	}
+	for _, id := range c.voterIds() {
+		c.Voters[id].becomeUnattached(0)
+	}
+
+	return c
+}
+
+// Now returns the simulated time since the start of the simulation.
+func (c *Cluster) Now() time.Duration {
+	return c.now
+}
+
+// WallClock returns the simulated wall clock time.
+func (c *Cluster) WallClock() time.Time {
+	return c.Start.Add(c.now)
+}
+
+func (c *Cluster) schedule(after time.Duration, fn func()) {
+	c.seq++
+	heap.Push(&c.queue, &event{at: c.now + after, seq: c.seq, fn: fn})
+}
+
+// Step runs the next event and advances the clock to it. It returns false when nothing is scheduled.
+func (c *Cluster) Step() bool {
+	if len(c.queue) == 0 {
+		return false
+	}
+
+	e := heap.Pop(&c.queue).(*event)
+	c.now = e.at
+	e.fn()
+	return true
+}
+
+// Advance runs all events scheduled in the next d and moves the clock forward by d.
+func (c *Cluster) Advance(d time.Duration) {
+	end := c.now + d
+	for len(c.queue) > 0 && c.queue[0].at <= end {
+		c.Step()
+	}
+	c.now = end
+}
+
+// RunUntil runs events until the condition holds or the clock moved forward by limit. It returns
+// whether the condition holds.
+func (c *Cluster) RunUntil(condition func() bool, limit time.Duration) bool {
+	end := c.now + limit
+	for !condition() {
+		if len(c.queue) == 0 || c.queue[0].at > end {
+			c.now = end
+			return condition()
+		}
+		c.Step()
+	}
+	return true
+}
+
+// Leader returns the voter which is the leader in the highest epoch, or nil when there is none.
+func (c *Cluster) Leader() *Voter {
+	var leader *Voter
+	for _, id := range c.voterIds() {
+		v := c.Voters[id]
+		if v.Role == Leader && !v.Stopped && (leader == nil || v.Epoch > leader.Epoch) {
+			leader = v
+		}
+	}
+	return leader
+}
+
+// StableLeader returns the leader once all running voters connected to it follow it in its epoch
+// and they form a majority.
+func (c *Cluster) StableLeader() *Voter {
+	leader := c.Leader()
+	if leader == nil {
+		return nil
+	}
+
+	following := 1
+	for _, v := range c.Voters {
+		if v.Stopped || v == leader || !c.Network.Connected(v.Id, leader.Id) {
+			continue
+		}
+		if v.Role != Follower || v.Epoch != leader.Epoch || v.LeaderId != leader.Id {
+			return nil
+		}
+		following++
+	}
+	if following < c.majority() {
+		return nil
+	}
+	return leader
+}
+
+// Append appends records with the values to the log of the leader and returns the offset of the
+// first one. It returns an error when there is no leader.
+func (c *Cluster) Append(values ...[]byte) (int64, error) {
+	leader := c.Leader()
+	if leader == nil {
+		return -1, ErrNoLeader
+	}
+	return leader.append(values), nil
+}
+
+// Stop crashes a voter: it stops processing messages and timers. Its log, epoch and vote are kept,
+// like the log and the quorum-state file on disk.
+func (c *Cluster) Stop(id int32) {
+	v := c.Voters[id]
+	v.Stopped = true
+	v.generation++
+	c.tracef("%d stopped", id)
+}
+
+// Restart restarts a stopped voter. It comes back unattached in its last epoch and forgets its
+// high watermark.
+func (c *Cluster) Restart(id int32) {
+	v := c.Voters[id]
+	v.Stopped = false
+	v.HighWatermark = 0
+	c.tracef("%d restarted", id)
+	v.restart()
+}
+
+// Resign makes the leader resign gracefully: it sends EndQuorumEpoch to the other voters so that
+// the most up-to-date one starts an election right away.
+func (c *Cluster) Resign() error {
+	leader := c.Leader()
+	if leader == nil {
+		return ErrNoLeader
+	}
+	leader.resign()
+	return nil
+}
+
+func (c *Cluster) voterIds() []int32 {
+	ids := make([]int32, 0, len(c.Voters))
+	for id := range c.Voters {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (c *Cluster) majority() int {
+	return len(c.Voters)/2 + 1
+}
+
+// jitter returns a random duration in [0, max).
+func (c *Cluster) jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(c.rand.Int63n(int64(max)))
+}
+
+func (c *Cluster) tracef(format string, args ...any) {
+	if c.Trace != nil {
+		fmt.Fprintf(c.Trace, "%8d ms  ", c.now.Milliseconds())
+		fmt.Fprintf(c.Trace, format, args...)
+		fmt.Fprintln(c.Trace)
+	}
+}
@@ -0,0 +1,100 @@
+package raftsim
+
+import (
+	"fmt"
+
+	"github.com/scholzj/go-kafka-protocol/api/describequorum"
+	"github.com/scholzj/go-kafka-protocol/clustermetadata"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+// DescribeQuorum sends a DescribeQuorum request to the voter, the way an admin client talking to
+// the controller listener would, and returns the decoded response. The request bypasses the
+// simulated network and takes no simulated time, but it is encoded and decoded as a real frame.
+func (c *Cluster) DescribeQuorum(id int32) (*describequorum.DescribeQuorumResponse, error) {
+	v, ok := c.Voters[id]
+	if !ok || v.Stopped {
+		return nil, fmt.Errorf("voter %d is not running", id)
+	}
+
+	clientId := "raftsim-admin"
+	header := protocol.RequestHeader{ApiKey: messages.DescribeQuorum, ApiVersion: describeQuorumVersion, CorrelationId: 1, ClientId: &clientId}
+	data, err := encodeRequest(header, &describequorum.DescribeQuorumRequest{
+		ApiVersion: describeQuorumVersion,
+		Topics: &[]describequorum.DescribeQuorumRequestTopic{{
+			TopicName:  metadataTopic(),
+			Partitions: &[]describequorum.DescribeQuorumRequestTopicPartition{{PartitionIndex: 0}},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	request, body, err := decodeRequest(data)
+	if err != nil {
+		return nil, err
+	}
+	data, err = encodeResponse(request.RequestHeader, v.handleDescribeQuorum(body.(*describequorum.DescribeQuorumRequest)))
+	if err != nil {
+		return nil, err
+	}
+
+	_, response, err := decodeResponse(data, map[int32]protocol.RequestHeader{header.CorrelationId: header})
+	if err != nil {
+		return nil, err
+	}
+	return response.(*describequorum.DescribeQuorumResponse), nil
+}
+
+func (v *Voter) handleDescribeQuorum(req *describequorum.DescribeQuorumRequest) *describequorum.DescribeQuorumResponse {
+	partition := describequorum.DescribeQuorumResponseTopicPartition{
+		LeaderId:      v.LeaderId,
+		LeaderEpoch:   v.Epoch,
+		HighWatermark: v.HighWatermark,
+		CurrentVoters: &[]describequorum.DescribeQuorumResponseTopicPartitionCurrentVoter{},
+		Observers:     &[]describequorum.DescribeQuorumResponseTopicPartitionObserver{},
+	}
+	response := &describequorum.DescribeQuorumResponse{
+		ApiVersion: req.ApiVersion,
+		Topics:     &[]describequorum.DescribeQuorumResponseTopic{{TopicName: metadataTopic(), Partitions: &[]describequorum.DescribeQuorumResponseTopicPartition{}}},
+		Nodes:      &[]describequorum.DescribeQuorumResponseNode{},
+	}
+	done := func() *describequorum.DescribeQuorumResponse {
+		*(*response.Topics)[0].Partitions = append(*(*response.Topics)[0].Partitions, partition)
+		return response
+	}
+
+	// Only the leader knows the state of the quorum.
+	if v.Role != Leader {
+		partition.ErrorCode = errorcodes.NotLeaderOrFollower
+		message := fmt.Sprintf("voter %d is not the leader of %s", v.Id, clustermetadata.MetadataTopic)
+		partition.ErrorMessage = &message
+		return done()
+	}
+
+	now := v.cluster.WallClock().UnixMilli()
+	for _, id := range v.cluster.voterIds() {
+		voter := describequorum.DescribeQuorumResponseTopicPartitionCurrentVoter{
+			ReplicaId:             id,
+			ReplicaDirectoryId:    v.cluster.Voters[id].DirectoryId,
+			LogEndOffset:          v.LogEndOffset(),
+			LastFetchTimestamp:    -1,
+			LastCaughtUpTimestamp: now,
+		}
+		if replica, ok := v.replicas[id]; ok {
+			voter.LogEndOffset = replica.logEndOffset
+			voter.LastFetchTimestamp = replica.lastFetchTimestamp
+			voter.LastCaughtUpTimestamp = replica.lastCaughtUpTimestamp
+		}
+		*partition.CurrentVoters = append(*partition.CurrentVoters, voter)
+
+		endpoint := v.cluster.Voters[id].endpoint()
+		*response.Nodes = append(*response.Nodes, describequorum.DescribeQuorumResponseNode{
+			NodeId:    id,
+			Listeners: &[]describequorum.DescribeQuorumResponseNodeListener{{Name: endpoint.Name, Host: endpoint.Host, Port: endpoint.Port}},
+		})
+	}
+	return done()
+}
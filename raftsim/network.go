@@ -0,0 +1,108 @@
+package raftsim
+
+import (
+	"time"
+)
+
+// Message is a request or a response on the simulated network. Data is the complete frame, size
+// prefix included, as it would be written to the socket.
+type Message struct {
+	From     int32
+	To       int32
+	ApiKey   int16
+	Response bool
+	Data     []byte
+	SentAt   time.Duration
+}
+
+// Network delivers messages between the voters after the latency. Messages between nodes in
+// different partitions, to stopped nodes, rejected by the filter or picked by the loss rate are
+// dropped when they would be delivered.
+type Network struct {
+	Latency  time.Duration // The one way latency.
+	Jitter   time.Duration // A random extra latency in [0, Jitter), which can reorder messages.
+	LossRate float64       // The probability that a message is lost.
+
+	// Filter drops every message for which it returns false. It can be used to cut a single
+	// direction of a link or to drop a specific message type.
+	Filter func(m *Message) bool
+
+	Sent      int
+	Delivered int
+	Dropped   int
+
+	cluster   *Cluster
+	partition map[int32]int
+}
+
+func newNetwork(c *Cluster) *Network {
+	return &Network{Latency: DefaultLatency, cluster: c}
+}
+
+// Partition splits the network: nodes in different groups cannot communicate. Nodes missing from
+// all groups are isolated.
+func (n *Network) Partition(groups ...[]int32) {
+	n.partition = make(map[int32]int)
+	for i, group := range groups {
+		for _, id := range group {
+			n.partition[id] = i + 1
+		}
+	}
+	n.cluster.tracef("network partitioned %v", groups)
+}
+
+// Isolate disconnects a node from all other nodes.
+func (n *Network) Isolate(id int32) {
+	var others []int32
+	for _, other := range n.cluster.voterIds() {
+		if other != id {
+			others = append(others, other)
+		}
+	}
+	n.Partition([]int32{id}, others)
+}
+
+// Heal removes the partitions.
+func (n *Network) Heal() {
+	n.partition = nil
+	n.cluster.tracef("network healed")
+}
+
+// Connected returns whether two nodes can communicate, ignoring message loss and the filter.
+func (n *Network) Connected(a int32, b int32) bool {
+	if n.partition == nil {
+		return true
+	}
+	groupA, okA := n.partition[a]
+	groupB, okB := n.partition[b]
+	return okA && okB && groupA == groupB
+}
+
+func (n *Network) send(m *Message, deliver func(m *Message)) {
+	n.Sent++
+	m.SentAt = n.cluster.now
+
+	delay := n.Latency + n.cluster.jitter(n.Jitter)
+	n.cluster.schedule(delay, func() {
+		to := n.cluster.Voters[m.To]
+		switch {
+		case to == nil || to.Stopped || !n.Connected(m.From, m.To):
+		case n.LossRate > 0 && n.cluster.rand.Float64() < n.LossRate:
+		case n.Filter != nil && !n.Filter(m):
+		default:
+			n.Delivered++
+			deliver(m)
+			return
+		}
+
+		n.Dropped++
+		n.cluster.tracef("%d -> %d %s dropped", m.From, m.To, describe(m))
+	})
+}
+
+func describe(m *Message) string {
+	if m.Response {
+		return apiName(m.ApiKey) + " response"
+	}
+	return apiName(m.ApiKey) + " request"
+}
@@ -0,0 +1,270 @@
+package raftsim
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+func newTestCluster(t *testing.T, seed int64, voters ...int32) *Cluster {
+	t.Helper()
+	config := NewConfig(voters...)
+	config.Seed = seed
+	return NewCluster(config)
+}
+
+func waitForLeader(t *testing.T, c *Cluster) *Voter {
+	t.Helper()
+	if !c.RunUntil(func() bool { return c.StableLeader() != nil }, 30*time.Second) {
+		t.Fatalf("no stable leader after %v", c.Now())
+	}
+	return c.StableLeader()
+}
+
+func waitForReplication(t *testing.T, c *Cluster, ids []int32, offset int64) {
+	t.Helper()
+	replicated := func() bool {
+		for _, id := range ids {
+			if c.Voters[id].HighWatermark < offset {
+				return false
+			}
+		}
+		return true
+	}
+	if !c.RunUntil(replicated, 30*time.Second) {
+		for _, id := range ids {
+			t.Logf("voter %d: %s epoch %d, LEO %d, HW %d", id, c.Voters[id].Role, c.Voters[id].Epoch, c.Voters[id].LogEndOffset(), c.Voters[id].HighWatermark)
+		}
+		t.Fatalf("offset %d was not replicated", offset)
+	}
+}
+
+func checkNoErrors(t *testing.T, c *Cluster) {
+	t.Helper()
+	for _, err := range c.Errors {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// checkLogs verifies that the committed parts of the logs of the voters are identical.
+func checkLogs(t *testing.T, c *Cluster) {
+	t.Helper()
+	for _, a := range c.Voters {
+		for _, b := range c.Voters {
+			for o := int64(0); o < min(a.HighWatermark, b.HighWatermark); o++ {
+				if a.Log[o].Epoch != b.Log[o].Epoch || !bytes.Equal(a.Log[o].Value, b.Log[o].Value) {
+					t.Fatalf("voters %d and %d disagree on committed offset %d", a.Id, b.Id, o)
+				}
+			}
+		}
+	}
+}
+
+func TestElectionAndReplication(t *testing.T) {
+	for _, voters := range [][]int32{{1}, {1, 2, 3}, {1, 2, 3, 4, 5}} {
+		c := newTestCluster(t, 1, voters...)
+		leader := waitForLeader(t, c)
+		if leader.Epoch < 1 {
+			t.Errorf("leader %d has epoch %d", leader.Id, leader.Epoch)
+		}
+
+		offset, err := c.Append([]byte("a"), []byte("b"), []byte("c"))
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		waitForReplication(t, c, voters, offset+3)
+
+		for _, v := range c.Voters {
+			if v.LogEndOffset() != offset+3 || string(v.Log[offset+2].Value) != "c" || !v.Log[0].Control {
+				t.Errorf("voter %d has an unexpected log %+v", v.Id, v.Log)
+			}
+		}
+		checkLogs(t, c)
+		checkNoErrors(t, c)
+	}
+}
+
+func TestLeaderIsolation(t *testing.T) {
+	c := newTestCluster(t, 2, 1, 2, 3)
+	old := waitForLeader(t, c)
+	if _, err := c.Append([]byte("committed")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	waitForReplication(t, c, c.voterIds(), 2)
+
+	// The isolated leader accepts a record which is never committed.
+	c.Network.Isolate(old.Id)
+	oldEpoch := old.Epoch
+	old.append([][]byte{[]byte("lost")})
+
+	leader := waitForLeader(t, c)
+	if leader.Id == old.Id || leader.Epoch <= oldEpoch {
+		t.Fatalf("leader %d in epoch %d did not replace %d in epoch %d", leader.Id, leader.Epoch, old.Id, oldEpoch)
+	}
+	if !c.RunUntil(func() bool { return old.Role != Leader }, 10*time.Second) {
+		t.Errorf("the isolated leader %d did not resign", old.Id)
+	}
+	if _, err := c.Append([]byte("new")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	// After healing, the old leader truncates the uncommitted record and catches up.
+	c.Network.Heal()
+	waitForReplication(t, c, c.voterIds(), leader.LogEndOffset())
+	for _, v := range c.Voters {
+		for _, entry := range v.Log {
+			if string(entry.Value) == "lost" {
+				t.Errorf("voter %d still has the uncommitted record", v.Id)
+			}
+		}
+	}
+	checkLogs(t, c)
+	checkNoErrors(t, c)
+}
+
+func TestResign(t *testing.T) {
+	c := newTestCluster(t, 3, 1, 2, 3)
+	old := waitForLeader(t, c)
+	oldEpoch := old.Epoch
+	start := c.Now()
+
+	if err := c.Resign(); err != nil {
+		t.Fatalf("Resign: %v", err)
+	}
+	leader := waitForLeader(t, c)
+	if leader.Id == old.Id || leader.Epoch != oldEpoch+1 {
+		t.Errorf("leader %d in epoch %d after %d resigned in epoch %d", leader.Id, leader.Epoch, old.Id, oldEpoch)
+	}
+	// The preferred candidate does not wait for the fetch timeout.
+	if elapsed := c.Now() - start; elapsed >= DefaultFetchTimeout {
+		t.Errorf("the election after resigning took %v", elapsed)
+	}
+	checkNoErrors(t, c)
+}
+
+func TestMessageLoss(t *testing.T) {
+	c := newTestCluster(t, 4, 1, 2, 3)
+	c.Network.LossRate = 0.2
+	c.Network.Jitter = 5 * time.Millisecond
+
+	waitForLeader(t, c)
+	var end int64
+	for i := 0; i < 10; i++ {
+		if c.Leader() == nil {
+			waitForLeader(t, c)
+		}
+		offset, err := c.Append([]byte(fmt.Sprintf("record-%d", i)))
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		end = offset + 1
+		c.Advance(100 * time.Millisecond)
+	}
+
+	c.Network.LossRate = 0
+	waitForLeader(t, c)
+	if _, err := c.Append([]byte("last")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	waitForReplication(t, c, c.voterIds(), c.Leader().LogEndOffset())
+	if c.Network.Dropped == 0 || end == 0 {
+		t.Errorf("no messages were dropped")
+	}
+	checkLogs(t, c)
+	checkNoErrors(t, c)
+}
+
+func TestStopAndRestart(t *testing.T) {
+	c := newTestCluster(t, 5, 1, 2, 3)
+	old := waitForLeader(t, c)
+
+	c.Stop(old.Id)
+	leader := waitForLeader(t, c)
+	if leader.Id == old.Id {
+		t.Fatalf("the stopped voter %d is still the leader", old.Id)
+	}
+	offset, err := c.Append([]byte("while stopped"))
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	var others []int32
+	for _, id := range c.voterIds() {
+		if id != old.Id {
+			others = append(others, id)
+		}
+	}
+	waitForReplication(t, c, others, offset+1)
+
+	c.Restart(old.Id)
+	waitForReplication(t, c, c.voterIds(), offset+1)
+	if old.Role != Follower || old.LeaderId != leader.Id {
+		t.Errorf("the restarted voter is %s of %d", old.Role, old.LeaderId)
+	}
+	checkLogs(t, c)
+	checkNoErrors(t, c)
+}
+
+func TestDescribeQuorum(t *testing.T) {
+	c := newTestCluster(t, 6, 1, 2, 3)
+	leader := waitForLeader(t, c)
+	if _, err := c.Append([]byte("a")); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	waitForReplication(t, c, c.voterIds(), 2)
+
+	response, err := c.DescribeQuorum(leader.Id)
+	if err != nil {
+		t.Fatalf("DescribeQuorum: %v", err)
+	}
+	partition := (*(*response.Topics)[0].Partitions)[0]
+	if partition.ErrorCode != errorcodes.None || partition.LeaderId != leader.Id || partition.LeaderEpoch != leader.Epoch || partition.HighWatermark != 2 {
+		t.Errorf("unexpected partition %+v", partition)
+	}
+	if len(*partition.CurrentVoters) != 3 || len(*response.Nodes) != 3 {
+		t.Fatalf("unexpected voters %+v and nodes %+v", *partition.CurrentVoters, *response.Nodes)
+	}
+	for _, voter := range *partition.CurrentVoters {
+		if voter.LogEndOffset != 2 || voter.ReplicaDirectoryId != c.Voters[voter.ReplicaId].DirectoryId {
+			t.Errorf("unexpected voter %+v", voter)
+		}
+	}
+	if host := *(*(*response.Nodes)[0].Listeners)[0].Host; host != "controller-1" {
+		t.Errorf("unexpected host %s", host)
+	}
+
+	for _, id := range c.voterIds() {
+		if id == leader.Id {
+			continue
+		}
+		response, err := c.DescribeQuorum(id)
+		if err != nil {
+			t.Fatalf("DescribeQuorum: %v", err)
+		}
+		if code := (*(*response.Topics)[0].Partitions)[0].ErrorCode; code != errorcodes.NotLeaderOrFollower {
+			t.Errorf("follower %d returned error code %d", id, code)
+		}
+	}
+}
+
+func TestDeterminism(t *testing.T) {
+	run := func() string {
+		var trace bytes.Buffer
+		c := newTestCluster(t, 42, 1, 2, 3, 4, 5)
+		c.Trace = &trace
+		c.Network.Jitter = 3 * time.Millisecond
+		c.Network.LossRate = 0.05
+		waitForLeader(t, c)
+		c.Append([]byte("x"))
+		c.Advance(5 * time.Second)
+		return trace.String()
+	}
+
+	first, second := run(), run()
+	if first == "" || first != second {
+		t.Error("two runs with the same seed differ")
+	}
+}
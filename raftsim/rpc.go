@@ -0,0 +1,89 @@
+package raftsim
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+// The API versions used between the voters.
+const (
+	fetchVersion            int16 = 17
+	voteVersion             int16 = 2
+	beginQuorumEpochVersion int16 = 1
+	endQuorumEpochVersion   int16 = 1
+	describeQuorumVersion   int16 = 2
+)
+
+var ErrNoLeader = errors.New("the quorum has no leader")
+
+func apiName(apiKey int16) string {
+	return messages.Name(apiKey)
+}
+
+func encodeRequest(header protocol.RequestHeader, body protocol.RequestBody) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0))
+	if err := body.Write(buf); err != nil {
+		return nil, fmt.Errorf("failed to encode the %s request: %w", apiName(header.ApiKey), err)
+	}
+
+	frame := bytes.NewBuffer(make([]byte, 0))
+	request := protocol.Request{RequestHeader: header, Body: buf}
+	if err := request.Write(frame); err != nil {
+		return nil, err
+	}
+	return frame.Bytes(), nil
+}
+
+func decodeRequest(data []byte) (protocol.Request, protocol.RequestBody, error) {
+	request, err := protocol.ReadRequest(bytes.NewReader(data))
+	if err != nil {
+		return request, nil, fmt.Errorf("failed to decode the request: %w", err)
+	}
+
+	body, ok := messages.NewRequestBody(request.ApiKey)
+	if !ok {
+		return request, nil, fmt.Errorf("unknown API key %d", request.ApiKey)
+	}
+	if err := body.Read(&request); err != nil {
+		return request, nil, fmt.Errorf("failed to decode the %s request: %w", apiName(request.ApiKey), err)
+	}
+	return request, body, nil
+}
+
+func encodeResponse(header protocol.RequestHeader, body protocol.ResponseBody) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0))
+	if err := body.Write(buf); err != nil {
+		return nil, fmt.Errorf("failed to encode the %s response: %w", apiName(header.ApiKey), err)
+	}
+
+	frame := bytes.NewBuffer(make([]byte, 0))
+	response := protocol.Response{
+		ResponseHeader: protocol.ResponseHeader{ApiKey: header.ApiKey, ApiVersion: header.ApiVersion, CorrelationId: header.CorrelationId},
+		Body:           buf,
+	}
+	if err := response.Write(frame); err != nil {
+		return nil, err
+	}
+	return frame.Bytes(), nil
+}
+
+func decodeResponse(data []byte, inflight map[int32]protocol.RequestHeader) (protocol.RequestHeader, protocol.ResponseBody, error) {
+	response, err := protocol.ReadResponse(bytes.NewReader(data), inflight)
+	if err != nil {
+		return protocol.RequestHeader{}, nil, fmt.Errorf("failed to decode the response: %w", err)
+	}
+	header := inflight[response.CorrelationId]
+
+	body, ok := messages.NewResponseBody(response.ApiKey)
+	if !ok {
+		return header, nil, fmt.Errorf("unknown API key %d", response.ApiKey)
+	}
+	if err := body.Read(&response); err != nil {
+		return header, nil, fmt.Errorf("failed to decode the %s response: %w", apiName(response.ApiKey), err)
+	}
+	return header, body, nil
+}
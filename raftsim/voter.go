@@ -0,0 +1,912 @@
+package raftsim
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/beginquorumepoch"
+	"github.com/scholzj/go-kafka-protocol/api/endquorumepoch"
+	"github.com/scholzj/go-kafka-protocol/api/fetch"
+	"github.com/scholzj/go-kafka-protocol/api/vote"
+	"github.com/scholzj/go-kafka-protocol/clustermetadata"
+	"github.com/scholzj/go-kafka-protocol/compression"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// Role is the state of a voter in its epoch.
+type Role int
+
+const (
+	Unattached Role = iota // No leader is known; the voter may have voted.
+	Candidate              // The voter asks for votes.
+	Leader                 // The voter was elected.
+	Follower               // The voter fetches from the leader.
+	Resigned               // The former leader waits for a new election.
+)
+
+func (r Role) String() string {
+	switch r {
+	case Unattached:
+		return "Unattached"
+	case Candidate:
+		return "Candidate"
+	case Leader:
+		return "Leader"
+	case Follower:
+		return "Follower"
+	case Resigned:
+		return "Resigned"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(r))
+	}
+}
+
+// Entry is a record in the log of a voter. A new leader starts its epoch with a control entry,
+// which is replicated as a LeaderChange control batch.
+type Entry struct {
+	Epoch     int32
+	Control   bool
+	Timestamp int64
+	Value     []byte
+}
+
+type replicaState struct {
+	logEndOffset          int64
+	lastFetchTimestamp    int64
+	lastCaughtUpTimestamp int64
+	lastFetch             time.Duration // The simulated time of the last fetch, or -1.
+	acknowledged          bool          // Whether the voter knows about the epoch of the leader.
+}
+
+// Voter is a member of the simulated quorum. Its exported fields can be inspected at any time but
+// should not be changed while the simulation runs, except for ClusterId.
+type Voter struct {
+	Id            int32
+	DirectoryId   uuid.UUID
+	ClusterId     string
+	Role          Role
+	Epoch         int32
+	LeaderId      int32
+	VotedFor      int32
+	Log           []Entry
+	HighWatermark int64
+	Stopped       bool
+
+	cluster       *Cluster
+	generation    uint64 // Increased on every state change to cancel the timers of the previous state.
+	electionTimer uint64
+	fetchTimer    uint64
+	correlationId int32
+	inflight      map[int32]protocol.RequestHeader
+
+	votes            map[int32]bool
+	replicas         map[int32]*replicaState
+	epochStartOffset int64
+	fetchInFlight    int32 // The correlation id of the outstanding fetch, or -1.
+}
+
+func newVoter(c *Cluster, id int32, directoryId uuid.UUID) *Voter {
+	return &Voter{
+		Id:            id,
+		DirectoryId:   directoryId,
+		ClusterId:     c.Config.ClusterId,
+		LeaderId:      -1,
+		VotedFor:      -1,
+		cluster:       c,
+		inflight:      make(map[int32]protocol.RequestHeader),
+		fetchInFlight: -1,
+	}
+}
+
+// LogEndOffset returns the offset of the next record appended to the log.
+func (v *Voter) LogEndOffset() int64 {
+	return int64(len(v.Log))
+}
+
+// LastEpoch returns the epoch of the last record in the log, or 0 for an empty log.
+func (v *Voter) LastEpoch() int32 {
+	if len(v.Log) == 0 {
+		return 0
+	}
+	return v.Log[len(v.Log)-1].Epoch
+}
+
+// endOffsetForEpoch returns the largest epoch in the log at or below the epoch and its end offset,
+// the same way the leader answers the divergence check of a fetch.
+func (v *Voter) endOffsetForEpoch(epoch int32) (int32, int64) {
+	found := int32(-1)
+	for i, entry := range v.Log {
+		if entry.Epoch > epoch {
+			return found, int64(i)
+		}
+		found = entry.Epoch
+	}
+	return found, v.LogEndOffset()
+}
+
+////////////////////
+// Timers and state changes
+////////////////////
+
+// after runs fn after d unless the voter changed its state or stopped in the meantime.
+func (v *Voter) after(d time.Duration, fn func()) {
+	generation := v.generation
+	v.cluster.schedule(d, func() {
+		if v.generation == generation && !v.Stopped {
+			fn()
+		}
+	})
+}
+
+// restartable runs fn after d unless the timer was reset in the meantime.
+func (v *Voter) restartable(timer *uint64, d time.Duration, fn func()) {
+	*timer++
+	id := *timer
+	v.after(d, func() {
+		if *timer == id {
+			fn()
+		}
+	})
+}
+
+func (v *Voter) randomElectionTimeout() time.Duration {
+	return v.cluster.Config.ElectionTimeout + v.cluster.jitter(v.cluster.Config.ElectionTimeout)
+}
+
+func (v *Voter) transition(role Role, epoch int32, leaderId int32) {
+	if epoch > v.Epoch {
+		v.VotedFor = -1
+	}
+	v.Role = role
+	v.Epoch = epoch
+	v.LeaderId = leaderId
+	v.generation++
+	v.votes = nil
+	v.replicas = nil
+	v.fetchInFlight = -1
+	v.cluster.tracef("%d is %s in epoch %d (leader %d, voted for %d)", v.Id, role, epoch, leaderId, v.VotedFor)
+}
+
+func (v *Voter) startElectionTimer(d time.Duration) {
+	v.restartable(&v.electionTimer, d, v.becomeCandidate)
+}
+
+func (v *Voter) becomeUnattached(epoch int32) {
+	v.transition(Unattached, epoch, -1)
+	v.startElectionTimer(v.randomElectionTimeout())
+}
+
+func (v *Voter) becomeCandidate() {
+	v.transition(Candidate, v.Epoch+1, -1)
+	v.VotedFor = v.Id
+	v.votes = map[int32]bool{v.Id: true}
+
+	if len(v.votes) >= v.cluster.majority() {
+		v.becomeLeader()
+		return
+	}
+
+	for _, id := range v.cluster.voterIds() {
+		if id != v.Id {
+			v.sendVote(id)
+		}
+	}
+
+	// When the election does not complete, back off for a random time and try again.
+	v.after(v.randomElectionTimeout(), func() {
+		v.cluster.tracef("%d election in epoch %d timed out", v.Id, v.Epoch)
+		v.after(v.cluster.jitter(v.cluster.Config.ElectionBackoffMax), v.becomeCandidate)
+	})
+}
+
+func (v *Voter) becomeLeader() {
+	granting := make([]int32, 0, len(v.votes))
+	for id := range v.votes {
+		granting = append(granting, id)
+	}
+	sort.Slice(granting, func(i, j int) bool { return granting[i] < granting[j] })
+
+	v.transition(Leader, v.Epoch, v.Id)
+	v.replicas = make(map[int32]*replicaState)
+	for _, id := range v.cluster.voterIds() {
+		if id != v.Id {
+			v.replicas[id] = &replicaState{logEndOffset: -1, lastFetchTimestamp: -1, lastCaughtUpTimestamp: -1, lastFetch: -1}
+		}
+	}
+
+	v.epochStartOffset = v.LogEndOffset()
+	v.Log = append(v.Log, Entry{Epoch: v.Epoch, Control: true, Timestamp: v.cluster.WallClock().UnixMilli(), Value: leaderChangeValue(v.Id, v.cluster.voterIds(), granting)})
+	v.updateHighWatermark()
+
+	v.sendBeginQuorumEpochs()
+	v.after(v.checkQuorumInterval(), v.checkQuorum)
+}
+
+// checkQuorumInterval is how often a leader verifies that a majority is still fetching from it.
+func (v *Voter) checkQuorumInterval() time.Duration {
+	return v.cluster.Config.FetchTimeout * 3 / 2
+}
+
+func (v *Voter) checkQuorum() {
+	fetching := 1
+	for _, replica := range v.replicas {
+		if replica.lastFetch >= 0 && v.cluster.now-replica.lastFetch <= v.checkQuorumInterval() {
+			fetching++
+		}
+	}
+
+	if fetching < v.cluster.majority() {
+		v.cluster.tracef("%d lost the quorum: %d of %d voters are fetching", v.Id, fetching, len(v.cluster.Voters))
+		v.resign()
+		return
+	}
+	v.after(v.checkQuorumInterval(), v.checkQuorum)
+}
+
+func (v *Voter) becomeFollower(epoch int32, leaderId int32) {
+	v.transition(Follower, epoch, leaderId)
+	v.resetFetchTimer(v.cluster.Config.FetchTimeout)
+	v.fetch()
+}
+
+func (v *Voter) resetFetchTimer(d time.Duration) {
+	v.restartable(&v.fetchTimer, d, func() {
+		v.cluster.tracef("%d fetch from leader %d timed out", v.Id, v.LeaderId)
+		v.becomeCandidate()
+	})
+}
+
+// resign ends the epoch of the leader. The other voters are told with EndQuorumEpoch, ordered by
+// how far their logs are replicated, so that the best candidate starts the next election first.
+func (v *Voter) resign() {
+	candidates := make([]int32, 0, len(v.replicas))
+	for id := range v.replicas {
+		candidates = append(candidates, id)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := v.replicas[candidates[i]], v.replicas[candidates[j]]
+		if a.logEndOffset != b.logEndOffset {
+			return a.logEndOffset > b.logEndOffset
+		}
+		return candidates[i] < candidates[j]
+	})
+
+	v.transition(Resigned, v.Epoch, v.Id)
+	for _, id := range candidates {
+		v.sendEndQuorumEpoch(id, candidates)
+	}
+	v.startElectionTimer(v.randomElectionTimeout())
+}
+
+func (v *Voter) restart() {
+	v.inflight = make(map[int32]protocol.RequestHeader)
+	if v.LeaderId >= 0 && v.LeaderId != v.Id {
+		v.becomeFollower(v.Epoch, v.LeaderId)
+		return
+	}
+
+	votedFor := v.VotedFor
+	v.becomeUnattached(v.Epoch)
+	v.VotedFor = votedFor
+}
+
+// observe moves the voter to a newer epoch, or to following a leader it learned about in its own
+// epoch. It returns whether the voter changed its state.
+func (v *Voter) observe(epoch int32, leaderId int32) bool {
+	switch {
+	case epoch > v.Epoch && leaderId >= 0 && leaderId != v.Id:
+		v.becomeFollower(epoch, leaderId)
+	case epoch > v.Epoch:
+		v.becomeUnattached(epoch)
+	case epoch == v.Epoch && leaderId >= 0 && leaderId != v.Id && (v.Role == Unattached || v.Role == Candidate):
+		v.becomeFollower(epoch, leaderId)
+	default:
+		return false
+	}
+	return true
+}
+
+////////////////////
+// Log
+////////////////////
+
+func (v *Voter) append(values [][]byte) int64 {
+	offset := v.LogEndOffset()
+	for _, value := range values {
+		v.Log = append(v.Log, Entry{Epoch: v.Epoch, Timestamp: v.cluster.WallClock().UnixMilli(), Value: value})
+	}
+	v.updateHighWatermark()
+	return offset
+}
+
+// updateHighWatermark moves the high watermark of the leader to the offset replicated to a majority.
+// Like in Kafka, it only moves once an offset of the current epoch is replicated.
+func (v *Voter) updateHighWatermark() {
+	offsets := []int64{v.LogEndOffset()}
+	for _, replica := range v.replicas {
+		offsets = append(offsets, replica.logEndOffset)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] > offsets[j] })
+
+	hw := offsets[v.cluster.majority()-1]
+	if hw > v.epochStartOffset && hw > v.HighWatermark {
+		v.HighWatermark = hw
+		v.cluster.tracef("%d high watermark %d", v.Id, hw)
+	}
+}
+
+func (v *Voter) truncate(offset int64) {
+	if offset < v.LogEndOffset() {
+		v.cluster.tracef("%d truncates its log from %d to %d", v.Id, v.LogEndOffset(), offset)
+		v.Log = v.Log[:offset]
+	}
+	if v.HighWatermark > offset {
+		v.HighWatermark = offset
+	}
+}
+
+func leaderChangeValue(leaderId int32, voters []int32, granting []int32) []byte {
+	batch, err := clustermetadata.NewControlBatch(0, 0, 0, []clustermetadata.ControlMessage{
+		&clustermetadata.LeaderChangeMessage{LeaderId: leaderId, Voters: voters, GrantingVoters: granting},
+	})
+	if err != nil {
+		return nil
+	}
+	return batch.Records[0].Value
+}
+
+// encodeEntries encodes the entries from the offset as record batches, one batch per run of
+// entries with the same epoch and a separate control batch for every leader change.
+func (v *Voter) encodeEntries(offset int64, max int) ([]byte, error) {
+	end := v.LogEndOffset()
+	if max > 0 && end-offset > int64(max) {
+		end = offset + int64(max)
+	}
+
+	var batches []*records.RecordBatch
+	var current *records.RecordBatch
+	for o := offset; o < end; o++ {
+		entry := v.Log[o]
+		if entry.Control {
+			batch := records.NewRecordBatch(o, compression.None)
+			batch.Attributes |= records.ControlAttribute
+			batch.PartitionLeaderEpoch = entry.Epoch
+			batch.AppendRecord(entry.Timestamp, records.ControlRecordKey(records.ControlLeaderChange), entry.Value, nil)
+			batches = append(batches, batch)
+			current = nil
+			continue
+		}
+
+		if current == nil || current.PartitionLeaderEpoch != entry.Epoch {
+			current = records.NewRecordBatch(o, compression.None)
+			current.PartitionLeaderEpoch = entry.Epoch
+			batches = append(batches, current)
+		}
+		current.AppendRecord(entry.Timestamp, nil, entry.Value, nil)
+	}
+
+	return records.WriteRecordBatches(batches)
+}
+
+func decodeEntries(data []byte) ([]*records.RecordBatch, []Entry, error) {
+	batches, err := records.ReadRecordBatches(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var entries []Entry
+	for _, batch := range batches {
+		for _, record := range batch.Records {
+			entries = append(entries, Entry{Epoch: batch.PartitionLeaderEpoch, Control: batch.IsControl(), Timestamp: batch.Timestamp(record), Value: record.Value})
+		}
+	}
+	return batches, entries, nil
+}
+
+////////////////////
+// Sending
+////////////////////
+
+func (v *Voter) send(to int32, apiKey int16, version int16, body protocol.RequestBody) int32 {
+	v.correlationId++
+	clientId := fmt.Sprintf("raft-client-%d", v.Id)
+	header := protocol.RequestHeader{ApiKey: apiKey, ApiVersion: version, CorrelationId: v.correlationId, ClientId: &clientId}
+
+	data, err := encodeRequest(header, body)
+	if err != nil {
+		v.cluster.fail(err)
+		return -1
+	}
+
+	v.inflight[header.CorrelationId] = header
+	v.cluster.tracef("%d -> %d %s", v.Id, to, apiName(apiKey))
+	v.cluster.Network.send(&Message{From: v.Id, To: to, ApiKey: apiKey, Data: data}, v.cluster.deliver)
+	return header.CorrelationId
+}
+
+func (v *Voter) respond(to *Message, header protocol.RequestHeader, body protocol.ResponseBody) {
+	data, err := encodeResponse(header, body)
+	if err != nil {
+		v.cluster.fail(err)
+		return
+	}
+	v.cluster.Network.send(&Message{From: v.Id, To: to.From, ApiKey: header.ApiKey, Response: true, Data: data}, v.cluster.deliver)
+}
+
+func (v *Voter) clusterId() *string {
+	id := v.ClusterId
+	return &id
+}
+
+func metadataTopic() *string {
+	topic := clustermetadata.MetadataTopic
+	return &topic
+}
+
+func (v *Voter) sendVote(to int32) {
+	request := &vote.VoteRequest{
+		ApiVersion: voteVersion,
+		ClusterId:  v.clusterId(),
+		VoterId:    to,
+		Topics: &[]vote.VoteRequestTopic{{
+			TopicName: metadataTopic(),
+			Partitions: &[]vote.VoteRequestTopicPartition{{
+				ReplicaEpoch:       v.Epoch,
+				ReplicaId:          v.Id,
+				ReplicaDirectoryId: v.DirectoryId,
+				VoterDirectoryId:   v.cluster.Voters[to].DirectoryId,
+				LastOffsetEpoch:    v.LastEpoch(),
+				LastOffset:         v.LogEndOffset(),
+			}},
+		}},
+	}
+	v.send(to, messages.Vote, voteVersion, request)
+}
+
+func (v *Voter) sendBeginQuorumEpochs() {
+	for _, id := range v.cluster.voterIds() {
+		if replica, ok := v.replicas[id]; ok && !replica.acknowledged {
+			v.send(id, messages.BeginQuorumEpoch, beginQuorumEpochVersion, &beginquorumepoch.BeginQuorumEpochRequest{
+				ApiVersion: beginQuorumEpochVersion,
+				ClusterId:  v.clusterId(),
+				VoterId:    id,
+				Topics: &[]beginquorumepoch.BeginQuorumEpochRequestTopic{{
+					TopicName: metadataTopic(),
+					Partitions: &[]beginquorumepoch.BeginQuorumEpochRequestTopicPartition{{
+						VoterDirectoryId: v.cluster.Voters[id].DirectoryId,
+						LeaderId:         v.Id,
+						LeaderEpoch:      v.Epoch,
+					}},
+				}},
+				LeaderEndpoints: &[]beginquorumepoch.BeginQuorumEpochRequestLeaderEndpoint{v.endpoint()},
+			})
+		}
+	}
+
+	// Retry until every voter acknowledged the epoch or fetched from the leader.
+	v.after(v.cluster.Config.RequestTimeout, v.sendBeginQuorumEpochs)
+}
+
+func (v *Voter) endpoint() beginquorumepoch.BeginQuorumEpochRequestLeaderEndpoint {
+	name, host := "CONTROLLER", fmt.Sprintf("controller-%d", v.Id)
+	return beginquorumepoch.BeginQuorumEpochRequestLeaderEndpoint{Name: &name, Host: &host, Port: 9093}
+}
+
+func (v *Voter) sendEndQuorumEpoch(to int32, candidates []int32) {
+	preferred := make([]endquorumepoch.EndQuorumEpochRequestTopicPartitionPreferredCandidate, 0, len(candidates))
+	for _, id := range candidates {
+		preferred = append(preferred, endquorumepoch.EndQuorumEpochRequestTopicPartitionPreferredCandidate{CandidateId: id, CandidateDirectoryId: v.cluster.Voters[id].DirectoryId})
+	}
+
+	endpoint := v.endpoint()
+	v.send(to, messages.EndQuorumEpoch, endQuorumEpochVersion, &endquorumepoch.EndQuorumEpochRequest{
+		ApiVersion: endQuorumEpochVersion,
+		ClusterId:  v.clusterId(),
+		Topics: &[]endquorumepoch.EndQuorumEpochRequestTopic{{
+			TopicName: metadataTopic(),
+			Partitions: &[]endquorumepoch.EndQuorumEpochRequestTopicPartition{{
+				LeaderId:            v.Id,
+				LeaderEpoch:         v.Epoch,
+				PreferredCandidates: &preferred,
+			}},
+		}},
+		LeaderEndpoints: &[]endquorumepoch.EndQuorumEpochRequestLeaderEndpoint{{Name: endpoint.Name, Host: endpoint.Host, Port: endpoint.Port}},
+	})
+}
+
+func (v *Voter) fetch() {
+	if v.Role != Follower || v.fetchInFlight >= 0 {
+		return
+	}
+
+	lastFetchedEpoch := int32(-1)
+	if len(v.Log) > 0 {
+		lastFetchedEpoch = v.LastEpoch()
+	}
+
+	request := &fetch.FetchRequest{
+		ApiVersion:   fetchVersion,
+		ClusterId:    v.clusterId(),
+		ReplicaState: &fetch.FetchRequestReplicaState{ReplicaId: v.Id, ReplicaEpoch: -1},
+		MaxWaitMs:    int32(v.cluster.Config.FetchInterval.Milliseconds()),
+		MaxBytes:     8 * 1024 * 1024,
+		SessionEpoch: -1,
+		RackId:       new(string),
+		Topics: &[]fetch.FetchRequestTopic{{
+			TopicId: clustermetadata.MetadataTopicId,
+			Partitions: &[]fetch.FetchRequestTopicPartition{{
+				CurrentLeaderEpoch: v.Epoch,
+				FetchOffset:        v.LogEndOffset(),
+				LastFetchedEpoch:   lastFetchedEpoch,
+				PartitionMaxBytes:  8 * 1024 * 1024,
+				ReplicaDirectoryId: v.DirectoryId,
+				HighWatermark:      v.HighWatermark,
+			}},
+		}},
+		ForgottenTopicsData: &[]fetch.FetchRequestForgottenTopicsData{},
+	}
+
+	correlationId := v.send(v.LeaderId, messages.Fetch, fetchVersion, request)
+	v.fetchInFlight = correlationId
+
+	// A lost request or response is retried after the request timeout.
+	v.after(v.cluster.Config.RequestTimeout, func() {
+		if v.fetchInFlight == correlationId {
+			v.fetchInFlight = -1
+			v.fetch()
+		}
+	})
+}
+
+////////////////////
+// Receiving
+////////////////////
+
+func (c *Cluster) deliver(m *Message) {
+	v := c.Voters[m.To]
+	if m.Response {
+		v.handleResponse(m)
+	} else {
+		v.handleRequest(m)
+	}
+}
+
+func (c *Cluster) fail(err error) {
+	c.Errors = append(c.Errors, err)
+	c.tracef("error: %v", err)
+}
+
+func (v *Voter) handleRequest(m *Message) {
+	request, body, err := decodeRequest(m.Data)
+	if err != nil {
+		v.cluster.fail(err)
+		return
+	}
+	v.cluster.tracef("%d <- %d %s", v.Id, m.From, apiName(request.ApiKey))
+
+	switch req := body.(type) {
+	case *vote.VoteRequest:
+		v.respond(m, request.RequestHeader, v.handleVote(req))
+	case *beginquorumepoch.BeginQuorumEpochRequest:
+		v.respond(m, request.RequestHeader, v.handleBeginQuorumEpoch(req))
+	case *endquorumepoch.EndQuorumEpochRequest:
+		v.respond(m, request.RequestHeader, v.handleEndQuorumEpoch(req))
+	case *fetch.FetchRequest:
+		v.respond(m, request.RequestHeader, v.handleFetch(req))
+	default:
+		v.cluster.fail(fmt.Errorf("%d received an unexpected %s request", v.Id, apiName(request.ApiKey)))
+	}
+}
+
+func (v *Voter) handleResponse(m *Message) {
+	header, body, err := decodeResponse(m.Data, v.inflight)
+	if err != nil {
+		v.cluster.fail(err)
+		return
+	}
+	delete(v.inflight, header.CorrelationId)
+	v.cluster.tracef("%d <- %d %s response", v.Id, m.From, apiName(header.ApiKey))
+
+	switch res := body.(type) {
+	case *vote.VoteResponse:
+		v.handleVoteResponse(m.From, res)
+	case *beginquorumepoch.BeginQuorumEpochResponse:
+		v.handleBeginQuorumEpochResponse(m.From, res)
+	case *endquorumepoch.EndQuorumEpochResponse:
+		v.handleEndQuorumEpochResponse(res)
+	case *fetch.FetchResponse:
+		v.handleFetchResponse(header.CorrelationId, res)
+	}
+}
+
+// upToDate returns whether a log ending with the epoch and offset is at least as up to date as the
+// log of the voter.
+func (v *Voter) upToDate(lastEpoch int32, endOffset int64) bool {
+	return lastEpoch > v.LastEpoch() || (lastEpoch == v.LastEpoch() && endOffset >= v.LogEndOffset())
+}
+
+func (v *Voter) handleVote(req *vote.VoteRequest) *vote.VoteResponse {
+	response := func(errorCode int16, granted bool) *vote.VoteResponse {
+		return &vote.VoteResponse{
+			ApiVersion: req.ApiVersion,
+			ErrorCode:  errorcodes.None,
+			Topics: &[]vote.VoteResponseTopic{{
+				TopicName:  metadataTopic(),
+				Partitions: &[]vote.VoteResponseTopicPartition{{ErrorCode: errorCode, LeaderId: v.LeaderId, LeaderEpoch: v.Epoch, VoteGranted: granted}},
+			}},
+			NodeEndpoints: &[]vote.VoteResponseNodeEndpoint{},
+		}
+	}
+
+	if req.ClusterId != nil && *req.ClusterId != v.ClusterId {
+		res := response(errorcodes.None, false)
+		res.ErrorCode = errorcodes.InconsistentClusterId
+		return res
+	}
+
+	p := (*(*req.Topics)[0].Partitions)[0]
+	if p.ReplicaEpoch < v.Epoch {
+		return response(errorcodes.FencedLeaderEpoch, false)
+	}
+	if p.ReplicaEpoch > v.Epoch {
+		v.becomeUnattached(p.ReplicaEpoch)
+	}
+
+	granted := v.Role == Unattached && (v.VotedFor == -1 || v.VotedFor == p.ReplicaId) && v.upToDate(p.LastOffsetEpoch, p.LastOffset)
+	if granted && v.VotedFor == -1 {
+		v.VotedFor = p.ReplicaId
+		v.cluster.tracef("%d voted for %d in epoch %d", v.Id, p.ReplicaId, v.Epoch)
+		v.startElectionTimer(v.randomElectionTimeout())
+	}
+	return response(errorcodes.None, granted)
+}
+
+func (v *Voter) handleVoteResponse(from int32, res *vote.VoteResponse) {
+	if res.ErrorCode != errorcodes.None || res.Topics == nil || len(*res.Topics) == 0 {
+		return
+	}
+
+	p := (*(*res.Topics)[0].Partitions)[0]
+	if v.observe(p.LeaderEpoch, p.LeaderId) {
+		return
+	}
+	if v.Role != Candidate || p.LeaderEpoch != v.Epoch || p.ErrorCode != errorcodes.None || !p.VoteGranted {
+		return
+	}
+
+	v.votes[from] = true
+	if len(v.votes) >= v.cluster.majority() {
+		v.becomeLeader()
+	}
+}
+
+func (v *Voter) handleBeginQuorumEpoch(req *beginquorumepoch.BeginQuorumEpochRequest) *beginquorumepoch.BeginQuorumEpochResponse {
+	response := func(errorCode int16) *beginquorumepoch.BeginQuorumEpochResponse {
+		return &beginquorumepoch.BeginQuorumEpochResponse{
+			ApiVersion: req.ApiVersion,
+			Topics: &[]beginquorumepoch.BeginQuorumEpochResponseTopic{{
+				TopicName:  metadataTopic(),
+				Partitions: &[]beginquorumepoch.BeginQuorumEpochResponseTopicPartition{{ErrorCode: errorCode, LeaderId: v.LeaderId, LeaderEpoch: v.Epoch}},
+			}},
+			NodeEndpoints: &[]beginquorumepoch.BeginQuorumEpochResponseNodeEndpoint{},
+		}
+	}
+
+	if req.ClusterId != nil && *req.ClusterId != v.ClusterId {
+		res := response(errorcodes.None)
+		res.ErrorCode = errorcodes.InconsistentClusterId
+		return res
+	}
+
+	p := (*(*req.Topics)[0].Partitions)[0]
+	if p.LeaderEpoch < v.Epoch {
+		return response(errorcodes.FencedLeaderEpoch)
+	}
+	if v.Role != Follower || v.Epoch != p.LeaderEpoch || v.LeaderId != p.LeaderId {
+		v.becomeFollower(p.LeaderEpoch, p.LeaderId)
+	}
+	return response(errorcodes.None)
+}
+
+func (v *Voter) handleBeginQuorumEpochResponse(from int32, res *beginquorumepoch.BeginQuorumEpochResponse) {
+	if res.ErrorCode != errorcodes.None || res.Topics == nil || len(*res.Topics) == 0 {
+		return
+	}
+
+	p := (*(*res.Topics)[0].Partitions)[0]
+	if v.observe(p.LeaderEpoch, p.LeaderId) {
+		return
+	}
+	if v.Role == Leader && p.ErrorCode == errorcodes.None && p.LeaderEpoch == v.Epoch {
+		v.replicas[from].acknowledged = true
+	}
+}
+
+func (v *Voter) handleEndQuorumEpoch(req *endquorumepoch.EndQuorumEpochRequest) *endquorumepoch.EndQuorumEpochResponse {
+	response := func(errorCode int16) *endquorumepoch.EndQuorumEpochResponse {
+		return &endquorumepoch.EndQuorumEpochResponse{
+			ApiVersion: req.ApiVersion,
+			Topics: &[]endquorumepoch.EndQuorumEpochResponseTopic{{
+				TopicName:  metadataTopic(),
+				Partitions: &[]endquorumepoch.EndQuorumEpochResponseTopicPartition{{ErrorCode: errorCode, LeaderId: v.LeaderId, LeaderEpoch: v.Epoch}},
+			}},
+			NodeEndpoints: &[]endquorumepoch.EndQuorumEpochResponseNodeEndpoint{},
+		}
+	}
+
+	if req.ClusterId != nil && *req.ClusterId != v.ClusterId {
+		res := response(errorcodes.None)
+		res.ErrorCode = errorcodes.InconsistentClusterId
+		return res
+	}
+
+	p := (*(*req.Topics)[0].Partitions)[0]
+	if p.LeaderEpoch < v.Epoch {
+		return response(errorcodes.FencedLeaderEpoch)
+	}
+	if p.LeaderEpoch > v.Epoch {
+		v.becomeUnattached(p.LeaderEpoch)
+	}
+
+	// The most up-to-date voter starts the election right away, the others back off by their
+	// position so that they vote for it instead of competing.
+	if (v.Role == Follower && v.LeaderId == p.LeaderId) || v.Role == Unattached {
+		position := 0
+		if p.PreferredCandidates != nil {
+			for i, candidate := range *p.PreferredCandidates {
+				if candidate.CandidateId == v.Id {
+					position = i
+				}
+			}
+		}
+
+		backoff := v.cluster.Config.ElectionBackoffMax * time.Duration(position) / time.Duration(len(v.cluster.Voters))
+		v.cluster.tracef("%d starts an election in %v after the leader %d resigned", v.Id, backoff, p.LeaderId)
+		if v.Role == Follower {
+			v.resetFetchTimer(backoff)
+		} else {
+			v.startElectionTimer(backoff)
+		}
+	}
+	return response(errorcodes.None)
+}
+
+func (v *Voter) handleEndQuorumEpochResponse(res *endquorumepoch.EndQuorumEpochResponse) {
+	if res.ErrorCode != errorcodes.None || res.Topics == nil || len(*res.Topics) == 0 {
+		return
+	}
+	p := (*(*res.Topics)[0].Partitions)[0]
+	v.observe(p.LeaderEpoch, p.LeaderId)
+}
+
+func (v *Voter) handleFetch(req *fetch.FetchRequest) *fetch.FetchResponse {
+	partition := fetch.FetchResponseResponsePartition{
+		HighWatermark:        v.HighWatermark,
+		LastStableOffset:     v.HighWatermark,
+		DivergingEpoch:       &fetch.FetchResponseResponsePartitionDivergingEpoch{Epoch: -1, EndOffset: -1},
+		CurrentLeader:        &fetch.FetchResponseResponsePartitionCurrentLeader{LeaderId: v.LeaderId, LeaderEpoch: v.Epoch},
+		SnapshotId:           &fetch.FetchResponseResponsePartitionSnapshotId{EndOffset: -1, Epoch: -1},
+		PreferredReadReplica: -1,
+		Records:              &[]byte{},
+	}
+	response := &fetch.FetchResponse{
+		ApiVersion:    req.ApiVersion,
+		Responses:     &[]fetch.FetchResponseResponse{{TopicId: clustermetadata.MetadataTopicId, Partitions: &[]fetch.FetchResponseResponsePartition{}}},
+		NodeEndpoints: &[]fetch.FetchResponseNodeEndpoint{},
+	}
+	done := func(errorCode int16) *fetch.FetchResponse {
+		partition.ErrorCode = errorCode
+		*(*response.Responses)[0].Partitions = append(*(*response.Responses)[0].Partitions, partition)
+		return response
+	}
+
+	if req.ClusterId != nil && *req.ClusterId != v.ClusterId {
+		response.ErrorCode = errorcodes.InconsistentClusterId
+		return done(errorcodes.None)
+	}
+
+	p := (*(*req.Topics)[0].Partitions)[0]
+	switch {
+	case p.CurrentLeaderEpoch < v.Epoch:
+		return done(errorcodes.FencedLeaderEpoch)
+	case p.CurrentLeaderEpoch > v.Epoch:
+		return done(errorcodes.UnknownLeaderEpoch)
+	case v.Role != Leader:
+		return done(errorcodes.NotLeaderOrFollower)
+	}
+
+	replicaId := req.ReplicaState.ReplicaId
+	replica, ok := v.replicas[replicaId]
+	if !ok {
+		return done(errorcodes.NotLeaderOrFollower)
+	}
+
+	// A follower whose last fetched epoch ends earlier in the log of the leader has diverged and
+	// must truncate before it can continue.
+	if p.FetchOffset > 0 {
+		epoch, endOffset := v.endOffsetForEpoch(p.LastFetchedEpoch)
+		if epoch != p.LastFetchedEpoch || endOffset < p.FetchOffset {
+			partition.DivergingEpoch = &fetch.FetchResponseResponsePartitionDivergingEpoch{Epoch: epoch, EndOffset: endOffset}
+			return done(errorcodes.None)
+		}
+	}
+
+	now := v.cluster.WallClock().UnixMilli()
+	replica.acknowledged = true
+	replica.lastFetch = v.cluster.now
+	replica.lastFetchTimestamp = now
+	replica.logEndOffset = p.FetchOffset
+	if p.FetchOffset >= v.LogEndOffset() {
+		replica.lastCaughtUpTimestamp = now
+	}
+	v.updateHighWatermark()
+	partition.HighWatermark = v.HighWatermark
+	partition.LastStableOffset = v.HighWatermark
+
+	data, err := v.encodeEntries(p.FetchOffset, v.cluster.Config.MaxFetchRecords)
+	if err != nil {
+		v.cluster.fail(err)
+		return done(errorcodes.UnknownServerError)
+	}
+	partition.Records = &data
+	return done(errorcodes.None)
+}
+
+func (v *Voter) handleFetchResponse(correlationId int32, res *fetch.FetchResponse) {
+	if v.Role != Follower || correlationId != v.fetchInFlight {
+		return
+	}
+	v.fetchInFlight = -1
+
+	if res.ErrorCode != errorcodes.None || res.Responses == nil || len(*res.Responses) == 0 {
+		v.after(v.cluster.Config.RequestTimeout, v.fetch)
+		return
+	}
+
+	p := (*(*res.Responses)[0].Partitions)[0]
+	if p.ErrorCode != errorcodes.None {
+		if p.CurrentLeader != nil && v.observe(p.CurrentLeader.LeaderEpoch, p.CurrentLeader.LeaderId) {
+			return
+		}
+		v.after(v.cluster.Config.FetchInterval, v.fetch)
+		return
+	}
+
+	v.resetFetchTimer(v.cluster.Config.FetchTimeout)
+
+	if p.DivergingEpoch != nil && p.DivergingEpoch.EndOffset >= 0 {
+		_, endOffset := v.endOffsetForEpoch(p.DivergingEpoch.Epoch)
+		v.truncate(min(endOffset, p.DivergingEpoch.EndOffset))
+		v.fetch()
+		return
+	}
+
+	var appended int
+	if p.Records != nil && len(*p.Records) > 0 {
+		batches, entries, err := decodeEntries(*p.Records)
+		if err != nil {
+			v.cluster.fail(fmt.Errorf("%d failed to decode the fetched records: %w", v.Id, err))
+			v.after(v.cluster.Config.FetchInterval, v.fetch)
+			return
+		}
+		if len(batches) > 0 && batches[0].BaseOffset == v.LogEndOffset() {
+			v.Log = append(v.Log, entries...)
+			appended = len(entries)
+		}
+	}
+
+	if hw := min(p.HighWatermark, v.LogEndOffset()); hw > v.HighWatermark {
+		v.HighWatermark = hw
+	}
+
+	if appended > 0 {
+		v.fetch()
+	} else {
+		v.after(v.cluster.Config.FetchInterval, v.fetch)
+	}
+}
@@ -0,0 +1,321 @@
+package raftvoters
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/scholzj/go-kafka-protocol/api/addraftvoter"
+	"github.com/scholzj/go-kafka-protocol/api/removeraftvoter"
+	"github.com/scholzj/go-kafka-protocol/api/updateraftvoter"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+// The range of kraft.version feature levels advertised in UpdateRaftVoter requests. Level 1 is the
+// first one which supports dynamic quorums.
+const (
+	MinKRaftVersion int16 = 0
+	MaxKRaftVersion int16 = 1
+)
+
+// DefaultTimeout is the TimeoutMs sent in AddRaftVoter requests.
+const DefaultTimeout = 30 * time.Second
+
+// ChangeType is the kind of a single voter change.
+type ChangeType int
+
+const (
+	AddVoter    ChangeType = iota // AddRaftVoter: a caught-up observer becomes a voter.
+	RemoveVoter                   // RemoveRaftVoter: a voter becomes an observer.
+	UpdateVoter                   // UpdateRaftVoter: a voter changes its endpoints.
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case AddVoter:
+		return "AddVoter"
+	case RemoveVoter:
+		return "RemoveVoter"
+	case UpdateVoter:
+		return "UpdateVoter"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(t))
+	}
+}
+
+// Change is one step of a plan. For RemoveVoter only the key of the voter is used.
+type Change struct {
+	Type  ChangeType
+	Voter Voter
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s", c.Type, c.Voter.VoterKey)
+}
+
+// Plan returns the changes which turn the voter set of the quorum into the target voter set, one
+// voter at a time as KIP-853 requires. Endpoint updates come first. New voters are added before old
+// ones are removed, alternating between the two, so that the fault tolerance of the quorum does not
+// drop while it is reshaped. Voters which keep their id but got a new directory id are replaced by
+// removing the old key before adding the new one, since the voter set cannot hold the same id
+// twice. The current leader is removed last.
+//
+// The endpoints of a voter are only compared when the quorum knows them; Plan never adds a change
+// for a voter which already matches the target.
+func Plan(quorum *Quorum, target []Voter) ([]Change, error) {
+	if len(target) == 0 {
+		return nil, ErrEmptyVoterSet
+	}
+
+	wanted := make(map[int32]Voter, len(target))
+	for _, v := range target {
+		if err := v.Validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := wanted[v.Id]; ok {
+			return nil, fmt.Errorf("%w: voter %d is in the target voter set more than once", ErrDuplicateVoter, v.Id)
+		}
+		wanted[v.Id] = v
+	}
+
+	var updates, adds, removes []Change
+	var replacements [][2]Change
+	for _, current := range quorum.Voters {
+		v, ok := wanted[current.Id]
+		switch {
+		case !ok:
+			removes = append(removes, Change{Type: RemoveVoter, Voter: Voter{VoterKey: current.VoterKey}})
+		case v.DirectoryId != current.DirectoryId:
+			replacements = append(replacements, [2]Change{{Type: RemoveVoter, Voter: Voter{VoterKey: current.VoterKey}}, {Type: AddVoter, Voter: v}})
+		case len(current.Endpoints) > 0 && !sameEndpoints(current.Endpoints, v.Endpoints):
+			updates = append(updates, Change{Type: UpdateVoter, Voter: v})
+		}
+	}
+	for _, v := range target {
+		if quorum.Voter(v.Id) == nil {
+			adds = append(adds, Change{Type: AddVoter, Voter: v})
+		}
+	}
+
+	sort.SliceStable(adds, func(i, j int) bool { return adds[i].Voter.Id < adds[j].Voter.Id })
+	leaderLast := func(a int32, b int32) bool {
+		if (a == quorum.LeaderId) != (b == quorum.LeaderId) {
+			return b == quorum.LeaderId
+		}
+		return a < b
+	}
+	sort.SliceStable(removes, func(i, j int) bool { return leaderLast(removes[i].Voter.Id, removes[j].Voter.Id) })
+	sort.SliceStable(replacements, func(i, j int) bool { return leaderLast(replacements[i][0].Voter.Id, replacements[j][0].Voter.Id) })
+
+	plan := append([]Change(nil), updates...)
+	for len(adds) > 0 || len(removes) > 0 {
+		if len(adds) > 0 {
+			plan = append(plan, adds[0])
+			adds = adds[1:]
+		}
+		if len(removes) > 0 {
+			plan = append(plan, removes[0])
+			removes = removes[1:]
+		}
+	}
+	for _, replacement := range replacements {
+		plan = append(plan, replacement[:]...)
+	}
+
+	// The voter set must never become empty, which can only happen when a single voter is replaced.
+	size := len(quorum.Voters)
+	for _, change := range plan {
+		switch change.Type {
+		case AddVoter:
+			size++
+		case RemoveVoter:
+			size--
+		}
+		if size == 0 {
+			return nil, fmt.Errorf("%w: voter %d cannot be replaced in a single-voter quorum; add another voter first", ErrEmptyVoterSet, change.Voter.Id)
+		}
+	}
+
+	return plan, nil
+}
+
+// Applied returns whether the quorum already reflects the change, for example to verify it with a
+// fresh DescribeQuorum after a response was lost.
+func Applied(quorum *Quorum, change Change) bool {
+	switch change.Type {
+	case AddVoter:
+		return quorum.HasVoter(change.Voter.VoterKey)
+	case RemoveVoter:
+		return !quorum.HasVoter(change.Voter.VoterKey)
+	case UpdateVoter:
+		v := quorum.Voter(change.Voter.Id)
+		return v != nil && v.DirectoryId == change.Voter.DirectoryId && sameEndpoints(v.Endpoints, change.Voter.Endpoints)
+	default:
+		return false
+	}
+}
+
+////////////////////
+// Requests
+////////////////////
+
+func nullableClusterId(clusterId string) *string {
+	if clusterId == "" {
+		return nil
+	}
+	return &clusterId
+}
+
+// NewAddRaftVoterRequest creates an AddRaftVoter request which is acknowledged once the new voter set
+// is committed.
+func NewAddRaftVoterRequest(clusterId string, voter Voter, timeout time.Duration) *addraftvoter.AddRaftVoterRequest {
+	listeners := make([]addraftvoter.AddRaftVoterRequestListener, 0, len(voter.Endpoints))
+	for _, endpoint := range voter.Endpoints {
+		name, host := endpoint.Name, endpoint.Host
+		listeners = append(listeners, addraftvoter.AddRaftVoterRequestListener{Name: &name, Host: &host, Port: endpoint.Port})
+	}
+
+	return &addraftvoter.AddRaftVoterRequest{
+		ApiVersion:       1,
+		ClusterId:        nullableClusterId(clusterId),
+		TimeoutMs:        int32(timeout.Milliseconds()),
+		VoterId:          voter.Id,
+		VoterDirectoryId: voter.DirectoryId,
+		Listeners:        &listeners,
+		AckWhenCommitted: true,
+	}
+}
+
+// NewRemoveRaftVoterRequest creates a RemoveRaftVoter request.
+func NewRemoveRaftVoterRequest(clusterId string, key VoterKey) *removeraftvoter.RemoveRaftVoterRequest {
+	return &removeraftvoter.RemoveRaftVoterRequest{
+		ApiVersion:       0,
+		ClusterId:        nullableClusterId(clusterId),
+		VoterId:          key.Id,
+		VoterDirectoryId: key.DirectoryId,
+	}
+}
+
+// NewUpdateRaftVoterRequest creates an UpdateRaftVoter request. Kafka controllers send it themselves
+// when their listeners or supported kraft.version range change.
+func NewUpdateRaftVoterRequest(clusterId string, leaderEpoch int32, voter Voter, minKRaftVersion int16, maxKRaftVersion int16) *updateraftvoter.UpdateRaftVoterRequest {
+	listeners := make([]updateraftvoter.UpdateRaftVoterRequestListener, 0, len(voter.Endpoints))
+	for _, endpoint := range voter.Endpoints {
+		name, host := endpoint.Name, endpoint.Host
+		listeners = append(listeners, updateraftvoter.UpdateRaftVoterRequestListener{Name: &name, Host: &host, Port: endpoint.Port})
+	}
+
+	return &updateraftvoter.UpdateRaftVoterRequest{
+		ApiVersion:          0,
+		ClusterId:           nullableClusterId(clusterId),
+		CurrentLeaderEpoch:  leaderEpoch,
+		VoterId:             voter.Id,
+		VoterDirectoryId:    voter.DirectoryId,
+		Listeners:           &listeners,
+		KRaftVersionFeature: &updateraftvoter.UpdateRaftVoterRequestKRaftVersionFeature{MinSupportedVersion: minKRaftVersion, MaxSupportedVersion: maxKRaftVersion},
+	}
+}
+
+// Request creates the request body for the change, using DefaultTimeout and the default kraft.version
+// range.
+func (c Change) Request(clusterId string, leaderEpoch int32) protocol.RequestBody {
+	switch c.Type {
+	case AddVoter:
+		return NewAddRaftVoterRequest(clusterId, c.Voter, DefaultTimeout)
+	case RemoveVoter:
+		return NewRemoveRaftVoterRequest(clusterId, c.Voter.VoterKey)
+	default:
+		return NewUpdateRaftVoterRequest(clusterId, leaderEpoch, c.Voter, MinKRaftVersion, MaxKRaftVersion)
+	}
+}
+
+////////////////////
+// Responses
+////////////////////
+
+// ChangeError is a failed voter change. It unwraps to the *errorcodes.Error of the response and
+// matches the ErrDuplicateVoter, ErrVoterNotFound and ErrInvalidVoterKey sentinels.
+type ChangeError struct {
+	Change Change
+	Err    *errorcodes.Error
+
+	// The leader reported by an UpdateRaftVoter response, or -1.
+	LeaderId    int32
+	LeaderEpoch int32
+}
+
+func (e *ChangeError) Error() string {
+	return fmt.Sprintf("%s failed: %v", e.Change, e.Err)
+}
+
+func (e *ChangeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ChangeError) Is(target error) bool {
+	switch e.Err.Code {
+	case errorcodes.DuplicateVoter:
+		return target == ErrDuplicateVoter
+	case errorcodes.VoterNotFound:
+		return target == ErrVoterNotFound
+	case errorcodes.InvalidVoterKey:
+		return target == ErrInvalidVoterKey
+	default:
+		return false
+	}
+}
+
+// Retriable returns whether the same change can be sent again, possibly to a new leader. A timed
+// out AddRaftVoter usually means that the observer has not caught up yet. Before retrying a change
+// whose outcome is unknown, check it with Applied.
+func (e *ChangeError) Retriable() bool {
+	return errorcodes.Retriable(e.Err.Code)
+}
+
+func changeError(change Change, code int16, message *string) error {
+	err := errorcodes.ToError(code, message)
+	if err == nil {
+		return nil
+	}
+
+	var kafkaErr *errorcodes.Error
+	errors.As(err, &kafkaErr)
+	return &ChangeError{Change: change, Err: kafkaErr, LeaderId: -1, LeaderEpoch: -1}
+}
+
+// CheckAddRaftVoterResponse returns a *ChangeError when the response carries an error.
+func CheckAddRaftVoterResponse(change Change, response *addraftvoter.AddRaftVoterResponse) error {
+	return changeError(change, response.ErrorCode, response.ErrorMessage)
+}
+
+// CheckRemoveRaftVoterResponse returns a *ChangeError when the response carries an error.
+func CheckRemoveRaftVoterResponse(change Change, response *removeraftvoter.RemoveRaftVoterResponse) error {
+	return changeError(change, response.ErrorCode, response.ErrorMessage)
+}
+
+// CheckUpdateRaftVoterResponse returns a *ChangeError when the response carries an error, with the
+// current leader when the response names one.
+func CheckUpdateRaftVoterResponse(change Change, response *updateraftvoter.UpdateRaftVoterResponse) error {
+	err := changeError(change, response.ErrorCode, nil)
+	if err != nil && response.CurrentLeader != nil {
+		err.(*ChangeError).LeaderId = response.CurrentLeader.LeaderId
+		err.(*ChangeError).LeaderEpoch = response.CurrentLeader.LeaderEpoch
+	}
+	return err
+}
+
+// CheckResponse dispatches to the Check function matching the response type.
+func CheckResponse(change Change, response protocol.ResponseBody) error {
+	switch r := response.(type) {
+	case *addraftvoter.AddRaftVoterResponse:
+		return CheckAddRaftVoterResponse(change, r)
+	case *removeraftvoter.RemoveRaftVoterResponse:
+		return CheckRemoveRaftVoterResponse(change, r)
+	case *updateraftvoter.UpdateRaftVoterResponse:
+		return CheckUpdateRaftVoterResponse(change, r)
+	default:
+		return fmt.Errorf("unexpected response %T for %s", response, change)
+	}
+}
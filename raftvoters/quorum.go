@@ -0,0 +1,241 @@
+package raftvoters
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/describequorum"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+// Helpers for dynamic KRaft quorums (KIP-853). Voters are identified by their id together with the
+// directory id of their metadata log directory, so that a controller whose disk was replaced cannot
+// silently take the place of the old voter. The voter set can only change one voter at a time; Plan
+// turns a target voter set into such a sequence of AddRaftVoter, RemoveRaftVoter and UpdateRaftVoter
+// requests, and the Check* functions interpret their responses.
+
+var (
+	ErrInvalidVoterKey  = errors.New("invalid voter key")
+	ErrInvalidEndpoint  = errors.New("invalid voter endpoint")
+	ErrDuplicateVoter   = errors.New("duplicate voter")
+	ErrVoterNotFound    = errors.New("voter not found")
+	ErrEmptyVoterSet    = errors.New("the voter set cannot be empty")
+	ErrNotCaughtUp      = errors.New("the replica has not caught up with the leader")
+	ErrNoDirectoryIds   = errors.New("the DescribeQuorum response does not carry directory ids (version 2+ is required)")
+	ErrMissingPartition = errors.New("the DescribeQuorum response has no metadata partition")
+)
+
+// VoterKey identifies a voter by its replica id and the directory id of its metadata log.
+type VoterKey struct {
+	Id          int32
+	DirectoryId uuid.UUID
+}
+
+func (k VoterKey) String() string {
+	return fmt.Sprintf("%d (%s)", k.Id, k.DirectoryId)
+}
+
+// Validate checks that the key can be used in a voter change: the id must not be negative and the
+// directory id must be set.
+func (k VoterKey) Validate() error {
+	if k.Id < 0 {
+		return fmt.Errorf("%w: negative id %d", ErrInvalidVoterKey, k.Id)
+	}
+	if k.DirectoryId == uuid.Nil {
+		return fmt.Errorf("%w: voter %d has no directory id", ErrInvalidVoterKey, k.Id)
+	}
+	return nil
+}
+
+// Endpoint is a listener on which a controller accepts connections from the other voters.
+type Endpoint struct {
+	Name string
+	Host string
+	Port uint16
+}
+
+func (e Endpoint) String() string {
+	return fmt.Sprintf("%s://%s:%d", e.Name, e.Host, e.Port)
+}
+
+// Voter is a member of the target voter set with the endpoints it should be registered with.
+type Voter struct {
+	VoterKey
+	Endpoints []Endpoint
+}
+
+// Validate checks the voter key and its endpoints. A voter needs at least one endpoint, and every
+// endpoint needs a unique listener name, a host and a port.
+func (v Voter) Validate() error {
+	if err := v.VoterKey.Validate(); err != nil {
+		return err
+	}
+	if len(v.Endpoints) == 0 {
+		return fmt.Errorf("%w: voter %d has no endpoints", ErrInvalidEndpoint, v.Id)
+	}
+
+	names := make(map[string]bool)
+	for _, endpoint := range v.Endpoints {
+		switch {
+		case endpoint.Name == "":
+			return fmt.Errorf("%w: voter %d has an endpoint without a listener name", ErrInvalidEndpoint, v.Id)
+		case endpoint.Host == "":
+			return fmt.Errorf("%w: listener %s of voter %d has no host", ErrInvalidEndpoint, endpoint.Name, v.Id)
+		case endpoint.Port == 0:
+			return fmt.Errorf("%w: listener %s of voter %d has no port", ErrInvalidEndpoint, endpoint.Name, v.Id)
+		case names[endpoint.Name]:
+			return fmt.Errorf("%w: voter %d has listener %s more than once", ErrInvalidEndpoint, v.Id, endpoint.Name)
+		}
+		names[endpoint.Name] = true
+	}
+	return nil
+}
+
+// sameEndpoints compares the endpoints ignoring their order.
+func sameEndpoints(a []Endpoint, b []Endpoint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sorted := func(endpoints []Endpoint) []Endpoint {
+		s := append([]Endpoint(nil), endpoints...)
+		sort.Slice(s, func(i, j int) bool { return s[i].Name < s[j].Name })
+		return s
+	}
+	sa, sb := sorted(a), sorted(b)
+	for i := range sa {
+		if sa[i] != sb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Replica is a voter or an observer of the metadata partition as reported by the leader.
+type Replica struct {
+	VoterKey
+	Endpoints             []Endpoint // The endpoints from the Nodes of the response, if any.
+	LogEndOffset          int64      // -1 when unknown.
+	LastFetchTimestamp    int64      // -1 when unknown.
+	LastCaughtUpTimestamp int64      // -1 when unknown.
+}
+
+// Quorum is the state of the metadata partition as returned by DescribeQuorum.
+type Quorum struct {
+	LeaderId      int32
+	LeaderEpoch   int32
+	HighWatermark int64
+	Voters        []Replica
+	Observers     []Replica
+}
+
+// FromDescribeQuorum converts a DescribeQuorum response into a Quorum. It returns the top level or
+// partition error as an *errorcodes.Error, and ErrNoDirectoryIds for responses older than version 2
+// which cannot be used to plan voter changes.
+func FromDescribeQuorum(response *describequorum.DescribeQuorumResponse) (*Quorum, error) {
+	if err := errorcodes.ToError(response.ErrorCode, response.ErrorMessage); err != nil {
+		return nil, err
+	}
+	if response.ApiVersion < 2 {
+		return nil, ErrNoDirectoryIds
+	}
+	if response.Topics == nil || len(*response.Topics) == 0 || (*response.Topics)[0].Partitions == nil || len(*(*response.Topics)[0].Partitions) == 0 {
+		return nil, ErrMissingPartition
+	}
+
+	partition := (*(*response.Topics)[0].Partitions)[0]
+	if err := errorcodes.ToError(partition.ErrorCode, partition.ErrorMessage); err != nil {
+		return nil, err
+	}
+
+	endpoints := make(map[int32][]Endpoint)
+	if response.Nodes != nil {
+		for _, node := range *response.Nodes {
+			if node.Listeners == nil {
+				continue
+			}
+			for _, listener := range *node.Listeners {
+				endpoints[node.NodeId] = append(endpoints[node.NodeId], Endpoint{Name: deref(listener.Name), Host: deref(listener.Host), Port: listener.Port})
+			}
+		}
+	}
+
+	quorum := &Quorum{LeaderId: partition.LeaderId, LeaderEpoch: partition.LeaderEpoch, HighWatermark: partition.HighWatermark}
+	if partition.CurrentVoters != nil {
+		for _, v := range *partition.CurrentVoters {
+			quorum.Voters = append(quorum.Voters, Replica{
+				VoterKey:              VoterKey{Id: v.ReplicaId, DirectoryId: v.ReplicaDirectoryId},
+				Endpoints:             endpoints[v.ReplicaId],
+				LogEndOffset:          v.LogEndOffset,
+				LastFetchTimestamp:    v.LastFetchTimestamp,
+				LastCaughtUpTimestamp: v.LastCaughtUpTimestamp,
+			})
+		}
+	}
+	if partition.Observers != nil {
+		for _, o := range *partition.Observers {
+			quorum.Observers = append(quorum.Observers, Replica{
+				VoterKey:              VoterKey{Id: o.ReplicaId, DirectoryId: o.ReplicaDirectoryId},
+				Endpoints:             endpoints[o.ReplicaId],
+				LogEndOffset:          o.LogEndOffset,
+				LastFetchTimestamp:    o.LastFetchTimestamp,
+				LastCaughtUpTimestamp: o.LastCaughtUpTimestamp,
+			})
+		}
+	}
+
+	return quorum, nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Voter returns the voter with the id, or nil.
+func (q *Quorum) Voter(id int32) *Replica {
+	for i := range q.Voters {
+		if q.Voters[i].Id == id {
+			return &q.Voters[i]
+		}
+	}
+	return nil
+}
+
+// Observer returns the observer with the key, or nil.
+func (q *Quorum) Observer(key VoterKey) *Replica {
+	for i := range q.Observers {
+		if q.Observers[i].VoterKey == key {
+			return &q.Observers[i]
+		}
+	}
+	return nil
+}
+
+// HasVoter returns whether the voter with exactly this key is part of the voter set.
+func (q *Quorum) HasVoter(key VoterKey) bool {
+	v := q.Voter(key.Id)
+	return v != nil && v.DirectoryId == key.DirectoryId
+}
+
+// CaughtUp checks that the replica with the key is an observer which fetched up to maxLag offsets
+// below the high watermark. The leader only adds an observer which has caught up; the check lets
+// automation wait for it instead of retrying AddRaftVoter until it stops timing out.
+func (q *Quorum) CaughtUp(key VoterKey, maxLag int64) error {
+	observer := q.Observer(key)
+	if observer == nil {
+		return fmt.Errorf("%w: %s is not an observer of the metadata partition", ErrNotCaughtUp, key)
+	}
+	if observer.LogEndOffset < 0 || observer.LogEndOffset < q.HighWatermark-maxLag {
+		return fmt.Errorf("%w: %s is at offset %d, the high watermark is %d", ErrNotCaughtUp, key, observer.LogEndOffset, q.HighWatermark)
+	}
+	return nil
+}
+
+// Majority returns how many voters must acknowledge a record to commit it.
+func (q *Quorum) Majority() int {
+	return len(q.Voters)/2 + 1
+}
@@ -0,0 +1,274 @@
+package raftvoters
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/addraftvoter"
+	"github.com/scholzj/go-kafka-protocol/api/describequorum"
+	"github.com/scholzj/go-kafka-protocol/api/removeraftvoter"
+	"github.com/scholzj/go-kafka-protocol/api/updateraftvoter"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+	"github.com/scholzj/go-kafka-protocol/raftsim"
+)
+
+func voter(id int32, dir byte, host string) Voter {
+	return Voter{
+		VoterKey:  VoterKey{Id: id, DirectoryId: uuid.UUID{dir}},
+		Endpoints: []Endpoint{{Name: "CONTROLLER", Host: host, Port: 9093}},
+	}
+}
+
+func quorumOf(leaderId int32, voters ...Voter) *Quorum {
+	q := &Quorum{LeaderId: leaderId, LeaderEpoch: 5, HighWatermark: 100}
+	for _, v := range voters {
+		q.Voters = append(q.Voters, Replica{VoterKey: v.VoterKey, Endpoints: v.Endpoints, LogEndOffset: 100})
+	}
+	return q
+}
+
+func TestFromDescribeQuorum(t *testing.T) {
+	c := raftsim.NewCluster(raftsim.NewConfig(1, 2, 3))
+	if !c.RunUntil(func() bool { return c.StableLeader() != nil }, time.Minute) {
+		t.Fatal("the simulated quorum did not elect a leader")
+	}
+	leader := c.StableLeader()
+
+	response, err := c.DescribeQuorum(leader.Id)
+	if err != nil {
+		t.Fatalf("DescribeQuorum: %v", err)
+	}
+	q, err := FromDescribeQuorum(response)
+	if err != nil {
+		t.Fatalf("FromDescribeQuorum: %v", err)
+	}
+	if q.LeaderId != leader.Id || q.LeaderEpoch != leader.Epoch || len(q.Voters) != 3 || q.Majority() != 2 {
+		t.Fatalf("unexpected quorum %+v", q)
+	}
+	for _, v := range q.Voters {
+		if !q.HasVoter(VoterKey{Id: v.Id, DirectoryId: c.Voters[v.Id].DirectoryId}) || len(v.Endpoints) != 1 || v.Endpoints[0].Port != 9093 {
+			t.Errorf("unexpected voter %+v", v)
+		}
+	}
+
+	// Followers do not know the quorum state.
+	for id := range c.Voters {
+		if id == leader.Id {
+			continue
+		}
+		response, err := c.DescribeQuorum(id)
+		if err != nil {
+			t.Fatalf("DescribeQuorum: %v", err)
+		}
+		var kafkaErr *errorcodes.Error
+		if _, err := FromDescribeQuorum(response); !errors.As(err, &kafkaErr) || kafkaErr.Code != errorcodes.NotLeaderOrFollower {
+			t.Errorf("follower %d: unexpected error %v", id, err)
+		}
+	}
+
+	if _, err := FromDescribeQuorum(&describequorum.DescribeQuorumResponse{ApiVersion: 1}); !errors.Is(err, ErrNoDirectoryIds) {
+		t.Errorf("version 1 response: unexpected error %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		voter Voter
+		err   error
+	}{
+		{voter(1, 1, "c1"), nil},
+		{Voter{VoterKey: VoterKey{Id: -1, DirectoryId: uuid.UUID{1}}, Endpoints: voter(1, 1, "c1").Endpoints}, ErrInvalidVoterKey},
+		{Voter{VoterKey: VoterKey{Id: 1}, Endpoints: voter(1, 1, "c1").Endpoints}, ErrInvalidVoterKey},
+		{Voter{VoterKey: VoterKey{Id: 1, DirectoryId: uuid.UUID{1}}}, ErrInvalidEndpoint},
+		{Voter{VoterKey: VoterKey{Id: 1, DirectoryId: uuid.UUID{1}}, Endpoints: []Endpoint{{Name: "CONTROLLER", Host: "c1"}}}, ErrInvalidEndpoint},
+		{Voter{VoterKey: VoterKey{Id: 1, DirectoryId: uuid.UUID{1}}, Endpoints: []Endpoint{{Name: "A", Host: "c1", Port: 1}, {Name: "A", Host: "c1", Port: 2}}}, ErrInvalidEndpoint},
+	}
+
+	for i, test := range tests {
+		if err := test.voter.Validate(); !errors.Is(err, test.err) || (err == nil) != (test.err == nil) {
+			t.Errorf("test %d: Validate() = %v, want %v", i, err, test.err)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	v1, v2, v3, v4, v5 := voter(1, 1, "c1"), voter(2, 2, "c2"), voter(3, 3, "c3"), voter(4, 4, "c4"), voter(5, 5, "c5")
+
+	tests := []struct {
+		name   string
+		quorum *Quorum
+		target []Voter
+		want   []Change
+	}{
+		{"no change", quorumOf(1, v1, v2, v3), []Voter{v3, v2, v1}, nil},
+		{"grow", quorumOf(1, v1), []Voter{v1, v2, v3}, []Change{{AddVoter, v2}, {AddVoter, v3}}},
+		{"shrink removes the leader last", quorumOf(1, v1, v2, v3, v4, v5), []Voter{v4, v5, v3}, []Change{
+			{RemoveVoter, Voter{VoterKey: v2.VoterKey}},
+			{RemoveVoter, Voter{VoterKey: v1.VoterKey}},
+		}},
+		{"move adds before removing", quorumOf(2, v1, v2, v3), []Voter{v3, v4, v5}, []Change{
+			{AddVoter, v4},
+			{RemoveVoter, Voter{VoterKey: v1.VoterKey}},
+			{AddVoter, v5},
+			{RemoveVoter, Voter{VoterKey: v2.VoterKey}},
+		}},
+		{"endpoint update", quorumOf(1, v1, v2, v3), []Voter{v1, voter(2, 2, "new-c2"), v3}, []Change{{UpdateVoter, voter(2, 2, "new-c2")}}},
+		{"directory replacement", quorumOf(1, v1, v2, v3), []Voter{voter(1, 9, "c1"), v2, voter(3, 8, "c3")}, []Change{
+			{RemoveVoter, Voter{VoterKey: v3.VoterKey}},
+			{AddVoter, voter(3, 8, "c3")},
+			{RemoveVoter, Voter{VoterKey: v1.VoterKey}},
+			{AddVoter, voter(1, 9, "c1")},
+		}},
+	}
+
+	for _, test := range tests {
+		plan, err := Plan(test.quorum, test.target)
+		if err != nil {
+			t.Errorf("%s: Plan: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(plan, test.want) {
+			t.Errorf("%s: Plan() =\n%v\nwant\n%v", test.name, plan, test.want)
+		}
+
+		// Applying the plan step by step reaches the target.
+		q := test.quorum
+		for _, change := range plan {
+			if Applied(q, change) {
+				t.Errorf("%s: %s is already applied", test.name, change)
+			}
+			q = apply(q, change)
+			if !Applied(q, change) {
+				t.Errorf("%s: %s is not applied", test.name, change)
+			}
+		}
+		if len(q.Voters) != len(test.target) {
+			t.Errorf("%s: the plan ends with %d voters", test.name, len(q.Voters))
+		}
+	}
+
+	for _, test := range []struct {
+		quorum *Quorum
+		target []Voter
+		err    error
+	}{
+		{quorumOf(1, v1), nil, ErrEmptyVoterSet},
+		{quorumOf(1, v1), []Voter{voter(1, 9, "c1")}, ErrEmptyVoterSet},
+		{quorumOf(1, v1), []Voter{v1, v1}, ErrDuplicateVoter},
+		{quorumOf(1, v1), []Voter{{VoterKey: v2.VoterKey}}, ErrInvalidEndpoint},
+	} {
+		if _, err := Plan(test.quorum, test.target); !errors.Is(err, test.err) {
+			t.Errorf("Plan(%v) error = %v, want %v", test.target, err, test.err)
+		}
+	}
+}
+
+func apply(q *Quorum, change Change) *Quorum {
+	next := &Quorum{LeaderId: q.LeaderId, LeaderEpoch: q.LeaderEpoch, HighWatermark: q.HighWatermark}
+	for _, v := range q.Voters {
+		if v.Id == change.Voter.Id && change.Type != AddVoter {
+			if change.Type == UpdateVoter {
+				v.Endpoints = change.Voter.Endpoints
+				next.Voters = append(next.Voters, v)
+			}
+			continue
+		}
+		next.Voters = append(next.Voters, v)
+	}
+	if change.Type == AddVoter {
+		next.Voters = append(next.Voters, Replica{VoterKey: change.Voter.VoterKey, Endpoints: change.Voter.Endpoints})
+	}
+	return next
+}
+
+func TestCaughtUp(t *testing.T) {
+	q := quorumOf(1, voter(1, 1, "c1"))
+	key := VoterKey{Id: 2, DirectoryId: uuid.UUID{2}}
+	if err := q.CaughtUp(key, 10); !errors.Is(err, ErrNotCaughtUp) {
+		t.Errorf("unknown observer: %v", err)
+	}
+
+	q.Observers = []Replica{{VoterKey: key, LogEndOffset: 80}}
+	if err := q.CaughtUp(key, 10); !errors.Is(err, ErrNotCaughtUp) {
+		t.Errorf("lagging observer: %v", err)
+	}
+	if err := q.CaughtUp(key, 20); err != nil {
+		t.Errorf("caught up observer: %v", err)
+	}
+}
+
+func TestRequests(t *testing.T) {
+	v := voter(4, 4, "c4")
+	for _, change := range []Change{{AddVoter, v}, {RemoveVoter, v}, {UpdateVoter, v}} {
+		body := change.Request("cluster", 7)
+
+		var buf bytes.Buffer
+		if err := body.Write(&buf); err != nil {
+			t.Fatalf("%s: Write: %v", change, err)
+		}
+		encoded := append([]byte(nil), buf.Bytes()...)
+
+		var decoded protocol.RequestBody
+		var version int16
+		switch r := body.(type) {
+		case *addraftvoter.AddRaftVoterRequest:
+			decoded, version = &addraftvoter.AddRaftVoterRequest{}, r.ApiVersion
+		case *removeraftvoter.RemoveRaftVoterRequest:
+			decoded, version = &removeraftvoter.RemoveRaftVoterRequest{}, r.ApiVersion
+		case *updateraftvoter.UpdateRaftVoterRequest:
+			decoded, version = &updateraftvoter.UpdateRaftVoterRequest{}, r.ApiVersion
+		}
+		request := protocol.Request{RequestHeader: protocol.RequestHeader{ApiVersion: version}, Body: &buf}
+		if err := decoded.Read(&request); err != nil {
+			t.Fatalf("%s: Read: %v", change, err)
+		}
+		var again bytes.Buffer
+		if err := decoded.Write(&again); err != nil {
+			t.Fatalf("%s: Write: %v", change, err)
+		}
+		if !bytes.Equal(again.Bytes(), encoded) {
+			t.Errorf("%s: round trip\n got %+v\nwant %+v", change, decoded, body)
+		}
+	}
+
+	add := NewAddRaftVoterRequest("", v, time.Minute)
+	if add.ClusterId != nil || add.TimeoutMs != 60000 || !add.AckWhenCommitted || *(*add.Listeners)[0].Host != "c4" {
+		t.Errorf("unexpected AddRaftVoter request %+v", add)
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	change := Change{Type: AddVoter, Voter: voter(4, 4, "c4")}
+
+	if err := CheckResponse(change, &addraftvoter.AddRaftVoterResponse{}); err != nil {
+		t.Errorf("successful response: %v", err)
+	}
+
+	err := CheckResponse(change, &addraftvoter.AddRaftVoterResponse{ErrorCode: errorcodes.DuplicateVoter})
+	var changeErr *ChangeError
+	if !errors.As(err, &changeErr) || !errors.Is(err, ErrDuplicateVoter) || changeErr.Retriable() {
+		t.Errorf("DuplicateVoter: unexpected error %v", err)
+	}
+
+	err = CheckResponse(change, &addraftvoter.AddRaftVoterResponse{ErrorCode: errorcodes.RequestTimedOut})
+	if !errors.As(err, &changeErr) || !changeErr.Retriable() {
+		t.Errorf("RequestTimedOut: unexpected error %v", err)
+	}
+
+	if err := CheckResponse(change, &removeraftvoter.RemoveRaftVoterResponse{ErrorCode: errorcodes.VoterNotFound}); !errors.Is(err, ErrVoterNotFound) {
+		t.Errorf("VoterNotFound: unexpected error %v", err)
+	}
+
+	err = CheckResponse(Change{Type: UpdateVoter, Voter: change.Voter}, &updateraftvoter.UpdateRaftVoterResponse{
+		ErrorCode:     errorcodes.NotLeaderOrFollower,
+		CurrentLeader: &updateraftvoter.UpdateRaftVoterResponseCurrentLeader{LeaderId: 2, LeaderEpoch: 9},
+	})
+	if !errors.As(err, &changeErr) || changeErr.LeaderId != 2 || changeErr.LeaderEpoch != 9 || !changeErr.Retriable() {
+		t.Errorf("NotLeaderOrFollower: unexpected error %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package reassignment
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/scholzj/go-kafka-protocol/api/describetopicpartitions"
+	"github.com/scholzj/go-kafka-protocol/api/metadata"
+)
+
+// The reassignment planner computes balanced, rack-aware replica assignments for a set of target
+// brokers, splits the resulting moves into throttled waves and emits the AlterPartitionReassignments
+// requests executing them. The progress of a wave is tracked with ListPartitionReassignments. The
+// planner works on request and response bodies only, so they can be sent with any client.
+
+// TopicPartition identifies a partition of a topic.
+type TopicPartition struct {
+	Topic     string
+	Partition int32
+}
+
+func (tp TopicPartition) String() string {
+	return fmt.Sprintf("%s-%d", tp.Topic, tp.Partition)
+}
+
+// Layout is the current replica layout of a cluster: the replicas of every partition, with the
+// preferred leader first, and the racks of the brokers.
+type Layout struct {
+	Replicas map[TopicPartition][]int32
+	Racks    map[int32]string // Brokers without a rack are missing.
+}
+
+// NewLayout returns an empty layout.
+func NewLayout() *Layout {
+	return &Layout{Replicas: make(map[TopicPartition][]int32), Racks: make(map[int32]string)}
+}
+
+// AddMetadata adds the brokers and the partitions of the topics of a Metadata response. Topics
+// with an error are skipped.
+func (l *Layout) AddMetadata(res *metadata.MetadataResponse) {
+	for _, b := range deref(res.Brokers) {
+		if b.Rack != nil {
+			l.Racks[b.NodeId] = *b.Rack
+		}
+	}
+	for _, t := range deref(res.Topics) {
+		if t.ErrorCode != 0 || t.Name == nil {
+			continue
+		}
+		for _, p := range deref(t.Partitions) {
+			l.Replicas[TopicPartition{*t.Name, p.PartitionIndex}] = slices.Clone(deref(p.ReplicaNodes))
+		}
+	}
+}
+
+// AddDescribeTopicPartitions adds the partitions of a DescribeTopicPartitions response, so a layout
+// can be built page by page following the cursor. The response does not describe brokers, so their
+// racks have to come from a Metadata response or be set directly.
+func (l *Layout) AddDescribeTopicPartitions(res *describetopicpartitions.DescribeTopicPartitionsResponse) {
+	for _, t := range deref(res.Topics) {
+		if t.ErrorCode != 0 || t.Name == nil {
+			continue
+		}
+		for _, p := range deref(t.Partitions) {
+			l.Replicas[TopicPartition{*t.Name, p.PartitionIndex}] = slices.Clone(deref(p.ReplicaNodes))
+		}
+	}
+}
+
+// Partitions returns the partitions of the layout sorted by topic and partition.
+func (l *Layout) Partitions() []TopicPartition {
+	partitions := make([]TopicPartition, 0, len(l.Replicas))
+	for tp := range l.Replicas {
+		partitions = append(partitions, tp)
+	}
+	sortPartitions(partitions)
+	return partitions
+}
+
+func sortPartitions(partitions []TopicPartition) {
+	sort.Slice(partitions, func(i, j int) bool {
+		if partitions[i].Topic != partitions[j].Topic {
+			return partitions[i].Topic < partitions[j].Topic
+		}
+		return partitions[i].Partition < partitions[j].Partition
+	})
+}
+
+func deref[T any](s *[]T) []T {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
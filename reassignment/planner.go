@@ -0,0 +1,453 @@
+package reassignment
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+var (
+	ErrNoBrokers         = errors.New("no target brokers")
+	ErrDuplicateBroker   = errors.New("duplicate target broker")
+	ErrIncompleteRacks   = errors.New("only some of the target brokers have a rack")
+	ErrReplicationFactor = errors.New("invalid replication factor")
+	ErrUnknownTopic      = errors.New("unknown topic")
+)
+
+// Broker is a target broker of a reassignment.
+type Broker struct {
+	Id   int32
+	Rack string // Empty when the broker has no rack.
+}
+
+// Brokers returns the brokers with the ids and their racks in the layout.
+func (l *Layout) Brokers(ids ...int32) []Broker {
+	brokers := make([]Broker, 0, len(ids))
+	for _, id := range ids {
+		brokers = append(brokers, Broker{Id: id, Rack: l.Racks[id]})
+	}
+	return brokers
+}
+
+// Planner plans the reassignment of partitions to a set of target brokers and throttles the moves
+// into waves.
+//
+// Replicas on target brokers are kept where possible, so only as much data is moved as is needed to
+// balance the replicas across the target brokers. When all target brokers have a rack, the replicas of
+// a partition are spread across as many racks as possible. The preferred leaders are balanced as well,
+// but a partition keeps its preferred leader unless that broker leads more than its share.
+type Planner struct {
+	Brokers           []Broker // Each broker at most once.
+	ReplicationFactor int      // The replication factor of all partitions, or 0 to keep their replication factor.
+
+	// Limits of a wave, 0 for no limit. A wave has always at least one move, even when the move alone
+	// exceeds the limits.
+	MaxPartitionsPerWave int
+	MaxMovesPerBroker    int   // The number of replicas added to a broker.
+	MaxBytesPerWave      int64 // The number of bytes copied to new replicas, by the sizes of the partitions.
+
+	Sizes map[TopicPartition]int64 // The sizes of the partitions, for example from DescribeLogDirs.
+}
+
+// NewPlanner returns a planner for the target brokers without wave limits.
+func NewPlanner(brokers ...Broker) *Planner {
+	return &Planner{Brokers: brokers}
+}
+
+// Move is the reassignment of a partition. The first replica is the preferred leader.
+type Move struct {
+	TopicPartition
+	Current []int32
+	Target  []int32
+}
+
+// Adding returns the replicas which are added to the partition.
+func (m Move) Adding() []int32 {
+	return difference(m.Target, m.Current)
+}
+
+// Removing returns the replicas which are removed from the partition.
+func (m Move) Removing() []int32 {
+	return difference(m.Current, m.Target)
+}
+
+// Plan is a reassignment plan. The moves are sorted by topic and partition, the moves of the waves are
+// in the same order.
+type Plan struct {
+	Moves []Move
+	Waves []Wave
+}
+
+// Plan plans the reassignment of the partitions of the topics in the layout to the target brokers,
+// or of all partitions without topics. The replicas are balanced across the planned partitions only,
+// replicas of other partitions are not counted. Partitions which are already balanced have no move.
+func (p *Planner) Plan(layout *Layout, topics ...string) (*Plan, error) {
+	if len(p.Brokers) == 0 {
+		return nil, ErrNoBrokers
+	}
+	racks := make(map[string]bool)
+	ids := make(map[int32]bool, len(p.Brokers))
+	for _, b := range p.Brokers {
+		if ids[b.Id] {
+			return nil, fmt.Errorf("%w: broker %d", ErrDuplicateBroker, b.Id)
+		}
+		ids[b.Id] = true
+		if b.Rack != "" {
+			racks[b.Rack] = true
+		}
+	}
+	for _, b := range p.Brokers {
+		if len(racks) > 0 && b.Rack == "" {
+			return nil, fmt.Errorf("%w: broker %d", ErrIncompleteRacks, b.Id)
+		}
+	}
+
+	partitions := layout.Partitions()
+	if len(topics) > 0 {
+		selected := make(map[string]bool, len(topics))
+		for _, topic := range topics {
+			selected[topic] = false
+		}
+		partitions = slices.DeleteFunc(partitions, func(tp TopicPartition) bool {
+			_, ok := selected[tp.Topic]
+			selected[tp.Topic] = ok
+			return !ok
+		})
+		for _, topic := range topics {
+			if !selected[topic] {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownTopic, topic)
+			}
+		}
+	}
+
+	factors := make(map[TopicPartition]int, len(partitions))
+	for _, tp := range partitions {
+		rf := p.ReplicationFactor
+		if rf == 0 {
+			rf = len(layout.Replicas[tp])
+		}
+		if rf < 1 || rf > len(p.Brokers) {
+			return nil, fmt.Errorf("%w: %d replicas of %v on %d brokers", ErrReplicationFactor, rf, tp, len(p.Brokers))
+		}
+		factors[tp] = rf
+	}
+
+	targets := p.assign(layout, partitions, factors, len(racks))
+	plan := &Plan{}
+	for _, tp := range partitions {
+		current := layout.Replicas[tp]
+		if !slices.Equal(current, targets[tp]) {
+			plan.Moves = append(plan.Moves, Move{TopicPartition: tp, Current: slices.Clone(current), Target: targets[tp]})
+		}
+	}
+	plan.Waves = p.waves(plan.Moves)
+	return plan, nil
+}
+
+// assign computes the target replicas of the partitions. Replicas on target brokers are kept first, as
+// long as they respect the rack limit of their partition. Brokers with more replicas than their share
+// then give up replicas, followers before leaders, and the missing replicas are filled up, the
+// partitions missing the most replicas first, with the brokers furthest below their share. Brokers
+// which still have more replicas than their share finally hand replicas over to brokers with fewer.
+func (p *Planner) assign(layout *Layout, partitions []TopicPartition, factors map[TopicPartition]int, numRacks int) map[TopicPartition][]int32 {
+	rackOf := make(map[int32]string, len(p.Brokers))
+	brokers := make([]int32, 0, len(p.Brokers))
+	for _, b := range p.Brokers {
+		rackOf[b.Id] = b.Rack
+		brokers = append(brokers, b.Id)
+	}
+	slices.Sort(brokers)
+
+	// rackLimit is the most replicas of a partition in one rack, when the replicas are spread evenly.
+	rackLimit := func(rf int) int {
+		if numRacks == 0 {
+			return rf
+		}
+		return (rf + numRacks - 1) / numRacks
+	}
+	perRack := func(replicas []int32) map[string]int {
+		counts := make(map[string]int)
+		for _, id := range replicas {
+			counts[rackOf[id]]++
+		}
+		return counts
+	}
+
+	load := make(map[int32]int, len(brokers))
+	assigned := make(map[TopicPartition][]int32, len(partitions))
+	for _, tp := range partitions {
+		var kept []int32
+		counts := make(map[string]int)
+		for _, id := range layout.Replicas[tp] {
+			rack, ok := rackOf[id]
+			if !ok || slices.Contains(kept, id) || counts[rack] >= rackLimit(factors[tp]) || len(kept) == factors[tp] {
+				continue
+			}
+			kept = append(kept, id)
+			counts[rack]++
+			load[id]++
+		}
+		assigned[tp] = kept
+	}
+	capacity := capacities(brokers, rackOf, factors, rackLimit, load)
+
+	isLeader := func(tp TopicPartition, i int) bool {
+		current := layout.Replicas[tp]
+		return i == 0 && len(current) > 0 && assigned[tp][0] == current[0]
+	}
+	for _, leaders := range []bool{false, true} {
+		for _, tp := range partitions {
+			kept := assigned[tp]
+			for i := len(kept) - 1; i >= 0; i-- {
+				if load[kept[i]] > capacity[kept[i]] && isLeader(tp, i) == leaders {
+					load[kept[i]]--
+					kept = slices.Delete(kept, i, i+1)
+				}
+			}
+			assigned[tp] = kept
+		}
+	}
+
+	// The partitions missing the most replicas have the fewest choices, so they are filled first.
+	byMissing := slices.Clone(partitions)
+	slices.SortStableFunc(byMissing, func(a, b TopicPartition) int {
+		return (factors[b] - len(assigned[b])) - (factors[a] - len(assigned[a]))
+	})
+	for _, tp := range byMissing {
+		replicas := assigned[tp]
+		for len(replicas) < factors[tp] {
+			counts := perRack(replicas)
+			best, bestRelaxed := int32(-1), int32(-1)
+			for _, id := range brokers {
+				if slices.Contains(replicas, id) {
+					continue
+				}
+				if bestRelaxed < 0 || load[id]-capacity[id] < load[bestRelaxed]-capacity[bestRelaxed] {
+					bestRelaxed = id
+				}
+				if counts[rackOf[id]] >= rackLimit(factors[tp]) {
+					continue
+				}
+				free, bestFree := capacity[id]-load[id], 0
+				if best >= 0 {
+					bestFree = capacity[best] - load[best]
+				}
+				if best < 0 || free > bestFree || (free == bestFree && counts[rackOf[id]] < counts[rackOf[best]]) {
+					best = id
+				}
+			}
+			// The racks can be too small for an even spread, then any broker has to do.
+			if best < 0 {
+				best = bestRelaxed
+			}
+			replicas = append(replicas, best)
+			load[best]++
+		}
+		assigned[tp] = replicas
+	}
+
+	// A broker above its share always has a replica in a partition without a replica on a broker
+	// below its share. Replicas which are moved anyway and followers are handed over first.
+	for {
+		over := slices.IndexFunc(brokers, func(id int32) bool { return load[id] > capacity[id] })
+		under := slices.IndexFunc(brokers, func(id int32) bool { return load[id] < capacity[id] })
+		if over < 0 || under < 0 || !handOver(layout, partitions, assigned, factors, rackOf, rackLimit, brokers[over], brokers[under]) {
+			break
+		}
+		load[brokers[over]]--
+		load[brokers[under]]++
+	}
+
+	// Partitions which lost their leader get the replica with the fewest leaderships as the leader.
+	// Brokers which still lead more than their share of partitions then hand leaderships over, in
+	// partitions which are moved anyway first.
+	leaders := make(map[int32]int, len(brokers))
+	for _, tp := range partitions {
+		if isLeader(tp, 0) {
+			leaders[assigned[tp][0]]++
+		}
+	}
+	leastLeading := func(replicas []int32) int {
+		least := 0
+		for i, id := range replicas {
+			if leaders[id] < leaders[replicas[least]] {
+				least = i
+			}
+		}
+		return least
+	}
+	for _, tp := range partitions {
+		if replicas := assigned[tp]; !isLeader(tp, 0) {
+			least := leastLeading(replicas)
+			replicas[0], replicas[least] = replicas[least], replicas[0]
+			leaders[replicas[0]]++
+		}
+	}
+	leaderCapacity := (len(partitions) + len(brokers) - 1) / len(brokers)
+	for _, moved := range []bool{true, false} {
+		for _, tp := range partitions {
+			replicas := assigned[tp]
+			if (len(difference(replicas, layout.Replicas[tp])) > 0) != moved || leaders[replicas[0]] <= leaderCapacity {
+				continue
+			}
+			if least := leastLeading(replicas); leaders[replicas[least]] < leaders[replicas[0]]-1 {
+				leaders[replicas[0]]--
+				replicas[0], replicas[least] = replicas[least], replicas[0]
+				leaders[replicas[0]]++
+			}
+		}
+	}
+	return assigned
+}
+
+// handOver moves a replica from one broker to another in a partition which has no replica on the
+// other broker and keeps its rack limit, preferring replicas which are moved anyway and followers.
+func handOver(layout *Layout, partitions []TopicPartition, assigned map[TopicPartition][]int32, factors map[TopicPartition]int,
+	rackOf map[int32]string, rackLimit func(int) int, from int32, to int32) bool {
+	found, foundIndex, foundRank := TopicPartition{}, -1, 0
+	for _, tp := range partitions {
+		replicas := assigned[tp]
+		i := slices.Index(replicas, from)
+		if i < 0 || slices.Contains(replicas, to) {
+			continue
+		}
+		if rackOf[from] != rackOf[to] {
+			inRack := 0
+			for _, id := range replicas {
+				if rackOf[id] == rackOf[to] {
+					inRack++
+				}
+			}
+			if inRack >= rackLimit(factors[tp]) {
+				continue
+			}
+		}
+
+		rank := 0
+		if slices.Contains(layout.Replicas[tp], from) {
+			rank += 2
+		}
+		if i == 0 {
+			rank++
+		}
+		if foundIndex < 0 || rank < foundRank {
+			found, foundIndex, foundRank = tp, i, rank
+		}
+	}
+	if foundIndex < 0 {
+		return false
+	}
+	assigned[found][foundIndex] = to
+	return true
+}
+
+// capacities returns the replicas of every broker in a balanced assignment. The replicas are spread
+// evenly across the brokers, except in racks which cannot take their share because partitions have at
+// most rackLimit replicas in a rack. The replicas those racks cannot take are spread across the other
+// racks. When the replicas cannot be split evenly, the brokers with the most kept replicas take one
+// more.
+func capacities(brokers []int32, rackOf map[int32]string, factors map[TopicPartition]int, rackLimit func(int) int, kept map[int32]int) map[int32]int {
+	members := make(map[string][]int32)
+	for _, id := range brokers {
+		members[rackOf[id]] = append(members[rackOf[id]], id)
+	}
+	limits := make(map[string]int, len(members))
+	remaining := 0
+	for _, rf := range factors {
+		remaining += rf
+		for rack, ids := range members {
+			limits[rack] += min(rackLimit(rf), len(ids))
+		}
+	}
+
+	capacity := make(map[int32]int, len(brokers))
+	// spread splits the replicas evenly across the brokers.
+	spread := func(ids []int32, replicas int) {
+		ids = slices.Clone(ids)
+		slices.SortStableFunc(ids, func(a, b int32) int { return kept[b] - kept[a] })
+		for i, id := range ids {
+			capacity[id] = replicas / len(ids)
+			if i < replicas%len(ids) {
+				capacity[id]++
+			}
+		}
+	}
+
+	open := maps.Clone(members)
+	for len(open) > 0 {
+		size := 0
+		for _, ids := range open {
+			size += len(ids)
+		}
+		capped := false
+		for _, rack := range slices.Sorted(maps.Keys(open)) {
+			ids := open[rack]
+			if limits[rack]*size < remaining*len(ids) {
+				spread(ids, limits[rack])
+				remaining -= limits[rack]
+				delete(open, rack)
+				capped = true
+			}
+		}
+		if !capped {
+			var ids []int32
+			for _, id := range brokers {
+				if _, ok := open[rackOf[id]]; ok {
+					ids = append(ids, id)
+				}
+			}
+			spread(ids, remaining)
+			break
+		}
+	}
+	return capacity
+}
+
+// waves splits the moves into waves within the limits of the planner. Every wave takes the moves
+// which still fit in their order, so a move which does not fit is deferred to a later wave.
+func (p *Planner) waves(moves []Move) []Wave {
+	var waves []Wave
+	for remaining := moves; len(remaining) > 0; {
+		var wave Wave
+		var deferred []Move
+		incoming := make(map[int32]int)
+		var bytes int64
+		for _, m := range remaining {
+			adding := m.Adding()
+			size := p.Sizes[m.TopicPartition] * int64(len(adding))
+			fits := p.MaxPartitionsPerWave <= 0 || len(wave.Moves) < p.MaxPartitionsPerWave
+			if p.MaxBytesPerWave > 0 && bytes+size > p.MaxBytesPerWave {
+				fits = false
+			}
+			for _, id := range adding {
+				if p.MaxMovesPerBroker > 0 && incoming[id] >= p.MaxMovesPerBroker {
+					fits = false
+				}
+			}
+			if !fits && len(wave.Moves) > 0 {
+				deferred = append(deferred, m)
+				continue
+			}
+			wave.Moves = append(wave.Moves, m)
+			bytes += size
+			for _, id := range adding {
+				incoming[id]++
+			}
+		}
+		waves = append(waves, wave)
+		remaining = deferred
+	}
+	return waves
+}
+
+// difference returns the replicas of a which are not in b.
+func difference(a, b []int32) []int32 {
+	var diff []int32
+	for _, id := range a {
+		if !slices.Contains(b, id) {
+			diff = append(diff, id)
+		}
+	}
+	return diff
+}
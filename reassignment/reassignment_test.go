@@ -0,0 +1,363 @@
+package reassignment
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/scholzj/go-kafka-protocol/api/describetopicpartitions"
+	"github.com/scholzj/go-kafka-protocol/api/listpartitionreassignments"
+	"github.com/scholzj/go-kafka-protocol/api/metadata"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+func str(s string) *string {
+	return &s
+}
+
+// testLayout returns a layout of three brokers in racks a, b and c, and the topic orders with six
+// partitions with two replicas all on brokers 0 and 1.
+func testLayout() *Layout {
+	brokers := []metadata.MetadataResponseBroker{
+		{NodeId: 0, Host: str("b0"), Rack: str("a")},
+		{NodeId: 1, Host: str("b1"), Rack: str("b")},
+		{NodeId: 2, Host: str("b2"), Rack: str("c")},
+	}
+	partitions := []metadata.MetadataResponseTopicPartition{}
+	for i := int32(0); i < 6; i++ {
+		replicas := []int32{i % 2, (i + 1) % 2}
+		partitions = append(partitions, metadata.MetadataResponseTopicPartition{PartitionIndex: i, LeaderId: replicas[0], ReplicaNodes: &replicas})
+	}
+	layout := NewLayout()
+	layout.AddMetadata(&metadata.MetadataResponse{
+		ApiVersion: 12,
+		Brokers:    &brokers,
+		Topics: &[]metadata.MetadataResponseTopic{
+			{Name: str("orders"), Partitions: &partitions},
+			{Name: str("missing"), ErrorCode: errorcodes.UnknownTopicOrPartition},
+		},
+	})
+	return layout
+}
+
+// checkPlan checks that the layout after the plan has the replication factor, only uses the brokers and
+// spreads the replicas of every partition across racks. It returns the replicas and the preferred
+// leaders of every broker.
+func checkPlan(t *testing.T, name string, layout *Layout, plan *Plan, brokers []Broker, rf int) (map[int32]int, map[int32]int) {
+	t.Helper()
+
+	after := make(map[TopicPartition][]int32, len(layout.Replicas))
+	for tp, replicas := range layout.Replicas {
+		after[tp] = replicas
+	}
+	for _, m := range plan.Moves {
+		after[m.TopicPartition] = m.Target
+	}
+
+	racks := make(map[int32]string)
+	for _, b := range brokers {
+		racks[b.Id] = b.Rack
+	}
+	load := make(map[int32]int)
+	leaders := make(map[int32]int)
+	for tp, replicas := range after {
+		seen := make(map[string]bool)
+		for _, id := range replicas {
+			rack, ok := racks[id]
+			if !ok || seen[rack] {
+				t.Errorf("%s: unexpected replicas %v of %v", name, replicas, tp)
+			}
+			seen[rack] = true
+			load[id]++
+		}
+		if len(replicas) != rf {
+			t.Errorf("%s: unexpected replicas %v of %v", name, replicas, tp)
+		}
+		leaders[replicas[0]]++
+	}
+
+	var waved []Move
+	for _, w := range plan.Waves {
+		waved = append(waved, w.Moves...)
+	}
+	if len(waved) != len(plan.Moves) {
+		t.Errorf("%s: %d moves in waves, expected %d", name, len(waved), len(plan.Moves))
+	}
+	return load, leaders
+}
+
+func TestPlan(t *testing.T) {
+	layout := testLayout()
+	if len(layout.Replicas) != 6 || layout.Racks[2] != "c" {
+		t.Fatalf("unexpected layout %+v", layout)
+	}
+
+	// Adding broker 2 moves a third of the replicas to it.
+	brokers := layout.Brokers(0, 1, 2)
+	plan, err := NewPlanner(brokers...).Plan(layout)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	if load, leaders := checkPlan(t, "expand", layout, plan, brokers, 2); !maps.Equal(load, map[int32]int{0: 4, 1: 4, 2: 4}) ||
+		!maps.Equal(leaders, map[int32]int{0: 2, 1: 2, 2: 2}) {
+		t.Errorf("expand: unbalanced replicas %v and leaders %v", load, leaders)
+	}
+	for _, m := range plan.Moves {
+		if len(m.Adding()) != 1 || !slices.Equal(m.Adding(), []int32{2}) || len(m.Removing()) != 1 {
+			t.Errorf("expand: unexpected move %v: %v -> %v", m.TopicPartition, m.Current, m.Target)
+		}
+	}
+	if len(plan.Moves) != 4 || len(plan.Waves) != 1 {
+		t.Errorf("expand: %d moves in %d waves, expected 4 in 1", len(plan.Moves), len(plan.Waves))
+	}
+
+	// A balanced layout has no moves.
+	for _, m := range plan.Moves {
+		layout.Replicas[m.TopicPartition] = m.Target
+	}
+	if plan, err = NewPlanner(brokers...).Plan(layout); err != nil || len(plan.Moves) != 0 {
+		t.Errorf("balanced: unexpected plan %+v (%v)", plan, err)
+	}
+
+	// Removing broker 0 moves all of its replicas.
+	layout = testLayout()
+	brokers = []Broker{{1, "b"}, {2, "c"}, {3, "a"}}
+	if plan, err = NewPlanner(brokers...).Plan(layout, "orders"); err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	if load, leaders := checkPlan(t, "decommission", layout, plan, brokers, 2); !maps.Equal(load, map[int32]int{1: 4, 2: 4, 3: 4}) ||
+		!maps.Equal(leaders, map[int32]int{1: 2, 2: 2, 3: 2}) {
+		t.Errorf("decommission: unbalanced replicas %v and leaders %v", load, leaders)
+	}
+	for _, m := range plan.Moves {
+		if slices.Contains(m.Current, 1) && !slices.Contains(m.Target, 1) && slices.Contains(m.Target, 0) {
+			t.Errorf("decommission: unexpected move %v: %v -> %v", m.TopicPartition, m.Current, m.Target)
+		}
+	}
+
+	// Increasing the replication factor, with a second broker in rack a.
+	layout = testLayout()
+	brokers = []Broker{{0, "a"}, {1, "b"}, {2, "c"}, {3, "a"}}
+	planner := NewPlanner(brokers...)
+	planner.ReplicationFactor = 3
+	if plan, err = planner.Plan(layout); err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	// Every partition has a replica in rack a, so brokers 0 and 3 share them.
+	if load, _ := checkPlan(t, "replication factor", layout, plan, brokers, 3); !maps.Equal(load, map[int32]int{0: 3, 1: 6, 2: 6, 3: 3}) {
+		t.Errorf("replication factor: unbalanced replicas %v", load)
+	}
+
+	// Brokers without racks.
+	layout = testLayout()
+	brokers = []Broker{{0, ""}, {1, ""}, {2, ""}}
+	layout.Racks = nil
+	if plan, err = NewPlanner(brokers...).Plan(layout); err != nil || len(plan.Moves) != 4 {
+		t.Errorf("no racks: unexpected plan %+v (%v)", plan, err)
+	}
+}
+
+// checkBalance checks that the replicas of every partition after the plan are distinct target brokers
+// and that the replica counts of the target brokers differ by at most one.
+func checkBalance(t *testing.T, name string, layout *Layout, plan *Plan, brokers []Broker, rf int) {
+	t.Helper()
+
+	after := maps.Clone(layout.Replicas)
+	for _, m := range plan.Moves {
+		after[m.TopicPartition] = m.Target
+	}
+	load := make(map[int32]int, len(brokers))
+	for _, b := range brokers {
+		load[b.Id] = 0
+	}
+	for tp, replicas := range after {
+		want := rf
+		if want == 0 {
+			want = len(layout.Replicas[tp])
+		}
+		for i, id := range replicas {
+			if _, ok := load[id]; !ok || slices.Contains(replicas[:i], id) {
+				t.Errorf("%s: unexpected replicas %v of %v", name, replicas, tp)
+			}
+			load[id]++
+		}
+		if len(replicas) != want {
+			t.Errorf("%s: unexpected replicas %v of %v", name, replicas, tp)
+		}
+	}
+	counts := slices.Collect(maps.Values(load))
+	if slices.Max(counts)-slices.Min(counts) > 1 {
+		t.Errorf("%s: unbalanced replicas %v", name, load)
+	}
+}
+
+func TestPlanBalance(t *testing.T) {
+	layout := NewLayout()
+	for i, replicas := range [][]int32{{3}, {9}, {2, 9, 8}, {9, 0, 8}, {9, 2}, {6, 5}, {2}, {5}, {3, 8}, {1, 5}, {1}, {0, 7}, {2, 0, 5}, {0, 4, 2}, {0, 1, 9}} {
+		layout.Replicas[TopicPartition{"t", int32(i)}] = replicas
+	}
+	brokers := []Broker{{0, ""}, {8, ""}, {9, ""}}
+	plan, err := NewPlanner(brokers...).Plan(layout)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	checkBalance(t, "mixed replication factors", layout, plan, brokers, 0)
+
+	random := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < 3000; i++ {
+		layout := NewLayout()
+		ids := random.Perm(12)
+		brokers := make([]Broker, 1+random.IntN(8))
+		for j := range brokers {
+			brokers[j] = Broker{Id: int32(ids[j])}
+		}
+		planner := NewPlanner(brokers...)
+		maxRf := min(len(brokers), 4)
+		if random.IntN(2) == 0 {
+			planner.ReplicationFactor = 1 + random.IntN(maxRf)
+		}
+		for j := 0; j < 1+random.IntN(20); j++ {
+			var replicas []int32
+			for _, id := range random.Perm(12)[:1+random.IntN(maxRf)] {
+				replicas = append(replicas, int32(id))
+			}
+			layout.Replicas[TopicPartition{"t", int32(j)}] = replicas
+		}
+
+		plan, err := planner.Plan(layout)
+		if err != nil {
+			t.Fatalf("case %d: Plan: %v", i, err)
+		}
+		checkBalance(t, fmt.Sprintf("case %d", i), layout, plan, brokers, planner.ReplicationFactor)
+	}
+}
+
+func TestPlanErrors(t *testing.T) {
+	layout := testLayout()
+
+	tests := []struct {
+		planner  *Planner
+		topics   []string
+		expected error
+	}{
+		{NewPlanner(), nil, ErrNoBrokers},
+		{NewPlanner(Broker{0, "a"}, Broker{1, ""}), nil, ErrIncompleteRacks},
+		{NewPlanner(Broker{0, "a"}), nil, ErrReplicationFactor},
+		{&Planner{Brokers: layout.Brokers(0, 1, 1), ReplicationFactor: 3}, nil, ErrDuplicateBroker},
+		{&Planner{Brokers: layout.Brokers(0, 1, 2), ReplicationFactor: 4}, nil, ErrReplicationFactor},
+		{NewPlanner(layout.Brokers(0, 1, 2)...), []string{"orders", "missing"}, ErrUnknownTopic},
+	}
+	for i, test := range tests {
+		if _, err := test.planner.Plan(layout, test.topics...); !errors.Is(err, test.expected) {
+			t.Errorf("test %d: unexpected error %v, expected %v", i, err, test.expected)
+		}
+	}
+}
+
+func TestWaves(t *testing.T) {
+	layout := NewLayout()
+	layout.AddDescribeTopicPartitions(&describetopicpartitions.DescribeTopicPartitionsResponse{Topics: &[]describetopicpartitions.DescribeTopicPartitionsResponseTopic{
+		{Name: str("orders"), Partitions: &[]describetopicpartitions.DescribeTopicPartitionsResponseTopicPartition{
+			{PartitionIndex: 0, ReplicaNodes: &[]int32{0}},
+			{PartitionIndex: 1, ReplicaNodes: &[]int32{0}},
+			{PartitionIndex: 2, ReplicaNodes: &[]int32{0}},
+			{PartitionIndex: 3, ReplicaNodes: &[]int32{0}},
+		}},
+		{Name: str("payments"), Partitions: &[]describetopicpartitions.DescribeTopicPartitionsResponseTopicPartition{
+			{PartitionIndex: 0, ReplicaNodes: &[]int32{0}},
+			{PartitionIndex: 1, ReplicaNodes: &[]int32{0}},
+		}},
+	}})
+	sizes := map[TopicPartition]int64{{"orders", 0}: 100, {"orders", 1}: 100, {"orders", 2}: 300, {"orders", 3}: 100, {"payments", 0}: 10, {"payments", 1}: 10}
+
+	tests := []struct {
+		planner  Planner
+		expected [][]string
+	}{
+		{Planner{}, [][]string{{"orders-0", "orders-1", "orders-2", "orders-3"}}},
+		{Planner{MaxPartitionsPerWave: 3}, [][]string{{"orders-0", "orders-1", "orders-2"}, {"orders-3"}}},
+		{Planner{MaxMovesPerBroker: 1}, [][]string{{"orders-0", "orders-1"}, {"orders-2", "orders-3"}}},
+		{Planner{MaxBytesPerWave: 250, Sizes: sizes}, [][]string{{"orders-0", "orders-1"}, {"orders-2"}, {"orders-3"}}},
+	}
+	for i, test := range tests {
+		test.planner.Brokers = []Broker{{0, ""}, {1, ""}, {2, ""}}
+		plan, err := test.planner.Plan(layout)
+		if err != nil {
+			t.Fatalf("test %d: Plan: %v", i, err)
+		}
+		var waves [][]string
+		for _, w := range plan.Waves {
+			var partitions []string
+			for _, tp := range w.Partitions() {
+				partitions = append(partitions, tp.String())
+			}
+			waves = append(waves, partitions)
+		}
+		if fmt.Sprint(waves) != fmt.Sprint(test.expected) {
+			t.Errorf("test %d: unexpected waves %v, expected %v", i, waves, test.expected)
+		}
+	}
+}
+
+func TestRequests(t *testing.T) {
+	wave := Wave{Moves: []Move{
+		{TopicPartition{"orders", 0}, []int32{0, 1}, []int32{2, 1}},
+		{TopicPartition{"orders", 3}, []int32{0, 1}, []int32{0, 2}},
+		{TopicPartition{"payments", 1}, []int32{1, 0}, []int32{1, 2}},
+	}}
+
+	req := wave.Request(1, 30000)
+	if req.ApiVersion != 1 || req.TimeoutMs != 30000 || req.AllowReplicationFactorChange || len(*req.Topics) != 2 {
+		t.Fatalf("unexpected request %+v", req)
+	}
+	if topic := (*req.Topics)[0]; *topic.Name != "orders" || len(*topic.Partitions) != 2 ||
+		(*topic.Partitions)[1].PartitionIndex != 3 || !slices.Equal(*(*topic.Partitions)[1].Replicas, []int32{0, 2}) {
+		t.Errorf("unexpected topic %+v", topic)
+	}
+	cancel := wave.CancelRequest(1, 30000)
+	for _, topic := range *cancel.Topics {
+		for _, p := range *topic.Partitions {
+			if p.Replicas != nil {
+				t.Errorf("unexpected replicas of %s-%d in cancel request", *topic.Name, p.PartitionIndex)
+			}
+		}
+	}
+	rollback := wave.Rollback().Request(1, 30000)
+	if replicas := *(*(*rollback.Topics)[1].Partitions)[0].Replicas; !slices.Equal(replicas, []int32{1, 0}) {
+		t.Errorf("unexpected rollback replicas %v", replicas)
+	}
+
+	list := wave.ListRequest(0, 30000)
+	if len(*list.Topics) != 2 || !slices.Equal(*(*list.Topics)[0].PartitionIndexes, []int32{0, 3}) {
+		t.Errorf("unexpected list request %+v", list)
+	}
+
+	progress, err := wave.Progress(&listpartitionreassignments.ListPartitionReassignmentsResponse{Topics: &[]listpartitionreassignments.ListPartitionReassignmentsResponseTopic{
+		{Name: str("orders"), Partitions: &[]listpartitionreassignments.ListPartitionReassignmentsResponseTopicPartition{
+			{PartitionIndex: 3, Replicas: &[]int32{0, 2, 1}, AddingReplicas: &[]int32{2}, RemovingReplicas: &[]int32{1}},
+		}},
+		{Name: str("other"), Partitions: &[]listpartitionreassignments.ListPartitionReassignmentsResponseTopicPartition{
+			{PartitionIndex: 0, Replicas: &[]int32{0}, AddingReplicas: &[]int32{}, RemovingReplicas: &[]int32{}},
+		}},
+	}})
+	if err != nil || progress.Done() || len(progress.Ongoing) != 1 || len(progress.Completed) != 2 ||
+		!slices.Equal(progress.Ongoing[TopicPartition{"orders", 3}].AddingReplicas, []int32{2}) {
+		t.Errorf("unexpected progress %+v (%v)", progress, err)
+	}
+	if progress, err = wave.Progress(&listpartitionreassignments.ListPartitionReassignmentsResponse{}); err != nil || !progress.Done() {
+		t.Errorf("unexpected progress %+v (%v)", progress, err)
+	}
+	var kafkaErr *errorcodes.Error
+	if _, err = wave.Progress(&listpartitionreassignments.ListPartitionReassignmentsResponse{ErrorCode: errorcodes.NotController}); !errors.As(err, &kafkaErr) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	data, err := wave.JSON()
+	expected := `{"version":1,"partitions":[{"topic":"orders","partition":0,"replicas":[2,1]},{"topic":"orders","partition":3,"replicas":[0,2]},{"topic":"payments","partition":1,"replicas":[1,2]}]}`
+	if err != nil || string(data) != expected {
+		t.Errorf("unexpected JSON %s (%v)", data, err)
+	}
+}
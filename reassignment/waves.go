@@ -0,0 +1,164 @@
+package reassignment
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/scholzj/go-kafka-protocol/api/alterpartitionreassignments"
+	"github.com/scholzj/go-kafka-protocol/api/listpartitionreassignments"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+// Wave is a set of moves which are executed together.
+type Wave struct {
+	Moves []Move
+}
+
+// Partitions returns the partitions of the moves of the wave.
+func (w Wave) Partitions() []TopicPartition {
+	partitions := make([]TopicPartition, 0, len(w.Moves))
+	for _, m := range w.Moves {
+		partitions = append(partitions, m.TopicPartition)
+	}
+	return partitions
+}
+
+// Rollback returns the wave which moves the partitions of the wave back to their current replicas.
+func (w Wave) Rollback() Wave {
+	rollback := Wave{Moves: make([]Move, 0, len(w.Moves))}
+	for _, m := range w.Moves {
+		rollback.Moves = append(rollback.Moves, Move{TopicPartition: m.TopicPartition, Current: m.Target, Target: m.Current})
+	}
+	return rollback
+}
+
+// Request returns the AlterPartitionReassignments request which starts the moves of the wave. A change
+// of the replication factor is only allowed when a move of the wave changes it.
+func (w Wave) Request(version int16, timeoutMs int32) *alterpartitionreassignments.AlterPartitionReassignmentsRequest {
+	changesFactor := false
+	for _, m := range w.Moves {
+		changesFactor = changesFactor || len(m.Current) != len(m.Target)
+	}
+	return &alterpartitionreassignments.AlterPartitionReassignmentsRequest{
+		ApiVersion:                   version,
+		TimeoutMs:                    timeoutMs,
+		AllowReplicationFactorChange: changesFactor,
+		Topics:                       w.alterTopics(false),
+	}
+}
+
+// CancelRequest returns the AlterPartitionReassignments request which cancels the ongoing moves of
+// the wave. Replicas which have already been added are removed again.
+func (w Wave) CancelRequest(version int16, timeoutMs int32) *alterpartitionreassignments.AlterPartitionReassignmentsRequest {
+	return &alterpartitionreassignments.AlterPartitionReassignmentsRequest{
+		ApiVersion:                   version,
+		TimeoutMs:                    timeoutMs,
+		AllowReplicationFactorChange: true,
+		Topics:                       w.alterTopics(true),
+	}
+}
+
+func (w Wave) alterTopics(cancel bool) *[]alterpartitionreassignments.AlterPartitionReassignmentsRequestTopic {
+	topics := []alterpartitionreassignments.AlterPartitionReassignmentsRequestTopic{}
+	for _, m := range w.Moves {
+		if len(topics) == 0 || *topics[len(topics)-1].Name != m.Topic {
+			topics = append(topics, alterpartitionreassignments.AlterPartitionReassignmentsRequestTopic{
+				Name:       &m.Topic,
+				Partitions: &[]alterpartitionreassignments.AlterPartitionReassignmentsRequestTopicPartition{},
+			})
+		}
+		partition := alterpartitionreassignments.AlterPartitionReassignmentsRequestTopicPartition{PartitionIndex: m.Partition}
+		if !cancel {
+			replicas := slices.Clone(m.Target)
+			partition.Replicas = &replicas
+		}
+		partitions := topics[len(topics)-1].Partitions
+		*partitions = append(*partitions, partition)
+	}
+	return &topics
+}
+
+// ListRequest returns the ListPartitionReassignments request which lists the ongoing moves of the wave.
+func (w Wave) ListRequest(version int16, timeoutMs int32) *listpartitionreassignments.ListPartitionReassignmentsRequest {
+	topics := []listpartitionreassignments.ListPartitionReassignmentsRequestTopic{}
+	for _, m := range w.Moves {
+		if len(topics) == 0 || *topics[len(topics)-1].Name != m.Topic {
+			topics = append(topics, listpartitionreassignments.ListPartitionReassignmentsRequestTopic{Name: &m.Topic, PartitionIndexes: &[]int32{}})
+		}
+		indexes := topics[len(topics)-1].PartitionIndexes
+		*indexes = append(*indexes, m.Partition)
+	}
+	return &listpartitionreassignments.ListPartitionReassignmentsRequest{ApiVersion: version, TimeoutMs: timeoutMs, Topics: &topics}
+}
+
+// Ongoing is a move which is still in progress.
+type Ongoing struct {
+	Replicas         []int32 // All replicas of the partition, including the adding and removing ones.
+	AddingReplicas   []int32
+	RemovingReplicas []int32
+}
+
+// Progress is the progress of a wave.
+type Progress struct {
+	Completed []TopicPartition
+	Ongoing   map[TopicPartition]Ongoing
+}
+
+// Done returns whether all moves of the wave have completed.
+func (p Progress) Done() bool {
+	return len(p.Ongoing) == 0
+}
+
+// Progress returns the progress of the wave from the response to its ListRequest. Moves of the wave
+// which are not listed as ongoing have completed, reassignments of other partitions are ignored.
+func (w Wave) Progress(res *listpartitionreassignments.ListPartitionReassignmentsResponse) (Progress, error) {
+	if err := errorcodes.ToError(res.ErrorCode, res.ErrorMessage); err != nil {
+		return Progress{}, err
+	}
+
+	ongoing := make(map[TopicPartition]Ongoing)
+	for _, t := range deref(res.Topics) {
+		if t.Name == nil {
+			continue
+		}
+		for _, p := range deref(t.Partitions) {
+			ongoing[TopicPartition{*t.Name, p.PartitionIndex}] = Ongoing{
+				Replicas:         deref(p.Replicas),
+				AddingReplicas:   deref(p.AddingReplicas),
+				RemovingReplicas: deref(p.RemovingReplicas),
+			}
+		}
+	}
+
+	progress := Progress{Ongoing: make(map[TopicPartition]Ongoing)}
+	for _, tp := range w.Partitions() {
+		if o, ok := ongoing[tp]; ok {
+			progress.Ongoing[tp] = o
+		} else {
+			progress.Completed = append(progress.Completed, tp)
+		}
+	}
+	return progress, nil
+}
+
+// reassignmentJSON is the reassignment file format of kafka-reassign-partitions.sh.
+type reassignmentJSON struct {
+	Version    int                     `json:"version"`
+	Partitions []reassignmentPartition `json:"partitions"`
+}
+
+type reassignmentPartition struct {
+	Topic     string  `json:"topic"`
+	Partition int32   `json:"partition"`
+	Replicas  []int32 `json:"replicas"`
+}
+
+// JSON returns the moves of the wave in the reassignment file format of kafka-reassign-partitions.sh,
+// so a wave can also be executed or reviewed with the Kafka tools.
+func (w Wave) JSON() ([]byte, error) {
+	file := reassignmentJSON{Version: 1, Partitions: make([]reassignmentPartition, 0, len(w.Moves))}
+	for _, m := range w.Moves {
+		file.Partitions = append(file.Partitions, reassignmentPartition{Topic: m.Topic, Partition: m.Partition, Replicas: m.Target})
+	}
+	return json.Marshal(file)
+}
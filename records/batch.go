@@ -0,0 +1,502 @@
+package records
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/scholzj/go-kafka-protocol/compression"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+// The record batch (magic v2) format used in the records fields of Produce, Fetch and ShareFetch,
+// in the KRaft metadata log and snapshots, and in the log segments on disk.
+
+const (
+	MagicV2 int8 = 2
+
+	// BatchHeaderSize is the size of the batch header up to and including the records count.
+	BatchHeaderSize = 61
+
+	// LogOverhead is the size of the base offset and batch length fields that precede every batch.
+	LogOverhead = 12
+
+	// NoProducerId, NoProducerEpoch and NoSequence are used by batches from non-idempotent producers.
+	NoProducerId    int64 = -1
+	NoProducerEpoch int16 = -1
+	NoSequence      int32 = -1
+
+	// NoPartitionLeaderEpoch is used before the leader has assigned the batch its epoch.
+	NoPartitionLeaderEpoch int32 = -1
+)
+
+// Batch attribute bits.
+const (
+	CompressionCodecMask   int16 = 0x07
+	TimestampTypeAttribute int16 = 0x08
+	TransactionalAttribute int16 = 0x10
+	ControlAttribute       int16 = 0x20
+	DeleteHorizonAttribute int16 = 0x40
+)
+
+// TimestampType tells how the timestamps of a batch were assigned.
+type TimestampType int8
+
+const (
+	CreateTime    TimestampType = 0
+	LogAppendTime TimestampType = 1
+)
+
+var (
+	ErrCorruptBatch     = errors.New("corrupt record batch")
+	ErrUnsupportedMagic = errors.New("unsupported record batch magic")
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// Header is a record header. A nil Value is a null header value.
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Record is a single record in a batch. Its offset and timestamp are stored relative to the batch;
+// use RecordBatch.Offset and RecordBatch.Timestamp to get the absolute values. A nil Key or Value is
+// null (a record with a null value is a tombstone in compacted topics).
+type Record struct {
+	Attributes     int8
+	TimestampDelta int64
+	OffsetDelta    int32
+	Key            []byte
+	Value          []byte
+	Headers        []Header
+}
+
+// RecordBatch is a magic v2 record batch. Crc holds the checksum read from the wire; it is computed
+// again when the batch is written.
+type RecordBatch struct {
+	BaseOffset           int64
+	PartitionLeaderEpoch int32
+	Magic                int8
+	Crc                  uint32
+	Attributes           int16
+	LastOffsetDelta      int32
+	BaseTimestamp        int64
+	MaxTimestamp         int64
+	ProducerId           int64
+	ProducerEpoch        int16
+	BaseSequence         int32
+	Records              []Record
+}
+
+// NewRecordBatch returns an empty batch without a producer id, ready for records to be appended.
+func NewRecordBatch(baseOffset int64, codec compression.Type) *RecordBatch {
+	return &RecordBatch{
+		BaseOffset:           baseOffset,
+		PartitionLeaderEpoch: NoPartitionLeaderEpoch,
+		Magic:                MagicV2,
+		Attributes:           int16(codec) & CompressionCodecMask,
+		BaseTimestamp:        -1,
+		MaxTimestamp:         -1,
+		ProducerId:           NoProducerId,
+		ProducerEpoch:        NoProducerEpoch,
+		BaseSequence:         NoSequence,
+	}
+}
+
+func (b *RecordBatch) Compression() compression.Type {
+	return compression.Type(b.Attributes & CompressionCodecMask)
+}
+
+func (b *RecordBatch) TimestampType() TimestampType {
+	if b.Attributes&TimestampTypeAttribute != 0 {
+		return LogAppendTime
+	}
+	return CreateTime
+}
+
+func (b *RecordBatch) IsTransactional() bool {
+	return b.Attributes&TransactionalAttribute != 0
+}
+
+func (b *RecordBatch) IsControl() bool {
+	return b.Attributes&ControlAttribute != 0
+}
+
+func (b *RecordBatch) HasDeleteHorizon() bool {
+	return b.Attributes&DeleteHorizonAttribute != 0
+}
+
+func (b *RecordBatch) LastOffset() int64 {
+	return b.BaseOffset + int64(b.LastOffsetDelta)
+}
+
+func (b *RecordBatch) NextOffset() int64 {
+	return b.LastOffset() + 1
+}
+
+// Offset returns the absolute offset of a record of this batch.
+func (b *RecordBatch) Offset(record Record) int64 {
+	return b.BaseOffset + int64(record.OffsetDelta)
+}
+
+// Timestamp returns the timestamp of a record of this batch. With LogAppendTime all records share
+// the max timestamp of the batch.
+func (b *RecordBatch) Timestamp(record Record) int64 {
+	if b.TimestampType() == LogAppendTime {
+		return b.MaxTimestamp
+	}
+	return b.BaseTimestamp + record.TimestampDelta
+}
+
+// AppendRecord appends a record with the next offset delta and updates the offset and timestamp
+// bookkeeping of the batch. The first record sets the base timestamp.
+func (b *RecordBatch) AppendRecord(timestamp int64, key []byte, value []byte, headers []Header) {
+	if len(b.Records) == 0 {
+		b.BaseTimestamp = timestamp
+		b.MaxTimestamp = timestamp
+	}
+
+	offsetDelta := int32(0)
+	if len(b.Records) > 0 {
+		offsetDelta = b.LastOffsetDelta + 1
+	}
+
+	b.Records = append(b.Records, Record{
+		TimestampDelta: timestamp - b.BaseTimestamp,
+		OffsetDelta:    offsetDelta,
+		Key:            key,
+		Value:          value,
+		Headers:        headers,
+	})
+	b.LastOffsetDelta = offsetDelta
+	if timestamp > b.MaxTimestamp {
+		b.MaxTimestamp = timestamp
+	}
+}
+
+////////////////////
+// Decoding
+////////////////////
+
+// The end of the magic in a batch after the batch length.
+const magicEnd = 5
+
+// ReadRecordBatch reads one batch, validates its CRC and decompresses its records.
+func ReadRecordBatch(r io.Reader) (*RecordBatch, error) {
+	baseOffset, err := protocol.ReadInt64(r)
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := protocol.ReadInt32(r)
+	if err != nil {
+		return nil, err
+	}
+	// The magic follows the partition leader epoch, or the CRC of the older message formats, so the
+	// messages of magic v0 and v1 are recognized even though they are shorter than the v2 header.
+	if length < magicEnd {
+		return nil, fmt.Errorf("%w: batch length %d is smaller than the batch header", ErrCorruptBatch, length)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, min(int(length), 64*1024)))
+	if _, err := io.CopyN(buf, r, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	data := buf.Bytes()
+	if magic := int8(data[magicEnd-1]); magic != MagicV2 {
+		return nil, fmt.Errorf("%w %d", ErrUnsupportedMagic, magic)
+	}
+	if length < BatchHeaderSize-LogOverhead {
+		return nil, fmt.Errorf("%w: batch length %d is smaller than the batch header", ErrCorruptBatch, length)
+	}
+
+	return decodeBatch(baseOffset, data)
+}
+
+// ReadRecordBatches reads all batches of a records field. Fetch responses may end with a partial
+// batch cut off at the fetch size limit; such a trailing batch is ignored rather than reported.
+func ReadRecordBatches(data []byte) ([]*RecordBatch, error) {
+	batches := make([]*RecordBatch, 0)
+
+	for len(data) >= LogOverhead {
+		length := int32(binary.BigEndian.Uint32(data[8:]))
+		if length < 0 || int64(len(data)-LogOverhead) < int64(length) {
+			break
+		}
+
+		size := LogOverhead + int(length)
+		batch, err := ReadRecordBatch(bytes.NewReader(data[:size]))
+		if err != nil {
+			return nil, fmt.Errorf("batch at offset %d: %w", int64(binary.BigEndian.Uint64(data)), err)
+		}
+		batches = append(batches, batch)
+		data = data[size:]
+	}
+
+	return batches, nil
+}
+
+// decodeBatch decodes the part of a batch that follows the batch length.
+func decodeBatch(baseOffset int64, data []byte) (*RecordBatch, error) {
+	batch := &RecordBatch{BaseOffset: baseOffset}
+	batch.PartitionLeaderEpoch = int32(binary.BigEndian.Uint32(data))
+	batch.Magic = int8(data[4])
+	if batch.Magic != MagicV2 {
+		return nil, fmt.Errorf("%w %d", ErrUnsupportedMagic, batch.Magic)
+	}
+
+	batch.Crc = binary.BigEndian.Uint32(data[5:])
+	if crc := crc32.Checksum(data[9:], crc32c); crc != batch.Crc {
+		return nil, fmt.Errorf("%w: CRC is %08x, computed %08x", ErrCorruptBatch, batch.Crc, crc)
+	}
+
+	header := data[9:]
+	batch.Attributes = int16(binary.BigEndian.Uint16(header))
+	batch.LastOffsetDelta = int32(binary.BigEndian.Uint32(header[2:]))
+	batch.BaseTimestamp = int64(binary.BigEndian.Uint64(header[6:]))
+	batch.MaxTimestamp = int64(binary.BigEndian.Uint64(header[14:]))
+	batch.ProducerId = int64(binary.BigEndian.Uint64(header[22:]))
+	batch.ProducerEpoch = int16(binary.BigEndian.Uint16(header[30:]))
+	batch.BaseSequence = int32(binary.BigEndian.Uint32(header[32:]))
+	count := int32(binary.BigEndian.Uint32(header[36:]))
+	if count < 0 {
+		return nil, fmt.Errorf("%w: negative records count %d", ErrCorruptBatch, count)
+	}
+
+	payload := header[40:]
+	if batch.Compression() != compression.None {
+		var err error
+		payload, err = compression.Decompress(batch.Compression(), payload)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCorruptBatch, err)
+		}
+	}
+
+	r := bytes.NewReader(payload)
+	batch.Records = make([]Record, 0, min(int(count), 4096))
+	for i := int32(0); i < count; i++ {
+		record, err := readRecord(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: record %d: %w", ErrCorruptBatch, i, err)
+		}
+		batch.Records = append(batch.Records, record)
+	}
+
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%w: %d bytes after the last record", ErrCorruptBatch, r.Len())
+	}
+
+	return batch, nil
+}
+
+func readRecord(r *bytes.Reader) (Record, error) {
+	record := Record{}
+
+	length, err := protocol.ReadVarint(r)
+	if err != nil {
+		return record, err
+	}
+	if length < 0 || length > int64(r.Len()) {
+		return record, fmt.Errorf("invalid record length %d", length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return record, err
+	}
+	br := bytes.NewReader(body)
+
+	if record.Attributes, err = protocol.ReadInt8(br); err != nil {
+		return record, err
+	}
+	if record.TimestampDelta, err = protocol.ReadVarlong(br); err != nil {
+		return record, err
+	}
+	offsetDelta, err := protocol.ReadVarint(br)
+	if err != nil {
+		return record, err
+	}
+	record.OffsetDelta = int32(offsetDelta)
+
+	if record.Key, err = readVarintBytes(br); err != nil {
+		return record, err
+	}
+	if record.Value, err = readVarintBytes(br); err != nil {
+		return record, err
+	}
+
+	count, err := protocol.ReadVarint(br)
+	if err != nil {
+		return record, err
+	}
+	if count < 0 || count > int64(br.Len()) {
+		return record, fmt.Errorf("invalid header count %d", count)
+	}
+
+	for i := int64(0); i < count; i++ {
+		key, err := readVarintBytes(br)
+		if err != nil {
+			return record, err
+		}
+		if key == nil {
+			return record, errors.New("null header key")
+		}
+
+		value, err := readVarintBytes(br)
+		if err != nil {
+			return record, err
+		}
+
+		record.Headers = append(record.Headers, Header{Key: string(key), Value: value})
+	}
+
+	if br.Len() != 0 {
+		return record, fmt.Errorf("%d bytes after the record headers", br.Len())
+	}
+
+	return record, nil
+}
+
+// readVarintBytes reads a varint length prefixed byte array, where a length of -1 is null.
+func readVarintBytes(r *bytes.Reader) ([]byte, error) {
+	length, err := protocol.ReadVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		return nil, nil
+	}
+	if length > int64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	value := make([]byte, length)
+	_, err = io.ReadFull(r, value)
+	return value, err
+}
+
+////////////////////
+// Encoding
+////////////////////
+
+// Write encodes the batch, compressing its records with the codec from its attributes, and sets
+// Crc to the computed checksum.
+func (b *RecordBatch) Write(w io.Writer) error {
+	payload := bytes.NewBuffer(make([]byte, 0))
+	for _, record := range b.Records {
+		if err := writeRecord(payload, record); err != nil {
+			return err
+		}
+	}
+
+	records := payload.Bytes()
+	if b.Compression() != compression.None {
+		var err error
+		records, err = compression.Compress(b.Compression(), records)
+		if err != nil {
+			return err
+		}
+	}
+
+	magic := b.Magic
+	if magic == 0 {
+		magic = MagicV2
+	}
+	if magic != MagicV2 {
+		return fmt.Errorf("%w %d", ErrUnsupportedMagic, magic)
+	}
+
+	header := make([]byte, 0, BatchHeaderSize-21+len(records))
+	header = binary.BigEndian.AppendUint16(header, uint16(b.Attributes))
+	header = binary.BigEndian.AppendUint32(header, uint32(b.LastOffsetDelta))
+	header = binary.BigEndian.AppendUint64(header, uint64(b.BaseTimestamp))
+	header = binary.BigEndian.AppendUint64(header, uint64(b.MaxTimestamp))
+	header = binary.BigEndian.AppendUint64(header, uint64(b.ProducerId))
+	header = binary.BigEndian.AppendUint16(header, uint16(b.ProducerEpoch))
+	header = binary.BigEndian.AppendUint32(header, uint32(b.BaseSequence))
+	header = binary.BigEndian.AppendUint32(header, uint32(len(b.Records)))
+	header = append(header, records...)
+	b.Crc = crc32.Checksum(header, crc32c)
+
+	prefix := make([]byte, 0, 21)
+	prefix = binary.BigEndian.AppendUint64(prefix, uint64(b.BaseOffset))
+	prefix = binary.BigEndian.AppendUint32(prefix, uint32(9+len(header)))
+	prefix = binary.BigEndian.AppendUint32(prefix, uint32(b.PartitionLeaderEpoch))
+	prefix = append(prefix, byte(magic))
+	prefix = binary.BigEndian.AppendUint32(prefix, b.Crc)
+
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+	_, err := w.Write(header)
+	return err
+}
+
+// WriteRecordBatches encodes batches into the contents of a records field.
+func WriteRecordBatches(batches []*RecordBatch) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0))
+	for _, batch := range batches {
+		if err := batch.Write(buf); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeRecord(w io.Writer, record Record) error {
+	body := bytes.NewBuffer(make([]byte, 0))
+
+	if err := protocol.WriteInt8(body, record.Attributes); err != nil {
+		return err
+	}
+	if err := protocol.WriteVarlong(body, record.TimestampDelta); err != nil {
+		return err
+	}
+	if err := protocol.WriteVarint(body, int64(record.OffsetDelta)); err != nil {
+		return err
+	}
+	if err := writeVarintBytes(body, record.Key); err != nil {
+		return err
+	}
+	if err := writeVarintBytes(body, record.Value); err != nil {
+		return err
+	}
+	if err := protocol.WriteVarint(body, int64(len(record.Headers))); err != nil {
+		return err
+	}
+	for _, header := range record.Headers {
+		if err := writeVarintBytes(body, []byte(header.Key)); err != nil {
+			return err
+		}
+		if err := writeVarintBytes(body, header.Value); err != nil {
+			return err
+		}
+	}
+
+	if err := protocol.WriteVarint(w, int64(body.Len())); err != nil {
+		return err
+	}
+	_, err := w.Write(body.Bytes())
+	return err
+}
+
+func writeVarintBytes(w io.Writer, value []byte) error {
+	if value == nil {
+		return protocol.WriteVarint(w, -1)
+	}
+
+	if err := protocol.WriteVarint(w, int64(len(value))); err != nil {
+		return err
+	}
+	_, err := w.Write(value)
+	return err
+}
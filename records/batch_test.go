@@ -0,0 +1,136 @@
+package records
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"reflect"
+	"testing"
+
+	"github.com/scholzj/go-kafka-protocol/compression"
+)
+
+func testBatch(codec compression.Type) *RecordBatch {
+	batch := NewRecordBatch(100, codec)
+	batch.PartitionLeaderEpoch = 5
+	batch.AppendRecord(1000, []byte("key-1"), []byte("value-1"), []Header{{Key: "h", Value: []byte("v")}, {Key: "null"}})
+	batch.AppendRecord(1005, nil, []byte("value-2"), nil)
+	batch.AppendRecord(999, []byte("key-3"), nil, nil)
+	return batch
+}
+
+func TestRecordBatchRoundTrip(t *testing.T) {
+	for _, codec := range []compression.Type{compression.None, compression.Gzip, compression.Snappy, compression.Lz4} {
+		t.Run(codec.String(), func(t *testing.T) {
+			batch := testBatch(codec)
+			data, err := WriteRecordBatches([]*RecordBatch{batch})
+			if err != nil {
+				t.Fatalf("WriteRecordBatches: %v", err)
+			}
+
+			read, err := ReadRecordBatches(data)
+			if err != nil {
+				t.Fatalf("ReadRecordBatches: %v", err)
+			}
+			if len(read) != 1 {
+				t.Fatalf("got %d batches", len(read))
+			}
+			got := read[0]
+
+			if !reflect.DeepEqual(got, batch) {
+				t.Errorf("round trip mismatch:\n got %+v\nwant %+v", got, batch)
+			}
+			if got.Compression() != codec || got.LastOffset() != 102 || got.NextOffset() != 103 || got.MaxTimestamp != 1005 {
+				t.Errorf("unexpected batch header %+v", got)
+			}
+			if got.Offset(got.Records[2]) != 102 || got.Timestamp(got.Records[2]) != 999 {
+				t.Errorf("record 2 offset=%d timestamp=%d", got.Offset(got.Records[2]), got.Timestamp(got.Records[2]))
+			}
+		})
+	}
+}
+
+func TestReadRecordBatchKnownBytes(t *testing.T) {
+	// A single uncompressed record with a null key and the value "a", laid out field by field.
+	data := []byte{
+		0, 0, 0, 0, 0, 0, 0, 0, // base offset
+		0, 0, 0, 0, // batch length, filled in below
+		0xff, 0xff, 0xff, 0xff, // partition leader epoch
+		2,          // magic
+		0, 0, 0, 0, // CRC, filled in below
+		0, 0, // attributes
+		0, 0, 0, 0, // last offset delta
+		0, 0, 0, 0, 0, 0, 0, 0x0a, // base timestamp
+		0, 0, 0, 0, 0, 0, 0, 0x0a, // max timestamp
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, // producer id
+		0xff, 0xff, // producer epoch
+		0xff, 0xff, 0xff, 0xff, // base sequence
+		0, 0, 0, 1, // records count
+		0x0e, 0, 0, 0, 1, 2, 'a', 0, // record: length 7, attributes, deltas, null key, value "a", no headers
+	}
+	binary.BigEndian.PutUint32(data[8:], uint32(len(data)-LogOverhead))
+	binary.BigEndian.PutUint32(data[17:], crc32.Checksum(data[21:], crc32.MakeTable(crc32.Castagnoli)))
+
+	batch, err := ReadRecordBatch(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadRecordBatch: %v", err)
+	}
+	if len(batch.Records) != 1 || batch.Records[0].Key != nil || string(batch.Records[0].Value) != "a" || batch.BaseTimestamp != 10 {
+		t.Errorf("unexpected batch %+v", batch)
+	}
+
+	var buf bytes.Buffer
+	if err := batch.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("Write:\n got %x\nwant %x", buf.Bytes(), data)
+	}
+}
+
+func TestReadRecordBatchesDetectsCorruption(t *testing.T) {
+	data, _ := WriteRecordBatches([]*RecordBatch{testBatch(compression.None)})
+	data[len(data)-2] ^= 0xff
+
+	if _, err := ReadRecordBatches(data); !errors.Is(err, ErrCorruptBatch) {
+		t.Errorf("ReadRecordBatches error = %v, want ErrCorruptBatch", err)
+	}
+}
+
+func TestReadRecordBatchesIgnoresPartialTrailingBatch(t *testing.T) {
+	first := testBatch(compression.None)
+	second := testBatch(compression.None)
+	second.BaseOffset = first.NextOffset()
+	data, _ := WriteRecordBatches([]*RecordBatch{first, second})
+
+	batches, err := ReadRecordBatches(data[:len(data)-10])
+	if err != nil {
+		t.Fatalf("ReadRecordBatches: %v", err)
+	}
+	if len(batches) != 1 || batches[0].BaseOffset != 100 {
+		t.Errorf("got %d batches", len(batches))
+	}
+}
+
+func TestEndTransactionMarker(t *testing.T) {
+	batch := NewEndTransactionMarkerBatch(42, 1000, 7, 3, EndTransactionMarker{Type: ControlCommit, CoordinatorEpoch: 11})
+	data, _ := WriteRecordBatches([]*RecordBatch{batch})
+
+	batches, err := ReadRecordBatches(data)
+	if err != nil {
+		t.Fatalf("ReadRecordBatches: %v", err)
+	}
+	read := batches[0]
+	if !read.IsControl() || !read.IsTransactional() || read.ProducerId != 7 || read.ProducerEpoch != 3 {
+		t.Errorf("unexpected control batch %+v", read)
+	}
+
+	marker, err := DecodeEndTransactionMarker(read.Records[0])
+	if err != nil {
+		t.Fatalf("DecodeEndTransactionMarker: %v", err)
+	}
+	if marker.Type != ControlCommit || marker.CoordinatorEpoch != 11 {
+		t.Errorf("unexpected marker %+v", marker)
+	}
+}
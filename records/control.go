@@ -0,0 +1,109 @@
+package records
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// ControlRecordType is the type stored in the key of a record in a control batch.
+type ControlRecordType int16
+
+const (
+	ControlAbort          ControlRecordType = 0
+	ControlCommit         ControlRecordType = 1
+	ControlLeaderChange   ControlRecordType = 2
+	ControlSnapshotHeader ControlRecordType = 3
+	ControlSnapshotFooter ControlRecordType = 4
+	ControlKRaftVersion   ControlRecordType = 5
+	ControlKRaftVoters    ControlRecordType = 6
+)
+
+// ControlRecordKeyVersion is the version of the control record key.
+const ControlRecordKeyVersion int16 = 0
+
+func (t ControlRecordType) String() string {
+	switch t {
+	case ControlAbort:
+		return "ABORT"
+	case ControlCommit:
+		return "COMMIT"
+	case ControlLeaderChange:
+		return "LEADER_CHANGE"
+	case ControlSnapshotHeader:
+		return "SNAPSHOT_HEADER"
+	case ControlSnapshotFooter:
+		return "SNAPSHOT_FOOTER"
+	case ControlKRaftVersion:
+		return "KRAFT_VERSION"
+	case ControlKRaftVoters:
+		return "KRAFT_VOTERS"
+	default:
+		return fmt.Sprintf("UNKNOWN(%d)", int16(t))
+	}
+}
+
+// ControlRecordKey encodes the key of a control record.
+func ControlRecordKey(t ControlRecordType) []byte {
+	key := binary.BigEndian.AppendUint16(nil, uint16(ControlRecordKeyVersion))
+	return binary.BigEndian.AppendUint16(key, uint16(t))
+}
+
+// ControlType decodes the type from the key of a control record.
+func ControlType(record Record) (ControlRecordType, error) {
+	if len(record.Key) < 4 {
+		return 0, fmt.Errorf("%w: control record key has %d bytes", ErrCorruptBatch, len(record.Key))
+	}
+
+	version := int16(binary.BigEndian.Uint16(record.Key))
+	if version != ControlRecordKeyVersion {
+		return 0, fmt.Errorf("unsupported control record key version %d", version)
+	}
+
+	return ControlRecordType(binary.BigEndian.Uint16(record.Key[2:])), nil
+}
+
+// EndTransactionMarker is the value of the COMMIT and ABORT control records that transaction
+// coordinators write to close a transaction on a partition.
+type EndTransactionMarker struct {
+	Type             ControlRecordType
+	CoordinatorEpoch int32
+}
+
+// EndTransactionMarkerVersion is the version of the end transaction marker value.
+const EndTransactionMarkerVersion int16 = 0
+
+// DecodeEndTransactionMarker decodes a COMMIT or ABORT control record.
+func DecodeEndTransactionMarker(record Record) (EndTransactionMarker, error) {
+	t, err := ControlType(record)
+	if err != nil {
+		return EndTransactionMarker{}, err
+	}
+	if t != ControlAbort && t != ControlCommit {
+		return EndTransactionMarker{}, fmt.Errorf("control record %s is not an end transaction marker", t)
+	}
+
+	if len(record.Value) < 6 {
+		return EndTransactionMarker{}, fmt.Errorf("%w: end transaction marker has %d bytes", ErrCorruptBatch, len(record.Value))
+	}
+	if version := int16(binary.BigEndian.Uint16(record.Value)); version < EndTransactionMarkerVersion {
+		return EndTransactionMarker{}, fmt.Errorf("invalid end transaction marker version %d", version)
+	}
+
+	return EndTransactionMarker{Type: t, CoordinatorEpoch: int32(binary.BigEndian.Uint32(record.Value[2:]))}, nil
+}
+
+// NewEndTransactionMarkerBatch returns the control batch a transaction coordinator writes for
+// the producer to commit or abort its transaction on a partition.
+func NewEndTransactionMarkerBatch(baseOffset int64, timestamp int64, producerId int64, producerEpoch int16, marker EndTransactionMarker) *RecordBatch {
+	value := bytes.NewBuffer(make([]byte, 0, 6))
+	value.Write(binary.BigEndian.AppendUint16(nil, uint16(EndTransactionMarkerVersion)))
+	value.Write(binary.BigEndian.AppendUint32(nil, uint32(marker.CoordinatorEpoch)))
+
+	batch := NewRecordBatch(baseOffset, 0)
+	batch.Attributes |= TransactionalAttribute | ControlAttribute
+	batch.ProducerId = producerId
+	batch.ProducerEpoch = producerEpoch
+	batch.AppendRecord(timestamp, ControlRecordKey(marker.Type), value.Bytes(), nil)
+	return batch
+}
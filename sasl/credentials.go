@@ -0,0 +1,221 @@
+package sasl
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/scholzj/go-kafka-protocol/api/alteruserscramcredentials"
+	"github.com/scholzj/go-kafka-protocol/api/describeuserscramcredentials"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+// ScramCredential is what a server stores for a SCRAM user instead of the password.
+type ScramCredential struct {
+	Salt       []byte
+	StoredKey  []byte
+	ServerKey  []byte
+	Iterations int
+}
+
+// NewScramCredential returns the credential of a salted password, the format of the upsertions of
+// AlterUserScramCredentials.
+func NewScramCredential(mechanism ScramMechanism, salt []byte, saltedPassword []byte, iterations int) ScramCredential {
+	return ScramCredential{
+		Salt:       salt,
+		StoredKey:  mechanism.StoredKey(mechanism.ClientKey(saltedPassword)),
+		ServerKey:  mechanism.ServerKey(saltedPassword),
+		Iterations: iterations,
+	}
+}
+
+// ScramStore looks up the SCRAM credentials of users.
+type ScramStore interface {
+	ScramCredential(mechanism ScramMechanism, username string) (ScramCredential, bool)
+}
+
+type scramKey struct {
+	mechanism ScramMechanism
+	username  string
+}
+
+// Credentials is an in-memory store of the passwords of PLAIN and the credentials of SCRAM. It applies
+// AlterUserScramCredentials requests and answers DescribeUserScramCredentials requests like a broker.
+// Credentials is safe for concurrent use.
+type Credentials struct {
+	mu        sync.Mutex
+	passwords map[string]string
+	scram     map[scramKey]ScramCredential
+}
+
+func NewCredentials() *Credentials {
+	return &Credentials{passwords: make(map[string]string), scram: make(map[scramKey]ScramCredential)}
+}
+
+// SetPassword sets the PLAIN password of the user.
+func (c *Credentials) SetPassword(username string, password string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.passwords[username] = password
+}
+
+func (c *Credentials) CheckPassword(username string, password string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expected, ok := c.passwords[username]
+	return ok && subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
+}
+
+// SetScramCredential sets the SCRAM credential of the user for the mechanism.
+func (c *Credentials) SetScramCredential(mechanism ScramMechanism, username string, credential ScramCredential) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.scram[scramKey{mechanism, username}] = credential
+}
+
+func (c *Credentials) ScramCredential(mechanism ScramMechanism, username string) (ScramCredential, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	credential, ok := c.scram[scramKey{mechanism, username}]
+	return credential, ok
+}
+
+// AlterUserScramCredentials applies the deletions and upsertions of the request and returns one result
+// per user. The alterations of a user are applied only when all of them are valid.
+func (c *Credentials) AlterUserScramCredentials(req *alteruserscramcredentials.AlterUserScramCredentialsRequest) *alteruserscramcredentials.AlterUserScramCredentialsResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var users []string
+	errs := make(map[string]error)
+	seen := make(map[scramKey]bool)
+	check := func(name *string, mechanism int8) (scramKey, bool) {
+		key := scramKey{ScramMechanism(mechanism), derefString(name)}
+		if _, ok := errs[key.username]; !ok {
+			users = append(users, key.username)
+			errs[key.username] = nil
+		}
+		switch {
+		case errs[key.username] != nil:
+		case key.username == "":
+			errs[key.username] = errorcodes.ToError(errorcodes.UnacceptableCredential, str("Username must not be empty"))
+		case key.mechanism != ScramSha256 && key.mechanism != ScramSha512:
+			errs[key.username] = errorcodes.ToError(errorcodes.UnsupportedSaslMechanism, str("Unknown SCRAM mechanism"))
+		case seen[key]:
+			errs[key.username] = errorcodes.ToError(errorcodes.DuplicateResource, str("A user credential cannot be altered twice in the same request"))
+		default:
+			seen[key] = true
+			return key, true
+		}
+		return key, false
+	}
+
+	var deletions []scramKey
+	for _, d := range deref(req.Deletions) {
+		key, ok := check(d.Name, d.Mechanism)
+		if _, exists := c.scram[key]; ok && !exists {
+			errs[key.username] = errorcodes.ToError(errorcodes.ResourceNotFound, str("Attempt to delete a user credential that does not exist"))
+		}
+		deletions = append(deletions, key)
+	}
+	upsertions := make(map[scramKey]ScramCredential)
+	for _, u := range deref(req.Upsertions) {
+		key, ok := check(u.Name, u.Mechanism)
+		if !ok {
+			continue
+		}
+		salt, saltedPassword := deref(u.Salt), deref(u.SaltedPassword)
+		switch {
+		case u.Iterations < MinScramIterations || u.Iterations > MaxScramIterations:
+			errs[key.username] = errorcodes.ToError(errorcodes.UnacceptableCredential, str(fmt.Sprintf("Iterations must be between %d and %d", MinScramIterations, MaxScramIterations)))
+		case len(salt) == 0 || len(saltedPassword) == 0:
+			errs[key.username] = errorcodes.ToError(errorcodes.UnacceptableCredential, str("Salt and salted password must not be empty"))
+		default:
+			upsertions[key] = NewScramCredential(key.mechanism, salt, saltedPassword, int(u.Iterations))
+		}
+	}
+
+	for _, key := range deletions {
+		if errs[key.username] == nil {
+			delete(c.scram, key)
+		}
+	}
+	for key, credential := range upsertions {
+		if errs[key.username] == nil {
+			c.scram[key] = credential
+		}
+	}
+
+	results := make([]alteruserscramcredentials.AlterUserScramCredentialsResponseResult, 0, len(users))
+	for _, user := range users {
+		result := alteruserscramcredentials.AlterUserScramCredentialsResponseResult{User: str(user)}
+		if kafkaErr, ok := errs[user].(*errorcodes.Error); ok {
+			result.ErrorCode, result.ErrorMessage = kafkaErr.Code, str(kafkaErr.Message)
+		}
+		results = append(results, result)
+	}
+	return &alteruserscramcredentials.AlterUserScramCredentialsResponse{ApiVersion: req.ApiVersion, Results: &results}
+}
+
+// DescribeUserScramCredentials describes the SCRAM credentials of the users of the request, or of all
+// users without users. Users are sorted by name when all of them are described.
+func (c *Credentials) DescribeUserScramCredentials(req *describeuserscramcredentials.DescribeUserScramCredentialsRequest) *describeuserscramcredentials.DescribeUserScramCredentialsResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var users []string
+	requested := req.Users != nil && len(*req.Users) > 0
+	counts := make(map[string]int)
+	if requested {
+		for _, u := range *req.Users {
+			name := derefString(u.Name)
+			if counts[name] == 0 {
+				users = append(users, name)
+			}
+			counts[name]++
+		}
+	} else {
+		for key := range c.scram {
+			if counts[key.username] == 0 {
+				users = append(users, key.username)
+			}
+			counts[key.username]++
+		}
+		sort.Strings(users)
+	}
+
+	results := make([]describeuserscramcredentials.DescribeUserScramCredentialsResponseResult, 0, len(users))
+	for _, user := range users {
+		result := describeuserscramcredentials.DescribeUserScramCredentialsResponseResult{User: str(user), CredentialInfos: &[]describeuserscramcredentials.DescribeUserScramCredentialsResponseResultCredentialInfo{}}
+		for _, m := range []ScramMechanism{ScramSha256, ScramSha512} {
+			if credential, ok := c.scram[scramKey{m, user}]; ok {
+				*result.CredentialInfos = append(*result.CredentialInfos, describeuserscramcredentials.DescribeUserScramCredentialsResponseResultCredentialInfo{Mechanism: int8(m), Iterations: int32(credential.Iterations)})
+			}
+		}
+		switch {
+		case requested && counts[user] > 1:
+			result.ErrorCode, result.ErrorMessage = errorcodes.DuplicateResource, str("Cannot describe SCRAM credentials for the same user twice in a single request")
+			result.CredentialInfos = &[]describeuserscramcredentials.DescribeUserScramCredentialsResponseResultCredentialInfo{}
+		case len(*result.CredentialInfos) == 0:
+			result.ErrorCode, result.ErrorMessage = errorcodes.ResourceNotFound, str("Attempt to describe a user credential that does not exist")
+		}
+		results = append(results, result)
+	}
+	return &describeuserscramcredentials.DescribeUserScramCredentialsResponse{ApiVersion: req.ApiVersion, Results: &results}
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func str(s string) *string {
+	return &s
+}
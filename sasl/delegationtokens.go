@@ -0,0 +1,354 @@
+package sasl
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scholzj/go-kafka-protocol/api/createdelegationtoken"
+	"github.com/scholzj/go-kafka-protocol/api/describedelegationtoken"
+	"github.com/scholzj/go-kafka-protocol/api/expiredelegationtoken"
+	"github.com/scholzj/go-kafka-protocol/api/renewdelegationtoken"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+// Delegation tokens (KIP-48) are shared secrets which the brokers derive from their secret key: the
+// HMAC of a token is the HMAC-SHA512 of its id with the key. A client authenticates with a token using
+// SCRAM, with the token id as user name, the base64 encoded HMAC as password and the tokenauth=true
+// extension, and is then authenticated as the owner of the token.
+
+// Defaults of the brokers, like delegation.token.max.lifetime.ms and delegation.token.expiry.time.ms.
+const (
+	DefaultTokenMaxLifetime = 7 * 24 * time.Hour
+	DefaultTokenExpiryTime  = 24 * time.Hour
+)
+
+// The SCRAM extension of clients authenticating with a delegation token.
+const tokenAuthExtension = "tokenauth"
+
+// DelegationToken is a delegation token with its HMAC.
+type DelegationToken struct {
+	TokenId    string
+	Hmac       []byte
+	Owner      string   // The principal of the owner, like "User:alice".
+	Requester  string   // The principal which created the token, the owner before version 3.
+	Renewers   []string // The principals which can renew and expire the token besides the owner and the requester.
+	IssueTime  time.Time
+	ExpiryTime time.Time // When the token expires unless it is renewed.
+	MaxTime    time.Time // After which the token cannot be renewed anymore.
+}
+
+// Password returns the SCRAM password of the token, its base64 encoded HMAC.
+func (t DelegationToken) Password() string {
+	return base64.StdEncoding.EncodeToString(t.Hmac)
+}
+
+// Scram returns the client mechanism which authenticates with the token.
+func (t DelegationToken) Scram(mechanism ScramMechanism) *Scram {
+	s := NewScram(mechanism, t.TokenId, t.Password())
+	s.Extensions = map[string]string{tokenAuthExtension: "true"}
+	return s
+}
+
+// DelegationTokenHmac returns the HMAC of the token with the secret key of the brokers.
+func DelegationTokenHmac(secret []byte, tokenId string) []byte {
+	mac := hmac.New(sha512.New, secret)
+	mac.Write([]byte(tokenId))
+	return mac.Sum(nil)
+}
+
+// CheckDelegationTokenHmac reports whether the HMAC of the token was derived from the secret key.
+func CheckDelegationTokenHmac(secret []byte, tokenId string, tokenHmac []byte) bool {
+	return hmac.Equal(DelegationTokenHmac(secret, tokenId), tokenHmac)
+}
+
+// TokenStore looks up the delegation tokens of clients authenticating with tokenauth.
+type TokenStore interface {
+	TokenCredential(mechanism ScramMechanism, tokenId string) (ScramCredential, DelegationToken, bool)
+}
+
+// tokenAuthenticated reports whether the client authenticated with a delegation token.
+func tokenAuthenticated(extensions map[string]string) bool {
+	return strings.EqualFold(extensions[tokenAuthExtension], "true")
+}
+
+////////////////////
+// Token store
+////////////////////
+
+type storedToken struct {
+	token       DelegationToken
+	credentials map[ScramMechanism]ScramCredential
+}
+
+// DelegationTokens is an in-memory store of delegation tokens. It answers the delegation token requests
+// of authenticated clients like a broker with the secret key, and looks up the tokens for ScramServer.
+// Like brokers without an authorizer, any client can describe all tokens. DelegationTokens is safe for
+// concurrent use.
+type DelegationTokens struct {
+	Secret      []byte
+	MaxLifetime time.Duration // The longest lifetime of a token, which clients can only shorten.
+	ExpiryTime  time.Duration // The time until a token expires, when it is created or renewed by default.
+
+	mu     sync.Mutex
+	tokens map[string]*storedToken
+	now    func() time.Time
+}
+
+func NewDelegationTokens(secret []byte) *DelegationTokens {
+	return &DelegationTokens{
+		Secret:      secret,
+		MaxLifetime: DefaultTokenMaxLifetime,
+		ExpiryTime:  DefaultTokenExpiryTime,
+		tokens:      make(map[string]*storedToken),
+		now:         time.Now,
+	}
+}
+
+// principal returns the principal of an authenticated client which may use the token requests. Like
+// Kafka, clients which authenticated with a token may not.
+func (d *DelegationTokens) principal(identity *Identity) (string, int16) {
+	if identity == nil || tokenAuthenticated(identity.Extensions) {
+		return "", errorcodes.DelegationTokenRequestNotAllowed
+	}
+	return "User:" + identity.Principal, errorcodes.None
+}
+
+// CreateDelegationToken creates a token of the owner of the request, or of the client without owner,
+// with a new random id and the SCRAM credentials of its HMAC.
+func (d *DelegationTokens) CreateDelegationToken(identity *Identity, req *createdelegationtoken.CreateDelegationTokenRequest) *createdelegationtoken.CreateDelegationTokenResponse {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	requester, code := d.principal(identity)
+	owner := requester
+	if req.OwnerPrincipalName != nil {
+		owner = derefString(req.OwnerPrincipalType) + ":" + *req.OwnerPrincipalName
+	}
+	token := DelegationToken{Owner: owner, Requester: requester}
+	for _, r := range deref(req.Renewers) {
+		if derefString(r.PrincipalType) != "User" && code == errorcodes.None {
+			code = errorcodes.InvalidPrincipalType
+		}
+		token.Renewers = append(token.Renewers, derefString(r.PrincipalType)+":"+derefString(r.PrincipalName))
+	}
+
+	if code == errorcodes.None {
+		if err := d.issue(&token, req.MaxLifetimeMs); err != nil {
+			code = errorcodes.UnknownServerError
+		}
+	}
+
+	// Like Kafka, a rejected request returns no principals.
+	if code != errorcodes.None {
+		token.Owner, token.Requester = "", ""
+	}
+	ownerType, ownerName, _ := strings.Cut(token.Owner, ":")
+	requesterType, requesterName, _ := strings.Cut(token.Requester, ":")
+	tokenHmac := append([]byte{}, token.Hmac...)
+	return &createdelegationtoken.CreateDelegationTokenResponse{
+		ApiVersion:                  req.ApiVersion,
+		ErrorCode:                   code,
+		PrincipalType:               &ownerType,
+		PrincipalName:               &ownerName,
+		TokenRequesterPrincipalType: &requesterType,
+		TokenRequesterPrincipalName: &requesterName,
+		IssueTimestampMs:            millis(token.IssueTime),
+		ExpiryTimestampMs:           millis(token.ExpiryTime),
+		MaxTimestampMs:              millis(token.MaxTime),
+		TokenId:                     &token.TokenId,
+		Hmac:                        &tokenHmac,
+	}
+}
+
+// issue stores the token with a new random id, its HMAC and the SCRAM credentials of its password for
+// all mechanisms.
+func (d *DelegationTokens) issue(token *DelegationToken, maxLifetimeMs int64) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	token.TokenId = base64.RawURLEncoding.EncodeToString(id)
+	token.Hmac = DelegationTokenHmac(d.Secret, token.TokenId)
+
+	credentials := make(map[ScramMechanism]ScramCredential)
+	for _, m := range []ScramMechanism{ScramSha256, ScramSha512} {
+		salt := make([]byte, scramSaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		saltedPassword := m.SaltedPassword([]byte(token.Password()), salt, MinScramIterations)
+		credentials[m] = NewScramCredential(m, salt, saltedPassword, MinScramIterations)
+	}
+
+	lifetime := d.MaxLifetime
+	if maxLifetimeMs > 0 {
+		lifetime = min(lifetime, time.Duration(maxLifetimeMs)*time.Millisecond)
+	}
+	token.IssueTime = d.now()
+	token.MaxTime = token.IssueTime.Add(lifetime)
+	token.ExpiryTime = minTime(token.IssueTime.Add(d.ExpiryTime), token.MaxTime)
+	d.tokens[token.TokenId] = &storedToken{token: *token, credentials: credentials}
+	return nil
+}
+
+// lookup returns the token with the HMAC which the client may renew or expire: the owner, the
+// requester which created it and the renewers may.
+func (d *DelegationTokens) lookup(identity *Identity, tokenHmac *[]byte) (*storedToken, int16) {
+	principal, code := d.principal(identity)
+	if code != errorcodes.None {
+		return nil, code
+	}
+	for _, stored := range d.tokens {
+		if !hmac.Equal(stored.token.Hmac, deref(tokenHmac)) {
+			continue
+		}
+		if stored.token.Owner != principal && stored.token.Requester != principal && !slices.Contains(stored.token.Renewers, principal) {
+			return nil, errorcodes.DelegationTokenOwnerMismatch
+		}
+		return stored, errorcodes.None
+	}
+	return nil, errorcodes.DelegationTokenNotFound
+}
+
+// RenewDelegationToken extends the expiry of the token by the renew period, or by ExpiryTime with a
+// negative period, up to its maximum lifetime. Only the owner, the requester and the renewers can
+// renew a token.
+func (d *DelegationTokens) RenewDelegationToken(identity *Identity, req *renewdelegationtoken.RenewDelegationTokenRequest) *renewdelegationtoken.RenewDelegationTokenResponse {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	res := &renewdelegationtoken.RenewDelegationTokenResponse{ApiVersion: req.ApiVersion, ExpiryTimestampMs: -1}
+	stored, code := d.lookup(identity, req.Hmac)
+	now := d.now()
+	if code == errorcodes.None && (stored.token.MaxTime.Before(now) || stored.token.ExpiryTime.Before(now)) {
+		code = errorcodes.DelegationTokenExpired
+	}
+	if code != errorcodes.None {
+		res.ErrorCode = code
+		return res
+	}
+
+	period := d.ExpiryTime
+	if req.RenewPeriodMs >= 0 {
+		period = time.Duration(req.RenewPeriodMs) * time.Millisecond
+	}
+	stored.token.ExpiryTime = minTime(now.Add(period), stored.token.MaxTime)
+	res.ExpiryTimestampMs = millis(stored.token.ExpiryTime)
+	return res
+}
+
+// ExpireDelegationToken shortens the expiry of the token to the expiry period, or removes the token
+// with a negative period. Only the owner, the requester and the renewers can expire a token.
+func (d *DelegationTokens) ExpireDelegationToken(identity *Identity, req *expiredelegationtoken.ExpireDelegationTokenRequest) *expiredelegationtoken.ExpireDelegationTokenResponse {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	res := &expiredelegationtoken.ExpireDelegationTokenResponse{ApiVersion: req.ApiVersion, ExpiryTimestampMs: -1}
+	stored, code := d.lookup(identity, req.Hmac)
+	now := d.now()
+	switch {
+	case code != errorcodes.None:
+		res.ErrorCode = code
+	case req.ExpiryTimePeriodMs < 0:
+		delete(d.tokens, stored.token.TokenId)
+		res.ExpiryTimestampMs = millis(now)
+	case stored.token.MaxTime.Before(now) || stored.token.ExpiryTime.Before(now):
+		res.ErrorCode = errorcodes.DelegationTokenExpired
+	default:
+		stored.token.ExpiryTime = minTime(now.Add(time.Duration(req.ExpiryTimePeriodMs)*time.Millisecond), stored.token.MaxTime)
+		res.ExpiryTimestampMs = millis(stored.token.ExpiryTime)
+	}
+	return res
+}
+
+// DescribeDelegationToken describes the tokens owned or renewable by the owners of the request, or all
+// tokens without owners, sorted by issue time.
+func (d *DelegationTokens) DescribeDelegationToken(identity *Identity, req *describedelegationtoken.DescribeDelegationTokenRequest) *describedelegationtoken.DescribeDelegationTokenResponse {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tokens := []describedelegationtoken.DescribeDelegationTokenResponseToken{}
+	res := &describedelegationtoken.DescribeDelegationTokenResponse{ApiVersion: req.ApiVersion, Tokens: &tokens}
+	if _, code := d.principal(identity); code != errorcodes.None {
+		res.ErrorCode = code
+		return res
+	}
+
+	var matching []DelegationToken
+	for _, stored := range d.tokens {
+		matches := req.Owners == nil
+		for _, o := range deref(req.Owners) {
+			owner := derefString(o.PrincipalType) + ":" + derefString(o.PrincipalName)
+			matches = matches || stored.token.Owner == owner || slices.Contains(stored.token.Renewers, owner)
+		}
+		if matches {
+			matching = append(matching, stored.token)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		if !matching[i].IssueTime.Equal(matching[j].IssueTime) {
+			return matching[i].IssueTime.Before(matching[j].IssueTime)
+		}
+		return matching[i].TokenId < matching[j].TokenId
+	})
+
+	for _, token := range matching {
+		ownerType, ownerName, _ := strings.Cut(token.Owner, ":")
+		requesterType, requesterName, _ := strings.Cut(token.Requester, ":")
+		tokenId, tokenHmac := token.TokenId, append([]byte{}, token.Hmac...)
+		renewers := make([]describedelegationtoken.DescribeDelegationTokenResponseTokenRenewer, 0, len(token.Renewers))
+		for _, r := range token.Renewers {
+			renewerType, renewerName, _ := strings.Cut(r, ":")
+			renewers = append(renewers, describedelegationtoken.DescribeDelegationTokenResponseTokenRenewer{PrincipalType: &renewerType, PrincipalName: &renewerName})
+		}
+		tokens = append(tokens, describedelegationtoken.DescribeDelegationTokenResponseToken{
+			PrincipalType:               &ownerType,
+			PrincipalName:               &ownerName,
+			TokenRequesterPrincipalType: &requesterType,
+			TokenRequesterPrincipalName: &requesterName,
+			IssueTimestamp:              millis(token.IssueTime),
+			ExpiryTimestamp:             millis(token.ExpiryTime),
+			MaxTimestamp:                millis(token.MaxTime),
+			TokenId:                     &tokenId,
+			Hmac:                        &tokenHmac,
+			Renewers:                    &renewers,
+		})
+	}
+	return res
+}
+
+// TokenCredential returns the SCRAM credential of the token for the mechanism. Expired tokens are
+// returned as well, and the sessions of their clients expire immediately.
+func (d *DelegationTokens) TokenCredential(mechanism ScramMechanism, tokenId string) (ScramCredential, DelegationToken, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stored, ok := d.tokens[tokenId]
+	if !ok {
+		return ScramCredential{}, DelegationToken{}, false
+	}
+	credential, ok := stored.credentials[mechanism]
+	return credential, stored.token, ok
+}
+
+func minTime(a time.Time, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// millis returns the timestamp in milliseconds, or -1 for the zero time.
+func millis(t time.Time) int64 {
+	if t.IsZero() {
+		return -1
+	}
+	return t.UnixMilli()
+}
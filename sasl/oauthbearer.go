@@ -0,0 +1,91 @@
+package sasl
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The separator of the key-value pairs of OAUTHBEARER messages.
+const oauthSeparator = "\x01"
+
+var (
+	oauthExtensionKey   = regexp.MustCompile(`^[A-Za-z]+$`)
+	oauthExtensionValue = regexp.MustCompile(`^[\x21-\x7E \t\r\n]+$`)
+)
+
+// OAuthBearer is the OAUTHBEARER mechanism (RFC 7628) with a bearer token, for example a JWT from an
+// OAuth authorization server. The token is fetched again for every authentication, so a provider can
+// refresh it before re-authentication.
+type OAuthBearer struct {
+	Token           func(ctx context.Context) (string, error)
+	AuthorizationId string
+	Extensions      map[string]string // SASL extensions (KIP-342), like a logical cluster id.
+}
+
+func NewOAuthBearer(token func(ctx context.Context) (string, error)) *OAuthBearer {
+	return &OAuthBearer{Token: token}
+}
+
+func (o *OAuthBearer) Name() string {
+	return "OAUTHBEARER"
+}
+
+// Start returns the client message with the token and the extensions.
+func (o *OAuthBearer) Start(ctx context.Context) (Exchange, []byte, error) {
+	token, err := o.Token(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	message, err := OAuthBearerMessage(o.AuthorizationId, token, o.Extensions)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &oauthExchange{}, message, nil
+}
+
+// OAuthBearerMessage returns the client message of OAUTHBEARER, the GS2 header followed by the token
+// and the extensions as key-value pairs.
+func OAuthBearerMessage(authorizationId string, token string, extensions map[string]string) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString("n,")
+	if authorizationId != "" {
+		b.WriteString("a=" + EscapeScramName(authorizationId))
+	}
+	b.WriteString("," + oauthSeparator + "auth=Bearer " + token)
+
+	keys := make([]string, 0, len(extensions))
+	for key := range extensions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := extensions[key]
+		if !oauthExtensionKey.MatchString(key) || key == "auth" || !oauthExtensionValue.MatchString(value) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidExtension, key)
+		}
+		b.WriteString(oauthSeparator + key + "=" + value)
+	}
+	b.WriteString(oauthSeparator + oauthSeparator)
+	return []byte(b.String()), nil
+}
+
+// oauthExchange completes with an empty server message. A failed authentication is answered with an
+// error in JSON first, which the client has to acknowledge before the server fails the authentication.
+type oauthExchange struct {
+	failure string
+}
+
+func (e *oauthExchange) Next(challenge []byte) ([]byte, bool, error) {
+	if e.failure != "" {
+		return nil, false, fmt.Errorf("%w: %s", ErrAuthenticationFailed, e.failure)
+	}
+	if len(challenge) > 0 {
+		e.failure = string(challenge)
+		return []byte(oauthSeparator), false, nil
+	}
+	return nil, true, nil
+}
@@ -0,0 +1,33 @@
+package sasl
+
+import (
+	"context"
+)
+
+// Plain is the PLAIN mechanism (RFC 4616). It sends the password in clear text, so it should only be
+// used over TLS.
+type Plain struct {
+	AuthorizationId string // Empty to act as the user itself, which is all Kafka accepts.
+	Username        string
+	Password        string
+}
+
+func NewPlain(username string, password string) *Plain {
+	return &Plain{Username: username, Password: password}
+}
+
+func (p *Plain) Name() string {
+	return "PLAIN"
+}
+
+// Start returns the only message of the client: the authorization id, the user name and the password
+// separated by NUL.
+func (p *Plain) Start(ctx context.Context) (Exchange, []byte, error) {
+	message := make([]byte, 0, len(p.AuthorizationId)+len(p.Username)+len(p.Password)+2)
+	message = append(message, p.AuthorizationId...)
+	message = append(message, 0)
+	message = append(message, p.Username...)
+	message = append(message, 0)
+	message = append(message, p.Password...)
+	return done{}, message, nil
+}
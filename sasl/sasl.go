@@ -0,0 +1,134 @@
+package sasl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/scholzj/go-kafka-protocol/api/saslauthenticate"
+	"github.com/scholzj/go-kafka-protocol/api/saslhandshake"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+// SASL mechanisms and the flow which authenticates a connection with them: SaslHandshake selects the
+// mechanism and SaslAuthenticate carries the messages of the mechanism until the exchange completes.
+// Brokers which return a session lifetime expect the connection to authenticate again before the
+// session expires (KIP-368). The server side of the mechanisms authenticates the connections of mock
+// brokers and proxies.
+
+var (
+	ErrUnsupportedHandshake  = errors.New("the broker does not support SaslHandshake version 1")
+	ErrUnsupportedMechanism  = errors.New("the broker does not support the SASL mechanism")
+	ErrAuthenticationFailed  = errors.New("SASL authentication failed")
+	ErrInvalidMessage        = errors.New("invalid SASL message")
+	ErrInvalidServerProof    = errors.New("invalid SCRAM server signature")
+	ErrInvalidExtension      = errors.New("invalid SASL extension")
+	ErrUnexpectedServerReply = errors.New("unexpected SASL server message after the exchange completed")
+)
+
+// Mechanism is a client SASL mechanism.
+type Mechanism interface {
+	// Name returns the name of the mechanism sent in SaslHandshake, like PLAIN.
+	Name() string
+	// Start starts an exchange and returns the first client message.
+	Start(ctx context.Context) (Exchange, []byte, error)
+}
+
+// Exchange is one authentication exchange of a mechanism.
+type Exchange interface {
+	// Next processes a server message and returns the next client message, or done when the exchange
+	// completed successfully with the server message.
+	Next(challenge []byte) (response []byte, done bool, err error)
+}
+
+// Conn is a connection to a broker which is authenticated.
+type Conn interface {
+	// Version returns the version of the API to use on the connection.
+	Version(apiKey int16) (int16, error)
+	// RoundTrip sends the request with the version and decodes the response into res.
+	RoundTrip(ctx context.Context, apiKey int16, version int16, req protocol.RequestBody, res protocol.ResponseBody) error
+}
+
+// Session is an authenticated SASL session.
+type Session struct {
+	Mechanism string
+	Lifetime  time.Duration // 0 when the session does not expire.
+}
+
+// Reauthenticate returns after how long the session should be authenticated again: like the Java
+// client, at a random point between 85% and 95% of its lifetime. It returns 0 when the session does
+// not expire.
+func (s Session) Reauthenticate() time.Duration {
+	return s.Lifetime * time.Duration(85+rand.IntN(11)) / 100
+}
+
+// Authenticate authenticates the connection with the mechanism. It requires version 1 of
+// SaslHandshake, with which the messages of the mechanism are sent with SaslAuthenticate. The same
+// flow authenticates a connection again.
+func Authenticate(ctx context.Context, conn Conn, mechanism Mechanism) (Session, error) {
+	version, err := conn.Version(messages.SaslHandshake)
+	if err != nil {
+		return Session{}, err
+	}
+	if version < 1 {
+		return Session{}, ErrUnsupportedHandshake
+	}
+
+	name := mechanism.Name()
+	handshake := &saslhandshake.SaslHandshakeResponse{}
+	if err := conn.RoundTrip(ctx, messages.SaslHandshake, version, &saslhandshake.SaslHandshakeRequest{ApiVersion: version, Mechanism: &name}, handshake); err != nil {
+		return Session{}, err
+	}
+	if handshake.ErrorCode == errorcodes.UnsupportedSaslMechanism {
+		return Session{}, fmt.Errorf("%w: %s, enabled mechanisms %v", ErrUnsupportedMechanism, name, deref(handshake.Mechanisms))
+	}
+	if err := errorcodes.ToError(handshake.ErrorCode, nil); err != nil {
+		return Session{}, err
+	}
+
+	if version, err = conn.Version(messages.SaslAuthenticate); err != nil {
+		return Session{}, err
+	}
+	exchange, message, err := mechanism.Start(ctx)
+	if err != nil {
+		return Session{}, err
+	}
+	for {
+		res := &saslauthenticate.SaslAuthenticateResponse{}
+		if err := conn.RoundTrip(ctx, messages.SaslAuthenticate, version, &saslauthenticate.SaslAuthenticateRequest{ApiVersion: version, AuthBytes: &message}, res); err != nil {
+			return Session{}, err
+		}
+		if err := errorcodes.ToError(res.ErrorCode, res.ErrorMessage); err != nil {
+			return Session{}, fmt.Errorf("%w: %w", ErrAuthenticationFailed, err)
+		}
+
+		var done bool
+		if message, done, err = exchange.Next(deref(res.AuthBytes)); err != nil {
+			return Session{}, fmt.Errorf("%w: %w", ErrAuthenticationFailed, err)
+		}
+		if done {
+			return Session{Mechanism: name, Lifetime: time.Duration(res.SessionLifetimeMs) * time.Millisecond}, nil
+		}
+	}
+}
+
+// done is the exchange of mechanisms which only send one message and complete with the server reply.
+type done struct{}
+
+func (done) Next(challenge []byte) ([]byte, bool, error) {
+	if len(challenge) > 0 {
+		return nil, false, fmt.Errorf("%w: %q", ErrUnexpectedServerReply, challenge)
+	}
+	return nil, true, nil
+}
+
+func deref[T any](s *[]T) []T {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
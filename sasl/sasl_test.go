@@ -0,0 +1,521 @@
+package sasl
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scholzj/go-kafka-protocol/api/alteruserscramcredentials"
+	"github.com/scholzj/go-kafka-protocol/api/createdelegationtoken"
+	"github.com/scholzj/go-kafka-protocol/api/describedelegationtoken"
+	"github.com/scholzj/go-kafka-protocol/api/describeuserscramcredentials"
+	"github.com/scholzj/go-kafka-protocol/api/expiredelegationtoken"
+	"github.com/scholzj/go-kafka-protocol/api/renewdelegationtoken"
+	"github.com/scholzj/go-kafka-protocol/api/saslauthenticate"
+	"github.com/scholzj/go-kafka-protocol/api/saslhandshake"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+	"github.com/scholzj/go-kafka-protocol/messages"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+)
+
+// testConn answers SaslHandshake with the error and SaslAuthenticate with the replies in order.
+type testConn struct {
+	handshakeVersion int16
+	handshakeError   int16
+	replies          []saslauthenticate.SaslAuthenticateResponse
+	mechanism        string
+	messages         [][]byte
+}
+
+func (c *testConn) Version(apiKey int16) (int16, error) {
+	if apiKey == messages.SaslHandshake {
+		return c.handshakeVersion, nil
+	}
+	return 2, nil
+}
+
+func (c *testConn) RoundTrip(ctx context.Context, apiKey int16, version int16, req protocol.RequestBody, res protocol.ResponseBody) error {
+	switch r := req.(type) {
+	case *saslhandshake.SaslHandshakeRequest:
+		c.mechanism = *r.Mechanism
+		*res.(*saslhandshake.SaslHandshakeResponse) = saslhandshake.SaslHandshakeResponse{ErrorCode: c.handshakeError, Mechanisms: &[]string{"PLAIN"}}
+	case *saslauthenticate.SaslAuthenticateRequest:
+		c.messages = append(c.messages, *r.AuthBytes)
+		*res.(*saslauthenticate.SaslAuthenticateResponse) = c.replies[0]
+		c.replies = c.replies[1:]
+	}
+	return nil
+}
+
+func reply(message string, lifetimeMs int64) saslauthenticate.SaslAuthenticateResponse {
+	b := []byte(message)
+	return saslauthenticate.SaslAuthenticateResponse{AuthBytes: &b, SessionLifetimeMs: lifetimeMs}
+}
+
+func TestPlain(t *testing.T) {
+	conn := &testConn{handshakeVersion: 1, replies: []saslauthenticate.SaslAuthenticateResponse{reply("", 60000)}}
+	session, err := Authenticate(context.Background(), conn, NewPlain("alice", "secret"))
+	if err != nil || session.Mechanism != "PLAIN" || session.Lifetime != time.Minute {
+		t.Fatalf("unexpected session %+v (%v)", session, err)
+	}
+	if conn.mechanism != "PLAIN" || len(conn.messages) != 1 || !bytes.Equal(conn.messages[0], []byte("\x00alice\x00secret")) {
+		t.Errorf("unexpected messages %q of %s", conn.messages, conn.mechanism)
+	}
+	if r := session.Reauthenticate(); r < 51*time.Second || r > 57*time.Second {
+		t.Errorf("unexpected re-authentication after %v", r)
+	}
+}
+
+// The example exchange of RFC 7677.
+func TestScram(t *testing.T) {
+	const (
+		clientFirst = "n,,n=user,r=rOprNGfwEbeRWgbNEkqO"
+		serverFirst = "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"
+		clientFinal = "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+		serverFinal = "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="
+	)
+	scram := NewScram(ScramSha256, "user", "pencil")
+	scram.nonce = func() (string, error) { return "rOprNGfwEbeRWgbNEkqO", nil }
+
+	conn := &testConn{handshakeVersion: 1, replies: []saslauthenticate.SaslAuthenticateResponse{reply(serverFirst, 0), reply(serverFinal, 0)}}
+	session, err := Authenticate(context.Background(), conn, scram)
+	if err != nil || session.Mechanism != "SCRAM-SHA-256" || session.Lifetime != 0 {
+		t.Fatalf("unexpected session %+v (%v)", session, err)
+	}
+	if len(conn.messages) != 2 || string(conn.messages[0]) != clientFirst || string(conn.messages[1]) != clientFinal {
+		t.Errorf("unexpected messages %q", conn.messages)
+	}
+
+	tests := []struct {
+		serverFirst string
+		serverFinal string
+		expected    error
+	}{
+		{serverFirst, "v=AAAA", ErrInvalidServerProof},
+		{serverFirst, "e=invalid-proof", ErrInvalidMessage},
+		{"r=other%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096", "", ErrInvalidMessage},
+		{"r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=1024", "", ErrInvalidMessage},
+		{"e=unknown-user", "", ErrInvalidMessage},
+	}
+	for _, test := range tests {
+		conn := &testConn{handshakeVersion: 1, replies: []saslauthenticate.SaslAuthenticateResponse{reply(test.serverFirst, 0), reply(test.serverFinal, 0)}}
+		if _, err := Authenticate(context.Background(), conn, scram); !errors.Is(err, test.expected) || !errors.Is(err, ErrAuthenticationFailed) {
+			t.Errorf("%s, %s: unexpected error %v", test.serverFirst, test.serverFinal, err)
+		}
+	}
+
+	scram = NewScram(ScramSha512, "us=er,1", "pencil")
+	scram.Extensions = map[string]string{"tokenauth": "true"}
+	_, first, err := scram.Start(context.Background())
+	if err != nil || !bytes.HasPrefix(first, []byte("n,,n=us=3Der=2C1,r=")) || !bytes.HasSuffix(first, []byte(",tokenauth=true")) {
+		t.Errorf("unexpected first message %q (%v)", first, err)
+	}
+	if name, err := UnescapeScramName("us=3Der=2C1"); err != nil || name != "us=er,1" {
+		t.Errorf("unexpected name %q (%v)", name, err)
+	}
+}
+
+func TestOAuthBearer(t *testing.T) {
+	oauth := NewOAuthBearer(func(ctx context.Context) (string, error) { return "token", nil })
+	oauth.Extensions = map[string]string{"cluster": "lkc-1", "env": "test"}
+
+	conn := &testConn{handshakeVersion: 1, replies: []saslauthenticate.SaslAuthenticateResponse{reply("", 0)}}
+	if _, err := Authenticate(context.Background(), conn, oauth); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if expected := "n,,\x01auth=Bearer token\x01cluster=lkc-1\x01env=test\x01\x01"; len(conn.messages) != 1 || string(conn.messages[0]) != expected {
+		t.Errorf("unexpected messages %q", conn.messages)
+	}
+
+	// A rejected token is answered with an error in JSON, which the client acknowledges.
+	conn = &testConn{handshakeVersion: 1, replies: []saslauthenticate.SaslAuthenticateResponse{
+		reply(`{"status":"invalid_token"}`, 0),
+		{ErrorCode: errorcodes.SaslAuthenticationFailed},
+	}}
+	var kafkaErr *errorcodes.Error
+	if _, err := Authenticate(context.Background(), conn, oauth); !errors.As(err, &kafkaErr) || kafkaErr.Code != errorcodes.SaslAuthenticationFailed {
+		t.Errorf("unexpected error %v", err)
+	}
+	if len(conn.messages) != 2 || string(conn.messages[1]) != "\x01" {
+		t.Errorf("unexpected messages %q", conn.messages)
+	}
+
+	oauth.Extensions = map[string]string{"auth": "x"}
+	if _, _, err := oauth.Start(context.Background()); !errors.Is(err, ErrInvalidExtension) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestAuthenticateErrors(t *testing.T) {
+	plain := NewPlain("alice", "secret")
+	if _, err := Authenticate(context.Background(), &testConn{handshakeVersion: 0}, plain); !errors.Is(err, ErrUnsupportedHandshake) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := Authenticate(context.Background(), &testConn{handshakeVersion: 1, handshakeError: errorcodes.UnsupportedSaslMechanism}, plain); !errors.Is(err, ErrUnsupportedMechanism) {
+		t.Errorf("unexpected error %v", err)
+	}
+	conn := &testConn{handshakeVersion: 1, replies: []saslauthenticate.SaslAuthenticateResponse{reply("unexpected", 0)}}
+	if _, err := Authenticate(context.Background(), conn, plain); !errors.Is(err, ErrUnexpectedServerReply) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+// serverConn sends the requests of a client to a server session.
+type serverConn struct {
+	session *ServerSession
+}
+
+func (c serverConn) Version(apiKey int16) (int16, error) {
+	return 1, nil
+}
+
+func (c serverConn) RoundTrip(ctx context.Context, apiKey int16, version int16, req protocol.RequestBody, res protocol.ResponseBody) error {
+	switch r := req.(type) {
+	case *saslhandshake.SaslHandshakeRequest:
+		*res.(*saslhandshake.SaslHandshakeResponse) = *c.session.Handshake(r)
+	case *saslauthenticate.SaslAuthenticateRequest:
+		*res.(*saslauthenticate.SaslAuthenticateResponse) = *c.session.Authenticate(r)
+	}
+	return nil
+}
+
+func testServer(t *testing.T) (*Server, *Credentials) {
+	t.Helper()
+
+	credentials := NewCredentials()
+	credentials.SetPassword("alice", "secret")
+	salt := []byte("salt")
+	res := credentials.AlterUserScramCredentials(&alteruserscramcredentials.AlterUserScramCredentialsRequest{Upsertions: &[]alteruserscramcredentials.AlterUserScramCredentialsRequestUpsertion{
+		{Name: str("alice"), Mechanism: int8(ScramSha256), Iterations: 4096, Salt: &salt, SaltedPassword: ptr(ScramSha256.SaltedPassword([]byte("secret"), salt, 4096))},
+		{Name: str("alice"), Mechanism: int8(ScramSha512), Iterations: 8192, Salt: &salt, SaltedPassword: ptr(ScramSha512.SaltedPassword([]byte("secret"), salt, 8192))},
+	}})
+	if len(*res.Results) != 1 || (*res.Results)[0].ErrorCode != 0 {
+		t.Fatalf("unexpected results %+v", *res.Results)
+	}
+
+	expiry := time.Now().Add(time.Hour)
+	server := NewServer(
+		NewPlainServer(credentials),
+		NewScramServer(ScramSha256, credentials),
+		NewScramServer(ScramSha512, credentials),
+		NewOAuthBearerServer(func(token string, extensions map[string]string) (Identity, error) {
+			principal, ok := strings.CutPrefix(token, "token-")
+			if !ok {
+				return Identity{}, ErrInvalidCredentials
+			}
+			return Identity{Principal: principal, Expiry: expiry}, nil
+		}),
+	)
+	return server, credentials
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestServer(t *testing.T) {
+	server, _ := testServer(t)
+	ctx := context.Background()
+	token := func(token string) func(ctx context.Context) (string, error) {
+		return func(ctx context.Context) (string, error) { return token, nil }
+	}
+
+	tests := []struct {
+		mechanism Mechanism
+		principal string
+		expected  error
+	}{
+		{NewPlain("alice", "secret"), "alice", nil},
+		{NewPlain("alice", "wrong"), "", ErrAuthenticationFailed},
+		{&Plain{AuthorizationId: "bob", Username: "alice", Password: "secret"}, "", ErrAuthenticationFailed},
+		{NewScram(ScramSha256, "alice", "secret"), "alice", nil},
+		{NewScram(ScramSha512, "alice", "secret"), "alice", nil},
+		{NewScram(ScramSha256, "alice", "wrong"), "", ErrAuthenticationFailed},
+		{NewScram(ScramSha512, "bob", "secret"), "", ErrAuthenticationFailed},
+		{NewOAuthBearer(token("token-alice")), "alice", nil},
+		{NewOAuthBearer(token("invalid")), "", ErrAuthenticationFailed},
+		{&OAuthBearer{Token: token("token-alice"), AuthorizationId: "bob"}, "", ErrAuthenticationFailed},
+	}
+	for _, test := range tests {
+		session := server.NewSession()
+		_, err := Authenticate(ctx, serverConn{session}, test.mechanism)
+		if !errors.Is(err, test.expected) {
+			t.Errorf("%s: unexpected error %v", test.mechanism.Name(), err)
+		}
+		if identity := session.Identity(); (test.principal == "" && identity != nil) || (test.principal != "" && (identity == nil || identity.Principal != test.principal)) {
+			t.Errorf("%s: unexpected identity %+v", test.mechanism.Name(), identity)
+		}
+	}
+
+	session := server.NewSession()
+	res := session.Handshake(&saslhandshake.SaslHandshakeRequest{ApiVersion: 1, Mechanism: str("GSSAPI")})
+	if res.ErrorCode != errorcodes.UnsupportedSaslMechanism || len(*res.Mechanisms) != 4 {
+		t.Errorf("unexpected handshake %+v", res)
+	}
+	if res := session.Authenticate(&saslauthenticate.SaslAuthenticateRequest{AuthBytes: &[]byte{}}); res.ErrorCode != errorcodes.IllegalSaslState || res.AuthBytes == nil {
+		t.Errorf("unexpected response %+v", res)
+	}
+}
+
+func TestServerSessionLifetime(t *testing.T) {
+	server, _ := testServer(t)
+	now := time.Now()
+	server.now = func() time.Time { return now }
+	server.MaxReauth = 2 * time.Hour
+	ctx := context.Background()
+
+	// The session of a token ends with the token.
+	session := server.NewSession()
+	oauth := NewOAuthBearer(func(ctx context.Context) (string, error) { return "token-alice", nil })
+	s, err := Authenticate(ctx, serverConn{session}, oauth)
+	if err != nil || s.Lifetime > time.Hour || s.Lifetime < 59*time.Minute {
+		t.Fatalf("unexpected session %+v (%v)", s, err)
+	}
+
+	var kafkaErr *errorcodes.Error
+	if _, err = Authenticate(ctx, serverConn{session}, NewPlain("alice", "secret")); !errors.As(err, &kafkaErr) || kafkaErr.Code != errorcodes.IllegalSaslState {
+		t.Errorf("unexpected error %v when changing the mechanism during re-authentication", err)
+	}
+
+	session = server.NewSession()
+	if s, err = Authenticate(ctx, serverConn{session}, NewPlain("alice", "secret")); err != nil || s.Lifetime != 2*time.Hour {
+		t.Fatalf("unexpected session %+v (%v)", s, err)
+	}
+	now = now.Add(time.Hour)
+	if session.Expired() {
+		t.Errorf("session expired early")
+	}
+	if s, err = Authenticate(ctx, serverConn{session}, NewPlain("alice", "secret")); err != nil || s.Lifetime != 2*time.Hour {
+		t.Fatalf("unexpected session %+v (%v)", s, err)
+	}
+	server.Mechanisms[0].(*PlainServer).Store.(*Credentials).SetPassword("bob", "secret")
+	if _, err = Authenticate(ctx, serverConn{session}, NewPlain("bob", "secret")); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("unexpected error %v", err)
+	}
+	now = now.Add(2 * time.Hour)
+	if !session.Expired() || session.Identity().Principal != "alice" {
+		t.Errorf("session did not expire")
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	_, credentials := testServer(t)
+	salt := []byte("salt")
+
+	res := credentials.AlterUserScramCredentials(&alteruserscramcredentials.AlterUserScramCredentialsRequest{
+		Deletions: &[]alteruserscramcredentials.AlterUserScramCredentialsRequestDeletion{
+			{Name: str("alice"), Mechanism: int8(ScramSha512)},
+			{Name: str("bob"), Mechanism: int8(ScramSha256)},
+		},
+		Upsertions: &[]alteruserscramcredentials.AlterUserScramCredentialsRequestUpsertion{
+			{Name: str("carol"), Mechanism: int8(ScramSha256), Iterations: 1000, Salt: &salt, SaltedPassword: &salt},
+			{Name: str("dave"), Mechanism: 3, Iterations: 4096, Salt: &salt, SaltedPassword: &salt},
+			{Name: str("erin"), Mechanism: int8(ScramSha256), Iterations: 4096, Salt: &salt, SaltedPassword: &salt},
+			{Name: str("erin"), Mechanism: int8(ScramSha256), Iterations: 4096, Salt: &salt, SaltedPassword: &salt},
+		},
+	})
+	expected := map[string]int16{
+		"alice": 0,
+		"bob":   errorcodes.ResourceNotFound,
+		"carol": errorcodes.UnacceptableCredential,
+		"dave":  errorcodes.UnsupportedSaslMechanism,
+		"erin":  errorcodes.DuplicateResource,
+	}
+	if len(*res.Results) != len(expected) {
+		t.Fatalf("unexpected results %+v", *res.Results)
+	}
+	for _, r := range *res.Results {
+		if r.ErrorCode != expected[*r.User] {
+			t.Errorf("unexpected result %+v of %s", r, *r.User)
+		}
+	}
+
+	described := credentials.DescribeUserScramCredentials(&describeuserscramcredentials.DescribeUserScramCredentialsRequest{})
+	if len(*described.Results) != 1 || *(*described.Results)[0].User != "alice" || len(*(*described.Results)[0].CredentialInfos) != 1 ||
+		(*(*described.Results)[0].CredentialInfos)[0].Iterations != 4096 {
+		t.Errorf("unexpected description %+v", *described.Results)
+	}
+	described = credentials.DescribeUserScramCredentials(&describeuserscramcredentials.DescribeUserScramCredentialsRequest{Users: &[]describeuserscramcredentials.DescribeUserScramCredentialsRequestUser{
+		{Name: str("alice")}, {Name: str("erin")},
+	}})
+	if len(*described.Results) != 2 || (*described.Results)[0].ErrorCode != 0 || (*described.Results)[1].ErrorCode != errorcodes.ResourceNotFound {
+		t.Errorf("unexpected description %+v", *described.Results)
+	}
+}
+
+func TestScramUpsertion(t *testing.T) {
+	upsertion, err := ScramUpsertion(ScramSha256, "alice", "secret", []byte("salt"), 0)
+	if err != nil || *upsertion.Name != "alice" || upsertion.Mechanism != 1 || upsertion.Iterations != DefaultScramIterations ||
+		!bytes.Equal(*upsertion.SaltedPassword, ScramSha256.SaltedPassword([]byte("secret"), []byte("salt"), 4096)) {
+		t.Fatalf("unexpected upsertion %+v (%v)", upsertion, err)
+	}
+	if !CheckScramPassword(upsertion, "secret") || CheckScramPassword(upsertion, "wrong") {
+		t.Errorf("unexpected password check")
+	}
+
+	random, err := NewScramUpsertion(ScramSha512, "bob", "secret", 8192)
+	if err != nil || len(*random.Salt) != 32 || len(*random.SaltedPassword) != 64 || !CheckScramPassword(random, "secret") {
+		t.Fatalf("unexpected upsertion %+v (%v)", random, err)
+	}
+	for _, iterations := range []int{1024, 20000} {
+		if _, err := NewScramUpsertion(ScramSha256, "bob", "secret", iterations); !errors.Is(err, ErrUnacceptableCredential) {
+			t.Errorf("unexpected error %v with %d iterations", err, iterations)
+		}
+	}
+	if _, err := NewScramUpsertion(3, "bob", "secret", 0); !errors.Is(err, ErrUnacceptableCredential) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// The store of the server takes the upsertions, and the users authenticate with their passwords.
+	server, credentials := testServer(t)
+	upsertions := []alteruserscramcredentials.AlterUserScramCredentialsRequestUpsertion{upsertion, random}
+	credentials.AlterUserScramCredentials(&alteruserscramcredentials.AlterUserScramCredentialsRequest{Upsertions: &upsertions})
+	described := credentials.DescribeUserScramCredentials(&describeuserscramcredentials.DescribeUserScramCredentialsRequest{})
+	if err := CheckScramUpsertions(described, upsertions); err != nil {
+		t.Errorf("CheckScramUpsertions: %v", err)
+	}
+	if _, err := Authenticate(context.Background(), serverConn{server.NewSession()}, NewScram(ScramSha512, "bob", "secret")); err != nil {
+		t.Errorf("Authenticate: %v", err)
+	}
+
+	other, _ := ScramUpsertion(ScramSha256, "bob", "secret", []byte("salt"), 8192)
+	upsertions = append(upsertions, other)
+	upsertions[1].Iterations = 4096
+	if err := CheckScramUpsertions(described, upsertions); !errors.Is(err, ErrCredentialMismatch) || strings.Count(err.Error(), "\n") != 1 {
+		t.Errorf("unexpected error %v", err)
+	}
+	other.Name = str("carol")
+	if err := CheckScramUpsertions(described, []alteruserscramcredentials.AlterUserScramCredentialsRequestUpsertion{other}); !errors.Is(err, ErrCredentialMismatch) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestDelegationTokens(t *testing.T) {
+	expected, _ := hex.DecodeString("a4866aa82ef1dbfa87a90605b24e7a19089ccc9e2f11127be20836468efd194878748391e31f4958868b7d320ac0a540e4bdfa6c130632faa9ca827047d3884f")
+	if !bytes.Equal(DelegationTokenHmac([]byte("secret"), "token"), expected) ||
+		!CheckDelegationTokenHmac([]byte("secret"), "token", expected) || CheckDelegationTokenHmac([]byte("other"), "token", expected) {
+		t.Fatalf("unexpected HMAC %x", DelegationTokenHmac([]byte("secret"), "token"))
+	}
+
+	now := time.UnixMilli(1700000000000)
+	tokens := NewDelegationTokens([]byte("secret"))
+	tokens.now = func() time.Time { return now }
+	alice, bob, carol := &Identity{Principal: "alice"}, &Identity{Principal: "bob"}, &Identity{Principal: "carol"}
+
+	created := tokens.CreateDelegationToken(alice, &createdelegationtoken.CreateDelegationTokenRequest{ApiVersion: 3, MaxLifetimeMs: (48 * time.Hour).Milliseconds(),
+		Renewers: &[]createdelegationtoken.CreateDelegationTokenRequestRenewer{{PrincipalType: str("User"), PrincipalName: str("bob")}}})
+	if created.ErrorCode != 0 || *created.PrincipalName != "alice" || *created.TokenRequesterPrincipalType != "User" ||
+		created.ExpiryTimestampMs != now.Add(24*time.Hour).UnixMilli() || created.MaxTimestampMs != now.Add(48*time.Hour).UnixMilli() ||
+		!CheckDelegationTokenHmac([]byte("secret"), *created.TokenId, *created.Hmac) {
+		t.Fatalf("unexpected response %+v", created)
+	}
+	token := DelegationToken{TokenId: *created.TokenId, Hmac: *created.Hmac}
+
+	for _, test := range []struct {
+		identity *Identity
+		renewer  string
+		code     int16
+	}{
+		{nil, "User", errorcodes.DelegationTokenRequestNotAllowed},
+		{&Identity{Principal: "alice", Extensions: map[string]string{"tokenauth": "true"}}, "User", errorcodes.DelegationTokenRequestNotAllowed},
+		{alice, "Group", errorcodes.InvalidPrincipalType},
+	} {
+		res := tokens.CreateDelegationToken(test.identity, &createdelegationtoken.CreateDelegationTokenRequest{ApiVersion: 3, MaxLifetimeMs: -1,
+			Renewers: &[]createdelegationtoken.CreateDelegationTokenRequestRenewer{{PrincipalType: str(test.renewer), PrincipalName: str("bob")}}})
+		if res.ErrorCode != test.code || *res.TokenId != "" || *res.PrincipalType != "" || *res.PrincipalName != "" || *res.TokenRequesterPrincipalName != "" {
+			t.Errorf("unexpected response %+v, expected %d", res, test.code)
+		}
+	}
+
+	// Clients authenticate with the token as its owner, until the token expires.
+	server := NewServer(NewScramServer(ScramSha256, NewCredentials()), &ScramServer{Mechanism: ScramSha512, Store: NewCredentials(), Tokens: tokens})
+	server.now = tokens.now
+	session := server.NewSession()
+	if _, err := Authenticate(context.Background(), serverConn{session}, token.Scram(ScramSha512)); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if identity := session.Identity(); identity.Principal != "alice" || !identity.Expiry.Equal(now.Add(24*time.Hour)) {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+	if res := tokens.CreateDelegationToken(session.Identity(), &createdelegationtoken.CreateDelegationTokenRequest{ApiVersion: 3, MaxLifetimeMs: -1}); res.ErrorCode != errorcodes.DelegationTokenRequestNotAllowed {
+		t.Errorf("unexpected error %d", res.ErrorCode)
+	}
+	wrong := DelegationToken{TokenId: token.TokenId, Hmac: DelegationTokenHmac([]byte("other"), token.TokenId)}
+	for _, mechanism := range []Mechanism{wrong.Scram(ScramSha512), token.Scram(ScramSha256), NewScram(ScramSha512, token.TokenId, token.Password())} {
+		if _, err := Authenticate(context.Background(), serverConn{server.NewSession()}, mechanism); !errors.Is(err, ErrAuthenticationFailed) {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+
+	renew := func(identity *Identity, period time.Duration) *renewdelegationtoken.RenewDelegationTokenResponse {
+		return tokens.RenewDelegationToken(identity, &renewdelegationtoken.RenewDelegationTokenRequest{Hmac: &token.Hmac, RenewPeriodMs: period.Milliseconds()})
+	}
+	if res := renew(carol, time.Hour); res.ErrorCode != errorcodes.DelegationTokenOwnerMismatch {
+		t.Errorf("unexpected response %+v", res)
+	}
+	if res := renew(bob, 36*time.Hour); res.ErrorCode != 0 || res.ExpiryTimestampMs != now.Add(36*time.Hour).UnixMilli() {
+		t.Errorf("unexpected response %+v", res)
+	}
+	now = now.Add(20 * time.Hour)
+	if res := renew(alice, -time.Millisecond); res.ErrorCode != 0 || res.ExpiryTimestampMs != now.Add(24*time.Hour).UnixMilli() {
+		t.Errorf("unexpected response %+v", res)
+	}
+	if res := renew(alice, 72*time.Hour); res.ErrorCode != 0 || res.ExpiryTimestampMs != created.MaxTimestampMs {
+		t.Errorf("unexpected response %+v", res)
+	}
+	expired := tokens.ExpireDelegationToken(bob, &expiredelegationtoken.ExpireDelegationTokenRequest{Hmac: &token.Hmac, ExpiryTimePeriodMs: time.Hour.Milliseconds()})
+	if expired.ErrorCode != 0 || expired.ExpiryTimestampMs != now.Add(time.Hour).UnixMilli() {
+		t.Errorf("unexpected response %+v", expired)
+	}
+	now = now.Add(2 * time.Hour)
+	if res := renew(alice, time.Hour); res.ErrorCode != errorcodes.DelegationTokenExpired {
+		t.Errorf("unexpected response %+v", res)
+	}
+	if _, err := Authenticate(context.Background(), serverConn{server.NewSession()}, token.Scram(ScramSha512)); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	other := tokens.CreateDelegationToken(carol, &createdelegationtoken.CreateDelegationTokenRequest{ApiVersion: 3, MaxLifetimeMs: -1,
+		OwnerPrincipalType: str("User"), OwnerPrincipalName: str("dave")})
+	if other.ErrorCode != 0 || *other.PrincipalName != "dave" || *other.TokenRequesterPrincipalName != "carol" {
+		t.Fatalf("unexpected response %+v", other)
+	}
+	// The requester may renew and expire the token it created for another owner.
+	if res := tokens.RenewDelegationToken(carol, &renewdelegationtoken.RenewDelegationTokenRequest{Hmac: other.Hmac, RenewPeriodMs: -1}); res.ErrorCode != 0 {
+		t.Errorf("unexpected response %+v", res)
+	}
+	if res := tokens.RenewDelegationToken(bob, &renewdelegationtoken.RenewDelegationTokenRequest{Hmac: other.Hmac, RenewPeriodMs: -1}); res.ErrorCode != errorcodes.DelegationTokenOwnerMismatch {
+		t.Errorf("unexpected response %+v", res)
+	}
+	for _, test := range []struct {
+		owners   *[]describedelegationtoken.DescribeDelegationTokenRequestOwner
+		expected []string
+	}{
+		{nil, []string{token.TokenId, *other.TokenId}},
+		{&[]describedelegationtoken.DescribeDelegationTokenRequestOwner{{PrincipalType: str("User"), PrincipalName: str("bob")}}, []string{token.TokenId}},
+		{&[]describedelegationtoken.DescribeDelegationTokenRequestOwner{{PrincipalType: str("User"), PrincipalName: str("dave")}}, []string{*other.TokenId}},
+		{&[]describedelegationtoken.DescribeDelegationTokenRequestOwner{}, nil},
+	} {
+		res := tokens.DescribeDelegationToken(carol, &describedelegationtoken.DescribeDelegationTokenRequest{Owners: test.owners})
+		var ids []string
+		for _, described := range *res.Tokens {
+			ids = append(ids, *described.TokenId)
+		}
+		if res.ErrorCode != 0 || !slices.Equal(ids, test.expected) {
+			t.Errorf("unexpected tokens %v, expected %v", ids, test.expected)
+		}
+	}
+
+	expired = tokens.ExpireDelegationToken(alice, &expiredelegationtoken.ExpireDelegationTokenRequest{Hmac: &token.Hmac, ExpiryTimePeriodMs: -1})
+	if expired.ErrorCode != 0 || expired.ExpiryTimestampMs != now.UnixMilli() {
+		t.Errorf("unexpected response %+v", expired)
+	}
+	if res := renew(alice, time.Hour); res.ErrorCode != errorcodes.DelegationTokenNotFound {
+		t.Errorf("unexpected response %+v", res)
+	}
+}
@@ -0,0 +1,432 @@
+package sasl
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScramMechanism is a SCRAM mechanism with the type used by AlterUserScramCredentials and
+// DescribeUserScramCredentials.
+type ScramMechanism int8
+
+const (
+	ScramSha256 ScramMechanism = 1
+	ScramSha512 ScramMechanism = 2
+)
+
+// Iteration limits of Kafka for SCRAM credentials.
+const (
+	MinScramIterations = 4096
+	MaxScramIterations = 16384
+)
+
+func (m ScramMechanism) String() string {
+	switch m {
+	case ScramSha256:
+		return "SCRAM-SHA-256"
+	case ScramSha512:
+		return "SCRAM-SHA-512"
+	default:
+		return fmt.Sprintf("SCRAM-UNKNOWN(%d)", int8(m))
+	}
+}
+
+// ScramMechanismByName returns the SCRAM mechanism with the name, like SCRAM-SHA-256.
+func ScramMechanismByName(name string) (ScramMechanism, bool) {
+	for _, m := range []ScramMechanism{ScramSha256, ScramSha512} {
+		if m.String() == name {
+			return m, true
+		}
+	}
+	return 0, false
+}
+
+func (m ScramMechanism) hash() func() hash.Hash {
+	if m == ScramSha512 {
+		return sha512.New
+	}
+	return sha256.New
+}
+
+func (m ScramMechanism) hmac(key []byte, data []byte) []byte {
+	mac := hmac.New(m.hash(), key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// SaltedPassword returns Hi(password, salt, iterations) of RFC 5802, which is PBKDF2 with the HMAC of
+// the mechanism and one block of output.
+func (m ScramMechanism) SaltedPassword(password []byte, salt []byte, iterations int) []byte {
+	mac := hmac.New(m.hash(), password)
+	mac.Write(salt)
+	mac.Write(binary.BigEndian.AppendUint32(nil, 1))
+	u := mac.Sum(nil)
+	result := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
+
+// ClientKey returns HMAC(SaltedPassword, "Client Key").
+func (m ScramMechanism) ClientKey(saltedPassword []byte) []byte {
+	return m.hmac(saltedPassword, []byte("Client Key"))
+}
+
+// StoredKey returns H(ClientKey), which the server stores instead of the client key.
+func (m ScramMechanism) StoredKey(clientKey []byte) []byte {
+	h := m.hash()()
+	h.Write(clientKey)
+	return h.Sum(nil)
+}
+
+// ServerKey returns HMAC(SaltedPassword, "Server Key").
+func (m ScramMechanism) ServerKey(saltedPassword []byte) []byte {
+	return m.hmac(saltedPassword, []byte("Server Key"))
+}
+
+// Signature returns HMAC(key, authMessage), the client signature with the stored key and the server
+// signature with the server key.
+func (m ScramMechanism) Signature(key []byte, authMessage string) []byte {
+	return m.hmac(key, []byte(authMessage))
+}
+
+// Scram is the SCRAM-SHA-256 or SCRAM-SHA-512 mechanism (RFC 5802 and RFC 7677). The server is
+// authenticated as well, by its signature in the final server message.
+type Scram struct {
+	Mechanism  ScramMechanism
+	Username   string
+	Password   string
+	Extensions map[string]string // Sent in the first client message, like tokenauth for delegation tokens.
+
+	nonce func() (string, error)
+}
+
+func NewScram(mechanism ScramMechanism, username string, password string) *Scram {
+	return &Scram{Mechanism: mechanism, Username: username, Password: password}
+}
+
+func (s *Scram) Name() string {
+	return s.Mechanism.String()
+}
+
+// Start returns the first client message with the user name and a new nonce.
+func (s *Scram) Start(ctx context.Context) (Exchange, []byte, error) {
+	nonceFunc := s.nonce
+	if nonceFunc == nil {
+		nonceFunc = ScramNonce
+	}
+	nonce, err := nonceFunc()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var first strings.Builder
+	first.WriteString("n=" + EscapeScramName(s.Username) + ",r=" + nonce)
+	keys := make([]string, 0, len(s.Extensions))
+	for key := range s.Extensions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		first.WriteString("," + key + "=" + s.Extensions[key])
+	}
+
+	exchange := &scramExchange{scram: s, nonce: nonce, clientFirstBare: first.String()}
+	return exchange, []byte(scramGs2Header + exchange.clientFirstBare), nil
+}
+
+// The GS2 header of Kafka clients: no channel binding and no authorization id.
+const scramGs2Header = "n,,"
+
+type scramExchange struct {
+	scram           *Scram
+	nonce           string
+	clientFirstBare string
+	serverSignature []byte
+}
+
+// Next answers the first server message with the client proof, and verifies the server signature of
+// the final server message.
+func (e *scramExchange) Next(challenge []byte) ([]byte, bool, error) {
+	attributes, err := ParseScramAttributes(string(challenge))
+	if err != nil {
+		return nil, false, err
+	}
+	if message, ok := attributes["e"]; ok {
+		return nil, false, fmt.Errorf("%w: %s", ErrInvalidMessage, message)
+	}
+	if e.serverSignature != nil {
+		return nil, true, e.verify(attributes)
+	}
+
+	m := e.scram.Mechanism
+	nonce, salt, iterations, err := e.parseServerFirst(attributes)
+	if err != nil {
+		return nil, false, err
+	}
+	saltedPassword := m.SaltedPassword([]byte(e.scram.Password), salt, iterations)
+	clientFinal := "c=" + base64.StdEncoding.EncodeToString([]byte(scramGs2Header)) + ",r=" + nonce
+	authMessage := e.clientFirstBare + "," + string(challenge) + "," + clientFinal
+
+	clientKey := m.ClientKey(saltedPassword)
+	proof := m.Signature(m.StoredKey(clientKey), authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	e.serverSignature = m.Signature(m.ServerKey(saltedPassword), authMessage)
+	return []byte(clientFinal + ",p=" + base64.StdEncoding.EncodeToString(proof)), false, nil
+}
+
+func (e *scramExchange) parseServerFirst(attributes map[string]string) (string, []byte, int, error) {
+	if _, ok := attributes["m"]; ok {
+		return "", nil, 0, fmt.Errorf("%w: unsupported mandatory extension", ErrInvalidMessage)
+	}
+	nonce := attributes["r"]
+	if !strings.HasPrefix(nonce, e.nonce) || len(nonce) == len(e.nonce) {
+		return "", nil, 0, fmt.Errorf("%w: the server nonce does not extend the client nonce", ErrInvalidMessage)
+	}
+	salt, err := base64.StdEncoding.DecodeString(attributes["s"])
+	if err != nil || len(salt) == 0 {
+		return "", nil, 0, fmt.Errorf("%w: invalid salt", ErrInvalidMessage)
+	}
+	iterations, err := strconv.Atoi(attributes["i"])
+	if err != nil || iterations < MinScramIterations {
+		return "", nil, 0, fmt.Errorf("%w: invalid iterations %q", ErrInvalidMessage, attributes["i"])
+	}
+	return nonce, salt, iterations, nil
+}
+
+func (e *scramExchange) verify(attributes map[string]string) error {
+	signature, err := base64.StdEncoding.DecodeString(attributes["v"])
+	if err != nil {
+		return fmt.Errorf("%w: invalid verifier", ErrInvalidMessage)
+	}
+	if subtle.ConstantTimeCompare(signature, e.serverSignature) != 1 {
+		return ErrInvalidServerProof
+	}
+	return nil
+}
+
+// ScramNonce returns a random nonce of printable characters without commas.
+func ScramNonce() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(b), nil
+}
+
+// EscapeScramName escapes = and , in a user name.
+func EscapeScramName(name string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(name)
+}
+
+// UnescapeScramName reverses EscapeScramName.
+func UnescapeScramName(name string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == ',' {
+			return "", fmt.Errorf("%w: unescaped comma in name", ErrInvalidMessage)
+		}
+		if name[i] != '=' {
+			b.WriteByte(name[i])
+			continue
+		}
+		switch {
+		case strings.HasPrefix(name[i:], "=3D"):
+			b.WriteByte('=')
+		case strings.HasPrefix(name[i:], "=2C"):
+			b.WriteByte(',')
+		default:
+			return "", fmt.Errorf("%w: invalid escape in name", ErrInvalidMessage)
+		}
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// ParseScramAttributes parses the comma separated attributes of a SCRAM message, like r=nonce.
+func ParseScramAttributes(message string) (map[string]string, error) {
+	attributes := make(map[string]string)
+	for _, attribute := range strings.Split(message, ",") {
+		key, value, ok := strings.Cut(attribute, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%w: invalid attribute %q", ErrInvalidMessage, attribute)
+		}
+		attributes[key] = value
+	}
+	return attributes, nil
+}
+
+// ScramServer is the server of SCRAM-SHA-256 or SCRAM-SHA-512 with the credentials of a store. Clients
+// with the tokenauth extension are authenticated with the delegation tokens of Tokens instead, as the
+// owners of the tokens until the tokens expire.
+type ScramServer struct {
+	Mechanism ScramMechanism
+	Store     ScramStore
+	Tokens    TokenStore
+
+	nonce func() (string, error)
+}
+
+func NewScramServer(mechanism ScramMechanism, store ScramStore) *ScramServer {
+	return &ScramServer{Mechanism: mechanism, Store: store}
+}
+
+func (s *ScramServer) Name() string {
+	return s.Mechanism.String()
+}
+
+func (s *ScramServer) Start() ServerExchange {
+	return &scramServerExchange{server: s}
+}
+
+type scramServerExchange struct {
+	server          *ScramServer
+	gs2Header       string
+	clientFirstBare string
+	serverFirst     string
+	nonce           string
+	username        string
+	extensions      map[string]string
+	credential      ScramCredential
+	principal       string
+	expiry          time.Time
+}
+
+// Next answers the first client message with the salt, the iterations and the nonce of the server, and
+// verifies the proof of the final client message.
+func (e *scramServerExchange) Next(message []byte) ([]byte, *Identity, error) {
+	if e.serverFirst == "" {
+		challenge, err := e.first(string(message))
+		return challenge, nil, err
+	}
+	return e.final(string(message))
+}
+
+func (e *scramServerExchange) first(message string) ([]byte, error) {
+	parts := strings.SplitN(message, ",", 3)
+	if len(parts) != 3 || (parts[0] != "n" && parts[0] != "y") {
+		return nil, fmt.Errorf("%w: invalid GS2 header, channel binding is not supported", ErrInvalidMessage)
+	}
+	e.gs2Header, e.clientFirstBare = parts[0]+","+parts[1]+",", parts[2]
+
+	attributes, err := ParseScramAttributes(e.clientFirstBare)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(e.clientFirstBare, "n=") || attributes["r"] == "" {
+		return nil, fmt.Errorf("%w: expected user name and nonce", ErrInvalidMessage)
+	}
+	if _, ok := attributes["m"]; ok {
+		return nil, fmt.Errorf("%w: unsupported mandatory extension", ErrInvalidMessage)
+	}
+	if e.username, err = UnescapeScramName(attributes["n"]); err != nil {
+		return nil, err
+	}
+	if parts[1] != "" {
+		authorizationId, err := UnescapeScramName(strings.TrimPrefix(parts[1], "a="))
+		if err != nil || !strings.HasPrefix(parts[1], "a=") || authorizationId != e.username {
+			return nil, fmt.Errorf("%w: the authorization id is not the user name", ErrInvalidCredentials)
+		}
+	}
+	e.extensions = make(map[string]string)
+	for key, value := range attributes {
+		if key != "n" && key != "r" {
+			e.extensions[key] = value
+		}
+	}
+
+	if err := e.lookup(); err != nil {
+		return nil, err
+	}
+	nonceFunc := e.server.nonce
+	if nonceFunc == nil {
+		nonceFunc = ScramNonce
+	}
+	serverNonce, err := nonceFunc()
+	if err != nil {
+		return nil, err
+	}
+	e.nonce = attributes["r"] + serverNonce
+	e.serverFirst = "r=" + e.nonce + ",s=" + base64.StdEncoding.EncodeToString(e.credential.Salt) + ",i=" + strconv.Itoa(e.credential.Iterations)
+	return []byte(e.serverFirst), nil
+}
+
+// lookup looks up the credential of the user, or of the delegation token with tokenauth.
+func (e *scramServerExchange) lookup() error {
+	if !tokenAuthenticated(e.extensions) {
+		var ok bool
+		if e.credential, ok = e.server.Store.ScramCredential(e.server.Mechanism, e.username); !ok {
+			return fmt.Errorf("%w: unknown user", ErrInvalidCredentials)
+		}
+		e.principal = e.username
+		return nil
+	}
+
+	if e.server.Tokens == nil {
+		return fmt.Errorf("%w: delegation tokens are not supported", ErrInvalidCredentials)
+	}
+	credential, token, ok := e.server.Tokens.TokenCredential(e.server.Mechanism, e.username)
+	if !ok {
+		return fmt.Errorf("%w: unknown delegation token", ErrInvalidCredentials)
+	}
+	_, owner, _ := strings.Cut(token.Owner, ":")
+	e.credential, e.principal, e.expiry = credential, owner, token.ExpiryTime
+	return nil
+}
+
+func (e *scramServerExchange) final(message string) ([]byte, *Identity, error) {
+	attributes, err := ParseScramAttributes(message)
+	if err != nil {
+		return nil, nil, err
+	}
+	withoutProof, _, ok := strings.Cut(message, ",p=")
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: missing proof", ErrInvalidMessage)
+	}
+	if attributes["c"] != base64.StdEncoding.EncodeToString([]byte(e.gs2Header)) {
+		return nil, nil, fmt.Errorf("%w: invalid channel binding", ErrInvalidMessage)
+	}
+	if attributes["r"] != e.nonce {
+		return nil, nil, fmt.Errorf("%w: invalid nonce", ErrInvalidMessage)
+	}
+	proof, err := base64.StdEncoding.DecodeString(attributes["p"])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid proof", ErrInvalidMessage)
+	}
+
+	m := e.server.Mechanism
+	authMessage := e.clientFirstBare + "," + e.serverFirst + "," + withoutProof
+	clientKey := m.Signature(e.credential.StoredKey, authMessage)
+	if len(proof) != len(clientKey) {
+		return nil, nil, fmt.Errorf("%w: invalid proof", ErrInvalidCredentials)
+	}
+	for i := range clientKey {
+		clientKey[i] ^= proof[i]
+	}
+	if subtle.ConstantTimeCompare(m.StoredKey(clientKey), e.credential.StoredKey) != 1 {
+		return nil, nil, fmt.Errorf("%w: invalid proof", ErrInvalidCredentials)
+	}
+
+	serverFinal := "v=" + base64.StdEncoding.EncodeToString(m.Signature(e.credential.ServerKey, authMessage))
+	return []byte(serverFinal), &Identity{Principal: e.principal, Expiry: e.expiry, Extensions: e.extensions}, nil
+}
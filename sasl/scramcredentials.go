@@ -0,0 +1,140 @@
+package sasl
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"github.com/scholzj/go-kafka-protocol/api/alteruserscramcredentials"
+	"github.com/scholzj/go-kafka-protocol/api/describeuserscramcredentials"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+// DefaultScramIterations is the number of iterations used by the Kafka tools.
+const DefaultScramIterations = 4096
+
+// The length of the random salts of new credentials.
+const scramSaltLength = 32
+
+var (
+	ErrUnacceptableCredential = errors.New("unacceptable SCRAM credential")
+	ErrCredentialMismatch     = errors.New("the described SCRAM credentials do not match")
+)
+
+// ScramCredentialInfo describes a SCRAM credential without its secrets, like
+// DescribeUserScramCredentials.
+type ScramCredentialInfo struct {
+	Mechanism  ScramMechanism
+	Iterations int
+}
+
+// NewScramUpsertion returns the AlterUserScramCredentials upsertion which sets the password of the user
+// for the mechanism, salted with a new random salt. Iterations of 0 use DefaultScramIterations.
+func NewScramUpsertion(mechanism ScramMechanism, username string, password string, iterations int) (alteruserscramcredentials.AlterUserScramCredentialsRequestUpsertion, error) {
+	salt := make([]byte, scramSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return alteruserscramcredentials.AlterUserScramCredentialsRequestUpsertion{}, err
+	}
+	return ScramUpsertion(mechanism, username, password, salt, iterations)
+}
+
+// ScramUpsertion returns the AlterUserScramCredentials upsertion which sets the password of the user for
+// the mechanism with the salt. Iterations of 0 use DefaultScramIterations. The credential is checked
+// against the limits of the brokers.
+func ScramUpsertion(mechanism ScramMechanism, username string, password string, salt []byte, iterations int) (alteruserscramcredentials.AlterUserScramCredentialsRequestUpsertion, error) {
+	if iterations == 0 {
+		iterations = DefaultScramIterations
+	}
+	switch {
+	case mechanism != ScramSha256 && mechanism != ScramSha512:
+		return alteruserscramcredentials.AlterUserScramCredentialsRequestUpsertion{}, fmt.Errorf("%w: unknown mechanism %v", ErrUnacceptableCredential, mechanism)
+	case username == "":
+		return alteruserscramcredentials.AlterUserScramCredentialsRequestUpsertion{}, fmt.Errorf("%w: empty user name", ErrUnacceptableCredential)
+	case password == "" || len(salt) == 0:
+		return alteruserscramcredentials.AlterUserScramCredentialsRequestUpsertion{}, fmt.Errorf("%w: empty password or salt", ErrUnacceptableCredential)
+	case iterations < MinScramIterations || iterations > MaxScramIterations:
+		return alteruserscramcredentials.AlterUserScramCredentialsRequestUpsertion{}, fmt.Errorf("%w: iterations must be between %d and %d", ErrUnacceptableCredential, MinScramIterations, MaxScramIterations)
+	}
+
+	salt = append([]byte(nil), salt...)
+	saltedPassword := mechanism.SaltedPassword([]byte(password), salt, iterations)
+	return alteruserscramcredentials.AlterUserScramCredentialsRequestUpsertion{
+		Name:           &username,
+		Mechanism:      int8(mechanism),
+		Iterations:     int32(iterations),
+		Salt:           &salt,
+		SaltedPassword: &saltedPassword,
+	}, nil
+}
+
+// ScramDeletion returns the AlterUserScramCredentials deletion of the credential of the user for the
+// mechanism.
+func ScramDeletion(mechanism ScramMechanism, username string) alteruserscramcredentials.AlterUserScramCredentialsRequestDeletion {
+	return alteruserscramcredentials.AlterUserScramCredentialsRequestDeletion{Name: &username, Mechanism: int8(mechanism)}
+}
+
+// CheckScramPassword reports whether the upsertion sets the password.
+func CheckScramPassword(upsertion alteruserscramcredentials.AlterUserScramCredentialsRequestUpsertion, password string) bool {
+	mechanism := ScramMechanism(upsertion.Mechanism)
+	if mechanism != ScramSha256 && mechanism != ScramSha512 || upsertion.Iterations <= 0 {
+		return false
+	}
+	saltedPassword := mechanism.SaltedPassword([]byte(password), deref(upsertion.Salt), int(upsertion.Iterations))
+	return subtle.ConstantTimeCompare(saltedPassword, deref(upsertion.SaltedPassword)) == 1
+}
+
+// ScramCredentialInfos returns the credentials of the users of a DescribeUserScramCredentials response.
+// Users which failed, like users without credentials, are returned with their error instead.
+func ScramCredentialInfos(res *describeuserscramcredentials.DescribeUserScramCredentialsResponse) (map[string][]ScramCredentialInfo, map[string]error, error) {
+	if err := errorcodes.ToError(res.ErrorCode, res.ErrorMessage); err != nil {
+		return nil, nil, err
+	}
+
+	infos := make(map[string][]ScramCredentialInfo)
+	errs := make(map[string]error)
+	for _, r := range deref(res.Results) {
+		user := derefString(r.User)
+		if err := errorcodes.ToError(r.ErrorCode, r.ErrorMessage); err != nil {
+			errs[user] = err
+			continue
+		}
+		for _, c := range deref(r.CredentialInfos) {
+			infos[user] = append(infos[user], ScramCredentialInfo{Mechanism: ScramMechanism(c.Mechanism), Iterations: int(c.Iterations)})
+		}
+	}
+	return infos, errs, nil
+}
+
+// CheckScramUpsertions checks a DescribeUserScramCredentials response after the upsertions were
+// applied: every user has a credential for the mechanism of its upsertion with its iterations. The
+// error lists all mismatches.
+func CheckScramUpsertions(res *describeuserscramcredentials.DescribeUserScramCredentialsResponse, upsertions []alteruserscramcredentials.AlterUserScramCredentialsRequestUpsertion) error {
+	infos, userErrs, err := ScramCredentialInfos(res)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, u := range upsertions {
+		user, mechanism := derefString(u.Name), ScramMechanism(u.Mechanism)
+		if err, ok := userErrs[user]; ok {
+			errs = append(errs, fmt.Errorf("%w: %s: %w", ErrCredentialMismatch, user, err))
+			continue
+		}
+		found := false
+		for _, info := range infos[user] {
+			if info.Mechanism != mechanism {
+				continue
+			}
+			found = true
+			if info.Iterations != int(u.Iterations) {
+				errs = append(errs, fmt.Errorf("%w: %s has %v with %d iterations, expected %d", ErrCredentialMismatch, user, mechanism, info.Iterations, u.Iterations))
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("%w: %s has no %v credential", ErrCredentialMismatch, user, mechanism))
+		}
+	}
+	return errors.Join(errs...)
+}
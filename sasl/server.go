@@ -0,0 +1,334 @@
+package sasl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scholzj/go-kafka-protocol/api/saslauthenticate"
+	"github.com/scholzj/go-kafka-protocol/api/saslhandshake"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrPrincipalChanged   = errors.New("the principal changed during re-authentication")
+	ErrSessionExpired     = errors.New("the credential expired")
+)
+
+// ServerMechanism is a server SASL mechanism.
+type ServerMechanism interface {
+	Name() string
+	// Start starts the exchange of a client.
+	Start() ServerExchange
+}
+
+// ServerExchange is one authentication exchange of a server mechanism.
+type ServerExchange interface {
+	// Next processes a client message and returns the server message, and the identity of the client
+	// when the exchange completed successfully.
+	Next(message []byte) (challenge []byte, identity *Identity, err error)
+}
+
+// Identity is an authenticated client.
+type Identity struct {
+	Principal  string
+	Expiry     time.Time         // When the credential expires, zero if it does not.
+	Extensions map[string]string // The SASL extensions sent by the client.
+}
+
+// Server authenticates the connections of a mock broker or a proxy with its mechanisms. Every
+// connection has its own ServerSession.
+type Server struct {
+	Mechanisms []ServerMechanism
+	// MaxReauth is the longest session before the client has to authenticate again, like
+	// connections.max.reauth.ms. Sessions do not expire without it.
+	MaxReauth time.Duration
+
+	now func() time.Time
+}
+
+func NewServer(mechanisms ...ServerMechanism) *Server {
+	return &Server{Mechanisms: mechanisms, now: time.Now}
+}
+
+// NewSession returns the session of a new connection.
+func (s *Server) NewSession() *ServerSession {
+	return &ServerSession{server: s}
+}
+
+func (s *Server) names() []string {
+	names := make([]string, 0, len(s.Mechanisms))
+	for _, m := range s.Mechanisms {
+		names = append(names, m.Name())
+	}
+	return names
+}
+
+// ServerSession is the SASL state of one connection. It answers SaslHandshake and SaslAuthenticate
+// requests, including those which authenticate the connection again (KIP-368). ServerSession is safe
+// for concurrent use.
+type ServerSession struct {
+	server *Server
+
+	mu        sync.Mutex
+	mechanism string
+	exchange  ServerExchange
+	identity  *Identity
+	expiresAt time.Time
+}
+
+// Handshake answers a SaslHandshake request. Only version 1 is supported, with which the messages of
+// the mechanism are sent with SaslAuthenticate. A session authenticating again has to keep its
+// mechanism.
+func (s *ServerSession) Handshake(req *saslhandshake.SaslHandshakeRequest) *saslhandshake.SaslHandshakeResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := s.server.names()
+	res := &saslhandshake.SaslHandshakeResponse{ApiVersion: req.ApiVersion, Mechanisms: &names}
+	if req.ApiVersion < 1 {
+		res.ErrorCode = errorcodes.UnsupportedVersion
+		return res
+	}
+
+	name := ""
+	if req.Mechanism != nil {
+		name = *req.Mechanism
+	}
+	for _, m := range s.server.Mechanisms {
+		if m.Name() != name {
+			continue
+		}
+		if s.identity != nil && s.mechanism != name {
+			res.ErrorCode = errorcodes.IllegalSaslState
+			return res
+		}
+		s.mechanism = name
+		s.exchange = m.Start()
+		return res
+	}
+	res.ErrorCode = errorcodes.UnsupportedSaslMechanism
+	return res
+}
+
+// Authenticate answers a SaslAuthenticate request. When the exchange completes, the session lifetime
+// is the shorter of MaxReauth and the time until the credential expires.
+func (s *ServerSession) Authenticate(req *saslauthenticate.SaslAuthenticateRequest) *saslauthenticate.SaslAuthenticateResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := &saslauthenticate.SaslAuthenticateResponse{ApiVersion: req.ApiVersion, AuthBytes: &[]byte{}}
+	fail := func(code int16, err error) *saslauthenticate.SaslAuthenticateResponse {
+		s.exchange = nil
+		message := fmt.Sprintf("Authentication failed during authentication with SASL mechanism %s: %v", s.mechanism, err)
+		res.ErrorCode, res.ErrorMessage = code, &message
+		return res
+	}
+
+	if s.exchange == nil {
+		return fail(errorcodes.IllegalSaslState, errors.New("SaslAuthenticate without SaslHandshake"))
+	}
+	var message []byte
+	if req.AuthBytes != nil {
+		message = *req.AuthBytes
+	}
+	challenge, identity, err := s.exchange.Next(message)
+	if err != nil {
+		return fail(errorcodes.SaslAuthenticationFailed, err)
+	}
+	if challenge != nil {
+		res.AuthBytes = &challenge
+	}
+	if identity == nil {
+		return res
+	}
+
+	s.exchange = nil
+	if s.identity != nil && s.identity.Principal != identity.Principal {
+		return fail(errorcodes.SaslAuthenticationFailed, fmt.Errorf("%w: %s to %s", ErrPrincipalChanged, s.identity.Principal, identity.Principal))
+	}
+	now := s.server.now()
+	var lifetime time.Duration
+	if s.server.MaxReauth > 0 {
+		lifetime = s.server.MaxReauth
+		if !identity.Expiry.IsZero() {
+			lifetime = min(lifetime, identity.Expiry.Sub(now))
+		}
+	}
+	if !identity.Expiry.IsZero() && !now.Before(identity.Expiry) {
+		return fail(errorcodes.SaslAuthenticationFailed, ErrSessionExpired)
+	}
+
+	s.identity = identity
+	s.expiresAt = time.Time{}
+	if lifetime > 0 {
+		s.expiresAt = now.Add(lifetime)
+	}
+	res.SessionLifetimeMs = lifetime.Milliseconds()
+	return res
+}
+
+// Identity returns the authenticated client, or nil before the first authentication completed.
+func (s *ServerSession) Identity() *Identity {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.identity
+}
+
+// Expired returns whether the session expired without authenticating again, after which the
+// connection has to be closed.
+func (s *ServerSession) Expired() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return !s.expiresAt.IsZero() && !s.server.now().Before(s.expiresAt)
+}
+
+////////////////////
+// PLAIN
+////////////////////
+
+// PasswordStore checks the passwords of PLAIN.
+type PasswordStore interface {
+	CheckPassword(username string, password string) bool
+}
+
+// PlainServer is the server of PLAIN.
+type PlainServer struct {
+	Store PasswordStore
+}
+
+func NewPlainServer(store PasswordStore) *PlainServer {
+	return &PlainServer{Store: store}
+}
+
+func (p *PlainServer) Name() string {
+	return "PLAIN"
+}
+
+func (p *PlainServer) Start() ServerExchange {
+	return p
+}
+
+// Next checks the password of the only client message. Like Kafka, the authorization id has to be
+// empty or the user name.
+func (p *PlainServer) Next(message []byte) ([]byte, *Identity, error) {
+	parts := bytes.Split(message, []byte{0})
+	if len(parts) != 3 || len(parts[1]) == 0 || len(parts[2]) == 0 {
+		return nil, nil, fmt.Errorf("%w: expected authorization id, user name and password", ErrInvalidMessage)
+	}
+	authorizationId, username := string(parts[0]), string(parts[1])
+	if authorizationId != "" && authorizationId != username {
+		return nil, nil, fmt.Errorf("%w: the authorization id is not the user name", ErrInvalidCredentials)
+	}
+	if !p.Store.CheckPassword(username, string(parts[2])) {
+		return nil, nil, fmt.Errorf("%w: invalid user name or password", ErrInvalidCredentials)
+	}
+	return nil, &Identity{Principal: username}, nil
+}
+
+////////////////////
+// OAUTHBEARER
+////////////////////
+
+// The error sent to clients with a rejected token before the authentication fails (RFC 7628).
+const oauthInvalidToken = `{"status":"invalid_token"}`
+
+// OAuthBearerServer is the server of OAUTHBEARER. Validate checks the token and returns the identity of
+// its owner, with the expiry of the token.
+type OAuthBearerServer struct {
+	Validate func(token string, extensions map[string]string) (Identity, error)
+}
+
+func NewOAuthBearerServer(validate func(token string, extensions map[string]string) (Identity, error)) *OAuthBearerServer {
+	return &OAuthBearerServer{Validate: validate}
+}
+
+func (o *OAuthBearerServer) Name() string {
+	return "OAUTHBEARER"
+}
+
+func (o *OAuthBearerServer) Start() ServerExchange {
+	return &oauthServerExchange{server: o}
+}
+
+type oauthServerExchange struct {
+	server  *OAuthBearerServer
+	failure error
+}
+
+// Next validates the token of the client message. A rejected token is answered with an error, and
+// the authentication fails when the client acknowledged it.
+func (e *oauthServerExchange) Next(message []byte) ([]byte, *Identity, error) {
+	if e.failure != nil {
+		if string(message) != oauthSeparator {
+			return nil, nil, fmt.Errorf("%w: expected the acknowledgement of the error", ErrInvalidMessage)
+		}
+		return nil, nil, e.failure
+	}
+
+	authorizationId, token, extensions, err := ParseOAuthBearerMessage(message)
+	if err != nil {
+		return nil, nil, err
+	}
+	identity, err := e.server.Validate(token, extensions)
+	if err == nil && authorizationId != "" && authorizationId != identity.Principal {
+		err = fmt.Errorf("%w: the authorization id is not the principal of the token", ErrInvalidCredentials)
+	}
+	if err != nil {
+		e.failure = err
+		return []byte(oauthInvalidToken), nil, nil
+	}
+	identity.Extensions = extensions
+	return nil, &identity, nil
+}
+
+// ParseOAuthBearerMessage parses the client message of OAUTHBEARER into the authorization id, the
+// bearer token and the extensions.
+func ParseOAuthBearerMessage(message []byte) (string, string, map[string]string, error) {
+	gs2, pairs, ok := strings.Cut(string(message), oauthSeparator)
+	if !ok || !strings.HasPrefix(gs2, "n,") || !strings.HasSuffix(gs2, ",") || !strings.HasSuffix(pairs, oauthSeparator+oauthSeparator) {
+		return "", "", nil, fmt.Errorf("%w: invalid OAUTHBEARER message", ErrInvalidMessage)
+	}
+
+	authorizationId := strings.TrimSuffix(strings.TrimPrefix(gs2, "n,"), ",")
+	if authorizationId != "" {
+		if !strings.HasPrefix(authorizationId, "a=") {
+			return "", "", nil, fmt.Errorf("%w: invalid authorization id", ErrInvalidMessage)
+		}
+		var err error
+		if authorizationId, err = UnescapeScramName(authorizationId[2:]); err != nil {
+			return "", "", nil, err
+		}
+	}
+
+	var token string
+	extensions := make(map[string]string)
+	for _, pair := range strings.Split(strings.TrimSuffix(pairs, oauthSeparator+oauthSeparator), oauthSeparator) {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return "", "", nil, fmt.Errorf("%w: invalid key-value pair", ErrInvalidMessage)
+		}
+		if key == "auth" {
+			scheme, bearer, ok := strings.Cut(value, " ")
+			if !ok || !strings.EqualFold(scheme, "Bearer") || bearer == "" {
+				return "", "", nil, fmt.Errorf("%w: invalid authorization", ErrInvalidMessage)
+			}
+			token = bearer
+			continue
+		}
+		if !oauthExtensionKey.MatchString(key) || !oauthExtensionValue.MatchString(value) {
+			return "", "", nil, fmt.Errorf("%w: %q", ErrInvalidExtension, key)
+		}
+		extensions[key] = value
+	}
+	if token == "" {
+		return "", "", nil, fmt.Errorf("%w: missing bearer token", ErrInvalidMessage)
+	}
+	return authorizationId, token, extensions, nil
+}
@@ -0,0 +1,689 @@
+package shareconsumer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/shareacknowledge"
+	"github.com/scholzj/go-kafka-protocol/api/sharefetch"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// AcknowledgeType is the per-offset acknowledgement sent in the AcknowledgeTypes arrays of the
+// ShareFetch and ShareAcknowledge requests.
+type AcknowledgeType int8
+
+const (
+	Gap     AcknowledgeType = 0 // The offset is not a record (for example it was compacted away)
+	Accept  AcknowledgeType = 1 // The record was processed successfully
+	Release AcknowledgeType = 2 // The record should be delivered again, to this or another consumer
+	Reject  AcknowledgeType = 3 // The record cannot be processed and must not be delivered again
+	Renew   AcknowledgeType = 4 // The acquisition lock should be extended (KIP-1222, ShareFetch / ShareAcknowledge v2+)
+)
+
+// DefaultLockTimeout matches the broker default of group.share.record.lock.duration.ms and is used
+// when a ShareFetch response (v0) does not carry AcquisitionLockTimeoutMs.
+const DefaultLockTimeout = 30 * time.Second
+
+var (
+	ErrNotAcquired            = errors.New("offset is not acquired by this consumer")
+	ErrAlreadyAcknowledged    = errors.New("offset has already been acknowledged")
+	ErrAcquisitionLockExpired = errors.New("acquisition lock of the offset has expired")
+	ErrInvalidOffsetRange     = errors.New("invalid acquired offset range")
+)
+
+// TopicPartition identifies a share-fetched partition. Share groups address topics by ID only.
+type TopicPartition struct {
+	TopicId   uuid.UUID
+	Partition int32
+}
+
+func (tp TopicPartition) String() string {
+	return fmt.Sprintf("%s-%d", tp.TopicId, tp.Partition)
+}
+
+// AcknowledgementBatch is a contiguous range of offsets with their acknowledge types. AcknowledgeTypes
+// holds either a single type which applies to the whole range or one type per offset.
+type AcknowledgementBatch struct {
+	FirstOffset      int64
+	LastOffset       int64
+	AcknowledgeTypes []int8
+}
+
+// OffsetRange is an inclusive range of offsets.
+type OffsetRange struct {
+	First int64
+	Last  int64
+}
+
+// MaxAcquiredRange is the most offsets Acquire accepts in one range, the most offsets one record batch
+// can span. Brokers acquire much fewer records of a partition at a time.
+const MaxAcquiredRange = math.MaxInt32
+
+// acquiredRange is a contiguous range of acquired offsets which share their delivery count and lock.
+type acquiredRange struct {
+	first         int64
+	last          int64
+	deliveryCount int16
+	lockExpiry    time.Time
+}
+
+// partitionState keeps the acquired offsets of a partition as sorted, non-overlapping ranges, so that
+// its size depends on the number of acquisitions rather than on the number of offsets, and the
+// acknowledge types of the offsets which were acknowledged but not sent yet.
+type partitionState struct {
+	ranges       []acquiredRange
+	acknowledged map[int64]AcknowledgeType
+}
+
+// find returns the index of the range which contains the offset.
+func (p *partitionState) find(offset int64) (int, bool) {
+	i := sort.Search(len(p.ranges), func(i int) bool { return p.ranges[i].last >= offset })
+	return i, i < len(p.ranges) && p.ranges[i].first <= offset
+}
+
+// isolate splits the range which contains the offset so that the offset has a range of its own and
+// returns its index.
+func (p *partitionState) isolate(offset int64) (int, bool) {
+	i, ok := p.find(offset)
+	if !ok {
+		return 0, false
+	}
+
+	r := p.ranges[i]
+	parts := make([]acquiredRange, 0, 3)
+	if r.first < offset {
+		before := r
+		before.last = offset - 1
+		parts = append(parts, before)
+	}
+	single := r
+	single.first, single.last = offset, offset
+	parts = append(parts, single)
+	if offset < r.last {
+		after := r
+		after.first = offset + 1
+		parts = append(parts, after)
+	}
+	p.ranges = slices.Replace(p.ranges, i, i+1, parts...)
+
+	if r.first < offset {
+		i++
+	}
+	return i, true
+}
+
+// remove drops the offset from the acquired ranges.
+func (p *partitionState) remove(offset int64) {
+	if i, ok := p.isolate(offset); ok {
+		p.ranges = slices.Delete(p.ranges, i, i+1)
+	}
+}
+
+func (p *partitionState) empty() bool {
+	return len(p.ranges) == 0 && len(p.acknowledged) == 0
+}
+
+// acknowledgedOffsets returns the sorted acknowledged offsets.
+func (p *partitionState) acknowledgedOffsets() []int64 {
+	offsets := make([]int64, 0, len(p.acknowledged))
+	for offset := range p.acknowledged {
+		offsets = append(offsets, offset)
+	}
+	slices.Sort(offsets)
+	return offsets
+}
+
+// split returns the parts of the range which are not acknowledged and the acknowledged offsets in it,
+// given all sorted acknowledged offsets of the partition. It works on the acknowledged offsets only, so
+// that its cost does not depend on the length of the range.
+func split(r acquiredRange, acknowledged []int64) ([]OffsetRange, []int64) {
+	i := sort.Search(len(acknowledged), func(i int) bool { return acknowledged[i] >= r.first })
+	j := sort.Search(len(acknowledged), func(j int) bool { return acknowledged[j] > r.last })
+	inRange := acknowledged[i:j]
+
+	var free []OffsetRange
+	first := r.first
+	for _, offset := range inRange {
+		if offset > first {
+			free = append(free, OffsetRange{First: first, Last: offset - 1})
+		}
+		if offset == r.last {
+			return free, inRange
+		}
+		first = offset + 1
+	}
+	return append(free, OffsetRange{First: first, Last: r.last}), inRange
+}
+
+// Tracker keeps the records a share consumer has acquired per partition together with their delivery
+// counts and acquisition lock expiry, collects the consumer's acknowledgements and turns them into the
+// acknowledgement batches of the next ShareFetch or ShareAcknowledge request. It is safe for concurrent
+// use.
+type Tracker struct {
+	// Now is the clock used for the acquisition lock expiry. It defaults to time.Now and can be
+	// replaced in tests.
+	Now func() time.Time
+
+	mu          sync.Mutex
+	lockTimeout time.Duration
+	partitions  map[TopicPartition]*partitionState
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		Now:         time.Now,
+		lockTimeout: DefaultLockTimeout,
+		partitions:  make(map[TopicPartition]*partitionState),
+	}
+}
+
+////////////////////
+// Acquiring records
+////////////////////
+
+// AddShareFetchResponse registers the acquired records of every partition in a ShareFetch response
+// which did not fail with a fetch error. The acquired ranges are limited to the offsets of the record
+// batches returned with them, and invalid or too long ranges are ignored. The acquisition lock timeout is taken
+// from the response when it carries one (v1+).
+func (t *Tracker) AddShareFetchResponse(res *sharefetch.ShareFetchResponse) {
+	if res == nil || res.Responses == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if res.ApiVersion >= 1 && res.AcquisitionLockTimeoutMs > 0 {
+		t.lockTimeout = time.Duration(res.AcquisitionLockTimeoutMs) * time.Millisecond
+	}
+	expiry := t.Now().Add(t.lockTimeout)
+
+	for _, topic := range *res.Responses {
+		if topic.Partitions == nil {
+			continue
+		}
+
+		for _, partition := range *topic.Partitions {
+			if partition.ErrorCode != 0 || partition.AcquiredRecords == nil || partition.Records == nil {
+				continue
+			}
+
+			firstReturned, lastReturned, ok := recordsSpan(*partition.Records)
+			if !ok {
+				continue
+			}
+
+			tp := TopicPartition{TopicId: topic.TopicId, Partition: partition.PartitionIndex}
+			for _, acquired := range *partition.AcquiredRecords {
+				firstOffset, lastOffset := max(acquired.FirstOffset, firstReturned), min(acquired.LastOffset, lastReturned)
+				if firstOffset <= lastOffset && lastOffset-firstOffset < MaxAcquiredRange {
+					t.acquire(tp, firstOffset, lastOffset, acquired.DeliveryCount, expiry)
+				}
+			}
+		}
+	}
+}
+
+// recordsSpan returns the first and the last offset of the record batches of a ShareFetch response. A
+// partial batch at the end of the data, which brokers may return, is ignored.
+func recordsSpan(data []byte) (int64, int64, bool) {
+	var first, last int64
+	found := false
+	for len(data) >= lastOffsetDeltaEnd {
+		baseOffset := int64(binary.BigEndian.Uint64(data[0:8]))
+		size := int64(records.LogOverhead) + int64(int32(binary.BigEndian.Uint32(data[8:12])))
+		if size < lastOffsetDeltaEnd || size > int64(len(data)) || int8(data[16]) != records.MagicV2 {
+			break
+		}
+		lastOffsetDelta := int64(int32(binary.BigEndian.Uint32(data[23:lastOffsetDeltaEnd])))
+		if lastOffsetDelta < 0 || baseOffset < 0 || baseOffset > math.MaxInt64-lastOffsetDelta {
+			break
+		}
+
+		if !found || baseOffset < first {
+			first = baseOffset
+		}
+		if !found || baseOffset+lastOffsetDelta > last {
+			last = baseOffset + lastOffsetDelta
+		}
+		found = true
+		data = data[size:]
+	}
+
+	return first, last, found
+}
+
+// The end of the LastOffsetDelta field in the header of a record batch.
+const lastOffsetDeltaEnd = 27
+
+// Acquire registers the inclusive offset range of at most MaxAcquiredRange offsets as acquired with
+// the given delivery count. The lock expires after the lock timeout of the last ShareFetch response (or
+// DefaultLockTimeout).
+func (t *Tracker) Acquire(tp TopicPartition, firstOffset int64, lastOffset int64, deliveryCount int16) error {
+	if firstOffset < 0 || firstOffset > lastOffset || lastOffset-firstOffset >= MaxAcquiredRange {
+		return fmt.Errorf("%w: %s offsets %d to %d", ErrInvalidOffsetRange, tp, firstOffset, lastOffset)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.acquire(tp, firstOffset, lastOffset, deliveryCount, t.Now().Add(t.lockTimeout))
+	return nil
+}
+
+func (t *Tracker) acquire(tp TopicPartition, firstOffset int64, lastOffset int64, deliveryCount int16, expiry time.Time) {
+	state, ok := t.partitions[tp]
+	if !ok {
+		state = &partitionState{acknowledged: make(map[int64]AcknowledgeType)}
+		t.partitions[tp] = state
+	}
+
+	// A re-acquired offset (for example after the lock expired) replaces the old state.
+	ranges := make([]acquiredRange, 0, len(state.ranges)+2)
+	for _, r := range state.ranges {
+		if r.last < firstOffset || r.first > lastOffset {
+			ranges = append(ranges, r)
+			continue
+		}
+		if r.first < firstOffset {
+			before := r
+			before.last = firstOffset - 1
+			ranges = append(ranges, before)
+		}
+		if r.last > lastOffset {
+			after := r
+			after.first = lastOffset + 1
+			ranges = append(ranges, after)
+		}
+	}
+	ranges = append(ranges, acquiredRange{first: firstOffset, last: lastOffset, deliveryCount: deliveryCount, lockExpiry: expiry})
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].first < ranges[j].first })
+	state.ranges = ranges
+
+	for offset := range state.acknowledged {
+		if offset >= firstOffset && offset <= lastOffset {
+			delete(state.acknowledged, offset)
+		}
+	}
+}
+
+////////////////////
+// Acknowledging records
+////////////////////
+
+func (t *Tracker) Accept(tp TopicPartition, offset int64) error {
+	return t.Acknowledge(tp, offset, Accept)
+}
+
+func (t *Tracker) Release(tp TopicPartition, offset int64) error {
+	return t.Acknowledge(tp, offset, Release)
+}
+
+func (t *Tracker) Reject(tp TopicPartition, offset int64) error {
+	return t.Acknowledge(tp, offset, Reject)
+}
+
+// Renew asks the broker to extend the acquisition lock of the offset. The offset stays acquired and
+// has to be acknowledged again later.
+func (t *Tracker) Renew(tp TopicPartition, offset int64) error {
+	return t.Acknowledge(tp, offset, Renew)
+}
+
+// Acknowledge records the acknowledge type for an acquired offset. It fails when the offset is not
+// acquired, has already been acknowledged, or its acquisition lock has expired (in which case the
+// offset is dropped, because the broker will deliver it again).
+func (t *Tracker) Acknowledge(tp TopicPartition, offset int64, ackType AcknowledgeType) error {
+	if ackType < Gap || ackType > Renew {
+		return fmt.Errorf("unknown acknowledge type %d", ackType)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.partitions[tp]
+	if !ok {
+		return fmt.Errorf("%w: %s offset %d", ErrNotAcquired, tp, offset)
+	}
+	i, ok := state.find(offset)
+	if !ok {
+		return fmt.Errorf("%w: %s offset %d", ErrNotAcquired, tp, offset)
+	}
+
+	if _, ok := state.acknowledged[offset]; ok {
+		return fmt.Errorf("%w: %s offset %d", ErrAlreadyAcknowledged, tp, offset)
+	}
+
+	if !t.Now().Before(state.ranges[i].lockExpiry) {
+		state.remove(offset)
+		t.removeIfEmpty(tp)
+		return fmt.Errorf("%w: %s offset %d", ErrAcquisitionLockExpired, tp, offset)
+	}
+
+	state.acknowledged[offset] = ackType
+
+	return nil
+}
+
+// DeliveryCount returns the delivery count the broker reported when the offset was acquired.
+func (t *Tracker) DeliveryCount(tp TopicPartition, offset int64) (int16, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.partitions[tp]
+	if !ok {
+		return 0, false
+	}
+	i, ok := state.find(offset)
+	if !ok {
+		return 0, false
+	}
+
+	return state.ranges[i].deliveryCount, true
+}
+
+// Acquired returns the sorted ranges of the offsets of the partition which are acquired and not yet
+// acknowledged.
+func (t *Tracker) Acquired(tp TopicPartition) []OffsetRange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	acquired := make([]OffsetRange, 0)
+	state, ok := t.partitions[tp]
+	if !ok {
+		return acquired
+	}
+	acknowledged := state.acknowledgedOffsets()
+	for _, r := range state.ranges {
+		free, _ := split(r, acknowledged)
+		acquired = append(acquired, free...)
+	}
+
+	return acquired
+}
+
+// ExpireLocks drops every unacknowledged offset whose acquisition lock has expired and returns their
+// ranges per partition. The broker makes these records available again (with an incremented delivery
+// count).
+func (t *Tracker) ExpireLocks() map[TopicPartition][]OffsetRange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.Now()
+	expired := make(map[TopicPartition][]OffsetRange)
+	for tp, state := range t.partitions {
+		acknowledged := state.acknowledgedOffsets()
+		ranges := make([]acquiredRange, 0, len(state.ranges))
+		for _, r := range state.ranges {
+			if now.Before(r.lockExpiry) {
+				ranges = append(ranges, r)
+				continue
+			}
+
+			// Acknowledged offsets are kept until their acknowledgements are sent.
+			free, kept := split(r, acknowledged)
+			expired[tp] = append(expired[tp], free...)
+			for _, offset := range kept {
+				single := r
+				single.first, single.last = offset, offset
+				ranges = append(ranges, single)
+			}
+		}
+		state.ranges = ranges
+
+		if state.empty() {
+			delete(t.partitions, tp)
+		}
+	}
+
+	return expired
+}
+
+func (t *Tracker) removeIfEmpty(tp TopicPartition) {
+	if state, ok := t.partitions[tp]; ok && state.empty() {
+		delete(t.partitions, tp)
+	}
+}
+
+////////////////////
+// Building acknowledgement batches
+////////////////////
+
+// HasPendingAcknowledgements reports whether there are acknowledgements which were not sent yet.
+func (t *Tracker) HasPendingAcknowledgements() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, state := range t.partitions {
+		if len(state.acknowledged) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DrainAcknowledgements returns the pending acknowledgements as minimal batches per partition and
+// forgets them. Acknowledged offsets are removed from the tracker, except for renewed ones, which
+// stay acquired with a refreshed lock. The boolean reports whether any Renew acknowledgement is
+// included (the request then has to set IsRenewAck).
+func (t *Tracker) DrainAcknowledgements() (map[TopicPartition][]AcknowledgementBatch, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.Now()
+	renew := false
+	batches := make(map[TopicPartition][]AcknowledgementBatch)
+	for tp, state := range t.partitions {
+		if len(state.acknowledged) == 0 {
+			continue
+		}
+
+		offsets := state.acknowledgedOffsets()
+
+		types := make([]int8, len(offsets))
+		for i, offset := range offsets {
+			ackType := state.acknowledged[offset]
+			types[i] = int8(ackType)
+			delete(state.acknowledged, offset)
+
+			if ackType == Renew {
+				renew = true
+				if j, ok := state.isolate(offset); ok {
+					state.ranges[j].lockExpiry = now.Add(t.lockTimeout)
+				}
+			} else {
+				state.remove(offset)
+			}
+		}
+		if state.empty() {
+			delete(t.partitions, tp)
+		}
+
+		batches[tp] = buildBatches(offsets, types)
+	}
+
+	return batches, renew
+}
+
+// buildBatches splits the sorted offsets into contiguous ranges. A range whose offsets all share the
+// same acknowledge type is sent with a single type, otherwise with one type per offset.
+func buildBatches(offsets []int64, types []int8) []AcknowledgementBatch {
+	batches := make([]AcknowledgementBatch, 0)
+
+	start := 0
+	for i := 1; i <= len(offsets); i++ {
+		if i < len(offsets) && offsets[i] == offsets[i-1]+1 {
+			continue
+		}
+
+		batchTypes := append([]int8{}, types[start:i]...)
+		uniform := true
+		for _, ackType := range batchTypes {
+			if ackType != batchTypes[0] {
+				uniform = false
+				break
+			}
+		}
+		if uniform {
+			batchTypes = batchTypes[:1]
+		}
+
+		batches = append(batches, AcknowledgementBatch{
+			FirstOffset:      offsets[start],
+			LastOffset:       offsets[i-1],
+			AcknowledgeTypes: batchTypes,
+		})
+		start = i
+	}
+
+	return batches
+}
+
+// AddToShareFetch drains the pending acknowledgements into the request so that they are piggybacked
+// on the next fetch. Partitions which are already fetched get the batches added; the others are
+// added with PartitionMaxBytes 0 (acknowledge only). It returns false if nothing was pending.
+func (t *Tracker) AddToShareFetch(req *sharefetch.ShareFetchRequest) bool {
+	batches, renew := t.DrainAcknowledgements()
+	if len(batches) == 0 {
+		return false
+	}
+
+	if req.Topics == nil {
+		req.Topics = &[]sharefetch.ShareFetchRequestTopic{}
+	}
+	if renew {
+		req.IsRenewAck = true
+	}
+
+	for _, tp := range sortedPartitions(batches) {
+		topic := findShareFetchTopic(req, tp.TopicId)
+		partition := findShareFetchPartition(topic, tp.Partition)
+
+		if partition.AcknowledgementBatches == nil {
+			partition.AcknowledgementBatches = &[]sharefetch.ShareFetchRequestTopicPartitionAcknowledgementBatche{}
+		}
+		for _, batch := range batches[tp] {
+			ackTypes := batch.AcknowledgeTypes
+			*partition.AcknowledgementBatches = append(*partition.AcknowledgementBatches, sharefetch.ShareFetchRequestTopicPartitionAcknowledgementBatche{
+				FirstOffset:      batch.FirstOffset,
+				LastOffset:       batch.LastOffset,
+				AcknowledgeTypes: &ackTypes,
+			})
+		}
+	}
+
+	return true
+}
+
+func findShareFetchTopic(req *sharefetch.ShareFetchRequest, topicId uuid.UUID) *sharefetch.ShareFetchRequestTopic {
+	for i := range *req.Topics {
+		if (*req.Topics)[i].TopicId == topicId {
+			return &(*req.Topics)[i]
+		}
+	}
+
+	*req.Topics = append(*req.Topics, sharefetch.ShareFetchRequestTopic{TopicId: topicId, Partitions: &[]sharefetch.ShareFetchRequestTopicPartition{}})
+	return &(*req.Topics)[len(*req.Topics)-1]
+}
+
+func findShareFetchPartition(topic *sharefetch.ShareFetchRequestTopic, partitionIndex int32) *sharefetch.ShareFetchRequestTopicPartition {
+	if topic.Partitions == nil {
+		topic.Partitions = &[]sharefetch.ShareFetchRequestTopicPartition{}
+	}
+
+	for i := range *topic.Partitions {
+		if (*topic.Partitions)[i].PartitionIndex == partitionIndex {
+			return &(*topic.Partitions)[i]
+		}
+	}
+
+	*topic.Partitions = append(*topic.Partitions, sharefetch.ShareFetchRequestTopicPartition{PartitionIndex: partitionIndex, PartitionMaxBytes: 0})
+	return &(*topic.Partitions)[len(*topic.Partitions)-1]
+}
+
+// AddToShareAcknowledge drains the pending acknowledgements into a ShareAcknowledge request. It
+// returns false if nothing was pending.
+func (t *Tracker) AddToShareAcknowledge(req *shareacknowledge.ShareAcknowledgeRequest) bool {
+	batches, renew := t.DrainAcknowledgements()
+	if len(batches) == 0 {
+		return false
+	}
+
+	if req.Topics == nil {
+		req.Topics = &[]shareacknowledge.ShareAcknowledgeRequestTopic{}
+	}
+	if renew {
+		req.IsRenewAck = true
+	}
+
+	for _, tp := range sortedPartitions(batches) {
+		topic := findShareAcknowledgeTopic(req, tp.TopicId)
+		partition := findShareAcknowledgePartition(topic, tp.Partition)
+
+		if partition.AcknowledgementBatches == nil {
+			partition.AcknowledgementBatches = &[]shareacknowledge.ShareAcknowledgeRequestTopicPartitionAcknowledgementBatche{}
+		}
+		for _, batch := range batches[tp] {
+			ackTypes := batch.AcknowledgeTypes
+			*partition.AcknowledgementBatches = append(*partition.AcknowledgementBatches, shareacknowledge.ShareAcknowledgeRequestTopicPartitionAcknowledgementBatche{
+				FirstOffset:      batch.FirstOffset,
+				LastOffset:       batch.LastOffset,
+				AcknowledgeTypes: &ackTypes,
+			})
+		}
+	}
+
+	return true
+}
+
+func findShareAcknowledgeTopic(req *shareacknowledge.ShareAcknowledgeRequest, topicId uuid.UUID) *shareacknowledge.ShareAcknowledgeRequestTopic {
+	for i := range *req.Topics {
+		if (*req.Topics)[i].TopicId == topicId {
+			return &(*req.Topics)[i]
+		}
+	}
+
+	*req.Topics = append(*req.Topics, shareacknowledge.ShareAcknowledgeRequestTopic{TopicId: topicId, Partitions: &[]shareacknowledge.ShareAcknowledgeRequestTopicPartition{}})
+	return &(*req.Topics)[len(*req.Topics)-1]
+}
+
+func findShareAcknowledgePartition(topic *shareacknowledge.ShareAcknowledgeRequestTopic, partitionIndex int32) *shareacknowledge.ShareAcknowledgeRequestTopicPartition {
+	if topic.Partitions == nil {
+		topic.Partitions = &[]shareacknowledge.ShareAcknowledgeRequestTopicPartition{}
+	}
+
+	for i := range *topic.Partitions {
+		if (*topic.Partitions)[i].PartitionIndex == partitionIndex {
+			return &(*topic.Partitions)[i]
+		}
+	}
+
+	*topic.Partitions = append(*topic.Partitions, shareacknowledge.ShareAcknowledgeRequestTopicPartition{PartitionIndex: partitionIndex})
+	return &(*topic.Partitions)[len(*topic.Partitions)-1]
+}
+
+// sortedPartitions returns the map keys in a stable order so that the generated requests are
+// deterministic.
+func sortedPartitions(batches map[TopicPartition][]AcknowledgementBatch) []TopicPartition {
+	partitions := make([]TopicPartition, 0, len(batches))
+	for tp := range batches {
+		partitions = append(partitions, tp)
+	}
+
+	sort.Slice(partitions, func(i, j int) bool {
+		if partitions[i].TopicId != partitions[j].TopicId {
+			return partitions[i].TopicId.String() < partitions[j].TopicId.String()
+		}
+		return partitions[i].Partition < partitions[j].Partition
+	})
+
+	return partitions
+}
@@ -0,0 +1,314 @@
+package shareconsumer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/shareacknowledge"
+	"github.com/scholzj/go-kafka-protocol/api/sharefetch"
+	"github.com/scholzj/go-kafka-protocol/compression"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+func newTestTracker(now *time.Time) *Tracker {
+	tracker := NewTracker()
+	tracker.Now = func() time.Time { return *now }
+	return tracker
+}
+
+// testRecords returns the record batches of a ShareFetch response with count records from baseOffset.
+func testRecords(t *testing.T, baseOffset int64, count int) *[]byte {
+	t.Helper()
+
+	batch := records.NewRecordBatch(baseOffset, compression.None)
+	for i := 0; i < count; i++ {
+		batch.AppendRecord(0, nil, []byte("value"), nil)
+	}
+	data, err := records.WriteRecordBatches([]*records.RecordBatch{batch})
+	if err != nil {
+		t.Fatalf("WriteRecordBatches: %v", err)
+	}
+	return &data
+}
+
+func TestBuildBatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		offsets []int64
+		types   []int8
+		want    []AcknowledgementBatch
+	}{
+		{
+			name:    "uniform range collapses to a single type",
+			offsets: []int64{10, 11, 12},
+			types:   []int8{1, 1, 1},
+			want:    []AcknowledgementBatch{{FirstOffset: 10, LastOffset: 12, AcknowledgeTypes: []int8{1}}},
+		},
+		{
+			name:    "mixed range keeps one type per offset",
+			offsets: []int64{10, 11, 12},
+			types:   []int8{1, 3, 1},
+			want:    []AcknowledgementBatch{{FirstOffset: 10, LastOffset: 12, AcknowledgeTypes: []int8{1, 3, 1}}},
+		},
+		{
+			name:    "holes split the batches",
+			offsets: []int64{1, 2, 5, 7, 8},
+			types:   []int8{1, 1, 2, 0, 1},
+			want: []AcknowledgementBatch{
+				{FirstOffset: 1, LastOffset: 2, AcknowledgeTypes: []int8{1}},
+				{FirstOffset: 5, LastOffset: 5, AcknowledgeTypes: []int8{2}},
+				{FirstOffset: 7, LastOffset: 8, AcknowledgeTypes: []int8{0, 1}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildBatches(tt.offsets, tt.types); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildBatches() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackerAcknowledgeAndDrain(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tracker := newTestTracker(&now)
+	tp := TopicPartition{TopicId: uuid.New(), Partition: 3}
+
+	tracker.AddShareFetchResponse(&sharefetch.ShareFetchResponse{
+		ApiVersion:               1,
+		AcquisitionLockTimeoutMs: 5000,
+		Responses: &[]sharefetch.ShareFetchResponseResponse{{
+			TopicId: tp.TopicId,
+			Partitions: &[]sharefetch.ShareFetchResponseResponsePartition{{
+				PartitionIndex:  tp.Partition,
+				Records:         testRecords(t, 100, 5),
+				AcquiredRecords: &[]sharefetch.ShareFetchResponseResponsePartitionAcquiredRecord{{FirstOffset: 100, LastOffset: 104, DeliveryCount: 2}},
+			}},
+		}},
+	})
+
+	if count, ok := tracker.DeliveryCount(tp, 102); !ok || count != 2 {
+		t.Fatalf("DeliveryCount() = (%d, %v), want (2, true)", count, ok)
+	}
+
+	for _, offset := range []int64{100, 101} {
+		if err := tracker.Accept(tp, offset); err != nil {
+			t.Fatalf("Accept(%d): %v", offset, err)
+		}
+	}
+	if err := tracker.Reject(tp, 102); err != nil {
+		t.Fatalf("Reject(102): %v", err)
+	}
+	if err := tracker.Accept(tp, 102); !errors.Is(err, ErrAlreadyAcknowledged) {
+		t.Errorf("second Accept(102) error = %v, want ErrAlreadyAcknowledged", err)
+	}
+	if err := tracker.Accept(tp, 200); !errors.Is(err, ErrNotAcquired) {
+		t.Errorf("Accept(200) error = %v, want ErrNotAcquired", err)
+	}
+
+	req := &sharefetch.ShareFetchRequest{ApiVersion: 1}
+	if !tracker.AddToShareFetch(req) {
+		t.Fatal("AddToShareFetch() = false, want pending acknowledgements")
+	}
+
+	partitions := *(*req.Topics)[0].Partitions
+	if len(partitions) != 1 || partitions[0].PartitionIndex != tp.Partition {
+		t.Fatalf("unexpected partitions %+v", partitions)
+	}
+	batches := *partitions[0].AcknowledgementBatches
+	if len(batches) != 1 || batches[0].FirstOffset != 100 || batches[0].LastOffset != 102 || !reflect.DeepEqual(*batches[0].AcknowledgeTypes, []int8{1, 1, 3}) {
+		t.Errorf("unexpected acknowledgement batches %+v", batches)
+	}
+
+	// The generated request must be encodable.
+	req.MemberId = reqPtr("member")
+	req.ForgottenTopicsData = &[]sharefetch.ShareFetchRequestForgottenTopicsData{}
+	var buf bytes.Buffer
+	if err := req.Write(&buf); err != nil {
+		t.Fatalf("ShareFetchRequest.Write: %v", err)
+	}
+
+	if got := tracker.Acquired(tp); !reflect.DeepEqual(got, []OffsetRange{{103, 104}}) {
+		t.Errorf("Acquired() = %v, want [{103 104}]", got)
+	}
+	if tracker.HasPendingAcknowledgements() {
+		t.Error("acknowledgements must be forgotten once drained")
+	}
+}
+
+func TestTrackerLockExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tracker := newTestTracker(&now)
+	tp := TopicPartition{TopicId: uuid.New(), Partition: 0}
+
+	tracker.Acquire(tp, 0, 2, 1)
+	if err := tracker.Release(tp, 0); err != nil {
+		t.Fatalf("Release(0): %v", err)
+	}
+
+	now = now.Add(DefaultLockTimeout)
+	if err := tracker.Accept(tp, 1); !errors.Is(err, ErrAcquisitionLockExpired) {
+		t.Errorf("Accept(1) error = %v, want ErrAcquisitionLockExpired", err)
+	}
+
+	expired := tracker.ExpireLocks()
+	if !reflect.DeepEqual(expired, map[TopicPartition][]OffsetRange{tp: {{2, 2}}}) {
+		t.Errorf("ExpireLocks() = %v, want offset 2 only", expired)
+	}
+
+	// The release acknowledged before the expiry is still sent.
+	req := &shareacknowledge.ShareAcknowledgeRequest{}
+	if !tracker.AddToShareAcknowledge(req) {
+		t.Fatal("AddToShareAcknowledge() = false, want the pending release")
+	}
+	batch := (*(*(*req.Topics)[0].Partitions)[0].AcknowledgementBatches)[0]
+	if batch.FirstOffset != 0 || batch.LastOffset != 0 || !reflect.DeepEqual(*batch.AcknowledgeTypes, []int8{int8(Release)}) {
+		t.Errorf("unexpected batch %+v", batch)
+	}
+}
+
+func TestTrackerRenewKeepsRecordAcquired(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tracker := newTestTracker(&now)
+	tp := TopicPartition{TopicId: uuid.New(), Partition: 0}
+
+	tracker.Acquire(tp, 5, 5, 1)
+	if err := tracker.Renew(tp, 5); err != nil {
+		t.Fatalf("Renew(5): %v", err)
+	}
+
+	now = now.Add(DefaultLockTimeout / 2)
+	req := &shareacknowledge.ShareAcknowledgeRequest{ApiVersion: 2, Topics: &[]shareacknowledge.ShareAcknowledgeRequestTopic{}}
+	if !tracker.AddToShareAcknowledge(req) || !req.IsRenewAck {
+		t.Fatal("renew acknowledgement must be sent with IsRenewAck")
+	}
+
+	// The lock was refreshed when the renewal was sent, so the record can still be accepted later.
+	now = now.Add(DefaultLockTimeout - time.Second)
+	if err := tracker.Accept(tp, 5); err != nil {
+		t.Errorf("Accept(5) after renewal: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := req.Write(&buf); err != nil {
+		t.Fatalf("ShareAcknowledgeRequest.Write: %v", err)
+	}
+	out := &shareacknowledge.ShareAcknowledgeRequest{}
+	request := &protocol.Request{Body: bytes.NewBuffer(buf.Bytes())}
+	request.ApiVersion = 2
+	if err := out.Read(request); err != nil || !out.IsRenewAck {
+		t.Errorf("round trip: err=%v IsRenewAck=%v", err, out.IsRenewAck)
+	}
+}
+
+func TestTrackerAcquiredRanges(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tracker := newTestTracker(&now)
+	tp := TopicPartition{TopicId: uuid.New(), Partition: 0}
+
+	// Invalid ranges are ignored and the others are limited to the returned records, even when they
+	// end at the largest offset.
+	data := append(*testRecords(t, 10, 3), *testRecords(t, 13, 2)...)
+	data = append(data, (*testRecords(t, 15, 5))[:20]...)
+	tracker.AddShareFetchResponse(&sharefetch.ShareFetchResponse{
+		Responses: &[]sharefetch.ShareFetchResponseResponse{{
+			TopicId: tp.TopicId,
+			Partitions: &[]sharefetch.ShareFetchResponseResponsePartition{{
+				PartitionIndex: tp.Partition,
+				Records:        &data,
+				AcquiredRecords: &[]sharefetch.ShareFetchResponseResponsePartitionAcquiredRecord{
+					{FirstOffset: 12, LastOffset: 11, DeliveryCount: 1},
+					{FirstOffset: 0, LastOffset: 11, DeliveryCount: 1},
+					{FirstOffset: 13, LastOffset: math.MaxInt64, DeliveryCount: 2},
+					{FirstOffset: 100, LastOffset: 200, DeliveryCount: 1},
+				},
+			}},
+		}},
+	})
+	if got := tracker.Acquired(tp); !reflect.DeepEqual(got, []OffsetRange{{10, 11}, {13, 14}}) {
+		t.Errorf("Acquired() = %v, want [{10 11} {13 14}]", got)
+	}
+	for _, r := range []OffsetRange{{5, 4}, {-1, 0}, {0, MaxAcquiredRange}, {0, math.MaxInt64}} {
+		if err := tracker.Acquire(tp, r.First, r.Last, 1); !errors.Is(err, ErrInvalidOffsetRange) {
+			t.Errorf("Acquire(%d, %d) error = %v, want ErrInvalidOffsetRange", r.First, r.Last, err)
+		}
+	}
+
+	// Large ranges are stored as ranges, and acknowledging an offset splits them.
+	if err := tracker.Acquire(tp, math.MaxInt64-MaxAcquiredRange+1, math.MaxInt64, 3); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if err := tracker.Accept(tp, math.MaxInt64-1<<30); err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	if err := tracker.Renew(tp, math.MaxInt64); err != nil {
+		t.Fatalf("Renew: %v", err)
+	}
+	batches, renew := tracker.DrainAcknowledgements()
+	want := []AcknowledgementBatch{
+		{FirstOffset: math.MaxInt64 - 1<<30, LastOffset: math.MaxInt64 - 1<<30, AcknowledgeTypes: []int8{int8(Accept)}},
+		{FirstOffset: math.MaxInt64, LastOffset: math.MaxInt64, AcknowledgeTypes: []int8{int8(Renew)}},
+	}
+	if !renew || !reflect.DeepEqual(batches[tp], want) {
+		t.Errorf("DrainAcknowledgements() = %+v, want %+v", batches[tp], want)
+	}
+	for _, offset := range []int64{math.MaxInt64 - 1<<30 - 1, math.MaxInt64 - 1<<30 + 1, math.MaxInt64} {
+		if count, ok := tracker.DeliveryCount(tp, offset); !ok || count != 3 {
+			t.Errorf("DeliveryCount(%d) = (%d, %v), want (3, true)", offset, count, ok)
+		}
+	}
+	if _, ok := tracker.DeliveryCount(tp, math.MaxInt64-1<<30); ok {
+		t.Error("the accepted offset must not be acquired anymore")
+	}
+	if err := tracker.Acquire(tp, math.MaxInt64-MaxAcquiredRange+1, math.MaxInt64, 1); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if state := tracker.partitions[tp]; len(state.ranges) != 3 || len(state.acknowledged) != 0 {
+		t.Errorf("unexpected state %+v", state)
+	}
+
+	// Listing and expiring the largest ranges works on the ranges, not on their offsets.
+	if err := tracker.Accept(tp, math.MaxInt64-10); err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	last := OffsetRange{math.MaxInt64 - MaxAcquiredRange + 1, math.MaxInt64}
+	if got := tracker.Acquired(tp); !reflect.DeepEqual(got, []OffsetRange{{10, 11}, {13, 14}, {last.First, math.MaxInt64 - 11}, {math.MaxInt64 - 9, last.Last}}) {
+		t.Errorf("Acquired() = %v", got)
+	}
+	now = now.Add(DefaultLockTimeout)
+	expired := tracker.ExpireLocks()
+	if want := []OffsetRange{{10, 11}, {13, 14}, {last.First, math.MaxInt64 - 11}, {math.MaxInt64 - 9, last.Last}}; !reflect.DeepEqual(expired[tp], want) {
+		t.Errorf("ExpireLocks() = %v, want %v", expired[tp], want)
+	}
+	if got := tracker.Acquired(tp); len(got) != 0 || !tracker.HasPendingAcknowledgements() {
+		t.Errorf("Acquired() after the expiry = %v", got)
+	}
+	// A batch which claims to span more offsets than a range may have is not acquired.
+	huge := *testRecords(t, 0, 1)
+	binary.BigEndian.PutUint32(huge[23:27], math.MaxInt32)
+	other := TopicPartition{TopicId: uuid.New(), Partition: 1}
+	tracker.AddShareFetchResponse(&sharefetch.ShareFetchResponse{
+		Responses: &[]sharefetch.ShareFetchResponseResponse{{
+			TopicId: other.TopicId,
+			Partitions: &[]sharefetch.ShareFetchResponseResponsePartition{{
+				PartitionIndex:  other.Partition,
+				Records:         &huge,
+				AcquiredRecords: &[]sharefetch.ShareFetchResponseResponsePartitionAcquiredRecord{{FirstOffset: 0, LastOffset: math.MaxInt64, DeliveryCount: 1}},
+			}},
+		}},
+	})
+	if got := tracker.Acquired(other); len(got) != 0 {
+		t.Errorf("Acquired() = %v, want no ranges", got)
+	}
+}
+
+func reqPtr[T any](v T) *T { return &v }
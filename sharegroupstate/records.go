@@ -0,0 +1,282 @@
+package sharegroupstate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/protocol"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// The records of the __share_group_state topic written by the share coordinator. The key starts
+// with an int16 version which identifies the record type, followed by the share-partition. The
+// value starts with the int16 value version; all values are flexible from version 0. A null value
+// is a tombstone.
+
+// Topic is the name of the internal share group state topic.
+const Topic = "__share_group_state"
+
+// Record types, identified by the version of their key.
+const (
+	ShareSnapshotKeyVersion int16 = 0
+	ShareUpdateKeyVersion   int16 = 1
+)
+
+// HighestSupportedValueVersion is the highest supported version of the values.
+const HighestSupportedValueVersion int16 = 0
+
+// Delivery states of the records in a state batch.
+const (
+	Available    int8 = 0
+	Acknowledged int8 = 2
+	Archived     int8 = 4
+)
+
+// NoStartOffset is the start offset of an uninitialized share-partition, and of an update which does
+// not move the start offset.
+const NoStartOffset int64 = -1
+
+var (
+	ErrUnknownRecordType  = errors.New("unknown share group state record type")
+	ErrUnsupportedVersion = errors.New("unsupported share group state value version")
+)
+
+// Key identifies a share-partition. ShareSnapshotKey and ShareUpdateKey have the same fields.
+type Key struct {
+	GroupId   string    // The group id. (versions: 0-1)
+	TopicId   uuid.UUID // The topic id. (versions: 0-1)
+	Partition int32     // The partition index. (versions: 0-1)
+}
+
+// StateBatch is the delivery state of a range of offsets.
+type StateBatch struct {
+	FirstOffset   int64 // The first offset of the batch. (versions: 0+)
+	LastOffset    int64 // The last offset of the batch. (versions: 0+)
+	DeliveryState int8  // The delivery state - 0:Available,2:Acked,4:Archived. (versions: 0+)
+	DeliveryCount int16 // The delivery count. (versions: 0+)
+}
+
+// ShareSnapshotValue is the complete state of a share-partition.
+type ShareSnapshotValue struct {
+	SnapshotEpoch int32        // The snapshot epoch. (versions: 0+)
+	StateEpoch    int32        // The state epoch of the share-partition. (versions: 0+)
+	LeaderEpoch   int32        // The leader epoch of the share-partition. (versions: 0+)
+	StartOffset   int64        // The share-partition start offset. (versions: 0+)
+	StateBatches  []StateBatch // The state batches. (versions: 0+)
+}
+
+// ShareUpdateValue is an update of a share-partition on top of its latest snapshot.
+type ShareUpdateValue struct {
+	SnapshotEpoch int32        // The epoch of the snapshot the update applies to. (versions: 0+)
+	LeaderEpoch   int32        // The leader epoch of the share-partition, or -1. (versions: 0+)
+	StartOffset   int64        // The new share-partition start offset, or -1. (versions: 0+)
+	StateBatches  []StateBatch // The updated state batches. (versions: 0+)
+}
+
+func decodeStateBatches(d *protocol.Decoder) []StateBatch {
+	return protocol.DecodeArray(d, func(d *protocol.Decoder) StateBatch {
+		var batch StateBatch
+		batch.FirstOffset = d.Int64()
+		batch.LastOffset = d.Int64()
+		batch.DeliveryState = d.Int8()
+		batch.DeliveryCount = d.Int16()
+		d.TaggedFields(noTaggedFields)
+		return batch
+	})
+}
+
+func encodeStateBatches(e *protocol.Encoder, batches []StateBatch) {
+	protocol.EncodeArray(e, batches, func(e *protocol.Encoder, batch StateBatch) {
+		e.Int64(batch.FirstOffset)
+		e.Int64(batch.LastOffset)
+		e.Int8(batch.DeliveryState)
+		e.Int16(batch.DeliveryCount)
+		e.TaggedFields(nil)
+	})
+}
+
+func (m *ShareSnapshotValue) decode(d *protocol.Decoder) {
+	m.SnapshotEpoch = d.Int32()
+	m.StateEpoch = d.Int32()
+	m.LeaderEpoch = d.Int32()
+	m.StartOffset = d.Int64()
+	m.StateBatches = decodeStateBatches(d)
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ShareSnapshotValue) encode(e *protocol.Encoder) {
+	e.Int32(m.SnapshotEpoch)
+	e.Int32(m.StateEpoch)
+	e.Int32(m.LeaderEpoch)
+	e.Int64(m.StartOffset)
+	encodeStateBatches(e, m.StateBatches)
+	e.TaggedFields(nil)
+}
+
+func (m *ShareUpdateValue) decode(d *protocol.Decoder) {
+	m.SnapshotEpoch = d.Int32()
+	m.LeaderEpoch = d.Int32()
+	m.StartOffset = d.Int64()
+	m.StateBatches = decodeStateBatches(d)
+	d.TaggedFields(noTaggedFields)
+}
+
+func (m *ShareUpdateValue) encode(e *protocol.Encoder) {
+	e.Int32(m.SnapshotEpoch)
+	e.Int32(m.LeaderEpoch)
+	e.Int64(m.StartOffset)
+	encodeStateBatches(e, m.StateBatches)
+	e.TaggedFields(nil)
+}
+
+// Record is a decoded share group state record. Exactly one of Snapshot and Update is set, unless
+// the record is a tombstone.
+type Record struct {
+	KeyVersion   int16
+	Key          Key
+	ValueVersion int16
+	Snapshot     *ShareSnapshotValue
+	Update       *ShareUpdateValue
+}
+
+// Tombstone returns true for a record without a value.
+func (r Record) Tombstone() bool {
+	return r.Snapshot == nil && r.Update == nil
+}
+
+// DecodeRecord decodes the key and the value of a share group state record.
+func DecodeRecord(key []byte, value []byte) (Record, error) {
+	var record Record
+
+	kr := bytes.NewReader(key)
+	keyVersion, err := protocol.ReadInt16(kr)
+	if err != nil {
+		return Record{}, fmt.Errorf("share group state record key: %w", err)
+	}
+	if keyVersion != ShareSnapshotKeyVersion && keyVersion != ShareUpdateKeyVersion {
+		return Record{}, fmt.Errorf("%w: key version %d", ErrUnknownRecordType, keyVersion)
+	}
+	record.KeyVersion = keyVersion
+
+	d := protocol.NewDecoder(kr, false)
+	record.Key.GroupId = d.String()
+	record.Key.TopicId = d.UUID()
+	record.Key.Partition = d.Int32()
+	if err := d.Err(); err != nil {
+		return Record{}, fmt.Errorf("%s: %w", keyName(keyVersion), err)
+	}
+	if kr.Len() != 0 {
+		return Record{}, fmt.Errorf("%s: %d bytes after the message", keyName(keyVersion), kr.Len())
+	}
+
+	if value == nil {
+		return record, nil
+	}
+
+	vr := bytes.NewReader(value)
+	if record.ValueVersion, err = protocol.ReadInt16(vr); err != nil {
+		return Record{}, fmt.Errorf("%s: %w", valueName(keyVersion), err)
+	}
+	if record.ValueVersion < 0 || record.ValueVersion > HighestSupportedValueVersion {
+		return Record{}, fmt.Errorf("%w: %s version %d", ErrUnsupportedVersion, valueName(keyVersion), record.ValueVersion)
+	}
+
+	d = protocol.NewDecoder(vr, true)
+	if keyVersion == ShareSnapshotKeyVersion {
+		record.Snapshot = &ShareSnapshotValue{}
+		record.Snapshot.decode(d)
+	} else {
+		record.Update = &ShareUpdateValue{}
+		record.Update.decode(d)
+	}
+	if err := d.Err(); err != nil {
+		return Record{}, fmt.Errorf("%s version %d: %w", valueName(keyVersion), record.ValueVersion, err)
+	}
+	if vr.Len() != 0 {
+		return Record{}, fmt.Errorf("%s version %d: %d bytes after the message", valueName(keyVersion), record.ValueVersion, vr.Len())
+	}
+
+	return record, nil
+}
+
+// EncodeRecord encodes a share group state record into its key and value. A tombstone encodes to a
+// nil value.
+func EncodeRecord(record Record) ([]byte, []byte, error) {
+	if record.KeyVersion != ShareSnapshotKeyVersion && record.KeyVersion != ShareUpdateKeyVersion {
+		return nil, nil, fmt.Errorf("%w: key version %d", ErrUnknownRecordType, record.KeyVersion)
+	}
+	if (record.KeyVersion == ShareSnapshotKeyVersion && record.Update != nil) || (record.KeyVersion == ShareUpdateKeyVersion && record.Snapshot != nil) {
+		return nil, nil, fmt.Errorf("the value does not match %s", keyName(record.KeyVersion))
+	}
+
+	key := bytes.NewBuffer(make([]byte, 0))
+	e := protocol.NewEncoder(key, false)
+	e.Int16(record.KeyVersion)
+	e.String(record.Key.GroupId)
+	e.UUID(record.Key.TopicId)
+	e.Int32(record.Key.Partition)
+	if err := e.Err(); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", keyName(record.KeyVersion), err)
+	}
+	if record.Tombstone() {
+		return key.Bytes(), nil, nil
+	}
+
+	if record.ValueVersion < 0 || record.ValueVersion > HighestSupportedValueVersion {
+		return nil, nil, fmt.Errorf("%w: %s version %d", ErrUnsupportedVersion, valueName(record.KeyVersion), record.ValueVersion)
+	}
+
+	value := bytes.NewBuffer(make([]byte, 0))
+	if err := protocol.WriteInt16(value, record.ValueVersion); err != nil {
+		return nil, nil, err
+	}
+	e = protocol.NewEncoder(value, true)
+	if record.Snapshot != nil {
+		record.Snapshot.encode(e)
+	} else {
+		record.Update.encode(e)
+	}
+	if err := e.Err(); err != nil {
+		return nil, nil, fmt.Errorf("%s version %d: %w", valueName(record.KeyVersion), record.ValueVersion, err)
+	}
+
+	return key.Bytes(), value.Bytes(), nil
+}
+
+// DecodeBatch decodes the records of a batch of the share group state topic.
+func DecodeBatch(batch *records.RecordBatch) ([]Record, error) {
+	decoded := make([]Record, 0, len(batch.Records))
+	if batch.IsControl() {
+		return decoded, nil
+	}
+
+	for _, record := range batch.Records {
+		r, err := DecodeRecord(record.Key, record.Value)
+		if err != nil {
+			return nil, fmt.Errorf("offset %d: %w", batch.Offset(record), err)
+		}
+		decoded = append(decoded, r)
+	}
+
+	return decoded, nil
+}
+
+func keyName(keyVersion int16) string {
+	if keyVersion == ShareSnapshotKeyVersion {
+		return "ShareSnapshotKey"
+	}
+	return "ShareUpdateKey"
+}
+
+func valueName(keyVersion int16) string {
+	if keyVersion == ShareSnapshotKeyVersion {
+		return "ShareSnapshotValue"
+	}
+	return "ShareUpdateValue"
+}
+
+func noTaggedFields(*protocol.Decoder, uint64) bool {
+	return false
+}
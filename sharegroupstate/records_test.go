@@ -0,0 +1,100 @@
+package sharegroupstate
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/compression"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+var key = Key{GroupId: "share", TopicId: uuid.MustParse("5d5e6f70-8192-4a3b-8c4d-5e6f708192a3"), Partition: 1}
+
+func TestRecordRoundTrip(t *testing.T) {
+	tests := []Record{
+		{KeyVersion: ShareSnapshotKeyVersion, Key: key, Snapshot: &ShareSnapshotValue{SnapshotEpoch: 1, StateEpoch: 2, LeaderEpoch: 3, StartOffset: 100, StateBatches: []StateBatch{{FirstOffset: 100, LastOffset: 109, DeliveryState: Acknowledged, DeliveryCount: 1}}}},
+		{KeyVersion: ShareUpdateKeyVersion, Key: key, Update: &ShareUpdateValue{SnapshotEpoch: 1, LeaderEpoch: -1, StartOffset: NoStartOffset, StateBatches: []StateBatch{}}},
+		{KeyVersion: ShareSnapshotKeyVersion, Key: key},
+	}
+
+	for _, test := range tests {
+		k, v, err := EncodeRecord(test)
+		if err != nil {
+			t.Fatalf("EncodeRecord: %v", err)
+		}
+		decoded, err := DecodeRecord(k, v)
+		if err != nil {
+			t.Fatalf("DecodeRecord: %v", err)
+		}
+		if !reflect.DeepEqual(decoded, test) {
+			t.Errorf("round trip:\n got %+v\nwant %+v", decoded, test)
+		}
+	}
+
+	if _, _, err := EncodeRecord(Record{KeyVersion: ShareUpdateKeyVersion, Key: key, Snapshot: &ShareSnapshotValue{}}); err == nil {
+		t.Error("EncodeRecord must reject a snapshot value with an update key")
+	}
+	if _, err := DecodeRecord([]byte{0, 2}, nil); !errors.Is(err, ErrUnknownRecordType) {
+		t.Errorf("unknown key version error = %v", err)
+	}
+	k, _, _ := EncodeRecord(tests[0])
+	if _, err := DecodeRecord(k, []byte{0, 1}); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("unsupported value version error = %v", err)
+	}
+}
+
+func TestStateReplay(t *testing.T) {
+	batch := records.NewRecordBatch(0, compression.None)
+	for _, r := range []Record{
+		{KeyVersion: ShareSnapshotKeyVersion, Key: key, Snapshot: &ShareSnapshotValue{SnapshotEpoch: 1, StateEpoch: 0, LeaderEpoch: 3, StartOffset: 100,
+			StateBatches: []StateBatch{{FirstOffset: 100, LastOffset: 119, DeliveryState: Available, DeliveryCount: 1}}}},
+		// Offsets 105-109 acknowledged, splitting the snapshot batch.
+		{KeyVersion: ShareUpdateKeyVersion, Key: key, Update: &ShareUpdateValue{SnapshotEpoch: 1, LeaderEpoch: -1, StartOffset: NoStartOffset,
+			StateBatches: []StateBatch{{FirstOffset: 105, LastOffset: 109, DeliveryState: Acknowledged, DeliveryCount: 1}}}},
+		// The start offset moves to 103 and 118-125 are archived.
+		{KeyVersion: ShareUpdateKeyVersion, Key: key, Update: &ShareUpdateValue{SnapshotEpoch: 1, LeaderEpoch: 4, StartOffset: 103,
+			StateBatches: []StateBatch{{FirstOffset: 118, LastOffset: 125, DeliveryState: Archived, DeliveryCount: 2}}}},
+		// An update for an older snapshot is ignored.
+		{KeyVersion: ShareUpdateKeyVersion, Key: key, Update: &ShareUpdateValue{SnapshotEpoch: 0, LeaderEpoch: -1, StartOffset: 200}},
+	} {
+		k, v, err := EncodeRecord(r)
+		if err != nil {
+			t.Fatalf("EncodeRecord: %v", err)
+		}
+		batch.AppendRecord(1000, k, v, nil)
+	}
+
+	state := NewState()
+	if err := state.ApplyBatch(batch); err != nil {
+		t.Fatalf("ApplyBatch: %v", err)
+	}
+
+	partition := state.Partitions[key]
+	if partition == nil {
+		t.Fatal("the share-partition is missing")
+	}
+	want := []StateBatch{
+		{FirstOffset: 103, LastOffset: 104, DeliveryState: Available, DeliveryCount: 1},
+		{FirstOffset: 105, LastOffset: 109, DeliveryState: Acknowledged, DeliveryCount: 1},
+		{FirstOffset: 110, LastOffset: 117, DeliveryState: Available, DeliveryCount: 1},
+		{FirstOffset: 118, LastOffset: 125, DeliveryState: Archived, DeliveryCount: 2},
+	}
+	if !reflect.DeepEqual(partition.StateBatches, want) {
+		t.Errorf("StateBatches = %+v, want %+v", partition.StateBatches, want)
+	}
+	if partition.StartOffset != 103 || partition.LeaderEpoch != 4 || partition.Updates != 2 || state.Orphaned != 1 {
+		t.Errorf("unexpected partition %+v, orphaned %d", partition, state.Orphaned)
+	}
+
+	result := partition.ReadResult(key.Partition)
+	if result.StartOffset != 103 || len(*result.StateBatches) != 4 || (*result.StateBatches)[1].DeliveryState != Acknowledged {
+		t.Errorf("unexpected ReadResult %+v", result)
+	}
+
+	state.Apply(Record{KeyVersion: ShareSnapshotKeyVersion, Key: key})
+	if len(state.Partitions) != 0 {
+		t.Error("the snapshot tombstone did not delete the share-partition")
+	}
+}
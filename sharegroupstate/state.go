@@ -0,0 +1,165 @@
+package sharegroupstate
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/scholzj/go-kafka-protocol/api/readsharegroupstate"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// SharePartition is the persisted state of a share-partition: its latest snapshot with the updates
+// written after it applied.
+type SharePartition struct {
+	SnapshotEpoch int32
+	StateEpoch    int32
+	LeaderEpoch   int32
+	StartOffset   int64
+	StateBatches  []StateBatch // Sorted by offset and not overlapping.
+	Updates       int          // The number of updates applied since the snapshot.
+}
+
+// State is the share coordinator state rebuilt by replaying a partition of the share group state
+// topic.
+type State struct {
+	Partitions map[Key]*SharePartition
+	Orphaned   int // The number of updates without a snapshot or for an older snapshot epoch.
+
+	nextOffset int64
+}
+
+// NewState returns an empty state.
+func NewState() *State {
+	return &State{Partitions: make(map[Key]*SharePartition)}
+}
+
+// NextOffset returns the offset of the next batch to apply.
+func (s *State) NextOffset() int64 {
+	return s.nextOffset
+}
+
+// ApplyBatch replays a batch of the share group state topic. Batches below NextOffset were already
+// applied and are ignored.
+func (s *State) ApplyBatch(batch *records.RecordBatch) error {
+	if batch.NextOffset() <= s.nextOffset {
+		return nil
+	}
+
+	if !batch.IsControl() {
+		for _, record := range batch.Records {
+			if batch.Offset(record) < s.nextOffset {
+				continue
+			}
+
+			r, err := DecodeRecord(record.Key, record.Value)
+			if err != nil {
+				return fmt.Errorf("offset %d: %w", batch.Offset(record), err)
+			}
+			s.Apply(r)
+		}
+	}
+
+	s.nextOffset = batch.NextOffset()
+	return nil
+}
+
+// Apply replays a single record. A snapshot replaces the state of the share-partition, an update
+// is merged into it, and a snapshot tombstone deletes the share-partition.
+func (s *State) Apply(record Record) {
+	switch {
+	case record.Snapshot != nil:
+		snapshot := record.Snapshot
+		partition := &SharePartition{
+			SnapshotEpoch: snapshot.SnapshotEpoch,
+			StateEpoch:    snapshot.StateEpoch,
+			LeaderEpoch:   snapshot.LeaderEpoch,
+			StartOffset:   snapshot.StartOffset,
+		}
+		partition.merge(snapshot.StateBatches)
+		s.Partitions[record.Key] = partition
+
+	case record.Update != nil:
+		partition, ok := s.Partitions[record.Key]
+		if !ok || partition.SnapshotEpoch != record.Update.SnapshotEpoch {
+			s.Orphaned++
+			return
+		}
+
+		update := record.Update
+		if update.LeaderEpoch != -1 {
+			partition.LeaderEpoch = update.LeaderEpoch
+		}
+		if update.StartOffset != NoStartOffset {
+			partition.StartOffset = update.StartOffset
+		}
+		partition.merge(update.StateBatches)
+		partition.Updates++
+
+	case record.KeyVersion == ShareSnapshotKeyVersion:
+		delete(s.Partitions, record.Key)
+	}
+}
+
+// merge overlays the batches over the current state batches and drops the offsets below the start
+// offset.
+func (p *SharePartition) merge(batches []StateBatch) {
+	for _, batch := range batches {
+		merged := make([]StateBatch, 0, len(p.StateBatches)+2)
+		for _, existing := range p.StateBatches {
+			if existing.LastOffset < batch.FirstOffset || existing.FirstOffset > batch.LastOffset {
+				merged = append(merged, existing)
+				continue
+			}
+
+			if existing.FirstOffset < batch.FirstOffset {
+				left := existing
+				left.LastOffset = batch.FirstOffset - 1
+				merged = append(merged, left)
+			}
+			if existing.LastOffset > batch.LastOffset {
+				right := existing
+				right.FirstOffset = batch.LastOffset + 1
+				merged = append(merged, right)
+			}
+		}
+		p.StateBatches = append(merged, batch)
+	}
+
+	sort.Slice(p.StateBatches, func(i, j int) bool { return p.StateBatches[i].FirstOffset < p.StateBatches[j].FirstOffset })
+
+	if p.StartOffset == NoStartOffset {
+		return
+	}
+	trimmed := p.StateBatches[:0]
+	for _, batch := range p.StateBatches {
+		if batch.LastOffset < p.StartOffset {
+			continue
+		}
+		if batch.FirstOffset < p.StartOffset {
+			batch.FirstOffset = p.StartOffset
+		}
+		trimmed = append(trimmed, batch)
+	}
+	p.StateBatches = trimmed
+}
+
+// ReadResult returns the share-partition in the form a ReadShareGroupState response reports it,
+// for comparing the replayed state with what the share coordinator returns.
+func (p *SharePartition) ReadResult(partition int32) readsharegroupstate.ReadShareGroupStateResponseResultPartition {
+	batches := make([]readsharegroupstate.ReadShareGroupStateResponseResultPartitionStateBatche, 0, len(p.StateBatches))
+	for _, batch := range p.StateBatches {
+		batches = append(batches, readsharegroupstate.ReadShareGroupStateResponseResultPartitionStateBatche{
+			FirstOffset:   batch.FirstOffset,
+			LastOffset:    batch.LastOffset,
+			DeliveryState: batch.DeliveryState,
+			DeliveryCount: batch.DeliveryCount,
+		})
+	}
+
+	return readsharegroupstate.ReadShareGroupStateResponseResultPartition{
+		Partition:    partition,
+		StateEpoch:   p.StateEpoch,
+		StartOffset:  p.StartOffset,
+		StateBatches: &batches,
+	}
+}
@@ -0,0 +1,727 @@
+package telemetry
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/scholzj/go-kafka-protocol/api/pushtelemetry"
+	"github.com/scholzj/go-kafka-protocol/compression"
+)
+
+////////////////////
+// Metrics model (OTLP metrics.proto v1, as pushed by KIP-714 clients)
+////////////////////
+
+type MetricType int
+
+const (
+	MetricTypeUnknown MetricType = iota
+	MetricTypeGauge
+	MetricTypeSum
+	MetricTypeHistogram
+	MetricTypeExponentialHistogram
+	MetricTypeSummary
+)
+
+func (t MetricType) String() string {
+	switch t {
+	case MetricTypeGauge:
+		return "gauge"
+	case MetricTypeSum:
+		return "sum"
+	case MetricTypeHistogram:
+		return "histogram"
+	case MetricTypeExponentialHistogram:
+		return "exponential_histogram"
+	case MetricTypeSummary:
+		return "summary"
+	default:
+		return "unknown"
+	}
+}
+
+type AggregationTemporality int32
+
+const (
+	TemporalityUnspecified AggregationTemporality = 0
+	TemporalityDelta       AggregationTemporality = 1
+	TemporalityCumulative  AggregationTemporality = 2
+)
+
+// Attribute is an OTLP key/value pair. Value is one of string, bool, int64, float64, []byte, []any
+// (an array value) or []Attribute (a key/value list), or nil if the value is empty.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+type Attributes []Attribute
+
+// Get returns the value of the first attribute with the key.
+func (a Attributes) Get(key string) (any, bool) {
+	for _, attribute := range a {
+		if attribute.Key == key {
+			return attribute.Value, true
+		}
+	}
+	return nil, false
+}
+
+type MetricsData struct {
+	ResourceMetrics []ResourceMetrics
+}
+
+type ResourceMetrics struct {
+	Resource     Attributes
+	SchemaUrl    string
+	ScopeMetrics []ScopeMetrics
+}
+
+type ScopeMetrics struct {
+	ScopeName    string
+	ScopeVersion string
+	SchemaUrl    string
+	Metrics      []Metric
+}
+
+type Metric struct {
+	Name        string
+	Description string
+	Unit        string
+	Type        MetricType
+	Temporality AggregationTemporality // Sum, Histogram and ExponentialHistogram only
+	IsMonotonic bool                   // Sum only
+	DataPoints  []DataPoint
+}
+
+// DataPoint holds the fields of every OTLP data point type. Which of them are set depends on the
+// metric type: Gauge and Sum use the number value, the histogram and summary types their counts,
+// sums and buckets.
+type DataPoint struct {
+	Attributes        Attributes
+	StartTimeUnixNano uint64
+	TimeUnixNano      uint64
+	Flags             uint32
+
+	// Gauge and Sum
+	IsInt       bool
+	IntValue    int64
+	DoubleValue float64
+
+	// Histogram, ExponentialHistogram and Summary
+	Count uint64
+	Sum   *float64
+	Min   *float64
+	Max   *float64
+
+	// Histogram
+	BucketCounts   []uint64
+	ExplicitBounds []float64
+
+	// ExponentialHistogram
+	Scale         int32
+	ZeroCount     uint64
+	ZeroThreshold float64
+	Positive      ExponentialBuckets
+	Negative      ExponentialBuckets
+
+	// Summary
+	QuantileValues []QuantileValue
+}
+
+// Value returns the number value of a Gauge or Sum data point as a float64.
+func (p DataPoint) Value() float64 {
+	if p.IsInt {
+		return float64(p.IntValue)
+	}
+	return p.DoubleValue
+}
+
+type ExponentialBuckets struct {
+	Offset       int32
+	BucketCounts []uint64
+}
+
+type QuantileValue struct {
+	Quantile float64
+	Value    float64
+}
+
+////////////////////
+// Decoding
+////////////////////
+
+// DecodePushTelemetry decompresses and decodes the Metrics of a PushTelemetry request.
+func DecodePushTelemetry(req *pushtelemetry.PushTelemetryRequest) (*MetricsData, error) {
+	if req.Metrics == nil {
+		return &MetricsData{}, nil
+	}
+
+	return DecodeMetrics(compression.Type(req.CompressionType), *req.Metrics)
+}
+
+// DecodeMetrics decompresses data with the compression type and decodes it as an OTLP MetricsData
+// message.
+func DecodeMetrics(compressionType compression.Type, data []byte) (*MetricsData, error) {
+	decompressed, err := compression.Decompress(compressionType, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress metrics: %w", err)
+	}
+
+	return DecodeMetricsData(decompressed)
+}
+
+// DecodeMetricsData decodes an uncompressed OTLP MetricsData protobuf message.
+func DecodeMetricsData(data []byte) (*MetricsData, error) {
+	metrics := &MetricsData{}
+
+	r := &protoReader{data: data}
+	for !r.done() {
+		number, wireType, err := r.field()
+		if err != nil {
+			return nil, err
+		}
+
+		if number == 1 {
+			if err := expect(number, wireType, wireBytes); err != nil {
+				return nil, err
+			}
+			msg, err := r.bytes()
+			if err != nil {
+				return nil, err
+			}
+			resourceMetrics, err := decodeResourceMetrics(msg)
+			if err != nil {
+				return nil, err
+			}
+			metrics.ResourceMetrics = append(metrics.ResourceMetrics, resourceMetrics)
+		} else if err := r.skip(wireType); err != nil {
+			return nil, err
+		}
+	}
+
+	return metrics, nil
+}
+
+func decodeResourceMetrics(data []byte) (ResourceMetrics, error) {
+	resourceMetrics := ResourceMetrics{}
+
+	r := &protoReader{data: data}
+	for !r.done() {
+		number, wireType, err := r.field()
+		if err != nil {
+			return resourceMetrics, err
+		}
+
+		switch {
+		case number == 1 && wireType == wireBytes: // Resource
+			msg, err := r.bytes()
+			if err != nil {
+				return resourceMetrics, err
+			}
+			resourceMetrics.Resource, err = decodeAttributesMessage(msg, 1)
+			if err != nil {
+				return resourceMetrics, err
+			}
+		case number == 2 && wireType == wireBytes: // ScopeMetrics
+			msg, err := r.bytes()
+			if err != nil {
+				return resourceMetrics, err
+			}
+			scopeMetrics, err := decodeScopeMetrics(msg)
+			if err != nil {
+				return resourceMetrics, err
+			}
+			resourceMetrics.ScopeMetrics = append(resourceMetrics.ScopeMetrics, scopeMetrics)
+		case number == 3 && wireType == wireBytes:
+			resourceMetrics.SchemaUrl, err = r.string()
+		default:
+			err = r.skip(wireType)
+		}
+		if err != nil {
+			return resourceMetrics, err
+		}
+	}
+
+	return resourceMetrics, nil
+}
+
+func decodeScopeMetrics(data []byte) (ScopeMetrics, error) {
+	scopeMetrics := ScopeMetrics{}
+
+	r := &protoReader{data: data}
+	for !r.done() {
+		number, wireType, err := r.field()
+		if err != nil {
+			return scopeMetrics, err
+		}
+
+		switch {
+		case number == 1 && wireType == wireBytes: // InstrumentationScope
+			var msg []byte
+			msg, err = r.bytes()
+			if err == nil {
+				scopeMetrics.ScopeName, scopeMetrics.ScopeVersion, err = decodeScope(msg)
+			}
+		case number == 2 && wireType == wireBytes:
+			msg, err := r.bytes()
+			if err != nil {
+				return scopeMetrics, err
+			}
+			metric, err := decodeMetric(msg)
+			if err != nil {
+				return scopeMetrics, err
+			}
+			scopeMetrics.Metrics = append(scopeMetrics.Metrics, metric)
+		case number == 3 && wireType == wireBytes:
+			scopeMetrics.SchemaUrl, err = r.string()
+		default:
+			err = r.skip(wireType)
+		}
+		if err != nil {
+			return scopeMetrics, err
+		}
+	}
+
+	return scopeMetrics, nil
+}
+
+func decodeScope(data []byte) (string, string, error) {
+	var name, version string
+
+	r := &protoReader{data: data}
+	for !r.done() {
+		number, wireType, err := r.field()
+		if err != nil {
+			return name, version, err
+		}
+
+		switch {
+		case number == 1 && wireType == wireBytes:
+			name, err = r.string()
+		case number == 2 && wireType == wireBytes:
+			version, err = r.string()
+		default:
+			err = r.skip(wireType)
+		}
+		if err != nil {
+			return name, version, err
+		}
+	}
+
+	return name, version, nil
+}
+
+func decodeMetric(data []byte) (Metric, error) {
+	metric := Metric{}
+
+	r := &protoReader{data: data}
+	for !r.done() {
+		number, wireType, err := r.field()
+		if err != nil {
+			return metric, err
+		}
+
+		switch {
+		case number == 1 && wireType == wireBytes:
+			metric.Name, err = r.string()
+		case number == 2 && wireType == wireBytes:
+			metric.Description, err = r.string()
+		case number == 3 && wireType == wireBytes:
+			metric.Unit, err = r.string()
+		case number == 5 && wireType == wireBytes:
+			metric.Type = MetricTypeGauge
+			err = decodeMetricData(r, &metric)
+		case number == 7 && wireType == wireBytes:
+			metric.Type = MetricTypeSum
+			err = decodeMetricData(r, &metric)
+		case number == 9 && wireType == wireBytes:
+			metric.Type = MetricTypeHistogram
+			err = decodeMetricData(r, &metric)
+		case number == 10 && wireType == wireBytes:
+			metric.Type = MetricTypeExponentialHistogram
+			err = decodeMetricData(r, &metric)
+		case number == 11 && wireType == wireBytes:
+			metric.Type = MetricTypeSummary
+			err = decodeMetricData(r, &metric)
+		default:
+			err = r.skip(wireType)
+		}
+		if err != nil {
+			return metric, fmt.Errorf("metric %q: %w", metric.Name, err)
+		}
+	}
+
+	return metric, nil
+}
+
+// decodeMetricData decodes the Gauge, Sum, Histogram, ExponentialHistogram or Summary message. They
+// all carry the data points in field 1; Sum and the histograms add the temporality in field 2 and Sum
+// the monotonic flag in field 3.
+func decodeMetricData(parent *protoReader, metric *Metric) error {
+	data, err := parent.bytes()
+	if err != nil {
+		return err
+	}
+
+	r := &protoReader{data: data}
+	for !r.done() {
+		number, wireType, err := r.field()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case number == 1 && wireType == wireBytes:
+			msg, err := r.bytes()
+			if err != nil {
+				return err
+			}
+			point, err := decodeDataPoint(msg, metric.Type)
+			if err != nil {
+				return err
+			}
+			metric.DataPoints = append(metric.DataPoints, point)
+		case number == 2 && wireType == wireVarint && metric.Type != MetricTypeGauge && metric.Type != MetricTypeSummary:
+			v, err := r.varint()
+			if err != nil {
+				return err
+			}
+			metric.Temporality = AggregationTemporality(v)
+		case number == 3 && wireType == wireVarint && metric.Type == MetricTypeSum:
+			v, err := r.varint()
+			if err != nil {
+				return err
+			}
+			metric.IsMonotonic = v != 0
+		default:
+			if err := r.skip(wireType); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// dataPointFields maps the field numbers of the data point messages, which differ between the
+// metric types.
+type dataPointFields struct {
+	attributes, count, sum, flags, min, max int
+}
+
+var dataPointFieldNumbers = map[MetricType]dataPointFields{
+	MetricTypeGauge:                {attributes: 7, flags: 8},
+	MetricTypeSum:                  {attributes: 7, flags: 8},
+	MetricTypeHistogram:            {attributes: 9, count: 4, sum: 5, flags: 10, min: 11, max: 12},
+	MetricTypeExponentialHistogram: {attributes: 1, count: 4, sum: 5, flags: 10, min: 12, max: 13},
+	MetricTypeSummary:              {attributes: 7, count: 4, sum: 5, flags: 8},
+}
+
+func decodeDataPoint(data []byte, metricType MetricType) (DataPoint, error) {
+	point := DataPoint{}
+	fields := dataPointFieldNumbers[metricType]
+	isNumber := metricType == MetricTypeGauge || metricType == MetricTypeSum
+
+	r := &protoReader{data: data}
+	for !r.done() {
+		number, wireType, err := r.field()
+		if err != nil {
+			return point, err
+		}
+
+		switch {
+		case number == fields.attributes && wireType == wireBytes:
+			msg, err := r.bytes()
+			if err != nil {
+				return point, err
+			}
+			attribute, err := decodeKeyValue(msg)
+			if err != nil {
+				return point, err
+			}
+			point.Attributes = append(point.Attributes, attribute)
+		case number == 2 && wireType == wireFixed64:
+			point.StartTimeUnixNano, err = r.fixed64()
+		case number == 3 && wireType == wireFixed64:
+			point.TimeUnixNano, err = r.fixed64()
+		case number == fields.flags && wireType == wireVarint:
+			var v uint64
+			v, err = r.varint()
+			point.Flags = uint32(v)
+
+		case isNumber && number == 4 && wireType == wireFixed64:
+			point.DoubleValue, err = r.double()
+		case isNumber && number == 6 && wireType == wireFixed64:
+			var v uint64
+			v, err = r.fixed64()
+			point.IsInt = true
+			point.IntValue = int64(v)
+
+		case !isNumber && number == fields.count && wireType == wireFixed64:
+			point.Count, err = r.fixed64()
+		case !isNumber && number == fields.sum && wireType == wireFixed64:
+			point.Sum, err = optionalDouble(r)
+		case fields.min != 0 && number == fields.min && wireType == wireFixed64:
+			point.Min, err = optionalDouble(r)
+		case fields.max != 0 && number == fields.max && wireType == wireFixed64:
+			point.Max, err = optionalDouble(r)
+
+		case metricType == MetricTypeHistogram && number == 6:
+			var counts []uint64
+			counts, err = r.packedFixed64(wireType)
+			point.BucketCounts = append(point.BucketCounts, counts...)
+		case metricType == MetricTypeHistogram && number == 7:
+			var bounds []uint64
+			bounds, err = r.packedFixed64(wireType)
+			for _, bound := range bounds {
+				point.ExplicitBounds = append(point.ExplicitBounds, math.Float64frombits(bound))
+			}
+
+		case metricType == MetricTypeExponentialHistogram && number == 6 && wireType == wireVarint:
+			var v uint64
+			v, err = r.varint()
+			point.Scale = zigzag32(v)
+		case metricType == MetricTypeExponentialHistogram && number == 7 && wireType == wireFixed64:
+			point.ZeroCount, err = r.fixed64()
+		case metricType == MetricTypeExponentialHistogram && (number == 8 || number == 9) && wireType == wireBytes:
+			var msg []byte
+			msg, err = r.bytes()
+			if err == nil {
+				var buckets ExponentialBuckets
+				buckets, err = decodeBuckets(msg)
+				if number == 8 {
+					point.Positive = buckets
+				} else {
+					point.Negative = buckets
+				}
+			}
+		case metricType == MetricTypeExponentialHistogram && number == 14 && wireType == wireFixed64:
+			point.ZeroThreshold, err = r.double()
+
+		case metricType == MetricTypeSummary && number == 6 && wireType == wireBytes:
+			var msg []byte
+			msg, err = r.bytes()
+			if err == nil {
+				var quantile QuantileValue
+				quantile, err = decodeQuantile(msg)
+				point.QuantileValues = append(point.QuantileValues, quantile)
+			}
+
+		default:
+			err = r.skip(wireType)
+		}
+		if err != nil {
+			return point, err
+		}
+	}
+
+	return point, nil
+}
+
+func optionalDouble(r *protoReader) (*float64, error) {
+	v, err := r.double()
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func decodeBuckets(data []byte) (ExponentialBuckets, error) {
+	buckets := ExponentialBuckets{}
+
+	r := &protoReader{data: data}
+	for !r.done() {
+		number, wireType, err := r.field()
+		if err != nil {
+			return buckets, err
+		}
+
+		switch {
+		case number == 1 && wireType == wireVarint:
+			var v uint64
+			v, err = r.varint()
+			buckets.Offset = zigzag32(v)
+		case number == 2:
+			var counts []uint64
+			counts, err = r.packedVarint(wireType)
+			buckets.BucketCounts = append(buckets.BucketCounts, counts...)
+		default:
+			err = r.skip(wireType)
+		}
+		if err != nil {
+			return buckets, err
+		}
+	}
+
+	return buckets, nil
+}
+
+func decodeQuantile(data []byte) (QuantileValue, error) {
+	quantile := QuantileValue{}
+
+	r := &protoReader{data: data}
+	for !r.done() {
+		number, wireType, err := r.field()
+		if err != nil {
+			return quantile, err
+		}
+
+		switch {
+		case number == 1 && wireType == wireFixed64:
+			quantile.Quantile, err = r.double()
+		case number == 2 && wireType == wireFixed64:
+			quantile.Value, err = r.double()
+		default:
+			err = r.skip(wireType)
+		}
+		if err != nil {
+			return quantile, err
+		}
+	}
+
+	return quantile, nil
+}
+
+////////////////////
+// Attributes
+////////////////////
+
+// decodeAttributesMessage decodes the repeated KeyValue field of a message such as Resource.
+func decodeAttributesMessage(data []byte, field int) (Attributes, error) {
+	attributes := Attributes{}
+
+	r := &protoReader{data: data}
+	for !r.done() {
+		number, wireType, err := r.field()
+		if err != nil {
+			return nil, err
+		}
+
+		if number == field && wireType == wireBytes {
+			msg, err := r.bytes()
+			if err != nil {
+				return nil, err
+			}
+			attribute, err := decodeKeyValue(msg)
+			if err != nil {
+				return nil, err
+			}
+			attributes = append(attributes, attribute)
+		} else if err := r.skip(wireType); err != nil {
+			return nil, err
+		}
+	}
+
+	return attributes, nil
+}
+
+func decodeKeyValue(data []byte) (Attribute, error) {
+	attribute := Attribute{}
+
+	r := &protoReader{data: data}
+	for !r.done() {
+		number, wireType, err := r.field()
+		if err != nil {
+			return attribute, err
+		}
+
+		switch {
+		case number == 1 && wireType == wireBytes:
+			attribute.Key, err = r.string()
+		case number == 2 && wireType == wireBytes:
+			var msg []byte
+			msg, err = r.bytes()
+			if err == nil {
+				attribute.Value, err = decodeAnyValue(msg)
+			}
+		default:
+			err = r.skip(wireType)
+		}
+		if err != nil {
+			return attribute, err
+		}
+	}
+
+	return attribute, nil
+}
+
+func decodeAnyValue(data []byte) (any, error) {
+	var value any
+
+	r := &protoReader{data: data}
+	for !r.done() {
+		number, wireType, err := r.field()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case number == 1 && wireType == wireBytes:
+			value, err = r.string()
+		case number == 2 && wireType == wireVarint:
+			var v uint64
+			v, err = r.varint()
+			value = v != 0
+		case number == 3 && wireType == wireVarint:
+			var v uint64
+			v, err = r.varint()
+			value = int64(v)
+		case number == 4 && wireType == wireFixed64:
+			value, err = r.double()
+		case number == 5 && wireType == wireBytes: // ArrayValue
+			var msg []byte
+			msg, err = r.bytes()
+			if err == nil {
+				value, err = decodeArrayValue(msg)
+			}
+		case number == 6 && wireType == wireBytes: // KeyValueList
+			var msg []byte
+			msg, err = r.bytes()
+			if err == nil {
+				var list Attributes
+				list, err = decodeAttributesMessage(msg, 1)
+				value = []Attribute(list)
+			}
+		case number == 7 && wireType == wireBytes:
+			var v []byte
+			v, err = r.bytes()
+			value = append([]byte{}, v...)
+		default:
+			err = r.skip(wireType)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return value, nil
+}
+
+func decodeArrayValue(data []byte) ([]any, error) {
+	values := make([]any, 0)
+
+	r := &protoReader{data: data}
+	for !r.done() {
+		number, wireType, err := r.field()
+		if err != nil {
+			return nil, err
+		}
+
+		if number == 1 && wireType == wireBytes {
+			msg, err := r.bytes()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeAnyValue(msg)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		} else if err := r.skip(wireType); err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
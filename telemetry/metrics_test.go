@@ -0,0 +1,146 @@
+package telemetry
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/pushtelemetry"
+	"github.com/scholzj/go-kafka-protocol/compression"
+)
+
+// protoWriter builds protobuf messages for the tests.
+type protoWriter []byte
+
+func (w protoWriter) key(number int, wireType int) protoWriter {
+	return binary.AppendUvarint(w, uint64(number<<3|wireType))
+}
+
+func (w protoWriter) varint(number int, v uint64) protoWriter {
+	return binary.AppendUvarint(w.key(number, wireVarint), v)
+}
+
+func (w protoWriter) fixed64(number int, v uint64) protoWriter {
+	return binary.LittleEndian.AppendUint64(w.key(number, wireFixed64), v)
+}
+
+func (w protoWriter) double(number int, v float64) protoWriter {
+	return w.fixed64(number, math.Float64bits(v))
+}
+
+func (w protoWriter) bytes(number int, v []byte) protoWriter {
+	w = binary.AppendUvarint(w.key(number, wireBytes), uint64(len(v)))
+	return append(w, v...)
+}
+
+func (w protoWriter) string(number int, v string) protoWriter {
+	return w.bytes(number, []byte(v))
+}
+
+func keyValue(key string, value protoWriter) []byte {
+	return protoWriter{}.string(1, key).bytes(2, value)
+}
+
+func testMetricsData() []byte {
+	resource := protoWriter{}.
+		bytes(1, keyValue("client_id", protoWriter{}.string(1, "producer-1"))).
+		bytes(1, keyValue("client_software_version", protoWriter{}.string(1, "3.9.0")))
+
+	sumPoint := protoWriter{}.
+		fixed64(2, 1000).
+		fixed64(3, 2000).
+		double(4, 42.5).
+		bytes(7, keyValue("topic", protoWriter{}.string(1, "orders"))).
+		bytes(7, keyValue("partition", protoWriter{}.varint(3, 3)))
+	sum := protoWriter{}.bytes(1, sumPoint).varint(2, uint64(TemporalityCumulative)).varint(3, 1)
+
+	gaugePoint := protoWriter{}.fixed64(3, 2000).fixed64(6, uint64(7))
+	gauge := protoWriter{}.bytes(1, gaugePoint)
+
+	packedCounts := binary.LittleEndian.AppendUint64(binary.LittleEndian.AppendUint64(nil, 3), 5)
+	packedBounds := binary.LittleEndian.AppendUint64(nil, math.Float64bits(10))
+	histogramPoint := protoWriter{}.
+		fixed64(4, 8).
+		double(5, 55).
+		bytes(6, packedCounts).
+		bytes(7, packedBounds).
+		double(11, 1).
+		double(12, 20)
+	histogram := protoWriter{}.bytes(1, histogramPoint).varint(2, uint64(TemporalityDelta))
+
+	metrics := protoWriter{}.
+		bytes(2, protoWriter{}.string(1, "org.apache.kafka.producer.record.send.total").string(3, "1").bytes(7, sum)).
+		bytes(2, protoWriter{}.string(1, "org.apache.kafka.producer.buffer.pool.wait").bytes(5, gauge)).
+		bytes(2, protoWriter{}.string(1, "org.apache.kafka.producer.request.latency").bytes(9, histogram).
+			varint(99, 1)) // an unknown field added by a newer OTLP version
+	scope := protoWriter{}.bytes(1, protoWriter{}.string(1, "kafka-client").string(2, "1.0"))
+
+	resourceMetrics := protoWriter{}.bytes(1, resource).bytes(2, append(scope, metrics...))
+	return protoWriter{}.bytes(1, resourceMetrics)
+}
+
+func TestDecodePushTelemetry(t *testing.T) {
+	compressed, err := compression.Compress(compression.Gzip, testMetricsData())
+	if err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+
+	req := &pushtelemetry.PushTelemetryRequest{
+		ClientInstanceId: uuid.New(),
+		CompressionType:  int8(compression.Gzip),
+		Metrics:          &compressed,
+	}
+
+	data, err := DecodePushTelemetry(req)
+	if err != nil {
+		t.Fatalf("DecodePushTelemetry: %v", err)
+	}
+
+	if len(data.ResourceMetrics) != 1 {
+		t.Fatalf("got %d resource metrics", len(data.ResourceMetrics))
+	}
+	resourceMetrics := data.ResourceMetrics[0]
+	if clientId, _ := resourceMetrics.Resource.Get("client_id"); clientId != "producer-1" {
+		t.Errorf("client_id = %v", clientId)
+	}
+
+	scope := resourceMetrics.ScopeMetrics[0]
+	if scope.ScopeName != "kafka-client" || scope.ScopeVersion != "1.0" || len(scope.Metrics) != 3 {
+		t.Fatalf("unexpected scope %+v", scope)
+	}
+
+	sum := scope.Metrics[0]
+	if sum.Type != MetricTypeSum || !sum.IsMonotonic || sum.Temporality != TemporalityCumulative || sum.Unit != "1" {
+		t.Errorf("unexpected sum %+v", sum)
+	}
+	point := sum.DataPoints[0]
+	if point.Value() != 42.5 || point.StartTimeUnixNano != 1000 || point.TimeUnixNano != 2000 {
+		t.Errorf("unexpected sum point %+v", point)
+	}
+	if partition, _ := point.Attributes.Get("partition"); partition != int64(3) {
+		t.Errorf("partition attribute = %#v", partition)
+	}
+
+	gauge := scope.Metrics[1]
+	if gauge.Type != MetricTypeGauge || !gauge.DataPoints[0].IsInt || gauge.DataPoints[0].Value() != 7 {
+		t.Errorf("unexpected gauge %+v", gauge)
+	}
+
+	histogram := scope.Metrics[2]
+	hp := histogram.DataPoints[0]
+	if histogram.Type != MetricTypeHistogram || histogram.Temporality != TemporalityDelta || hp.Count != 8 || *hp.Sum != 55 || *hp.Min != 1 || *hp.Max != 20 {
+		t.Errorf("unexpected histogram %+v", histogram)
+	}
+	if !reflect.DeepEqual(hp.BucketCounts, []uint64{3, 5}) || !reflect.DeepEqual(hp.ExplicitBounds, []float64{10}) {
+		t.Errorf("unexpected buckets %v / %v", hp.BucketCounts, hp.ExplicitBounds)
+	}
+}
+
+func TestDecodeMetricsDataRejectsTruncatedInput(t *testing.T) {
+	data := testMetricsData()
+	if _, err := DecodeMetricsData(data[:len(data)-3]); err == nil {
+		t.Error("DecodeMetricsData must fail on truncated data")
+	}
+}
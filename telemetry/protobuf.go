@@ -0,0 +1,171 @@
+package telemetry
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// A minimal protobuf wire format reader, just enough to walk the OTLP metrics messages without
+// depending on a protobuf runtime or generated code.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated protobuf message")
+
+type protoReader struct {
+	data []byte
+}
+
+func (r *protoReader) done() bool {
+	return len(r.data) == 0
+}
+
+func (r *protoReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	r.data = r.data[n:]
+	return v, nil
+}
+
+// field reads the next field key and returns its number and wire type.
+func (r *protoReader) field() (int, int, error) {
+	key, err := r.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	number := int(key >> 3)
+	if number <= 0 {
+		return 0, 0, fmt.Errorf("invalid protobuf field number %d", number)
+	}
+
+	return number, int(key & 0x07), nil
+}
+
+func (r *protoReader) fixed64() (uint64, error) {
+	if len(r.data) < 8 {
+		return 0, errTruncated
+	}
+	v := binary.LittleEndian.Uint64(r.data)
+	r.data = r.data[8:]
+	return v, nil
+}
+
+func (r *protoReader) fixed32() (uint32, error) {
+	if len(r.data) < 4 {
+		return 0, errTruncated
+	}
+	v := binary.LittleEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return v, nil
+}
+
+func (r *protoReader) double() (float64, error) {
+	v, err := r.fixed64()
+	return math.Float64frombits(v), err
+}
+
+func (r *protoReader) bytes() ([]byte, error) {
+	length, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(len(r.data)) {
+		return nil, errTruncated
+	}
+
+	v := r.data[:length]
+	r.data = r.data[length:]
+	return v, nil
+}
+
+func (r *protoReader) string() (string, error) {
+	v, err := r.bytes()
+	return string(v), err
+}
+
+// skip skips the value of a field with the given wire type. Unknown fields are skipped so that
+// newer OTLP versions can still be decoded.
+func (r *protoReader) skip(wireType int) error {
+	var err error
+	switch wireType {
+	case wireVarint:
+		_, err = r.varint()
+	case wireFixed64:
+		_, err = r.fixed64()
+	case wireBytes:
+		_, err = r.bytes()
+	case wireFixed32:
+		_, err = r.fixed32()
+	default:
+		err = fmt.Errorf("unsupported protobuf wire type %d", wireType)
+	}
+	return err
+}
+
+// packedFixed64 reads a repeated fixed64 field, which may be packed (wire type 2) or not.
+func (r *protoReader) packedFixed64(wireType int) ([]uint64, error) {
+	if wireType == wireFixed64 {
+		v, err := r.fixed64()
+		return []uint64{v}, err
+	}
+
+	data, err := r.bytes()
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%8 != 0 {
+		return nil, errTruncated
+	}
+
+	values := make([]uint64, 0, len(data)/8)
+	for i := 0; i < len(data); i += 8 {
+		values = append(values, binary.LittleEndian.Uint64(data[i:]))
+	}
+	return values, nil
+}
+
+// packedVarint reads a repeated varint field, which may be packed (wire type 2) or not.
+func (r *protoReader) packedVarint(wireType int) ([]uint64, error) {
+	if wireType == wireVarint {
+		v, err := r.varint()
+		return []uint64{v}, err
+	}
+
+	data, err := r.bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	packed := &protoReader{data: data}
+	values := make([]uint64, 0)
+	for !packed.done() {
+		v, err := packed.varint()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// expect checks the wire type of a known field.
+func expect(number int, wireType int, want int) error {
+	if wireType != want {
+		return fmt.Errorf("protobuf field %d has wire type %d, want %d", number, wireType, want)
+	}
+	return nil
+}
+
+func zigzag32(v uint64) int32 {
+	return int32(uint32(v)>>1) ^ -int32(uint32(v)&1)
+}
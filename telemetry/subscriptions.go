@@ -0,0 +1,380 @@
+package telemetry
+
+import (
+	"fmt"
+	"hash/crc32"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/gettelemetrysubscriptions"
+	"github.com/scholzj/go-kafka-protocol/api/pushtelemetry"
+	"github.com/scholzj/go-kafka-protocol/compression"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+// Defaults of the broker-side client metrics configuration.
+const (
+	DefaultPushIntervalMs    int32 = 5 * 60 * 1000
+	DefaultTelemetryMaxBytes int32 = 1024 * 1024
+)
+
+// Client selectors a subscription can match on (the keys of the client metrics "match" config).
+const (
+	MatchClientInstanceId      = "client_instance_id"
+	MatchClientId              = "client_id"
+	MatchClientSoftwareName    = "client_software_name"
+	MatchClientSoftwareVersion = "client_software_version"
+	MatchClientSourceAddress   = "client_source_address"
+	MatchClientSourcePort      = "client_source_port"
+)
+
+// ClientInfo is what the broker knows about the connection a telemetry request arrived on: the client
+// id from the request header, the software name and version from the ApiVersions request, and the
+// peer address.
+type ClientInfo struct {
+	ClientId        string
+	SoftwareName    string
+	SoftwareVersion string
+	SourceAddress   string
+	SourcePort      int
+}
+
+// Subscription is a client metrics subscription: which metrics (by name prefix) matching clients push
+// and how often. A subscription without match patterns applies to every client.
+type Subscription struct {
+	Name           string
+	Metrics        []string // Metric name prefixes; a single empty prefix subscribes to all metrics
+	PushIntervalMs int32
+	match          map[string]*regexp.Regexp
+}
+
+// NewSubscription creates a subscription. The match patterns are regular expressions keyed by the
+// Match* selectors, and have to match the whole selector value.
+func NewSubscription(name string, metrics []string, pushIntervalMs int32, match map[string]string) (*Subscription, error) {
+	if pushIntervalMs <= 0 {
+		return nil, fmt.Errorf("invalid push interval %d ms", pushIntervalMs)
+	}
+
+	subscription := &Subscription{
+		Name:           name,
+		Metrics:        append([]string{}, metrics...),
+		PushIntervalMs: pushIntervalMs,
+		match:          make(map[string]*regexp.Regexp, len(match)),
+	}
+
+	for selector, pattern := range match {
+		switch selector {
+		case MatchClientInstanceId, MatchClientId, MatchClientSoftwareName, MatchClientSoftwareVersion, MatchClientSourceAddress, MatchClientSourcePort:
+		default:
+			return nil, fmt.Errorf("unknown client match selector %q", selector)
+		}
+
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for %s: %w", selector, err)
+		}
+		subscription.match[selector] = re
+	}
+
+	return subscription, nil
+}
+
+func (s *Subscription) matches(instanceId uuid.UUID, client ClientInfo) bool {
+	values := map[string]string{
+		MatchClientInstanceId:      instanceId.String(),
+		MatchClientId:              client.ClientId,
+		MatchClientSoftwareName:    client.SoftwareName,
+		MatchClientSoftwareVersion: client.SoftwareVersion,
+		MatchClientSourceAddress:   client.SourceAddress,
+		MatchClientSourcePort:      strconv.Itoa(client.SourcePort),
+	}
+
+	for selector, re := range s.match {
+		if !re.MatchString(values[selector]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// clientInstance is the state kept per client instance id.
+type clientInstance struct {
+	subscriptionId  int32
+	metrics         []string
+	pushIntervalMs  int32
+	computedVersion int
+	lastGet         time.Time
+	lastPush        time.Time
+	lastActivity    time.Time
+	terminating     bool
+	lastError       int16
+}
+
+// SubscriptionManager answers GetTelemetrySubscriptions requests from the configured subscriptions
+// and validates PushTelemetry requests against the subscription each client instance received, the
+// way a KIP-714 broker does. It is safe for concurrent use.
+type SubscriptionManager struct {
+	// Now is the clock used for the push interval checks. It defaults to time.Now.
+	Now func() time.Time
+	// AcceptedCompressionTypes are offered to clients, in order of preference.
+	AcceptedCompressionTypes []compression.Type
+	TelemetryMaxBytes        int32
+
+	mu            sync.Mutex
+	subscriptions map[string]*Subscription
+	version       int
+	instances     map[uuid.UUID]*clientInstance
+}
+
+func NewSubscriptionManager() *SubscriptionManager {
+	return &SubscriptionManager{
+		Now:                      time.Now,
+		AcceptedCompressionTypes: []compression.Type{compression.Lz4, compression.Gzip, compression.Snappy},
+		TelemetryMaxBytes:        DefaultTelemetryMaxBytes,
+		subscriptions:            make(map[string]*Subscription),
+		instances:                make(map[uuid.UUID]*clientInstance),
+	}
+}
+
+// SetSubscription adds or replaces a subscription. Clients whose subscription changes get
+// UNKNOWN_SUBSCRIPTION_ID on their next push and fetch the new one.
+func (m *SubscriptionManager) SetSubscription(subscription *Subscription) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.subscriptions[subscription.Name] = subscription
+	m.version++
+}
+
+func (m *SubscriptionManager) RemoveSubscription(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.subscriptions[name]; ok {
+		delete(m.subscriptions, name)
+		m.version++
+	}
+}
+
+// GetTelemetrySubscriptions handles a GetTelemetrySubscriptions request. A request with a zero client
+// instance id gets a new id assigned.
+func (m *SubscriptionManager) GetTelemetrySubscriptions(req *gettelemetrysubscriptions.GetTelemetrySubscriptionsRequest, client ClientInfo) *gettelemetrysubscriptions.GetTelemetrySubscriptionsResponse {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.Now()
+	m.expireInstances(now)
+
+	res := &gettelemetrysubscriptions.GetTelemetrySubscriptionsResponse{
+		ApiVersion:               req.ApiVersion,
+		AcceptedCompressionTypes: &[]int8{},
+		RequestedMetrics:         &[]string{},
+	}
+
+	instanceId := req.ClientInstanceId
+	if instanceId == uuid.Nil {
+		instanceId = m.newInstanceId()
+		res.ClientInstanceId = instanceId
+	}
+
+	instance, ok := m.instances[instanceId]
+	if !ok {
+		instance = &clientInstance{computedVersion: -1}
+		m.instances[instanceId] = instance
+	} else if instance.lastError != errorcodes.UnknownSubscriptionId && instance.lastError != errorcodes.UnsupportedCompressionType &&
+		now.Sub(instance.lastGet) < time.Duration(instance.pushIntervalMs)*time.Millisecond {
+		// Clients may only re-fetch their subscription once per push interval, unless the last push
+		// told them to.
+		res.ErrorCode = errorcodes.ThrottlingQuotaExceeded
+		return res
+	}
+
+	m.refresh(instanceId, instance, client)
+	instance.lastGet = now
+	instance.lastActivity = now
+	instance.lastError = errorcodes.None
+
+	res.SubscriptionId = instance.subscriptionId
+	res.PushIntervalMs = instance.pushIntervalMs
+	res.TelemetryMaxBytes = m.TelemetryMaxBytes
+	res.DeltaTemporality = true
+	metrics := append([]string{}, instance.metrics...)
+	res.RequestedMetrics = &metrics
+	accepted := make([]int8, 0, len(m.AcceptedCompressionTypes))
+	for _, t := range m.AcceptedCompressionTypes {
+		accepted = append(accepted, int8(t))
+	}
+	res.AcceptedCompressionTypes = &accepted
+
+	return res
+}
+
+// PushTelemetry validates a PushTelemetry request against the client's subscription and decodes the
+// metrics. The metrics are nil whenever the response carries an error.
+func (m *SubscriptionManager) PushTelemetry(req *pushtelemetry.PushTelemetryRequest, client ClientInfo) (*pushtelemetry.PushTelemetryResponse, *MetricsData) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.Now()
+	m.expireInstances(now)
+
+	res := &pushtelemetry.PushTelemetryResponse{ApiVersion: req.ApiVersion}
+	fail := func(instance *clientInstance, code int16) (*pushtelemetry.PushTelemetryResponse, *MetricsData) {
+		if instance != nil {
+			instance.lastError = code
+		}
+		res.ErrorCode = code
+		return res, nil
+	}
+
+	if req.ClientInstanceId == uuid.Nil {
+		return fail(nil, errorcodes.InvalidRequest)
+	}
+
+	instance, ok := m.instances[req.ClientInstanceId]
+	if !ok {
+		return fail(nil, errorcodes.UnknownSubscriptionId)
+	}
+	instance.lastActivity = now
+
+	if instance.terminating {
+		return fail(instance, errorcodes.InvalidRequest)
+	}
+
+	m.refresh(req.ClientInstanceId, instance, client)
+	if req.SubscriptionId != instance.subscriptionId {
+		return fail(instance, errorcodes.UnknownSubscriptionId)
+	}
+
+	if !req.Terminating && !instance.lastPush.IsZero() && now.Sub(instance.lastPush) < time.Duration(instance.pushIntervalMs)*time.Millisecond {
+		return fail(instance, errorcodes.ThrottlingQuotaExceeded)
+	}
+
+	if !m.accepts(compression.Type(req.CompressionType)) {
+		return fail(instance, errorcodes.UnsupportedCompressionType)
+	}
+
+	if req.Metrics != nil && len(*req.Metrics) > int(m.TelemetryMaxBytes) {
+		return fail(instance, errorcodes.TelemetryTooLarge)
+	}
+
+	instance.lastPush = now
+	instance.terminating = req.Terminating
+	instance.lastError = errorcodes.None
+
+	metrics, err := DecodePushTelemetry(req)
+	if err != nil {
+		return fail(instance, errorcodes.InvalidRecord)
+	}
+
+	return res, metrics
+}
+
+// refresh recomputes the client's subscription when the subscriptions changed since it was computed.
+func (m *SubscriptionManager) refresh(instanceId uuid.UUID, instance *clientInstance, client ClientInfo) {
+	if instance.computedVersion == m.version {
+		return
+	}
+
+	names := make([]string, 0, len(m.subscriptions))
+	for name := range m.subscriptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metrics := make([]string, 0)
+	seen := make(map[string]bool)
+	pushIntervalMs := int32(0)
+	for _, name := range names {
+		subscription := m.subscriptions[name]
+		if !subscription.matches(instanceId, client) {
+			continue
+		}
+
+		for _, metric := range subscription.Metrics {
+			if !seen[metric] {
+				seen[metric] = true
+				metrics = append(metrics, metric)
+			}
+		}
+		if pushIntervalMs == 0 || subscription.PushIntervalMs < pushIntervalMs {
+			pushIntervalMs = subscription.PushIntervalMs
+		}
+	}
+
+	if pushIntervalMs == 0 {
+		pushIntervalMs = DefaultPushIntervalMs
+	}
+	// An empty prefix subscribes to everything; the other prefixes are then redundant.
+	if seen[""] {
+		metrics = []string{""}
+	}
+
+	id := subscriptionId(instanceId, metrics, pushIntervalMs)
+	if id != instance.subscriptionId {
+		// A new subscription starts a new push cycle.
+		instance.lastPush = time.Time{}
+	}
+
+	instance.metrics = metrics
+	instance.pushIntervalMs = pushIntervalMs
+	instance.computedVersion = m.version
+	instance.subscriptionId = id
+}
+
+// subscriptionId derives the id from the subscription content, so that it changes whenever the
+// metrics or the push interval of the client change.
+func subscriptionId(instanceId uuid.UUID, metrics []string, pushIntervalMs int32) int32 {
+	content := strings.Join(metrics, ",") + "|" + strconv.Itoa(int(pushIntervalMs)) + "|" + instanceId.String()
+	return int32(crc32.Checksum([]byte(content), crc32.MakeTable(crc32.Castagnoli)))
+}
+
+func (m *SubscriptionManager) accepts(t compression.Type) bool {
+	if t == compression.None {
+		return true
+	}
+
+	for _, accepted := range m.AcceptedCompressionTypes {
+		if accepted == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *SubscriptionManager) newInstanceId() uuid.UUID {
+	for {
+		id := uuid.New()
+		if _, ok := m.instances[id]; !ok {
+			return id
+		}
+	}
+}
+
+// expireInstances forgets client instances which have been quiet for three push intervals.
+func (m *SubscriptionManager) expireInstances(now time.Time) {
+	for id, instance := range m.instances {
+		if now.Sub(instance.lastActivity) > 3*time.Duration(instance.pushIntervalMs)*time.Millisecond {
+			delete(m.instances, id)
+		}
+	}
+}
+
+// SubscribedTo reports whether a metric name is covered by the requested metric prefixes of a
+// subscription.
+func SubscribedTo(requestedMetrics []string, metricName string) bool {
+	for _, prefix := range requestedMetrics {
+		if strings.HasPrefix(metricName, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
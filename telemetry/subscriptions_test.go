@@ -0,0 +1,118 @@
+package telemetry
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/scholzj/go-kafka-protocol/api/gettelemetrysubscriptions"
+	"github.com/scholzj/go-kafka-protocol/api/pushtelemetry"
+	"github.com/scholzj/go-kafka-protocol/compression"
+	"github.com/scholzj/go-kafka-protocol/errorcodes"
+)
+
+func TestSubscriptionManager(t *testing.T) {
+	now := time.Unix(1000, 0)
+	manager := NewSubscriptionManager()
+	manager.Now = func() time.Time { return now }
+
+	producers, err := NewSubscription("producers", []string{"org.apache.kafka.producer."}, 60000, map[string]string{MatchClientId: "producer-.*"})
+	if err != nil {
+		t.Fatalf("NewSubscription: %v", err)
+	}
+	java, err := NewSubscription("java", []string{"org.apache.kafka.client."}, 30000, map[string]string{MatchClientSoftwareName: "apache-kafka-java"})
+	if err != nil {
+		t.Fatalf("NewSubscription: %v", err)
+	}
+	manager.SetSubscription(producers)
+	manager.SetSubscription(java)
+
+	client := ClientInfo{ClientId: "producer-1", SoftwareName: "apache-kafka-java", SoftwareVersion: "3.9.0", SourceAddress: "10.0.0.1", SourcePort: 50000}
+
+	res := manager.GetTelemetrySubscriptions(&gettelemetrysubscriptions.GetTelemetrySubscriptionsRequest{}, client)
+	if res.ErrorCode != errorcodes.None || res.ClientInstanceId == uuid.Nil {
+		t.Fatalf("GetTelemetrySubscriptions: error=%d instance=%s", res.ErrorCode, res.ClientInstanceId)
+	}
+	if !reflect.DeepEqual(*res.RequestedMetrics, []string{"org.apache.kafka.client.", "org.apache.kafka.producer."}) || res.PushIntervalMs != 30000 {
+		t.Errorf("RequestedMetrics=%v PushIntervalMs=%d", *res.RequestedMetrics, res.PushIntervalMs)
+	}
+	instanceId := res.ClientInstanceId
+
+	// Fetching the subscription again within the push interval is throttled.
+	again := manager.GetTelemetrySubscriptions(&gettelemetrysubscriptions.GetTelemetrySubscriptionsRequest{ClientInstanceId: instanceId}, client)
+	if again.ErrorCode != errorcodes.ThrottlingQuotaExceeded {
+		t.Errorf("early GetTelemetrySubscriptions error = %d, want THROTTLING_QUOTA_EXCEEDED", again.ErrorCode)
+	}
+
+	metrics, _ := compression.Compress(compression.Lz4, testMetricsData())
+	push := &pushtelemetry.PushTelemetryRequest{
+		ClientInstanceId: instanceId,
+		SubscriptionId:   res.SubscriptionId,
+		CompressionType:  int8(compression.Lz4),
+		Metrics:          &metrics,
+	}
+
+	pushRes, data := manager.PushTelemetry(push, client)
+	if pushRes.ErrorCode != errorcodes.None || data == nil || len(data.ResourceMetrics) != 1 {
+		t.Fatalf("PushTelemetry: error=%d data=%v", pushRes.ErrorCode, data)
+	}
+
+	if pushRes, _ = manager.PushTelemetry(push, client); pushRes.ErrorCode != errorcodes.ThrottlingQuotaExceeded {
+		t.Errorf("early push error = %d, want THROTTLING_QUOTA_EXCEEDED", pushRes.ErrorCode)
+	}
+
+	// Changing the subscriptions invalidates the subscription id the client holds.
+	manager.RemoveSubscription("java")
+	now = now.Add(30 * time.Second)
+	if pushRes, _ = manager.PushTelemetry(push, client); pushRes.ErrorCode != errorcodes.UnknownSubscriptionId {
+		t.Fatalf("push with a stale subscription error = %d, want UNKNOWN_SUBSCRIPTION_ID", pushRes.ErrorCode)
+	}
+
+	// After UNKNOWN_SUBSCRIPTION_ID the client may re-fetch right away.
+	res = manager.GetTelemetrySubscriptions(&gettelemetrysubscriptions.GetTelemetrySubscriptionsRequest{ClientInstanceId: instanceId}, client)
+	if res.ErrorCode != errorcodes.None || res.PushIntervalMs != 60000 || res.ClientInstanceId != uuid.Nil {
+		t.Fatalf("re-fetch: error=%d interval=%d instance=%s", res.ErrorCode, res.PushIntervalMs, res.ClientInstanceId)
+	}
+	push.SubscriptionId = res.SubscriptionId
+
+	push.CompressionType = int8(compression.Zstd)
+	if pushRes, _ = manager.PushTelemetry(push, client); pushRes.ErrorCode != errorcodes.UnsupportedCompressionType {
+		t.Errorf("zstd push error = %d, want UNSUPPORTED_COMPRESSION_TYPE", pushRes.ErrorCode)
+	}
+
+	// A terminating push is accepted even within the push interval, but nothing may follow it.
+	push.CompressionType = int8(compression.Lz4)
+	push.Terminating = true
+	if pushRes, _ = manager.PushTelemetry(push, client); pushRes.ErrorCode != errorcodes.None {
+		t.Fatalf("terminating push error = %d", pushRes.ErrorCode)
+	}
+	now = now.Add(time.Minute)
+	if pushRes, _ = manager.PushTelemetry(push, client); pushRes.ErrorCode != errorcodes.InvalidRequest {
+		t.Errorf("push after terminating error = %d, want INVALID_REQUEST", pushRes.ErrorCode)
+	}
+}
+
+func TestSubscriptionMatching(t *testing.T) {
+	manager := NewSubscriptionManager()
+	subscription, _ := NewSubscription("all", []string{""}, 10000, map[string]string{MatchClientSourceAddress: `10\.0\..*`})
+	manager.SetSubscription(subscription)
+
+	matching := manager.GetTelemetrySubscriptions(&gettelemetrysubscriptions.GetTelemetrySubscriptionsRequest{}, ClientInfo{SourceAddress: "10.0.3.4"})
+	if !reflect.DeepEqual(*matching.RequestedMetrics, []string{""}) || matching.PushIntervalMs != 10000 {
+		t.Errorf("matching client: metrics=%v interval=%d", *matching.RequestedMetrics, matching.PushIntervalMs)
+	}
+
+	other := manager.GetTelemetrySubscriptions(&gettelemetrysubscriptions.GetTelemetrySubscriptionsRequest{}, ClientInfo{SourceAddress: "192.168.0.1"})
+	if len(*other.RequestedMetrics) != 0 || other.PushIntervalMs != DefaultPushIntervalMs {
+		t.Errorf("other client: metrics=%v interval=%d", *other.RequestedMetrics, other.PushIntervalMs)
+	}
+
+	if _, err := NewSubscription("bad", nil, 1000, map[string]string{"client_rack": ".*"}); err == nil {
+		t.Error("NewSubscription must reject unknown selectors")
+	}
+
+	if !SubscribedTo([]string{"org.apache.kafka.producer."}, "org.apache.kafka.producer.record.send.total") || SubscribedTo([]string{}, "x") {
+		t.Error("SubscribedTo does not match by prefix")
+	}
+}
@@ -0,0 +1,264 @@
+package transactionstate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/scholzj/go-kafka-protocol/protocol"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// The records of the __transaction_state topic written by the transaction coordinator. The key
+// is the int16 key version 0 and the transactional id, the value is the int16 value version and
+// the transaction metadata. A null value is a tombstone for an expired transactional id.
+
+// Topic is the name of the internal transaction state topic.
+const Topic = "__transaction_state"
+
+const (
+	// KeyVersion is the only version of TransactionLogKey.
+	KeyVersion int16 = 0
+
+	// HighestSupportedValueVersion is the highest supported version of TransactionLogValue.
+	// Version 1 is the first flexible version.
+	HighestSupportedValueVersion int16 = 1
+)
+
+// NoProducerId is the producer id of the tagged producer id fields when they are not set.
+const NoProducerId int64 = -1
+
+var ErrUnsupportedVersion = errors.New("unsupported transaction log version")
+
+// TransactionStatus is the state of a transaction.
+type TransactionStatus int8
+
+const (
+	Empty             TransactionStatus = 0
+	Ongoing           TransactionStatus = 1
+	PrepareCommit     TransactionStatus = 2
+	PrepareAbort      TransactionStatus = 3
+	CompleteCommit    TransactionStatus = 4
+	CompleteAbort     TransactionStatus = 5
+	Dead              TransactionStatus = 6
+	PrepareEpochFence TransactionStatus = 7
+)
+
+func (s TransactionStatus) String() string {
+	switch s {
+	case Empty:
+		return "Empty"
+	case Ongoing:
+		return "Ongoing"
+	case PrepareCommit:
+		return "PrepareCommit"
+	case PrepareAbort:
+		return "PrepareAbort"
+	case CompleteCommit:
+		return "CompleteCommit"
+	case CompleteAbort:
+		return "CompleteAbort"
+	case Dead:
+		return "Dead"
+	case PrepareEpochFence:
+		return "PrepareEpochFence"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int8(s))
+	}
+}
+
+// InProgress returns true for the states of a transaction which has not completed yet.
+func (s TransactionStatus) InProgress() bool {
+	return s == Ongoing || s == PrepareCommit || s == PrepareAbort || s == PrepareEpochFence
+}
+
+// TransactionLogKey is the key of a transaction log record.
+type TransactionLogKey struct {
+	TransactionalId string // The transactional id. (versions: 0)
+}
+
+// TransactionLogValue is the state of a transaction.
+type TransactionLogValue struct {
+	ProducerId                       int64                  // The producer id. (versions: 0+)
+	PreviousProducerId               int64                  // tag 0: The producer id before it was bumped by the last epoch exhaustion. (versions: 1+, default: -1)
+	NextProducerId                   int64                  // tag 1: The producer id the transaction continues with after the current one completes. (versions: 1+, default: -1)
+	ProducerEpoch                    int16                  // The producer epoch. (versions: 0+)
+	TransactionTimeoutMs             int32                  // The transaction timeout. (versions: 0+)
+	TransactionStatus                TransactionStatus      // The transaction state. (versions: 0+)
+	TransactionPartitions            []TransactionPartition // The partitions in the transaction, or null. (versions: 0+, nullable: 0+)
+	TransactionLastUpdateTimestampMs int64                  // The time of the last update of the transaction. (versions: 0+)
+	TransactionStartTimestampMs      int64                  // The time the transaction started. (versions: 0+)
+	ClientTransactionVersion         int16                  // tag 2: The transaction protocol version used by the client. (versions: 1+)
+}
+
+// TransactionPartition are the partitions of a topic in a transaction.
+type TransactionPartition struct {
+	Topic        string  // The topic name. (versions: 0+)
+	PartitionIds []int32 // The partition indexes. (versions: 0+)
+}
+
+func (m *TransactionLogValue) decode(d *protocol.Decoder, version int16) {
+	m.ProducerId = d.Int64()
+	m.PreviousProducerId = NoProducerId
+	m.NextProducerId = NoProducerId
+	m.ProducerEpoch = d.Int16()
+	m.TransactionTimeoutMs = d.Int32()
+	m.TransactionStatus = TransactionStatus(d.Int8())
+	m.TransactionPartitions = protocol.DecodeArray(d, func(d *protocol.Decoder) TransactionPartition {
+		var partition TransactionPartition
+		partition.Topic = d.String()
+		partition.PartitionIds = protocol.DecodeArray(d, (*protocol.Decoder).Int32)
+		d.TaggedFields(noTaggedFields)
+		return partition
+	})
+	m.TransactionLastUpdateTimestampMs = d.Int64()
+	m.TransactionStartTimestampMs = d.Int64()
+	d.TaggedFields(func(d *protocol.Decoder, tag uint64) bool {
+		switch tag {
+		case 0:
+			m.PreviousProducerId = d.Int64()
+		case 1:
+			m.NextProducerId = d.Int64()
+		case 2:
+			m.ClientTransactionVersion = d.Int16()
+		default:
+			return false
+		}
+		return true
+	})
+}
+
+func (m *TransactionLogValue) encode(e *protocol.Encoder, version int16) {
+	e.Int64(m.ProducerId)
+	e.Int16(m.ProducerEpoch)
+	e.Int32(m.TransactionTimeoutMs)
+	e.Int8(int8(m.TransactionStatus))
+	protocol.EncodeNullableArray(e, m.TransactionPartitions, func(e *protocol.Encoder, partition TransactionPartition) {
+		e.String(partition.Topic)
+		protocol.EncodeArray(e, partition.PartitionIds, (*protocol.Encoder).Int32)
+		e.TaggedFields(nil)
+	})
+	e.Int64(m.TransactionLastUpdateTimestampMs)
+	e.Int64(m.TransactionStartTimestampMs)
+
+	var tagged []protocol.TaggedField
+	if m.PreviousProducerId != NoProducerId {
+		tagged = e.Tagged(tagged, 0, func(e *protocol.Encoder) { e.Int64(m.PreviousProducerId) })
+	}
+	if m.NextProducerId != NoProducerId {
+		tagged = e.Tagged(tagged, 1, func(e *protocol.Encoder) { e.Int64(m.NextProducerId) })
+	}
+	if m.ClientTransactionVersion != 0 {
+		tagged = e.Tagged(tagged, 2, func(e *protocol.Encoder) { e.Int16(m.ClientTransactionVersion) })
+	}
+	e.TaggedFields(tagged)
+}
+
+// Record is a decoded transaction log record. Value is nil for a tombstone.
+type Record struct {
+	Key          TransactionLogKey
+	ValueVersion int16
+	Value        *TransactionLogValue
+}
+
+// DecodeRecord decodes the key and the value of a transaction log record.
+func DecodeRecord(key []byte, value []byte) (Record, error) {
+	var record Record
+
+	kr := bytes.NewReader(key)
+	keyVersion, err := protocol.ReadInt16(kr)
+	if err != nil {
+		return Record{}, fmt.Errorf("TransactionLogKey: %w", err)
+	}
+	if keyVersion != KeyVersion {
+		return Record{}, fmt.Errorf("%w: TransactionLogKey version %d", ErrUnsupportedVersion, keyVersion)
+	}
+
+	d := protocol.NewDecoder(kr, false)
+	record.Key.TransactionalId = d.String()
+	if err := d.Err(); err != nil {
+		return Record{}, fmt.Errorf("TransactionLogKey: %w", err)
+	}
+	if kr.Len() != 0 {
+		return Record{}, fmt.Errorf("TransactionLogKey: %d bytes after the message", kr.Len())
+	}
+
+	if value == nil {
+		return record, nil
+	}
+
+	vr := bytes.NewReader(value)
+	version, err := protocol.ReadInt16(vr)
+	if err != nil {
+		return Record{}, fmt.Errorf("TransactionLogValue: %w", err)
+	}
+	if version < 0 || version > HighestSupportedValueVersion {
+		return Record{}, fmt.Errorf("%w: TransactionLogValue version %d", ErrUnsupportedVersion, version)
+	}
+
+	record.ValueVersion = version
+	record.Value = &TransactionLogValue{}
+	d = protocol.NewDecoder(vr, version >= 1)
+	record.Value.decode(d, version)
+	if err := d.Err(); err != nil {
+		return Record{}, fmt.Errorf("TransactionLogValue version %d: %w", version, err)
+	}
+	if vr.Len() != 0 {
+		return Record{}, fmt.Errorf("TransactionLogValue version %d: %d bytes after the message", version, vr.Len())
+	}
+
+	return record, nil
+}
+
+// EncodeRecord encodes a transaction log record into its key and value. A record without a value
+// encodes to a tombstone with a nil value.
+func EncodeRecord(record Record) ([]byte, []byte, error) {
+	key := bytes.NewBuffer(make([]byte, 0))
+	e := protocol.NewEncoder(key, false)
+	e.Int16(KeyVersion)
+	e.String(record.Key.TransactionalId)
+	if err := e.Err(); err != nil {
+		return nil, nil, fmt.Errorf("TransactionLogKey: %w", err)
+	}
+	if record.Value == nil {
+		return key.Bytes(), nil, nil
+	}
+
+	if record.ValueVersion < 0 || record.ValueVersion > HighestSupportedValueVersion {
+		return nil, nil, fmt.Errorf("%w: TransactionLogValue version %d", ErrUnsupportedVersion, record.ValueVersion)
+	}
+
+	value := bytes.NewBuffer(make([]byte, 0))
+	if err := protocol.WriteInt16(value, record.ValueVersion); err != nil {
+		return nil, nil, err
+	}
+	e = protocol.NewEncoder(value, record.ValueVersion >= 1)
+	record.Value.encode(e, record.ValueVersion)
+	if err := e.Err(); err != nil {
+		return nil, nil, fmt.Errorf("TransactionLogValue version %d: %w", record.ValueVersion, err)
+	}
+
+	return key.Bytes(), value.Bytes(), nil
+}
+
+// DecodeBatch decodes the records of a batch of the transaction state topic.
+func DecodeBatch(batch *records.RecordBatch) ([]Record, error) {
+	decoded := make([]Record, 0, len(batch.Records))
+	if batch.IsControl() {
+		return decoded, nil
+	}
+
+	for _, record := range batch.Records {
+		r, err := DecodeRecord(record.Key, record.Value)
+		if err != nil {
+			return nil, fmt.Errorf("offset %d: %w", batch.Offset(record), err)
+		}
+		decoded = append(decoded, r)
+	}
+
+	return decoded, nil
+}
+
+func noTaggedFields(*protocol.Decoder, uint64) bool {
+	return false
+}
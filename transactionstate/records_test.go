@@ -0,0 +1,106 @@
+package transactionstate
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/scholzj/go-kafka-protocol/compression"
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+	tests := []Record{
+		{TransactionLogKey{"txn"}, 0, &TransactionLogValue{ProducerId: 1000, PreviousProducerId: NoProducerId, NextProducerId: NoProducerId, ProducerEpoch: 2, TransactionTimeoutMs: 60000, TransactionStatus: Ongoing,
+			TransactionPartitions: []TransactionPartition{{Topic: "orders", PartitionIds: []int32{0, 1}}}, TransactionLastUpdateTimestampMs: 2000, TransactionStartTimestampMs: 1000}},
+		{TransactionLogKey{"txn"}, 0, &TransactionLogValue{ProducerId: 1000, PreviousProducerId: NoProducerId, NextProducerId: NoProducerId, TransactionStatus: Empty, TransactionStartTimestampMs: -1}},
+		{TransactionLogKey{"txn"}, 1, &TransactionLogValue{ProducerId: 1001, PreviousProducerId: 1000, NextProducerId: NoProducerId, ProducerEpoch: 0, TransactionTimeoutMs: 60000, TransactionStatus: CompleteCommit,
+			TransactionPartitions: []TransactionPartition{}, TransactionLastUpdateTimestampMs: 3000, TransactionStartTimestampMs: 1000, ClientTransactionVersion: 2}},
+		{TransactionLogKey{"txn"}, 0, nil},
+	}
+
+	for _, test := range tests {
+		key, value, err := EncodeRecord(test)
+		if err != nil {
+			t.Fatalf("EncodeRecord: %v", err)
+		}
+		decoded, err := DecodeRecord(key, value)
+		if err != nil {
+			t.Fatalf("DecodeRecord: %v", err)
+		}
+		if !reflect.DeepEqual(decoded, test) {
+			t.Errorf("round trip of value version %d:\n got %+v\nwant %+v", test.ValueVersion, decoded, test)
+		}
+	}
+}
+
+func TestDecodeKnownBytes(t *testing.T) {
+	key := []byte{0, 0, 0, 3, 't', 'x', 'n'}
+	value := []byte{
+		0, 0, // version
+		0, 0, 0, 0, 0, 0, 0x03, 0xe8, // producer id
+		0, 5, // producer epoch
+		0, 0, 0xea, 0x60, // timeout
+		1,          // Ongoing
+		0, 0, 0, 1, // one topic
+		0, 1, 't', 0, 0, 0, 1, 0, 0, 0, 7,
+		0, 0, 0, 0, 0, 0, 0, 2, // last update
+		0, 0, 0, 0, 0, 0, 0, 1, // start
+	}
+
+	record, err := DecodeRecord(key, value)
+	if err != nil {
+		t.Fatalf("DecodeRecord: %v", err)
+	}
+	v := record.Value
+	if record.Key.TransactionalId != "txn" || v.ProducerId != 1000 || v.ProducerEpoch != 5 || v.TransactionTimeoutMs != 60000 || v.TransactionStatus != Ongoing ||
+		!reflect.DeepEqual(v.TransactionPartitions, []TransactionPartition{{Topic: "t", PartitionIds: []int32{7}}}) || v.TransactionStartTimestampMs != 1 {
+		t.Errorf("unexpected record %+v", v)
+	}
+
+	if _, err := DecodeRecord([]byte{0, 1, 0, 0}, nil); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("key version 1 error = %v", err)
+	}
+	if _, err := DecodeRecord(key, []byte{0, 2}); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("value version 2 error = %v", err)
+	}
+	if _, err := DecodeRecord(key, value[:len(value)-1]); err == nil {
+		t.Error("expected an error for a truncated value")
+	}
+}
+
+func TestStateHanging(t *testing.T) {
+	value := func(status TransactionStatus, start int64) *TransactionLogValue {
+		return &TransactionLogValue{ProducerId: 1, PreviousProducerId: NoProducerId, NextProducerId: NoProducerId, TransactionTimeoutMs: 60000, TransactionStatus: status, TransactionStartTimestampMs: start}
+	}
+
+	batch := records.NewRecordBatch(0, compression.None)
+	for _, r := range []Record{
+		{TransactionLogKey{"stuck"}, 1, value(Ongoing, 0)},
+		{TransactionLogKey{"committing"}, 1, value(PrepareCommit, 50_000)},
+		{TransactionLogKey{"recent"}, 1, value(Ongoing, 200_000)},
+		{TransactionLogKey{"done"}, 1, value(CompleteCommit, 0)},
+		{TransactionLogKey{"expired"}, 1, value(Ongoing, 0)},
+		{TransactionLogKey{"expired"}, 0, nil},
+	} {
+		key, v, err := EncodeRecord(r)
+		if err != nil {
+			t.Fatalf("EncodeRecord: %v", err)
+		}
+		batch.AppendRecord(1000, key, v, nil)
+	}
+
+	state := NewState()
+	if err := state.ApplyBatch(batch); err != nil {
+		t.Fatalf("ApplyBatch: %v", err)
+	}
+	if len(state.Transactions) != 4 || state.NextOffset() != 6 {
+		t.Fatalf("got %d transactions, next offset %d", len(state.Transactions), state.NextOffset())
+	}
+
+	hanging := state.Hanging(time.UnixMilli(240_000), time.Minute)
+	if len(hanging) != 2 || hanging[0].TransactionalId != "stuck" || hanging[1].TransactionalId != "committing" || hanging[0].Duration != 4*time.Minute {
+		t.Errorf("Hanging = %+v", hanging)
+	}
+}
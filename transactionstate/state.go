@@ -0,0 +1,94 @@
+package transactionstate
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/scholzj/go-kafka-protocol/records"
+)
+
+// State is the transaction coordinator state rebuilt by replaying a partition of the transaction
+// state topic: the latest metadata of every transactional id.
+type State struct {
+	Transactions map[string]*TransactionLogValue
+
+	nextOffset int64
+}
+
+// NewState returns an empty state.
+func NewState() *State {
+	return &State{Transactions: make(map[string]*TransactionLogValue)}
+}
+
+// NextOffset returns the offset of the next batch to apply.
+func (s *State) NextOffset() int64 {
+	return s.nextOffset
+}
+
+// ApplyBatch replays a batch of the transaction state topic. Batches below NextOffset were already
+// applied and are ignored.
+func (s *State) ApplyBatch(batch *records.RecordBatch) error {
+	if batch.NextOffset() <= s.nextOffset {
+		return nil
+	}
+
+	if !batch.IsControl() {
+		for _, record := range batch.Records {
+			if batch.Offset(record) < s.nextOffset {
+				continue
+			}
+
+			r, err := DecodeRecord(record.Key, record.Value)
+			if err != nil {
+				return fmt.Errorf("offset %d: %w", batch.Offset(record), err)
+			}
+			s.Apply(r)
+		}
+	}
+
+	s.nextOffset = batch.NextOffset()
+	return nil
+}
+
+// Apply replays a single record. A tombstone removes the transactional id.
+func (s *State) Apply(record Record) {
+	if record.Value == nil {
+		delete(s.Transactions, record.Key.TransactionalId)
+		return
+	}
+	s.Transactions[record.Key.TransactionalId] = record.Value
+}
+
+// HangingTransaction is a transaction which has been in progress for longer than expected.
+type HangingTransaction struct {
+	TransactionalId string
+	Transaction     *TransactionLogValue
+	Duration        time.Duration // How long the transaction has been running.
+}
+
+// Hanging returns the transactions that are still in progress and have been running for longer
+// than their transaction timeout plus the grace period, the longest running first. A transaction
+// past its timeout should have been aborted by the coordinator, so these usually point at a stuck
+// coordinator or a partition which does not accept the transaction markers.
+func (s *State) Hanging(now time.Time, grace time.Duration) []HangingTransaction {
+	var hanging []HangingTransaction
+	for id, txn := range s.Transactions {
+		if !txn.TransactionStatus.InProgress() || txn.TransactionStartTimestampMs < 0 {
+			continue
+		}
+
+		duration := now.Sub(time.UnixMilli(txn.TransactionStartTimestampMs))
+		if duration > time.Duration(txn.TransactionTimeoutMs)*time.Millisecond+grace {
+			hanging = append(hanging, HangingTransaction{TransactionalId: id, Transaction: txn, Duration: duration})
+		}
+	}
+
+	sort.Slice(hanging, func(i, j int) bool {
+		if hanging[i].Duration != hanging[j].Duration {
+			return hanging[i].Duration > hanging[j].Duration
+		}
+		return hanging[i].TransactionalId < hanging[j].TransactionalId
+	})
+	return hanging
+}